@@ -0,0 +1,225 @@
+// Command riscv-dev-bench runs the standard workload of riscv-dev/pkg/bench
+// on a board and measures it: wall time, cycles and instructions from the
+// perf counters, and energy from an INA219 on the board's supply if one is
+// given. Each run is a separate process, so the counters and the power
+// readings cover the workload alone.
+//
+// riscv-dev profile builds it once per build variant, runs it on every
+// board and compares the results; it also runs standalone.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"riscv-dev/pkg/bench"
+	"riscv-dev/pkg/buildinfo"
+	"riscv-dev/pkg/hal"
+)
+
+// envWorkload carries the workload, as JSON, to a worker process
+const envWorkload = "RISCV_DEV_BENCH_WORKLOAD"
+
+func main() {
+	if spec := os.Getenv(envWorkload); spec != "" {
+		if err := work(spec); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	var w bench.Workload
+	flag.IntVar(&w.Samples, "samples", 20000, "readings per run")
+	flag.IntVar(&w.Channels, "channels", 8, "channels per reading")
+	flag.IntVar(&w.Subscribers, "subscribers", 4, "local subscribers to the readings")
+	flag.Int64Var(&w.Seed, "seed", 1, "seed for the channels' signals")
+	runs := flag.Int("runs", 5, "measured runs, after one unmeasured warm-up")
+	ina219 := flag.String("ina219", "", "INA219 on the board's supply, as `device[:address]`, e.g. /dev/i2c-1:0x40")
+	shunt := flag.Float64("shunt", 0.1, "the INA219's shunt resistor in ohms")
+	idle := flag.Duration("idle", 3*time.Second, "how long to measure the idle draw before the runs, with -ina219")
+	interval := flag.Duration("interval", bench.DefaultPowerInterval, "how often to read the INA219")
+	variant := flag.String("variant", "", "name of this build in the results (default: its RISC-V profile)")
+	asJSON := flag.Bool("json", false, "print the result as JSON")
+	buildinfo.RegisterFlag(flag.CommandLine)
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	res, err := measure(ctx, w, *runs, *ina219, *shunt, *idle, *interval)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	res.Variant = *variant
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(res); err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		return
+	}
+	if err := bench.WriteTable(os.Stdout, bench.Compare([]bench.Result{res}), false); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+}
+
+// work runs the workload once and prints its timing, in a worker process
+func work(spec string) error {
+	var w bench.Workload
+	if err := json.Unmarshal([]byte(spec), &w); err != nil {
+		return fmt.Errorf("invalid %s: %w", envWorkload, err)
+	}
+	t, err := bench.Run(context.Background(), w, "")
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(os.Stdout).Encode(t)
+}
+
+// measure runs the workload in worker processes, one per run
+func measure(ctx context.Context, w bench.Workload, runs int, ina219 string, shunt float64, idle, interval time.Duration) (bench.Result, error) {
+	res := bench.Result{Board: boardModel(), Build: buildinfo.Get(), Workload: w}
+	self, err := os.Executable()
+	if err != nil {
+		return res, err
+	}
+	spec, err := json.Marshal(w)
+	if err != nil {
+		return res, err
+	}
+
+	var meter *hal.INA219
+	if ina219 != "" {
+		device, addr, err := parseMeter(ina219)
+		if err != nil {
+			return res, err
+		}
+		if meter, err = hal.OpenINA219(ctx, device, addr, shunt); err != nil {
+			return res, err
+		}
+		defer meter.Close()
+		fmt.Fprintf(os.Stderr, "📊 Measuring the idle draw for %v\n", idle)
+		rec := bench.Record(ctx, meter, interval)
+		if err := sleep(ctx, idle); err != nil {
+			return res, err
+		}
+		e, err := rec.Stop()
+		if err != nil {
+			return res, fmt.Errorf("reading the INA219: %w", err)
+		}
+		res.IdleWatts = &e.MeanWatts
+	} else {
+		res.EnergyError = "no power meter (-ina219)"
+	}
+
+	counting := true
+	for i := 0; i <= runs; i++ {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		var out bytes.Buffer
+		cmd := exec.CommandContext(ctx, self)
+		cmd.Env = append(os.Environ(), envWorkload+"="+string(spec))
+		cmd.Stdout, cmd.Stderr = &out, os.Stderr
+
+		var rec *bench.Recorder
+		if meter != nil && i > 0 {
+			rec = bench.Record(ctx, meter, interval)
+		}
+		start := time.Now()
+		var m bench.Measurement
+		if counting {
+			c, err := bench.CountProcess(cmd)
+			switch {
+			case errors.Is(err, bench.ErrCountersUnavailable):
+				// Carry on timing, without counters from now on
+				res.CountersError = err.Error()
+				counting = false
+				fmt.Fprintf(os.Stderr, "⚠️  %v\n", err)
+				err = cmd.Run()
+			case err == nil:
+				m.Counters = &c
+			}
+			if err != nil {
+				return res, fmt.Errorf("run %d: %w", i, err)
+			}
+		} else if err := cmd.Run(); err != nil {
+			return res, fmt.Errorf("run %d: %w", i, err)
+		}
+		m.Wall = time.Since(start).Seconds()
+		if rec != nil {
+			e, err := rec.Stop()
+			if err != nil {
+				return res, fmt.Errorf("reading the INA219: %w", err)
+			}
+			m.Energy = &e
+		}
+		if err := json.Unmarshal(out.Bytes(), &m.Timing); err != nil {
+			return res, fmt.Errorf("run %d: invalid output: %w", i, err)
+		}
+
+		if i == 0 {
+			fmt.Fprintf(os.Stderr, "🔥 Warm-up: %.3fs\n", m.Wall)
+			continue
+		}
+		res.Runs = append(res.Runs, m)
+		line := fmt.Sprintf("📊 Run %d/%d: %.3fs", i, runs, m.Wall)
+		if m.Counters != nil {
+			line += fmt.Sprintf(", %d cycles, IPC %.2f", m.Counters.Cycles, m.Counters.IPC())
+		}
+		if m.Energy != nil {
+			line += fmt.Sprintf(", %.2fJ (%.2fW)", m.Energy.Joules, m.Energy.MeanWatts)
+		}
+		fmt.Fprintln(os.Stderr, line)
+	}
+	return res, nil
+}
+
+// parseMeter splits device[:address]
+func parseMeter(s string) (device string, addr byte, err error) {
+	device = s
+	if i := strings.LastIndex(s, ":"); i >= 0 {
+		n, err := strconv.ParseUint(s[i+1:], 0, 8)
+		if err != nil {
+			return "", 0, fmt.Errorf("invalid INA219 address in %q", s)
+		}
+		device, addr = s[:i], byte(n)
+	}
+	return device, addr, nil
+}
+
+// boardModel returns the device-tree model, or the host name
+func boardModel() string {
+	if data, err := os.ReadFile("/proc/device-tree/model"); err == nil {
+		if model := strings.TrimSpace(strings.TrimRight(string(data), "\x00")); model != "" {
+			return model
+		}
+	}
+	host, _ := os.Hostname()
+	return host
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// artifacts is a directory collecting what a session with a board left
+// behind (test logs, coverage and pprof profiles, the board's logs) along
+// with metadata.json describing the board and the commit under test
+type artifacts struct {
+	dir  string
+	meta artifactMeta
+}
+
+// artifactMeta is written to metadata.json
+type artifactMeta struct {
+	Command   string          `json:"command"`
+	Started   time.Time       `json:"started"`
+	Finished  time.Time       `json:"finished"`
+	Board     boardInfo       `json:"board"`
+	Commit    commitInfo      `json:"commit"`
+	GoVersion string          `json:"go_version"`
+	Packages  []packageResult `json:"packages,omitempty"`
+	Files     []string        `json:"files"` // relative to the directory
+}
+
+type boardInfo struct {
+	Host    string `json:"host"`
+	Machine string `json:"machine,omitempty"` // uname -m
+	Model   string `json:"model,omitempty"`   // device-tree model
+	Kernel  string `json:"kernel,omitempty"`  // uname -r
+	OS      string `json:"os,omitempty"`      // os-release PRETTY_NAME
+}
+
+type commitInfo struct {
+	Revision string `json:"revision,omitempty"`
+	Branch   string `json:"branch,omitempty"`
+	Dirty    bool   `json:"dirty,omitempty"` // uncommitted changes
+}
+
+type packageResult struct {
+	Path    string  `json:"path"`
+	Passed  bool    `json:"passed"`
+	Seconds float64 `json:"seconds"`
+}
+
+// newArtifacts creates dir, or artifacts/<time>-<host> if it is empty, and
+// records what is known about the board and the local checkout
+func newArtifacts(ctx context.Context, dir string, board *remote, command string) (*artifacts, error) {
+	started := time.Now()
+	if dir == "" {
+		host := board.host[strings.LastIndex(board.host, "@")+1:]
+		dir = filepath.Join("artifacts", started.Format("20060102-150405")+"-"+host)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	a := &artifacts{dir: dir, meta: artifactMeta{
+		Command:   command,
+		Started:   started,
+		GoVersion: runtime.Version(),
+		Board:     boardInfo{Host: board.host},
+	}}
+
+	// One round trip; every probe may fail on a minimal image
+	info, err := board.run(ctx, `uname -m; uname -r; tr -d '\0' </proc/device-tree/model 2>/dev/null; echo;`+
+		` (. /etc/os-release 2>/dev/null && echo "$PRETTY_NAME"); true`)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(info, "\n")
+	for i, field := range []*string{&a.meta.Board.Machine, &a.meta.Board.Kernel, &a.meta.Board.Model, &a.meta.Board.OS} {
+		if i < len(lines) {
+			*field = strings.TrimSpace(lines[i])
+		}
+	}
+
+	git := func(args ...string) string {
+		out, err := exec.CommandContext(ctx, "git", args...).Output()
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(out))
+	}
+	a.meta.Commit = commitInfo{
+		Revision: git("rev-parse", "HEAD"),
+		Branch:   git("rev-parse", "--abbrev-ref", "HEAD"),
+		Dirty:    git("status", "--porcelain", "--untracked-files=no") != "",
+	}
+	return a, nil
+}
+
+// path returns the local path of an artifact, creating its directory
+func (a *artifacts) path(name string) string {
+	p := filepath.Join(a.dir, filepath.FromSlash(name))
+	os.MkdirAll(filepath.Dir(p), 0755)
+	return p
+}
+
+// systemLogs are the board's logs collected by collectLogs, by artifact
+var systemLogs = map[string]string{
+	"logs/dmesg.log":   "dmesg",
+	"logs/journal.log": "command -v journalctl >/dev/null && journalctl -b --no-pager -o short-precise",
+}
+
+// collectLogs saves, under logs/, the board's kernel log and, where systemd
+// runs, its journal since boot if system is set, and any extra files or
+// directories
+func (a *artifacts) collectLogs(ctx context.Context, board *remote, system bool, extra []string) {
+	for name, script := range systemLogs {
+		if !system {
+			break
+		}
+		var out bytes.Buffer
+		cmd := board.command(ctx, script)
+		cmd.Stdout = &out
+		if cmd.Run() != nil || out.Len() == 0 {
+			continue
+		}
+		if err := os.WriteFile(a.path(name), out.Bytes(), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️  Saving %s: %v\n", name, err)
+		}
+	}
+	for _, p := range extra {
+		if err := board.pull(ctx, p, a.path("logs/"+path.Base(p))); err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️  Collecting %s: %v\n", p, err)
+		}
+	}
+}
+
+// finish lists the collected files and writes metadata.json
+func (a *artifacts) finish() error {
+	a.meta.Finished = time.Now()
+	a.meta.Files = nil
+	filepath.Walk(a.dir, func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && info.Name() != "metadata.json" {
+			rel, _ := filepath.Rel(a.dir, p)
+			a.meta.Files = append(a.meta.Files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	data, err := json.MarshalIndent(a.meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(a.dir, "metadata.json"), append(data, '\n'), 0644)
+}
+
+// addCollectFlag registers -collect, naming files on the board to bring back
+func addCollectFlag(flags *flag.FlagSet) *[]string {
+	var paths []string
+	flags.Func("collect", "also bring back this file or directory from the board (repeatable)", func(s string) error {
+		paths = append(paths, s)
+		return nil
+	})
+	return &paths
+}
+
+// runCollect gathers artifacts after a manual deploy or debugging session
+func runCollect(args []string) error {
+	flags := flag.NewFlagSet("collect", flag.ContinueOnError)
+	board := addRemoteFlags(flags)
+	outDir := flags.String("o", "", "directory for the artifacts (default artifacts/<time>-<host>)")
+	collect := addCollectFlag(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: riscv-dev collect --host [user@]board [-collect path]... [-o dir]")
+		flags.PrintDefaults()
+	}
+	if _, err := parseArgs(flags, args); err != nil {
+		return err
+	}
+	if board.host == "" {
+		flags.Usage()
+		return fmt.Errorf("--host is required (or set RISCV_DEV_HOST)")
+	}
+
+	ctx := context.Background()
+	a, err := newArtifacts(ctx, *outDir, board, "collect")
+	if err != nil {
+		return err
+	}
+	a.collectLogs(ctx, board, true, *collect)
+	if err := a.finish(); err != nil {
+		return err
+	}
+	fmt.Printf("📦 %d files from %s in %s\n", len(a.meta.Files), board.host, a.dir)
+	return nil
+}
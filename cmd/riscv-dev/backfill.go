@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	"riscv-dev/pkg/agent"
+)
+
+func runBackfill(args []string) error {
+	flags := flag.NewFlagSet("backfill", flag.ContinueOnError)
+	configPath := flags.String("config", "config.json", "agent config.json defining the sink")
+	sinkName := flags.String("sink", "", "sink to replay into, by name (or type, if unnamed) in the config")
+	from := flags.String("from", "", "replay readings from this time (RFC 3339), e.g. to resume")
+	to := flags.String("to", "", "replay readings up to this time (RFC 3339)")
+	speed := flags.Float64("speed", 0, "pace as a multiple of the recorded time, e.g. 60 for an hour a minute (default: as fast as the sink takes)")
+	retries := flags.Int("retries", 5, "times to retry a failed write before stopping")
+	dryRun := flags.Bool("n", false, "only report what would be replayed")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: riscv-dev backfill -sink name [-config config.json] [-from t] [-to t] [-speed n] path...")
+		fmt.Fprintln(flags.Output(), "")
+		fmt.Fprintln(flags.Output(), "Replays recorded readings into a sink with their original timestamps,")
+		fmt.Fprintln(flags.Output(), "e.g. to fill a new backend with what a board kept while it was down.")
+		fmt.Fprintln(flags.Output(), "Paths are history files (.hist) or directories of them, and file sink")
+		fmt.Fprintln(flags.Output(), "output (.jsonl, rotated or gzipped). Readings go out oldest first; if")
+		fmt.Fprintln(flags.Output(), "the sink keeps failing, the time to resume -from is printed.")
+		flags.PrintDefaults()
+	}
+	paths, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if *sinkName == "" || len(paths) == 0 {
+		flags.Usage()
+		return errors.New("invalid arguments")
+	}
+	opts := agent.BackfillOptions{Speed: *speed, Retries: *retries}
+	for _, t := range []struct {
+		flag string
+		dst  *time.Time
+	}{{*from, &opts.From}, {*to, &opts.To}} {
+		if t.flag == "" {
+			continue
+		}
+		if *t.dst, err = time.Parse(time.RFC3339Nano, t.flag); err != nil {
+			return fmt.Errorf("invalid time %q: %w", t.flag, err)
+		}
+	}
+
+	cfg := agent.DefaultConfig()
+	data, err := os.ReadFile(*configPath)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("invalid config %s: %w", *configPath, err)
+	}
+	units := make(map[string]string)
+	for _, ch := range cfg.Channels {
+		units[ch.Name] = ch.Unit
+	}
+	readings, err := agent.LoadRecorded(paths, units)
+	if err != nil {
+		return err
+	}
+	var selected []agent.Reading
+	for _, r := range readings {
+		if (opts.From.IsZero() || !r.Time.Before(opts.From)) && (opts.To.IsZero() || !r.Time.After(opts.To)) {
+			selected = append(selected, r)
+		}
+	}
+	if len(selected) == 0 {
+		return errors.New("no readings in the selected time range")
+	}
+	first, last := selected[0].Time, selected[len(selected)-1].Time
+	fmt.Printf("📼 %d readings from %s to %s\n", len(selected), first.Format(time.RFC3339), last.Format(time.RFC3339))
+	if *dryRun {
+		return nil
+	}
+	ns, err := cfg.ResolveNamespace()
+	if err != nil {
+		return err
+	}
+	opts.Source = ns.Path()
+	sink, err := cfg.BackfillSink(*sinkName)
+	if err != nil {
+		return err
+	}
+	if c, ok := sink.(io.Closer); ok {
+		defer func() {
+			if err := c.Close(); err != nil {
+				fmt.Fprintf(os.Stderr, "⚠️  Closing sink %s: %v\n", *sinkName, err)
+			}
+		}()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	lastShown := time.Now()
+	opts.Progress = func(sent int, r agent.Reading) {
+		if time.Since(lastShown) >= 2*time.Second || sent == len(selected) {
+			fmt.Printf("  %d/%d, at %s\n", sent, len(selected), r.Time.Format(time.RFC3339))
+			lastShown = time.Now()
+		}
+	}
+	res, err := agent.Backfill(ctx, sink, selected, opts)
+	if err != nil {
+		if res.Sent > 0 {
+			return fmt.Errorf("%w; %d readings sent, resume with -from %s", err, res.Sent, res.Last.Add(time.Nanosecond).Format(time.RFC3339Nano))
+		}
+		return err
+	}
+	fmt.Printf("✅ Replayed %d readings into %s\n", res.Sent, *sinkName)
+	return nil
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// buildTarget is what `riscv-dev build --target` builds for
+type buildTarget struct {
+	summary string
+	env     []string // GOOS, GOARCH and CGO_ENABLED; empty for the host's
+	ldflags []string
+	gcflags string
+}
+
+var buildTargets = map[string]buildTarget{
+	// Static, stripped and without inlining, for the smallest binary that
+	// fits a board's flash
+	"board": {
+		summary: "linux/riscv64, static and stripped, for flashing to a board",
+		env:     []string{"GOOS=linux", "GOARCH=riscv64", "CGO_ENABLED=0"},
+		ldflags: []string{"-s", "-w"},
+		gcflags: "all=-l",
+	},
+	// Symbols kept and optimizations off, for Delve or gdb through
+	// qemu-riscv64 -g
+	"qemu": {
+		summary: "linux/riscv64, static with symbols and without optimizations, for debugging under QEMU",
+		env:     []string{"GOOS=linux", "GOARCH=riscv64", "CGO_ENABLED=0"},
+		gcflags: "all=-N -l",
+	},
+	"host": {
+		summary: "this machine, for running against simulated hardware",
+	},
+}
+
+// buildMain is a main package of an application module
+type buildMain struct {
+	app string // application name, the module directory's
+	dir string // module directory
+	cmd string // package under cmd/, which names the binary
+}
+
+func runBuild(args []string) error {
+	flags := flag.NewFlagSet("build", flag.ContinueOnError)
+	target := flags.String("target", "board", "what to build for: "+strings.Join(buildTargetNames(), "|"))
+	outDir := flags.String("o", "", "output directory; binaries go in <dir>/<target>/<app>/ (default bin in the checkout)")
+	version := flags.String("version", "", "version to stamp into the binaries (default: git describe)")
+	tags := flags.String("tags", "", "comma-separated build tags")
+	profile := flags.String("riscv64", "", "RISC-V profile to generate code for, rva20u64 or rva22u64 (default: Go's, rva20u64)")
+	verbose := flags.Bool("v", false, "print the go build commands")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: riscv-dev build [--target board|qemu|host] [flags] [apps]")
+		fmt.Fprintln(flags.Output(), "")
+		fmt.Fprintln(flags.Output(), "Builds every command under the apps' cmd/ directories, stamping the version,")
+		fmt.Fprintln(flags.Output(), "commit and build time into riscv-dev/pkg/buildinfo. An app is an example name")
+		fmt.Fprintln(flags.Output(), "or the path of an application module; apps default to every example.")
+		fmt.Fprintln(flags.Output(), "")
+		fmt.Fprintln(flags.Output(), "Targets:")
+		for _, name := range buildTargetNames() {
+			fmt.Fprintf(flags.Output(), "  %-6s %s\n", name, buildTargets[name].summary)
+		}
+		fmt.Fprintln(flags.Output(), "")
+		flags.PrintDefaults()
+	}
+	apps, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	bt, ok := buildTargets[*target]
+	if !ok {
+		return fmt.Errorf("unknown target %q (available: %s)", *target, strings.Join(buildTargetNames(), ", "))
+	}
+	env := bt.env
+	if *profile != "" {
+		if !slices.Contains(env, "GOARCH=riscv64") {
+			return fmt.Errorf("--riscv64 needs a riscv64 target, not %s", *target)
+		}
+		env = append(slices.Clip(env), "GORISCV64="+*profile)
+	}
+	root, err := resolveSDK("")
+	if err != nil {
+		return err
+	}
+	if *outDir == "" {
+		*outDir = filepath.Join(root, "bin")
+	}
+	mains, err := findMains(root, apps)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	ldflags := make(map[string]string) // by module directory
+	start := time.Now()
+	for _, m := range mains {
+		if _, ok := ldflags[m.dir]; !ok {
+			stamp := stampFlags(ctx, m.dir, *version, *target)
+			ldflags[m.dir] = strings.Join(append(append([]string(nil), bt.ldflags...), stamp...), " ")
+		}
+		out, err := filepath.Abs(filepath.Join(*outDir, *target, m.app, m.cmd))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("🔨 Building %s/%s for %s\n", m.app, m.cmd, *target)
+		cmdArgs := []string{"build", "-trimpath", "-ldflags", ldflags[m.dir], "-o", out}
+		if bt.gcflags != "" {
+			cmdArgs = append(cmdArgs, "-gcflags", bt.gcflags)
+		}
+		if *tags != "" {
+			cmdArgs = append(cmdArgs, "-tags", *tags)
+		}
+		cmdArgs = append(cmdArgs, "./cmd/"+m.cmd)
+		cmd := exec.CommandContext(ctx, "go", cmdArgs...)
+		cmd.Dir = m.dir
+		cmd.Env = append(os.Environ(), env...)
+		if len(env) == 0 {
+			// A GOOS or GOARCH left in the environment would cross-compile
+			cmd.Env = append(cmd.Env, "GOOS=", "GOARCH=")
+		}
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if *verbose {
+			fmt.Printf("   (cd %s && %s go %s)\n", m.dir, strings.Join(env, " "), strings.Join(cmdArgs, " "))
+		}
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("building %s/%s: %w", m.app, m.cmd, err)
+		}
+	}
+	fmt.Printf("✅ Built %d binaries for %s in %s (%v)\n", len(mains), *target, filepath.Join(*outDir, *target), time.Since(start).Round(100*time.Millisecond))
+	return nil
+}
+
+func buildTargetNames() []string {
+	names := make([]string, 0, len(buildTargets))
+	for name := range buildTargets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// findMains lists the commands of apps, every example when apps is empty
+func findMains(root string, apps []string) ([]buildMain, error) {
+	var dirs []string
+	if len(apps) == 0 {
+		entries, err := os.ReadDir(filepath.Join(root, "examples"))
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			dir := filepath.Join(root, "examples", e.Name())
+			if _, err := os.Stat(filepath.Join(dir, "go.mod")); e.IsDir() && err == nil {
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	for _, app := range apps {
+		dir := filepath.Join(root, "examples", app)
+		if strings.ContainsRune(app, filepath.Separator) || app == "." || app == ".." {
+			dir = app
+		}
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err != nil {
+			return nil, fmt.Errorf("%s: not an example or application module (no go.mod in %s)", app, dir)
+		}
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, abs)
+	}
+
+	var mains []buildMain
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(filepath.Join(dir, "cmd"))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(dir), err)
+		}
+		n := len(mains)
+		for _, e := range entries {
+			if e.IsDir() {
+				mains = append(mains, buildMain{app: filepath.Base(dir), dir: dir, cmd: e.Name()})
+			}
+		}
+		if len(mains) == n {
+			return nil, fmt.Errorf("%s: no commands under cmd/", filepath.Base(dir))
+		}
+	}
+	return mains, nil
+}
+
+// stampFlags returns the -X flags setting riscv-dev/pkg/buildinfo from the
+// git checkout holding dir, the application's rather than riscv-dev's. The build time is $SOURCE_DATE_EPOCH when set, for
+// reproducible builds.
+func stampFlags(ctx context.Context, dir, version, target string) []string {
+	git := func(args ...string) string {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = dir
+		out, err := cmd.Output()
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(out))
+	}
+	if version == "" {
+		version = git("describe", "--tags", "--always", "--dirty")
+	}
+	date := time.Now()
+	if epoch, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64); err == nil {
+		date = time.Unix(epoch, 0)
+	}
+	vars := []struct{ name, value string }{
+		{"Version", version},
+		{"Commit", git("rev-parse", "HEAD")},
+		{"Date", date.UTC().Format(time.RFC3339)},
+		{"Target", target},
+	}
+	var flags []string
+	for _, v := range vars {
+		if v.value != "" {
+			// Quoted for go build's flag splitting; versions have no quotes
+			flags = append(flags, fmt.Sprintf("-X 'riscv-dev/pkg/buildinfo.%s=%s'", v.name, v.value))
+		}
+	}
+	return flags
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"riscv-dev/pkg/sensor"
+)
+
+func runCalibrate(args []string) error {
+	flags := flag.NewFlagSet("calibrate", flag.ContinueOnError)
+	channel := flags.String("channel", "", "channel to calibrate, as named in config.json")
+	fit := flags.String("fit", "linear", "curve: piecewise, linear, or polyN for a polynomial of degree N")
+	comp := flags.String("compensate", "", "channel whose drift to fit, from the third column of the points")
+	compDegree := flags.Int("comp-degree", 1, "degree of the compensation")
+	reference := flags.Float64("reference", 25, "value of the compensating channel at which the correction is zero")
+	out := flags.String("o", "calibration.json", "calibration file to add the channel to")
+	instrument := flags.String("instrument", "", "reference instrument, recorded with the calibration")
+	operator := flags.String("operator", "", "who calibrated (default: the current user)")
+	notes := flags.String("notes", "", "free-form notes recorded with the calibration")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: riscv-dev calibrate -channel name [flags] points.csv")
+		fmt.Fprintln(flags.Output(), "")
+		fmt.Fprintln(flags.Output(), "Fits a calibration curve to reference points, one per line as")
+		fmt.Fprintln(flags.Output(), "raw,value[,compensation], and stores it in the calibration file.")
+		flags.PrintDefaults()
+	}
+	files, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if *channel == "" || len(files) != 1 {
+		flags.Usage()
+		return errors.New("a channel and one points file are required")
+	}
+
+	points, err := readPoints(files[0])
+	if err != nil {
+		return err
+	}
+
+	var cal sensor.Calibration
+	switch {
+	case *fit == "piecewise":
+		if *comp != "" {
+			return errors.New("-compensate needs a polynomial fit")
+		}
+		cal, err = sensor.NewPiecewise(points)
+	case *fit == "linear":
+		cal, err = sensor.FitPolynomial(points, 1, *comp, *compDegree, *reference)
+	case strings.HasPrefix(*fit, "poly"):
+		degree, perr := strconv.Atoi(strings.TrimPrefix(*fit, "poly"))
+		if perr != nil {
+			return fmt.Errorf("invalid fit %q (want piecewise, linear or polyN)", *fit)
+		}
+		cal, err = sensor.FitPolynomial(points, degree, *comp, *compDegree, *reference)
+	default:
+		return fmt.Errorf("invalid fit %q (want piecewise, linear or polyN)", *fit)
+	}
+	if err != nil {
+		return err
+	}
+	cal.Produced.Instrument = *instrument
+	cal.Produced.Operator = *operator
+	if cal.Produced.Operator == "" {
+		if u, err := user.Current(); err == nil {
+			cal.Produced.Operator = u.Username
+		}
+	}
+	cal.Produced.Notes = *notes
+
+	file, err := sensor.LoadCalibration(*out)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if file.Channels == nil {
+		file.Channels = make(map[string]sensor.Calibration)
+	}
+	_, replaced := file.Channels[*channel]
+	file.Channels[*channel] = cal
+	if err := sensor.SaveCalibration(*out, file); err != nil {
+		return err
+	}
+
+	verb := "Added"
+	if replaced {
+		verb = "Replaced"
+	}
+	fmt.Printf("✅ %s %s in %s: %s from %d points\n", verb, *channel, *out, cal.Produced.Method, len(points))
+	fmt.Printf("📊 RMS residual: %.4g\n", cal.Produced.Residual)
+	return nil
+}
+
+// readPoints reads raw,value[,compensation] lines, skipping blank lines,
+// # comments and a header line
+func readPoints(path string) ([]sensor.Point, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var points []sensor.Point
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		values := make([]float64, len(fields))
+		for i, field := range fields {
+			if values[i], err = strconv.ParseFloat(strings.TrimSpace(field), 64); err != nil {
+				break
+			}
+		}
+		switch {
+		case err != nil && len(points) == 0 && n == 1:
+			err = nil // header
+			continue
+		case err != nil:
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		case len(values) < 2 || len(values) > 3:
+			return nil, fmt.Errorf("%s:%d: want raw,value[,compensation]", path, n)
+		}
+		p := sensor.Point{Raw: values[0], Value: values[1]}
+		if len(values) == 3 {
+			p.Compensation = &values[2]
+		}
+		points = append(points, p)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(points) == 0 {
+		return nil, fmt.Errorf("%s has no points", path)
+	}
+	return points, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"riscv-dev/pkg/boards"
+)
+
+func runCapabilities(args []string) error {
+	flags := flag.NewFlagSet("capabilities", flag.ContinueOnError)
+	format := flags.String("format", "json", "output format: json or markdown")
+	out := flags.String("o", "", "write to this file instead of standard output")
+	board := flags.String("board", "", "only this board, or \"detect\" for the one this runs on")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: riscv-dev capabilities [-format json|markdown] [-board name|detect] [-o file]")
+		fmt.Fprintln(flags.Output(), "")
+		fmt.Fprintln(flags.Output(), "Prints which HAL features work on which board, from the board database.")
+		fmt.Fprintln(flags.Output(), "docs/setup/board-capabilities.md is this with -format markdown.")
+		flags.PrintDefaults()
+	}
+	if _, err := parseArgs(flags, args); err != nil {
+		return err
+	}
+
+	m := boards.NewMatrix()
+	switch *board {
+	case "":
+	case "detect":
+		b, model := boards.Detect()
+		if b == nil {
+			if model == "" {
+				return fmt.Errorf("no device-tree model here; is this a board?")
+			}
+			return fmt.Errorf("%q isn't in the board database", model)
+		}
+		m.Boards = only(m.Boards, b.Name)
+	default:
+		if _, ok := boards.Lookup(*board); !ok {
+			var names []string
+			for _, b := range boards.All() {
+				names = append(names, b.Name)
+			}
+			return fmt.Errorf("unknown board %q (known: %s)", *board, strings.Join(names, ", "))
+		}
+		m.Boards = only(m.Boards, strings.ToLower(*board))
+	}
+
+	var buf bytes.Buffer
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(&buf)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(m); err != nil {
+			return err
+		}
+	case "markdown":
+		if err := m.WriteMarkdown(&buf); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown format %q (json or markdown)", *format)
+	}
+	if *out == "" {
+		_, err := os.Stdout.Write(buf.Bytes())
+		return err
+	}
+	if err := os.WriteFile(*out, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("✅ Wrote the capability matrix of %d boards to %s\n", len(m.Boards), *out)
+	return nil
+}
+
+// only returns the board named name from all
+func only(all []*boards.Board, name string) []*boards.Board {
+	for _, b := range all {
+		if b.Name == name {
+			return []*boards.Board{b}
+		}
+	}
+	return nil
+}
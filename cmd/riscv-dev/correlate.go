@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"riscv-dev/pkg/agent"
+	"riscv-dev/pkg/sensor"
+)
+
+// maxCorrelationPoints caps the points a series is resampled to
+const maxCorrelationPoints = 5000
+
+// correlationReport is the report of the correlate command
+type correlationReport struct {
+	From     time.Time         `json:"from"`
+	To       time.Time         `json:"to"`
+	Step     float64           `json:"step_seconds"`
+	MaxLag   float64           `json:"max_lag_seconds"`
+	Channels []string          `json:"channels"`
+	Pairs    []correlationPair `json:"pairs"`
+}
+
+// correlationPair is the correlation of two channels. R and LagR are
+// null where no coefficient exists.
+type correlationPair struct {
+	A    string   `json:"a"`
+	B    string   `json:"b"`
+	R    *float64 `json:"r"`
+	N    int      `json:"n"`           // pairs of values compared
+	Lag  float64  `json:"lag_seconds"` // positive if b follows a
+	LagR *float64 `json:"lag_r"`       // coefficient at the lag
+	Note string   `json:"note,omitempty"`
+}
+
+func runCorrelate(args []string) error {
+	flags := flag.NewFlagSet("correlate", flag.ContinueOnError)
+	configPath := flags.String("config", "", "agent config.json, for channel units and ranges (optional)")
+	channels := flags.String("channels", "", "comma-separated channels to compare (default: all)")
+	from := flags.String("from", "", "start of the period to compare (RFC 3339)")
+	to := flags.String("to", "", "end of the period to compare (RFC 3339)")
+	step := flags.Duration("step", 0, "resample channels to this interval (default: the sample interval, at most 5000 points)")
+	maxLag := flags.Duration("max-lag", 10*time.Minute, "longest delay between channels to look for; 0 compares only simultaneous values")
+	format := flags.String("format", "", "report format, json or csv (default: from -o's extension, else json)")
+	out := flags.String("o", "", "write the report to this file instead of stdout")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: riscv-dev correlate [-channels a,b] [-from t] [-to t] [-step d] [-max-lag d] [-o report.csv] path...")
+		fmt.Fprintln(flags.Output(), "")
+		fmt.Fprintln(flags.Output(), "Computes the correlation coefficient of every pair of channels in")
+		fmt.Fprintln(flags.Output(), "recorded history, and the delay at which they correlate most, e.g.")
+		fmt.Fprintln(flags.Output(), "how long temperature lags light. Use it to sanity-check placement and")
+		fmt.Fprintln(flags.Output(), "wiring: channels that should move together but don't, or unrelated")
+		fmt.Fprintln(flags.Output(), "channels that track each other exactly. Paths are history files")
+		fmt.Fprintln(flags.Output(), "(.hist) or directories of them, and file sink output (.jsonl).")
+		flags.PrintDefaults()
+	}
+	paths, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 || *step < 0 || *maxLag < 0 {
+		flags.Usage()
+		return errors.New("invalid arguments")
+	}
+	if *format == "" {
+		*format = "json"
+		if strings.HasSuffix(*out, ".csv") {
+			*format = "csv"
+		}
+	}
+	if *format != "json" && *format != "csv" {
+		return fmt.Errorf("unknown format %q (want json or csv)", *format)
+	}
+
+	units := make(map[string]string)
+	ranges := make(map[string]sensor.Range)
+	if *configPath != "" {
+		cfg := agent.DefaultConfig()
+		data, err := os.ReadFile(*configPath)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return fmt.Errorf("invalid config %s: %w", *configPath, err)
+		}
+		for _, ch := range cfg.Channels {
+			units[ch.Name], ranges[ch.Name] = ch.Unit, ch.Range
+		}
+	}
+	readings, err := agent.LoadRecorded(paths, units)
+	if err != nil {
+		return err
+	}
+	var start, end time.Time
+	for _, t := range []struct {
+		flag string
+		dst  *time.Time
+	}{{*from, &start}, {*to, &end}} {
+		if t.flag == "" {
+			continue
+		}
+		if *t.dst, err = time.Parse(time.RFC3339Nano, t.flag); err != nil {
+			return fmt.Errorf("invalid time %q: %w", t.flag, err)
+		}
+	}
+
+	// Each channel's usable samples within the period
+	samples := make(map[string][]sensor.Sample)
+	var times []time.Time
+	for _, r := range readings {
+		if (!start.IsZero() && r.Time.Before(start)) || (!end.IsZero() && r.Time.After(end)) {
+			continue
+		}
+		times = append(times, r.Time)
+		for _, c := range r.Channels {
+			samples[c.Name] = append(samples[c.Name], sensor.Sample{Time: r.Time, Value: c.Value, Quality: c.Quality})
+		}
+	}
+	names := make([]string, 0, len(samples))
+	if *channels != "" {
+		for _, name := range strings.Split(*channels, ",") {
+			name = strings.TrimSpace(name)
+			if _, ok := samples[name]; !ok {
+				return fmt.Errorf("no readings of channel %q in the selected period", name)
+			}
+			names = append(names, name)
+		}
+	} else {
+		for name := range samples {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	if len(names) < 2 {
+		return fmt.Errorf("need at least two channels to correlate, have %v", names)
+	}
+	if len(times) < 2 {
+		return errors.New("too few readings in the selected period")
+	}
+
+	first, last := times[0], times[len(times)-1]
+	if *step == 0 {
+		*step = correlationStep(times)
+	}
+	if points := last.Sub(first) / *step; points > maxCorrelationPoints {
+		return fmt.Errorf("%d points at -step %v; use a step of at least %v", points, *step, (last.Sub(first)/maxCorrelationPoints + time.Second - 1).Truncate(time.Second))
+	}
+	series := make(map[string][]float64, len(names))
+	for _, name := range names {
+		buckets := sensor.Downsample(samples[name], first, last.Add(*step), *step, ranges[name])
+		values := make([]float64, len(buckets))
+		for i, b := range buckets {
+			values[i] = math.NaN()
+			if b.Mean != nil && b.Quality != sensor.Stale {
+				values[i] = *b.Mean
+			}
+		}
+		series[name] = values
+	}
+
+	report := correlationReport{From: first, To: last, Step: step.Seconds(), MaxLag: maxLag.Seconds(), Channels: names}
+	lagSteps := int(*maxLag / *step)
+	usable := make(map[string]int, len(names))
+	flat := make(map[string]bool, len(names))
+	for _, name := range names {
+		lo, hi := math.Inf(1), math.Inf(-1)
+		for _, v := range series[name] {
+			if !math.IsNaN(v) {
+				usable[name]++
+				lo, hi = math.Min(lo, v), math.Max(hi, v)
+			}
+		}
+		flat[name] = lo == hi
+		lagSteps = min(lagSteps, len(series[name])/2)
+	}
+	// worst returns whichever of a and b fails test, preferring a
+	worst := func(a, b string, test func(string) bool) string {
+		if test(a) {
+			return a
+		}
+		return b
+	}
+	for i, a := range names {
+		for _, b := range names[i+1:] {
+			c := sensor.Correlate(series[a], series[b], lagSteps)
+			p := correlationPair{A: a, B: b, N: c.N, R: finite(c.R), LagR: finite(c.LagR), Lag: (time.Duration(c.Lag) * *step).Seconds()}
+			switch {
+			case usable[a] < 10 || usable[b] < 10:
+				p.Note = worst(a, b, func(n string) bool { return usable[n] < 10 }) + " has too few usable readings (faults or out of range)"
+			case flat[a] || flat[b]:
+				p.Note = worst(a, b, func(n string) bool { return flat[n] }) + " doesn't vary: check it is connected"
+			case !c.Valid:
+				p.Note = "too few readings at the same times"
+			case math.Abs(c.R) >= 0.99:
+				p.Note = "nearly identical: check for inputs wired together or configured twice"
+			}
+			report.Pairs = append(report.Pairs, p)
+		}
+	}
+
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if *format == "csv" {
+		err = writeCorrelationCSV(w, report)
+	} else {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+	}
+	if err != nil {
+		return err
+	}
+	if *out != "" {
+		for _, p := range report.Pairs {
+			if p.Note != "" {
+				fmt.Printf("⚠️  %s / %s: %s\n", p.A, p.B, p.Note)
+			}
+		}
+		fmt.Printf("✅ Correlated %d channels over %v in steps of %v: %s\n", len(names), last.Sub(first).Round(time.Second), *step, *out)
+	}
+	return nil
+}
+
+// correlationStep picks the resampling interval: the median interval
+// between readings, coarser if needed to stay within maxCorrelationPoints
+func correlationStep(times []time.Time) time.Duration {
+	gaps := make([]time.Duration, 0, len(times)-1)
+	for i := 1; i < len(times); i++ {
+		gaps = append(gaps, times[i].Sub(times[i-1]))
+	}
+	sort.Slice(gaps, func(i, j int) bool { return gaps[i] < gaps[j] })
+	step := max(gaps[len(gaps)/2], times[len(times)-1].Sub(times[0])/maxCorrelationPoints)
+	unit := time.Millisecond
+	if step >= time.Second {
+		unit = time.Second
+	}
+	return (step + unit - 1).Truncate(unit)
+}
+
+// finite returns v, or nil for NaN
+func finite(v float64) *float64 {
+	if math.IsNaN(v) {
+		return nil
+	}
+	return &v
+}
+
+func writeCorrelationCSV(w io.Writer, report correlationReport) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"a", "b", "r", "n", "lag_seconds", "lag_r", "note"})
+	num := func(v *float64) string {
+		if v == nil {
+			return ""
+		}
+		return strconv.FormatFloat(*v, 'f', 4, 64)
+	}
+	for _, p := range report.Pairs {
+		cw.Write([]string{p.A, p.B, num(p.R), strconv.Itoa(p.N), strconv.FormatFloat(p.Lag, 'f', -1, 64), num(p.LagR), p.Note})
+	}
+	cw.Flush()
+	return cw.Error()
+}
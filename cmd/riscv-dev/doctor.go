@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"riscv-dev/pkg/devaccess"
+)
+
+func runDoctor(args []string) error {
+	flags := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	emitRules := flags.Bool("udev-rules", false, "print the udev rules file granting group access and exit")
+	verbose := flags.Bool("v", false, "list every device node checked")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: riscv-dev doctor [--udev-rules] [-v]")
+		flags.PrintDefaults()
+	}
+	if _, err := parseArgs(flags, args); err != nil {
+		return err
+	}
+
+	if *emitRules {
+		fmt.Print(devaccess.UdevRules())
+		return nil
+	}
+
+	fmt.Println("🩺 Checking device access for the current user...")
+	problems := 0
+	for _, res := range devaccess.Check() {
+		switch res.Status {
+		case devaccess.OK:
+			fmt.Printf("✅ %-5s %d device node(s) accessible\n", res.Class.Name, len(res.Nodes))
+		case devaccess.Missing:
+			fmt.Printf("➖ %-5s no device nodes present\n", res.Class.Name)
+		case devaccess.Denied:
+			problems++
+			fmt.Printf("❌ %-5s access denied\n", res.Class.Name)
+		}
+
+		if *verbose || res.Status == devaccess.Denied {
+			for _, n := range res.Nodes {
+				fmt.Printf("     %-45s %s group=%s r=%v w=%v\n", n.Path, n.Mode.Perm(), n.Group, n.Readable, n.Writable)
+			}
+		}
+		if res.Status == devaccess.Denied || *verbose {
+			for _, advice := range res.Advice {
+				fmt.Printf("     💡 %s\n", advice)
+			}
+		}
+	}
+
+	if problems > 0 {
+		return fmt.Errorf("%d device class(es) need attention", problems)
+	}
+	fmt.Println("✅ No access problems found")
+	return nil
+}
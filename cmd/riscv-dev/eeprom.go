@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"riscv-dev/pkg/hal"
+	"riscv-dev/pkg/nvstore"
+	"riscv-dev/pkg/sensor"
+)
+
+func runEEPROM(args []string) error {
+	flags := flag.NewFlagSet("eeprom", flag.ContinueOnError)
+	var cfg hal.EEPROMConfig
+	flags.StringVar(&cfg.Device, "device", "", "I2C bus, or the eeprom file of the kernel's at24 driver (default: the first I2C bus)")
+	flags.IntVar(&cfg.Address, "address", 0x50, "I2C address")
+	flags.StringVar(&cfg.Model, "model", "24c32", "memory model: "+strings.Join(hal.AT24Models(), ", "))
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: riscv-dev eeprom [flags] list")
+		fmt.Fprintln(flags.Output(), "       riscv-dev eeprom [flags] get key")
+		fmt.Fprintln(flags.Output(), "       riscv-dev eeprom [flags] set key value")
+		fmt.Fprintln(flags.Output(), "       riscv-dev eeprom [flags] rm key")
+		fmt.Fprintln(flags.Output(), "       riscv-dev eeprom [flags] calibration calibration.json")
+		fmt.Fprintln(flags.Output(), "")
+		fmt.Fprintln(flags.Output(), "Reads and writes the records of a board's EEPROM: its identity (serial,")
+		fmt.Fprintln(flags.Output(), "model, revision) and, with calibration, the curves of a calibration file.")
+		flags.PrintDefaults()
+	}
+	pos, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(pos) == 0 {
+		pos = []string{"list"}
+	}
+	want := map[string]int{"list": 1, "get": 2, "set": 3, "rm": 2, "calibration": 2}
+	if n, ok := want[pos[0]]; !ok || n != len(pos) {
+		flags.Usage()
+		return errors.New("invalid arguments")
+	}
+
+	mem, err := hal.OpenEEPROM(cfg)
+	if err != nil {
+		return err
+	}
+	defer mem.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	store, err := nvstore.Open(ctx, mem)
+	if err != nil {
+		return err
+	}
+
+	switch pos[0] {
+	case "list":
+		for _, k := range store.Keys() {
+			v, _ := store.Get(k)
+			if len(v) > 60 {
+				v = fmt.Sprintf("%s... (%d bytes)", v[:57], len(v))
+			}
+			fmt.Printf("%-12s %s\n", k, v)
+		}
+		fmt.Printf("%d bytes free\n", store.Free())
+		return nil
+	case "get":
+		v, ok := store.Get(pos[1])
+		if !ok {
+			return fmt.Errorf("no key %q", pos[1])
+		}
+		fmt.Println(v)
+		return nil
+	case "set":
+		err = store.Set(pos[1], pos[2])
+	case "rm":
+		store.Delete(pos[1])
+	case "calibration":
+		err = setCalibration(store, pos[1])
+	}
+	if err != nil {
+		return err
+	}
+	if err := store.Commit(ctx); err != nil {
+		return err
+	}
+	fmt.Printf("✅ Written, %d bytes free\n", store.Free())
+	return nil
+}
+
+// setCalibration stores a calibration file in the EEPROM, compacted
+func setCalibration(store *nvstore.Store, path string) error {
+	f, err := sensor.LoadCalibration(path)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return store.Set("calibration", string(data))
+}
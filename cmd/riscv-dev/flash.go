@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"riscv-dev/pkg/hal"
+)
+
+func runFlash(args []string) error {
+	flags := flag.NewFlagSet("flash", flag.ContinueOnError)
+	var cfg hal.FlashConfig
+	flags.StringVar(&cfg.Device, "device", "", "MTD device (/dev/mtd0), MTD partition name, or spidev device with the chip")
+	flags.IntVar(&cfg.Mode, "mode", 0, "SPI mode, for spidev")
+	flags.IntVar(&cfg.Speed, "speed", 10000000, "SPI clock in Hz, for spidev")
+	offset := flags.Int64("offset", 0, "start of the region, in bytes (0x prefix for hex)")
+	length := flags.Int64("length", 0, "length of the region for dump and erase (default: to the end)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: riscv-dev flash list")
+		fmt.Fprintln(flags.Output(), "       riscv-dev flash -device dev info")
+		fmt.Fprintln(flags.Output(), "       riscv-dev flash -device dev [-offset n] [-length n] dump out.img")
+		fmt.Fprintln(flags.Output(), "       riscv-dev flash -device dev [-offset n] write in.img")
+		fmt.Fprintln(flags.Output(), "       riscv-dev flash -device dev [-offset n] [-length n] erase")
+		fmt.Fprintln(flags.Output(), "")
+		fmt.Fprintln(flags.Output(), "Reads and writes NOR flash, such as the boot flash holding the SPL,")
+		fmt.Fprintln(flags.Output(), "OpenSBI and U-Boot, through MTD or directly over spidev. write only")
+		fmt.Fprintln(flags.Output(), "rewrites the erase blocks that differ and verifies them.")
+		flags.PrintDefaults()
+	}
+	pos, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	want := map[string]int{"list": 1, "info": 1, "dump": 2, "write": 2, "erase": 1}
+	if len(pos) == 0 || want[pos[0]] != len(pos) {
+		flags.Usage()
+		return errors.New("invalid arguments")
+	}
+	if pos[0] == "list" {
+		return listFlash()
+	}
+	if cfg.Device == "" {
+		flags.Usage()
+		return errors.New("-device is required")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	f, err := hal.OpenFlash(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	region := func() (int64, error) {
+		n := *length
+		if n == 0 {
+			n = f.Size() - *offset
+		}
+		if *offset < 0 || n <= 0 || *offset+n > f.Size() {
+			return 0, fmt.Errorf("region 0x%x+0x%x outside the 0x%x byte flash", *offset, n, f.Size())
+		}
+		return n, nil
+	}
+
+	switch pos[0] {
+	case "info":
+		fmt.Printf("Size:       %d bytes (0x%x)\n", f.Size(), f.Size())
+		fmt.Printf("Erase size: %d bytes (0x%x)\n", f.EraseSize(), f.EraseSize())
+		if nor, ok := f.(*hal.SPINOR); ok {
+			fmt.Printf("JEDEC ID:   % x\n", nor.ID())
+		}
+		return nil
+
+	case "dump":
+		n, err := region()
+		if err != nil {
+			return err
+		}
+		data := make([]byte, n)
+		if err := f.ReadAt(ctx, data, *offset); err != nil {
+			return err
+		}
+		if err := os.WriteFile(pos[1], data, 0644); err != nil {
+			return err
+		}
+		fmt.Printf("✅ Dumped %d bytes from 0x%x to %s\n", n, *offset, pos[1])
+		return nil
+
+	case "write":
+		data, err := os.ReadFile(pos[1])
+		if err != nil {
+			return err
+		}
+		written, skipped, err := hal.UpdateFlash(ctx, f, data, *offset)
+		if err != nil {
+			return fmt.Errorf("%w (%d blocks written before the failure)", err, written)
+		}
+		fmt.Printf("✅ Wrote %s at 0x%x: %d blocks rewritten, %d unchanged\n", pos[1], *offset, written, skipped)
+		return nil
+
+	case "erase":
+		n, err := region()
+		if err != nil {
+			return err
+		}
+		if err := f.Erase(ctx, *offset, n); err != nil {
+			return err
+		}
+		fmt.Printf("✅ Erased 0x%x bytes at 0x%x\n", n, *offset)
+		return nil
+	}
+	return nil
+}
+
+func listFlash() error {
+	parts, err := hal.MTDPartitions()
+	if err != nil {
+		return err
+	}
+	if len(parts) == 0 {
+		fmt.Println("➖ No MTD devices (use -device /dev/spidevB.C for a chip without a kernel driver)")
+		return nil
+	}
+	fmt.Printf("%-12s %-16s %10s %10s\n", "DEVICE", "NAME", "SIZE", "ERASE")
+	for _, p := range parts {
+		fmt.Printf("%-12s %-16s %#10x %#10x\n", p.Device, p.Name, p.Size, p.EraseSize)
+	}
+	return nil
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// goarchByMachine maps `uname -m` on the board to GOARCH
+var goarchByMachine = map[string]string{
+	"riscv64": "riscv64",
+	"aarch64": "arm64",
+	"armv7l":  "arm",
+	"x86_64":  "amd64",
+}
+
+// hilPackage is a package with tests that only build under the hil tag
+type hilPackage struct {
+	path string // import path
+	dir  string // source directory, for testdata
+	name string // directory name on the board
+}
+
+func runHiltest(args []string) error {
+	flags := flag.NewFlagSet("hiltest", flag.ContinueOnError)
+	board := addRemoteFlags(flags)
+	arch := flags.String("arch", "", "GOARCH of the board (default: detected with uname -m)")
+	runPattern := flags.String("run", "", "run only tests matching this regexp")
+	timeout := flags.Duration("timeout", 10*time.Minute, "fail a package whose tests take longer")
+	cover := flags.Bool("cover", false, "collect a coverage profile")
+	profile := flags.Bool("profile", false, "collect CPU and memory pprof profiles")
+	logs := flags.Bool("logs", true, "collect the board's kernel log and journal")
+	collect := addCollectFlag(flags)
+	tags := flags.String("tags", "", "comma-separated build tags in addition to hil")
+	outDir := flags.String("o", "", "directory for the artifacts (default artifacts/<time>-<host>)")
+	keep := flags.Bool("keep", false, "leave the test binaries on the board")
+	verbose := flags.Bool("v", false, "print test output as it arrives")
+	var env []string
+	flags.Func("env", "set `KEY=VALUE` in the tests' environment on the board (repeatable)", func(s string) error {
+		if !strings.Contains(s, "=") {
+			return fmt.Errorf("want KEY=VALUE, got %q", s)
+		}
+		env = append(env, s)
+		return nil
+	})
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: riscv-dev hiltest --host [user@]board [flags] [packages]")
+		fmt.Fprintln(flags.Output(), "")
+		fmt.Fprintln(flags.Output(), "Builds the packages' tests tagged hil for the board, runs them there over SSH")
+		fmt.Fprintln(flags.Output(), "and collects the results. Packages default to ./...")
+		flags.PrintDefaults()
+	}
+	patterns, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if board.host == "" {
+		flags.Usage()
+		return errors.New("--host is required (or set RISCV_DEV_HOST)")
+	}
+	if len(patterns) == 0 {
+		patterns = []string{"./..."}
+	}
+	buildTags := "hil"
+	if *tags != "" {
+		buildTags += "," + *tags
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if *arch == "" {
+		machine, err := board.run(ctx, "uname -m")
+		if err != nil {
+			return err
+		}
+		if *arch = goarchByMachine[machine]; *arch == "" {
+			return fmt.Errorf("%s is %s; set --arch", board.host, machine)
+		}
+	}
+	fmt.Printf("🎯 %s (linux/%s)\n", board.host, *arch)
+
+	pkgs, err := listHILPackages(ctx, *arch, buildTags, patterns)
+	if err != nil {
+		return err
+	}
+	if len(pkgs) == 0 {
+		return fmt.Errorf("no packages in %s have tests tagged hil", strings.Join(patterns, " "))
+	}
+
+	// Build every test binary before touching the board
+	build, err := os.MkdirTemp("", "riscv-dev-hil")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(build)
+	for _, p := range pkgs {
+		fmt.Printf("🔨 Building %s\n", p.path)
+		dir := filepath.Join(build, p.name)
+		args := []string{"test", "-c", "-tags", buildTags, "-o", filepath.Join(dir, "pkg.test")}
+		if *cover {
+			args = append(args, "-cover")
+		}
+		cmd := exec.CommandContext(ctx, "go", append(args, p.path)...)
+		cmd.Env = append(os.Environ(), "GOOS=linux", "GOARCH="+*arch, "CGO_ENABLED=0")
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("building tests for %s: %w", p.path, err)
+		}
+	}
+
+	art, err := newArtifacts(ctx, *outDir, board, "hiltest "+strings.Join(args, " "))
+	if err != nil {
+		return err
+	}
+	results, err := os.Create(art.path("results.json"))
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+
+	// Deploy
+	remoteDir, err := board.run(ctx, "mktemp -d /tmp/riscv-dev-hil.XXXXXX")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if *keep {
+			fmt.Printf("💡 Test binaries left in %s:%s\n", board.host, remoteDir)
+			return
+		}
+		// The run may have been interrupted, so clean up with a fresh context
+		cleanup, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		art.collectLogs(cleanup, board, *logs, *collect)
+		if err := art.finish(); err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️  Writing artifact metadata: %v\n", err)
+		}
+		if _, err := board.run(cleanup, "rm -rf "+shellQuote(remoteDir)); err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️  Cleaning up the board: %v\n", err)
+		}
+	}()
+	fmt.Printf("📦 Copying %d test binaries to %s:%s\n", len(pkgs), board.host, remoteDir)
+	for _, p := range pkgs {
+		if err := board.push(ctx, remoteDir, filepath.Join(build, p.name)); err != nil {
+			return err
+		}
+		if testdata := filepath.Join(p.dir, "testdata"); isDir(testdata) {
+			if err := board.push(ctx, remoteDir+"/"+p.name, testdata); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Run
+	var failed []string
+	var profiles []string
+	for _, p := range pkgs {
+		start := time.Now()
+		ok, err := runHILPackage(ctx, board, p, remoteDir, hilRunOptions{
+			run: *runPattern, timeout: *timeout, cover: *cover, profile: *profile,
+			verbose: *verbose, env: env, art: art, results: results,
+		})
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		elapsed := time.Since(start).Round(10 * time.Millisecond)
+		switch {
+		case err != nil:
+			failed = append(failed, p.path)
+			fmt.Printf("❌ FAIL %s: %v\n", p.path, err)
+		case !ok:
+			failed = append(failed, p.path)
+			fmt.Printf("❌ FAIL %s (%v), see %s\n", p.path, elapsed, art.path(p.name+"/test.log"))
+		default:
+			fmt.Printf("✅ ok   %s (%v)\n", p.path, elapsed)
+		}
+		art.meta.Packages = append(art.meta.Packages, packageResult{Path: p.path, Passed: err == nil && ok, Seconds: elapsed.Seconds()})
+
+		// Bring back what the test binary wrote; a crashed test may not have
+		var pull []string
+		if *cover {
+			pull = append(pull, "cover.out")
+		}
+		if *profile {
+			pull = append(pull, "cpu.pprof", "mem.pprof")
+		}
+		for _, name := range pull {
+			local := art.path(p.name + "/" + name)
+			if err := board.pull(ctx, remoteDir+"/"+p.name+"/"+name, local); err != nil {
+				fmt.Fprintf(os.Stderr, "⚠️  No %s for %s: %v\n", name, p.path, err)
+			} else if name == "cover.out" {
+				profiles = append(profiles, local)
+			}
+		}
+	}
+
+	if len(profiles) > 0 {
+		merged := art.path("coverage.out")
+		if err := mergeCoverProfiles(merged, profiles); err != nil {
+			return err
+		}
+		fmt.Printf("📊 Coverage profile: %s (go tool cover -html=%s)\n", merged, merged)
+	}
+	fmt.Printf("📊 %d of %d packages passed on %s; artifacts in %s\n", len(pkgs)-len(failed), len(pkgs), board.host, art.dir)
+	if len(failed) > 0 {
+		return fmt.Errorf("%d package(s) failed on the board", len(failed))
+	}
+	return nil
+}
+
+// listHILPackages returns the packages matching patterns that have test
+// files built only with the hil tag
+func listHILPackages(ctx context.Context, arch, tags string, patterns []string) ([]hilPackage, error) {
+	type listed struct {
+		dir   string
+		tests string // test file counts
+	}
+	list := func(tags string) (map[string]listed, error) {
+		args := []string{"list", "-e", "-f", "{{.ImportPath}}\t{{.Dir}}\t{{len .TestGoFiles}}/{{len .XTestGoFiles}}"}
+		if tags != "" {
+			args = append(args, "-tags", tags)
+		}
+		cmd := exec.CommandContext(ctx, "go", append(args, patterns...)...)
+		cmd.Env = append(os.Environ(), "GOOS=linux", "GOARCH="+arch)
+		cmd.Stderr = os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("listing packages: %w", err)
+		}
+		pkgs := make(map[string]listed)
+		for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+			if f := strings.Split(line, "\t"); len(f) == 3 {
+				pkgs[f[0]] = listed{dir: f[1], tests: f[2]}
+			}
+		}
+		return pkgs, nil
+	}
+	withHIL, err := list(tags)
+	if err != nil {
+		return nil, err
+	}
+	without, err := list(strings.TrimPrefix(strings.TrimPrefix(tags, "hil"), ","))
+	if err != nil {
+		return nil, err
+	}
+
+	var pkgs []hilPackage
+	for path, info := range withHIL {
+		if info.tests != without[path].tests {
+			name := strings.NewReplacer("/", "_", ".", "_").Replace(path)
+			pkgs = append(pkgs, hilPackage{path: path, dir: info.dir, name: name})
+		}
+	}
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].path < pkgs[j].path })
+	return pkgs, nil
+}
+
+type hilRunOptions struct {
+	run     string
+	timeout time.Duration
+	cover   bool
+	profile bool
+	verbose bool
+	env     []string // KEY=VALUE
+	art     *artifacts
+	results io.Writer // test2json events for all packages
+}
+
+// runHILPackage runs one package's test binary on the board, logging its
+// output to <name>/test.log among the artifacts and converting it to JSON
+// events. It reports whether the tests passed; an error means they
+// couldn't be run.
+func runHILPackage(ctx context.Context, board *remote, p hilPackage, remoteDir string, opts hilRunOptions) (bool, error) {
+	logFile, err := os.Create(opts.art.path(p.name + "/test.log"))
+	if err != nil {
+		return false, err
+	}
+	defer logFile.Close()
+
+	// The test's working directory is its package, as with go test
+	script := "cd " + shellQuote(remoteDir+"/"+p.name) + " &&"
+	if len(opts.env) > 0 {
+		script += " env"
+		for _, kv := range opts.env {
+			script += " " + shellQuote(kv)
+		}
+	}
+	script += fmt.Sprintf(" ./pkg.test -test.v -test.timeout=%s", opts.timeout)
+	if opts.run != "" {
+		script += " -test.run=" + shellQuote(opts.run)
+	}
+	if opts.cover {
+		script += " -test.coverprofile=cover.out"
+	}
+	if opts.profile {
+		script += " -test.cpuprofile=cpu.pprof -test.memprofile=mem.pprof"
+	}
+
+	conv := exec.CommandContext(ctx, "go", "tool", "test2json", "-t", "-p", p.path)
+	conv.Stdout = opts.results
+	conv.Stderr = os.Stderr
+	convIn, err := conv.StdinPipe()
+	if err != nil {
+		return false, err
+	}
+	if err := conv.Start(); err != nil {
+		return false, fmt.Errorf("test2json: %w", err)
+	}
+
+	writers := []io.Writer{logFile, convIn}
+	if opts.verbose {
+		writers = append(writers, os.Stdout)
+	}
+	out := io.MultiWriter(writers...)
+	test := board.command(ctx, script)
+	test.Stdout, test.Stderr = out, out
+	runErr := test.Run()
+	convIn.Close()
+	if err := conv.Wait(); err != nil && ctx.Err() == nil {
+		return false, fmt.Errorf("test2json: %w", err)
+	}
+
+	var exit *exec.ExitError
+	switch {
+	case runErr == nil:
+		return true, nil
+	case errors.As(runErr, &exit) && exit.ExitCode() != 255: // 255 is ssh's own failure
+		return false, nil
+	}
+	return false, fmt.Errorf("running tests on %s: %w", board.host, runErr)
+}
+
+// mergeCoverProfiles concatenates coverage profiles, keeping one mode line
+func mergeCoverProfiles(path string, profiles []string) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	w := bufio.NewWriter(out)
+	wroteMode := false
+	for _, profile := range profiles {
+		data, err := os.ReadFile(profile)
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.SplitAfter(string(data), "\n") {
+			if strings.HasPrefix(line, "mode:") {
+				if wroteMode {
+					continue
+				}
+				wroteMode = true
+			}
+			w.WriteString(line)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+func isDir(path string) bool {
+	st, err := os.Stat(path)
+	return err == nil && st.IsDir()
+}
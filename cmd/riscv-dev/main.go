@@ -0,0 +1,104 @@
+// Command riscv-dev is the developer CLI for the RISC-V development
+// environment: it scaffolds new applications and wraps common board tasks.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"riscv-dev/pkg/buildinfo"
+)
+
+// command is a riscv-dev subcommand
+type command struct {
+	summary string
+	run     func(args []string) error
+}
+
+var commands = map[string]command{
+	"new":          {"Scaffold a new application module from a template", runNew},
+	"preflight":    {"Check the kernel has the drivers the configured hardware needs", runPreflight},
+	"capabilities": {"Print which features work on which board, as JSON or Markdown", runCapabilities},
+	"doctor":       {"Diagnose device access rights (GPIO, I2C, SPI, IIO, PWM)", runDoctor},
+	"udev":         {"Print or install udev rules for non-root device access", runUdev},
+	"overlay":      {"List, enable or disable device-tree overlays", runOverlay},
+	"hiltest":      {"Run tests tagged hil on a board over SSH", runHiltest},
+	"calibrate":    {"Fit a sensor calibration curve to reference points", runCalibrate},
+	"collect":      {"Bring back logs and files from a board, with board and commit metadata", runCollect},
+	"flash":        {"List, dump, erase or write NOR flash partitions", runFlash},
+	"ota":          {"Install, confirm or roll back an A/B root filesystem update", runOTA},
+	"ubootenv":     {"Print or set U-Boot environment variables", runUbootenv},
+	"eeprom":       {"Read or write identity and calibration records in a board EEPROM", runEEPROM},
+	"serial":       {"Copy files such as the sensor history off a board over its serial console", runSerial},
+	"usb":          {"Set up USB gadget mode, or provision a board over its USB serial port", runUSB},
+	"soak":         {"Run the pipeline for simulated days with injected faults, checking for leaks", runSoak},
+	"profile":      {"Compare the standard workload's speed and energy across RISC-V profiles and boards", runProfile},
+	"build":        {"Build the examples or an application for a board, QEMU or this host", runBuild},
+	"backfill":     {"Replay recorded readings into a sink with their original timestamps", runBackfill},
+	"correlate":    {"Report how channels in recorded history correlate, and with what lag", runCorrelate},
+	"version":      {"Print the CLI's version, commit and build date", runVersion},
+}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] == "-h" || os.Args[1] == "--help" || os.Args[1] == "help" {
+		usage()
+		return
+	}
+
+	if os.Args[1] == "-version" || os.Args[1] == "--version" {
+		os.Args[1] = "version"
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "❌ Unknown command %q\n\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Println("riscv-dev - RISC-V development environment CLI")
+	fmt.Println("")
+	fmt.Println("Usage: riscv-dev <command> [arguments]")
+	fmt.Println("")
+	fmt.Println("Commands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("  %-12s %s\n", name, commands[name].summary)
+	}
+	fmt.Println("")
+	fmt.Println("Run 'riscv-dev <command> -h' for command options.")
+}
+
+func runVersion(args []string) error {
+	fmt.Println("riscv-dev", buildinfo.Get())
+	return nil
+}
+
+// parseArgs parses flags that may appear before or after positional
+// arguments, returning the positional arguments in order.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
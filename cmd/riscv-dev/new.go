@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+//go:embed all:templates
+var templateFS embed.FS
+
+// templateData is passed to every scaffolding template
+type templateData struct {
+	Name     string
+	Template string
+	SDKPath  string
+}
+
+var validName = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+func runNew(args []string) error {
+	flags := flag.NewFlagSet("new", flag.ContinueOnError)
+	tmpl := flags.String("template", "sensor", "application template: "+strings.Join(templateNames(), "|"))
+	dir := flags.String("dir", "", "output directory (default ./<name>)")
+	sdk := flags.String("sdk", "", "path to the riscv-dev checkout (default: auto-detect or $RISCV_DEV_ROOT)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: riscv-dev new <name> [--template sensor|server|gpio] [--dir path] [--sdk path]")
+		flags.PrintDefaults()
+	}
+
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		flags.Usage()
+		return errors.New("expected exactly one application name")
+	}
+
+	name := positional[0]
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid name %q: use lowercase letters, digits and dashes", name)
+	}
+	if !hasTemplate(*tmpl) {
+		return fmt.Errorf("unknown template %q (available: %s)", *tmpl, strings.Join(templateNames(), ", "))
+	}
+
+	outDir := *dir
+	if outDir == "" {
+		outDir = name
+	}
+	if entries, err := os.ReadDir(outDir); err == nil && len(entries) > 0 {
+		return fmt.Errorf("output directory %s already exists and is not empty", outDir)
+	}
+
+	sdkPath, err := resolveSDK(*sdk)
+	if err != nil {
+		return err
+	}
+	if absOut, err := filepath.Abs(outDir); err == nil {
+		if rel, err := filepath.Rel(absOut, sdkPath); err == nil {
+			sdkPath = rel
+		}
+	}
+
+	data := templateData{Name: name, Template: *tmpl, SDKPath: filepath.ToSlash(sdkPath)}
+	fmt.Printf("🔨 Scaffolding %s from the %s template...\n", name, *tmpl)
+	if err := renderTree("templates/common", outDir, data); err != nil {
+		return err
+	}
+	if err := renderTree("templates/"+*tmpl, outDir, data); err != nil {
+		return err
+	}
+
+	fmt.Printf("✅ Created %s\n", outDir)
+	fmt.Println("")
+	fmt.Println("Next steps:")
+	fmt.Printf("  cd %s\n", outDir)
+	fmt.Println("  make run-host      # run on this machine with the sim backend")
+	fmt.Println("  make build         # cross-compile for linux/riscv64")
+	return nil
+}
+
+// templateNames lists the application templates (every directory except common)
+func templateNames() []string {
+	entries, _ := templateFS.ReadDir("templates")
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() && e.Name() != "common" {
+			names = append(names, e.Name())
+		}
+	}
+	return names
+}
+
+func hasTemplate(name string) bool {
+	for _, n := range templateNames() {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// renderTree executes every file under root into outDir, stripping the
+// .tmpl suffix that keeps the Go toolchain away from template sources.
+func renderTree(root, outDir string, data templateData) error {
+	return fs.WalkDir(templateFS, root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		rel := strings.TrimPrefix(p, root+"/")
+		target := filepath.Join(outDir, filepath.FromSlash(strings.TrimSuffix(rel, ".tmpl")))
+
+		src, err := templateFS.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		t, err := template.New(path.Base(p)).Delims("[[", "]]").Parse(string(src))
+		if err != nil {
+			return fmt.Errorf("template %s: %w", p, err)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		f, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := t.Execute(f, data); err != nil {
+			return fmt.Errorf("template %s: %w", p, err)
+		}
+		fmt.Printf("  + %s\n", target)
+		return nil
+	})
+}
+
+// resolveSDK locates the riscv-dev module the new application depends on
+func resolveSDK(flagValue string) (string, error) {
+	candidates := []string{flagValue, os.Getenv("RISCV_DEV_ROOT")}
+	if wd, err := os.Getwd(); err == nil {
+		for dir := wd; ; dir = filepath.Dir(dir) {
+			candidates = append(candidates, dir)
+			if filepath.Dir(dir) == dir {
+				break
+			}
+		}
+	}
+
+	for _, dir := range candidates {
+		if dir == "" {
+			continue
+		}
+		if isSDKRoot(dir) {
+			return filepath.Abs(dir)
+		}
+		if flagValue != "" && dir == flagValue {
+			return "", fmt.Errorf("%s is not a riscv-dev checkout (no 'module riscv-dev' go.mod)", dir)
+		}
+	}
+	return "", errors.New("could not locate the riscv-dev checkout; pass --sdk or set RISCV_DEV_ROOT")
+}
+
+func isSDKRoot(dir string) bool {
+	f, err := os.Open(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "module riscv-dev" {
+			return true
+		}
+	}
+	return false
+}
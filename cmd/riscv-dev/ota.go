@@ -0,0 +1,268 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"time"
+
+	"riscv-dev/pkg/abupdate"
+	"riscv-dev/pkg/state"
+)
+
+func runOTA(args []string) error {
+	flags := flag.NewFlagSet("ota", flag.ContinueOnError)
+	configPath := flags.String("config", "/etc/riscv-dev/ota.json", "slot configuration")
+	sum := flags.String("sha256", "", "expected SHA-256 of the (uncompressed) image")
+	health := flags.String("health", "", "health endpoint that must answer 200 OK before confirming, e.g. http://127.0.0.1:9464/healthz")
+	wait := flags.Duration("wait", 5*time.Minute, "how long confirm waits for the health endpoint")
+	reboot := flags.Bool("reboot", false, "reboot after install, or after a rollback")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: riscv-dev ota [flags] status")
+		fmt.Fprintln(flags.Output(), "       riscv-dev ota [flags] install rootfs.img[.gz]|URL|-")
+		fmt.Fprintln(flags.Output(), "       riscv-dev ota [flags] confirm")
+		fmt.Fprintln(flags.Output(), "       riscv-dev ota [flags] rollback")
+		fmt.Fprintln(flags.Output(), "")
+		fmt.Fprintln(flags.Output(), "Updates the root filesystem with A/B slots: install writes the slot not")
+		fmt.Fprintln(flags.Output(), "running and boots it on trial; confirm, run at boot, keeps it once")
+		fmt.Fprintln(flags.Output(), "healthy and rolls back otherwise.")
+		flags.PrintDefaults()
+	}
+	pos, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	want := map[string]int{"status": 1, "install": 2, "confirm": 1, "rollback": 1}
+	if len(pos) == 0 || want[pos[0]] != len(pos) {
+		flags.Usage()
+		return errors.New("invalid arguments")
+	}
+
+	var cfg abupdate.Config
+	data, err := os.ReadFile(*configPath)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("invalid config %s: %w", *configPath, err)
+	}
+	u, err := abupdate.New(cfg)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	counts := openOTAState(cfg.StateFile)
+	defer counts.close()
+
+	switch pos[0] {
+	case "status":
+		s, err := u.Status(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Running:  %s\n", s.Running)
+		fmt.Printf("Boots:    %s\n", s.Boot)
+		if s.Trial {
+			fmt.Printf("Trial:    yes, %d boot(s) so far, falls back to %s\n", s.BootCount, s.Previous)
+		}
+		if s.Failed != "" {
+			fmt.Printf("⚠️  Slot %s failed to boot and was abandoned\n", s.Failed)
+		}
+		if counts.store != nil {
+			fmt.Printf("Updates:  %d attempted, %d installed, %d failed, %d rolled back\n",
+				counts.store.Counter(otaAttempts), counts.store.Counter(otaInstalled),
+				counts.store.Counter(otaFailed), counts.store.Counter(otaRolledBack))
+		}
+		return nil
+
+	case "install":
+		image, err := openImage(ctx, pos[1])
+		if err != nil {
+			return err
+		}
+		defer image.Close()
+		counts.add(otaAttempts)
+		start, last := time.Now(), time.Now()
+		slot, err := u.Install(ctx, image, *sum, func(n int64) {
+			if time.Since(last) >= 5*time.Second {
+				fmt.Printf("📊 %d MB written\n", n>>20)
+				last = time.Now()
+			}
+		})
+		if err != nil {
+			counts.add(otaFailed)
+			return err
+		}
+		counts.add(otaInstalled)
+		fmt.Printf("✅ Slot %s written and verified in %v; it boots on trial next\n", slot, time.Since(start).Round(time.Second))
+		return maybeReboot(*reboot)
+
+	case "confirm":
+		if *health != "" {
+			s, err := u.Status(ctx)
+			if err != nil {
+				return err
+			}
+			if s.Trial && s.Running == s.Boot {
+				hctx, cancel := context.WithTimeout(ctx, *wait)
+				err := abupdate.WaitHealthy(hctx, *health)
+				cancel()
+				if err != nil {
+					fmt.Printf("❌ %v\n", err)
+					slot, rerr := u.Rollback(ctx)
+					if rerr != nil {
+						return rerr
+					}
+					counts.add(otaRolledBack)
+					fmt.Printf("🛑 Rolled back to slot %s\n", slot)
+					return maybeReboot(*reboot)
+				}
+			}
+		}
+		if err := u.Confirm(ctx); err != nil {
+			if errors.Is(err, abupdate.ErrRolledBack) {
+				counts.bootRollback()
+			}
+			return err
+		}
+		fmt.Println("✅ Running slot confirmed")
+		return nil
+
+	case "rollback":
+		slot, err := u.Rollback(ctx)
+		if err != nil {
+			return err
+		}
+		counts.add(otaRolledBack)
+		fmt.Printf("✅ Slot %s boots next\n", slot)
+		return maybeReboot(*reboot)
+	}
+	return nil
+}
+
+// Counters in the OTA state file
+const (
+	otaAttempts   = "ota.attempts"
+	otaInstalled  = "ota.installed"
+	otaFailed     = "ota.failed"
+	otaRolledBack = "ota.rolled_back"
+	// attempt whose boot loader rollback was counted, as confirm reports
+	// it on every boot until the next update
+	otaRollbackSeen = "ota.rollback_seen"
+)
+
+// otaState counts updates in the state file, if configured. Counting is
+// best effort and never stands in the way of an update.
+type otaState struct{ store *state.Store }
+
+func openOTAState(path string) otaState {
+	if path == "" {
+		return otaState{}
+	}
+	s, err := state.Open(path)
+	if err != nil {
+		fmt.Printf("⚠️  Update counts not kept: %v\n", err)
+		return otaState{}
+	}
+	return otaState{s}
+}
+
+func (o otaState) add(key string) {
+	if o.store == nil {
+		return
+	}
+	_, err := o.store.Add(key, 1)
+	if err == nil {
+		err = o.store.Flush()
+	}
+	if err != nil {
+		fmt.Printf("⚠️  Counting %s: %v\n", key, err)
+	}
+}
+
+// bootRollback counts a rollback by the boot loader, once per attempt
+func (o otaState) bootRollback() {
+	if o.store == nil {
+		return
+	}
+	err := o.store.Update(func(tx *state.Tx) error {
+		attempt, _ := tx.Get(otaAttempts)
+		if seen, _ := tx.Get(otaRollbackSeen); seen != attempt {
+			tx.Set(otaRollbackSeen, attempt)
+			tx.Add(otaRolledBack, 1)
+		}
+		return nil
+	})
+	if err == nil {
+		err = o.store.Flush()
+	}
+	if err != nil {
+		fmt.Printf("⚠️  Counting %s: %v\n", otaRolledBack, err)
+	}
+}
+
+func (o otaState) close() {
+	if o.store != nil {
+		o.store.Close()
+	}
+}
+
+// openImage opens an image file, URL or stdin ("-"), decompressing it if
+// its name ends in .gz
+func openImage(ctx context.Context, src string) (io.ReadCloser, error) {
+	var r io.ReadCloser
+	switch {
+	case src == "-":
+		r = io.NopCloser(os.Stdin)
+	case strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://"):
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s: %s", src, resp.Status)
+		}
+		r = resp.Body
+	default:
+		f, err := os.Open(src)
+		if err != nil {
+			return nil, err
+		}
+		r = f
+	}
+	if !strings.HasSuffix(src, ".gz") {
+		return r, nil
+	}
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{gz, r}, nil
+}
+
+func maybeReboot(reboot bool) error {
+	if !reboot {
+		return nil
+	}
+	fmt.Println("Rebooting...")
+	return exec.Command("reboot").Run()
+}
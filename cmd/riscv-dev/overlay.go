@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"riscv-dev/pkg/overlay"
+)
+
+func runOverlay(args []string) error {
+	flags := flag.NewFlagSet("overlay", flag.ContinueOnError)
+	envFile := flags.String("env", "", "boot environment file to edit (default: auto-detect)")
+	dtbDir := flags.String("dtb-dir", "/boot/dtb", "directory searched for .dtbo files when --env is set")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: riscv-dev overlay list | enable <name> | disable <name> [--env file] [--dtb-dir dir]")
+		flags.PrintDefaults()
+	}
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		flags.Usage()
+		return errors.New("expected an overlay action")
+	}
+
+	var mgr overlay.Manager
+	if *envFile != "" {
+		mgr = overlay.NewEnvFile(*envFile, *dtbDir)
+	} else if mgr, err = overlay.Detect(); err != nil {
+		return err
+	}
+
+	action := positional[0]
+	switch action {
+	case "list":
+		available, err := mgr.Available()
+		if err != nil {
+			return err
+		}
+		enabled, err := mgr.Enabled()
+		if err != nil {
+			return err
+		}
+		on := make(map[string]bool)
+		for _, name := range enabled {
+			on[name] = true
+		}
+		fmt.Printf("Device-tree overlays (%s):\n", mgr.Name())
+		for _, name := range available {
+			mark := "  "
+			if on[name] {
+				mark = "✅"
+			}
+			fmt.Printf("  %s %s\n", mark, name)
+		}
+		return nil
+
+	case "enable", "disable":
+		if len(positional) != 2 {
+			flags.Usage()
+			return fmt.Errorf("%s needs an overlay name", action)
+		}
+		name := positional[1]
+		if action == "enable" {
+			err = mgr.Enable(name)
+		} else {
+			err = mgr.Disable(name)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✅ Overlay %s %sd (%s)\n", name, action, mgr.Name())
+		if mgr.NeedsReboot() {
+			fmt.Println("💡 Reboot for the change to take effect")
+		}
+		return nil
+
+	default:
+		flags.Usage()
+		return fmt.Errorf("unknown overlay action %q", action)
+	}
+}
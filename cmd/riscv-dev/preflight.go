@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"riscv-dev/pkg/agent"
+	"riscv-dev/pkg/boards"
+	"riscv-dev/pkg/kmod"
+)
+
+func runPreflight(args []string) error {
+	flags := flag.NewFlagSet("preflight", flag.ContinueOnError)
+	configPath := flags.String("config", "", "agent config.json whose hardware to check for (default: check every feature)")
+	features := flags.String("features", "", "comma-separated features to check: gpio, i2c, spi, iio, pwm, mtd, sound")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: riscv-dev preflight [-config config.json] [-features list]")
+		fmt.Fprintln(flags.Output(), "")
+		fmt.Fprintln(flags.Output(), "Checks that the running kernel has the drivers the HAL needs, and says")
+		fmt.Fprintln(flags.Output(), "what to load, rebuild or enable for each one missing. Run it on the board.")
+		flags.PrintDefaults()
+	}
+	if _, err := parseArgs(flags, args); err != nil {
+		return err
+	}
+
+	var required []string
+	if *features != "" {
+		for _, f := range strings.Split(*features, ",") {
+			required = append(required, strings.TrimSpace(f))
+		}
+	}
+	if *configPath != "" {
+		cfg := agent.DefaultConfig()
+		data, err := os.ReadFile(*configPath)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return fmt.Errorf("invalid config %s: %w", *configPath, err)
+		}
+		required = append(required, cfg.KernelFeatures()...)
+		if len(required) == 0 {
+			fmt.Println("✅ The configuration needs no particular kernel driver")
+			return nil
+		}
+	}
+	for _, f := range required {
+		known := false
+		for _, r := range kmod.Requirements {
+			known = known || r.Feature == f
+		}
+		if !known {
+			return fmt.Errorf("unknown feature %q", f)
+		}
+	}
+
+	// The kernel may have a driver for hardware the board lacks
+	if b, model := boards.Detect(); b != nil {
+		fmt.Printf("🎯 %s (%s)\n", b.Description, model)
+		for _, w := range b.Check(required) {
+			fmt.Printf("⚠️  %s\n", w)
+		}
+	}
+
+	fmt.Println("🩺 Checking kernel drivers...")
+	problems := 0
+	for _, res := range kmod.Check(required...) {
+		switch res.Status {
+		case kmod.Present:
+			fmt.Printf("✅ %-6s %s: %s\n", res.Feature, res.What, res.Detail)
+			continue
+		case kmod.NoDevice, kmod.Unknown:
+			fmt.Printf("⚠️  %-6s %s: %s\n", res.Feature, res.What, res.Detail)
+		default:
+			fmt.Printf("❌ %-6s %s: %s\n", res.Feature, res.What, res.Detail)
+		}
+		fmt.Printf("     💡 %s\n", res.Fix)
+		problems++
+	}
+	switch {
+	case problems > 0 && len(required) == 0:
+		return errors.New("some features are unavailable (pass -config or -features to check only what you need)")
+	case problems > 0:
+		return fmt.Errorf("%d required feature(s) unavailable", problems)
+	}
+	fmt.Println("✅ All drivers present")
+	return nil
+}
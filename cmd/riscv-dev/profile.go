@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"riscv-dev/pkg/bench"
+)
+
+// benchVariant is one build of riscv-dev-bench
+type benchVariant struct {
+	name    string   // e.g. rva22u64, or rva22u64+inline
+	arch    string   // GOARCH
+	env     []string // beyond GOOS, GOARCH and CGO_ENABLED
+	gcflags string
+}
+
+// profileReport is report.json, which profile also takes in place of
+// result files
+type profileReport struct {
+	Results []bench.Result `json:"results"`
+	Failed  []string       `json:"failed,omitempty"` // board/build pairs that didn't run
+}
+
+func runProfile(args []string) error {
+	flags := flag.NewFlagSet("profile", flag.ContinueOnError)
+	board := addRemoteFlags(flags)
+	profiles := flags.String("riscv64", "rva20u64,rva22u64", "comma-separated RISC-V profiles to build for riscv64 boards")
+	inline := flags.Bool("inline", false, "also build each profile with inlining, which board builds leave out")
+	runs := flags.Int("runs", 5, "measured runs of each build")
+	samples := flags.Int("samples", 20000, "readings per run")
+	channels := flags.Int("channels", 8, "channels per reading")
+	subscribers := flags.Int("subscribers", 4, "local subscribers to the readings")
+	ina219 := flags.String("ina219", "", "INA219 on the boards' supply, as `device[:address]`, e.g. /dev/i2c-1:0x40")
+	shunt := flags.Float64("shunt", 0.1, "the INA219's shunt resistor in ohms")
+	outDir := flags.String("o", "", "directory for the results and report (default artifacts/<time>-profile)")
+	markdown := flags.Bool("markdown", false, "print the comparison as Markdown")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: riscv-dev profile --host [user@]board[,board...] [flags]")
+		fmt.Fprintln(flags.Output(), "       riscv-dev profile [--markdown] report.json|result.json...")
+		fmt.Fprintln(flags.Output(), "")
+		fmt.Fprintln(flags.Output(), "Builds the standard workload (sampling, filtering, JSON encoding and local")
+		fmt.Fprintln(flags.Output(), "broadcast) once per RISC-V profile, runs every build on every board and")
+		fmt.Fprintln(flags.Output(), "compares wall time, cycles and instructions from the perf counters, and")
+		fmt.Fprintln(flags.Output(), "energy from an INA219 if the boards have one. Builds are compared with the")
+		fmt.Fprintln(flags.Output(), "first on the same board. Given earlier reports or results, compares those.")
+		flags.PrintDefaults()
+	}
+	files, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(files) > 0 {
+		var results []bench.Result
+		for _, path := range files {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			var report profileReport
+			if err := json.Unmarshal(data, &report); err == nil && len(report.Results) > 0 {
+				results = append(results, report.Results...)
+				continue
+			}
+			var r bench.Result
+			if err := json.Unmarshal(data, &r); err != nil {
+				return fmt.Errorf("invalid result %s: %w", path, err)
+			}
+			results = append(results, r)
+		}
+		return bench.WriteTable(os.Stdout, bench.Compare(results), *markdown)
+	}
+	if board.host == "" {
+		flags.Usage()
+		return errors.New("--host is required (or set RISCV_DEV_HOST)")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	root, err := resolveSDK("")
+	if err != nil {
+		return err
+	}
+
+	// Find out what each board needs before building anything
+	var boards []*remote
+	arches := make(map[*remote]string)
+	for _, host := range strings.Split(board.host, ",") {
+		b := &remote{host: strings.TrimSpace(host), port: board.port, identity: board.identity}
+		machine, err := b.run(ctx, "uname -m")
+		if err != nil {
+			return err
+		}
+		if arches[b] = goarchByMachine[machine]; arches[b] == "" {
+			return fmt.Errorf("%s is %s, which riscv-dev doesn't build for", b.host, machine)
+		}
+		boards = append(boards, b)
+		fmt.Printf("🎯 %s (linux/%s)\n", b.host, arches[b])
+	}
+
+	build, err := os.MkdirTemp("", "riscv-dev-profile")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(build)
+	variants := make(map[string][]benchVariant) // by GOARCH
+	stamp := strings.Join(append([]string{"-s", "-w"}, stampFlags(ctx, root, "", "board")...), " ")
+	for _, arch := range arches {
+		if _, ok := variants[arch]; ok {
+			continue
+		}
+		var vs []benchVariant
+		if arch == "riscv64" {
+			for _, p := range strings.Split(*profiles, ",") {
+				vs = append(vs, benchVariant{name: strings.TrimSpace(p), arch: arch, env: []string{"GORISCV64=" + strings.TrimSpace(p)}, gcflags: "all=-l"})
+			}
+		} else {
+			vs = append(vs, benchVariant{name: arch, arch: arch, gcflags: "all=-l"})
+		}
+		if *inline {
+			for _, v := range vs {
+				v.name += "+inline"
+				v.gcflags = ""
+				vs = append(vs, v)
+			}
+		}
+		for _, v := range vs {
+			fmt.Printf("🔨 Building %s\n", v.name)
+			cmdArgs := []string{"build", "-trimpath", "-ldflags", stamp}
+			if v.gcflags != "" {
+				cmdArgs = append(cmdArgs, "-gcflags", v.gcflags)
+			}
+			cmd := exec.CommandContext(ctx, "go", append(cmdArgs, "-o", filepath.Join(build, "bench-"+v.name), "./cmd/riscv-dev-bench")...)
+			cmd.Dir = root
+			cmd.Env = append(append(os.Environ(), "GOOS=linux", "GOARCH="+arch, "CGO_ENABLED=0"), v.env...)
+			cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+			if err := cmd.Run(); err != nil {
+				return fmt.Errorf("building %s: %w", v.name, err)
+			}
+		}
+		variants[arch] = vs
+	}
+
+	if *outDir == "" {
+		*outDir = filepath.Join("artifacts", time.Now().Format("20060102-150405")+"-profile")
+	}
+	benchArgs := fmt.Sprintf(" -json -runs %d -samples %d -channels %d -subscribers %d", *runs, *samples, *channels, *subscribers)
+	if *ina219 != "" {
+		benchArgs += " -ina219 " + shellQuote(*ina219) + " -shunt " + strconv.FormatFloat(*shunt, 'g', -1, 64)
+	}
+	var report profileReport
+	for _, b := range boards {
+		results, failed, err := profileBoard(ctx, b, variants[arches[b]], build, benchArgs, *outDir, "profile "+strings.Join(args, " "))
+		if err != nil {
+			return err
+		}
+		report.Results = append(report.Results, results...)
+		report.Failed = append(report.Failed, failed...)
+	}
+	if len(report.Results) == 0 {
+		return errors.New("no build ran on any board")
+	}
+
+	rows := bench.Compare(report.Results)
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(*outDir, "report.json"), append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	var md bytes.Buffer
+	fmt.Fprintf(&md, "# Workload profile\n\n%d readings of %d channels to %d subscribers, median of %d runs.\n\n",
+		*samples, *channels, *subscribers, *runs)
+	bench.WriteTable(&md, rows, true)
+	for _, f := range report.Failed {
+		fmt.Fprintf(&md, "\n%s did not run.", f)
+	}
+	if err := os.WriteFile(filepath.Join(*outDir, "report.md"), md.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	fmt.Println()
+	if err := bench.WriteTable(os.Stdout, rows, *markdown); err != nil {
+		return err
+	}
+	fmt.Printf("\n📊 Report in %s\n", filepath.Join(*outDir, "report.md"))
+	if len(report.Failed) > 0 {
+		return fmt.Errorf("%d build(s) failed to run: %s", len(report.Failed), strings.Join(report.Failed, ", "))
+	}
+	return nil
+}
+
+// profileBoard runs every variant on a board, saving each result under
+// <dir>/<host>/ with the board's metadata. A variant that fails to run,
+// e.g. a profile the board's CPU lacks instructions for, is reported as
+// failed; an error means the board couldn't be used.
+func profileBoard(ctx context.Context, b *remote, variants []benchVariant, build, benchArgs, dir, command string) ([]bench.Result, []string, error) {
+	host := b.host[strings.LastIndex(b.host, "@")+1:]
+	art, err := newArtifacts(ctx, filepath.Join(dir, host), b, command)
+	if err != nil {
+		return nil, nil, err
+	}
+	remoteDir, err := b.run(ctx, "mktemp -d /tmp/riscv-dev-profile.XXXXXX")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		// The run may have been interrupted, so clean up with a fresh context
+		cleanup, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := art.finish(); err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️  Writing artifact metadata: %v\n", err)
+		}
+		if _, err := b.run(cleanup, "rm -rf "+shellQuote(remoteDir)); err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️  Cleaning up the board: %v\n", err)
+		}
+	}()
+
+	var local []string
+	for _, v := range variants {
+		local = append(local, filepath.Join(build, "bench-"+v.name))
+	}
+	fmt.Printf("📦 Copying %d builds to %s:%s\n", len(local), b.host, remoteDir)
+	if err := b.push(ctx, remoteDir, local...); err != nil {
+		return nil, nil, err
+	}
+
+	var results []bench.Result
+	var failed []string
+	for _, v := range variants {
+		fmt.Printf("🔥 %s on %s\n", v.name, b.host)
+		var out bytes.Buffer
+		cmd := b.command(ctx, "cd "+shellQuote(remoteDir)+" && ./bench-"+v.name+" -variant "+shellQuote(v.name)+benchArgs)
+		cmd.Stdout, cmd.Stderr = &out, os.Stderr
+		if err := cmd.Run(); err != nil {
+			if ctx.Err() != nil {
+				return nil, nil, ctx.Err()
+			}
+			var exit *exec.ExitError
+			if errors.As(err, &exit) && exit.ExitCode() == 255 { // ssh's own failure
+				return nil, nil, fmt.Errorf("%s: %w", b.host, err)
+			}
+			fmt.Printf("❌ %s failed on %s: %v\n", v.name, b.host, err)
+			failed = append(failed, v.name+" on "+host)
+			continue
+		}
+		if err := os.WriteFile(art.path(v.name+".json"), out.Bytes(), 0644); err != nil {
+			return nil, nil, err
+		}
+		var r bench.Result
+		if err := json.Unmarshal(out.Bytes(), &r); err != nil {
+			return nil, nil, fmt.Errorf("%s on %s: invalid result: %w", v.name, b.host, err)
+		}
+		r.Host = b.host
+		results = append(results, r)
+	}
+	return results, failed, nil
+}
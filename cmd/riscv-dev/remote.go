@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// remote runs commands on a board and copies files to and from it with the
+// system ssh and scp, so the user's keys, agent and ~/.ssh/config apply
+type remote struct {
+	host     string // [user@]host
+	port     int    // 0 for the ssh default
+	identity string // private key file, if not the default
+}
+
+// addRemoteFlags registers the flags selecting a board
+func addRemoteFlags(flags *flag.FlagSet) *remote {
+	r := &remote{}
+	flags.StringVar(&r.host, "host", os.Getenv("RISCV_DEV_HOST"), "board to use, as [user@]host (default $RISCV_DEV_HOST)")
+	flags.IntVar(&r.port, "port", 0, "SSH port")
+	flags.StringVar(&r.identity, "identity", "", "SSH private key file")
+	return r
+}
+
+// options returns the options shared by ssh and scp. BatchMode makes a
+// missing key fail instead of prompting, which would hang under CI.
+func (r *remote) options() []string {
+	opts := []string{"-o", "BatchMode=yes", "-o", "ConnectTimeout=10"}
+	if r.identity != "" {
+		opts = append(opts, "-i", r.identity)
+	}
+	return opts
+}
+
+// command prepares a shell command line to run on the board
+func (r *remote) command(ctx context.Context, script string) *exec.Cmd {
+	args := r.options()
+	if r.port != 0 {
+		args = append(args, "-p", strconv.Itoa(r.port))
+	}
+	args = append(args, r.host, script)
+	return exec.CommandContext(ctx, "ssh", args...)
+}
+
+// run runs script on the board and returns its trimmed output
+func (r *remote) run(ctx context.Context, script string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := r.command(ctx, script)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s: %s: %w%s", r.host, script, err, detail(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// push copies local files and directories into dir on the board
+func (r *remote) push(ctx context.Context, dir string, local ...string) error {
+	args := append(r.scpOptions(), local...)
+	return r.scp(ctx, append(args, r.host+":"+dir+"/")...)
+}
+
+// pull copies path on the board, a file or directory, to local
+func (r *remote) pull(ctx context.Context, path, local string) error {
+	return r.scp(ctx, append(r.scpOptions(), r.host+":"+path, local)...)
+}
+
+func (r *remote) scpOptions() []string {
+	args := append(r.options(), "-q", "-r")
+	if r.port != 0 {
+		args = append(args, "-P", strconv.Itoa(r.port))
+	}
+	return args
+}
+
+func (r *remote) scp(ctx context.Context, args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "scp", args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("scp %s: %w%s", r.host, err, detail(stderr.String()))
+	}
+	return nil
+}
+
+// detail formats a command's error output for appending to an error
+func detail(stderr string) string {
+	if s := strings.TrimSpace(stderr); s != "" {
+		return " (" + s + ")"
+	}
+	return ""
+}
+
+// shellQuote quotes s for the board's POSIX shell
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./=:@,+") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
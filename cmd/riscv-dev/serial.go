@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"riscv-dev/pkg/hal"
+	"riscv-dev/pkg/sensor"
+	"riscv-dev/pkg/serialexport"
+)
+
+// maxCommandChunks bounds the chunk list typed into the board's shell,
+// well inside the 4096 characters a terminal line holds
+const maxCommandChunks = 1500
+
+func runSerial(args []string) error {
+	flags := flag.NewFlagSet("serial", flag.ContinueOnError)
+	chunks := flags.String("chunks", "", "send only these chunks, as listed by receive: name:1,4-9;other:0")
+	port := flags.String("port", "", "serial device on the host, e.g. /dev/ttyUSB0")
+	baud := flags.Int("baud", 115200, "baud rate of the console")
+	out := flags.String("out", ".", "directory for the received files")
+	run := flags.String("run", "", "send command to type into the board's shell, e.g. \"riscv-dev serial send /var/lib/sensor/history\"")
+	retries := flags.Int("retries", 10, "times to ask again for chunks lost on the line, with -run")
+	idle := flags.Duration("idle", 30*time.Second, "give up after this long without output")
+	toCSV := flags.Bool("csv", false, "also write each history file (.hist) as CSV")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: riscv-dev serial send [-chunks list] path...")
+		fmt.Fprintln(flags.Output(), "       riscv-dev serial receive -port dev [-baud n] [-out dir] [-run cmd] [-csv]")
+		fmt.Fprintln(flags.Output(), "")
+		fmt.Fprintln(flags.Output(), "Gets files, such as the sensor history, off a board over its serial")
+		fmt.Fprintln(flags.Output(), "console when the network is down. send runs on the board and prints")
+		fmt.Fprintln(flags.Output(), "the files (or every file in a directory) as checksummed text lines.")
+		fmt.Fprintln(flags.Output(), "receive runs on the host, picks them out of the console output and")
+		fmt.Fprintln(flags.Output(), "writes the files; with -run it types the send command into a logged-in")
+		fmt.Fprintln(flags.Output(), "shell itself, and asks again for whatever was garbled.")
+		flags.PrintDefaults()
+	}
+	pos, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(pos) >= 2 && pos[0] == "send":
+		return serialSend(pos[1:], *chunks)
+	case len(pos) == 1 && pos[0] == "receive" && *port != "":
+		return serialReceive(*port, *baud, *out, *run, *retries, *idle, *toCSV)
+	}
+	flags.Usage()
+	return errors.New("invalid arguments")
+}
+
+// serialSend prints the files, and those in directories, to stdout
+func serialSend(args []string, chunks string) error {
+	var only map[string][]int
+	if chunks != "" {
+		var err error
+		if only, err = serialexport.ParseChunks(chunks); err != nil {
+			return err
+		}
+	}
+	var paths []string
+	for _, arg := range args {
+		fi, err := os.Stat(arg)
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			paths = append(paths, arg)
+			continue
+		}
+		entries, err := os.ReadDir(arg)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if e.Type().IsRegular() {
+				paths = append(paths, filepath.Join(arg, e.Name()))
+			}
+		}
+	}
+	return serialexport.Send(os.Stdout, paths, only)
+}
+
+// idleReader gives up when nothing arrives for a while
+type idleReader struct {
+	uart *hal.UART
+	idle time.Duration
+}
+
+func (r idleReader) Read(p []byte) (int, error) {
+	r.uart.SetReadDeadline(time.Now().Add(r.idle))
+	return r.uart.Read(p)
+}
+
+func serialReceive(port string, baud int, out, run string, retries int, idle time.Duration, toCSV bool) error {
+	uart, err := hal.OpenUART(port, baud)
+	if err != nil {
+		return err
+	}
+	defer uart.Close()
+	if err := os.MkdirAll(out, 0755); err != nil {
+		return err
+	}
+
+	rc := serialexport.NewReceiver()
+	cmd := run
+	if run == "" {
+		fmt.Printf("📡 Listening on %s; run 'riscv-dev serial send <paths>' on the board console\n", port)
+	}
+	for attempt := 0; ; attempt++ {
+		if cmd != "" {
+			fmt.Printf("📡 %s\n", cmd)
+			// A terminal sends carriage return for Enter
+			if _, err := io.WriteString(uart, cmd+"\r"); err != nil {
+				return err
+			}
+		}
+		err := rc.Read(idleReader{uart: uart, idle: idle})
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			err = fmt.Errorf("nothing from %s for %v", port, idle)
+		}
+		if err != nil {
+			return err
+		}
+		missing := rc.Missing(maxCommandChunks)
+		if run == "" || attempt == retries || (missing == "" && rc.Lost() == 0) {
+			break
+		}
+		if rc.Lost() > 0 {
+			fmt.Printf("⚠️  %d files lost on the line, sending everything again\n", rc.Lost())
+			cmd = run
+		} else {
+			n := 0
+			for _, f := range rc.Files() {
+				n += len(f.Missing())
+			}
+			fmt.Printf("⚠️  %d chunks garbled on the line, asking again\n", n)
+			cmd = run + " -chunks '" + missing + "'"
+		}
+	}
+
+	failed := 0
+	for _, f := range rc.Files() {
+		data, err := f.Data()
+		if err != nil {
+			fmt.Printf("❌ %s: %v (missing %d chunks)\n", f.Name, err, len(f.Missing()))
+			failed++
+			continue
+		}
+		path := filepath.Join(out, f.Name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			return err
+		}
+		fmt.Printf("✅ %s (%d bytes)\n", path, len(data))
+		if toCSV && strings.HasSuffix(f.Name, ".hist") {
+			if err := writeHistoryCSV(strings.TrimSuffix(path, ".hist")+".csv", data); err != nil {
+				fmt.Printf("❌ %s: %v\n", f.Name, err)
+			}
+		}
+	}
+	if rc.Bad > 0 {
+		fmt.Printf("⚠️  %d garbled lines dropped\n", rc.Bad)
+	}
+	if rc.Lost() > 0 {
+		failed += rc.Lost()
+		fmt.Printf("❌ %d files lost entirely\n", rc.Lost())
+	}
+	if failed > 0 {
+		if m := rc.Missing(0); m != "" {
+			fmt.Printf("💡 Fill in with: riscv-dev serial send <paths> -chunks '%s'\n", m)
+		}
+		return fmt.Errorf("%d files incomplete", failed)
+	}
+	return nil
+}
+
+// writeHistoryCSV writes the samples of a history file as time, value,
+// quality
+func writeHistoryCSV(path string, data []byte) error {
+	samples, err := sensor.DecodeHistory(data)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"time", "value", "quality"})
+	for _, s := range samples {
+		w.Write([]string{s.Time.UTC().Format(time.RFC3339Nano), strconv.FormatFloat(s.Value, 'g', -1, 64), s.Quality.String()})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("✅ %s (%d samples)\n", path, len(samples))
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"riscv-dev/pkg/ubootenv"
+)
+
+func runUbootenv(args []string) error {
+	flags := flag.NewFlagSet("ubootenv", flag.ContinueOnError)
+	config := flags.String("c", ubootenv.DefaultConfig, "fw_env.config file locating the environment")
+	force := flags.Bool("force", false, "set variables even if no valid environment is stored, replacing U-Boot's built-in default")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: riscv-dev ubootenv [-c fw_env.config] print [name...]")
+		fmt.Fprintln(flags.Output(), "       riscv-dev ubootenv [-c fw_env.config] set name [value...]")
+		fmt.Fprintln(flags.Output(), "")
+		fmt.Fprintln(flags.Output(), "Prints or changes U-Boot environment variables, like fw_printenv and")
+		fmt.Fprintln(flags.Output(), "fw_setenv. set without a value deletes the variable.")
+		flags.PrintDefaults()
+	}
+	pos, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(pos) == 0 {
+		pos = []string{"print"}
+	}
+	if (pos[0] != "print" && pos[0] != "set") || (pos[0] == "set" && len(pos) < 2) {
+		flags.Usage()
+		return errors.New("invalid arguments")
+	}
+
+	locs, err := ubootenv.LoadConfig(*config)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	env, err := ubootenv.Read(ctx, locs)
+	if errors.Is(err, ubootenv.ErrNoValidEnv) {
+		if pos[0] == "set" && !*force {
+			return fmt.Errorf("%w; the board boots with U-Boot's default environment, which saving would replace (use -force, or saveenv from U-Boot first)", err)
+		}
+		fmt.Println("⚠️  No valid environment stored; U-Boot uses its built-in default")
+	} else if err != nil {
+		return err
+	}
+
+	if pos[0] == "set" {
+		if err := env.Set(pos[1], strings.Join(pos[2:], " ")); err != nil {
+			return err
+		}
+		return env.Save(ctx)
+	}
+	names := pos[1:]
+	if len(names) == 0 {
+		names = env.Names()
+	}
+	for _, name := range names {
+		v, ok := env.Get(name)
+		if !ok {
+			return fmt.Errorf("%s not defined", name)
+		}
+		fmt.Printf("%s=%s\n", name, v)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"riscv-dev/pkg/devaccess"
+)
+
+func runUdev(args []string) error {
+	flags := flag.NewFlagSet("udev", flag.ContinueOnError)
+	dir := flags.String("dir", devaccess.DefaultRulesDir, "udev rules directory")
+	noReload := flags.Bool("no-reload", false, "do not run udevadm after installing")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: riscv-dev udev print|install [--dir path] [--no-reload]")
+		flags.PrintDefaults()
+	}
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		flags.Usage()
+		return errors.New("expected 'print' or 'install'")
+	}
+
+	switch positional[0] {
+	case "print":
+		fmt.Print(devaccess.UdevRules())
+		return nil
+
+	case "install":
+		created, err := devaccess.EnsureGroups()
+		for _, g := range created {
+			fmt.Printf("✅ Created group %s\n", g)
+		}
+		if err != nil {
+			return err
+		}
+
+		path, err := devaccess.InstallRules(*dir)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✅ Installed %s\n", path)
+
+		if !*noReload {
+			if err := devaccess.ReloadUdev(); err != nil {
+				return err
+			}
+			fmt.Println("✅ udev rules reloaded and triggered")
+		}
+		fmt.Println("💡 Add users to the gpio/i2c/spi/iio/pwm groups with: sudo usermod -aG <group> <user>")
+		return nil
+
+	default:
+		flags.Usage()
+		return fmt.Errorf("unknown udev action %q", positional[0])
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"riscv-dev/pkg/hal"
+	"riscv-dev/pkg/usbgadget"
+)
+
+// usbTimeout bounds the wait for the board's answer to a provisioning
+// request
+const usbTimeout = 10 * time.Second
+
+func runUSB(args []string) error {
+	flags := flag.NewFlagSet("usb", flag.ContinueOnError)
+	root := flags.String("root", "/", "root of the board's filesystem (sysfs and configfs)")
+	serial := flags.Int("serial", 1, "serial ports (CDC-ACM) to set up")
+	ether := flags.String("ether", "", "Ethernet function to set up: ecm (Linux, macOS) or rndis (Windows)")
+	name := flags.String("name", usbgadget.DefaultName, "gadget name under configfs")
+	udc := flags.String("udc", "", "USB device controller, default the first")
+	serialNumber := flags.String("serialnumber", "", "serial number shown to the host")
+	port := flags.String("port", "auto", "host serial device of the board, e.g. /dev/ttyACM0")
+	token := flags.String("token", "", "provisioning token, if the board's config sets one")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: riscv-dev usb status")
+		fmt.Fprintln(flags.Output(), "       riscv-dev usb setup [-serial n] [-ether ecm|rndis] [-name name] [-udc udc]")
+		fmt.Fprintln(flags.Output(), "       riscv-dev usb teardown [-name name]")
+		fmt.Fprintln(flags.Output(), "       riscv-dev usb provision [-port dev] [-token t] info|list|get NAME [file]|put NAME file")
+		fmt.Fprintln(flags.Output(), "")
+		fmt.Fprintln(flags.Output(), "For boards such as the Milk-V Duo that appear to a host as a USB device.")
+		fmt.Fprintln(flags.Output(), "status, setup and teardown run on the board: setup makes it a serial")
+		fmt.Fprintln(flags.Output(), "port (/dev/ttyGS0 on the board, /dev/ttyACM0 on a Linux host) and")
+		fmt.Fprintln(flags.Output(), "optionally a network link, like g_serial and g_ether but through")
+		fmt.Fprintln(flags.Output(), "configfs. provision runs on the host and reads or replaces the files")
+		fmt.Fprintln(flags.Output(), "an agent with provisioning configured offers over that serial port.")
+		flags.PrintDefaults()
+	}
+	pos, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(pos) == 1 && pos[0] == "status":
+		return usbStatus(*root)
+	case len(pos) == 1 && pos[0] == "setup":
+		err := usbgadget.Setup(*root, usbgadget.Config{
+			Name: *name, Serial: *serial, Ethernet: *ether, UDC: *udc, SerialNumber: *serialNumber,
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✅ Gadget %s set up; it appears to the host once the cable is plugged in\n", *name)
+		return nil
+	case len(pos) == 1 && pos[0] == "teardown":
+		if err := usbgadget.Teardown(*root, *name); err != nil {
+			return err
+		}
+		fmt.Printf("✅ Gadget %s removed\n", *name)
+		return nil
+	case len(pos) >= 2 && pos[0] == "provision":
+		return usbProvision(*port, *token, pos[1:])
+	}
+	flags.Usage()
+	return errors.New("invalid arguments")
+}
+
+func usbStatus(root string) error {
+	st, err := usbgadget.Detect(root)
+	if err != nil {
+		return err
+	}
+	if len(st.UDCs) == 0 {
+		fmt.Println("No USB device controller: the port is host-only or not in peripheral (OTG) mode")
+		return nil
+	}
+	for _, u := range st.UDCs {
+		bound := "unused"
+		if u.Bound != "" {
+			bound = "gadget " + u.Bound
+		}
+		fmt.Printf("🔌 %s: %s, %s\n", u.Name, u.State, bound)
+	}
+	for _, m := range st.Legacy {
+		fmt.Printf("   legacy module %s loaded\n", m)
+	}
+	for _, p := range st.Serial {
+		fmt.Printf("   serial %s\n", p)
+	}
+	for _, n := range st.Net {
+		fmt.Printf("   network %s\n", n)
+	}
+	if !st.Active() {
+		fmt.Println("💡 No gadget set up; run riscv-dev usb setup")
+	}
+	return nil
+}
+
+// usbProvision sends one provisioning request to the board
+func usbProvision(port, token string, args []string) error {
+	if port == "auto" {
+		ports := usbgadget.HostPorts("/")
+		if len(ports) == 0 {
+			return errors.New("no USB serial port (/dev/ttyACM*) found; is the board plugged in and set up?")
+		}
+		port = ports[0]
+	}
+	req := usbgadget.Request{Op: args[0]}
+	switch {
+	case (args[0] == "info" || args[0] == "list") && len(args) == 1:
+	case args[0] == "get" && (len(args) == 2 || len(args) == 3):
+		req.File = args[1]
+	case args[0] == "put" && len(args) == 3:
+		data, err := os.ReadFile(args[2])
+		if err != nil {
+			return err
+		}
+		req.File, req.Data = args[1], data
+	default:
+		return fmt.Errorf("bad provisioning request %q (want info, list, get NAME [file] or put NAME file)", strings.Join(args, " "))
+	}
+
+	u, err := hal.OpenUART(port, 115200)
+	if err != nil {
+		return err
+	}
+	defer u.Close()
+	u.SetReadDeadline(time.Now().Add(usbTimeout))
+	c := usbgadget.NewClient(u)
+	c.Token = token
+	resp, err := c.Do(req)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return fmt.Errorf("no answer on %s; is provisioning configured on the board?", port)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch req.Op {
+	case "info":
+		keys := make([]string, 0, len(resp.Info))
+		for k := range resp.Info {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Printf("%-14s %s\n", k, resp.Info[k])
+		}
+	case "list":
+		for _, f := range resp.Files {
+			fmt.Println(f)
+		}
+	case "get":
+		if len(args) == 3 {
+			return os.WriteFile(args[2], resp.Data, 0644)
+		}
+		if json.Valid(resp.Data) {
+			os.Stdout.Write(resp.Data)
+			if len(resp.Data) > 0 && resp.Data[len(resp.Data)-1] != '\n' {
+				fmt.Println()
+			}
+		} else {
+			fmt.Printf("%q\n", resp.Data)
+		}
+	case "put":
+		fmt.Printf("✅ %s replaced on %s; restart the agent to apply\n", req.File, port)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"riscv-dev/pkg/agent"
+	"riscv-dev/pkg/auth"
+	"riscv-dev/pkg/buildinfo"
+	"riscv-dev/pkg/health"
+)
+
+// dashboardConfig serves a page showing the gateway at a glance: channels,
+// sinks, actuators, health, recent events and updates
+type dashboardConfig struct {
+	Addr  string `json:"addr,omitempty"`  // default :8080
+	Title string `json:"title,omitempty"` // default the namespace, or "Edge gateway"
+	// Auth guards the page like the agent's endpoints; without it the
+	// page is open to the network
+	Auth *auth.Config `json:"auth,omitempty"`
+}
+
+// dashboardEvents is how many recent events the page lists
+const dashboardEvents = 20
+
+//go:embed dashboard.html
+var dashboardPage []byte
+
+// dashboard serves the page and the state it polls
+type dashboard struct {
+	cfg   dashboardConfig
+	agent *agent.Agent
+	ota   *otaUpdater // nil without OTA
+	auth  *auth.Authenticator
+
+	mu     sync.Mutex
+	events []agent.Event // newest last
+}
+
+func newDashboard(cfg dashboardConfig, a *agent.Agent, ota *otaUpdater) (*dashboard, error) {
+	if cfg.Addr == "" {
+		cfg.Addr = ":8080"
+	}
+	if cfg.Title == "" {
+		cfg.Title = "Edge gateway"
+		if p := a.Namespace().Path(); p != "" {
+			cfg.Title = p
+		}
+	}
+	authn, err := auth.New(cfg.Auth)
+	if err != nil {
+		return nil, err
+	}
+	return &dashboard{cfg: cfg, agent: a, ota: ota, auth: authn}, nil
+}
+
+// dashboardState is what GET /api/state returns
+type dashboardState struct {
+	Title     string               `json:"title"`
+	Version   string               `json:"version"`
+	Time      time.Time            `json:"time"`
+	Samples   int                  `json:"samples"`
+	Health    health.Report        `json:"health"`
+	Channels  []agent.ChannelInfo  `json:"channels"`
+	Sinks     []agent.SinkStatus   `json:"sinks"`
+	Actuators []agent.ActuatorInfo `json:"actuators,omitempty"`
+	Events    []agent.Event        `json:"events"` // newest first
+	OTA       *otaStatus           `json:"ota,omitempty"`
+}
+
+// run serves the dashboard and keeps recent events until ctx is cancelled
+func (d *dashboard) run(ctx context.Context) error {
+	events, cancel := d.agent.Subscribe(64)
+	defer cancel()
+	go d.collect(ctx, events)
+
+	mux := http.NewServeMux()
+	mux.Handle("/", d.auth.Handler(http.HandlerFunc(d.servePage)))
+	mux.Handle("/api/state", d.auth.Handler(http.HandlerFunc(d.serveState)))
+	srv := &http.Server{Addr: d.cfg.Addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	log.Printf("🎯 Dashboard on %s", d.cfg.Addr)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// collect keeps the latest events other than readings, which the channels
+// already show
+func (d *dashboard) collect(ctx context.Context, events <-chan agent.Event) {
+	for {
+		select {
+		case e := <-events:
+			if e.Type == agent.EventReading {
+				continue
+			}
+			d.mu.Lock()
+			d.events = append(d.events, e)
+			if len(d.events) > dashboardEvents {
+				d.events = d.events[len(d.events)-dashboardEvents:]
+			}
+			d.mu.Unlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (d *dashboard) servePage(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(dashboardPage)
+}
+
+func (d *dashboard) serveState(w http.ResponseWriter, r *http.Request) {
+	s := dashboardState{
+		Title:     d.cfg.Title,
+		Version:   buildinfo.Get().Version,
+		Time:      time.Now(),
+		Samples:   d.agent.SampleCount(),
+		Health:    d.agent.Health().Check(r.Context()),
+		Channels:  d.agent.Channels(),
+		Sinks:     d.agent.SinkStatus(),
+		Actuators: d.agent.Actuators(),
+	}
+	d.mu.Lock()
+	s.Events = make([]agent.Event, len(d.events))
+	for i, e := range d.events {
+		s.Events[len(d.events)-1-i] = e
+	}
+	d.mu.Unlock()
+	if d.ota != nil {
+		status := d.ota.Status()
+		s.OTA = &status
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(s)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"riscv-dev/pkg/agent"
+)
+
+// influxConfig is the "influx" sink's own fields. It writes each channel
+// of a reading as a point in line protocol, tagged with the channel, its
+// unit and the namespace:
+//
+//	{"type": "influx", "url": "http://influx.lan:8086", "org": "acme",
+//	 "bucket": "sensors", "token": "..."}
+type influxConfig struct {
+	URL string `json:"url"`
+	// Org, Bucket and Token address InfluxDB 2 and later
+	Org    string `json:"org,omitempty"`
+	Bucket string `json:"bucket,omitempty"`
+	Token  string `json:"token,omitempty"`
+	// Database, Username and Password address InfluxDB 1
+	Database string `json:"database,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// Measurement names the points; default "sensor"
+	Measurement string `json:"measurement,omitempty"`
+}
+
+func init() {
+	agent.RegisterSinkType("influx", newInfluxSink)
+}
+
+func newInfluxSink(cfg agent.SinkConfig) (agent.Sink, error) {
+	var ic influxConfig
+	if err := cfg.Decode(&ic); err != nil {
+		return nil, err
+	}
+	if ic.URL == "" {
+		return nil, fmt.Errorf("influx sink: url is required")
+	}
+	if ic.Measurement == "" {
+		ic.Measurement = "sensor"
+	}
+	q := url.Values{"precision": {"ms"}}
+	endpoint := strings.TrimSuffix(ic.URL, "/")
+	switch {
+	case ic.Bucket != "" && ic.Database != "":
+		return nil, fmt.Errorf("influx sink: bucket (InfluxDB 2) and database (InfluxDB 1) are mutually exclusive")
+	case ic.Bucket != "":
+		endpoint += "/api/v2/write"
+		q.Set("bucket", ic.Bucket)
+		if ic.Org != "" {
+			q.Set("org", ic.Org)
+		}
+	case ic.Database != "":
+		endpoint += "/write"
+		q.Set("db", ic.Database)
+	default:
+		return nil, fmt.Errorf("influx sink: bucket or database is required")
+	}
+	tlsCfg, err := cfg.TLS.Client(ic.URL)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsCfg
+	transport.DialContext = cfg.Dial()
+	s := &influxSink{cfg: ic, url: endpoint + "?" + q.Encode(), client: &http.Client{Transport: transport}}
+	// Namespace levels tag every point, sorted as InfluxDB prefers
+	labels := cfg.Namespace.Labels()
+	for k := range labels {
+		s.tags = append(s.tags, k)
+	}
+	sort.Strings(s.tags)
+	for i, k := range s.tags {
+		s.tags[i] = escapeTag(k) + "=" + escapeTag(labels[k])
+	}
+	return s, nil
+}
+
+// influxSink writes readings with InfluxDB's HTTP write API. Connections
+// use the sink's interface, budget and static hosts like any other sink's.
+type influxSink struct {
+	cfg    influxConfig
+	url    string
+	tags   []string // key=value, escaped
+	client *http.Client
+}
+
+// Network marks the sink as needing the network
+func (s *influxSink) Network() bool { return true }
+
+// Reconnect drops idle connections, so the next Write dials the new
+// uplink
+func (s *influxSink) Reconnect() { s.client.CloseIdleConnections() }
+
+// Close drops idle connections
+func (s *influxSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+func (s *influxSink) Write(ctx context.Context, r agent.Reading) error {
+	body := s.lines(r)
+	if len(body) == 0 {
+		return nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	switch {
+	case s.cfg.Token != "":
+		req.Header.Set("Authorization", "Token "+s.cfg.Token)
+	case s.cfg.Username != "":
+		req.SetBasicAuth(s.cfg.Username, s.cfg.Password)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("influx %s: %s: %s", s.cfg.URL, resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// lines encodes a reading in line protocol, a point per channel. A
+// channel without a value (a fault) still records its quality.
+func (s *influxSink) lines(r agent.Reading) []byte {
+	var b bytes.Buffer
+	ts := strconv.FormatInt(r.Time.UnixMilli(), 10)
+	for _, ch := range r.Channels {
+		b.WriteString(escapeMeasurement(s.cfg.Measurement))
+		b.WriteString(",channel=" + escapeTag(ch.Name))
+		if ch.Unit != "" {
+			b.WriteString(",unit=" + escapeTag(ch.Unit))
+		}
+		for _, t := range s.tags {
+			b.WriteString("," + t)
+		}
+		b.WriteString(" quality=" + quoteField(ch.Quality.String()))
+		if !math.IsNaN(ch.Value) && !math.IsInf(ch.Value, 0) {
+			b.WriteString(",value=" + strconv.FormatFloat(ch.Value, 'g', -1, 64))
+		}
+		if ch.Min != nil {
+			b.WriteString(",min=" + strconv.FormatFloat(*ch.Min, 'g', -1, 64))
+		}
+		if ch.Max != nil {
+			b.WriteString(",max=" + strconv.FormatFloat(*ch.Max, 'g', -1, 64))
+		}
+		b.WriteString(" " + ts + "\n")
+	}
+	return b.Bytes()
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	tagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	fieldEscaper       = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+)
+
+func escapeMeasurement(s string) string { return measurementEscaper.Replace(s) }
+func escapeTag(s string) string         { return tagEscaper.Replace(s) }
+func quoteField(s string) string        { return `"` + fieldEscaper.Replace(s) + `"` }
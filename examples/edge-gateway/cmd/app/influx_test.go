@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"riscv-dev/pkg/agent"
+	"riscv-dev/pkg/namespace"
+	"riscv-dev/pkg/sensor"
+)
+
+func TestInfluxSink(t *testing.T) {
+	var query, auth, body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		query, auth, body = r.URL.Path+"?"+r.URL.RawQuery, r.Header.Get("Authorization"), string(b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	var sc agent.SinkConfig
+	raw := `{"type": "influx", "url": "` + srv.URL + `/", "org": "acme", "bucket": "plant room", "token": "s3cret"}`
+	if err := json.Unmarshal([]byte(raw), &sc); err != nil {
+		t.Fatal(err)
+	}
+	sc.Namespace = namespace.Namespace{Site: "acme", Room: "boiler, east", Device: "gw=1"}
+	sink, err := newInfluxSink(sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lo, hi := 11.0, 14.0
+	r := agent.Reading{Time: time.UnixMilli(1700000000123), Channels: []agent.ChannelReading{
+		{Name: "flow", Unit: "l/min", Value: 12.5, Quality: sensor.OK, Min: &lo, Max: &hi},
+		{Name: "tank top", Unit: "°C", Value: math.NaN(), Quality: sensor.Fault},
+	}}
+	if err := sink.Write(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	if want := "/api/v2/write?bucket=plant+room&org=acme&precision=ms"; query != want {
+		t.Errorf("request %s, want %s", query, want)
+	}
+	if auth != "Token s3cret" {
+		t.Errorf("Authorization %q", auth)
+	}
+	want := `sensor,channel=flow,unit=l/min,device=gw\=1,room=boiler\,\ east,site=acme quality="ok",value=12.5,min=11,max=14 1700000000123
+sensor,channel=tank\ top,unit=°C,device=gw\=1,room=boiler\,\ east,site=acme quality="sensor-fault" 1700000000123
+`
+	if body != want {
+		t.Errorf("got\n%s\nwant\n%s", body, want)
+	}
+}
+
+func TestInfluxSinkV1(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); r.URL.Path != "/write" || r.URL.Query().Get("db") != "sensors" || user != "gw" || pass != "pw" {
+			http.Error(w, `{"error":"authorization failed"}`, http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	for _, tt := range []struct {
+		raw string
+		ok  bool
+	}{
+		{`{"url": "` + srv.URL + `", "database": "sensors", "username": "gw", "password": "pw"}`, true},
+		{`{"url": "` + srv.URL + `", "database": "sensors", "username": "gw", "password": "wrong"}`, false},
+	} {
+		var sc agent.SinkConfig
+		json.Unmarshal([]byte(tt.raw), &sc)
+		sink, err := newInfluxSink(sc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := agent.Reading{Time: time.Now(), Channels: []agent.ChannelReading{{Name: "flow", Value: 1}}}
+		if err := sink.Write(context.Background(), r); (err == nil) != tt.ok {
+			t.Errorf("%s: %v", tt.raw, err)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"riscv-dev/pkg/agent"
+	"riscv-dev/pkg/buildinfo"
+	"riscv-dev/pkg/config"
+	"riscv-dev/pkg/yaml"
+	// The simulated ADC stands in off the board, e.g. on a development
+	// host or under QEMU
+	_ "riscv-dev/pkg/sim"
+)
+
+// gatewayConfig is gateway.yaml: the agent's configuration, whose drivers
+// add the Modbus, 1-Wire and SHT3x sensors and whose sinks include mqtt and
+// influx, and the gateway's own dashboard and updates
+type gatewayConfig struct {
+	Agent     agent.Config     `json:"agent"`
+	Dashboard *dashboardConfig `json:"dashboard,omitempty"` // nil serves no dashboard
+	OTA       *otaConfig       `json:"ota,omitempty"`       // nil leaves updates to riscv-dev ota
+}
+
+// loadConfig reads a YAML (or JSON) configuration over the defaults. Like
+// config.Load, $RISCV_DEV_CONFIG overrides the path, and only a missing
+// file at the default path is allowed.
+func loadConfig(path string, explicit bool) (gatewayConfig, error) {
+	cfg := gatewayConfig{Agent: agent.DefaultConfig()}
+	if env := os.Getenv(config.EnvPath); env != "" {
+		path, explicit = env, true
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && !explicit {
+			log.Printf("⚠️  No %s; running simulated channels only", path)
+			return cfg, nil
+		}
+		return cfg, fmt.Errorf("failed to read config: %w", err)
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func main() {
+	configPath := flag.String("config", "gateway.yaml", "configuration file, YAML or JSON")
+	buildinfo.RegisterFlag(flag.CommandLine)
+	flag.Parse()
+	explicit := false
+	flag.Visit(func(f *flag.Flag) { explicit = explicit || f.Name == "config" })
+
+	cfg, err := loadConfig(*configPath, explicit)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	if err := agent.ApplyStraps(&cfg.Agent); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+
+	a, err := agent.New(cfg.Agent)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	defer a.Close()
+
+	var ota *otaUpdater
+	if cfg.OTA != nil {
+		if ota, err = newOTAUpdater(*cfg.OTA, a); err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+	}
+	var dash *dashboard
+	if cfg.Dashboard != nil {
+		if dash, err = newDashboard(*cfg.Dashboard, a, ota); err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+	}
+	log.Printf("Edge gateway %s started: %d channels every %v, %d sinks",
+		buildinfo.Get().Version, len(a.Sensors()), cfg.Agent.SampleInterval.D(), len(a.SinkStatus()))
+
+	// Handle graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	var wg sync.WaitGroup
+	if dash != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := dash.run(ctx); err != nil {
+				// Sampling carries on without the dashboard
+				log.Printf("❌ Dashboard: %v", err)
+			}
+		}()
+	}
+	if ota != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ota.run(ctx)
+		}()
+	}
+	if err := a.Run(ctx); err != nil {
+		log.Printf("❌ %v", err)
+	}
+	stop()
+	wg.Wait()
+	log.Printf("Edge gateway stopped after %d samples", a.SampleCount())
+}
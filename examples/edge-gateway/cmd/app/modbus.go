@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"riscv-dev/pkg/agent"
+	"riscv-dev/pkg/config"
+	"riscv-dev/pkg/hal"
+	"riscv-dev/pkg/sensor"
+)
+
+// modbusOptions are the options of a "modbus" driver: one device, over
+// Modbus TCP or Modbus RTU on a serial line, and the registers read from
+// it, each a channel
+type modbusOptions struct {
+	// Address is a Modbus TCP server, host[:port] (port 502 by default)
+	Address string `json:"address,omitempty"`
+	// Serial is the RS-485 line of a Modbus RTU device, e.g. /dev/ttyUSB0
+	Serial string `json:"serial,omitempty"`
+	Baud   int    `json:"baud,omitempty"` // default 9600
+	// Unit is the device's unit (slave) ID; default 1
+	Unit    int             `json:"unit,omitempty"`
+	Timeout config.Duration `json:"timeout,omitempty"` // default 1s
+	// Model is reported as the channels' metadata
+	Model     string           `json:"model,omitempty"`
+	Registers []modbusRegister `json:"registers"`
+}
+
+// modbusRegister is a value read from one or two registers
+type modbusRegister struct {
+	Name string `json:"name"`
+	Unit string `json:"unit,omitempty"`
+	// Address is the register's address on the wire, from 0: the register
+	// data sheets number 40001 is holding register 0
+	Address int `json:"address"`
+	// Table is "holding" (the default) or "input"
+	Table string `json:"table,omitempty"`
+	// Type is uint16 (the default), int16, uint32, int32 or float32.
+	// 32-bit values take two registers, high word first unless WordSwap.
+	Type     string `json:"type,omitempty"`
+	WordSwap bool   `json:"word_swap,omitempty"`
+	// Scale multiplies the value (default 1) and Offset is added after
+	Scale  float64 `json:"scale,omitempty"`
+	Offset float64 `json:"offset,omitempty"`
+}
+
+// Modbus function codes for reading registers
+const (
+	modbusReadHolding = 0x03
+	modbusReadInput   = 0x04
+)
+
+// modbusExceptions name the exception codes a device answers with
+var modbusExceptions = map[byte]string{
+	1:  "illegal function",
+	2:  "illegal data address",
+	3:  "illegal data value",
+	4:  "server device failure",
+	6:  "server device busy",
+	10: "gateway path unavailable",
+	11: "gateway target device failed to respond",
+}
+
+// modbusError is an exception response
+type modbusError struct{ fn, code byte }
+
+func (e modbusError) Error() string {
+	if name, ok := modbusExceptions[e.code]; ok {
+		return fmt.Sprintf("modbus exception %d (%s) to function %d", e.code, name, e.fn)
+	}
+	return fmt.Sprintf("modbus exception %d to function %d", e.code, e.fn)
+}
+
+func init() {
+	agent.RegisterDriverType("modbus", newModbusSensors)
+}
+
+// newModbusSensors creates a sensor per register, sharing a connection to
+// the device that is made on the first read and remade after errors
+func newModbusSensors(options json.RawMessage) ([]agent.Sensor, error) {
+	var opts modbusOptions
+	if err := json.Unmarshal(options, &opts); err != nil {
+		return nil, fmt.Errorf("modbus: %w", err)
+	}
+	if (opts.Address == "") == (opts.Serial == "") {
+		return nil, fmt.Errorf("modbus: set one of address (TCP) and serial (RTU)")
+	}
+	if opts.Baud == 0 {
+		opts.Baud = 9600
+	}
+	if opts.Unit == 0 {
+		opts.Unit = 1
+	}
+	if opts.Unit > 247 {
+		return nil, fmt.Errorf("modbus: unit %d out of range 1-247", opts.Unit)
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = config.Duration(time.Second)
+	}
+	if len(opts.Registers) == 0 {
+		return nil, fmt.Errorf("modbus %s: no registers", opts.device())
+	}
+
+	c := &modbusClient{opts: opts}
+	sensors := make([]agent.Sensor, 0, len(opts.Registers))
+	for _, r := range opts.Registers {
+		s := &modbusSensor{client: c, reg: r, fn: modbusReadHolding, count: 1}
+		switch r.Table {
+		case "", "holding":
+		case "input":
+			s.fn = modbusReadInput
+		default:
+			return nil, fmt.Errorf("modbus %s: %s: unknown table %q (holding or input)", opts.device(), r.Name, r.Table)
+		}
+		switch r.Type {
+		case "", "uint16", "int16":
+		case "uint32", "int32", "float32":
+			s.count = 2
+		default:
+			return nil, fmt.Errorf("modbus %s: %s: unknown type %q (uint16, int16, uint32, int32 or float32)", opts.device(), r.Name, r.Type)
+		}
+		if r.Name == "" {
+			return nil, fmt.Errorf("modbus %s: register %d has no name", opts.device(), r.Address)
+		}
+		if r.Address < 0 || r.Address+int(s.count) > 0x10000 {
+			return nil, fmt.Errorf("modbus %s: %s: address %d out of range", opts.device(), r.Name, r.Address)
+		}
+		if s.reg.Scale == 0 {
+			s.reg.Scale = 1
+		}
+		sensors = append(sensors, s)
+	}
+	c.refs = len(sensors)
+	return sensors, nil
+}
+
+// device names the device in errors
+func (o modbusOptions) device() string {
+	if o.Address != "" {
+		return fmt.Sprintf("%s unit %d", o.Address, o.Unit)
+	}
+	return fmt.Sprintf("%s unit %d", o.Serial, o.Unit)
+}
+
+// modbusSensor is one register of a device
+type modbusSensor struct {
+	client *modbusClient
+	reg    modbusRegister
+	fn     byte
+	count  uint16
+	closed bool
+}
+
+func (s *modbusSensor) Name() string { return s.reg.Name }
+func (s *modbusSensor) Unit() string { return s.reg.Unit }
+
+func (s *modbusSensor) Metadata() sensor.Metadata {
+	return sensor.Metadata{Model: s.client.opts.Model}
+}
+
+func (s *modbusSensor) Read(ctx context.Context) (float64, sensor.Quality, error) {
+	words, err := s.client.readRegisters(ctx, s.fn, uint16(s.reg.Address), s.count)
+	if err != nil {
+		return 0, sensor.Fault, err
+	}
+	return decodeRegisters(words, s.reg.Type, s.reg.WordSwap)*s.reg.Scale + s.reg.Offset, sensor.OK, nil
+}
+
+// Close releases the connection, closed with the device's last sensor
+func (s *modbusSensor) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.client.release()
+}
+
+// decodeRegisters returns the value of typ held in words
+func decodeRegisters(words []uint16, typ string, swap bool) float64 {
+	if len(words) == 1 {
+		if typ == "int16" {
+			return float64(int16(words[0]))
+		}
+		return float64(words[0])
+	}
+	hi, lo := words[0], words[1]
+	if swap {
+		hi, lo = lo, hi
+	}
+	v := uint32(hi)<<16 | uint32(lo)
+	switch typ {
+	case "int32":
+		return float64(int32(v))
+	case "float32":
+		return float64(math.Float32frombits(v))
+	}
+	return float64(v)
+}
+
+// modbusTransport sends a request PDU to a unit and returns the response
+// PDU
+type modbusTransport interface {
+	roundTrip(unit byte, pdu []byte, deadline time.Time) ([]byte, error)
+	Close() error
+}
+
+// modbusClient serialises a device's requests over one transport
+type modbusClient struct {
+	opts modbusOptions
+
+	mu   sync.Mutex
+	t    modbusTransport // nil until connected, and after an error
+	refs int
+}
+
+// readRegisters reads count registers from addr with function fn
+func (c *modbusClient) readRegisters(ctx context.Context, fn byte, addr, count uint16) ([]uint16, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	deadline := time.Now().Add(c.opts.Timeout.D())
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if c.t == nil {
+		t, err := c.connect(ctx, deadline)
+		if err != nil {
+			return nil, err
+		}
+		c.t = t
+	}
+	pdu := []byte{fn, byte(addr >> 8), byte(addr), byte(count >> 8), byte(count)}
+	resp, err := c.t.roundTrip(byte(c.opts.Unit), pdu, deadline)
+	if err != nil {
+		// Whatever is still on its way would be taken for the next answer
+		c.t.Close()
+		c.t = nil
+		return nil, err
+	}
+	return parseReadResponse(fn, count, resp)
+}
+
+// parseReadResponse checks a response to a read of count registers and
+// returns them
+func parseReadResponse(fn byte, count uint16, pdu []byte) ([]uint16, error) {
+	switch {
+	case len(pdu) == 2 && pdu[0] == fn|0x80:
+		return nil, modbusError{fn: fn, code: pdu[1]}
+	case len(pdu) < 2 || pdu[0] != fn:
+		return nil, fmt.Errorf("modbus: unexpected response % x to function %d", pdu, fn)
+	case int(pdu[1]) != 2*int(count) || len(pdu) != 2+2*int(count):
+		return nil, fmt.Errorf("modbus: got %d bytes of registers, want %d", len(pdu)-2, 2*count)
+	}
+	words := make([]uint16, count)
+	for i := range words {
+		words[i] = binary.BigEndian.Uint16(pdu[2+2*i:])
+	}
+	return words, nil
+}
+
+func (c *modbusClient) connect(ctx context.Context, deadline time.Time) (modbusTransport, error) {
+	if c.opts.Serial != "" {
+		port, err := hal.OpenUART(c.opts.Serial, c.opts.Baud)
+		if err != nil {
+			return nil, err
+		}
+		return &modbusRTU{port: port, gap: rtuGap(c.opts.Baud)}, nil
+	}
+	addr := c.opts.Address
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "502")
+	}
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &modbusTCP{conn: conn}, nil
+}
+
+func (c *modbusClient) release() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.refs--; c.refs > 0 || c.t == nil {
+		return nil
+	}
+	err := c.t.Close()
+	c.t = nil
+	return err
+}
+
+// modbusTCP frames requests with the MBAP header of Modbus TCP
+type modbusTCP struct {
+	conn net.Conn
+	tid  uint16
+}
+
+func (t *modbusTCP) roundTrip(unit byte, pdu []byte, deadline time.Time) ([]byte, error) {
+	t.tid++
+	frame := make([]byte, 7, 7+len(pdu))
+	binary.BigEndian.PutUint16(frame[0:], t.tid)
+	binary.BigEndian.PutUint16(frame[4:], uint16(1+len(pdu)))
+	frame[6] = unit
+	frame = append(frame, pdu...)
+	t.conn.SetDeadline(deadline)
+	if _, err := t.conn.Write(frame); err != nil {
+		return nil, err
+	}
+	header := make([]byte, 7)
+	if _, err := io.ReadFull(t.conn, header); err != nil {
+		return nil, err
+	}
+	n := int(binary.BigEndian.Uint16(header[4:]))
+	switch {
+	case binary.BigEndian.Uint16(header[2:]) != 0:
+		return nil, fmt.Errorf("modbus: not a Modbus TCP response")
+	case n < 2 || n > 254:
+		return nil, fmt.Errorf("modbus: bad response length %d", n)
+	}
+	resp := make([]byte, n-1)
+	if _, err := io.ReadFull(t.conn, resp); err != nil {
+		return nil, err
+	}
+	if id := binary.BigEndian.Uint16(header); id != t.tid || header[6] != unit {
+		return nil, fmt.Errorf("modbus: response to transaction %d unit %d, want %d unit %d", id, header[6], t.tid, unit)
+	}
+	return resp, nil
+}
+
+func (t *modbusTCP) Close() error { return t.conn.Close() }
+
+// serialPort is what Modbus RTU needs of a serial line, as hal.UART
+type serialPort interface {
+	io.ReadWriteCloser
+	SetReadDeadline(t time.Time) error
+}
+
+// modbusRTU frames requests for Modbus RTU: the unit, the PDU and a CRC,
+// with frames kept apart by a silent interval
+type modbusRTU struct {
+	port serialPort
+	gap  time.Duration
+	last time.Time // when the previous frame ended
+}
+
+// rtuGap is the silence of 3.5 characters that separates frames, fixed
+// at 1.75ms above 19200 baud as the specification recommends
+func rtuGap(baud int) time.Duration {
+	if baud > 19200 {
+		return 1750 * time.Microsecond
+	}
+	// 11 bits a character: start, 8 data, parity or a second stop, stop
+	return time.Duration(3.5 * 11 * float64(time.Second) / float64(baud))
+}
+
+func (t *modbusRTU) roundTrip(unit byte, pdu []byte, deadline time.Time) ([]byte, error) {
+	if wait := time.Until(t.last.Add(t.gap)); wait > 0 {
+		time.Sleep(wait)
+	}
+	defer func() { t.last = time.Now() }()
+	frame := append([]byte{unit}, pdu...)
+	frame = binary.LittleEndian.AppendUint16(frame, crc16(frame))
+	if _, err := t.port.Write(frame); err != nil {
+		return nil, err
+	}
+	t.port.SetReadDeadline(deadline)
+	resp := make([]byte, 3, 3+255+2)
+	if _, err := io.ReadFull(t.port, resp); err != nil {
+		return nil, err
+	}
+	// An exception is unit, function, code and CRC; a read response has
+	// its byte count third
+	rest := 2
+	if resp[1]&0x80 == 0 {
+		rest += int(resp[2])
+	}
+	resp = resp[:3+rest]
+	if _, err := io.ReadFull(t.port, resp[3:]); err != nil {
+		return nil, err
+	}
+	body := resp[:len(resp)-2]
+	switch {
+	case binary.LittleEndian.Uint16(resp[len(resp)-2:]) != crc16(body):
+		return nil, fmt.Errorf("modbus: response CRC mismatch")
+	case resp[0] != unit:
+		return nil, fmt.Errorf("modbus: response from unit %d, want %d", resp[0], unit)
+	}
+	return body[1:], nil
+}
+
+func (t *modbusRTU) Close() error { return t.port.Close() }
+
+// crc16 is the Modbus CRC: CRC-16/MODBUS, sent low byte first
+func crc16(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCRC16(t *testing.T) {
+	// Read 10 holding registers from unit 1, as framed in the Modbus
+	// over serial line specification's examples
+	if got := crc16([]byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x0A}); got != 0xCDC5 {
+		t.Errorf("crc16 = %#04x, want 0xcdc5 (sent C5 CD)", got)
+	}
+}
+
+func TestDecodeRegisters(t *testing.T) {
+	f := math.Float32bits(21.5)
+	tests := []struct {
+		words []uint16
+		typ   string
+		swap  bool
+		want  float64
+	}{
+		{[]uint16{0xFFFE}, "uint16", false, 65534},
+		{[]uint16{0xFFFE}, "int16", false, -2},
+		{[]uint16{0x0001, 0x0002}, "uint32", false, 65538},
+		{[]uint16{0x0002, 0x0001}, "uint32", true, 65538},
+		{[]uint16{0xFFFF, 0xFFFF}, "int32", false, -1},
+		{[]uint16{uint16(f >> 16), uint16(f)}, "float32", false, 21.5},
+		{[]uint16{uint16(f), uint16(f >> 16)}, "float32", true, 21.5},
+	}
+	for _, tt := range tests {
+		if got := decodeRegisters(tt.words, tt.typ, tt.swap); got != tt.want {
+			t.Errorf("decodeRegisters(%04x, %s, %t) = %g, want %g", tt.words, tt.typ, tt.swap, got, tt.want)
+		}
+	}
+}
+
+// serveModbusTCP answers reads of holding registers from regs until the
+// listener closes, with exception 2 beyond them
+func serveModbusTCP(ln net.Listener, regs []uint16) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			for {
+				req := make([]byte, 12)
+				if _, err := io.ReadFull(conn, req); err != nil {
+					return
+				}
+				fn, addr, count := req[7], binary.BigEndian.Uint16(req[8:]), binary.BigEndian.Uint16(req[10:])
+				pdu := []byte{fn, byte(2 * count)}
+				switch {
+				case fn != modbusReadHolding:
+					pdu = []byte{fn | 0x80, 1}
+				case int(addr)+int(count) > len(regs):
+					pdu = []byte{fn | 0x80, 2}
+				default:
+					for _, w := range regs[addr : addr+count] {
+						pdu = binary.BigEndian.AppendUint16(pdu, w)
+					}
+				}
+				resp := append([]byte(nil), req[:7]...)
+				binary.BigEndian.PutUint16(resp[4:], uint16(1+len(pdu)))
+				conn.Write(append(resp, pdu...))
+			}
+		}()
+	}
+}
+
+func TestModbusTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	f := math.Float32bits(-3.25)
+	go serveModbusTCP(ln, []uint16{215, uint16(f >> 16), uint16(f)})
+
+	options, _ := json.Marshal(modbusOptions{
+		Address: ln.Addr().String(),
+		Registers: []modbusRegister{
+			{Name: "flow", Address: 0, Scale: 0.1},
+			{Name: "pressure", Address: 1, Type: "float32", Offset: 1},
+			{Name: "missing", Address: 2, Type: "uint32"},
+			{Name: "input", Address: 0, Table: "input"},
+		},
+	})
+	sensors, err := newModbusSensors(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i, want := range []float64{21.5, -2.25} {
+		v, _, err := sensors[i].Read(ctx)
+		if err != nil || math.Abs(v-want) > 1e-9 {
+			t.Errorf("%s = %g, %v; want %g", sensors[i].Name(), v, err, want)
+		}
+	}
+	var me modbusError
+	if _, _, err := sensors[2].Read(ctx); !errors.As(err, &me) || me.code != 2 {
+		t.Errorf("reading past the registers: got %v, want exception 2", err)
+	}
+	if _, _, err := sensors[3].Read(ctx); !errors.As(err, &me) || me.code != 1 {
+		t.Errorf("reading input registers: got %v, want exception 1", err)
+	}
+	// Exceptions keep the connection
+	if v, _, err := sensors[0].Read(ctx); err != nil || v != 21.5 {
+		t.Errorf("after exceptions: %g, %v", v, err)
+	}
+	for _, s := range sensors {
+		s.(io.Closer).Close()
+	}
+}
+
+func TestModbusRTU(t *testing.T) {
+	host, device := net.Pipe()
+	go func() {
+		defer device.Close()
+		req := make([]byte, 8)
+		if _, err := io.ReadFull(device, req); err != nil {
+			return
+		}
+		if crc16(req[:6]) != binary.LittleEndian.Uint16(req[6:]) {
+			return
+		}
+		// Two input registers from unit 7, then a garbled answer
+		resp := []byte{7, modbusReadInput, 4, 0x00, 0x01, 0x86, 0xA0}
+		device.Write(binary.LittleEndian.AppendUint16(resp, crc16(resp)))
+		io.ReadFull(device, req)
+		device.Write([]byte{7, modbusReadInput | 0x80, 2, 0, 0})
+	}()
+
+	rtu := &modbusRTU{port: host, gap: rtuGap(9600)}
+	pdu := []byte{modbusReadInput, 0, 10, 0, 2}
+	resp, err := rtu.roundTrip(7, pdu, time.Now().Add(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	words, err := parseReadResponse(modbusReadInput, 2, resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := decodeRegisters(words, "uint32", false); v != 100000 {
+		t.Errorf("got %g, want 100000", v)
+	}
+	if _, err := rtu.roundTrip(7, pdu, time.Now().Add(5*time.Second)); err == nil {
+		t.Error("accepted a response with a bad CRC")
+	}
+}
+
+func TestRTUGap(t *testing.T) {
+	if got := rtuGap(9600); got < 4*time.Millisecond || got > 4100*time.Microsecond {
+		t.Errorf("rtuGap(9600) = %v, want about 4ms", got)
+	}
+	if got := rtuGap(115200); got != 1750*time.Microsecond {
+		t.Errorf("rtuGap(115200) = %v, want 1.75ms", got)
+	}
+}
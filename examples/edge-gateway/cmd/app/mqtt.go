@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"riscv-dev/pkg/agent"
+	"riscv-dev/pkg/config"
+)
+
+// mqttConfig is the "mqtt" sink's own fields. It publishes each reading
+// as JSON with MQTT 3.1.1, which every broker speaks:
+//
+//	{"type": "mqtt", "broker": "tcp://broker.lan:1883", "qos": 1}
+type mqttConfig struct {
+	// Broker is tcp://host[:1883], or mqtts://, ssl:// or tls://host[:8883]
+	// for TLS with the sink's tls settings
+	Broker string `json:"broker"`
+	// ClientID identifies the session; default riscv-dev-<device>, with
+	// the namespace's device or the host name
+	ClientID string `json:"client_id,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// Topic is where readings go, under the namespace; default "readings"
+	Topic string `json:"topic,omitempty"`
+	// Channels also publishes each channel's value, as text, to
+	// <topic>/<channel>, for dashboards that take one value a topic
+	Channels bool `json:"channels,omitempty"`
+	// QoS 1 waits for the broker to acknowledge each message; 0 doesn't
+	QoS    int  `json:"qos,omitempty"`
+	Retain bool `json:"retain,omitempty"`
+	// KeepAlive is how often the connection is checked while idle;
+	// default 60s
+	KeepAlive config.Duration `json:"keep_alive,omitempty"`
+	// Status is a retained topic under the namespace reading "online"
+	// while the gateway is connected and "offline" once it isn't, set by
+	// the broker as the connection's will if it drops; default "status"
+	Status string `json:"status,omitempty"`
+	// StatusOff publishes no status
+	StatusOff bool `json:"status_off,omitempty"`
+}
+
+// MQTT control packet types
+const (
+	mqttConnect    = 1
+	mqttConnAck    = 2
+	mqttPublish    = 3
+	mqttPubAck     = 4
+	mqttPingReq    = 12
+	mqttPingResp   = 13
+	mqttDisconnect = 14
+)
+
+// mqttRefused name the return codes of a refused connection
+var mqttRefused = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "client identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+// mqttAckTimeout bounds the wait for CONNACK and PUBACK
+const mqttAckTimeout = 10 * time.Second
+
+func init() {
+	agent.RegisterSinkType("mqtt", newMQTTSink)
+}
+
+func newMQTTSink(cfg agent.SinkConfig) (agent.Sink, error) {
+	var mc mqttConfig
+	if err := cfg.Decode(&mc); err != nil {
+		return nil, err
+	}
+	if mc.Broker == "" {
+		return nil, fmt.Errorf("mqtt sink: broker is required")
+	}
+	u, err := url.Parse(mc.Broker)
+	if err != nil {
+		return nil, fmt.Errorf("mqtt sink: %w", err)
+	}
+	s := &mqttSink{cfg: mc, dial: cfg.Dial(), addr: u.Host}
+	port := "1883"
+	switch u.Scheme {
+	case "tcp", "mqtt":
+	case "mqtts", "ssl", "tls":
+		port = "8883"
+		if s.tls, err = cfg.TLS.Client(mc.Broker); err != nil {
+			return nil, err
+		}
+		if s.tls.ServerName == "" {
+			s.tls.ServerName = u.Hostname()
+		}
+	default:
+		return nil, fmt.Errorf("mqtt sink: unknown scheme %q (tcp or mqtts)", u.Scheme)
+	}
+	if u.Port() == "" {
+		s.addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	if mc.QoS != 0 && mc.QoS != 1 {
+		return nil, fmt.Errorf("mqtt sink: qos %d isn't supported (0 or 1)", mc.QoS)
+	}
+	if mc.KeepAlive <= 0 {
+		s.cfg.KeepAlive = config.Duration(time.Minute)
+	}
+	if s.cfg.ClientID == "" {
+		id := cfg.Namespace.Device
+		if id == "" {
+			id, _ = os.Hostname()
+		}
+		s.cfg.ClientID = "riscv-dev-" + id
+	}
+	if s.cfg.Topic == "" {
+		s.cfg.Topic = "readings"
+	}
+	s.topic = cfg.Namespace.Topic(s.cfg.Topic)
+	if !mc.StatusOff {
+		if s.cfg.Status == "" {
+			s.cfg.Status = "status"
+		}
+		s.status = cfg.Namespace.Topic(s.cfg.Status)
+	}
+	return s, nil
+}
+
+// mqttSink publishes readings over a connection made on the first Write
+// and remade after errors; the agent retries the reading that failed
+type mqttSink struct {
+	cfg    mqttConfig
+	addr   string
+	tls    *tls.Config // nil without TLS
+	dial   agent.DialFunc
+	topic  string
+	status string // "" without a status topic
+
+	mu   sync.Mutex
+	conn *mqttConn
+}
+
+// Network marks the sink as needing the network
+func (s *mqttSink) Network() bool { return true }
+
+func (s *mqttSink) Write(ctx context.Context, r agent.Reading) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		c, err := s.connect(ctx)
+		if err != nil {
+			return fmt.Errorf("mqtt %s: %w", s.addr, err)
+		}
+		s.conn = c
+	}
+	payload, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	err = s.conn.publish(ctx, s.topic, payload, s.cfg.QoS, s.cfg.Retain)
+	if s.cfg.Channels {
+		for _, ch := range r.Channels {
+			if err != nil {
+				break
+			}
+			if math.IsNaN(ch.Value) {
+				continue // a fault has no value; the reading has its quality
+			}
+			v := strconv.FormatFloat(ch.Value, 'f', -1, 64)
+			err = s.conn.publish(ctx, s.topic+"/"+ch.Name, []byte(v), s.cfg.QoS, s.cfg.Retain)
+		}
+	}
+	if err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return fmt.Errorf("mqtt %s: %w", s.addr, err)
+	}
+	return nil
+}
+
+// Reconnect drops the connection, so the next Write dials the new uplink
+func (s *mqttSink) Reconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// Close marks the gateway offline and disconnects cleanly, which the
+// broker doesn't take as a reason to send the will
+func (s *mqttSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if s.status != "" {
+		s.conn.publish(ctx, s.status, []byte("offline"), s.cfg.QoS, true)
+	}
+	s.conn.send([]byte{mqttDisconnect << 4, 0})
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// connect dials the broker, logs in and announces the gateway online
+func (s *mqttSink) connect(ctx context.Context) (*mqttConn, error) {
+	nc, err := s.dial(ctx, "tcp", s.addr)
+	if err != nil {
+		return nil, err
+	}
+	if s.tls != nil {
+		tc := tls.Client(nc, s.tls)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			nc.Close()
+			return nil, err
+		}
+		nc = tc
+	}
+	c := &mqttConn{Conn: nc, r: bufio.NewReader(nc), acks: make(chan uint16, 16), done: make(chan struct{})}
+	nc.SetDeadline(time.Now().Add(mqttAckTimeout))
+	if err := c.send(s.connectPacket()); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	typ, _, body, err := readMQTTPacket(c.r)
+	switch {
+	case err != nil:
+		nc.Close()
+		return nil, err
+	case typ != mqttConnAck || len(body) != 2:
+		nc.Close()
+		return nil, fmt.Errorf("expected CONNACK, got packet type %d", typ)
+	case body[1] != 0:
+		nc.Close()
+		if why, ok := mqttRefused[body[1]]; ok {
+			return nil, fmt.Errorf("connection refused: %s", why)
+		}
+		return nil, fmt.Errorf("connection refused with code %d", body[1])
+	}
+	nc.SetDeadline(time.Time{})
+	c.touch(&c.received)
+	go c.readLoop()
+	go c.keepAlive(s.cfg.KeepAlive.D())
+	if s.status != "" {
+		if err := c.publish(ctx, s.status, []byte("online"), s.cfg.QoS, true); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// connectPacket logs in with a clean session, leaving "offline" on the
+// status topic as the will
+func (s *mqttSink) connectPacket() []byte {
+	flags := byte(0x02) // clean session
+	body := appendMQTTString(nil, "MQTT")
+	body = append(body, 4, 0) // protocol level 3.1.1; flags set below
+	body = binary.BigEndian.AppendUint16(body, uint16(s.cfg.KeepAlive.D()/time.Second))
+	body = appendMQTTString(body, s.cfg.ClientID)
+	if s.status != "" {
+		flags |= 0x04 | 0x20 | byte(s.cfg.QoS)<<3 // will, retained
+		body = appendMQTTString(body, s.status)
+		body = appendMQTTString(body, "offline")
+	}
+	if s.cfg.Username != "" {
+		flags |= 0x80
+		body = appendMQTTString(body, s.cfg.Username)
+		if s.cfg.Password != "" {
+			flags |= 0x40
+			body = appendMQTTString(body, s.cfg.Password)
+		}
+	}
+	body[7] = flags
+	return mqttPacket(mqttConnect<<4, body)
+}
+
+// mqttConn is a connection to the broker, with a goroutine reading what
+// the broker sends and one keeping the connection alive
+type mqttConn struct {
+	net.Conn
+	r *bufio.Reader
+
+	wmu    sync.Mutex // serialises packets
+	nextID uint16
+	acks   chan uint16 // packet IDs of PUBACKs
+	done   chan struct{}
+	err    error // why done was closed
+
+	sent, received atomic.Int64 // unix nanoseconds
+}
+
+func (c *mqttConn) touch(t *atomic.Int64) { t.Store(time.Now().UnixNano()) }
+
+func (c *mqttConn) send(packet []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.SetWriteDeadline(time.Now().Add(mqttAckTimeout))
+	_, err := c.Write(packet)
+	c.touch(&c.sent)
+	return err
+}
+
+// publish sends a message and, at QoS 1, waits for the broker to
+// acknowledge it
+func (c *mqttConn) publish(ctx context.Context, topic string, payload []byte, qos int, retain bool) error {
+	header := byte(mqttPublish<<4 | qos<<1)
+	if retain {
+		header |= 1
+	}
+	body := appendMQTTString(nil, topic)
+	var id uint16
+	if qos > 0 {
+		c.wmu.Lock()
+		if c.nextID++; c.nextID == 0 {
+			c.nextID = 1 // 0 isn't a valid packet ID
+		}
+		id = c.nextID
+		c.wmu.Unlock()
+		body = binary.BigEndian.AppendUint16(body, id)
+	}
+	if err := c.send(mqttPacket(header, append(body, payload...))); err != nil {
+		return err
+	}
+	if qos == 0 {
+		return nil
+	}
+	timeout := time.NewTimer(mqttAckTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case got := <-c.acks:
+			if got == id {
+				return nil
+			}
+		case <-c.done:
+			return c.err
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout.C:
+			return fmt.Errorf("no PUBACK from the broker in %v", mqttAckTimeout)
+		}
+	}
+}
+
+// readLoop takes acknowledgements and ping responses until the
+// connection fails
+func (c *mqttConn) readLoop() {
+	for {
+		typ, _, body, err := readMQTTPacket(c.r)
+		if err != nil {
+			c.err = err
+			close(c.done)
+			return
+		}
+		c.touch(&c.received)
+		if typ == mqttPubAck && len(body) == 2 {
+			select {
+			case c.acks <- binary.BigEndian.Uint16(body):
+			default: // an ack nobody waits for any more
+			}
+		}
+	}
+}
+
+// keepAlive pings the broker when nothing has been sent for half the keep
+// alive interval, and closes the connection if the broker has been silent
+// for one and a half, so a dead link is noticed before the next Write
+func (c *mqttConn) keepAlive(interval time.Duration) {
+	t := time.NewTicker(interval / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case now := <-t.C:
+			if now.Sub(time.Unix(0, c.received.Load())) > interval*3/2 {
+				c.Close()
+				return
+			}
+			if now.Sub(time.Unix(0, c.sent.Load())) >= interval/2 {
+				c.send([]byte{mqttPingReq << 4, 0})
+			}
+		}
+	}
+}
+
+// mqttPacket frames a packet: its header byte, the remaining length and
+// the body
+func mqttPacket(header byte, body []byte) []byte {
+	p := []byte{header}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		if n /= 128; n > 0 {
+			b |= 0x80
+		}
+		p = append(p, b)
+		if n == 0 {
+			break
+		}
+	}
+	return append(p, body...)
+}
+
+// readMQTTPacket reads a packet, returning its type, flags and body
+func readMQTTPacket(r *bufio.Reader) (typ, flags byte, body []byte, err error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	n, shift := 0, 0
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		n |= int(b&0x7F) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		if shift += 7; shift > 21 {
+			return 0, 0, nil, errors.New("malformed remaining length")
+		}
+	}
+	body = make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, 0, nil, err
+	}
+	return header >> 4, header & 0x0F, body, nil
+}
+
+// appendMQTTString appends s with its 16-bit length
+func appendMQTTString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
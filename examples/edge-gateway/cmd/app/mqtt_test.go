@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"riscv-dev/pkg/agent"
+	"riscv-dev/pkg/namespace"
+	"riscv-dev/pkg/sensor"
+)
+
+// mqttMessage is a PUBLISH the fake broker received
+type mqttMessage struct {
+	topic   string
+	payload string
+	qos     int
+	retain  bool
+}
+
+// fakeBroker accepts one connection, checks CONNECT, acknowledges QoS 1
+// messages and sends everything published on messages
+func fakeBroker(t *testing.T, ln net.Listener, connect func(body []byte), messages chan<- mqttMessage) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	typ, _, body, err := readMQTTPacket(r)
+	if err != nil || typ != mqttConnect {
+		t.Errorf("first packet: type %d, %v", typ, err)
+		return
+	}
+	connect(body)
+	conn.Write([]byte{mqttConnAck << 4, 2, 0, 0})
+	for {
+		typ, flags, body, err := readMQTTPacket(r)
+		if err != nil || typ == mqttDisconnect {
+			close(messages)
+			return
+		}
+		if typ != mqttPublish {
+			continue
+		}
+		n := int(binary.BigEndian.Uint16(body))
+		m := mqttMessage{topic: string(body[2 : 2+n]), qos: int(flags>>1) & 3, retain: flags&1 != 0}
+		rest := body[2+n:]
+		if m.qos > 0 {
+			conn.Write(append([]byte{mqttPubAck << 4, 2}, rest[:2]...))
+			rest = rest[2:]
+		}
+		m.payload = string(rest)
+		messages <- m
+	}
+}
+
+func TestMQTTSink(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	messages := make(chan mqttMessage, 10)
+	var clientID, will string
+	var flags byte
+	go fakeBroker(t, ln, func(body []byte) {
+		flags = body[7]
+		rest := body[10:]
+		next := func() string {
+			n := int(binary.BigEndian.Uint16(rest))
+			s := string(rest[2 : 2+n])
+			rest = rest[2+n:]
+			return s
+		}
+		clientID, will = next(), next()+"="+next()
+	}, messages)
+
+	raw := fmt.Sprintf(`{"type": "mqtt", "broker": "tcp://%s", "qos": 1, "channels": true}`, ln.Addr())
+	var sc agent.SinkConfig
+	if err := json.Unmarshal([]byte(raw), &sc); err != nil {
+		t.Fatal(err)
+	}
+	sc.Namespace = namespace.Namespace{Site: "acme", Device: "gw-1"}
+	sink, err := newMQTTSink(sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	r := agent.Reading{Time: time.Unix(1700000000, 0).UTC(), Channels: []agent.ChannelReading{
+		{Name: "flow", Unit: "l/min", Value: 12.5, Quality: sensor.OK},
+		{Name: "return", Value: math.NaN(), Quality: sensor.Fault},
+	}}
+	if err := sink.Write(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+	sink.(interface{ Close() error }).Close()
+
+	var got []mqttMessage
+	for m := range messages {
+		got = append(got, m)
+	}
+	if clientID != "riscv-dev-gw-1" || will != "acme/gw-1/status=offline" || flags != 0x2E {
+		t.Errorf("CONNECT: client %q, will %q, flags %#x", clientID, will, flags)
+	}
+	want := []mqttMessage{
+		{topic: "acme/gw-1/status", payload: "online", qos: 1, retain: true},
+		{topic: "acme/gw-1/readings", qos: 1},
+		{topic: "acme/gw-1/readings/flow", payload: "12.5", qos: 1},
+		{topic: "acme/gw-1/status", payload: "offline", qos: 1, retain: true},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d messages, want %d: %+v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		g := got[i]
+		if i == 1 {
+			if !strings.Contains(g.payload, `"value":null`) || !strings.Contains(g.payload, `"name":"flow"`) {
+				t.Errorf("reading payload %s", g.payload)
+			}
+			g.payload = ""
+		}
+		if g != w {
+			t.Errorf("message %d: got %+v, want %+v", i, g, w)
+		}
+	}
+}
+
+func TestMQTTRefused(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		readMQTTPacket(bufio.NewReader(conn))
+		conn.Write([]byte{mqttConnAck << 4, 2, 0, 4})
+	}()
+	var sc agent.SinkConfig
+	json.Unmarshal([]byte(fmt.Sprintf(`{"type": "mqtt", "broker": "tcp://%s", "username": "gw", "password": "wrong"}`, ln.Addr())), &sc)
+	sink, err := newMQTTSink(sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sink.Write(context.Background(), agent.Reading{})
+	if err == nil || !strings.Contains(err.Error(), "bad user name or password") {
+		t.Errorf("got %v, want a refused connection", err)
+	}
+}
+
+func TestMQTTRemainingLength(t *testing.T) {
+	for _, n := range []int{0, 127, 128, 16383, 16384, 2097152} {
+		p := mqttPacket(mqttPublish<<4, make([]byte, n))
+		typ, _, body, err := readMQTTPacket(bufio.NewReader(strings.NewReader(string(p))))
+		if err != nil || typ != mqttPublish || len(body) != n {
+			t.Errorf("length %d: got type %d, %d bytes, %v", n, typ, len(body), err)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"riscv-dev/pkg/agent"
+	"riscv-dev/pkg/sensor"
+)
+
+// oneWireOptions are the options of a "onewire" driver: DS18B20
+// temperature probes on the kernel's 1-Wire bus (the w1-gpio and w1-therm
+// modules, with a w1-gpio overlay naming the data pin)
+type oneWireOptions struct {
+	// Dir is where the kernel lists 1-Wire devices
+	Dir     string          `json:"dir,omitempty"` // default /sys/bus/w1/devices
+	Sensors []oneWireSensor `json:"sensors"`
+}
+
+// oneWireSensor is a probe, by the ID it is listed under
+type oneWireSensor struct {
+	Name string `json:"name"`
+	// ID is the probe's family and serial number, e.g. 28-0316a27949ff
+	ID string `json:"id"`
+}
+
+// powerOnCelsius is what a DS18B20 holds before its first conversion; read
+// out of the blue it means the probe lost power and reset mid-read
+const powerOnCelsius = 85
+
+func init() {
+	agent.RegisterDriverType("onewire", newOneWireSensors)
+}
+
+func newOneWireSensors(options json.RawMessage) ([]agent.Sensor, error) {
+	var opts oneWireOptions
+	if err := json.Unmarshal(options, &opts); err != nil {
+		return nil, fmt.Errorf("onewire: %w", err)
+	}
+	if opts.Dir == "" {
+		opts.Dir = "/sys/bus/w1/devices"
+	}
+	if len(opts.Sensors) == 0 {
+		return nil, fmt.Errorf("onewire: no sensors; probes on the bus: %s", listProbes(opts.Dir))
+	}
+	sensors := make([]agent.Sensor, 0, len(opts.Sensors))
+	for _, s := range opts.Sensors {
+		if s.Name == "" || s.ID == "" {
+			return nil, fmt.Errorf("onewire: sensors need a name and an id; probes on the bus: %s", listProbes(opts.Dir))
+		}
+		sensors = append(sensors, &ds18b20{name: s.Name, id: s.ID, dir: filepath.Join(opts.Dir, s.ID), last: math.NaN()})
+	}
+	return sensors, nil
+}
+
+// listProbes returns the IDs of the temperature probes under dir, to help
+// fill in the config
+func listProbes(dir string) string {
+	var ids []string
+	for _, family := range []string{"10", "22", "28", "3b", "42"} {
+		found, _ := filepath.Glob(filepath.Join(dir, family+"-*"))
+		for _, path := range found {
+			ids = append(ids, filepath.Base(path))
+		}
+	}
+	if len(ids) == 0 {
+		return "none found in " + dir
+	}
+	return strings.Join(ids, ", ")
+}
+
+// ds18b20 reads a probe through w1-therm
+type ds18b20 struct {
+	name, id, dir string
+	last          float64 // the previous good value, NaN before one
+}
+
+func (s *ds18b20) Name() string { return s.name }
+func (s *ds18b20) Unit() string { return "°C" }
+
+func (s *ds18b20) Metadata() sensor.Metadata {
+	return sensor.Metadata{Model: "DS18B20", Serial: s.id}
+}
+
+// Read starts a conversion, which takes up to 750ms at 12 bits
+func (s *ds18b20) Read(ctx context.Context) (float64, sensor.Quality, error) {
+	milli, err := s.readMilli()
+	if err != nil {
+		return 0, sensor.Fault, err
+	}
+	v := float64(milli) / 1000
+	if v == powerOnCelsius && !(math.Abs(s.last-powerOnCelsius) < 5) {
+		return 0, sensor.Fault, fmt.Errorf("%s read the power-on value; check its power", s.id)
+	}
+	s.last = v
+	return v, sensor.OK, nil
+}
+
+// readMilli returns the temperature in thousandths of a degree, from the
+// temperature file of newer kernels or w1_slave
+func (s *ds18b20) readMilli() (int64, error) {
+	if b, err := os.ReadFile(filepath.Join(s.dir, "temperature")); err == nil {
+		return strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	}
+	b, err := os.ReadFile(filepath.Join(s.dir, "w1_slave"))
+	if err != nil {
+		return 0, err
+	}
+	return parseW1Slave(string(b))
+}
+
+// parseW1Slave parses w1_slave, the scratchpad with its CRC check and then
+// the temperature:
+//
+//	72 01 4b 46 7f ff 0e 10 57 : crc=57 YES
+//	72 01 4b 46 7f ff 0e 10 57 t=23125
+func parseW1Slave(text string) (int64, error) {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	if len(lines) != 2 {
+		return 0, fmt.Errorf("unexpected w1_slave %q", text)
+	}
+	if !strings.HasSuffix(strings.TrimSpace(lines[0]), "YES") {
+		return 0, fmt.Errorf("CRC check failed; check the wiring and the pull-up")
+	}
+	i := strings.LastIndex(lines[1], "t=")
+	if i < 0 {
+		return 0, fmt.Errorf("no temperature in %q", lines[1])
+	}
+	return strconv.ParseInt(strings.TrimSpace(lines[1][i+2:]), 10, 64)
+}
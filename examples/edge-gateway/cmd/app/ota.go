@@ -0,0 +1,418 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"riscv-dev/pkg/abupdate"
+	"riscv-dev/pkg/agent"
+	"riscv-dev/pkg/buildinfo"
+	"riscv-dev/pkg/config"
+	"riscv-dev/pkg/state"
+)
+
+// otaConfig updates the gateway's root filesystem with A/B slots (see
+// riscv-dev/pkg/abupdate): a new slot on trial is kept once the gateway
+// has been healthy on it for a while, and new images are fetched from a
+// manifest
+type otaConfig struct {
+	abupdate.Config
+	// Manifest is a URL polled for updates, answering
+	// {"version": "1.4.0", "url": "https://…/rootfs.img.gz", "sha256": "…"}.
+	// An update whose version differs from the running build's is
+	// installed, unless it was rolled back before.
+	Manifest string          `json:"manifest,omitempty"`
+	Interval config.Duration `json:"interval,omitempty"` // between manifest checks; default 6h
+	// Confirm is how long the gateway must stay healthy on a slot on
+	// trial before the slot is kept; default 2m
+	Confirm config.Duration `json:"confirm,omitempty"`
+	// Deadline is how long after startup a slot on trial may take to be
+	// confirmed before it is rolled back; default 15m
+	Deadline config.Duration `json:"deadline,omitempty"`
+	// Ignore are health checks that don't count against a slot on trial,
+	// as patterns, e.g. ["sink:*"] so an unreachable broker doesn't roll
+	// back a good update
+	Ignore []string `json:"ignore,omitempty"`
+	// Reboot reboots into an installed update, or out of a rolled back
+	// one, at once; otherwise the change waits for the next reboot
+	Reboot bool `json:"reboot,omitempty"`
+}
+
+// otaManifest describes the latest release
+type otaManifest struct {
+	Version string `json:"version"`
+	URL     string `json:"url"`
+	SHA256  string `json:"sha256"`
+}
+
+// Keys in the state file, shared with riscv-dev ota so its status counts
+// the gateway's updates too
+const (
+	otaAttempts   = "ota.attempts"
+	otaInstalled  = "ota.installed"
+	otaFailed     = "ota.failed"
+	otaRolledBack = "ota.rolled_back"
+	// otaVersion is the version of the last update installed, and
+	// otaBadVersion one that was rolled back and isn't to be installed
+	// again
+	otaVersion    = "gateway.ota_version"
+	otaBadVersion = "gateway.ota_bad_version"
+)
+
+// otaStatus is what the dashboard shows of updates
+type otaStatus struct {
+	Running   string     `json:"running,omitempty"` // slot
+	Trial     bool       `json:"trial"`
+	Available string     `json:"available,omitempty"` // version in the manifest, if newer
+	Installed string     `json:"installed,omitempty"` // version waiting for a reboot
+	Checked   *time.Time `json:"checked,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// otaUpdater confirms or rolls back the running slot and installs updates
+type otaUpdater struct {
+	cfg   otaConfig
+	u     *abupdate.Updater
+	agent *agent.Agent
+	store *state.Store // nil without a state file
+
+	mu     sync.Mutex
+	status otaStatus
+}
+
+func newOTAUpdater(cfg otaConfig, a *agent.Agent) (*otaUpdater, error) {
+	if cfg.Interval <= 0 {
+		cfg.Interval = config.Duration(6 * time.Hour)
+	}
+	if cfg.Confirm <= 0 {
+		cfg.Confirm = config.Duration(2 * time.Minute)
+	}
+	if cfg.Deadline <= 0 {
+		cfg.Deadline = config.Duration(15 * time.Minute)
+	}
+	for _, p := range cfg.Ignore {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("ota: ignore %q: %w", p, err)
+		}
+	}
+	u, err := abupdate.New(cfg.Config)
+	if err != nil {
+		return nil, err
+	}
+	o := &otaUpdater{cfg: cfg, u: u, agent: a}
+	if cfg.StateFile != "" {
+		if o.store, err = state.Open(cfg.StateFile); err != nil {
+			return nil, fmt.Errorf("ota: %w", err)
+		}
+	} else if cfg.Manifest != "" {
+		log.Printf("⚠️  OTA: without a state_file, an update that is rolled back is installed again at the next check")
+	}
+	return o, nil
+}
+
+// Status returns the state of updates, for the dashboard
+func (o *otaUpdater) Status() otaStatus {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.status
+}
+
+func (o *otaUpdater) setError(err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.status.Error = ""
+	if err != nil {
+		o.status.Error = err.Error()
+	}
+}
+
+// run settles a slot on trial, then checks the manifest every interval,
+// until ctx is cancelled
+func (o *otaUpdater) run(ctx context.Context) {
+	defer func() {
+		if o.store != nil {
+			o.store.Close()
+		}
+	}()
+	s, err := o.u.Status(ctx)
+	if err != nil {
+		log.Printf("❌ OTA: %v", err)
+		o.setError(err)
+		return
+	}
+	o.mu.Lock()
+	o.status.Running, o.status.Trial = s.Running, s.Trial && s.Running == s.Boot
+	o.mu.Unlock()
+	log.Printf("🔄 OTA: running slot %s, version %s", s.Running, buildinfo.Get().Version)
+
+	if s.Trial && s.Running == s.Boot {
+		if !o.settle(ctx) {
+			return
+		}
+	} else if err := o.u.Confirm(ctx); errors.Is(err, abupdate.ErrRolledBack) {
+		// The boot loader gave up on the update before we ran
+		log.Printf("⚠️  OTA: %v", err)
+		o.markBad()
+	}
+	if o.cfg.Manifest == "" {
+		return
+	}
+	t := time.NewTicker(o.cfg.Interval.D())
+	defer t.Stop()
+	for {
+		if err := o.check(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("❌ OTA: %v", err)
+			o.setError(err)
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// settle keeps the running slot once the gateway has been healthy for
+// Confirm, or rolls it back at Deadline. It returns false if the gateway
+// is to stop updating: rolled back, or stopped.
+func (o *otaUpdater) settle(ctx context.Context) bool {
+	log.Printf("🔄 OTA: slot on trial; keeping it once healthy for %v", o.cfg.Confirm.D())
+	deadline := time.Now().Add(o.cfg.Deadline.D())
+	var since time.Time // healthy since
+	var failing []string
+	t := time.NewTicker(5 * time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return false
+		}
+		failing = o.failing(ctx)
+		now := time.Now()
+		switch {
+		case len(failing) > 0:
+			since = time.Time{}
+		case since.IsZero():
+			since = now
+		case now.Sub(since) >= o.cfg.Confirm.D():
+			if err := o.u.Confirm(ctx); err != nil {
+				log.Printf("❌ OTA: confirming: %v", err)
+				o.setError(err)
+				return false
+			}
+			log.Printf("✅ OTA: slot kept after %v healthy", o.cfg.Confirm.D())
+			o.mu.Lock()
+			o.status.Trial = false
+			o.mu.Unlock()
+			return true
+		}
+		if now.After(deadline) {
+			break
+		}
+	}
+	why := strings.Join(failing, ", ")
+	if why == "" {
+		why = "not healthy for long enough"
+	}
+	log.Printf("❌ OTA: slot not confirmed after %v (%s); rolling back", o.cfg.Deadline.D(), why)
+	slot, err := o.u.Rollback(ctx)
+	if err != nil {
+		log.Printf("❌ OTA: rollback: %v", err)
+		o.setError(err)
+		return false
+	}
+	o.count(otaRolledBack)
+	o.markBad()
+	log.Printf("🛑 OTA: slot %s boots next", slot)
+	o.reboot()
+	return false
+}
+
+// failing returns the health checks failing that count against a slot on
+// trial
+func (o *otaUpdater) failing(ctx context.Context) []string {
+	var failing []string
+	for name, result := range o.agent.Health().Check(ctx).Checks {
+		if result == "ok" || o.ignored(name) {
+			continue
+		}
+		failing = append(failing, name+": "+result)
+	}
+	sort.Strings(failing)
+	return failing
+}
+
+func (o *otaUpdater) ignored(check string) bool {
+	for _, p := range o.cfg.Ignore {
+		if ok, _ := path.Match(p, check); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// check fetches the manifest and installs a new version
+func (o *otaUpdater) check(ctx context.Context) error {
+	m, err := fetchManifest(ctx, o.cfg.Manifest)
+	now := time.Now()
+	o.mu.Lock()
+	o.status.Checked = &now
+	o.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	running := buildinfo.Get().Version
+	if m.Version == "" || m.Version == running {
+		o.setError(nil)
+		return nil
+	}
+	o.mu.Lock()
+	o.status.Available = m.Version
+	installed := o.status.Installed
+	o.mu.Unlock()
+	switch {
+	case installed == m.Version:
+		return nil // waiting for a reboot
+	case o.get(otaBadVersion) == m.Version:
+		return fmt.Errorf("version %s was rolled back; not installing it again", m.Version)
+	}
+
+	log.Printf("🔄 OTA: installing version %s over %s from %s", m.Version, running, m.URL)
+	o.count(otaAttempts)
+	slot, err := o.install(ctx, m)
+	if err != nil {
+		o.count(otaFailed)
+		return fmt.Errorf("installing %s: %w", m.Version, err)
+	}
+	o.count(otaInstalled)
+	o.set(otaVersion, m.Version)
+	o.mu.Lock()
+	o.status.Installed = m.Version
+	o.mu.Unlock()
+	o.setError(nil)
+	log.Printf("✅ OTA: version %s written to slot %s; it boots on trial next", m.Version, slot)
+	o.reboot()
+	return nil
+}
+
+func (o *otaUpdater) install(ctx context.Context, m otaManifest) (string, error) {
+	if m.URL == "" || m.SHA256 == "" {
+		return "", fmt.Errorf("the manifest needs url and sha256")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.URL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", m.URL, resp.Status)
+	}
+	var image io.Reader = resp.Body
+	if strings.HasSuffix(m.URL, ".gz") {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return "", err
+		}
+		image = gz
+	}
+	last := time.Now()
+	return o.u.Install(ctx, image, m.SHA256, func(n int64) {
+		if time.Since(last) >= 30*time.Second {
+			log.Printf("📊 OTA: %d MB written", n>>20)
+			last = time.Now()
+		}
+	})
+}
+
+func fetchManifest(ctx context.Context, url string) (otaManifest, error) {
+	var m otaManifest
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return m, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return m, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return m, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&m); err != nil {
+		return m, fmt.Errorf("%s: %w", url, err)
+	}
+	return m, nil
+}
+
+// markBad records the version last installed as rolled back
+func (o *otaUpdater) markBad() {
+	if v := o.get(otaVersion); v != "" {
+		o.set(otaBadVersion, v)
+	}
+}
+
+// reboot reboots if configured to
+func (o *otaUpdater) reboot() {
+	if !o.cfg.Reboot {
+		return
+	}
+	log.Printf("🔄 OTA: rebooting")
+	if err := exec.Command("reboot").Run(); err != nil {
+		log.Printf("❌ OTA: reboot: %v", err)
+	}
+}
+
+// The state file is best effort, like riscv-dev ota's counts
+
+func (o *otaUpdater) get(key string) string {
+	if o.store == nil {
+		return ""
+	}
+	v, _ := o.store.Get(key)
+	return v
+}
+
+func (o *otaUpdater) set(key, value string) {
+	if o.store == nil {
+		return
+	}
+	err := o.store.Set(key, value)
+	if err == nil {
+		err = o.store.Flush()
+	}
+	if err != nil {
+		log.Printf("⚠️  OTA: saving %s: %v", key, err)
+	}
+}
+
+func (o *otaUpdater) count(key string) {
+	if o.store == nil {
+		return
+	}
+	_, err := o.store.Add(key, 1)
+	if err == nil {
+		err = o.store.Flush()
+	}
+	if err != nil {
+		log.Printf("⚠️  OTA: counting %s: %v", key, err)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseW1Slave(t *testing.T) {
+	milli, err := parseW1Slave("72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n72 01 4b 46 7f ff 0e 10 57 t=23125\n")
+	if err != nil || milli != 23125 {
+		t.Errorf("got %d, %v; want 23125", milli, err)
+	}
+	if _, err := parseW1Slave("ff ff ff ff ff ff ff ff ff : crc=c9 NO\nff ff ff ff ff ff ff ff ff t=-62\n"); err == nil {
+		t.Error("accepted a failed CRC")
+	}
+	if milli, err := parseW1Slave("5e ff 4b 46 7f ff 02 10 d9 : crc=d9 YES\n5e ff 4b 46 7f ff 02 10 d9 t=-10125\n"); err != nil || milli != -10125 {
+		t.Errorf("below zero: got %d, %v", milli, err)
+	}
+}
+
+func TestDS18B20PowerOn(t *testing.T) {
+	dir := t.TempDir()
+	write := func(milli string) {
+		if err := os.WriteFile(filepath.Join(dir, "temperature"), []byte(milli+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &ds18b20{name: "tank", id: "28-0316a27949ff", dir: dir, last: math.NaN()}
+	write("85000")
+	if _, _, err := s.Read(context.Background()); err == nil {
+		t.Error("took the power-on value on the first read")
+	}
+	write("84250")
+	if v, _, err := s.Read(context.Background()); err != nil || v != 84.25 {
+		t.Fatalf("got %g, %v", v, err)
+	}
+	// Rising through 85°C is a real value
+	write("85000")
+	if v, _, err := s.Read(context.Background()); err != nil || v != 85 {
+		t.Errorf("got %g, %v after 84.25", v, err)
+	}
+}
+
+func TestSHT3x(t *testing.T) {
+	// The data sheet's example: 0xBEEF has the CRC 0x92
+	if got := crc8([]byte{0xBE, 0xEF}); got != 0x92 {
+		t.Errorf("crc8(0xBEEF) = %#x, want 0x92", got)
+	}
+	tw, hw := []byte{0x66, 0x66}, []byte{0x80, 0x00}
+	b := []byte{tw[0], tw[1], crc8(tw), hw[0], hw[1], crc8(hw)}
+	celsius, rh, err := decodeSHT3x(b)
+	if err != nil || math.Abs(celsius-25) > 0.01 || math.Abs(rh-50) > 0.01 {
+		t.Errorf("got %.3f°C %.3f%%, %v; want 25°C 50%%", celsius, rh, err)
+	}
+	b[5] ^= 1
+	if _, _, err := decodeSHT3x(b); err == nil {
+		t.Error("accepted a bad CRC")
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"riscv-dev/pkg/agent"
+	"riscv-dev/pkg/hal"
+	"riscv-dev/pkg/sensor"
+)
+
+// sht3xOptions are the options of an "sht3x" driver: a Sensirion SHT30,
+// SHT31 or SHT35 temperature and humidity sensor on I2C, as two channels,
+// <name>_temperature and <name>_humidity
+type sht3xOptions struct {
+	Name    string `json:"name,omitempty"`    // default sht3x
+	Bus     string `json:"bus,omitempty"`     // default /dev/i2c-1
+	Address int    `json:"address,omitempty"` // default 0x44, 0x45 with ADDR high
+}
+
+const (
+	// sht3xMeasure starts a single-shot measurement at high repeatability,
+	// without clock stretching
+	sht3xMeasure = 0x2400
+	// sht3xDuration is the longest a high-repeatability measurement takes
+	sht3xDuration = 16 * time.Millisecond
+	// sht3xMaxAge is how long a measurement serves both channels, so a
+	// sample takes one
+	sht3xMaxAge = 500 * time.Millisecond
+)
+
+func init() {
+	agent.RegisterDriverType("sht3x", newSHT3xSensors)
+}
+
+func newSHT3xSensors(options json.RawMessage) ([]agent.Sensor, error) {
+	opts := sht3xOptions{Name: "sht3x", Bus: "/dev/i2c-1", Address: 0x44}
+	if err := json.Unmarshal(options, &opts); err != nil {
+		return nil, fmt.Errorf("sht3x: %w", err)
+	}
+	if opts.Address != 0x44 && opts.Address != 0x45 {
+		return nil, fmt.Errorf("sht3x: address %#x isn't 0x44 or 0x45", opts.Address)
+	}
+	d := &sht3x{opts: opts, refs: 2}
+	return []agent.Sensor{&sht3xChannel{dev: d, humidity: false}, &sht3xChannel{dev: d, humidity: true}}, nil
+}
+
+// sht3x is a device, opened on the first read so a missing bus faults the
+// channels rather than stopping the gateway
+type sht3x struct {
+	opts sht3xOptions
+
+	mu          sync.Mutex
+	bus         *hal.LinuxI2C
+	at          time.Time // when the measurement was taken
+	celsius, rh float64
+	refs        int
+}
+
+// measure returns a measurement at most sht3xMaxAge old
+func (d *sht3x) measure(ctx context.Context) (celsius, rh float64, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if time.Since(d.at) < sht3xMaxAge {
+		return d.celsius, d.rh, nil
+	}
+	if d.bus == nil {
+		if d.bus, err = hal.NewLinuxI2C(d.opts.Bus); err != nil {
+			return 0, 0, err
+		}
+	}
+	addr := byte(d.opts.Address)
+	if err := d.bus.Write(ctx, addr, []byte{sht3xMeasure >> 8, sht3xMeasure & 0xFF}); err != nil {
+		return 0, 0, err
+	}
+	select {
+	case <-time.After(sht3xDuration):
+	case <-ctx.Done():
+		return 0, 0, ctx.Err()
+	}
+	b, err := d.bus.Read(ctx, addr, 6)
+	if err != nil {
+		return 0, 0, err
+	}
+	if d.celsius, d.rh, err = decodeSHT3x(b); err != nil {
+		return 0, 0, err
+	}
+	d.at = time.Now()
+	return d.celsius, d.rh, nil
+}
+
+// decodeSHT3x converts a measurement, temperature and humidity words each
+// followed by its CRC
+func decodeSHT3x(b []byte) (celsius, rh float64, err error) {
+	if len(b) != 6 {
+		return 0, 0, fmt.Errorf("sht3x: got %d bytes, want 6", len(b))
+	}
+	if crc8(b[0:2]) != b[2] || crc8(b[3:5]) != b[5] {
+		return 0, 0, fmt.Errorf("sht3x: CRC mismatch")
+	}
+	t := float64(uint16(b[0])<<8 | uint16(b[1]))
+	h := float64(uint16(b[3])<<8 | uint16(b[4]))
+	return -45 + 175*t/65535, 100 * h / 65535, nil
+}
+
+// crc8 is Sensirion's CRC: polynomial 0x31, initial value 0xFF
+func crc8(data []byte) byte {
+	crc := byte(0xFF)
+	for _, b := range data {
+		crc ^= b
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x31
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func (d *sht3x) release() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.refs--; d.refs > 0 || d.bus == nil {
+		return nil
+	}
+	return d.bus.Close()
+}
+
+// sht3xChannel is the temperature or the humidity of a device
+type sht3xChannel struct {
+	dev      *sht3x
+	humidity bool
+	closed   bool
+}
+
+func (c *sht3xChannel) Name() string {
+	if c.humidity {
+		return c.dev.opts.Name + "_humidity"
+	}
+	return c.dev.opts.Name + "_temperature"
+}
+
+func (c *sht3xChannel) Unit() string {
+	if c.humidity {
+		return "%RH"
+	}
+	return "°C"
+}
+
+func (c *sht3xChannel) Metadata() sensor.Metadata {
+	return sensor.Metadata{Model: "SHT3x"}
+}
+
+func (c *sht3xChannel) Read(ctx context.Context) (float64, sensor.Quality, error) {
+	celsius, rh, err := c.dev.measure(ctx)
+	if err != nil {
+		return 0, sensor.Fault, err
+	}
+	if c.humidity {
+		return rh, sensor.OK, nil
+	}
+	return celsius, sensor.OK, nil
+}
+
+func (c *sht3xChannel) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.dev.release()
+}
@@ -0,0 +1,9 @@
+module riscv-edge-gateway
+
+go 1.21
+
+require riscv-dev v0.0.0
+
+// Shared agent, HAL and update packages from this repository. No external
+// dependencies: the YAML, Modbus, MQTT and InfluxDB support is our own.
+replace riscv-dev => ../..
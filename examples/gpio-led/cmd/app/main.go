@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"log"
 	"os"
-	"os/signal"
-	"syscall"
 	"time"
+
+	"riscv-dev/pkg/buildinfo"
+	"riscv-dev/pkg/hal"
+	"riscv-dev/pkg/safestate"
+	"riscv-dev/pkg/sim"
 )
 
 const (
@@ -14,64 +20,73 @@ const (
 
 	// Blink interval
 	BLINK_INTERVAL = 500 * time.Millisecond
+
+	// Upper bound for a single GPIO operation
+	GPIO_TIMEOUT = 100 * time.Millisecond
 )
 
-// SimulatedGPIO simulates GPIO operations for demonstration
-type SimulatedGPIO struct {
-	pins map[int]bool // pin number -> state
-}
+func main() {
+	// A panic turns the LED off before the program dies
+	defer safestate.Recover()
 
-func NewSimulatedGPIO() *SimulatedGPIO {
-	return &SimulatedGPIO{
-		pins: make(map[int]bool),
-	}
-}
+	driver := flag.String("driver", hal.Auto, "GPIO backend: auto, gpiochip, sysfs or sim")
+	chip := flag.String("chip", "", "GPIO chip for the gpiochip backend (default /dev/gpiochip0)")
+	stateFile := flag.String("state", "gpio-state.json", "where the safe GPIO state is kept while running")
+	buildinfo.RegisterFlag(flag.CommandLine)
+	flag.Parse()
 
-func (gpio *SimulatedGPIO) Output(pin int) {
-	// In simulation, just initialize the pin
-	gpio.pins[pin] = false
-}
+	fmt.Println("🚀 RISC-V GPIO LED Example")
+	fmt.Printf("Board: %s\n", getBoardInfo())
+	fmt.Printf("LED Pin: GPIO%d\n", LED_PIN)
 
-func (gpio *SimulatedGPIO) Toggle(pin int) {
-	if state, exists := gpio.pins[pin]; exists {
-		gpio.pins[pin] = !state
-	} else {
-		gpio.pins[pin] = true
+	// Open the best available GPIO backend (falls back to simulation)
+	gpio, err := hal.NewGPIOController(hal.GPIOConfig{Driver: *driver, Chip: *chip})
+	if err != nil {
+		log.Fatalf("❌ Failed to open GPIO: %v", err)
 	}
-}
-
-func (gpio *SimulatedGPIO) Low(pin int) {
-	gpio.pins[pin] = false
-}
+	defer gpio.Close()
 
-func (gpio *SimulatedGPIO) Read(pin int) bool {
-	return gpio.pins[pin]
-}
+	if _, simulated := gpio.(*sim.GPIO); simulated {
+		fmt.Println("⚠️  Running in simulation mode (no physical GPIO access)")
+	}
 
-func (gpio *SimulatedGPIO) GetState(pin int) string {
-	if gpio.pins[pin] {
-		return "HIGH"
+	// A state file left behind means the last run didn't shut down
+	// cleanly, and its lines may have been left driven
+	if snap, err := hal.LoadGPIOSnapshot(*stateFile); err == nil {
+		fmt.Printf("⚠️  Previous run did not shut down cleanly, restoring its safe state from %s\n", *stateFile)
+		if err := restoreGPIO(gpio, snap); err != nil {
+			log.Printf("❌ Failed to restore GPIO state: %v", err)
+		}
 	}
-	return "LOW"
-}
 
-func main() {
-	fmt.Println("🚀 RISC-V GPIO LED Example")
-	fmt.Printf("Board: %s\n", getBoardInfo())
-	fmt.Printf("LED Pin: GPIO%d\n", LED_PIN)
-	fmt.Println("⚠️  Running in simulation mode (no physical GPIO access)")
+	if err := gpio.SetMode(LED_PIN, hal.Output); err != nil {
+		log.Fatalf("❌ Failed to configure GPIO%d as output: %v", LED_PIN, err)
+	}
+	if err := writePin(gpio, LED_PIN, false); err != nil {
+		log.Fatalf("❌ Failed to write GPIO%d: %v", LED_PIN, err)
+	}
 
-	// Initialize simulated GPIO
-	gpio := NewSimulatedGPIO()
-	gpio.Output(LED_PIN)
+	// Remember the safe state (LED off) to return to on shutdown, or on
+	// the next start after a crash
+	safe, err := hal.SnapshotGPIO(context.Background(), gpio)
+	if err != nil {
+		log.Fatalf("❌ Failed to snapshot GPIO state: %v", err)
+	}
+	if err := hal.SaveGPIOSnapshot(*stateFile, safe); err != nil {
+		log.Printf("⚠️  Failed to save GPIO state: %v", err)
+	}
+	safestate.Register("LED off", func(ctx context.Context) error {
+		return hal.RestoreGPIO(ctx, gpio, safe)
+	})
 
-	fmt.Println("✅ GPIO simulation initialized successfully")
+	fmt.Println("✅ GPIO initialized successfully")
 	fmt.Printf("🎯 Starting LED blink pattern (interval: %v)\n", BLINK_INTERVAL)
 
-	// Handle graceful shutdown
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	// Handle graceful shutdown; the LED is already off when ctx is done
+	ctx, stop := safestate.NotifyContext(context.Background())
+	defer stop()
 
+	ledOn := false
 	blinkCount := 0
 	ticker := time.NewTicker(BLINK_INTERVAL)
 	defer ticker.Stop()
@@ -80,23 +95,55 @@ func main() {
 		select {
 		case <-ticker.C:
 			// Toggle LED state
-			gpio.Toggle(LED_PIN)
+			if err := writePin(gpio, LED_PIN, !ledOn); err != nil {
+				log.Printf("❌ Failed to write GPIO%d: %v", LED_PIN, err)
+				continue
+			}
+			ledOn = !ledOn
 			blinkCount++
 
-			state := gpio.GetState(LED_PIN)
-
-			fmt.Printf("💡 LED %s (blink #%d)\n", state, blinkCount)
+			fmt.Printf("💡 LED %s (blink #%d)\n", getState(gpio, LED_PIN), blinkCount)
 
-		case <-sigChan:
+		case <-ctx.Done():
+			stop()
 			fmt.Println("\n🛑 Shutting down gracefully...")
 			// Ensure LED is off when exiting
-			gpio.Low(LED_PIN)
-			fmt.Printf("✅ LED turned off (final state: %s)\n", gpio.GetState(LED_PIN))
+			if err := restoreGPIO(gpio, safe); err != nil {
+				log.Printf("❌ Failed to restore GPIO state: %v", err)
+				return
+			}
+			os.Remove(*stateFile)
+			fmt.Printf("✅ LED turned off (final state: %s)\n", getState(gpio, LED_PIN))
 			return
 		}
 	}
 }
 
+// writePin drives a pin, giving up after GPIO_TIMEOUT
+func writePin(gpio hal.GPIOController, pin int, value bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), GPIO_TIMEOUT)
+	defer cancel()
+	return gpio.Write(ctx, pin, value)
+}
+
+// restoreGPIO returns the lines to a snapshot, giving up after GPIO_TIMEOUT
+// per line
+func restoreGPIO(gpio hal.GPIOController, snap hal.GPIOSnapshot) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(len(snap.Lines)+1)*GPIO_TIMEOUT)
+	defer cancel()
+	return hal.RestoreGPIO(ctx, gpio, snap)
+}
+
+// getState reads a pin back as "HIGH" or "LOW"
+func getState(gpio hal.GPIOController, pin int) string {
+	ctx, cancel := context.WithTimeout(context.Background(), GPIO_TIMEOUT)
+	defer cancel()
+	if value, err := gpio.Read(ctx, pin); err == nil && value {
+		return "HIGH"
+	}
+	return "LOW"
+}
+
 // getBoardInfo attempts to identify the RISC-V board
 func getBoardInfo() string {
 	// Read board information from common locations
@@ -2,4 +2,8 @@ module riscv-gpio-led
 
 go 1.21
 
-// No external dependencies - uses GPIO simulation for portability
+require riscv-dev v0.0.0
+
+// Shared HAL from this repository (falls back to GPIO simulation when no
+// hardware backend is available). No external dependencies.
+replace riscv-dev => ../..
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io"
+	"net"
+	"time"
+)
+
+// maxBatch bounds how many messages go out in one write, well under the
+// 1024 buffers a writev takes
+const maxBatch = 256
+
+// buffersWriter writes several buffers in one go, which the connection
+// types between a client and its socket pass down
+type buffersWriter interface {
+	WriteBuffers(bufs net.Buffers) (int64, error)
+}
+
+// writeBuffers writes bufs to w in one go where w allows: a single
+// writev on a TCP connection, else a single write of them joined
+func writeBuffers(w io.Writer, bufs net.Buffers) (int64, error) {
+	switch w := w.(type) {
+	case buffersWriter:
+		return w.WriteBuffers(bufs)
+	case *net.TCPConn:
+		return bufs.WriteTo(w)
+	}
+	var joined []byte
+	for _, b := range bufs {
+		joined = append(joined, b...)
+	}
+	n, err := w.Write(joined)
+	return int64(n), err
+}
+
+// sendBuffers writes bufs to the client at once, ignoring errors as send
+// does
+func (c *client) sendBuffers(bufs net.Buffers) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeBuffers(c.conn, bufs)
+}
+
+// gather returns first with the messages queued behind it, waiting up to
+// the flush interval for more, so that a burst reaches each client in a
+// single write
+func (s *Server) gather(first message) []message {
+	batch := []message{first}
+	var timeout <-chan time.Time
+	for len(batch) < maxBatch {
+		select {
+		case m, ok := <-s.messages:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, m)
+			continue
+		default:
+		}
+		if s.opts.FlushInterval <= 0 {
+			return batch
+		}
+		if timeout == nil {
+			t := time.NewTimer(s.opts.FlushInterval)
+			defer t.Stop()
+			timeout = t.C
+		}
+		select {
+		case m, ok := <-s.messages:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, m)
+		case <-timeout:
+			return batch
+		}
+	}
+	return batch
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+)
+
+// Command is a parsed client command
+type Command struct {
+	Name string   // lower case, without the leading slash
+	Args []string // whitespace-separated arguments
+	Rest string   // everything after the command name, trimmed
+}
+
+// legacyCommands are recognised without a slash, as in earlier versions
+var legacyCommands = map[string]bool{"help": true, "time": true, "clients": true, "quit": true}
+
+// ParseCommand parses a line of client input. Commands start with a slash
+// ("/join garden"); the original one-word commands also work without it.
+// Anything else is a chat message and ok is false.
+func ParseCommand(line string) (cmd Command, ok bool) {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "/") {
+		line = line[1:]
+	} else if !legacyCommands[strings.ToLower(line)] {
+		return Command{}, false
+	}
+
+	name, rest, _ := strings.Cut(line, " ")
+	if name == "" {
+		return Command{}, false
+	}
+	return Command{
+		Name: strings.ToLower(name),
+		Args: strings.Fields(rest),
+		Rest: strings.TrimSpace(rest),
+	}, true
+}
+
+// validName reports whether a nickname or room name is acceptable: 1 to 24
+// letters, digits, '-' or '_'
+func validName(name string) bool {
+	if len(name) == 0 || len(name) > 24 {
+		return false
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// FuzzParseCommand feeds arbitrary client lines to the command parser,
+// which sees every line a connected client types
+func FuzzParseCommand(f *testing.F) {
+	for _, seed := range []string{
+		"", "/", "//", " / ", "hello", "help", "HELP", " quit ", "clients",
+		"/join garden", "/JOIN   garden  ", "/topic  Plants & things ",
+		"/register hunter2", "/ban mallory 10m spam", "/color off",
+		"/\t", "/join\tgarden", "/é", "\xff/join", "/join \xff\xfe",
+		"/" + strings.Repeat("a", 600),
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, line string) {
+		cmd, ok := ParseCommand(line)
+		if !ok {
+			if !reflect.DeepEqual(cmd, Command{}) {
+				t.Fatalf("ParseCommand(%q) = %+v with ok false", line, cmd)
+			}
+			return
+		}
+		if cmd.Name == "" || strings.Contains(cmd.Name, " ") {
+			t.Fatalf("ParseCommand(%q): bad name %q", line, cmd.Name)
+		}
+		if cmd.Rest != strings.TrimSpace(cmd.Rest) {
+			t.Fatalf("ParseCommand(%q): Rest %q not trimmed", line, cmd.Rest)
+		}
+		if fields := strings.Fields(cmd.Rest); len(fields) != len(cmd.Args) {
+			t.Fatalf("ParseCommand(%q): Args %q don't match Rest %q", line, cmd.Args, cmd.Rest)
+		}
+		if !strings.Contains(strings.ToLower(line), cmd.Name) {
+			t.Fatalf("ParseCommand(%q): name %q not in input", line, cmd.Name)
+		}
+	})
+}
+
+// FuzzValidName checks that anything validName accepts is safe to use as
+// a nickname, room name and store key
+func FuzzValidName(f *testing.F) {
+	for _, seed := range []string{"", "alice", "Bob_2", "a-b", "a b", "ålice", "x\x00", strings.Repeat("n", 25)} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, name string) {
+		if !validName(name) {
+			return
+		}
+		if len(name) > 24 || sanitize(name) != name || strings.ContainsAny(name, " /:\t\r\n") {
+			t.Fatalf("validName accepted %q", name)
+		}
+	})
+}
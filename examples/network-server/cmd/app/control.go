@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"riscv-dev/pkg/buildinfo"
+	"riscv-network-server/internal/control"
+)
+
+// serveControl listens on the Unix control socket at path until the
+// returned listener is closed
+func (s *Server) serveControl(path string) (net.Listener, error) {
+	// A socket left behind by a crashed server blocks Listen; remove it
+	// unless another server still answers on it
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another server", path)
+		}
+		os.Remove(path)
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// Owner and group only: the socket can shut the server down
+	if err := os.Chmod(path, 0660); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if err != nil {
+				log.Printf("❌ Control socket: %v", err)
+				continue
+			}
+			go s.handleControl(conn)
+		}
+	}()
+	return l, nil
+}
+
+func (s *Server) handleControl(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	enc := json.NewEncoder(conn)
+	for scanner.Scan() {
+		var req control.Request
+		resp := control.Response{OK: true}
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			resp = control.Response{Error: "invalid request: " + err.Error()}
+		} else if err := s.runControl(req, &resp); err != nil {
+			resp = control.Response{Error: err.Error()}
+		}
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) runControl(req control.Request, resp *control.Response) error {
+	switch req.Command {
+	case control.CmdStatus:
+		resp.Status = s.status()
+	case control.CmdBroadcast:
+		if req.Text == "" {
+			return fmt.Errorf("broadcast needs text")
+		}
+		if req.Room != "" {
+			if _, ok := s.store.Room(req.Room); !ok {
+				return fmt.Errorf("no room %s", req.Room)
+			}
+		}
+		s.event("📣 Control socket broadcast: %s", req.Text)
+		s.messages <- systemMessage(req.Room, req.Text)
+	case control.CmdShutdown:
+		s.event("🛑 Shutdown requested on the control socket")
+		s.Shutdown()
+	case control.CmdUpgrade:
+		s.event("♻️  Upgrade requested on the control socket")
+		return s.Upgrade()
+	default:
+		return fmt.Errorf("unknown command %q", req.Command)
+	}
+	return nil
+}
+
+func (s *Server) status() *control.Status {
+	st := &control.Status{
+		Listen:   s.addr,
+		Started:  s.started,
+		Messages: s.delivered.Load(),
+		Rooms:    len(s.store.Rooms()),
+		Clients:  []control.Client{},
+		Runtime:  s.runtime(),
+		Sent:     s.sent.Load(),
+		Writes:   s.writes.Load(),
+		Build:    buildinfo.Get(),
+	}
+	for _, c := range s.snapshot() {
+		st.Clients = append(st.Clients, control.Client{
+			Name:  c.name,
+			Addr:  c.addr,
+			Room:  c.currentRoom(),
+			Op:    c.isOp(),
+			Since: c.since,
+		})
+	}
+	return st
+}
+
+// runtime measures the server's memory and CPU use
+func (s *Server) runtime() control.Runtime {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	rt := control.Runtime{
+		Mode:       s.mode(),
+		Goroutines: runtime.NumGoroutine(),
+		Heap:       ms.HeapInuse,
+		Stacks:     ms.StackInuse,
+	}
+	// The second field of statm is resident pages
+	if b, err := os.ReadFile("/proc/self/statm"); err == nil {
+		if f := strings.Fields(string(b)); len(f) > 1 {
+			pages, _ := strconv.ParseUint(f[1], 10, 64)
+			rt.Resident = pages * uint64(os.Getpagesize())
+		}
+	}
+	var ru syscall.Rusage
+	if syscall.Getrusage(syscall.RUSAGE_SELF, &ru) == nil {
+		rt.CPU = time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+	}
+	return rt
+}
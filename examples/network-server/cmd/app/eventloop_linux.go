@@ -0,0 +1,388 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// maxQueued is how much output a client in the event loop may leave
+// unread before it is disconnected, in place of the goroutine mode's
+// write timeout
+const maxQueued = 64 << 10
+
+// eventLoop serves logged-in clients from one goroutine, with epoll and
+// non-blocking sockets, so thousands of idle clients cost a socket and a
+// small buffer each rather than a goroutine blocked in Read. Logins still
+// run in a goroutine of their own, then hand the connection over.
+//
+// Writes never wait: they go straight to the socket, and what it won't
+// take yet is queued and flushed when epoll reports it writable. A client
+// that lets maxQueued build up is disconnected, so the broadcaster is
+// never held up by a slow reader.
+type eventLoop struct {
+	s    *Server
+	epfd int
+
+	mu    sync.Mutex
+	conns map[int]*loopConn // by file descriptor
+
+	stopping atomic.Bool // set by release
+	stopped  chan struct{}
+}
+
+func newEventLoop(s *Server) (*eventLoop, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("epoll: %w", err)
+	}
+	return &eventLoop{s: s, epfd: epfd, conns: make(map[int]*loopConn), stopped: make(chan struct{})}, nil
+}
+
+// loopConn is a client connection owned by the event loop. Writes may
+// come from any goroutine; everything else happens on the loop's.
+type loopConn struct {
+	fd     int
+	loop   *eventLoop
+	c      *client
+	tc     *telnetConn // nil without telnet negotiation
+	input  *lineReader
+	local  net.Addr
+	remote net.Addr
+
+	// idle tracking, only touched by the loop
+	lastRead time.Time
+	warned   bool
+
+	wmu        sync.Mutex
+	out        []byte // written but not yet taken by the socket
+	closed     bool   // Close was called or the loop let go of fd
+	registered bool   // fd is in the epoll set
+	writable   bool   // waiting for the socket to take out
+}
+
+// adopt takes over c's connection from the goroutine that logged it in,
+// which returns if it succeeds. raw is the accepted connection, tc the
+// telnet layer over it if any, and input what has been read from it.
+func (l *eventLoop) adopt(c *client, raw net.Conn, tc *telnetConn, input *lineReader) bool {
+	fd, err := dupFD(raw)
+	if err != nil {
+		log.Printf("⚠️  Event loop: %s (%s) stays on its own goroutine: %v", c.name, c.addr, err)
+		return false
+	}
+	lc := &loopConn{fd: fd, loop: l, c: c, tc: tc, input: input, local: raw.LocalAddr(), remote: c.conn.RemoteAddr(), lastRead: time.Now()}
+
+	// Writes go to lc from here on; the old chain of connections is
+	// dropped along with its goroutine
+	var conn net.Conn = lc
+	if tc != nil {
+		tc.setConn(lc)
+		conn = tc
+	}
+	l.s.mu.Lock()
+	c.mu.Lock()
+	delete(l.s.clients, c.conn)
+	c.conn = conn
+	l.s.clients[conn] = c
+	c.mu.Unlock()
+	l.s.mu.Unlock()
+	raw.Close()
+
+	l.mu.Lock()
+	l.conns[fd] = lc
+	l.mu.Unlock()
+	lc.wmu.Lock()
+	err = lc.watch(syscall.EPOLL_CTL_ADD, len(lc.out) > 0)
+	lc.registered = err == nil
+	lc.wmu.Unlock()
+	if err != nil {
+		l.finish(lc, fmt.Errorf("event loop: %w", err))
+	}
+	return true
+}
+
+// run waits for sockets to become readable or writable, and once a
+// second checks for idle clients
+func (l *eventLoop) run() {
+	defer close(l.stopped)
+	events := make([]syscall.EpollEvent, 128)
+	buf := make([]byte, 4096)
+	lastCheck := time.Now()
+	for !l.stopping.Load() {
+		n, err := syscall.EpollWait(l.epfd, events, 1000)
+		if err != nil && !errors.Is(err, syscall.EINTR) {
+			log.Printf("❌ Event loop stopped: %v", err)
+			return
+		}
+		for _, ev := range events[:max(n, 0)] {
+			l.mu.Lock()
+			lc := l.conns[int(ev.Fd)]
+			l.mu.Unlock()
+			if lc == nil {
+				continue
+			}
+			if ev.Events&syscall.EPOLLOUT != 0 {
+				lc.flush()
+			}
+			if ev.Events&(syscall.EPOLLIN|syscall.EPOLLRDHUP|syscall.EPOLLHUP|syscall.EPOLLERR) != 0 {
+				l.read(lc, buf)
+			}
+		}
+		if time.Since(lastCheck) >= time.Second {
+			lastCheck = time.Now()
+			l.checkIdle()
+		}
+	}
+}
+
+// release stops the loop, within a second, and returns its clients for an
+// upgrade. Writes to them still work, queueing what the sockets won't
+// take.
+func (l *eventLoop) release() []handoff {
+	l.stopping.Store(true)
+	<-l.stopped
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	handoffs := make([]handoff, 0, len(l.conns))
+	for _, lc := range l.conns {
+		handoffs = append(handoffs, handoff{c: lc.c, lc: lc, tc: lc.tc, input: lc.input})
+	}
+	return handoffs
+}
+
+// read handles what lc's client sent, using buf to read it
+func (l *eventLoop) read(lc *loopConn, buf []byte) {
+	n, err := syscall.Read(lc.fd, buf)
+	if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR) {
+		return
+	}
+	if err != nil {
+		l.finish(lc, err)
+		return
+	}
+	data := buf[:n]
+	if lc.tc != nil {
+		data = lc.tc.filter(data)
+	}
+	if len(data) > 0 {
+		lc.lastRead, lc.warned = time.Now(), false
+		lc.input.Feed(data)
+	}
+	for {
+		line, truncated, ok := lc.input.Line(n == 0)
+		if !ok {
+			break
+		}
+		if !l.s.handleLine(lc.c, line, truncated) {
+			l.finish(lc, nil)
+			return
+		}
+	}
+	if n == 0 {
+		l.finish(lc, nil)
+	}
+}
+
+// checkIdle warns and then disconnects clients silent for too long, as
+// timeoutConn does for clients on goroutines
+func (l *eventLoop) checkIdle() {
+	timeout := l.s.opts.IdleTimeout
+	if timeout <= 0 {
+		return
+	}
+	warning := warnBefore(timeout, l.s.opts.IdleWarning)
+	l.mu.Lock()
+	conns := make([]*loopConn, 0, len(l.conns))
+	for _, lc := range l.conns {
+		conns = append(conns, lc)
+	}
+	l.mu.Unlock()
+	for _, lc := range conns {
+		switch idle := time.Since(lc.lastRead); {
+		case idle >= timeout:
+			lc.c.send("%s", idleGoodbye(timeout))
+			lc.c.mu.Lock()
+			lc.c.gone = errIdle.Error()
+			lc.c.mu.Unlock()
+			lc.Close()
+		case idle >= timeout-warning && !lc.warned:
+			lc.warned = true
+			lc.c.send("%s", idleWarning(timeout, warning))
+		}
+	}
+}
+
+// finish lets go of lc once its client is gone or should be, as the
+// goroutine serving it would have on returning
+func (l *eventLoop) finish(lc *loopConn, err error) {
+	lc.wmu.Lock()
+	lc.closeLocked()
+	l.mu.Lock()
+	delete(l.conns, lc.fd)
+	l.mu.Unlock()
+	syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_DEL, lc.fd, nil)
+	syscall.Close(lc.fd)
+	lc.wmu.Unlock()
+
+	if err != nil {
+		log.Printf("❌ %s (%s): %v", lc.c.name, lc.c.addr, err)
+	}
+	l.s.remove(lc.c, err)
+}
+
+// watch sets the events epoll reports for lc, with or without its
+// becoming writable. The caller holds wmu.
+func (lc *loopConn) watch(op int, writable bool) error {
+	ev := syscall.EpollEvent{Events: syscall.EPOLLIN | syscall.EPOLLRDHUP, Fd: int32(lc.fd)}
+	if writable {
+		ev.Events |= syscall.EPOLLOUT
+	}
+	lc.writable = writable
+	return syscall.EpollCtl(lc.loop.epfd, op, lc.fd, &ev)
+}
+
+// Write sends p without waiting, queueing what the socket won't take yet
+func (lc *loopConn) Write(p []byte) (int, error) {
+	n, err := lc.WriteBuffers(net.Buffers{p})
+	return int(n), err
+}
+
+// WriteBuffers sends bufs in a single writev, queueing what the socket
+// won't take yet
+func (lc *loopConn) WriteBuffers(bufs net.Buffers) (int64, error) {
+	lc.wmu.Lock()
+	defer lc.wmu.Unlock()
+	if lc.closed {
+		return 0, net.ErrClosed
+	}
+	var n int64
+	for _, b := range bufs {
+		n += int64(len(b))
+	}
+	written := 0
+	if len(lc.out) == 0 {
+		var err error
+		if written, err = writev(lc.fd, bufs); err != nil {
+			lc.closeLocked()
+			return int64(written), err
+		}
+		if int64(written) == n {
+			return n, nil
+		}
+	}
+	if len(lc.out)+int(n)-written > maxQueued {
+		log.Printf("⚠️  %s (%s) stopped reading; disconnecting with %d bytes unsent", lc.c.name, lc.c.addr, len(lc.out)+int(n)-written)
+		lc.closeLocked()
+		return 0, errors.New("output queue full")
+	}
+	for _, b := range bufs {
+		skip := min(written, len(b))
+		lc.out = append(lc.out, b[skip:]...)
+		written -= skip
+	}
+	if lc.registered && !lc.writable {
+		lc.watch(syscall.EPOLL_CTL_MOD, true)
+	}
+	return n, nil
+}
+
+// flush sends queued output once the socket is writable
+func (lc *loopConn) flush() {
+	lc.wmu.Lock()
+	defer lc.wmu.Unlock()
+	if lc.closed {
+		return
+	}
+	written, err := write(lc.fd, lc.out)
+	if err != nil {
+		lc.closeLocked()
+		return
+	}
+	lc.out = lc.out[:copy(lc.out, lc.out[written:])]
+	if len(lc.out) == 0 {
+		lc.out = nil // don't keep a burst's buffer for an idle client
+		lc.watch(syscall.EPOLL_CTL_MOD, false)
+	}
+}
+
+// write writes as much of p to fd as it takes without blocking
+func write(fd int, p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		n, err := syscall.Write(fd, p[written:])
+		switch {
+		case errors.Is(err, syscall.EINTR):
+			continue
+		case errors.Is(err, syscall.EAGAIN):
+			return written, nil
+		case err != nil:
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// writev writes as much of bufs to fd as it takes in one call without
+// blocking
+func writev(fd int, bufs [][]byte) (int, error) {
+	iov := make([]syscall.Iovec, 0, len(bufs))
+	for _, b := range bufs {
+		if len(b) > 0 {
+			v := syscall.Iovec{Base: &b[0]}
+			v.SetLen(len(b))
+			iov = append(iov, v)
+		}
+	}
+	if len(iov) == 0 {
+		return 0, nil
+	}
+	for {
+		n, _, errno := syscall.Syscall(syscall.SYS_WRITEV, uintptr(fd), uintptr(unsafe.Pointer(&iov[0])), uintptr(len(iov)))
+		switch errno {
+		case 0:
+			return int(n), nil
+		case syscall.EINTR:
+			continue
+		case syscall.EAGAIN:
+			return 0, nil
+		}
+		return 0, errno
+	}
+}
+
+// Close disconnects the client: the loop sees the socket shut down and
+// finishes with it
+func (lc *loopConn) Close() error {
+	lc.wmu.Lock()
+	defer lc.wmu.Unlock()
+	lc.closeLocked()
+	return nil
+}
+
+func (lc *loopConn) closeLocked() {
+	if lc.closed {
+		return
+	}
+	lc.closed = true
+	write(lc.fd, lc.out) // a last try, e.g. for a goodbye
+	lc.out = nil
+	syscall.Shutdown(lc.fd, syscall.SHUT_RDWR)
+}
+
+// Read is never called: the loop reads the socket itself
+func (lc *loopConn) Read(p []byte) (int, error) {
+	return 0, errors.New("read by the event loop")
+}
+
+func (lc *loopConn) LocalAddr() net.Addr                { return lc.local }
+func (lc *loopConn) RemoteAddr() net.Addr               { return lc.remote }
+func (lc *loopConn) SetDeadline(t time.Time) error      { return nil }
+func (lc *loopConn) SetReadDeadline(t time.Time) error  { return nil }
+func (lc *loopConn) SetWriteDeadline(t time.Time) error { return nil }
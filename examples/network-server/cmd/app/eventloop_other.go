@@ -0,0 +1,33 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+// eventLoop needs epoll, so off Linux -event-loop fails to start and
+// clients are always served by a goroutine each
+type eventLoop struct{}
+
+// loopConn is never created without an event loop
+type loopConn struct {
+	fd     int
+	wmu    sync.Mutex
+	out    []byte
+	closed bool
+}
+
+func newEventLoop(s *Server) (*eventLoop, error) {
+	return nil, errors.New("epoll is only available on Linux")
+}
+
+func (l *eventLoop) adopt(c *client, raw net.Conn, tc *telnetConn, input *lineReader) bool {
+	return false
+}
+
+func (l *eventLoop) run() {}
+
+func (l *eventLoop) release() []handoff { return nil }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// errIdle ends a connection that stayed silent past the idle timeout
+var errIdle = errors.New("idle timeout")
+
+// writeTimeout bounds every write, so a client that stopped reading
+// cannot stall the broadcaster
+const writeTimeout = 10 * time.Second
+
+// timeoutConn bounds every write by writeTimeout and disconnects clients
+// that send nothing for the idle timeout, warning them shortly before.
+// Once stop is set, a read deadline ends reading with errUpgrade instead.
+type timeoutConn struct {
+	net.Conn
+	timeout time.Duration // 0 never disconnects idle clients
+	warning time.Duration // how long before the timeout to warn
+	warned  bool          // only touched by Read
+	stop    *atomic.Bool
+}
+
+func newTimeoutConn(conn net.Conn, timeout, warning time.Duration, stop *atomic.Bool) *timeoutConn {
+	return &timeoutConn{Conn: conn, timeout: timeout, warning: warnBefore(timeout, warning), stop: stop}
+}
+
+// warnBefore returns how long before timeout to warn an idle client
+func warnBefore(timeout, warning time.Duration) time.Duration {
+	if warning <= 0 || warning >= timeout {
+		return timeout / 10
+	}
+	return warning
+}
+
+func (c *timeoutConn) Write(p []byte) (int, error) {
+	c.Conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	defer c.Conn.SetWriteDeadline(time.Time{})
+	return c.Conn.Write(p)
+}
+
+// WriteBuffers writes bufs in one go, bounded like Write
+func (c *timeoutConn) WriteBuffers(bufs net.Buffers) (int64, error) {
+	c.Conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	defer c.Conn.SetWriteDeadline(time.Time{})
+	return writeBuffers(c.Conn, bufs)
+}
+
+func (c *timeoutConn) Read(p []byte) (int, error) {
+	for {
+		if c.stop.Load() {
+			return 0, errUpgrade
+		}
+		if c.timeout <= 0 {
+			n, err := c.Conn.Read(p)
+			if n == 0 && errors.Is(err, os.ErrDeadlineExceeded) {
+				continue
+			}
+			return n, err
+		}
+		wait := c.timeout - c.warning
+		if c.warned {
+			wait = c.warning
+		}
+		c.Conn.SetReadDeadline(time.Now().Add(wait))
+
+		n, err := c.Conn.Read(p)
+		if n > 0 || !errors.Is(err, os.ErrDeadlineExceeded) {
+			c.warned = false
+			return n, err
+		}
+		if c.stop.Load() {
+			return 0, errUpgrade
+		}
+		if c.warned {
+			fmt.Fprint(c, idleGoodbye(c.timeout))
+			return 0, errIdle
+		}
+		c.warned = true
+		fmt.Fprint(c, idleWarning(c.timeout, c.warning))
+	}
+}
+
+// idleWarning and idleGoodbye are what idle clients are told, warning
+// before timeout
+func idleWarning(timeout, warning time.Duration) string {
+	return fmt.Sprintf("⚠️  You have been idle for %v and will be disconnected in %v unless you send something.\n",
+		timeout-warning, warning)
+}
+
+func idleGoodbye(timeout time.Duration) string {
+	return fmt.Sprintf("Disconnected after %v of inactivity.\n", timeout)
+}
+
+// setKeepAlive enables TCP keepalive probes, so the kernel notices peers
+// that vanished without closing the connection (e.g. dropped Wi-Fi)
+func setKeepAlive(conn net.Conn, period time.Duration) {
+	if w, ok := conn.(interface{ NetConn() net.Conn }); ok {
+		conn = w.NetConn()
+	}
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	if period <= 0 {
+		tcp.SetKeepAlive(false)
+		return
+	}
+	tcp.SetKeepAlive(true)
+	tcp.SetKeepAlivePeriod(period)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// lineSplitter splits like bufio.ScanLines but never fails on long input:
+// a line longer than max is cut at max bytes and the rest, up to the next
+// newline, is discarded. A bare bufio.Scanner would instead stop with
+// ErrTooLong and end the connection.
+type lineSplitter struct {
+	max        int
+	discarding bool // inside the remainder of an over-long line
+	truncated  bool // the last token was cut short
+}
+
+// lineReader reads client input as sanitized lines of bounded length,
+// either from a connection (Next) or from data handed to it by the event
+// loop (Feed)
+type lineReader struct {
+	r     io.Reader
+	split *lineSplitter
+	buf   []byte // input not yet split into lines
+	err   error  // what ended reading from r
+}
+
+func newLineReader(r io.Reader, max int) *lineReader {
+	return &lineReader{r: r, split: &lineSplitter{max: max}, buf: make([]byte, 0, max+1)}
+}
+
+// Next returns the next line, trimmed and sanitized, and whether it was
+// cut to the maximum length. ok is false once the connection ends.
+func (lr *lineReader) Next() (line string, truncated, ok bool) {
+	for {
+		// Input cut off by an upgrade is handed over, not ended
+		atEOF := lr.err != nil && !errors.Is(lr.err, errUpgrade)
+		if line, truncated, ok := lr.Line(atEOF); ok || lr.err != nil {
+			return line, truncated, ok
+		}
+		// Line leaves less than max bytes buffered, so there is room
+		n, err := lr.r.Read(lr.buf[len(lr.buf):cap(lr.buf)])
+		lr.buf = lr.buf[:len(lr.buf)+n]
+		if err != nil {
+			lr.err = err
+		}
+	}
+}
+
+// Feed adds input for Line
+func (lr *lineReader) Feed(p []byte) { lr.buf = append(lr.buf, p...) }
+
+// Buffered returns how many bytes of input are waiting to be split
+func (lr *lineReader) Buffered() int { return len(lr.buf) }
+
+// Line splits the next complete line off the buffered input, or at the
+// end of the input whatever is left. ok is false if there is none yet.
+func (lr *lineReader) Line(atEOF bool) (line string, truncated, ok bool) {
+	for {
+		advance, token, _ := lr.split.split(lr.buf, atEOF)
+		if advance == 0 && token == nil {
+			return "", false, false
+		}
+		if token != nil {
+			line, truncated, ok = strings.TrimSpace(sanitize(string(token))), lr.split.truncated, true
+		}
+		lr.buf = lr.buf[:copy(lr.buf, lr.buf[advance:])]
+		if ok {
+			return line, truncated, true
+		}
+	}
+}
+
+// Err returns the error that ended the input, if it wasn't a clean close
+func (lr *lineReader) Err() error {
+	if errors.Is(lr.err, io.EOF) {
+		return nil
+	}
+	return lr.err
+}
+
+func (ls *lineSplitter) split(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	i := bytes.IndexByte(data, '\n')
+	if ls.discarding {
+		if i < 0 {
+			return len(data), nil, nil
+		}
+		ls.discarding = false
+		return i + 1, nil, nil
+	}
+
+	ls.truncated = false
+	switch {
+	case i >= 0 && i <= ls.max:
+		return i + 1, bytes.TrimSuffix(data[:i], []byte{'\r'}), nil
+	case len(data) >= ls.max:
+		ls.truncated = true
+		ls.discarding = i < 0
+		if i >= 0 {
+			return i + 1, data[:ls.max], nil
+		}
+		return len(data), data[:ls.max], nil
+	case atEOF && len(data) > 0:
+		return len(data), bytes.TrimSuffix(data, []byte{'\r'}), nil
+	}
+	return 0, nil, nil
+}
+
+// sanitize makes client text safe to show to others: invalid UTF-8 is
+// replaced, and control characters (including terminal escape sequences'
+// ESC) and bidirectional overrides are removed. Tabs become spaces.
+func sanitize(s string) string {
+	s = strings.ToValidUTF8(s, "\uFFFD")
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\t':
+			return ' '
+		case unicode.IsControl(r), isBidiControl(r):
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// isBidiControl reports whether r reorders text (e.g. U+202E RIGHT-TO-LEFT
+// OVERRIDE), which can make a message or name display misleadingly
+func isBidiControl(r rune) bool {
+	return (r >= '\u202A' && r <= '\u202E') || (r >= '\u2066' && r <= '\u2069') ||
+		r == '\u200E' || r == '\u200F' || r == '\u061C'
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode"
+	"unicode/utf8"
+)
+
+// FuzzLineReader feeds arbitrary bytes through the input path: whatever
+// a client sends, lines must stay within the limit and be safe to show
+func FuzzLineReader(f *testing.F) {
+	f.Add([]byte("alice\r\nhello\r\n/quit\r\n"), 16)
+	f.Add([]byte("no newline at all"), 16)
+	f.Add([]byte(strings.Repeat("x", 100)+"\nnext\n"), 16)
+	f.Add([]byte("\x1b[2J\x1b]0;pwned\x07hi\n"), 64)
+	f.Add([]byte("‮gnp.exe\n\xff\xfe\xfd\n"), 16)
+	f.Add([]byte("a\tb\r\r\n\n\n"), 16)
+	f.Add([]byte("\xff\xfb\x03\xff\xfd\x01"), 16)
+	f.Fuzz(func(t *testing.T, data []byte, max int) {
+		if max < 16 || max > 4096 {
+			max = 16 + (max&0xfff+0xfff)%4080
+		}
+		lr := newLineReader(strings.NewReader(string(data)), max)
+		var lines []string
+		for n := 0; ; n++ {
+			line, _, ok := lr.Next()
+			if !ok {
+				break
+			}
+			lines = append(lines, line)
+			if n > len(data) {
+				t.Fatalf("more lines than input bytes")
+			}
+			if c := utf8.RuneCountInString(line); c > max {
+				t.Fatalf("line of %d runes exceeds max %d", c, max)
+			}
+			if !utf8.ValidString(line) {
+				t.Fatalf("invalid UTF-8 in %q", line)
+			}
+			for _, r := range line {
+				if unicode.IsControl(r) || isBidiControl(r) {
+					t.Fatalf("control character %U in %q", r, line)
+				}
+			}
+		}
+		if err := lr.Err(); err != nil {
+			t.Fatalf("input ended with %v", err)
+		}
+
+		// The event loop feeds input as it arrives, in pieces of any size,
+		// and must see the same lines
+		fed := newLineReader(nil, max)
+		var got []string
+		for rest := data; ; {
+			chunk := min(len(rest), 1+len(rest)%7)
+			fed.Feed(rest[:chunk])
+			rest = rest[chunk:]
+			for {
+				line, _, ok := fed.Line(len(rest) == 0)
+				if !ok {
+					break
+				}
+				got = append(got, line)
+			}
+			if len(rest) == 0 {
+				break
+			}
+		}
+		if strings.Join(got, "\n") != strings.Join(lines, "\n") || len(got) != len(lines) {
+			t.Fatalf("fed lines %q, read lines %q", got, lines)
+		}
+	})
+}
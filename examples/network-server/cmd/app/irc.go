@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// IRCOptions bridge chat rooms to IRC channels, relaying messages both
+// ways
+type IRCOptions struct {
+	Server   string // host:port of the IRC server; empty disables the bridge
+	TLS      bool
+	Nick     string
+	Password string // server password (PASS), if it needs one
+	// Channels maps chat rooms to the IRC channels they are bridged to
+	Channels map[string]string
+}
+
+const (
+	// IRC servers disconnect clients that send too fast: after a burst,
+	// the bridge sends a line every ircSendInterval
+	ircBurst        = 4
+	ircSendInterval = 500 * time.Millisecond
+
+	// ircTextLimit keeps a relayed line, with the prefix the IRC server
+	// adds when passing it on, within IRC's 512 bytes
+	ircTextLimit = 400
+
+	// Without even a PING from the server for this long, the connection
+	// is taken for dead
+	ircReadTimeout = 5 * time.Minute
+
+	ircMaxBackoff = 5 * time.Minute
+)
+
+// ParseBridges parses room=#channel pairs separated by commas
+func ParseBridges(s string) (map[string]string, error) {
+	channels := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		room, channel, ok := strings.Cut(pair, "=")
+		if !ok || !validName(room) {
+			return nil, fmt.Errorf("%q is not room=#channel", pair)
+		}
+		if len(channel) < 2 || !strings.ContainsRune("#&", rune(channel[0])) || strings.ContainsAny(channel, " ,\a") {
+			return nil, fmt.Errorf("%q is not an IRC channel", channel)
+		}
+		channels[room] = channel
+	}
+	if len(channels) == 0 {
+		return nil, errors.New("no rooms to bridge")
+	}
+	return channels, nil
+}
+
+// ircBridge relays messages between bridged rooms and their IRC channels
+type ircBridge struct {
+	s     *Server
+	opts  IRCOptions
+	rooms map[string]string // lower-case IRC channel to room
+	out   chan message      // chat messages waiting to be sent to IRC
+
+	dropped atomic.Uint64 // messages dropped while the queue was full
+}
+
+func newIRCBridge(s *Server, opts IRCOptions) *ircBridge {
+	b := &ircBridge{s: s, opts: opts, rooms: make(map[string]string), out: make(chan message, 100)}
+	for room, channel := range opts.Channels {
+		b.rooms[strings.ToLower(channel)] = room
+	}
+	return b
+}
+
+// relay queues a message for IRC if its room is bridged. Messages that
+// came from IRC aren't sent back, and of the system messages only those
+// to every room are relayed, leaving out people joining and leaving.
+func (b *ircBridge) relay(m message) {
+	if m.bridged || (m.from == "" && m.room != "") {
+		return
+	}
+	if m.room != "" && b.channel(m.room) == "" {
+		return
+	}
+	select {
+	case b.out <- m:
+	default:
+		if b.dropped.Add(1) == 1 {
+			log.Printf("⚠️  IRC bridge is behind, dropping messages")
+		}
+	}
+}
+
+// channel returns the IRC channel room is bridged to, or ""
+func (b *ircBridge) channel(room string) string {
+	for r, channel := range b.opts.Channels {
+		if key(r) == key(room) {
+			return channel
+		}
+	}
+	return ""
+}
+
+// run keeps the bridge connected until the server shuts down, backing
+// off while the IRC server can't be reached
+func (b *ircBridge) run() {
+	wait := time.Second
+	for {
+		started := time.Now()
+		err := b.session()
+		select {
+		case <-b.s.stop:
+			return
+		default:
+		}
+		if time.Since(started) > ircMaxBackoff {
+			wait = time.Second
+		}
+		log.Printf("⚠️  IRC bridge to %s: %v; reconnecting in %v", b.opts.Server, err, wait)
+		select {
+		case <-b.s.stop:
+			return
+		case <-time.After(wait):
+		}
+		wait = min(2*wait, ircMaxBackoff)
+	}
+}
+
+// session connects, registers and relays until the connection fails
+func (b *ircBridge) session() error {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	var conn net.Conn
+	var err error
+	if b.opts.TLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", b.opts.Server, &tls.Config{MinVersion: tls.VersionTLS12})
+	} else {
+		conn, err = dialer.Dial("tcp", b.opts.Server)
+	}
+	if err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-b.s.stop:
+		case <-done:
+		}
+		conn.Close()
+	}()
+
+	var wmu sync.Mutex
+	send := func(format string, args ...any) error {
+		wmu.Lock()
+		defer wmu.Unlock()
+		conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+		_, err := fmt.Fprintf(conn, format+"\r\n", args...)
+		return err
+	}
+
+	nick := b.opts.Nick
+	if b.opts.Password != "" {
+		send("PASS %s", b.opts.Password)
+	}
+	send("NICK %s", nick)
+	send("USER %s 0 * :RISC-V chat bridge", nick)
+
+	r := bufio.NewReader(conn)
+	registered := false
+	for {
+		conn.SetReadDeadline(time.Now().Add(ircReadTimeout))
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		msg, ok := parseIRC(strings.TrimRight(line, "\r\n"))
+		if !ok {
+			continue
+		}
+		switch msg.command {
+		case "PING":
+			err = send("PONG :%s", msg.param(0))
+		case "ERROR":
+			return fmt.Errorf("server closed the link: %s", msg.param(0))
+		case "433": // nickname in use
+			if !registered {
+				nick += "_"
+				err = send("NICK %s", nick)
+			}
+		case "001": // welcome: registered
+			registered = true
+			for room, channel := range b.opts.Channels {
+				send("JOIN %s", channel)
+				b.s.event("🔗 Bridging #%s to %s on %s as %s", room, channel, b.opts.Server, nick)
+			}
+			go b.write(send, done)
+		case "KICK":
+			if strings.EqualFold(msg.param(1), nick) {
+				log.Printf("⚠️  IRC bridge was kicked from %s: %s", msg.param(0), msg.param(2))
+				time.AfterFunc(10*time.Second, func() { send("JOIN %s", msg.param(0)) })
+			}
+		case "PRIVMSG":
+			b.receive(msg)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// receive posts a message from an IRC channel to its room
+func (b *ircBridge) receive(msg ircMessage) {
+	room, ok := b.rooms[strings.ToLower(msg.param(0))]
+	if !ok {
+		return
+	}
+	text := msg.param(1)
+	if action, ok := strings.CutPrefix(text, "\x01ACTION "); ok {
+		text = "* " + strings.TrimSuffix(action, "\x01")
+	} else if strings.HasPrefix(text, "\x01") {
+		return // other CTCP requests
+	}
+	text = strings.TrimSpace(sanitize(stripIRCFormatting(text)))
+	if limit := b.s.opts.MaxLine; limit > 0 {
+		text = truncateUTF8(text, limit)
+	}
+	if text == "" {
+		return
+	}
+	from := sanitize(msg.nick()) + "@irc"
+	b.s.messages <- message{room: room, from: from, text: text, time: time.Now(), bridged: true}
+}
+
+// write sends queued chat messages to IRC, pacing them so the IRC server
+// doesn't take the bridge for a flood
+func (b *ircBridge) write(send func(string, ...any) error, done <-chan struct{}) {
+	tokens, last := float64(ircBurst), time.Now()
+	for {
+		var m message
+		select {
+		case <-done:
+			return
+		case m = <-b.out:
+		}
+		var channels []string
+		if m.room == "" {
+			for _, channel := range b.opts.Channels {
+				channels = append(channels, channel)
+			}
+		} else {
+			channels = []string{b.channel(m.room)}
+		}
+		text := m.text
+		if m.from != "" {
+			text = "<" + m.from + "> " + text
+		}
+		for _, channel := range channels {
+			for _, part := range splitUTF8(text, ircTextLimit) {
+				now := time.Now()
+				tokens = min(tokens+now.Sub(last).Seconds()/ircSendInterval.Seconds(), ircBurst)
+				last = now
+				if tokens < 1 {
+					select {
+					case <-done:
+						return
+					case <-time.After(time.Duration((1 - tokens) * float64(ircSendInterval))):
+					}
+					tokens, last = 1, time.Now()
+				}
+				tokens--
+				if send("PRIVMSG %s :%s", channel, part) != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// ircMessage is a line from an IRC server: [:prefix] command params...
+type ircMessage struct {
+	prefix  string
+	command string
+	params  []string
+}
+
+// parseIRC parses a line, ignoring IRCv3 message tags
+func parseIRC(line string) (ircMessage, bool) {
+	var m ircMessage
+	if strings.HasPrefix(line, "@") {
+		_, line, _ = strings.Cut(line, " ")
+	}
+	if strings.HasPrefix(line, ":") {
+		m.prefix, line, _ = strings.Cut(line[1:], " ")
+	}
+	line = strings.TrimLeft(line, " ")
+	for line != "" {
+		if strings.HasPrefix(line, ":") {
+			m.params = append(m.params, line[1:])
+			break
+		}
+		var param string
+		param, line, _ = strings.Cut(line, " ")
+		line = strings.TrimLeft(line, " ")
+		if m.command == "" {
+			m.command = strings.ToUpper(param)
+		} else {
+			m.params = append(m.params, param)
+		}
+	}
+	return m, m.command != ""
+}
+
+// param returns the i'th parameter, or ""
+func (m ircMessage) param(i int) string {
+	if i < len(m.params) {
+		return m.params[i]
+	}
+	return ""
+}
+
+// nick returns the sender's nickname from the prefix nick!user@host
+func (m ircMessage) nick() string {
+	nick, _, _ := strings.Cut(m.prefix, "!")
+	return nick
+}
+
+// stripIRCFormatting removes IRC's colour codes with their numbers; the
+// other formatting codes are control characters, which sanitize removes
+func stripIRCFormatting(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == 0x03: // colour: up to two digits, optionally ,background
+			i = skipDigits(s, i+1, 2) - 1
+			if i+2 < len(s) && s[i+1] == ',' && isDigit(s[i+2]) {
+				i = skipDigits(s, i+2, 2) - 1
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// skipDigits returns the index after up to n digits from i
+func skipDigits(s string, i, n int) int {
+	for ; n > 0 && i < len(s) && isDigit(s[i]); n-- {
+		i++
+	}
+	return i
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+// splitUTF8 splits s into parts of at most n bytes, between characters
+func splitUTF8(s string, n int) []string {
+	var parts []string
+	for len(s) > n {
+		part := truncateUTF8(s, n)
+		parts = append(parts, part)
+		s = s[len(part):]
+	}
+	return append(parts, s)
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a character
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
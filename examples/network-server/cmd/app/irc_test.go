@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseIRC(t *testing.T) {
+	for _, tc := range []struct {
+		line string
+		want ircMessage
+	}{
+		{"PING :tungsten.libera.chat", ircMessage{command: "PING", params: []string{"tungsten.libera.chat"}}},
+		{":alice!~a@host PRIVMSG #riscv :hello: there ", ircMessage{prefix: "alice!~a@host", command: "PRIVMSG", params: []string{"#riscv", "hello: there "}}},
+		{"@time=2024-01-01T00:00:00Z :srv 001 bridge :Welcome", ircMessage{prefix: "srv", command: "001", params: []string{"bridge", "Welcome"}}},
+		{":srv  433  *  bridge :Nickname is already in use", ircMessage{prefix: "srv", command: "433", params: []string{"*", "bridge", "Nickname is already in use"}}},
+	} {
+		got, ok := parseIRC(tc.line)
+		if !ok || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseIRC(%q) = %+v, %v", tc.line, got, ok)
+		}
+	}
+	if _, ok := parseIRC(":prefix-only"); ok {
+		t.Error("parseIRC accepted a line without a command")
+	}
+}
+
+func TestStripIRCFormatting(t *testing.T) {
+	for in, want := range map[string]string{
+		"\x02bold\x02 \x0304red\x03 \x0312,01blue on black\x03": "\x02bold\x02 red blue on black",
+		"\x039,text":  ",text",
+		"50\x03% off": "50% off",
+		"\x03123":     "3",
+	} {
+		if got := stripIRCFormatting(in); got != want {
+			t.Errorf("stripIRCFormatting(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSplitUTF8(t *testing.T) {
+	s := strings.Repeat("é", 5) // 10 bytes
+	parts := splitUTF8(s, 3)
+	if strings.Join(parts, "") != s || len(parts) != 5 {
+		t.Errorf("splitUTF8 = %q", parts)
+	}
+	for _, p := range parts {
+		if len(p) > 3 || !strings.HasPrefix(p, "é") {
+			t.Errorf("part %q", p)
+		}
+	}
+}
+
+func TestParseBridges(t *testing.T) {
+	got, err := ParseBridges("lobby=#riscv, sensors=&board-sensors")
+	if err != nil || !reflect.DeepEqual(got, map[string]string{"lobby": "#riscv", "sensors": "&board-sensors"}) {
+		t.Errorf("ParseBridges = %v, %v", got, err)
+	}
+	for _, bad := range []string{"", "lobby", "lobby=riscv", "lobby=#", "lob by=#riscv", "lobby=#a b"} {
+		if _, err := ParseBridges(bad); err == nil {
+			t.Errorf("ParseBridges(%q) accepted", bad)
+		}
+	}
+}
+
+// TestIRCBridge relays a message each way through a fake IRC server
+func TestIRCBridge(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	store, err := OpenStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(store, Options{MaxLine: MAX_LINE, Output: "plain"})
+	defer s.Shutdown()
+	b := newIRCBridge(s, IRCOptions{Server: ln.Addr().String(), Nick: "bridge", Channels: map[string]string{"lobby": "#riscv"}})
+	go b.run()
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	expect := func(want string) {
+		t.Helper()
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("waiting for %q: %v", want, err)
+			}
+			if strings.TrimRight(line, "\r\n") == want {
+				return
+			}
+		}
+	}
+
+	expect("NICK bridge")
+	conn.Write([]byte(":srv 433 * bridge :Nickname is already in use\r\n"))
+	expect("NICK bridge_")
+	conn.Write([]byte(":srv 001 bridge_ :Welcome\r\nPING :srv\r\n"))
+	expect("JOIN #riscv")
+	expect("PONG :srv")
+
+	conn.Write([]byte(":alice!a@host PRIVMSG #RISCV :\x0304hi\x03 from IRC\r\n:alice!a@host PRIVMSG #other :not bridged\r\n"))
+	select {
+	case m := <-s.messages:
+		if m.room != "lobby" || m.from != "alice@irc" || m.text != "hi from IRC" || !m.bridged {
+			t.Errorf("relayed %+v", m)
+		}
+		b.relay(m) // not echoed back
+	case <-time.After(5 * time.Second):
+		t.Fatal("message from IRC not relayed")
+	}
+
+	b.relay(message{room: "Lobby", from: "bob", text: "hi from chat"})
+	b.relay(systemMessage("lobby", "carol joined the chat"))
+	b.relay(message{room: "garden", from: "dave", text: "not bridged"})
+	b.relay(systemMessage("", "Rebooting soon"))
+	expect("PRIVMSG #riscv :<bob> hi from chat")
+	line, _ := r.ReadString('\n')
+	if line != "PRIVMSG #riscv :📢 Rebooting soon\r\n" {
+		t.Errorf("relayed %q after the chat message", line)
+	}
+}
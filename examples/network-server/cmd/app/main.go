@@ -1,198 +1,169 @@
 package main
 
 import (
-	"bufio"
+	"flag"
 	"fmt"
 	"log"
 	"net"
 	"os"
-	"os/signal"
 	"strings"
-	"syscall"
 	"time"
+
+	"riscv-dev/pkg/buildinfo"
+	"riscv-dev/pkg/realip"
+	"riscv-dev/pkg/termout"
+	"riscv-network-server/internal/bench"
+	"riscv-network-server/internal/chatevents"
+	"riscv-network-server/internal/control"
 )
 
 const (
 	SERVER_HOST = "0.0.0.0" // Listen on all interfaces
 	SERVER_PORT = "8080"
 	SERVER_TYPE = "tcp"
-)
 
-type Server struct {
-	clients     map[net.Conn]string
-	messages    chan string
-	newClients  chan net.Conn
-	doneClients chan net.Conn
-}
+	// Registered names, bans and rooms survive restarts in this file
+	DATA_FILE = "chat-state.json"
 
-func NewServer() *Server {
-	return &Server{
-		clients:     make(map[net.Conn]string),
-		messages:    make(chan string, 100),
-		newClients:  make(chan net.Conn),
-		doneClients: make(chan net.Conn),
-	}
-}
+	// Connection health: dead peers are found by keepalive probes, silent
+	// clients are warned and then disconnected
+	KEEPALIVE    = 30 * time.Second
+	IDLE_TIMEOUT = 15 * time.Minute
+	IDLE_WARNING = time.Minute
 
-func (s *Server) handleConnection(conn net.Conn) {
-	defer conn.Close()
+	// Longest accepted input line in bytes
+	MAX_LINE = 512
 
-	// Get client info
-	clientAddr := conn.RemoteAddr().String()
-	fmt.Printf("📡 New connection from: %s\n", clientAddr)
+	// The broadcaster waits this long for more messages to send with one
+	FLUSH_INTERVAL = 5 * time.Millisecond
 
-	// Send welcome message
-	conn.Write([]byte(fmt.Sprintf("Welcome to RISC-V Network Server!\nServer time: %s\nType 'help' for commands.\n\n", time.Now().Format(time.RFC3339))))
+	// Sensor readings relayed with -readings are posted this often
+	READINGS_EVERY = time.Minute
 
-	// Read client name
-	conn.Write([]byte("Enter your name: "))
-	scanner := bufio.NewScanner(conn)
-	if !scanner.Scan() {
+	// Nickname of the IRC bridge, and the room bridged by default
+	IRC_NICK   = "riscv-chat"
+	IRC_BRIDGE = DEFAULT_ROOM + "=#riscv-chat"
+)
+
+func main() {
+	listen := flag.String("listen", SERVER_HOST+":"+SERVER_PORT, "address to listen on")
+	dataFile := flag.String("data", DATA_FILE, "file keeping registered names, bans and rooms (empty: memory only)")
+	op := flag.String("op", "", "comma-separated registered names to make operators")
+	telnet := flag.Bool("telnet", true, "negotiate telnet options (hidden passwords, colour); disable for raw TCP clients")
+	keepAlive := flag.Duration("keepalive", KEEPALIVE, "TCP keepalive probe interval (0 disables)")
+	idleTimeout := flag.Duration("idle-timeout", IDLE_TIMEOUT, "disconnect clients that send nothing for this long (0 never)")
+	idleWarning := flag.Duration("idle-warning", IDLE_WARNING, "warn idle clients this long before disconnecting them")
+	eventLoop := flag.Bool("event-loop", false, "serve logged-in clients from one epoll event loop instead of a goroutine each, for thousands of mostly idle clients")
+	flushInterval := flag.Duration("flush-interval", FLUSH_INTERVAL, "how long broadcasts wait for more messages to send to each client in one write (0: only what is already queued)")
+	maxLine := flag.Int("max-line", MAX_LINE, "longest accepted input line in bytes; longer lines are truncated")
+	proxyFrom := flag.String("proxy-from", "", "comma-separated load balancer addresses or CIDRs that send PROXY protocol headers")
+	controlPath := flag.String("control", control.DefaultSocket, "Unix control socket for chatctl (empty disables)")
+	readings := flag.String("readings", "", "IPC socket of a sensor agent on this board whose readings to post in #"+SENSOR_ROOM+" (empty disables)")
+	readingsEvery := flag.Duration("readings-every", READINGS_EVERY, "how often to post sensor readings (0: every one); quality changes are posted at once")
+	ircServer := flag.String("irc", "", "IRC server (host:port) to bridge rooms to (empty disables)")
+	ircTLS := flag.Bool("irc-tls", true, "connect to the IRC server over TLS")
+	ircNick := flag.String("irc-nick", IRC_NICK, "nickname of the IRC bridge")
+	ircBridge := flag.String("irc-bridge", IRC_BRIDGE, "comma-separated room=#channel pairs to bridge")
+	eventsSocket := flag.String("events", chatevents.DefaultSocket, "IPC socket to publish server events on (empty disables)")
+	eventsFile := flag.String("events-file", "", "JSON lines file to append server events to (empty disables)")
+	eventsWebhook := flag.String("events-webhook", "", "URL to POST batches of server events to (empty disables)")
+	output := flag.String("output", "auto", "console output: pretty, plain (key=value), json, or auto to pick pretty on a terminal")
+	benchAddr := flag.String("bench", "", "instead of chatting, answer net-bench on this address (e.g. :"+bench.DefaultPort+")")
+	buildinfo.RegisterFlag(flag.CommandLine)
+	flag.Parse()
+	mode, err := termout.ParseMode(*output)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	if mode == "" {
+		mode = termout.Detect(os.Stdout)
+	}
+	termout.SetupLog(mode)
+	if *benchAddr != "" {
+		runBench(*benchAddr)
 		return
 	}
-	clientName := strings.TrimSpace(scanner.Text())
-	if clientName == "" {
-		clientName = clientAddr
+	if *maxLine < 16 {
+		log.Fatalf("❌ -max-line must be at least 16")
 	}
 
-	// Register client
-	s.newClients <- conn
-	s.clients[conn] = clientName
-
-	fmt.Printf("👤 Client '%s' (%s) joined\n", clientName, clientAddr)
-
-	// Handle client messages
-	for scanner.Scan() {
-		message := strings.TrimSpace(scanner.Text())
-		if message == "" {
-			continue
-		}
-
-		// Handle commands
-		switch strings.ToLower(message) {
-		case "help":
-			conn.Write([]byte("Available commands:\n"))
-			conn.Write([]byte("  help    - Show this help\n"))
-			conn.Write([]byte("  time    - Get current server time\n"))
-			conn.Write([]byte("  clients - List connected clients\n"))
-			conn.Write([]byte("  quit    - Disconnect from server\n"))
-			conn.Write([]byte("  <text>  - Send message to all clients\n\n"))
-		case "time":
-			conn.Write([]byte(fmt.Sprintf("Current server time: %s\n\n", time.Now().Format(time.RFC3339))))
-		case "clients":
-			conn.Write([]byte(fmt.Sprintf("Connected clients (%d):\n", len(s.clients))))
-			for _, name := range s.clients {
-				conn.Write([]byte(fmt.Sprintf("  - %s\n", name)))
-			}
-			conn.Write([]byte("\n"))
-		case "quit":
-			conn.Write([]byte("Goodbye!\n"))
-			return
-		default:
-			// Broadcast message to all clients
-			s.messages <- fmt.Sprintf("[%s] %s: %s", time.Now().Format("15:04:05"), clientName, message)
-		}
+	trusted, err := realip.ParseTrusted(*proxyFrom)
+	if err != nil {
+		log.Fatalf("❌ -proxy-from: %v", err)
 	}
 
-	// Client disconnected
-	s.doneClients <- conn
-	fmt.Printf("👋 Client '%s' (%s) disconnected\n", clientName, clientAddr)
-}
-
-func (s *Server) broadcastMessages() {
-	for {
-		select {
-		case conn := <-s.newClients:
-			clientName := s.clients[conn]
-			broadcastMsg := fmt.Sprintf("📢 %s joined the chat\n", clientName)
-			s.broadcastToAll(broadcastMsg, conn)
-		case conn := <-s.doneClients:
-			if clientName, exists := s.clients[conn]; exists {
-				delete(s.clients, conn)
-				broadcastMsg := fmt.Sprintf("📢 %s left the chat\n", clientName)
-				s.broadcastToAll(broadcastMsg, nil)
-			}
-		case message := <-s.messages:
-			s.broadcastToAll(message+"\n", nil)
+	ircOpts := IRCOptions{Server: *ircServer, TLS: *ircTLS, Nick: *ircNick, Password: os.Getenv("IRC_PASSWORD")}
+	if *ircServer != "" {
+		if ircOpts.Channels, err = ParseBridges(*ircBridge); err != nil {
+			log.Fatalf("❌ -irc-bridge: %v", err)
 		}
 	}
-}
 
-func (s *Server) broadcastToAll(message string, excludeConn net.Conn) {
-	for conn := range s.clients {
-		if conn != excludeConn {
-			conn.Write([]byte(message))
+	events := chatevents.Config{Socket: *eventsSocket, File: *eventsFile, Webhook: *eventsWebhook, Token: os.Getenv("EVENTS_WEBHOOK_TOKEN")}
+
+	// Started by Upgrade: take over from the old server before reading the
+	// store, which it may write to until it hands over
+	var handedOver *takeover
+	if os.Getenv(upgradeEnv) != "" {
+		if handedOver, err = takeOver(); err != nil {
+			log.Fatalf("❌ Upgrade: %v", err)
 		}
 	}
-	// Also print to server console
-	fmt.Print(message)
-}
-
-func (s *Server) startServer() error {
-	fmt.Printf("🚀 Starting RISC-V Network Server\n")
-	fmt.Printf("Board: %s\n", getBoardInfo())
-	fmt.Printf("Listening on: %s:%s\n", SERVER_HOST, SERVER_PORT)
-	fmt.Printf("Server type: %s\n", SERVER_TYPE)
 
-	// Start message broadcaster
-	go s.broadcastMessages()
-
-	// Listen for connections
-	listener, err := net.Listen(SERVER_TYPE, SERVER_HOST+":"+SERVER_PORT)
+	store, err := OpenStore(*dataFile)
 	if err != nil {
-		return fmt.Errorf("failed to start server: %w", err)
+		log.Fatalf("❌ %v", err)
 	}
-	defer listener.Close()
-
-	fmt.Println("✅ Server started successfully!")
-	fmt.Println("💡 Try connecting with: telnet localhost 8080")
-	fmt.Println("💡 Or use: nc localhost 8080")
-	fmt.Println("💡 Press Ctrl+C to stop the server\n")
-
-	// Handle graceful shutdown
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-
-	// Accept connections
-	go func() {
-		for {
-			conn, err := listener.Accept()
-			if err != nil {
-				log.Printf("❌ Connection error: %v", err)
-				continue
-			}
-			go s.handleConnection(conn)
+	for _, name := range strings.Split(*op, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if err := store.SetOp(name, true); err != nil {
+			log.Printf("⚠️  -op %s: %v (register the name first)", name, err)
 		}
-	}()
-
-	// Wait for shutdown signal
-	<-sigChan
-	fmt.Println("\n🛑 Shutting down server gracefully...")
-
-	// Close all client connections
-	for conn := range s.clients {
-		conn.Write([]byte("Server is shutting down. Goodbye!\n"))
-		conn.Close()
 	}
-
-	fmt.Println("✅ Server shutdown complete")
-	return nil
-}
-
-func main() {
-	server := NewServer()
+	server := NewServer(store, Options{
+		Telnet:        *telnet,
+		KeepAlive:     *keepAlive,
+		IdleTimeout:   *idleTimeout,
+		IdleWarning:   *idleWarning,
+		MaxLine:       *maxLine,
+		EventLoop:     *eventLoop,
+		FlushInterval: *flushInterval,
+		ProxyFrom:     trusted,
+		Readings:      *readings,
+		ReadingsEvery: *readingsEvery,
+		IRC:           ircOpts,
+		Events:        events,
+		Output:        mode,
+	})
+	server.takeover = handedOver
 
 	// Display system information
-	fmt.Printf("🌐 RISC-V Network Server Example\n")
-	fmt.Printf("Go version: %s\n", getGoVersion())
-	fmt.Printf("Architecture: %s\n", getArchInfo())
-	fmt.Printf("Server will listen on port %s\n\n", SERVER_PORT)
+	if mode == termout.Pretty {
+		fmt.Printf("🌐 RISC-V Network Server Example\n")
+		fmt.Printf("Version: %s\n", buildinfo.Get())
+		fmt.Printf("Architecture: %s\n", getArchInfo())
+		fmt.Printf("Server will listen on %s\n\n", *listen)
+	}
 
-	if err := server.startServer(); err != nil {
+	if err := server.startServer(*listen, *controlPath); err != nil {
 		log.Fatalf("❌ Server error: %v", err)
 	}
 }
 
+// runBench serves benchmark clients until the process is stopped
+func runBench(addr string) {
+	ln, err := net.Listen(SERVER_TYPE, addr)
+	if err != nil {
+		log.Fatalf("❌ Benchmark server: %v", err)
+	}
+	log.Printf("📊 Benchmark server listening on %s (%s)", ln.Addr(), buildinfo.Get())
+	if err := bench.NewServer().Serve(ln); err != nil {
+		log.Fatalf("❌ Benchmark server: %v", err)
+	}
+}
+
 // Helper functions
 func getBoardInfo() string {
 	boardFiles := []string{
@@ -209,10 +180,6 @@ func getBoardInfo() string {
 	return "Unknown RISC-V Board"
 }
 
-func getGoVersion() string {
-	return "Go 1.21+ (cross-compiled for RISC-V)"
-}
-
 func getArchInfo() string {
 	return "RISC-V 64-bit (RV64GC)"
 }
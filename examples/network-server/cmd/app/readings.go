@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"riscv-dev/pkg/agent"
+	"riscv-dev/pkg/metrics"
+	"riscv-dev/pkg/sensor"
+)
+
+// SENSOR_ROOM is where readings from the sensor agent are posted
+const SENSOR_ROOM = "sensors"
+
+// relayReadings posts the readings a sensor agent on the same board
+// publishes on its IPC socket to the sensors room: a summary every
+// interval, and at once when a channel's quality changes. It reconnects
+// whenever the agent restarts, until the server shuts down, and notes
+// readings missed on the way.
+func (s *Server) relayReadings(path string, every time.Duration) {
+	dedup := agent.NewDedup(metrics.Default)
+	waiting := false
+	for {
+		stream, err := agent.SubscribeReadings(path)
+		if err != nil {
+			if !waiting {
+				log.Printf("⚠️  Waiting for the sensor agent on %s: %v", path, err)
+				waiting = true
+			}
+			select {
+			case <-time.After(5 * time.Second):
+				continue
+			case <-s.stop:
+				return
+			}
+		}
+		waiting = false
+		s.event("📊 Relaying sensor readings from %s to #%s", path, SENSOR_ROOM)
+		done := make(chan struct{})
+		go func() {
+			select {
+			case <-s.stop:
+				stream.Close()
+			case <-done:
+			}
+		}()
+
+		var posted time.Time
+		quality := make(map[string]sensor.Quality)
+		for {
+			r, err := stream.Next()
+			if err != nil {
+				select {
+				case <-s.stop:
+					return
+				default:
+				}
+				if errors.Is(err, io.EOF) {
+					log.Printf("⚠️  The sensor agent on %s stopped", path)
+				} else {
+					log.Printf("❌ Sensor readings: %v", err)
+				}
+				break
+			}
+			res, ok := dedup.Check(r)
+			if res.Missed > 0 {
+				log.Printf("⚠️  Missed %d sensor readings before #%d", res.Missed, r.Seq)
+			}
+			if !ok {
+				continue
+			}
+			changed := false
+			for _, c := range r.Channels {
+				if q, ok := quality[c.Name]; ok && q != c.Quality {
+					changed = true
+				}
+				quality[c.Name] = c.Quality
+			}
+			if changed || r.Time.Sub(posted) >= every {
+				posted = r.Time
+				s.messages <- message{room: SENSOR_ROOM, from: "sensors", text: readingText(r), time: r.Time}
+			}
+		}
+		close(done)
+		stream.Close()
+	}
+}
+
+// readingText summarises a reading in one chat line
+func readingText(r agent.Reading) string {
+	parts := make([]string, 0, len(r.Channels))
+	for _, c := range r.Channels {
+		value := "fault"
+		if !math.IsNaN(c.Value) {
+			precision := 2
+			if c.Meta != nil && c.Meta.Precision != nil {
+				precision = *c.Meta.Precision
+			}
+			value = strconv.FormatFloat(c.Value, 'f', precision, 64)
+			if c.Unit != "" {
+				value += " " + c.Unit
+			}
+		}
+		if c.Quality != sensor.OK && !math.IsNaN(c.Value) {
+			value += fmt.Sprintf(" (%s)", c.Quality)
+		}
+		parts = append(parts, c.Name+" "+value)
+	}
+	return strings.Join(parts, ", ")
+}
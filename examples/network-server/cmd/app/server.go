@@ -0,0 +1,922 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"riscv-dev/pkg/realip"
+	"riscv-dev/pkg/termout"
+	"riscv-network-server/internal/chatevents"
+)
+
+// DEFAULT_ROOM is where clients start
+const DEFAULT_ROOM = "lobby"
+
+// client is a connected user
+type client struct {
+	conn   net.Conn
+	telnet *telnetConn // nil unless telnet negotiation is enabled
+	name   string
+	addr   string
+	ip     string
+	since  time.Time
+
+	mu    sync.Mutex // guards room, op, color and gone, and serialises writes
+	room  string
+	op    bool   // logged in to a registered operator nickname
+	color bool   // ANSI colour, detected from the telnet terminal type
+	gone  string // why the server disconnected the client, if it did
+}
+
+// send writes to the client, ignoring errors: a broken connection is
+// noticed by its reader
+func (c *client) send(format string, args ...any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(c.conn, format, args...)
+}
+
+// close disconnects the client
+func (c *client) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.Close()
+}
+
+// closeFor disconnects the client, noting why for its disconnect event
+func (c *client) closeFor(reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gone = reason
+	c.conn.Close()
+}
+
+// goneWhy returns why the server disconnected the client, or else the
+// error that ended its connection, or "" if it left by choice
+func (c *client) goneWhy(err error) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case c.gone != "":
+		return c.gone
+	case err != nil:
+		return err.Error()
+	}
+	return ""
+}
+
+func (c *client) currentRoom() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.room
+}
+
+func (c *client) isOp() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.op
+}
+
+// render formats m for c, in colour if its terminal supports it
+func (c *client) render(m message) string {
+	c.mu.Lock()
+	color := c.color
+	c.mu.Unlock()
+
+	if m.from == "" {
+		if color {
+			return ansiSystem + m.text + ansiReset + "\n"
+		}
+		return m.text + "\n"
+	}
+	from := m.from
+	if color {
+		from = ansiName + from + ansiReset
+	}
+	return fmt.Sprintf("[%s] %s: %s\n", m.time.Format("15:04:05"), from, m.text)
+}
+
+// message is a line for everyone in a room except one client
+type message struct {
+	room    string // empty for every room
+	from    string // sender; empty for system messages
+	text    string
+	time    time.Time
+	exclude *client
+	bridged bool // came from IRC, so isn't sent back
+	// flushed is closed once the messages before it are broadcast, for
+	// an otherwise empty message
+	flushed chan struct{}
+}
+
+// systemMessage announces text to a room
+func systemMessage(room, text string) message {
+	return message{room: room, text: "📢 " + text, time: time.Now()}
+}
+
+type Server struct {
+	mu       sync.Mutex
+	clients  map[net.Conn]*client
+	guests   int
+	store    *Store
+	messages chan message
+	opts     Options
+	loop     *eventLoop // nil unless Options.EventLoop
+	irc      *ircBridge // nil unless Options.IRC has a server
+	// events is nil unless Options.Events has a destination
+	events *chatevents.Exporter
+
+	addr      string
+	started   time.Time
+	delivered atomic.Uint64 // messages broadcast to rooms
+	sent      atomic.Uint64 // messages written to clients
+	writes    atomic.Uint64 // writes those took, one per client per batch
+	stop      chan struct{}
+	stopOnce  sync.Once
+
+	// In-place upgrades (upgrade.go): listener is the TCP listener passed
+	// on, and readers stop and send their clients to handoffs once
+	// draining is set
+	listener  net.Listener
+	upgrading atomic.Bool
+	draining  atomic.Bool
+	handoffs  chan handoff
+	upgrade   *upgrade  // guarded by mu; set once clients are drained
+	takeover  *takeover // what this process took over, if upgraded to
+}
+
+// Options tune how the server treats connections
+type Options struct {
+	Telnet      bool          // negotiate telnet options with clients
+	KeepAlive   time.Duration // TCP keepalive probe interval; 0 disables
+	IdleTimeout time.Duration // disconnect clients silent this long; 0 never does
+	IdleWarning time.Duration // warn this long before an idle disconnect
+	MaxLine     int           // longest accepted input line in bytes
+
+	// EventLoop serves logged-in clients from one epoll loop instead of
+	// a goroutine each, for thousands of mostly idle clients
+	EventLoop bool
+
+	// FlushInterval is how long the broadcaster waits for more messages
+	// to send along with one, so a burst takes one write per client
+	FlushInterval time.Duration
+
+	// ProxyFrom lists load balancers whose PROXY protocol headers carry
+	// the real client address
+	ProxyFrom realip.Trusted
+
+	// Readings is the IPC socket of a sensor agent on the same board whose
+	// readings are posted to the sensors room, every ReadingsEvery
+	Readings      string
+	ReadingsEvery time.Duration
+
+	// IRC bridges rooms to IRC channels
+	IRC IRCOptions
+
+	// Events are exported to these destinations
+	Events chatevents.Config
+
+	// Output is how events are printed: pretty (the default) for a
+	// terminal, or plain or JSON log records
+	Output termout.Mode
+}
+
+func NewServer(store *Store, opts Options) *Server {
+	return &Server{
+		clients:  make(map[net.Conn]*client),
+		store:    store,
+		messages: make(chan message, 100),
+		opts:     opts,
+		stop:     make(chan struct{}),
+		handoffs: make(chan handoff),
+	}
+}
+
+// Shutdown makes startServer disconnect everyone and return
+func (s *Server) Shutdown() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+func (s *Server) handleConnection(conn net.Conn) {
+	raw := conn
+	served := false // then serve closes it
+	defer func() {
+		if !served {
+			raw.Close()
+		}
+	}()
+	if err := realip.Err(conn); err != nil {
+		log.Printf("❌ Connection from proxy %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	setKeepAlive(conn, s.opts.KeepAlive)
+	var tc *telnetConn
+	if s.opts.Telnet {
+		tc = newTelnetConn(conn)
+		conn = tc
+	}
+	conn = newTimeoutConn(conn, s.opts.IdleTimeout, s.opts.IdleWarning, &s.draining)
+
+	// Get client info
+	clientAddr := conn.RemoteAddr().String()
+	ip, _, _ := net.SplitHostPort(clientAddr)
+	s.event("📡 New connection from: %s", clientAddr)
+
+	if b, banned := s.store.Banned("", ip); banned {
+		fmt.Fprintf(conn, "You are banned from this server%s.\n", reasonSuffix(b))
+		s.event("🚫 Rejected banned address %s", clientAddr)
+		s.events.Emit(chatevents.Event{Type: chatevents.Error, Addr: clientAddr, Error: "banned address"})
+		return
+	}
+
+	// Send welcome message
+	fmt.Fprintf(conn, "Welcome to RISC-V Network Server!\nServer time: %s\nType 'help' for commands.\n\n", time.Now().Format(time.RFC3339))
+
+	input := newLineReader(conn, s.opts.MaxLine)
+	c := s.login(conn, tc, input, clientAddr, ip)
+	if c == nil {
+		return
+	}
+
+	s.event("👤 Client '%s' (%s) joined", c.name, clientAddr)
+	s.events.Emit(chatevents.Event{Type: chatevents.Connect, Name: c.name, Addr: clientAddr})
+	s.enterRoom(c, DEFAULT_ROOM)
+	served = true
+	s.serve(c, raw, tc, input)
+}
+
+// serve handles a logged-in client's messages until it leaves, handing
+// the connection to the event loop once nothing read ahead is left to
+// handle here, or to a new server in an upgrade
+func (s *Server) serve(c *client, raw net.Conn, tc *telnetConn, input *lineReader) {
+	kept := false // by the event loop or an upgrade
+	defer func() {
+		if !kept {
+			s.remove(c, input.Err())
+			raw.Close()
+		}
+	}()
+
+	loop := s.loop
+	for {
+		if loop != nil && input.Buffered() == 0 {
+			if kept = loop.adopt(c, raw, tc, input); kept {
+				return
+			}
+			loop = nil
+		}
+		line, truncated, ok := input.Next()
+		if !ok {
+			break
+		}
+		if !s.handleLine(c, line, truncated) {
+			return
+		}
+	}
+	if errors.Is(input.Err(), errUpgrade) {
+		select {
+		case s.handoffs <- handoff{c: c, raw: raw, tc: tc, input: input}:
+			kept = true
+		case <-s.stop:
+		}
+		return
+	}
+	if err := input.Err(); err != nil && !errors.Is(err, errIdle) && !errors.Is(err, net.ErrClosed) {
+		log.Printf("❌ %s (%s): %v", c.name, c.addr, err)
+	}
+}
+
+// handleLine acts on a line from c and reports whether the connection
+// stays open
+func (s *Server) handleLine(c *client, line string, truncated bool) bool {
+	if truncated {
+		c.send("⚠️  Line too long; only the first %d bytes were kept.\n", s.opts.MaxLine)
+	}
+	if line == "" {
+		return true
+	}
+	if cmd, ok := ParseCommand(line); ok {
+		return s.runCommand(c, cmd)
+	}
+	// Broadcast message to the client's room
+	s.messages <- message{room: c.currentRoom(), from: c.name, text: line, time: time.Now()}
+	return true
+}
+
+// login asks for a nickname, and its password if it is registered, and
+// adds the client. It returns nil if the client gave up or is banned.
+func (s *Server) login(conn net.Conn, tc *telnetConn, input *lineReader, addr, ip string) *client {
+	for attempt := 0; attempt < 3; attempt++ {
+		fmt.Fprint(conn, "Enter your name: ")
+		name, _, ok := input.Next()
+		if !ok {
+			return nil
+		}
+		if name == "" {
+			s.mu.Lock()
+			s.guests++
+			name = fmt.Sprintf("guest%d", s.guests)
+			s.mu.Unlock()
+		}
+		if !validName(name) {
+			fmt.Fprint(conn, "Names are 1-24 letters, digits, '-' or '_'.\n")
+			continue
+		}
+		if b, banned := s.store.Banned(name, ip); banned {
+			fmt.Fprintf(conn, "%s is banned from this server%s.\n", name, reasonSuffix(b))
+			s.event("🚫 Rejected banned name '%s' from %s", name, addr)
+			s.events.Emit(chatevents.Event{Type: chatevents.Error, Name: name, Addr: addr, Error: "banned name"})
+			return nil
+		}
+
+		op := false
+		if u, registered := s.store.User(name); registered {
+			password, ok := readPassword(conn, tc, input)
+			if !ok {
+				return nil
+			}
+			if !s.store.CheckPassword(name, password) {
+				fmt.Fprint(conn, "Wrong password.\n")
+				s.event("🔒 Failed login as '%s' from %s", name, addr)
+				s.events.Emit(chatevents.Event{Type: chatevents.Error, Name: name, Addr: addr, Error: "wrong password"})
+				continue
+			}
+			name, op = u.Name, u.Op
+		}
+
+		c := &client{conn: conn, telnet: tc, name: name, addr: addr, ip: ip, op: op, since: time.Now()}
+		if tc != nil {
+			c.color = tc.Color()
+		}
+		if !s.add(c) {
+			fmt.Fprintf(conn, "%s is already connected.\n", name)
+			s.events.Emit(chatevents.Event{Type: chatevents.Error, Name: name, Addr: addr, Error: "name in use"})
+			continue
+		}
+		return c
+	}
+	return nil
+}
+
+// add registers c unless its name is taken
+func (s *Server) add(c *client) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, other := range s.clients {
+		if key(other.name) == key(c.name) {
+			return false
+		}
+	}
+	s.clients[c.conn] = c
+	return true
+}
+
+// remove unregisters c and announces that it left; err is what ended
+// its connection, if anything did
+func (s *Server) remove(c *client, err error) {
+	s.mu.Lock()
+	_, exists := s.clients[c.conn]
+	delete(s.clients, c.conn)
+	s.mu.Unlock()
+	if exists {
+		s.messages <- systemMessage(c.currentRoom(), c.name+" left the chat")
+		s.event("👋 Client '%s' (%s) disconnected", c.name, c.addr)
+		s.events.Emit(chatevents.Event{Type: chatevents.Disconnect, Name: c.name, Addr: c.addr, Room: c.currentRoom(), Error: c.goneWhy(err)})
+	}
+}
+
+// find returns the connected client with the given name
+func (s *Server) find(name string) *client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.clients {
+		if key(c.name) == key(name) {
+			return c
+		}
+	}
+	return nil
+}
+
+// snapshot returns the connected clients sorted by name
+func (s *Server) snapshot() []*client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clients := make([]*client, 0, len(s.clients))
+	for _, c := range s.clients {
+		clients = append(clients, c)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].name < clients[j].name })
+	return clients
+}
+
+// enterRoom moves c into room, creating it if needed
+func (s *Server) enterRoom(c *client, name string) {
+	room, err := s.store.EnsureRoom(name, c.name)
+	if err != nil {
+		log.Printf("❌ Store: %v", err)
+		s.events.Emit(chatevents.Event{Type: chatevents.Error, Name: c.name, Room: name, Error: err.Error()})
+		c.send("Could not create %s.\n\n", name)
+		return
+	}
+
+	c.mu.Lock()
+	previous := c.room
+	c.room = room.Name
+	c.mu.Unlock()
+
+	joined := systemMessage(room.Name, c.name+" joined the chat")
+	if previous != "" {
+		s.messages <- systemMessage(previous, c.name+" left "+previous)
+		s.events.Emit(chatevents.Event{Type: chatevents.Leave, Name: c.name, Room: previous})
+		joined = systemMessage(room.Name, c.name+" joined "+room.Name)
+	}
+	s.events.Emit(chatevents.Event{Type: chatevents.Join, Name: c.name, Room: room.Name})
+	joined.exclude = c
+	s.messages <- joined
+	c.send("You are in %s.\n", room.Name)
+	if room.Topic != "" {
+		c.send("Topic: %s\n", room.Topic)
+	}
+	c.send("\n")
+}
+
+// runCommand executes cmd for c and reports whether the connection stays
+// open
+func (s *Server) runCommand(c *client, cmd Command) bool {
+	switch cmd.Name {
+	case "help":
+		c.send("Available commands:\n")
+		c.send("  help                 - Show this help\n")
+		c.send("  time                 - Get current server time\n")
+		c.send("  clients              - List connected clients\n")
+		c.send("  quit                 - Disconnect from server\n")
+		c.send("  /register <password> - Reserve your name\n")
+		c.send("  /rooms               - List rooms\n")
+		c.send("  /join <room>         - Enter a room, creating it if needed\n")
+		c.send("  /topic [text]        - Show or set the room topic\n")
+		c.send("  /color on|off        - Colour system messages and names\n")
+		if c.isOp() {
+			c.send("  /kick <name> [reason]        - Disconnect a client\n")
+			c.send("  /ban <name|ip:addr> [reason] - Ban a name or address\n")
+			c.send("  /unban <name|ip:addr>        - Lift a ban\n")
+			c.send("  /bans                        - List bans\n")
+			c.send("  /op <name>                   - Make a registered name an operator\n")
+		}
+		c.send("  <text>               - Send message to your room\n\n")
+	case "time":
+		c.send("Current server time: %s\n\n", time.Now().Format(time.RFC3339))
+	case "clients":
+		clients := s.snapshot()
+		c.send("Connected clients (%d):\n", len(clients))
+		for _, other := range clients {
+			c.send("  - %s (%s)\n", other.name, other.currentRoom())
+		}
+		c.send("\n")
+	case "quit":
+		c.send("Goodbye!\n")
+		return false
+
+	case "register":
+		if len(cmd.Args) != 1 {
+			c.send("Usage: /register <password>\n\n")
+			break
+		}
+		if err := s.store.Register(c.name, cmd.Args[0]); err != nil {
+			c.send("%v\n\n", err)
+			break
+		}
+		c.send("%s is now registered; you will be asked for the password next time.\n\n", c.name)
+		s.event("📝 '%s' registered", c.name)
+	case "rooms":
+		counts := make(map[string]int)
+		for _, other := range s.snapshot() {
+			counts[key(other.currentRoom())]++
+		}
+		c.send("Rooms:\n")
+		for _, r := range s.store.Rooms() {
+			c.send("  %-16s %2d online  %s\n", r.Name, counts[key(r.Name)], r.Topic)
+		}
+		c.send("\n")
+	case "join":
+		if len(cmd.Args) != 1 || !validName(cmd.Args[0]) {
+			c.send("Usage: /join <room>\n\n")
+			break
+		}
+		s.enterRoom(c, cmd.Args[0])
+	case "topic":
+		room, _ := s.store.Room(c.currentRoom())
+		if cmd.Rest == "" {
+			c.send("Topic of %s: %s\n\n", room.Name, room.Topic)
+			break
+		}
+		if !c.isOp() && key(room.CreatedBy) != key(c.name) {
+			c.send("Only the room's creator or an operator can set the topic.\n\n")
+			break
+		}
+		if err := s.store.SetTopic(room.Name, cmd.Rest); err != nil {
+			c.send("%v\n\n", err)
+			break
+		}
+		s.messages <- systemMessage(room.Name, c.name+" set the topic: "+cmd.Rest)
+	case "color":
+		on := len(cmd.Args) == 1 && cmd.Args[0] == "on"
+		if len(cmd.Args) != 1 || (!on && cmd.Args[0] != "off") {
+			c.send("Usage: /color on|off\n\n")
+			break
+		}
+		c.mu.Lock()
+		c.color = on
+		c.mu.Unlock()
+		c.send("Colour %s.\n\n", cmd.Args[0])
+
+	case "kick", "ban", "unban", "bans", "op":
+		if !c.isOp() {
+			c.send("Operators only.\n\n")
+			break
+		}
+		s.runOpCommand(c, cmd)
+	default:
+		c.send("Unknown command: %s (try help)\n\n", cmd.Name)
+	}
+	return true
+}
+
+// runOpCommand executes a moderation command
+func (s *Server) runOpCommand(c *client, cmd Command) {
+	switch cmd.Name {
+	case "kick":
+		if len(cmd.Args) < 1 {
+			c.send("Usage: /kick <name> [reason]\n\n")
+			return
+		}
+		other := s.find(cmd.Args[0])
+		if other == nil {
+			c.send("%s is not connected.\n\n", cmd.Args[0])
+			return
+		}
+		reason := strings.TrimSpace(strings.TrimPrefix(cmd.Rest, cmd.Args[0]))
+		s.event("👢 %s kicked %s", c.name, other.name)
+		s.events.Emit(chatevents.Event{Type: chatevents.Kick, Name: other.name, Addr: other.addr, By: c.name, Text: reason})
+		other.send("You have been kicked by %s%s.\n", c.name, reasonSuffix(Ban{Reason: reason}))
+		other.closeFor("kicked")
+		c.send("Kicked %s.\n\n", other.name)
+	case "ban":
+		if len(cmd.Args) < 1 {
+			c.send("Usage: /ban <name|ip:addr> [reason]\n\n")
+			return
+		}
+		target := cmd.Args[0]
+		reason := strings.TrimSpace(strings.TrimPrefix(cmd.Rest, target))
+		if err := s.store.AddBan(Ban{Target: target, Reason: reason, By: c.name}); err != nil {
+			c.send("%v\n\n", err)
+			return
+		}
+		s.event("🚫 %s banned %s", c.name, target)
+		s.events.Emit(chatevents.Event{Type: chatevents.Ban, Name: target, By: c.name, Text: reason})
+		c.send("Banned %s.\n\n", target)
+		for _, other := range s.snapshot() {
+			if b, banned := s.store.Banned(other.name, other.ip); banned {
+				other.send("You have been banned%s.\n", reasonSuffix(b))
+				other.closeFor("banned")
+			}
+		}
+	case "unban":
+		if len(cmd.Args) != 1 {
+			c.send("Usage: /unban <name|ip:addr>\n\n")
+			return
+		}
+		removed, err := s.store.RemoveBan(cmd.Args[0])
+		switch {
+		case err != nil:
+			c.send("%v\n\n", err)
+		case !removed:
+			c.send("%s is not banned.\n\n", cmd.Args[0])
+		default:
+			s.event("✅ %s unbanned %s", c.name, cmd.Args[0])
+			c.send("Unbanned %s.\n\n", cmd.Args[0])
+		}
+	case "bans":
+		bans := s.store.Bans()
+		c.send("Bans (%d):\n", len(bans))
+		for _, b := range bans {
+			c.send("  %-20s by %s on %s%s\n", b.Target, b.By, b.At.Format("2006-01-02"), reasonSuffix(b))
+		}
+		c.send("\n")
+	case "op":
+		if len(cmd.Args) != 1 {
+			c.send("Usage: /op <name>\n\n")
+			return
+		}
+		if err := s.store.SetOp(cmd.Args[0], true); err != nil {
+			c.send("%v\n\n", err)
+			return
+		}
+		if other := s.find(cmd.Args[0]); other != nil {
+			other.mu.Lock()
+			other.op = true
+			other.mu.Unlock()
+			other.send("You are now an operator.\n\n")
+		}
+		c.send("%s is now an operator.\n\n", cmd.Args[0])
+	}
+}
+
+// readPassword prompts for a password, hiding it from telnet clients
+func readPassword(conn net.Conn, tc *telnetConn, input *lineReader) (string, bool) {
+	if tc != nil {
+		tc.SetEcho(false)
+		defer func() {
+			tc.SetEcho(true)
+			fmt.Fprint(conn, "\n") // the user's Enter wasn't echoed
+		}()
+	}
+	fmt.Fprint(conn, "Password: ")
+	password, _, ok := input.Next()
+	return password, ok
+}
+
+func reasonSuffix(b Ban) string {
+	if b.Reason == "" {
+		return ""
+	}
+	return " (" + b.Reason + ")"
+}
+
+// broadcastMessages sends queued messages to their rooms, gathering
+// those that arrive together into batches
+func (s *Server) broadcastMessages() {
+	for m := range s.messages {
+		batch := s.gather(m)
+		var flushed []chan struct{}
+		n := 0
+		for _, m := range batch {
+			if m.flushed != nil {
+				flushed = append(flushed, m.flushed)
+				continue
+			}
+			batch[n] = m
+			n++
+		}
+		batch = batch[:n]
+		s.broadcast(batch)
+		s.delivered.Add(uint64(len(batch)))
+		for _, m := range batch {
+			if m.from != "" {
+				s.events.Emit(chatevents.Event{Type: chatevents.Message, Time: m.time, Name: m.from, Room: m.room, Text: m.text})
+			}
+		}
+		for _, done := range flushed {
+			close(done)
+		}
+	}
+}
+
+// broadcast sends each client the messages of batch for its room in a
+// single write
+func (s *Server) broadcast(batch []message) {
+	for _, c := range s.snapshot() {
+		room := key(c.currentRoom())
+		var bufs net.Buffers
+		for _, m := range batch {
+			if c != m.exclude && (m.room == "" || room == key(m.room)) {
+				bufs = append(bufs, []byte(c.render(m)))
+			}
+		}
+		if len(bufs) > 0 {
+			c.sendBuffers(bufs)
+			s.sent.Add(uint64(len(bufs)))
+			s.writes.Add(1)
+		}
+	}
+	if s.irc != nil {
+		for _, m := range batch {
+			s.irc.relay(m)
+		}
+	}
+	// Also print to server console
+	for _, m := range batch {
+		room := m.room
+		if room == "" {
+			room = "*"
+		}
+		if !s.pretty() {
+			log.Printf("[%s] %s", room, strings.TrimSuffix((&client{}).render(m), "\n"))
+			continue
+		}
+		fmt.Printf("[%s] %s", room, (&client{}).render(m))
+	}
+}
+
+// mode names how connections are served
+func (s *Server) mode() string {
+	if s.opts.EventLoop {
+		return "event-loop"
+	}
+	return "goroutines"
+}
+
+// pretty reports whether the console is a terminal to print for
+func (s *Server) pretty() bool {
+	return s.opts.Output == "" || s.opts.Output == termout.Pretty
+}
+
+// event reports something that happened on the server: printed as it
+// is for a terminal, else logged as a record in the output mode
+func (s *Server) event(format string, args ...any) {
+	if s.pretty() {
+		fmt.Printf(format+"\n", args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// eventDestinations lists where events are exported, for the startup log
+func eventDestinations(cfg chatevents.Config) string {
+	var to []string
+	if cfg.Socket != "" {
+		to = append(to, cfg.Socket)
+	}
+	if cfg.File != "" {
+		to = append(to, cfg.File)
+	}
+	if cfg.Webhook != "" {
+		to = append(to, cfg.Webhook)
+	}
+	return strings.Join(to, ", ")
+}
+
+func (s *Server) startServer(addr, controlPath string) error {
+	s.addr, s.started = addr, time.Now()
+	defer s.handOver() // last, after everything else has closed
+	if s.pretty() {
+		fmt.Printf("🚀 Starting RISC-V Network Server\n")
+		fmt.Printf("Board: %s\n", getBoardInfo())
+		fmt.Printf("Listening on: %s\n", addr)
+		fmt.Printf("Server type: %s\n", SERVER_TYPE)
+		fmt.Printf("Connections: %s\n", s.mode())
+	}
+
+	if _, err := s.store.EnsureRoom(DEFAULT_ROOM, "server"); err != nil {
+		return fmt.Errorf("failed to open store: %w", err)
+	}
+
+	if s.opts.EventLoop {
+		loop, err := newEventLoop(s)
+		if err != nil {
+			return fmt.Errorf("failed to start the event loop: %w", err)
+		}
+		s.loop = loop
+		go loop.run()
+	}
+
+	// Rooms bridged to IRC, set up before the broadcaster relays to them
+	if s.opts.IRC.Server != "" {
+		for room := range s.opts.IRC.Channels {
+			if _, err := s.store.EnsureRoom(room, "server"); err != nil {
+				return fmt.Errorf("failed to open store: %w", err)
+			}
+		}
+		s.irc = newIRCBridge(s, s.opts.IRC)
+		go s.irc.run()
+	}
+
+	// Event export, started before anything that emits events; the chat
+	// works without it
+	if ev := s.opts.Events; ev.Socket != "" || ev.File != "" || ev.Webhook != "" {
+		exporter, err := chatevents.Open(ev)
+		if err != nil {
+			log.Printf("⚠️  Event export disabled: %v", err)
+		} else {
+			s.events = exporter
+			defer exporter.Close()
+			s.event("📤 Exporting events to %s", eventDestinations(ev))
+		}
+	}
+
+	// Start message broadcaster
+	go s.broadcastMessages()
+
+	// Listen for connections, on the socket of the server upgraded from
+	// if there was one
+	var listener net.Listener
+	if s.takeover != nil {
+		listener = s.takeover.listener
+	} else {
+		var err error
+		if listener, err = net.Listen(SERVER_TYPE, addr); err != nil {
+			return fmt.Errorf("failed to start server: %w", err)
+		}
+	}
+	s.listener = listener
+	if len(s.opts.ProxyFrom) > 0 {
+		listener = realip.NewListener(listener, s.opts.ProxyFrom)
+	}
+	defer listener.Close()
+
+	// Local control socket for chatctl; the chat works without it
+	if controlPath != "" {
+		ctl, err := s.serveControl(controlPath)
+		if err != nil {
+			log.Printf("⚠️  Control socket disabled: %v", err)
+		} else {
+			defer ctl.Close()
+			s.event("🔧 Control socket: %s", controlPath)
+		}
+	}
+
+	if s.opts.Readings != "" {
+		if _, err := s.store.EnsureRoom(SENSOR_ROOM, "server"); err != nil {
+			return fmt.Errorf("failed to open store: %w", err)
+		}
+		go s.relayReadings(s.opts.Readings, s.opts.ReadingsEvery)
+	}
+
+	if s.pretty() {
+		fmt.Println("✅ Server started successfully!")
+		fmt.Println("💡 Try connecting with: telnet localhost 8080")
+		fmt.Println("💡 Or use: nc localhost 8080")
+		fmt.Print("💡 Press Ctrl+C to stop the server\n\n")
+	} else {
+		log.Printf("✅ Server listening on %s (%s) on %s", addr, SERVER_TYPE, getBoardInfo())
+	}
+
+	// Handle graceful shutdown
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	// SIGHUP upgrades in place to the executable now on disk
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := s.Upgrade(); err != nil {
+				log.Printf("❌ Upgrade: %v", err)
+			}
+		}
+	}()
+
+	// Carry on serving the clients of the server upgraded from
+	if t := s.takeover; t != nil {
+		for _, in := range t.clients {
+			s.resume(in)
+		}
+		s.event("♻️  Took over %d clients from pid %d", len(t.clients), t.from)
+	}
+
+	// Accept connections
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if err != nil {
+				log.Printf("❌ Connection error: %v", err)
+				continue
+			}
+			go s.handleConnection(conn)
+		}
+	}()
+
+	// Wait for shutdown signal or a shutdown request on the control socket
+	select {
+	case <-sigChan:
+	case <-s.stop:
+	}
+	if s.pretty() {
+		fmt.Println()
+	}
+	s.mu.Lock()
+	upgraded := s.upgrade != nil
+	s.mu.Unlock()
+	if upgraded {
+		// Everyone drained is the new server's; only stragglers are left
+		for _, c := range s.snapshot() {
+			c.send("Server is restarting. Please reconnect.\n")
+			c.closeFor("server upgrade")
+		}
+		s.event("♻️  Handing over to the new server...")
+		return nil
+	}
+	s.event("🛑 Shutting down server gracefully...")
+	listener.Close()
+
+	// Close all client connections
+	for _, c := range s.snapshot() {
+		c.send("Server is shutting down. Goodbye!\n")
+		c.closeFor("server shutdown")
+	}
+
+	s.event("✅ Server shutdown complete")
+	return nil
+}
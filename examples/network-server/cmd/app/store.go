@@ -0,0 +1,275 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hashRounds is the number of SHA-256 rounds applied to salted passwords
+const hashRounds = 10000
+
+// User is a registered nickname
+type User struct {
+	Name       string    `json:"name"`
+	Salt       string    `json:"salt"`
+	Hash       string    `json:"hash"`
+	Op         bool      `json:"op,omitempty"` // may ban, unban and op others
+	Registered time.Time `json:"registered"`
+}
+
+// Ban keeps a nickname or address out of the server
+type Ban struct {
+	Target string    `json:"target"` // nickname, or "ip:" followed by the address
+	Reason string    `json:"reason,omitempty"`
+	By     string    `json:"by"`
+	At     time.Time `json:"at"`
+}
+
+// Room is a persistent chat room
+type Room struct {
+	Name      string    `json:"name"`
+	Topic     string    `json:"topic,omitempty"`
+	CreatedBy string    `json:"created_by"`
+	Created   time.Time `json:"created"`
+}
+
+// state is the on-disk form of the store
+type state struct {
+	Users map[string]*User `json:"users"`
+	Bans  map[string]*Ban  `json:"bans"`
+	Rooms map[string]*Room `json:"rooms"`
+}
+
+// Store keeps users, bans and rooms in a JSON file, rewritten atomically
+// after every change so a restart or power cut never loses or corrupts it
+type Store struct {
+	mu   sync.Mutex
+	path string // empty keeps the state in memory only
+	st   state
+}
+
+// OpenStore loads the store at path, starting empty if it doesn't exist
+func OpenStore(path string) (*Store, error) {
+	s := &Store{path: path, st: state{
+		Users: make(map[string]*User),
+		Bans:  make(map[string]*Ban),
+		Rooms: make(map[string]*Room),
+	}}
+	if path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.st); err != nil {
+		return nil, fmt.Errorf("invalid store %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// key normalises a nickname or room name for lookups
+func key(name string) string { return strings.ToLower(name) }
+
+// User returns the registered user with the given nickname
+func (s *Store) User(name string) (User, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.st.Users[key(name)]
+	if !ok {
+		return User{}, false
+	}
+	return *u, true
+}
+
+// Register claims a nickname with a password
+func (s *Store) Register(name, password string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.st.Users[key(name)]; ok {
+		return fmt.Errorf("%s is already registered", name)
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	s.st.Users[key(name)] = &User{
+		Name:       name,
+		Salt:       hex.EncodeToString(salt),
+		Hash:       hashPassword(salt, password),
+		Registered: time.Now(),
+	}
+	return s.save()
+}
+
+// CheckPassword reports whether password matches the registered nickname
+func (s *Store) CheckPassword(name, password string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.st.Users[key(name)]
+	if !ok {
+		return false
+	}
+	salt, err := hex.DecodeString(u.Salt)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashPassword(salt, password)), []byte(u.Hash)) == 1
+}
+
+// SetOp grants or revokes operator rights of a registered nickname
+func (s *Store) SetOp(name string, op bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.st.Users[key(name)]
+	if !ok {
+		return fmt.Errorf("%s is not registered", name)
+	}
+	if u.Op == op {
+		return nil
+	}
+	u.Op = op
+	return s.save()
+}
+
+// AddBan bans a nickname or, with an "ip:" prefix, an address
+func (s *Store) AddBan(b Ban) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b.At = time.Now()
+	s.st.Bans[key(b.Target)] = &b
+	return s.save()
+}
+
+// RemoveBan lifts a ban and reports whether there was one
+func (s *Store) RemoveBan(target string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.st.Bans[key(target)]; !ok {
+		return false, nil
+	}
+	delete(s.st.Bans, key(target))
+	return true, s.save()
+}
+
+// Banned returns the ban matching a nickname or address, if any
+func (s *Store) Banned(name, ip string) (Ban, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range []string{key(name), "ip:" + ip} {
+		if b, ok := s.st.Bans[k]; ok && k != "" {
+			return *b, true
+		}
+	}
+	return Ban{}, false
+}
+
+// Bans returns all bans sorted by target
+func (s *Store) Bans() []Ban {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bans := make([]Ban, 0, len(s.st.Bans))
+	for _, b := range s.st.Bans {
+		bans = append(bans, *b)
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].Target < bans[j].Target })
+	return bans
+}
+
+// Room returns a room definition
+func (s *Store) Room(name string) (Room, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.st.Rooms[key(name)]
+	if !ok {
+		return Room{}, false
+	}
+	return *r, true
+}
+
+// EnsureRoom creates a room unless it exists and returns its definition
+func (s *Store) EnsureRoom(name, by string) (Room, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r, ok := s.st.Rooms[key(name)]; ok {
+		return *r, nil
+	}
+	r := &Room{Name: name, CreatedBy: by, Created: time.Now()}
+	s.st.Rooms[key(name)] = r
+	return *r, s.save()
+}
+
+// SetTopic changes a room's topic
+func (s *Store) SetTopic(name, topic string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.st.Rooms[key(name)]
+	if !ok {
+		return fmt.Errorf("no room %s", name)
+	}
+	r.Topic = topic
+	return s.save()
+}
+
+// Rooms returns all rooms sorted by name
+func (s *Store) Rooms() []Room {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rooms := make([]Room, 0, len(s.st.Rooms))
+	for _, r := range s.st.Rooms {
+		rooms = append(rooms, *r)
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].Name < rooms[j].Name })
+	return rooms
+}
+
+// save writes the state to a temporary file and renames it into place;
+// s.mu must be held
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(&s.st, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".chat-state-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func hashPassword(salt []byte, password string) string {
+	sum := sha256.Sum256(append(salt, password...))
+	for i := 1; i < hashRounds; i++ {
+		sum = sha256.Sum256(sum[:])
+	}
+	return hex.EncodeToString(sum[:])
+}
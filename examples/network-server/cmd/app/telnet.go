@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Telnet protocol bytes (RFC 854) and the options we negotiate
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetWONT = 252
+	telnetDO   = 253
+	telnetDONT = 254
+	telnetIAC  = 255
+
+	optEcho  = 1  // RFC 857
+	optSGA   = 3  // suppress go-ahead, RFC 858
+	optTType = 24 // terminal type, RFC 1091
+
+	ttypeIs   = 0
+	ttypeSend = 1
+)
+
+// telnetConn speaks just enough telnet for line-based chat: it strips
+// option negotiation from the input, answers it, escapes output and sends
+// CRLF line endings, so real telnet clients see clean text. Clients that
+// never negotiate (nc) are unaffected apart from the initial offer.
+type telnetConn struct {
+	net.Conn
+
+	wmu sync.Mutex // serialises writes, including negotiation replies
+
+	// input parser state, only touched by Read
+	state byte
+	cmd   byte
+	sb    []byte
+
+	mu       sync.Mutex
+	termType string // reported terminal type, upper case
+	echoOff  bool
+}
+
+// parser states
+const (
+	stData = iota
+	stIAC
+	stOpt
+	stSB
+	stSBIAC
+)
+
+func newTelnetConn(conn net.Conn) *telnetConn {
+	t := &telnetConn{Conn: conn}
+	// Offer to suppress go-ahead and ask for the terminal type, which tells
+	// us whether the client understands ANSI colour
+	t.writeRaw([]byte{telnetIAC, telnetWILL, optSGA, telnetIAC, telnetDO, optTType})
+	return t
+}
+
+// Read returns client data with telnet commands removed
+func (t *telnetConn) Read(p []byte) (int, error) {
+	for {
+		n, err := t.Conn.Read(p)
+		out := t.filter(p[:n])
+		// Only hand back an empty read on error, so callers never see a
+		// spurious 0, nil when a packet held nothing but negotiation
+		if len(out) > 0 || err != nil {
+			return len(out), err
+		}
+	}
+}
+
+// filter removes telnet commands from p in place, answering them, and
+// returns the data left. The event loop calls it with what it reads.
+func (t *telnetConn) filter(p []byte) []byte {
+	out := p[:0]
+	for _, b := range p {
+		if c, ok := t.input(b); ok {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// input advances the parser by one byte and returns the data byte, if any
+func (t *telnetConn) input(b byte) (byte, bool) {
+	switch t.state {
+	case stIAC:
+		switch b {
+		case telnetIAC:
+			t.state = stData
+			return b, true
+		case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+			t.cmd, t.state = b, stOpt
+		case telnetSB:
+			t.sb, t.state = t.sb[:0], stSB
+		default: // NOP, GA, AYT and friends
+			t.state = stData
+		}
+		return 0, false
+	case stOpt:
+		t.state = stData
+		t.negotiate(t.cmd, b)
+		return 0, false
+	case stSB:
+		if b == telnetIAC {
+			t.state = stSBIAC
+		} else if len(t.sb) < 64 {
+			t.sb = append(t.sb, b)
+		}
+		return 0, false
+	case stSBIAC:
+		if b == telnetSE {
+			t.state = stData
+			t.subnegotiation(t.sb)
+		} else {
+			t.state = stSB
+			if len(t.sb) < 64 {
+				t.sb = append(t.sb, b)
+			}
+		}
+		return 0, false
+	}
+
+	switch b {
+	case telnetIAC:
+		t.state = stIAC
+		return 0, false
+	case 0: // telnet sends CR NUL for a bare carriage return
+		return 0, false
+	}
+	return b, true
+}
+
+// negotiate answers a WILL/WONT/DO/DONT from the client
+func (t *telnetConn) negotiate(cmd, opt byte) {
+	t.mu.Lock()
+	echoOff := t.echoOff
+	t.mu.Unlock()
+
+	switch {
+	case cmd == telnetWILL && opt == optTType:
+		t.writeRaw([]byte{telnetIAC, telnetSB, optTType, ttypeSend, telnetIAC, telnetSE})
+	case cmd == telnetDO && opt == optSGA:
+		// already offered
+	case cmd == telnetDO && opt == optEcho:
+		if !echoOff {
+			t.writeRaw([]byte{telnetIAC, telnetWONT, optEcho})
+		}
+	case cmd == telnetDONT && opt == optEcho:
+		// fine either way
+	case cmd == telnetDO:
+		t.writeRaw([]byte{telnetIAC, telnetWONT, opt})
+	case cmd == telnetWILL:
+		t.writeRaw([]byte{telnetIAC, telnetDONT, opt})
+	}
+}
+
+func (t *telnetConn) subnegotiation(sb []byte) {
+	if len(sb) > 1 && sb[0] == optTType && sb[1] == ttypeIs {
+		t.mu.Lock()
+		t.termType = strings.ToUpper(string(sb[2:]))
+		t.mu.Unlock()
+	}
+}
+
+// Write escapes IAC bytes and turns LF into CRLF
+func (t *telnetConn) Write(p []byte) (int, error) {
+	var buf bytes.Buffer
+	buf.Grow(len(p) + 16)
+	escape(&buf, p)
+	if _, err := t.writeRaw(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// WriteBuffers escapes bufs as Write does, in one write
+func (t *telnetConn) WriteBuffers(bufs net.Buffers) (int64, error) {
+	var buf bytes.Buffer
+	var n int64
+	for _, p := range bufs {
+		escape(&buf, p)
+		n += int64(len(p))
+	}
+	if _, err := t.writeRaw(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func escape(buf *bytes.Buffer, p []byte) {
+	for i, b := range p {
+		switch {
+		case b == telnetIAC:
+			buf.WriteByte(telnetIAC)
+		case b == '\n' && (i == 0 || p[i-1] != '\r'):
+			buf.WriteByte('\r')
+		}
+		buf.WriteByte(b)
+	}
+}
+
+func (t *telnetConn) writeRaw(p []byte) (int, error) {
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+	return t.Conn.Write(p)
+}
+
+// setConn makes t write to conn from now on, when the event loop takes
+// over the connection
+func (t *telnetConn) setConn(conn net.Conn) {
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+	t.Conn = conn
+}
+
+// SetEcho asks the client to stop (false) or resume (true) echoing what
+// the user types, e.g. around a password prompt
+func (t *telnetConn) SetEcho(on bool) {
+	t.mu.Lock()
+	t.echoOff = !on
+	t.mu.Unlock()
+	if on {
+		t.writeRaw([]byte{telnetIAC, telnetWONT, optEcho})
+	} else {
+		t.writeRaw([]byte{telnetIAC, telnetWILL, optEcho})
+	}
+}
+
+// TerminalType returns the terminal type the client reported, if any
+func (t *telnetConn) TerminalType() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.termType
+}
+
+// Color reports whether the client's terminal is known to support ANSI
+// colour. Clients that don't speak telnet get plain text.
+func (t *telnetConn) Color() bool {
+	term := t.TerminalType()
+	for _, prefix := range []string{"XTERM", "ANSI", "VT100", "VT220", "LINUX", "SCREEN", "TMUX", "RXVT"} {
+		if strings.HasPrefix(term, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ANSI escape sequences for the chat's colours
+const (
+	ansiReset  = "\x1b[0m"
+	ansiSystem = "\x1b[33m"   // yellow: joins, leaves, topics
+	ansiName   = "\x1b[1;36m" // bold cyan: the sender of a message
+)
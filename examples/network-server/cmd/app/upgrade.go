@@ -0,0 +1,520 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"riscv-dev/pkg/buildinfo"
+)
+
+// In-place upgrades replace the running server with its executable as it
+// now is on disk (e.g. after an OTA update) without dropping anyone. The
+// old process starts the new one, passing it the listening socket, so
+// connections arriving meanwhile wait in the listen backlog. Once the new
+// process reports ready, the old one stops reading from its clients,
+// broadcasts what they already sent, and hands over each connection over
+// a Unix socket, with the client's name, room, telnet state and any input
+// or output still in flight. The new process carries on where the old one
+// left off, and the old one exits.
+//
+// Handover protocol, one JSON message per SOCK_SEQPACKET packet:
+//
+//	new → old: ready
+//	old → new: client, with its socket attached; once per client
+//	old → new: done
+//	new → old: adopted
+const upgradeEnv = "RISCV_CHAT_UPGRADE"
+
+// Files the new process inherits after stdin, stdout and stderr
+const (
+	upgradeListenerFD = 3
+	upgradeSocketFD   = 4
+)
+
+const (
+	// upgradeReadyTimeout bounds the new process's start, and is under
+	// chatctl's 10s wait for an answer
+	upgradeReadyTimeout = 8 * time.Second
+	// upgradeDrainTimeout bounds the wait for client readers to stop
+	upgradeDrainTimeout = 5 * time.Second
+	// upgradeHandoverTimeout bounds the whole handover as the new process
+	// sees it, including the old one closing its event export
+	upgradeHandoverTimeout = time.Minute
+)
+
+// errUpgrade ends a client's reader so its connection can be handed over
+var errUpgrade = errors.New("handed over to a new server")
+
+// handoverMsg is one message of the handover protocol
+type handoverMsg struct {
+	Type    string          `json:"type"` // ready, client, done or adopted
+	Pid     int             `json:"pid,omitempty"`
+	Version string          `json:"version,omitempty"`
+	Client  *handoverClient `json:"client,omitempty"`
+	Count   int             `json:"count,omitempty"` // clients adopted
+}
+
+// handoverClient is what the new process needs to carry on serving a
+// client
+type handoverClient struct {
+	Name       string    `json:"name"`
+	Addr       string    `json:"addr"`
+	IP         string    `json:"ip"`
+	Room       string    `json:"room"`
+	Op         bool      `json:"op,omitempty"`
+	Color      bool      `json:"color,omitempty"`
+	Since      time.Time `json:"since"`
+	Telnet     bool      `json:"telnet,omitempty"`
+	TermType   string    `json:"term_type,omitempty"`
+	EchoOff    bool      `json:"echo_off,omitempty"`
+	Input      []byte    `json:"input,omitempty"`      // read but not yet a whole line
+	Discarding bool      `json:"discarding,omitempty"` // in the rest of an over-long line
+	Output     []byte    `json:"output,omitempty"`     // queued for the socket, already encoded
+}
+
+// handoff is a client whose reader stopped for an upgrade
+type handoff struct {
+	c     *client
+	raw   net.Conn  // the accepted connection, for a client on a goroutine
+	lc    *loopConn // or its connection in the event loop
+	tc    *telnetConn
+	input *lineReader
+}
+
+// upgrade is an upgrade under way in the old process
+type upgrade struct {
+	conn     *net.UnixConn
+	pid      int
+	handoffs []handoff
+}
+
+// takeover is what the new process inherited
+type takeover struct {
+	listener net.Listener
+	clients  []inherited
+	from     int // the old process
+}
+
+type inherited struct {
+	state handoverClient
+	fd    int
+}
+
+// Upgrade starts the server's executable to take over from this process.
+// It returns once the new process is ready, or with an error and the
+// server carrying on as before; the handover itself happens in the
+// background and ends with startServer returning.
+func (s *Server) Upgrade() error {
+	if !s.upgrading.CompareAndSwap(false, true) {
+		return errors.New("an upgrade is already under way")
+	}
+	conn, pid, err := s.startUpgrade()
+	if err != nil {
+		s.upgrading.Store(false)
+		return err
+	}
+	go func() {
+		handoffs := s.drain()
+		s.mu.Lock()
+		s.upgrade = &upgrade{conn: conn, pid: pid, handoffs: handoffs}
+		s.mu.Unlock()
+		s.Shutdown()
+	}()
+	return nil
+}
+
+// startUpgrade starts the new process and waits for it to be ready
+func (s *Server) startUpgrade() (*net.UnixConn, int, error) {
+	tl, ok := s.listener.(*net.TCPListener)
+	if !ok {
+		return nil, 0, errors.New("not listening on TCP")
+	}
+	lf, err := tl.File()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer lf.Close()
+	ours, theirs, err := socketPair()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer theirs.Close()
+	fc, err := net.FileConn(ours)
+	ours.Close()
+	if err != nil {
+		return nil, 0, err
+	}
+	conn := fc.(*net.UnixConn)
+
+	exe, err := os.Executable()
+	if err != nil {
+		conn.Close()
+		return nil, 0, err
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), upgradeEnv+"=1")
+	cmd.ExtraFiles = []*os.File{lf, theirs} // upgradeListenerFD, upgradeSocketFD
+	if err := cmd.Start(); err != nil {
+		conn.Close()
+		return nil, 0, err
+	}
+	theirs.Close()
+	s.event("♻️  Upgrade: started %s (pid %d)", exe, cmd.Process.Pid)
+
+	conn.SetDeadline(time.Now().Add(upgradeReadyTimeout))
+	var ready handoverMsg
+	_, err = receiveHandover(conn, &ready)
+	if err == nil && ready.Type != "ready" {
+		err = fmt.Errorf("unexpected %q", ready.Type)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		conn.Close()
+		return nil, 0, fmt.Errorf("new server did not start: %w", err)
+	}
+	conn.SetDeadline(time.Time{})
+	go cmd.Wait() // reaped here only if this process outlives it
+	s.event("♻️  Upgrade: %s is ready, handing over", ready.Version)
+	return conn, cmd.Process.Pid, nil
+}
+
+// socketPair returns the two ends of a connected SOCK_SEQPACKET socket
+func socketPair() (*os.File, *os.File, error) {
+	syscall.ForkLock.RLock()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_SEQPACKET, 0)
+	if err == nil {
+		syscall.CloseOnExec(fds[0])
+		syscall.CloseOnExec(fds[1])
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return nil, nil, fmt.Errorf("socketpair: %w", err)
+	}
+	return os.NewFile(uintptr(fds[0]), "handover"), os.NewFile(uintptr(fds[1]), "handover"), nil
+}
+
+// drain stops accepting and reading from clients, waits until what they
+// sent has been broadcast, and returns them. Clients whose readers don't
+// stop in time stay behind and are disconnected at shutdown.
+func (s *Server) drain() []handoff {
+	s.draining.Store(true)
+	s.listener.Close() // the new process has its own copy
+
+	var handoffs []handoff
+	got := make(map[*client]bool)
+	if s.loop != nil {
+		for _, h := range s.loop.release() {
+			handoffs = append(handoffs, h)
+			got[h.c] = true
+		}
+	}
+	// A reader may set a fresh deadline just after this one, so repeat it
+	// until every reader has stopped
+	deadline := time.Now().Add(upgradeDrainTimeout)
+	for time.Now().Before(deadline) {
+		waiting := 0
+		for _, c := range s.snapshot() {
+			if !got[c] {
+				c.mu.Lock()
+				c.conn.SetReadDeadline(time.Now())
+				c.mu.Unlock()
+				waiting++
+			}
+		}
+		if waiting == 0 {
+			break
+		}
+		select {
+		case h := <-s.handoffs:
+			handoffs = append(handoffs, h)
+			got[h.c] = true
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	s.flush()
+	s.mu.Lock()
+	for _, h := range handoffs {
+		delete(s.clients, h.c.conn)
+	}
+	s.mu.Unlock()
+	return handoffs
+}
+
+// flush waits until the messages queued so far have been broadcast
+func (s *Server) flush() {
+	done := make(chan struct{})
+	s.messages <- message{flushed: done}
+	<-done
+}
+
+// handOver sends the drained clients to the new process, once everything
+// else has shut down so it can take over the control and event sockets.
+// startServer defers it first, so it runs last.
+func (s *Server) handOver() {
+	s.mu.Lock()
+	up := s.upgrade
+	s.mu.Unlock()
+	if up == nil {
+		return
+	}
+	defer up.conn.Close()
+	sent := 0
+	for _, h := range up.handoffs {
+		state, fd, err := h.state()
+		if err != nil {
+			log.Printf("❌ Upgrade: %s (%s) not handed over: %v", h.c.name, h.c.addr, err)
+			continue
+		}
+		err = sendHandover(up.conn, handoverMsg{Type: "client", Client: &state}, fd)
+		syscall.Close(fd)
+		if err != nil {
+			log.Printf("❌ Upgrade: handing over to pid %d: %v", up.pid, err)
+			return
+		}
+		sent++
+	}
+	if err := sendHandover(up.conn, handoverMsg{Type: "done"}, -1); err != nil {
+		log.Printf("❌ Upgrade: handing over to pid %d: %v", up.pid, err)
+		return
+	}
+	up.conn.SetDeadline(time.Now().Add(upgradeReadyTimeout))
+	var ack handoverMsg
+	if _, err := receiveHandover(up.conn, &ack); err != nil || ack.Type != "adopted" {
+		log.Printf("⚠️  Upgrade: pid %d did not confirm the handover: %v", up.pid, err)
+		return
+	}
+	s.event("✅ Upgrade: handed %d of %d clients to pid %d", ack.Count, sent, up.pid)
+}
+
+// state captures h's client for the new process, with a duplicate of its
+// socket. Nothing is written to the client here afterwards.
+func (h handoff) state() (handoverClient, int, error) {
+	c := h.c
+	c.mu.Lock()
+	st := handoverClient{Name: c.name, Addr: c.addr, IP: c.ip, Room: c.room, Op: c.op, Color: c.color, Since: c.since}
+	c.mu.Unlock()
+	if h.tc != nil {
+		h.tc.mu.Lock()
+		st.Telnet, st.TermType, st.EchoOff = true, h.tc.termType, h.tc.echoOff
+		h.tc.mu.Unlock()
+	}
+	st.Input = append([]byte(nil), h.input.buf...)
+	st.Discarding = h.input.split.discarding
+
+	if h.lc != nil {
+		h.lc.wmu.Lock()
+		defer h.lc.wmu.Unlock()
+		if h.lc.closed {
+			return st, -1, errors.New("disconnected")
+		}
+		// The loop has stopped, so the socket is the loop's alone to give
+		h.lc.closed = true
+		st.Output, h.lc.out = h.lc.out, nil
+		fd, err := syscall.Dup(h.lc.fd)
+		return st, fd, err
+	}
+	fd, err := dupFD(h.raw)
+	return st, fd, err
+}
+
+// dupFD returns a non-blocking duplicate of conn's socket, which stays
+// open when conn is closed
+func dupFD(conn net.Conn) (int, error) {
+	if w, ok := conn.(interface{ NetConn() net.Conn }); ok {
+		conn = w.NetConn()
+	}
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return -1, errors.New("not a socket")
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+	fd := -1
+	var dupErr error
+	err = rc.Control(func(orig uintptr) {
+		syscall.ForkLock.RLock()
+		defer syscall.ForkLock.RUnlock()
+		if fd, dupErr = syscall.Dup(int(orig)); dupErr == nil {
+			syscall.CloseOnExec(fd)
+		}
+	})
+	if err == nil {
+		err = dupErr
+	}
+	if err == nil {
+		err = syscall.SetNonblock(fd, true)
+	}
+	if err != nil {
+		if fd >= 0 {
+			syscall.Close(fd)
+		}
+		return -1, err
+	}
+	return fd, nil
+}
+
+// sendHandover sends msg, with fd attached unless it is -1
+func sendHandover(conn *net.UnixConn, msg handoverMsg, fd int) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	var oob []byte
+	if fd >= 0 {
+		oob = syscall.UnixRights(fd)
+	}
+	_, _, err = conn.WriteMsgUnix(b, oob, nil)
+	return err
+}
+
+// receiveHandover reads a message into msg and returns the descriptor
+// attached to it, or -1
+func receiveHandover(conn *net.UnixConn, msg *handoverMsg) (int, error) {
+	buf := make([]byte, 64<<10)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return -1, err
+	}
+	if n == 0 {
+		return -1, errors.New("connection closed")
+	}
+	fd := -1
+	if oobn > 0 {
+		cmsgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+		if err == nil && len(cmsgs) > 0 {
+			if fds, err := syscall.ParseUnixRights(&cmsgs[0]); err == nil && len(fds) > 0 {
+				fd = fds[0]
+				syscall.CloseOnExec(fd)
+				for _, extra := range fds[1:] {
+					syscall.Close(extra)
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal(buf[:n], msg); err != nil {
+		if fd >= 0 {
+			syscall.Close(fd)
+		}
+		return -1, err
+	}
+	return fd, nil
+}
+
+// takeOver is called at startup in a process started by Upgrade: it
+// reports ready, then receives the listener and clients of the old
+// process, which exits
+func takeOver() (*takeover, error) {
+	os.Unsetenv(upgradeEnv)
+	lf := os.NewFile(upgradeListenerFD, "listener")
+	ln, err := net.FileListener(lf)
+	lf.Close()
+	if err != nil {
+		return nil, fmt.Errorf("inherited listener: %w", err)
+	}
+	hf := os.NewFile(upgradeSocketFD, "handover")
+	fc, err := net.FileConn(hf)
+	hf.Close()
+	if err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("handover socket: %w", err)
+	}
+	conn, ok := fc.(*net.UnixConn)
+	if !ok {
+		fc.Close()
+		ln.Close()
+		return nil, errors.New("handover socket is not a Unix socket")
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(upgradeHandoverTimeout))
+	t := &takeover{listener: ln, from: os.Getppid()}
+	if err := sendHandover(conn, handoverMsg{Type: "ready", Pid: os.Getpid(), Version: buildinfo.Get().String()}, -1); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("handover: %w", err)
+	}
+	for {
+		var msg handoverMsg
+		fd, err := receiveHandover(conn, &msg)
+		if err != nil {
+			// The old process is gone: carry on with what it sent
+			log.Printf("⚠️  Upgrade: handover from pid %d ended early: %v", t.from, err)
+			return t, nil
+		}
+		switch {
+		case msg.Type == "client" && msg.Client != nil && fd >= 0:
+			t.clients = append(t.clients, inherited{state: *msg.Client, fd: fd})
+			continue
+		case msg.Type == "done":
+			// The service manager follows this process from now on
+			sdNotify(fmt.Sprintf("MAINPID=%d", os.Getpid()))
+			sendHandover(conn, handoverMsg{Type: "adopted", Count: len(t.clients)}, -1)
+			return t, nil
+		}
+		if fd >= 0 {
+			syscall.Close(fd)
+		}
+	}
+}
+
+// resume serves a client inherited from the old process
+func (s *Server) resume(in inherited) {
+	st := in.state
+	f := os.NewFile(uintptr(in.fd), "client")
+	raw, err := net.FileConn(f)
+	f.Close()
+	if err != nil {
+		log.Printf("❌ Upgrade: %s (%s) not taken over: %v", st.Name, st.Addr, err)
+		return
+	}
+	if len(st.Output) > 0 {
+		raw.Write(st.Output)
+	}
+	var conn net.Conn = raw
+	var tc *telnetConn
+	if st.Telnet {
+		// Negotiated already, so no offer this time
+		tc = &telnetConn{Conn: raw, termType: st.TermType, echoOff: st.EchoOff}
+		conn = tc
+	}
+	conn = newTimeoutConn(conn, s.opts.IdleTimeout, s.opts.IdleWarning, &s.draining)
+	input := newLineReader(conn, s.opts.MaxLine)
+	input.Feed(st.Input)
+	input.split.discarding = st.Discarding
+
+	c := &client{conn: conn, telnet: tc, name: st.Name, addr: st.Addr, ip: st.IP, since: st.Since,
+		room: st.Room, op: st.Op, color: st.Color}
+	if !s.add(c) {
+		fmt.Fprintf(conn, "%s is already connected.\n", c.name)
+		raw.Close()
+		return
+	}
+	go s.serve(c, raw, tc, input)
+}
+
+// sdNotify sends state to systemd if it started the server with
+// NotifyAccess set, so it follows the new process after an upgrade
+func sdNotify(state string) {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return
+	}
+	conn, err := net.Dial("unixgram", path)
+	if err != nil {
+		log.Printf("⚠️  Notifying systemd: %v", err)
+		return
+	}
+	defer conn.Close()
+	conn.Write([]byte(state))
+}
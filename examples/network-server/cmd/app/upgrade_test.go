@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestHandover passes a client's state and socket over the handover
+// protocol and checks the socket still reaches the same peer
+func TestHandover(t *testing.T) {
+	ours, theirs, err := socketPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := unixConn(t, ours)
+	defer old.Close()
+	next := unixConn(t, theirs)
+	defer next.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	peer, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd, err := dupFD(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close() // the duplicate keeps the connection open
+
+	state := handoverClient{Name: "alice", Room: "garden", Telnet: true, Input: []byte("half a li"), Output: []byte("unsent\r\n")}
+	if err := sendHandover(old, handoverMsg{Type: "client", Client: &state}, fd); err != nil {
+		t.Fatal(err)
+	}
+	syscall.Close(fd)
+	if err := sendHandover(old, handoverMsg{Type: "done"}, -1); err != nil {
+		t.Fatal(err)
+	}
+
+	var msg handoverMsg
+	got, err := receiveHandover(next, &msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "client" || msg.Client == nil || msg.Client.Name != "alice" || string(msg.Client.Input) != "half a li" || got < 0 {
+		t.Fatalf("received %+v with fd %d", msg, got)
+	}
+	f := os.NewFile(uintptr(got), "client")
+	adopted, err := net.FileConn(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adopted.Close()
+	if got, err := receiveHandover(next, &msg); err != nil || msg.Type != "done" || got != -1 {
+		t.Fatalf("received %+v with fd %d: %v", msg, got, err)
+	}
+
+	adopted.Write([]byte("hello\n"))
+	peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 16)
+	n, err := peer.Read(buf)
+	if err != nil || string(buf[:n]) != "hello\n" {
+		t.Errorf("peer read %q, %v", buf[:n], err)
+	}
+}
+
+func unixConn(t *testing.T, f *os.File) *net.UnixConn {
+	t.Helper()
+	defer f.Close()
+	c, err := net.FileConn(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.(*net.UnixConn)
+}
@@ -0,0 +1,358 @@
+// chat-loadgen puts the chat server under load: it connects N clients that
+// each send messages at a fixed rate, and measures how long broadcasts
+// take to reach the other clients.
+//
+//	chat-loadgen -addr board:8080 -clients 50 -rate 2 -duration 1m
+//
+// Every client receives every message in its room, including its own, so
+// the server delivers clients² × rate lines per second. -idle adds clients
+// that log in and only listen, to see what many quiet connections cost.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"riscv-dev/pkg/buildinfo"
+	"riscv-network-server/internal/bench"
+)
+
+// marker starts every load message so receivers can find them
+const marker = "lg:"
+
+// stats collects results from all clients
+type stats struct {
+	sent      atomic.Int64
+	received  atomic.Int64
+	connected atomic.Int64
+	idle      atomic.Int64 // idle clients logged in
+
+	mu        sync.Mutex
+	latencies []time.Duration
+	errors    map[string]int
+}
+
+func (s *stats) fail(kind string) {
+	s.mu.Lock()
+	s.errors[kind]++
+	s.mu.Unlock()
+}
+
+func (s *stats) observe(d time.Duration) {
+	s.received.Add(1)
+	s.mu.Lock()
+	s.latencies = append(s.latencies, d)
+	s.mu.Unlock()
+}
+
+type options struct {
+	addr     string
+	rate     float64
+	size     int
+	room     string
+	prefix   string
+	duration time.Duration
+}
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8080", "chat server address")
+	clients := flag.Int("clients", 20, "number of concurrent clients")
+	idle := flag.Int("idle", 0, "extra clients that log in and only listen")
+	rate := flag.Float64("rate", 1, "messages per second per client")
+	size := flag.Int("size", 64, "message size in bytes")
+	duration := flag.Duration("duration", 30*time.Second, "how long to send messages")
+	ramp := flag.Duration("ramp", 5*time.Second, "spread client connections over this time")
+	room := flag.String("room", "", "room to join (default: the server's lobby)")
+	prefix := flag.String("prefix", "load", "client name prefix")
+	buildinfo.RegisterFlag(flag.CommandLine)
+	flag.Parse()
+
+	if *clients < 1 || *idle < 0 || *rate <= 0 || *size < 32 {
+		fmt.Fprintln(os.Stderr, "❌ need -clients >= 1, -idle >= 0, -rate > 0 and -size >= 32")
+		os.Exit(2)
+	}
+	bench.RaiseFileLimit() // for thousands of -idle clients
+
+	opts := options{addr: *addr, rate: *rate, size: *size, room: *room, prefix: *prefix, duration: *duration}
+	st := &stats{errors: make(map[string]int)}
+
+	fmt.Printf("🚀 %d clients → %s, %.1f msg/s each, %d bytes, for %v\n", *clients, *addr, *rate, *size, *duration)
+	if *idle > 0 {
+		fmt.Printf("💤 %d idle clients\n", *idle)
+	}
+
+	begin := make(chan struct{})
+	stop := make(chan struct{})
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+
+	// Idle clients ramp up alongside the others
+	var wg sync.WaitGroup
+	start := time.Now()
+	launch := func(n int, run func(i int)) {
+		for i := 0; i < n; i++ {
+			i := i
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				delay := time.Duration(0)
+				if n > 1 {
+					delay = *ramp * time.Duration(i) / time.Duration(n-1)
+				}
+				select {
+				case <-time.After(delay):
+				case <-stop:
+					return
+				}
+				run(i)
+			}()
+		}
+	}
+	launch(*clients, func(i int) { runClient(i, opts, st, begin, stop) })
+	launch(*idle, func(i int) { runIdle(i, opts, st, stop) })
+
+	// Report progress until the run ends or is interrupted
+	rampDone := time.After(*ramp + time.Second)
+	end := time.After(*ramp + time.Second + *duration + 2*time.Second)
+	receivers := int64(0)
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	var lastSent, lastReceived int64
+loop:
+	for {
+		select {
+		case <-ticker.C:
+			sent, received := st.sent.Load(), st.received.Load()
+			fmt.Printf("⏱️  %4.0fs  %d connected  sent %d (+%d)  received %d (+%d)\n",
+				time.Since(start).Seconds(), st.connected.Load(), sent, sent-lastSent, received, received-lastReceived)
+			lastSent, lastReceived = sent, received
+		case <-rampDone:
+			receivers = st.connected.Load()
+			if *idle > 0 {
+				fmt.Printf("💤 %d of %d idle clients connected\n", st.idle.Load(), *idle)
+			}
+			fmt.Printf("✅ %d of %d clients connected, sending\n", receivers, *clients)
+			close(begin)
+		case <-end:
+			break loop
+		case <-sig:
+			fmt.Println("\n🛑 Interrupted")
+			break loop
+		}
+	}
+
+	close(stop)
+	wg.Wait()
+	report(st, *clients, receivers)
+}
+
+// runClient logs in, sends messages at the configured rate and records the
+// latency of every load message it receives
+func runClient(id int, opts options, st *stats, begin, stop <-chan struct{}) {
+	name := fmt.Sprintf("%s-%d", opts.prefix, id)
+	conn, err := net.DialTimeout("tcp", opts.addr, 5*time.Second)
+	if err != nil {
+		st.fail("connect")
+		return
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	fmt.Fprintf(conn, "%s\r\n", name)
+	if opts.room != "" {
+		fmt.Fprintf(conn, "/join %s\r\n", opts.room)
+	}
+	if !waitFor(conn, reader, "You are in "+opts.room) {
+		st.fail("login")
+		return
+	}
+	st.connected.Add(1)
+	defer st.connected.Add(-1)
+
+	// Receiver; closing is set when we hang up ourselves
+	var closing atomic.Bool
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				if !closing.Load() {
+					st.fail("disconnected")
+				}
+				return
+			}
+			if i := strings.Index(line, marker); i >= 0 {
+				fields := strings.Fields(line[i+len(marker):])
+				if len(fields) > 0 {
+					if ns, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+						st.observe(time.Since(time.Unix(0, ns)))
+					}
+				}
+			}
+		}
+	}()
+
+	// Sender: once every client has had the chance to connect, starting at
+	// a random offset so clients don't send in lockstep
+	select {
+	case <-begin:
+	case <-stop:
+		closing.Store(true)
+		conn.Close()
+		<-done
+		return
+	}
+	interval := time.Duration(float64(time.Second) / opts.rate)
+	pad := strings.Repeat("x", opts.size)
+	timer := time.NewTimer(time.Duration(rand.Int63n(int64(interval))))
+	defer timer.Stop()
+	deadline := time.After(opts.duration)
+	for {
+		select {
+		case <-timer.C:
+			msg := fmt.Sprintf("%s%d %s", marker, time.Now().UnixNano(), name)
+			if len(msg) < opts.size {
+				msg += " " + pad[:opts.size-len(msg)-1]
+			}
+			conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+			if _, err := fmt.Fprintf(conn, "%s\r\n", msg); err != nil {
+				st.fail("write")
+				return
+			}
+			st.sent.Add(1)
+			timer.Reset(interval)
+		case <-deadline:
+			// Give in-flight broadcasts a moment to arrive
+			time.Sleep(time.Second)
+			closing.Store(true)
+			conn.Close()
+			<-done
+			return
+		case <-stop:
+			closing.Store(true)
+			conn.Close()
+			<-done
+			return
+		case <-done:
+			return
+		}
+	}
+}
+
+// runIdle logs in and listens without sending until the run ends
+func runIdle(id int, opts options, st *stats, stop <-chan struct{}) {
+	conn, err := net.DialTimeout("tcp", opts.addr, 5*time.Second)
+	if err != nil {
+		st.fail("connect")
+		return
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	fmt.Fprintf(conn, "%s-idle-%d\r\n", opts.prefix, id)
+	if opts.room != "" {
+		fmt.Fprintf(conn, "/join %s\r\n", opts.room)
+	}
+	if !waitFor(conn, reader, "You are in "+opts.room) {
+		st.fail("login")
+		return
+	}
+	st.idle.Add(1)
+	defer st.idle.Add(-1)
+
+	var closing atomic.Bool
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		io.Copy(io.Discard, reader)
+		if !closing.Load() {
+			st.fail("disconnected")
+		}
+	}()
+	select {
+	case <-stop:
+		closing.Store(true)
+		conn.Close()
+		<-done
+	case <-done:
+	}
+}
+
+// waitFor reads until a line containing want arrives, for up to 10s
+func waitFor(conn net.Conn, r *bufio.Reader, want string) bool {
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return false
+		}
+		if strings.Contains(line, want) {
+			return true
+		}
+	}
+}
+
+// report prints the results; every message should reach each of the
+// receivers that were connected when sending began
+func report(st *stats, clients int, receivers int64) {
+	sent, received := st.sent.Load(), st.received.Load()
+	expected := sent * receivers
+
+	fmt.Printf("\n📊 RESULTS\n")
+	fmt.Printf("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━\n")
+	fmt.Printf("Messages sent:      %d\n", sent)
+	fmt.Printf("Deliveries:         %d of %d expected", received, expected)
+	if expected > 0 {
+		fmt.Printf(" (%.2f%% missing)", 100*float64(expected-received)/float64(expected))
+	}
+	fmt.Println()
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if n := len(st.latencies); n > 0 {
+		sort.Slice(st.latencies, func(i, j int) bool { return st.latencies[i] < st.latencies[j] })
+		pct := func(p float64) time.Duration { return st.latencies[int(p*float64(n-1))] }
+		fmt.Printf("Broadcast latency:  p50 %v  p90 %v  p99 %v  max %v\n",
+			round(pct(0.50)), round(pct(0.90)), round(pct(0.99)), round(st.latencies[n-1]))
+	}
+
+	if len(st.errors) == 0 {
+		fmt.Println("Errors:             none")
+		return
+	}
+	kinds := make([]string, 0, len(st.errors))
+	for k := range st.errors {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	fmt.Printf("Errors:            ")
+	for _, k := range kinds {
+		fmt.Printf(" %s %d (%.1f%% of clients)", k, st.errors[k], 100*float64(st.errors[k])/float64(clients))
+	}
+	fmt.Println()
+}
+
+func round(d time.Duration) time.Duration {
+	switch {
+	case d > time.Second:
+		return d.Round(time.Millisecond)
+	case d > time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	}
+	return d.Round(time.Microsecond)
+}
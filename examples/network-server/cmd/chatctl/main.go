@@ -0,0 +1,164 @@
+// chatctl administers a running network-server through its local control
+// socket:
+//
+//	chatctl status
+//	chatctl broadcast [-room lobby] "Rebooting in 5 minutes"
+//	chatctl shutdown
+//	chatctl events [-types join,leave]
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"riscv-dev/pkg/buildinfo"
+	"riscv-network-server/internal/chatevents"
+	"riscv-network-server/internal/control"
+)
+
+func main() {
+	socket := flag.String("socket", control.DefaultSocket, "control socket of the server")
+	asJSON := flag.Bool("json", false, "print the raw response")
+	flag.Usage = usage
+	buildinfo.RegisterFlag(flag.CommandLine)
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	if flag.Arg(0) == "events" {
+		fs := flag.NewFlagSet("events", flag.ExitOnError)
+		from := fs.String("from", chatevents.DefaultSocket, "event socket of the server")
+		types := fs.String("types", "", "comma-separated event types to show (default all)")
+		fs.Parse(flag.Args()[1:])
+		followEvents(*from, *types, *asJSON)
+		return
+	}
+
+	req := control.Request{Command: flag.Arg(0)}
+	switch req.Command {
+	case control.CmdStatus, control.CmdShutdown, control.CmdUpgrade:
+	case control.CmdBroadcast:
+		fs := flag.NewFlagSet("broadcast", flag.ExitOnError)
+		room := fs.String("room", "", "only announce in this room")
+		fs.Parse(flag.Args()[1:])
+		req.Room, req.Text = *room, strings.Join(fs.Args(), " ")
+		if req.Text == "" {
+			fmt.Fprintln(os.Stderr, "❌ broadcast needs a message")
+			os.Exit(2)
+		}
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	resp, err := control.Call(*socket, req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %s: %v\n", req.Command, err)
+		os.Exit(1)
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(resp)
+		return
+	}
+
+	switch req.Command {
+	case control.CmdStatus:
+		printStatus(resp.Status)
+	case control.CmdBroadcast:
+		fmt.Println("✅ Broadcast sent")
+	case control.CmdShutdown:
+		fmt.Println("✅ Server is shutting down")
+	case control.CmdUpgrade:
+		fmt.Println("✅ New server started; clients are being handed over")
+	}
+}
+
+func printStatus(st *control.Status) {
+	fmt.Printf("🌐 Listening on %s, up %v\n", st.Listen, time.Since(st.Started).Round(time.Second))
+	fmt.Printf("📦 %s\n", st.Build)
+	fmt.Printf("💬 %d messages, %d rooms, %d deliveries in %d writes\n", st.Messages, st.Rooms, st.Sent, st.Writes)
+	rt := st.Runtime
+	fmt.Printf("⚙️  %s: %d goroutines, %s resident (heap %s, stacks %s), %v CPU\n",
+		rt.Mode, rt.Goroutines, mib(rt.Resident), mib(rt.Heap), mib(rt.Stacks), rt.CPU.Round(10*time.Millisecond))
+	fmt.Printf("👤 %d clients:\n", len(st.Clients))
+	for _, c := range st.Clients {
+		op := ""
+		if c.Op {
+			op = " (op)"
+		}
+		fmt.Printf("  %-24s %-12s %-21s connected %v ago%s\n",
+			c.Name, c.Room, c.Addr, time.Since(c.Since).Round(time.Second), op)
+	}
+}
+
+// followEvents prints the server's events as they happen until it stops
+func followEvents(path, types string, asJSON bool) {
+	stream, err := chatevents.Subscribe(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ events: %v\n", err)
+		os.Exit(1)
+	}
+	defer stream.Close()
+	show := make(map[string]bool)
+	for _, t := range strings.Split(types, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			show[t] = true
+		}
+	}
+	enc := json.NewEncoder(os.Stdout)
+	for {
+		ev, err := stream.Next()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				fmt.Fprintf(os.Stderr, "❌ events: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+		if len(show) > 0 && !show[ev.Type] {
+			continue
+		}
+		if asJSON {
+			enc.Encode(ev)
+			continue
+		}
+		line := fmt.Sprintf("%s %-10s %s", ev.Time.Format("15:04:05"), ev.Type, ev.Name)
+		for _, field := range []struct{ label, value string }{
+			{"addr", ev.Addr}, {"room", ev.Room}, {"by", ev.By}, {"text", ev.Text}, {"error", ev.Error},
+		} {
+			if field.value != "" {
+				line += fmt.Sprintf(" %s=%q", field.label, field.value)
+			}
+		}
+		fmt.Println(line)
+	}
+}
+
+func mib(n uint64) string {
+	return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: chatctl [-socket path] [-json] <command>
+
+Commands:
+  status                        show connected clients and counters
+  broadcast [-room name] <text> announce text to everyone, or one room
+  shutdown                      disconnect everyone and stop the server
+  upgrade                       restart with the executable on disk, keeping everyone connected
+  events [-from path] [-types a,b]
+                                follow server events, as JSON lines with -json
+
+`)
+	flag.PrintDefaults()
+}
@@ -0,0 +1,123 @@
+// net-bench measures a board's network stack against the network server's
+// benchmark mode: TCP throughput either way, connection setup rate, and
+// how many connections the board holds open at once.
+//
+//	app -bench :5201                   # on the board
+//	net-bench -addr board:5201 -streams 4
+//
+// Run it from a faster machine on the same link, so the board is what
+// gets measured, and keep -json reports to compare boards, kernels and
+// drivers.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"riscv-dev/pkg/buildinfo"
+	"riscv-network-server/internal/bench"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:"+bench.DefaultPort, "benchmark server address")
+	duration := flag.Duration("duration", 10*time.Second, "how long each throughput test runs")
+	streams := flag.Int("streams", 1, "parallel connections in the throughput tests")
+	workers := flag.Int("workers", 8, "parallel connectors in the connection setup test")
+	connectFor := flag.Duration("connect-duration", 5*time.Second, "how long the connection setup test runs")
+	maxConns := flag.Int("max-conns", 10000, "stop the concurrent connections test at this many")
+	tests := flag.String("tests", "upload,download,connect,hold", "comma-separated tests to run")
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	buildinfo.RegisterFlag(flag.CommandLine)
+	flag.Parse()
+
+	if *duration <= 0 || *connectFor <= 0 || *streams < 1 || *workers < 1 || *maxConns < 1 {
+		fmt.Fprintln(os.Stderr, "❌ need positive -duration, -connect-duration, -streams, -workers and -max-conns")
+		os.Exit(2)
+	}
+	run := make(map[string]bool)
+	for _, t := range strings.Split(*tests, ",") {
+		switch t = strings.TrimSpace(t); t {
+		case bench.TestUpload, bench.TestDownload, bench.TestConnect, bench.TestHold:
+			run[t] = true
+		case "":
+		default:
+			fmt.Fprintf(os.Stderr, "❌ unknown test %q (want upload, download, connect or hold)\n", t)
+			os.Exit(2)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	bench.RaiseFileLimit()
+	client := &bench.Client{Addr: *addr}
+	report := bench.Report{Time: time.Now(), Client: bench.LocalInfo(), Errors: make(map[string]string)}
+
+	var err error
+	if report.Server, err = client.Info(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %s: %v (is app -bench running there?)\n", *addr, err)
+		os.Exit(1)
+	}
+	// Progress goes to stderr, keeping stdout for the report
+	progress := func(format string, args ...any) {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+	}
+	progress("🚀 Benchmarking %s (%s)", *addr, report.Server.Host)
+
+	if run[bench.TestUpload] && ctx.Err() == nil {
+		progress("   upload: %d streams for %v", *streams, *duration)
+		t, err := client.Upload(ctx, *streams, *duration)
+		if err != nil {
+			report.Errors[bench.TestUpload] = err.Error()
+		} else {
+			report.Upload = &t
+		}
+	}
+	if run[bench.TestDownload] && ctx.Err() == nil {
+		progress("   download: %d streams for %v", *streams, *duration)
+		t, err := client.Download(ctx, *streams, *duration)
+		if err != nil {
+			report.Errors[bench.TestDownload] = err.Error()
+		} else {
+			report.Download = &t
+		}
+	}
+	if run[bench.TestConnect] && ctx.Err() == nil {
+		progress("   connection setup: %d workers for %v", *workers, *connectFor)
+		c, err := client.Connect(ctx, *workers, *connectFor)
+		if err != nil {
+			report.Errors[bench.TestConnect] = err.Error()
+		} else {
+			report.Connect = &c
+		}
+	}
+	if run[bench.TestHold] && ctx.Err() == nil {
+		progress("   concurrent connections: up to %d", *maxConns)
+		c, err := client.Hold(ctx, *maxConns)
+		if err != nil {
+			report.Errors[bench.TestHold] = err.Error()
+		} else {
+			report.Concurrency = &c
+		}
+	}
+	if ctx.Err() != nil {
+		progress("⚠️  Interrupted, reporting what ran")
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		report.Print(os.Stdout)
+	}
+	if len(report.Errors) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,206 @@
+// sensor-bot bridges a sensor agent's alerts into a chat room: it follows
+// the agent's event stream over HTTP and posts each alert and forecast
+// warning as it happens, so whoever is in the room hears of them. Asked
+// "!alerts" in the room, it lists the channels alerting now.
+//
+//	sensor-bot -addr chat:8080 -events http://board:9100/events
+//
+// Unlike the server's own relay, which reads the agent's socket on the
+// same board, the bot may run anywhere that reaches both.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"riscv-dev/pkg/agent"
+	"riscv-dev/pkg/buildinfo"
+	"riscv-dev/pkg/sensor"
+	"riscv-network-server/internal/bot"
+	"riscv-network-server/internal/chatclient"
+)
+
+// alert is an agent.Alert as streamed, with a fault's value null
+type alert struct {
+	Channel  string         `json:"channel"`
+	Quality  sensor.Quality `json:"quality"`
+	Previous sensor.Quality `json:"previous"`
+	Value    *float64       `json:"value"`
+}
+
+func main() {
+	addr := flag.String("addr", "localhost:8080", "chat server address")
+	name := flag.String("name", "sensorbot", "name to log in as")
+	password := flag.String("password", "", "password, if the name is registered")
+	room := flag.String("room", "sensors", "room to post in")
+	events := flag.String("events", "http://localhost:9100/events", "sensor agent's event stream")
+	token := flag.String("token", "", "bearer token for the agent's API")
+	forecasts := flag.Bool("forecasts", true, "post forecast warnings too")
+	buildinfo.RegisterFlag(flag.CommandLine)
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	b := bot.New(bot.Config{Config: chatclient.Config{Addr: *addr, Name: *name, Password: *password, Room: *room}})
+	var alerting sync.Map // channel -> sensor.Quality
+	b.Script(bot.Rule{Match: regexp.MustCompile(`^!help\b`), Reply: "I post the sensor agent's alerts here. Say !alerts for what's alerting now.", Mention: true})
+	b.Respond(func(m chatclient.Message) string {
+		if strings.TrimSpace(m.Text) != "!alerts" {
+			return ""
+		}
+		var now []string
+		alerting.Range(func(k, v any) bool {
+			now = append(now, fmt.Sprintf("%s %s", k, v))
+			return true
+		})
+		if len(now) == 0 {
+			return "✅ No channel is alerting."
+		}
+		sort.Strings(now)
+		return "🚨 Alerting: " + strings.Join(now, ", ")
+	})
+
+	types := agent.EventAlert
+	if *forecasts {
+		types += "," + agent.EventForecast
+	}
+	url := *events + "?types=" + types
+	go follow(ctx, url, *token, func(event string, data []byte) {
+		var text string
+		switch event {
+		case agent.EventAlert:
+			var a alert
+			if err := json.Unmarshal(data, &a); err != nil {
+				log.Printf("⚠️  Unreadable alert: %v", err)
+				return
+			}
+			if a.Quality == sensor.OK {
+				alerting.Delete(a.Channel)
+			} else {
+				alerting.Store(a.Channel, a.Quality)
+			}
+			text = formatAlert(a)
+		case agent.EventForecast:
+			var f agent.ForecastAlert
+			if err := json.Unmarshal(data, &f); err != nil {
+				log.Printf("⚠️  Unreadable forecast: %v", err)
+				return
+			}
+			text = formatForecast(f)
+		default:
+			return
+		}
+		if !b.Post(text) {
+			log.Printf("⚠️  Dropped %q, too many waiting to be posted", text)
+		}
+	})
+	b.Run(ctx)
+}
+
+func formatAlert(a alert) string {
+	value := ""
+	if a.Value != nil {
+		value = fmt.Sprintf(": %g", *a.Value)
+	}
+	if a.Quality == sensor.OK {
+		return fmt.Sprintf("✅ %s recovered from %s%s", a.Channel, a.Previous, value)
+	}
+	return fmt.Sprintf("🚨 %s %s%s", a.Channel, a.Quality, value)
+}
+
+func formatForecast(f agent.ForecastAlert) string {
+	if !f.Active {
+		return fmt.Sprintf("✅ %s no longer forecast %s %g", f.Channel, f.Direction, f.Threshold)
+	}
+	in := "now"
+	if f.In != nil {
+		in = "in " + time.Duration(*f.In*float64(time.Second)).Round(time.Second).String()
+	}
+	return fmt.Sprintf("📈 %s forecast %s %g %s (at %.4g, trend %+.3g/min)", f.Channel, f.Direction, f.Threshold, in, f.Value, f.Trend*60)
+}
+
+// follow reads the server-sent events at url, passing each to handle,
+// and reconnects whenever the stream ends until ctx is done
+func follow(ctx context.Context, url, token string, handle func(event string, data []byte)) {
+	failing := false
+	for {
+		err := stream(ctx, url, token, func(event string, data []byte) {
+			if failing {
+				log.Printf("✅ Following %s again", url)
+				failing = false
+			}
+			handle(event, data)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if !failing {
+			log.Printf("⚠️  Event stream %s: %v; retrying", url, err)
+			failing = true
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(3 * time.Second):
+		}
+	}
+}
+
+// stream reads one connection's worth of events
+func stream(ctx context.Context, url, token string, handle func(event string, data []byte)) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", resp.Status)
+	}
+
+	var event string
+	var data []byte
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case line == "":
+			if data != nil {
+				handle(event, data)
+			}
+			event, data = "", nil
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			if data != nil {
+				data = append(data, '\n')
+			}
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")...)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("stream ended")
+}
@@ -2,4 +2,7 @@ module riscv-network-server
 
 go 1.21
 
-// No external dependencies - uses only standard library
+require riscv-dev v0.0.0
+
+// Shared packages from this repository. No external dependencies.
+replace riscv-dev => ../..
@@ -0,0 +1,228 @@
+// Package bench measures what a board's network stack sustains: TCP
+// throughput either way, how fast connections are set up, and how many it
+// holds open at once. The network server's benchmark mode (app -bench)
+// answers, and net-bench drives the tests from another machine.
+//
+// Every connection starts with a request, a line of JSON naming the test,
+// answered by a line of JSON. Throughput tests then stream raw data: for
+// an upload the client sends until it shuts down its side and the server
+// answers with what it received, timed by the server; for a download the
+// server sends for the requested time and closes, timed by the client.
+// Both ends measure as the receiver, so send buffers don't inflate rates.
+package bench
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"riscv-dev/pkg/buildinfo"
+)
+
+// DefaultPort is where the benchmark server listens unless told otherwise
+const DefaultPort = "5201"
+
+// Tests understood by the server
+const (
+	TestInfo     = "info"     // describe the server
+	TestUpload   = "upload"   // receive until EOF
+	TestDownload = "download" // send for Duration
+	TestConnect  = "connect"  // answer and hang up
+	TestHold     = "hold"     // answer and keep the connection open
+)
+
+// Request starts a test on a connection
+type Request struct {
+	Test     string        `json:"test"`
+	Duration time.Duration `json:"duration,omitempty"` // download
+}
+
+// Response answers a Request, and an upload once it is complete
+type Response struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+	Info  *Info  `json:"info,omitempty"`
+	// Bytes and Elapsed are what an upload delivered, from its first
+	// byte to its end
+	Bytes   int64         `json:"bytes,omitempty"`
+	Elapsed time.Duration `json:"elapsed,omitempty"`
+	// Open is how many connections the server holds, this one included
+	Open int64 `json:"open,omitempty"`
+}
+
+// Info describes one end of a benchmark, to tell reports apart
+type Info struct {
+	Host   string `json:"host"`
+	Board  string `json:"board,omitempty"`
+	Kernel string `json:"kernel,omitempty"`
+	CPUs   int    `json:"cpus"`
+	// MaxFiles is the open file limit, which bounds connections
+	MaxFiles uint64         `json:"max_files"`
+	Build    buildinfo.Info `json:"build"`
+}
+
+// chunk is the size of the writes and reads of throughput tests
+const chunk = 128 << 10
+
+// LocalInfo describes this machine
+func LocalInfo() Info {
+	info := Info{CPUs: runtime.NumCPU(), Build: buildinfo.Get()}
+	info.Host, _ = os.Hostname()
+	for _, path := range []string{"/proc/device-tree/model", "/sys/firmware/devicetree/base/model"} {
+		if b, err := os.ReadFile(path); err == nil {
+			info.Board = strings.TrimRight(string(b), "\x00\n")
+			break
+		}
+	}
+	if b, err := os.ReadFile("/proc/sys/kernel/osrelease"); err == nil {
+		info.Kernel = strings.TrimSpace(string(b))
+	}
+	var rl syscall.Rlimit
+	if syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl) == nil {
+		info.MaxFiles = rl.Cur
+	}
+	return info
+}
+
+// RaiseFileLimit lifts the soft open file limit to the hard one, so the
+// connection tests find the system's limit rather than the shell's
+func RaiseFileLimit() {
+	var rl syscall.Rlimit
+	if syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl) == nil && rl.Cur < rl.Max {
+		rl.Cur = rl.Max
+		syscall.Setrlimit(syscall.RLIMIT_NOFILE, &rl)
+	}
+}
+
+// Server answers benchmark clients
+type Server struct {
+	info Info
+	open atomic.Int64
+}
+
+// NewServer returns a server describing this machine
+func NewServer() *Server {
+	RaiseFileLimit()
+	return &Server{info: LocalInfo()}
+}
+
+// Serve answers the clients of ln until it is closed. Running out of file
+// descriptors is logged and waited out, as the connection test drives the
+// server there on purpose.
+func (s *Server) Serve(ln net.Listener) error {
+	var tempDelay time.Duration
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() || errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE) {
+				if tempDelay == 0 {
+					log.Printf("⚠️  Benchmark: accepting: %v (%d connections open)", err, s.open.Load())
+				}
+				tempDelay = min(max(2*tempDelay, 5*time.Millisecond), time.Second)
+				time.Sleep(tempDelay)
+				continue
+			}
+			return err
+		}
+		tempDelay = 0
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	n := s.open.Add(1)
+	defer s.open.Add(-1)
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	r := bufio.NewReader(conn)
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	var req Request
+	if err := json.Unmarshal(line, &req); err != nil {
+		reply(conn, Response{Error: "bad request: " + err.Error()})
+		return
+	}
+	switch req.Test {
+	case TestInfo:
+		reply(conn, Response{OK: true, Info: &s.info})
+	case TestConnect:
+		reply(conn, Response{OK: true, Open: n})
+	case TestHold:
+		reply(conn, Response{OK: true, Open: n})
+		io.Copy(io.Discard, r) // until the client hangs up
+	case TestUpload:
+		if err := reply(conn, Response{OK: true}); err != nil {
+			return
+		}
+		if _, err := r.Peek(1); err != nil {
+			return
+		}
+		start := time.Now()
+		got, err := drain(r)
+		if err != nil {
+			return
+		}
+		reply(conn, Response{OK: true, Bytes: got, Elapsed: time.Since(start)})
+	case TestDownload:
+		if req.Duration <= 0 || req.Duration > 10*time.Minute {
+			reply(conn, Response{Error: "download duration must be positive and at most 10m"})
+			return
+		}
+		if err := reply(conn, Response{OK: true}); err != nil {
+			return
+		}
+		buf := make([]byte, chunk)
+		end := time.Now().Add(req.Duration)
+		conn.SetWriteDeadline(end)
+		for time.Now().Before(end) {
+			if _, err := conn.Write(buf); err != nil {
+				return
+			}
+		}
+	default:
+		reply(conn, Response{Error: fmt.Sprintf("unknown test %q", req.Test)})
+	}
+}
+
+// drain reads r to its end, in chunks, returning how much it read
+func drain(r io.Reader) (int64, error) {
+	buf := make([]byte, chunk)
+	var total int64
+	for {
+		n, err := r.Read(buf)
+		total += int64(n)
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+func reply(conn net.Conn, resp Response) error {
+	b, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(append(b, '\n'))
+	return err
+}
@@ -0,0 +1,65 @@
+package bench
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLoopback(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go NewServer().Serve(ln)
+
+	ctx := context.Background()
+	c := &Client{Addr: ln.Addr().String(), Timeout: 2 * time.Second}
+	if _, err := c.Info(ctx); err != nil {
+		t.Fatalf("Info: %v", err)
+	}
+	for name, run := range map[string]func(context.Context, int, time.Duration) (Throughput, error){
+		"upload": c.Upload, "download": c.Download,
+	} {
+		tp, err := run(ctx, 2, 100*time.Millisecond)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if tp.Bytes <= 0 || tp.Elapsed <= 0 || tp.Mbps <= 0 || tp.Streams != 2 {
+			t.Errorf("%s: %+v", name, tp)
+		}
+	}
+	cr, err := c.Connect(ctx, 2, 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	if cr.Connections == 0 || cr.P50 > cr.P99 || cr.P99 > cr.Max {
+		t.Errorf("Connect: %+v", cr)
+	}
+	h, err := c.Hold(ctx, 20)
+	if err != nil {
+		t.Fatalf("Hold: %v", err)
+	}
+	if h.Held != 20 || h.ServerOpen < 20 || h.Limit != "" {
+		t.Errorf("Hold: %+v", h)
+	}
+}
+
+func TestUnknownTest(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go NewServer().Serve(ln)
+
+	c := &Client{Addr: ln.Addr().String(), Timeout: 2 * time.Second}
+	if _, _, _, err := c.start(context.Background(), Request{Test: "teleport"}); err == nil {
+		t.Fatal("unknown test accepted")
+	}
+	if _, _, _, err := c.start(context.Background(), Request{Test: TestDownload, Duration: -1}); err == nil {
+		t.Fatal("negative download accepted")
+	}
+}
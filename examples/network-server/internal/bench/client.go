@@ -0,0 +1,304 @@
+package bench
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Client runs tests against a benchmark server
+type Client struct {
+	Addr    string
+	Timeout time.Duration // for connecting and each answer; default 5s
+}
+
+// Throughput is the result of a throughput test
+type Throughput struct {
+	Streams int           `json:"streams"`
+	Bytes   int64         `json:"bytes"`
+	Elapsed time.Duration `json:"elapsed"`
+	// Mbps is megabits a second over all streams
+	Mbps float64 `json:"mbps"`
+}
+
+// ConnectRate is the result of the connection setup test
+type ConnectRate struct {
+	Workers     int           `json:"workers"`
+	Connections int64         `json:"connections"`
+	Failures    int64         `json:"failures"`
+	Elapsed     time.Duration `json:"elapsed"`
+	PerSecond   float64       `json:"per_second"`
+	// Setup times a connection up to the server's answer
+	P50 time.Duration `json:"p50"`
+	P99 time.Duration `json:"p99"`
+	Max time.Duration `json:"max"`
+}
+
+// Concurrency is the result of the concurrent connections test
+type Concurrency struct {
+	Target int `json:"target"`
+	// Held is how many connections were open at once, and ServerOpen how
+	// many the server counted
+	Held       int    `json:"held"`
+	ServerOpen int64  `json:"server_open"`
+	Limit      string `json:"limit,omitempty"` // what stopped it short of Target
+	// Elapsed is how long opening them took
+	Elapsed time.Duration `json:"elapsed"`
+}
+
+func (c *Client) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+	return 5 * time.Second
+}
+
+// start connects and makes req, returning the connection and the answer
+func (c *Client) start(ctx context.Context, req Request) (net.Conn, *bufio.Reader, Response, error) {
+	d := net.Dialer{Timeout: c.timeout()}
+	conn, err := d.DialContext(ctx, "tcp", c.Addr)
+	if err != nil {
+		return nil, nil, Response{}, err
+	}
+	r := bufio.NewReader(conn)
+	resp, err := c.request(conn, r, req)
+	if err != nil {
+		conn.Close()
+		return nil, nil, resp, err
+	}
+	return conn, r, resp, nil
+}
+
+func (c *Client) request(conn net.Conn, r *bufio.Reader, req Request) (Response, error) {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return Response{}, err
+	}
+	conn.SetDeadline(time.Now().Add(c.timeout()))
+	defer conn.SetDeadline(time.Time{})
+	if _, err := conn.Write(append(b, '\n')); err != nil {
+		return Response{}, err
+	}
+	return readResponse(r)
+}
+
+func readResponse(r *bufio.Reader) (Response, error) {
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return Response{}, fmt.Errorf("no answer: %w", err)
+	}
+	var resp Response
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return resp, err
+	}
+	if !resp.OK {
+		return resp, errors.New(resp.Error)
+	}
+	return resp, nil
+}
+
+// Info describes the server
+func (c *Client) Info(ctx context.Context) (Info, error) {
+	conn, _, resp, err := c.start(ctx, Request{Test: TestInfo})
+	if err != nil {
+		return Info{}, err
+	}
+	conn.Close()
+	if resp.Info == nil {
+		return Info{}, errors.New("server sent no info")
+	}
+	return *resp.Info, nil
+}
+
+// Upload sends over streams connections for d, measured by the server
+func (c *Client) Upload(ctx context.Context, streams int, d time.Duration) (Throughput, error) {
+	return c.throughput(ctx, streams, func(ctx context.Context) (int64, time.Duration, error) {
+		conn, r, _, err := c.start(ctx, Request{Test: TestUpload})
+		if err != nil {
+			return 0, 0, err
+		}
+		defer conn.Close()
+		buf := make([]byte, chunk)
+		end := time.Now().Add(d)
+		conn.SetWriteDeadline(end)
+		for time.Now().Before(end) && ctx.Err() == nil {
+			if _, err := conn.Write(buf); err != nil {
+				var ne net.Error
+				if errors.As(err, &ne) && ne.Timeout() {
+					break
+				}
+				return 0, 0, err
+			}
+		}
+		conn.(*net.TCPConn).CloseWrite()
+		conn.SetReadDeadline(time.Now().Add(d + c.timeout()))
+		resp, err := readResponse(r)
+		return resp.Bytes, resp.Elapsed, err
+	})
+}
+
+// Download receives over streams connections for d
+func (c *Client) Download(ctx context.Context, streams int, d time.Duration) (Throughput, error) {
+	return c.throughput(ctx, streams, func(ctx context.Context) (int64, time.Duration, error) {
+		conn, r, _, err := c.start(ctx, Request{Test: TestDownload, Duration: d})
+		if err != nil {
+			return 0, 0, err
+		}
+		defer conn.Close()
+		stop := context.AfterFunc(ctx, func() { conn.Close() })
+		defer stop()
+		conn.SetReadDeadline(time.Now().Add(d + c.timeout()))
+		if _, err := r.Peek(1); err != nil {
+			return 0, 0, err
+		}
+		start := time.Now()
+		n, err := drain(r)
+		return n, time.Since(start), err
+	})
+}
+
+// throughput runs stream on each of streams connections at once and adds
+// up what they moved
+func (c *Client) throughput(ctx context.Context, streams int, stream func(context.Context) (int64, time.Duration, error)) (Throughput, error) {
+	streams = max(streams, 1)
+	t := Throughput{Streams: streams}
+	var (
+		mu   sync.Mutex
+		errs []error
+		wg   sync.WaitGroup
+	)
+	for i := 0; i < streams; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n, elapsed, err := stream(ctx)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			t.Bytes += n
+			t.Elapsed = max(t.Elapsed, elapsed)
+		}()
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return t, errors.Join(errs...)
+	}
+	if t.Elapsed > 0 {
+		t.Mbps = float64(t.Bytes) * 8 / t.Elapsed.Seconds() / 1e6
+	}
+	return t, nil
+}
+
+// Connect sets up and tears down connections from workers goroutines for
+// d, each waiting for the server's answer
+func (c *Client) Connect(ctx context.Context, workers int, d time.Duration) (ConnectRate, error) {
+	workers = max(workers, 1)
+	res := ConnectRate{Workers: workers}
+	var (
+		mu    sync.Mutex
+		times []time.Duration
+		fails atomic.Int64
+		last  error
+		wg    sync.WaitGroup
+	)
+	ctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+	start := time.Now()
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var mine []time.Duration
+			for ctx.Err() == nil {
+				t := time.Now()
+				conn, _, _, err := c.start(ctx, Request{Test: TestConnect})
+				if err != nil {
+					if ctx.Err() == nil {
+						fails.Add(1)
+						mu.Lock()
+						last = err
+						mu.Unlock()
+					}
+					continue
+				}
+				mine = append(mine, time.Since(t))
+				conn.Close()
+			}
+			mu.Lock()
+			times = append(times, mine...)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	res.Elapsed = time.Since(start)
+	res.Connections, res.Failures = int64(len(times)), fails.Load()
+	if len(times) == 0 {
+		if last == nil {
+			last = errors.New("no connections")
+		}
+		return res, last
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	res.PerSecond = float64(len(times)) / res.Elapsed.Seconds()
+	res.P50 = times[len(times)/2]
+	res.P99 = times[(len(times)-1)*99/100]
+	res.Max = times[len(times)-1]
+	return res, nil
+}
+
+// Hold opens connections and keeps them open until target are, the
+// first fails, or ctx is done, then closes them all
+func (c *Client) Hold(ctx context.Context, target int) (Concurrency, error) {
+	res := Concurrency{Target: target}
+	conns := make([]net.Conn, 0, target)
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	start := time.Now()
+	for len(conns) < target && ctx.Err() == nil {
+		conn, _, resp, err := c.start(ctx, Request{Test: TestHold})
+		if err != nil {
+			res.Limit = limitReason(err)
+			break
+		}
+		conns = append(conns, conn)
+		res.ServerOpen = max(res.ServerOpen, resp.Open)
+	}
+	res.Elapsed = time.Since(start)
+	res.Held = len(conns)
+	if res.Held == 0 && res.Limit != "" {
+		return res, errors.New(res.Limit)
+	}
+	return res, nil
+}
+
+// limitReason says which end ran out, as far as an error tells
+func limitReason(err error) string {
+	switch {
+	case errors.Is(err, syscall.EMFILE), errors.Is(err, syscall.ENFILE):
+		return "client out of file descriptors: " + err.Error()
+	case errors.Is(err, syscall.EADDRNOTAVAIL):
+		return "client out of local ports: " + err.Error()
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.EOF):
+		return "server refused or dropped the connection: " + err.Error()
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return "server stopped answering (accept backlog full or out of file descriptors): " + err.Error()
+	}
+	return err.Error()
+}
@@ -0,0 +1,83 @@
+package bench
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Report is a benchmark run, printed for reading or kept as JSON for
+// comparing boards
+type Report struct {
+	Time        time.Time    `json:"time"`
+	Server      Info         `json:"server"`
+	Client      Info         `json:"client"`
+	Upload      *Throughput  `json:"upload,omitempty"`
+	Download    *Throughput  `json:"download,omitempty"`
+	Connect     *ConnectRate `json:"connect,omitempty"`
+	Concurrency *Concurrency `json:"concurrency,omitempty"`
+	// Errors are the tests that failed, by name
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// Print writes the report as a table
+func (r Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "\n📊 BENCHMARK %s\n", r.Time.Format(time.RFC3339))
+	fmt.Fprintf(w, "━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━\n")
+	fmt.Fprintf(w, "Server:             %s\n", describe(r.Server))
+	fmt.Fprintf(w, "Client:             %s\n", describe(r.Client))
+	if t := r.Upload; t != nil {
+		fmt.Fprintf(w, "Upload:             %.1f Mbit/s (%d streams, %s in %v)\n", t.Mbps, t.Streams, size(t.Bytes), t.Elapsed.Round(time.Millisecond))
+	}
+	if t := r.Download; t != nil {
+		fmt.Fprintf(w, "Download:           %.1f Mbit/s (%d streams, %s in %v)\n", t.Mbps, t.Streams, size(t.Bytes), t.Elapsed.Round(time.Millisecond))
+	}
+	if c := r.Connect; c != nil {
+		fmt.Fprintf(w, "Connection setup:   %.0f/s (%d workers, %d failed)  p50 %v  p99 %v  max %v\n",
+			c.PerSecond, c.Workers, c.Failures, round(c.P50), round(c.P99), round(c.Max))
+	}
+	if c := r.Concurrency; c != nil {
+		fmt.Fprintf(w, "Concurrent:         %d of %d held (server counted %d) in %v\n", c.Held, c.Target, c.ServerOpen, c.Elapsed.Round(time.Millisecond))
+		if c.Limit != "" {
+			fmt.Fprintf(w, "                    stopped by %s\n", c.Limit)
+		}
+	}
+	for _, test := range []string{TestUpload, TestDownload, TestConnect, TestHold} {
+		if err, ok := r.Errors[test]; ok {
+			fmt.Fprintf(w, "❌ %-17s %s\n", test+":", err)
+		}
+	}
+}
+
+// describe sums up one end, e.g. "StarFive VisionFive 2 (4 CPUs, Linux
+// 6.6.20, linux/riscv64, 65536 files) v0.4.1"
+func describe(i Info) string {
+	name := i.Board
+	if name == "" {
+		name = i.Host
+	}
+	details := []string{fmt.Sprintf("%d CPUs", i.CPUs)}
+	if i.Kernel != "" {
+		details = append(details, "Linux "+i.Kernel)
+	}
+	details = append(details, i.Build.GOOS+"/"+i.Build.GOARCH, fmt.Sprintf("%d files", i.MaxFiles))
+	return fmt.Sprintf("%s (%s) %s", name, strings.Join(details, ", "), i.Build.Version)
+}
+
+func size(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.2f GiB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	}
+	return fmt.Sprintf("%d KiB", n>>10)
+}
+
+func round(d time.Duration) time.Duration {
+	if d > time.Millisecond {
+		return d.Round(10 * time.Microsecond)
+	}
+	return d.Round(time.Microsecond)
+}
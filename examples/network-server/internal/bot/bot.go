@@ -0,0 +1,249 @@
+// Package bot runs chat bots: clients that stay logged in to a room,
+// reconnecting when the server goes away, and answer messages, post on a
+// schedule or relay lines from elsewhere into the room.
+package bot
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"riscv-network-server/internal/chatclient"
+)
+
+// Config sets up a bot
+type Config struct {
+	chatclient.Config
+	// Reconnect is how long to wait after losing the connection before
+	// trying again; default 5s
+	Reconnect time.Duration
+	// KeepAlive is how often a quiet bot tells the server it's still
+	// there, well within the server's idle timeout; default 5m
+	KeepAlive time.Duration
+	// Queue is how many posted lines wait while the bot is disconnected;
+	// default 100
+	Queue int
+}
+
+// Responder answers a message, or returns "" to stay quiet
+type Responder func(chatclient.Message) string
+
+// Rule is a scripted reply: to a message matching Match, the bot says
+// Reply, in which $1, ${name} and so on are replaced by what the groups
+// of Match matched
+type Rule struct {
+	Match *regexp.Regexp
+	Reply string
+	// Mention starts the reply with the sender's name
+	Mention bool
+}
+
+type announcer struct {
+	every time.Duration
+	text  func() string
+}
+
+// Bot is a chat bot. Set it up with Respond, Script and Every before
+// calling Run; Post may be called at any time.
+type Bot struct {
+	cfg        Config
+	responders []Responder
+	announcers []announcer
+	posts      chan string
+
+	mu     sync.Mutex
+	client *chatclient.Client // nil while disconnected
+}
+
+// New returns a bot that logs in as set up by cfg
+func New(cfg Config) *Bot {
+	if cfg.Reconnect <= 0 {
+		cfg.Reconnect = 5 * time.Second
+	}
+	if cfg.KeepAlive <= 0 {
+		cfg.KeepAlive = 5 * time.Minute
+	}
+	if cfg.Queue <= 0 {
+		cfg.Queue = 100
+	}
+	return &Bot{cfg: cfg, posts: make(chan string, cfg.Queue)}
+}
+
+// Respond adds a responder, which sees every chat message in the bot's
+// room other than the bot's own. Responders are asked in the order added
+// until one answers.
+func (b *Bot) Respond(r Responder) {
+	b.responders = append(b.responders, r)
+}
+
+// Script adds a responder that answers with the first matching rule
+func (b *Bot) Script(rules ...Rule) {
+	b.Respond(func(m chatclient.Message) string {
+		for _, rule := range rules {
+			match := rule.Match.FindStringSubmatchIndex(m.Text)
+			if match == nil {
+				continue
+			}
+			reply := string(rule.Match.ExpandString(nil, rule.Reply, m.Text, match))
+			if rule.Mention {
+				reply = m.From + ": " + reply
+			}
+			return reply
+		}
+		return ""
+	})
+}
+
+// Every adds an announcer, which says what text returns every interval
+// while the bot is connected, unless that is ""
+func (b *Bot) Every(interval time.Duration, text func() string) {
+	b.announcers = append(b.announcers, announcer{every: interval, text: text})
+}
+
+// Post queues text to say in the bot's room, for bridges relaying lines
+// from elsewhere. It reports false if the queue is full and text was
+// dropped.
+func (b *Bot) Post(text string) bool {
+	select {
+	case b.posts <- text:
+		return true
+	default:
+		return false
+	}
+}
+
+// Connected reports whether the bot is logged in
+func (b *Bot) Connected() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.client != nil
+}
+
+// Run keeps the bot logged in and working until ctx is done
+func (b *Bot) Run(ctx context.Context) {
+	for _, a := range b.announcers {
+		go b.announce(ctx, a)
+	}
+	failing := false
+	for {
+		c, err := chatclient.Dial(ctx, b.cfg.Config)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if !failing {
+				log.Printf("⚠️  %s: %v; retrying every %v", b.cfg.Name, err, b.cfg.Reconnect)
+				failing = true
+			}
+		} else {
+			log.Printf("✅ %s is in %s on %s", b.cfg.Name, c.Room(), b.cfg.Addr)
+			failing = false
+			err = b.serve(ctx, c)
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("⚠️  %s lost the connection: %v", b.cfg.Name, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(b.cfg.Reconnect):
+		}
+	}
+}
+
+// serve works a connection until it fails or ctx is done
+func (b *Bot) serve(ctx context.Context, c *chatclient.Client) error {
+	b.mu.Lock()
+	b.client = c
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		b.client = nil
+		b.mu.Unlock()
+		c.Close()
+	}()
+
+	errs := make(chan error, 2)
+	go func() { errs <- b.read(c) }()
+	keepAlive := time.NewTicker(b.cfg.KeepAlive)
+	defer keepAlive.Stop()
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err = <-errs:
+			return err
+		case text := <-b.posts:
+			err = b.say(c, text)
+		case <-keepAlive.C:
+			err = c.Ping()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// read answers messages until the connection fails
+func (b *Bot) read(c *chatclient.Client) error {
+	for {
+		m, err := c.Next()
+		if err != nil {
+			return err
+		}
+		if m.From == "" || strings.EqualFold(m.From, c.Name()) {
+			continue
+		}
+		for _, r := range b.responders {
+			if reply := r(m); reply != "" {
+				if err := b.say(c, reply); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+}
+
+// say sends text a line at a time, dropping lines the server would take
+// as commands
+func (b *Bot) say(c *chatclient.Client, text string) error {
+	for _, line := range strings.Split(strings.TrimRight(text, "\r\n"), "\n") {
+		err := c.Say(strings.TrimRight(line, "\r"))
+		if err == chatclient.ErrCommand {
+			log.Printf("⚠️  %s: not saying %q, which is a command", b.cfg.Name, line)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// announce says an announcer's text on its schedule while connected
+func (b *Bot) announce(ctx context.Context, a announcer) {
+	ticker := time.NewTicker(a.every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		b.mu.Lock()
+		c := b.client
+		b.mu.Unlock()
+		if c == nil {
+			continue
+		}
+		if text := a.text(); text != "" {
+			b.say(c, text)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+// Package chatclient speaks the chat server's line protocol as a client:
+// it logs in, joins a room, sends lines and parses what the server sends
+// back into messages. Bots and tools build on it.
+package chatclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config says where and as whom to connect
+type Config struct {
+	Addr string
+	Name string
+	// Password is asked for registered names
+	Password string
+	// Room is joined after logging in; empty stays in the lobby
+	Room string
+	// Timeout bounds connecting and logging in; default 10s
+	Timeout time.Duration
+}
+
+// Message is a line from the server
+type Message struct {
+	// Time is when the server stamped a chat message, to the second on
+	// the day it arrived; zero for other lines
+	Time time.Time
+	From string // sender of a chat message, empty for other lines
+	Text string
+	// System is set for announcements to the room, such as people
+	// joining and leaving
+	System bool
+	Room   string // the room the client was in when it arrived
+}
+
+// ErrCommand is returned by Say for text the server would take as a
+// command
+var ErrCommand = errors.New("chatclient: text would be taken as a command")
+
+// legacyCommands are taken as commands without a slash, as by the server
+var legacyCommands = map[string]bool{"help": true, "time": true, "clients": true, "quit": true}
+
+// Client is a logged-in connection. Say, Command and Ping may be called
+// while another goroutine reads with Next.
+type Client struct {
+	conn net.Conn
+	r    *bufio.Reader
+	name string
+
+	wmu sync.Mutex // serialises writes
+
+	mu   sync.Mutex
+	room string
+}
+
+// Dial connects and logs in, and joins cfg.Room if set
+func Dial(ctx context.Context, cfg Config) (*Client, error) {
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{conn: conn, r: bufio.NewReader(conn), name: cfg.Name}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+	if err := c.login(cfg); err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			err = fmt.Errorf("chatclient: logging in to %s: %w", cfg.Addr, ctx.Err())
+		}
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return c, nil
+}
+
+// login answers the name and password prompts, and waits until the
+// server places the client in a room
+func (c *Client) login(cfg Config) error {
+	if err := c.write(cfg.Name + "\r\n"); err != nil {
+		return err
+	}
+	if err := c.await("", cfg.Password); err != nil {
+		return err
+	}
+	if cfg.Room != "" {
+		return c.Join(cfg.Room)
+	}
+	return nil
+}
+
+// await reads until the server confirms the client is in room, or in any
+// room if room is empty, answering a password prompt with password and
+// failing on a refused login
+func (c *Client) await(room, password string) error {
+	for {
+		line, err := c.readPrompt()
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(line, "Password: ") {
+			if password == "" {
+				return fmt.Errorf("chatclient: %s is registered and needs a password", c.name)
+			}
+			if err := c.write(password + "\r\n"); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, refusal := range []string{"is already connected.", "Wrong password.", "banned from this server", "Names are 1-24"} {
+			if strings.Contains(line, refusal) {
+				return fmt.Errorf("chatclient: login refused: %s", strings.TrimPrefix(line, "Enter your name: "))
+			}
+		}
+		if strings.HasPrefix(line, "Usage: /join") {
+			return fmt.Errorf("chatclient: can't join %q", room)
+		}
+		if in, ok := c.track(line); ok && (room == "" || strings.EqualFold(in, room)) {
+			return nil
+		}
+	}
+}
+
+// Join moves the client to room, creating it if needed, and waits until
+// the server confirms it
+func (c *Client) Join(room string) error {
+	if err := c.Command("join", room); err != nil {
+		return err
+	}
+	return c.await(room, "")
+}
+
+// Name returns the name logged in with
+func (c *Client) Name() string { return c.name }
+
+// Room returns the room the client is in
+func (c *Client) Room() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.room
+}
+
+// Say sends text to the client's room. Text the server would take as a
+// command is refused with ErrCommand; use Command for those.
+func (c *Client) Say(text string) error {
+	text = strings.TrimRight(text, "\r\n")
+	if t := strings.TrimSpace(text); strings.HasPrefix(t, "/") || legacyCommands[strings.ToLower(t)] {
+		return ErrCommand
+	}
+	if strings.ContainsAny(text, "\r\n") {
+		return fmt.Errorf("chatclient: text spans several lines")
+	}
+	return c.write(text + "\r\n")
+}
+
+// Command sends a command such as Command("topic", "Greenhouse alerts")
+func (c *Client) Command(name string, args ...string) error {
+	line := "/" + strings.Join(append([]string{name}, args...), " ")
+	if strings.ContainsAny(line, "\r\n") {
+		return fmt.Errorf("chatclient: command spans several lines")
+	}
+	return c.write(line + "\r\n")
+}
+
+// Ping sends an empty line, which the server ignores but counts as
+// activity, so a quiet client isn't disconnected as idle
+func (c *Client) Ping() error { return c.write("\r\n") }
+
+func (c *Client) write(s string) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := c.conn.Write([]byte(s))
+	return err
+}
+
+// Next returns the next line from the server. Blank lines are skipped.
+func (c *Client) Next() (Message, error) {
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return Message{}, err
+		}
+		c.track(line)
+		if line == "" {
+			continue
+		}
+		return Parse(line, c.Room(), time.Now()), nil
+	}
+}
+
+// readLine reads a line without its line ending or telnet commands
+func (c *Client) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return stripTelnet(strings.TrimRight(line, "\r\n")), nil
+}
+
+// readPrompt is readLine, but also returns the password prompt, which
+// isn't followed by a line ending
+func (c *Client) readPrompt() (string, error) {
+	var line []byte
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if b == '\n' {
+			return stripTelnet(strings.TrimRight(string(line), "\r")), nil
+		}
+		line = append(line, b)
+		if bytes.HasSuffix(line, []byte("Password: ")) {
+			return stripTelnet(string(line)), nil
+		}
+	}
+}
+
+// track notes the room from the server's "You are in" line, and reports
+// it
+func (c *Client) track(line string) (string, bool) {
+	_, rest, ok := strings.Cut(line, "You are in ")
+	if !ok || !strings.HasSuffix(rest, ".") {
+		return "", false
+	}
+	room := strings.TrimSuffix(rest, ".")
+	c.mu.Lock()
+	c.room = room
+	c.mu.Unlock()
+	return room, true
+}
+
+// Close disconnects, saying goodbye first
+func (c *Client) Close() error {
+	c.write("quit\r\n")
+	return c.conn.Close()
+}
+
+// Parse parses a line from a server, as received in room on the day of
+// now: "[15:04:05] name: text" is a chat message, a line starting with
+// 📢 an announcement, and anything else the answer to a command
+func Parse(line, room string, now time.Time) Message {
+	m := Message{Text: line, Room: room}
+	if text, ok := strings.CutPrefix(line, "📢 "); ok {
+		m.Text, m.System = text, true
+		return m
+	}
+	if len(line) < 11 || line[0] != '[' || line[9] != ']' || line[10] != ' ' {
+		return m
+	}
+	t, err := time.ParseInLocation("15:04:05", line[1:9], now.Location())
+	if err != nil {
+		return m
+	}
+	from, text, ok := strings.Cut(line[11:], ": ")
+	if !ok || from == "" || strings.ContainsAny(from, " ") {
+		return m
+	}
+	y, mo, d := now.Date()
+	m.Time = time.Date(y, mo, d, t.Hour(), t.Minute(), t.Second(), 0, now.Location())
+	if m.Time.After(now.Add(time.Hour)) {
+		// Stamped just before midnight, arriving after it
+		m.Time = m.Time.AddDate(0, 0, -1)
+	}
+	m.From, m.Text = from, text
+	return m
+}
+
+// stripTelnet removes telnet commands, which servers in telnet mode send
+// to negotiate echo and the terminal type
+func stripTelnet(s string) string {
+	const iac, sb, se = 0xff, 0xfa, 0xf0
+	if strings.IndexByte(s, iac) < 0 {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] != iac:
+			b.WriteByte(s[i])
+		case i+1 < len(s) && s[i+1] == sb:
+			// Subnegotiation, up to IAC SE
+			j := strings.Index(s[i:], string([]byte{iac, se}))
+			if j < 0 {
+				return b.String()
+			}
+			i += j + 1
+		case i+1 < len(s) && s[i+1] >= 0xfb: // WILL, WONT, DO, DONT and an option
+			i += 2
+		default:
+			i++
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,126 @@
+package chatclient
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	now := time.Date(2024, 3, 1, 0, 0, 30, 0, time.UTC)
+	for _, tc := range []struct {
+		line   string
+		from   string
+		text   string
+		system bool
+		time   time.Time
+	}{
+		{"[00:00:10] alice: hello: world", "alice", "hello: world", false, time.Date(2024, 3, 1, 0, 0, 10, 0, time.UTC)},
+		{"[23:59:59] bob: late", "bob", "late", false, time.Date(2024, 2, 29, 23, 59, 59, 0, time.UTC)},
+		{"📢 carol joined lobby", "", "carol joined lobby", true, time.Time{}},
+		{"Topic of lobby: hi", "", "Topic of lobby: hi", false, time.Time{}},
+		{"[xx:00:10] alice: hi", "", "[xx:00:10] alice: hi", false, time.Time{}},
+	} {
+		m := Parse(tc.line, "lobby", now)
+		if m.From != tc.from || m.Text != tc.text || m.System != tc.system || !m.Time.Equal(tc.time) || m.Room != "lobby" {
+			t.Errorf("Parse(%q) = %+v", tc.line, m)
+		}
+	}
+}
+
+func TestStripTelnet(t *testing.T) {
+	in := "\xff\xfb\x01\xff\xfa\x18\x01\xff\xf0Enter\xff\xf1 your name: "
+	if got := stripTelnet(in); got != "Enter your name: " {
+		t.Errorf("stripTelnet = %q", got)
+	}
+}
+
+// fakeServer speaks the login part of the protocol: alice is registered
+// with password "secret"
+func fakeServer(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				read := func() string {
+					line, _ := r.ReadString('\n')
+					return strings.TrimRight(line, "\r\n")
+				}
+				fmt.Fprint(conn, "Welcome!\n\nEnter your name: ")
+				name := read()
+				if name == "alice" {
+					fmt.Fprint(conn, "Password: ")
+					if read() != "secret" {
+						fmt.Fprint(conn, "Wrong password.\n")
+						return
+					}
+				}
+				fmt.Fprint(conn, "You are in lobby.\n")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					switch line = strings.TrimRight(line, "\r\n"); {
+					case strings.HasPrefix(line, "/join "):
+						fmt.Fprintf(conn, "You are in %s.\n\n", strings.TrimPrefix(line, "/join "))
+					case line == "quit":
+						return
+					case line == "":
+					default:
+						fmt.Fprintf(conn, "[12:00:00] %s: %s\n", name, line)
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestDial(t *testing.T) {
+	addr := fakeServer(t)
+	ctx := context.Background()
+
+	c, err := Dial(ctx, Config{Addr: addr, Name: "alice", Password: "secret", Room: "sensors", Timeout: 2 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.Room() != "sensors" {
+		t.Errorf("room %q, want sensors", c.Room())
+	}
+	if err := c.Say("/quit"); err != ErrCommand {
+		t.Errorf("Say(/quit) = %v", err)
+	}
+	if err := c.Say("hi there"); err != nil {
+		t.Fatal(err)
+	}
+	m, err := c.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.From != "alice" || m.Text != "hi there" || m.Room != "sensors" {
+		t.Errorf("Next = %+v", m)
+	}
+
+	if _, err := Dial(ctx, Config{Addr: addr, Name: "alice", Password: "guess", Timeout: 2 * time.Second}); err == nil || !strings.Contains(err.Error(), "Wrong password") {
+		t.Errorf("wrong password: %v", err)
+	}
+	if _, err := Dial(ctx, Config{Addr: addr, Name: "alice", Timeout: 2 * time.Second}); err == nil {
+		t.Error("logged in to a registered name without a password")
+	}
+}
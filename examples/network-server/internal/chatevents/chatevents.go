@@ -0,0 +1,334 @@
+// Package chatevents exports what happens on the chat server as
+// structured events: people connecting, joining rooms and leaving,
+// messages, kicks, bans and errors. Events are published on an IPC socket
+// (see riscv-dev/pkg/ipc), the same kind of stream the sensor agent
+// publishes readings on, and can also be appended to a JSON lines file or
+// posted to a webhook, for analysis with the same tools as telemetry.
+package chatevents
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"riscv-dev/pkg/ipc"
+)
+
+// Kind identifies the event stream to subscribers
+const Kind = "chat-events"
+
+// DefaultSocket is where the server publishes events
+const DefaultSocket = "/run/riscv-chat-events.sock"
+
+// Event types
+const (
+	Connect    = "connect"    // logged in
+	Disconnect = "disconnect" // Error says why, if not by choice
+	Join       = "join"       // entered Room
+	Leave      = "leave"      // left Room for another
+	Message    = "message"    // said Text in Room
+	Kick       = "kick"       // By disconnected Name, for Text
+	Ban        = "ban"        // By banned Name, for Text
+	Error      = "error"      // e.g. a failed login or a store error
+)
+
+// Event is something that happened on the server
+type Event struct {
+	Seq   uint64    `json:"seq"` // counts up from 1 at server start
+	Time  time.Time `json:"time"`
+	Type  string    `json:"type"`
+	Name  string    `json:"name,omitempty"` // the client concerned
+	Addr  string    `json:"addr,omitempty"` // its address
+	Room  string    `json:"room,omitempty"`
+	Text  string    `json:"text,omitempty"` // message text, or a kick or ban reason
+	By    string    `json:"by,omitempty"`   // the operator who kicked or banned
+	Error string    `json:"error,omitempty"`
+}
+
+// Config says where to export events; each destination is optional
+type Config struct {
+	// Socket is the IPC socket to publish on
+	Socket string
+	// File is a JSON lines file, rotated at MaxSize into File.1 and so on
+	// up to MaxFiles; defaults 10 MiB and 3
+	File     string
+	MaxSize  int64
+	MaxFiles int
+	// Webhook is a URL events are POSTed to as a JSON array, at most
+	// every FlushInterval (default 5s) and sent with Token as a bearer
+	// token if set
+	Webhook       string
+	Token         string
+	FlushInterval time.Duration
+}
+
+// Exporter sends events to the configured destinations. A nil *Exporter
+// exports nothing, so callers don't need to check whether exporting is
+// enabled.
+type Exporter struct {
+	cfg    Config
+	seq    atomic.Uint64
+	pub    *ipc.Publisher
+	queue  chan Event // for the file and the webhook
+	client *http.Client
+
+	dropped atomic.Uint64
+	quit    chan struct{}
+	done    chan struct{}
+	stop    sync.Once
+
+	f    *os.File
+	size int64
+}
+
+// queueSize is how many events wait for the file and the webhook before
+// new ones are dropped, and how many a failing webhook holds on to
+const queueSize = 1000
+
+// Open starts exporting to the destinations in cfg
+func Open(cfg Config) (*Exporter, error) {
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = 10 << 20
+	}
+	if cfg.MaxFiles <= 0 {
+		cfg.MaxFiles = 3
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = 5 * time.Second
+	}
+	e := &Exporter{cfg: cfg, queue: make(chan Event, queueSize), client: &http.Client{Timeout: 10 * time.Second},
+		quit: make(chan struct{}), done: make(chan struct{})}
+	if cfg.File != "" {
+		if err := e.open(); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Socket != "" {
+		pub, err := ipc.Listen(cfg.Socket, ipc.Hello{Kind: Kind, Source: "network-server"}, ipc.DefaultBuffer)
+		if err != nil {
+			if e.f != nil {
+				e.f.Close()
+			}
+			return nil, err
+		}
+		e.pub = pub
+	}
+	go e.run()
+	return e, nil
+}
+
+// Emit exports ev, numbering and timestamping it. It never blocks: events
+// the file and webhook can't keep up with are dropped and counted.
+func (e *Exporter) Emit(ev Event) {
+	if e == nil {
+		return
+	}
+	ev.Seq = e.seq.Add(1)
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	if e.pub != nil {
+		e.pub.Publish(ev)
+	}
+	if e.cfg.File == "" && e.cfg.Webhook == "" {
+		return
+	}
+	select {
+	case e.queue <- ev:
+	default:
+		if e.dropped.Add(1) == 1 {
+			log.Printf("⚠️  Event export is behind, dropping events")
+		}
+	}
+}
+
+// Dropped returns how many events the file and webhook missed
+func (e *Exporter) Dropped() uint64 {
+	if e == nil {
+		return 0
+	}
+	return e.dropped.Load()
+}
+
+// Close writes out queued events, trying the webhook once more, and stops
+// exporting. Events emitted after it are dropped.
+func (e *Exporter) Close() error {
+	if e == nil {
+		return nil
+	}
+	e.stop.Do(func() { close(e.quit) })
+	<-e.done
+	if e.pub != nil {
+		e.pub.Close()
+	}
+	if e.f != nil {
+		return e.f.Close()
+	}
+	return nil
+}
+
+// run writes queued events to the file as they come and posts them to
+// the webhook in batches
+func (e *Exporter) run() {
+	defer close(e.done)
+	flush := time.NewTicker(e.cfg.FlushInterval)
+	defer flush.Stop()
+	var batch []Event
+	failing := false
+	post := func() {
+		if e.cfg.Webhook == "" || len(batch) == 0 {
+			return
+		}
+		err := e.post(batch)
+		switch {
+		case err != nil && !failing:
+			log.Printf("⚠️  Event webhook: %v; holding events", err)
+		case err == nil && failing:
+			log.Printf("✅ Event webhook delivering again")
+		}
+		failing = err != nil
+		if err == nil {
+			batch = batch[:0]
+		} else if over := len(batch) - queueSize; over > 0 {
+			e.dropped.Add(uint64(over))
+			batch = append(batch[:0], batch[over:]...)
+		}
+	}
+	add := func(ev Event) {
+		if e.f != nil {
+			e.write(ev)
+		}
+		if e.cfg.Webhook != "" {
+			batch = append(batch, ev)
+		}
+	}
+	for {
+		select {
+		case ev := <-e.queue:
+			add(ev)
+		case <-flush.C:
+			post()
+		case <-e.quit:
+			for {
+				select {
+				case ev := <-e.queue:
+					add(ev)
+				default:
+					post()
+					return
+				}
+			}
+		}
+	}
+}
+
+// post sends a batch to the webhook
+func (e *Exporter) post(batch []Event) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.cfg.Webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+e.cfg.Token)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
+
+// write appends an event to the file, rotating it when full
+func (e *Exporter) write(ev Event) {
+	line, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("❌ Event export: %v", err)
+		return
+	}
+	line = append(line, '\n')
+	if e.size+int64(len(line)) > e.cfg.MaxSize && e.size > 0 {
+		if err := e.rotate(); err != nil {
+			log.Printf("❌ Event export: rotating %s: %v", e.cfg.File, err)
+		}
+	}
+	if e.f == nil {
+		return
+	}
+	n, err := e.f.Write(line)
+	e.size += int64(n)
+	if err != nil {
+		log.Printf("❌ Event export: writing %s: %v", e.cfg.File, err)
+	}
+}
+
+func (e *Exporter) open() error {
+	f, err := os.OpenFile(e.cfg.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	e.f, e.size = f, st.Size()
+	return nil
+}
+
+// rotate shifts File.N to File.N+1, dropping the oldest, moves the file
+// to File.1 and starts a new one
+func (e *Exporter) rotate() error {
+	e.f.Close()
+	e.f = nil
+	os.Remove(e.rotated(e.cfg.MaxFiles))
+	for i := e.cfg.MaxFiles - 1; i >= 1; i-- {
+		os.Rename(e.rotated(i), e.rotated(i+1))
+	}
+	if err := os.Rename(e.cfg.File, e.rotated(1)); err != nil {
+		e.open()
+		return err
+	}
+	return e.open()
+}
+
+func (e *Exporter) rotated(n int) string { return e.cfg.File + "." + strconv.Itoa(n) }
+
+// Stream receives the events a server publishes
+type Stream struct {
+	sub *ipc.Subscriber
+}
+
+// Subscribe follows the events published on the socket at path
+func Subscribe(path string) (*Stream, error) {
+	sub, err := ipc.Dial(path, Kind)
+	if err != nil {
+		return nil, err
+	}
+	return &Stream{sub: sub}, nil
+}
+
+// Next waits for the next event. It returns io.EOF when the server stops.
+func (s *Stream) Next() (Event, error) {
+	var ev Event
+	err := s.sub.Receive(&ev)
+	return ev, err
+}
+
+// Close unsubscribes
+func (s *Stream) Close() error { return s.sub.Close() }
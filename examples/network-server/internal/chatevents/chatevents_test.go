@@ -0,0 +1,112 @@
+package chatevents
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestExport(t *testing.T) {
+	dir := t.TempDir()
+	var mu sync.Mutex
+	var posted []Event
+	var auth string
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []Event
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Errorf("webhook body: %v", err)
+		}
+		mu.Lock()
+		posted = append(posted, batch...)
+		auth = r.Header.Get("Authorization")
+		mu.Unlock()
+	}))
+	defer webhook.Close()
+
+	cfg := Config{
+		Socket:  filepath.Join(dir, "events.sock"),
+		File:    filepath.Join(dir, "events.jsonl"),
+		MaxSize: 300, // a few events per file
+		Webhook: webhook.URL, Token: "secret", FlushInterval: time.Hour,
+	}
+	e, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := Subscribe(cfg.Socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	const n = 10
+	for i := 0; i < n; i++ {
+		e.Emit(Event{Type: Message, Name: "alice", Room: "lobby", Text: "hello"})
+	}
+	for i := 1; i <= n; i++ {
+		ev, err := stream.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ev.Seq != uint64(i) || ev.Type != Message || ev.Time.IsZero() {
+			t.Fatalf("streamed %+v", ev)
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Close posts what the flush interval would have
+	mu.Lock()
+	if len(posted) != n || posted[n-1].Seq != n || auth != "Bearer secret" {
+		t.Errorf("webhook got %d events, auth %q", len(posted), auth)
+	}
+	mu.Unlock()
+
+	// The file and the rotated ones hold the latest events, oldest first
+	var seqs []uint64
+	for _, path := range []string{cfg.File + ".3", cfg.File + ".2", cfg.File + ".1", cfg.File} {
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		st, _ := f.Stat()
+		if st.Size() > cfg.MaxSize {
+			t.Errorf("%s is %d bytes", path, st.Size())
+		}
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			var ev Event
+			if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+				t.Fatal(err)
+			}
+			seqs = append(seqs, ev.Seq)
+		}
+		f.Close()
+	}
+	if len(seqs) == 0 || seqs[len(seqs)-1] != n {
+		t.Fatalf("file holds %v", seqs)
+	}
+	for i := 1; i < len(seqs); i++ {
+		if seqs[i] != seqs[i-1]+1 {
+			t.Fatalf("file holds %v", seqs)
+		}
+	}
+}
+
+func TestNilExporter(t *testing.T) {
+	var e *Exporter
+	e.Emit(Event{Type: Error})
+	if e.Dropped() != 0 || e.Close() != nil {
+		t.Error("nil exporter did something")
+	}
+}
@@ -0,0 +1,101 @@
+// Package control is the protocol of the chat server's local control
+// socket, shared by the server and chatctl. Each request and response is a
+// single line of JSON on a Unix domain socket; access is governed by the
+// socket's file permissions, so only local admins can use it.
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+
+	"riscv-dev/pkg/buildinfo"
+)
+
+// DefaultSocket is where the server listens unless told otherwise
+const DefaultSocket = "/run/riscv-chat.sock"
+
+// Commands understood by the server
+const (
+	CmdStatus    = "status"
+	CmdBroadcast = "broadcast"
+	CmdShutdown  = "shutdown"
+	CmdUpgrade   = "upgrade"
+)
+
+// Request is one control command
+type Request struct {
+	Command string `json:"command"`
+	Text    string `json:"text,omitempty"` // broadcast: the announcement
+	Room    string `json:"room,omitempty"` // broadcast: limit to one room
+}
+
+// Response answers a Request
+type Response struct {
+	OK     bool    `json:"ok"`
+	Error  string  `json:"error,omitempty"`
+	Status *Status `json:"status,omitempty"`
+}
+
+// Status describes the running server
+type Status struct {
+	Listen   string    `json:"listen"`
+	Started  time.Time `json:"started"`
+	Messages uint64    `json:"messages"` // delivered to rooms since start
+	Rooms    int       `json:"rooms"`
+	Clients  []Client  `json:"clients"`
+	Runtime  Runtime   `json:"runtime"`
+	// Sent counts messages written to clients, and Writes the writes they
+	// took, fewer when broadcasts are batched
+	Sent   uint64 `json:"sent"`
+	Writes uint64 `json:"writes"`
+	// Build identifies the server binary
+	Build buildinfo.Info `json:"build"`
+}
+
+// Runtime is what the server costs the board, to compare connection modes
+type Runtime struct {
+	Mode       string        `json:"mode"` // goroutines or event-loop
+	Goroutines int           `json:"goroutines"`
+	Resident   uint64        `json:"resident"` // bytes of memory in use
+	Heap       uint64        `json:"heap"`     // bytes of Go heap in use
+	Stacks     uint64        `json:"stacks"`   // bytes of goroutine stacks
+	CPU        time.Duration `json:"cpu"`      // user and system time since start
+}
+
+// Client is a connected user
+type Client struct {
+	Name  string    `json:"name"`
+	Addr  string    `json:"addr"`
+	Room  string    `json:"room"`
+	Op    bool      `json:"op,omitempty"`
+	Since time.Time `json:"since"`
+}
+
+// Call sends req to the server listening on path and waits for the answer
+func Call(path string, req Request) (*Response, error) {
+	conn, err := net.DialTimeout("unix", path, 2*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, err
+	}
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		return nil, fmt.Errorf("no response: %w", err)
+	}
+	var resp Response
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return nil, err
+	}
+	if !resp.OK {
+		return &resp, fmt.Errorf("%s", resp.Error)
+	}
+	return &resp, nil
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"riscv-dev/pkg/agent"
+	"riscv-dev/pkg/sensor"
+	"riscv-dev/pkg/termout"
+)
+
+const (
+	// Alerts stay on the banner this long unless cleared with c
+	ALERT_HOLD = 5 * time.Minute
+
+	// Log lines kept for the log pane
+	DASHBOARD_LOG_LINES = 50
+)
+
+// dashboardSteps are the sparkline intervals + and - step through: each
+// column of a sparkline is the mean over one, so a longer interval shows a
+// longer stretch of history (up to history_duration)
+var dashboardSteps = []time.Duration{time.Second, 5 * time.Second, 15 * time.Second, time.Minute, 5 * time.Minute}
+
+// dashboard is a sink drawing a full-screen view of the channels for an
+// operator at a terminal, e.g. over SSH: current values, a sparkline of
+// each channel's recent history, an alert banner and the log, redrawn in
+// place instead of scrolling
+type dashboard struct {
+	agent  *agent.Agent
+	out    *os.File
+	board  string
+	redraw chan struct{}
+
+	mu      sync.Mutex
+	last    agent.Reading
+	count   int
+	paused  bool
+	step    int      // index into dashboardSteps
+	alerts  []string // newest last
+	alertAt time.Time
+	logs    []string
+}
+
+func newDashboard(a *agent.Agent) *dashboard {
+	return &dashboard{agent: a, out: os.Stdout, board: getBoardInfo(), redraw: make(chan struct{}, 1), step: 1}
+}
+
+// Name identifies the sink in status and health reports
+func (d *dashboard) Name() string { return "dashboard" }
+
+// Write keeps the reading for the next redraw, unless paused
+func (d *dashboard) Write(ctx context.Context, r agent.Reading) error {
+	d.mu.Lock()
+	d.count++
+	paused := d.paused
+	if !paused {
+		d.last = r
+	}
+	d.mu.Unlock()
+	if !paused {
+		d.poke()
+	}
+	return nil
+}
+
+func (d *dashboard) poke() {
+	select {
+	case d.redraw <- struct{}{}:
+	default:
+	}
+}
+
+// dashboardLog is the log pane, where log output goes while the
+// dashboard is up
+type dashboardLog struct{ d *dashboard }
+
+func (l dashboardLog) Write(p []byte) (int, error) {
+	l.d.mu.Lock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		l.d.logs = append(l.d.logs, line)
+	}
+	if n := len(l.d.logs); n > DASHBOARD_LOG_LINES {
+		l.d.logs = append(l.d.logs[:0], l.d.logs[n-DASHBOARD_LOG_LINES:]...)
+	}
+	l.d.mu.Unlock()
+	l.d.poke()
+	return len(p), nil
+}
+
+// run shows the dashboard until q is pressed or ctx is cancelled, then
+// restores the terminal
+func (d *dashboard) run(ctx context.Context) error {
+	restore, err := termout.Cbreak(os.Stdin)
+	if err != nil {
+		return fmt.Errorf("dashboard needs a terminal: %w", err)
+	}
+	defer restore()
+	// Alternate screen, cursor hidden, for as long as the dashboard is up
+	fmt.Fprint(d.out, "\x1b[?1049h\x1b[?25l")
+	defer fmt.Fprint(d.out, "\x1b[?25h\x1b[?1049l")
+	log.SetOutput(dashboardLog{d})
+	defer log.SetOutput(os.Stderr)
+
+	events, unsubscribe := d.agent.Subscribe(16)
+	defer unsubscribe()
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer signal.Stop(winch)
+	keys := make(chan byte)
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if n, err := os.Stdin.Read(buf); err != nil {
+				return
+			} else if n == 0 {
+				continue
+			}
+			select {
+			case keys <- buf[0]:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+
+	for {
+		fmt.Fprint(d.out, d.render(time.Now()))
+		select {
+		case <-d.redraw:
+		case <-winch:
+		case <-tick.C:
+		case e := <-events:
+			d.event(e)
+		case k := <-keys:
+			if !d.key(k) {
+				return nil
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// key acts on a key press, returning false to quit
+func (d *dashboard) key(k byte) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch k {
+	case 'q', 'Q':
+		return false
+	case 'p', 'P', ' ':
+		d.paused = !d.paused
+	case '+', '=':
+		d.step = min(d.step+1, len(dashboardSteps)-1)
+	case '-', '_':
+		d.step = max(d.step-1, 0)
+	case 'c', 'C':
+		d.alerts = nil
+	}
+	return true
+}
+
+// event puts alerts on the banner
+func (d *dashboard) event(e agent.Event) {
+	var text string
+	switch {
+	case e.Alert != nil:
+		text = fmt.Sprintf("%s %s: %s → %s", e.Alert.Time.Format("15:04:05"), sensorLabel(e.Alert.Channel), e.Alert.Previous, e.Alert.Quality)
+	case e.Forecast != nil && e.Forecast.Active:
+		text = fmt.Sprintf("%s %s forecast to go %s %g", e.Forecast.Time.Format("15:04:05"), sensorLabel(e.Forecast.Channel), e.Forecast.Direction, e.Forecast.Threshold)
+	case e.Disk != nil:
+		text = fmt.Sprintf("%s %s", e.Disk.Time.Format("15:04:05"), e.Disk.Message)
+	case e.Kernel != nil:
+		text = fmt.Sprintf("%s %s: %s", e.Kernel.Time.Format("15:04:05"), e.Kernel.Class, e.Kernel.Message)
+	default:
+		return
+	}
+	d.mu.Lock()
+	d.alerts = append(d.alerts, text)
+	if n := len(d.alerts); n > 3 {
+		d.alerts = d.alerts[n-3:]
+	}
+	d.alertAt = time.Now()
+	d.mu.Unlock()
+}
+
+// render draws a frame over the previous one
+func (d *dashboard) render(now time.Time) string {
+	cols, rows, err := termout.Size(d.out)
+	if err != nil || cols < 40 || rows < 10 {
+		cols, rows = 80, 24
+	}
+	f := d.agent.Display()
+	d.mu.Lock()
+	r, count, paused, step := d.last, d.count, d.paused, dashboardSteps[d.step]
+	if now.Sub(d.alertAt) > ALERT_HOLD {
+		d.alerts = nil
+	}
+	alerts := append([]string(nil), d.alerts...)
+	logs := append([]string(nil), d.logs...)
+	d.mu.Unlock()
+
+	var lines []string
+	state := "\x1b[32m● live\x1b[0m"
+	if paused {
+		state = "\x1b[33m❚❚ paused\x1b[0m"
+	}
+	clock := "waiting for the first reading"
+	if count > 0 {
+		clock = f.Clock(r.Time)
+	}
+	lines = append(lines, fmt.Sprintf("\x1b[1m📊 %s\x1b[0m  %s  sample #%d  %s", fit(d.board, cols/3), clock, count, state))
+
+	// Alert banner: channels not OK now, then recent alerts
+	var problems []string
+	for _, c := range r.Channels {
+		if c.Quality != sensor.OK {
+			problems = append(problems, sensorLabel(c.Name)+" "+c.Quality.String())
+		}
+	}
+	if len(problems) > 0 {
+		lines = append(lines, banner("⚠ "+strings.Join(problems, ", "), cols, "\x1b[41;97m"))
+	}
+	for i := len(alerts) - 1; i >= 0; i-- {
+		lines = append(lines, banner("! "+alerts[i], cols, "\x1b[43;30m"))
+	}
+	lines = append(lines, "")
+
+	// One row per channel: value, sparkline, range over the sparkline
+	const labelWidth, valueWidth, rangeWidth = 14, 13, 26
+	width := max(cols-labelWidth-valueWidth-rangeWidth-3, 10)
+	for _, c := range r.Channels {
+		precision := channelPrecision(c)
+		value := fmt.Sprintf("%*s", valueWidth, f.Value(c.Value, c.Unit, precision))
+		switch {
+		case c.Quality == sensor.Fault:
+			value = "\x1b[31m" + value + "\x1b[0m"
+		case c.Quality != sensor.OK:
+			value = "\x1b[33m" + value + "\x1b[0m"
+		}
+		spark, span := strings.Repeat(" ", width), ""
+		if s, err := d.agent.History(c.Name, r.Time.Add(-time.Duration(width)*step), r.Time, step); err == nil {
+			means := make([]float64, 0, len(s.Points))
+			lo, hi := math.Inf(1), math.Inf(-1)
+			for _, p := range s.Points {
+				if p.Mean == nil {
+					means = append(means, math.NaN())
+					continue
+				}
+				means = append(means, *p.Mean)
+				lo, hi = math.Min(lo, *p.Min), math.Max(hi, *p.Max)
+			}
+			if len(means) > width {
+				means = means[len(means)-width:]
+			}
+			for len(means) < width {
+				means = append([]float64{math.NaN()}, means...)
+			}
+			if lo <= hi {
+				spark = termout.Sparkline(means, lo, hi)
+				span = f.Value(lo, c.Unit, precision) + " – " + f.Value(hi, c.Unit, precision)
+			}
+		}
+		lines = append(lines, fmt.Sprintf("%s %s \x1b[36m%s\x1b[0m %s", pad(sensorLabel(c.Name), labelWidth), value, spark, fit(span, rangeWidth)))
+	}
+	lines = append(lines, "", fmt.Sprintf("\x1b[2mone column = %v, last %v\x1b[0m", step, time.Duration(width)*step))
+
+	var sinks []string
+	for _, st := range d.agent.SinkStatus() {
+		if st.Name == d.Name() {
+			continue
+		}
+		mark := "\x1b[32m✓\x1b[0m"
+		if !st.Healthy {
+			mark = "\x1b[31m✗\x1b[0m"
+		}
+		sinks = append(sinks, fmt.Sprintf("%s %s (%d queued)", mark, st.Name, st.Queued))
+	}
+	if len(sinks) > 0 {
+		lines = append(lines, "sinks: "+strings.Join(sinks, "  "))
+	}
+
+	// The log fills what is left above the key bindings
+	lines = append(lines, "")
+	if room := rows - len(lines) - 1; room > 0 {
+		if len(logs) > room {
+			logs = logs[len(logs)-room:]
+		}
+		for _, l := range logs {
+			lines = append(lines, "\x1b[2m"+fit(l, cols)+"\x1b[0m")
+		}
+		for room -= len(logs); room > 0; room-- {
+			lines = append(lines, "")
+		}
+	}
+	if len(lines) > rows-1 {
+		lines = lines[:rows-1]
+	}
+	lines = append(lines, "\x1b[7m q quit  p pause  +/- interval  c clear alerts \x1b[0m")
+	return "\x1b[H" + strings.Join(lines, "\x1b[K\n") + "\x1b[K\x1b[J"
+}
+
+// banner draws text across the screen in colour
+func banner(text string, cols int, colour string) string {
+	return colour + pad(" "+text, cols) + "\x1b[0m"
+}
+
+// fit shortens s to n characters
+func fit(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	r := []rune(s)
+	return string(r[:max(n-1, 0)]) + "…"
+}
+
+// pad fits s into exactly n characters
+func pad(s string, n int) string {
+	s = fit(s, n)
+	return s + strings.Repeat(" ", n-utf8.RuneCountInString(s))
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+
+	"riscv-dev/pkg/agent"
+	"riscv-dev/pkg/display"
+	"riscv-dev/pkg/hal"
+	"riscv-dev/pkg/sensor"
+	"riscv-dev/pkg/termout"
+)
+
+// console is a sink printing every reading to the console: a panel for
+// a person at a terminal, else one line per reading. Without an agent
+// (showing another process's readings) the raw ADC values and statistics
+// are left out.
+type console struct {
+	agent  *agent.Agent
+	format *display.Formatter
+	mode   termout.Mode
+	count  int
+}
+
+// Name identifies the sink in status and health reports
+func (d *console) Name() string { return "console" }
+
+// Write formats and displays sensor readings
+func (d *console) Write(ctx context.Context, r agent.Reading) error {
+	d.count++
+	if d.mode != termout.Pretty {
+		d.writeLine(r)
+		return nil
+	}
+	f := d.format
+	fmt.Printf("\n🌡️  SENSOR READINGS (%s)\n", f.Clock(r.Time))
+	fmt.Printf("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━\n")
+
+	for _, c := range r.Channels {
+		v, unit := f.Convert(c.Value, c.Unit)
+		fmt.Printf("%-14s %7s %s%s\n", sensorIcon(c.Name)+" "+sensorLabel(c.Name)+":", f.Number(v, f.Precision(c.Unit, channelPrecision(c))), unit, qualityNote(c))
+	}
+
+	if d.agent != nil {
+		d.writeDetails(f, r)
+	}
+
+	// Environmental assessment
+	displayEnvironmentalAssessment(f, r)
+
+	// Show sample counter
+	fmt.Printf("\n📊 Sample #%d completed\n", d.count)
+	fmt.Printf("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━\n")
+	return nil
+}
+
+// writeDetails prints the raw ADC values and recent statistics, which
+// only the agent sampling the channels has
+func (d *console) writeDetails(f *display.Formatter, r agent.Reading) {
+	fmt.Printf("\n🔧 RAW ADC VALUES:\n")
+	for _, c := range r.Channels {
+		if c.Raw == nil {
+			continue
+		}
+		voltage := hal.ToVoltage(d.agent.ADC(), *c.Raw)
+		fmt.Printf("  %s: %4d ADC (%sV)%s\n", sensorLabel(c.Name), *c.Raw, f.Number(voltage, 3), qualityNote(c))
+	}
+
+	fmt.Printf("\n📈 LAST %v:\n", STATS_WINDOW)
+	for _, c := range r.Channels {
+		if st, err := d.agent.GetStats(c.Name, STATS_WINDOW); err == nil && st.Count > 0 {
+			p := f.Precision(c.Unit, 2)
+			num := func(v float64) string { v, _ = f.Convert(v, c.Unit); return f.Number(v, p) }
+			delta := func(v float64) string { v, _ = f.ConvertDelta(v, c.Unit); return f.Number(v, p+1) }
+			rate := delta(st.RateOfChange)
+			if st.RateOfChange >= 0 {
+				rate = "+" + rate
+			}
+			fmt.Printf("  %-12s min %7s  mean %7s  max %7s  σ %5s  %s/s\n",
+				sensorLabel(c.Name), num(st.Min), num(st.Mean), num(st.Max), delta(st.StdDev), rate)
+		}
+	}
+}
+
+// writeLine prints a reading as one plain or JSON line: each channel's
+// value as measured, in its unit, and the quality of any that is not OK
+func (d *console) writeLine(r agent.Reading) {
+	fields := map[string]any{"sample": d.count}
+	level := "info"
+	for _, c := range r.Channels {
+		fields[c.Name] = c.Value
+		if c.Quality != sensor.OK {
+			fields[c.Name+"_quality"] = c.Quality.String()
+			level = "warn"
+		}
+	}
+	fmt.Fprint(os.Stdout, termout.Format(d.mode, r.Time, level, "reading", fields))
+}
+
+// channelPrecision returns the decimal places to show a channel with
+func channelPrecision(c agent.ChannelReading) int {
+	switch {
+	case c.Meta != nil && c.Meta.Precision != nil:
+		return *c.Meta.Precision
+	case c.Name == "light":
+		return 0
+	}
+	return 2
+}
+
+// qualityNote returns a suffix flagging a channel whose value is not OK
+func qualityNote(c agent.ChannelReading) string {
+	if c.Quality != sensor.OK {
+		return fmt.Sprintf(" ⚠️  [%s]", c.Quality)
+	}
+	return ""
+}
+
+// sensorLabel returns a human-readable sensor name
+func sensorLabel(name string) string {
+	switch name {
+	case "temperature":
+		return "Temperature"
+	case "light":
+		return "Light Level"
+	case "pressure":
+		return "Pressure"
+	default:
+		return name
+	}
+}
+
+func sensorIcon(name string) string {
+	switch name {
+	case "temperature":
+		return "🌡️"
+	case "light":
+		return "💡"
+	default:
+		return "📊"
+	}
+}
+
+// displayEnvironmentalAssessment provides environmental insights
+func displayEnvironmentalAssessment(f *display.Formatter, r agent.Reading) {
+	fmt.Printf("\n🏠 ENVIRONMENTAL ASSESSMENT:\n")
+
+	// Temperature assessment
+	if c, ok := r.Get("temperature"); ok {
+		switch {
+		case math.IsNaN(c.Value):
+			fmt.Printf("  ⚠️  Temperature sensor fault\n")
+		case c.Value < 15:
+			fmt.Printf("  ❄️  Cool environment (%s)\n", f.Value(c.Value, c.Unit, 1))
+		case c.Value > 25:
+			fmt.Printf("  ☀️  Warm environment (%s)\n", f.Value(c.Value, c.Unit, 1))
+		default:
+			fmt.Printf("  ✅ Comfortable temperature (%s)\n", f.Value(c.Value, c.Unit, 1))
+		}
+	}
+
+	// Light level assessment
+	if c, ok := r.Get("light"); ok {
+		switch {
+		case math.IsNaN(c.Value):
+			fmt.Printf("  ⚠️  Light sensor fault\n")
+		case c.Value < 50:
+			fmt.Printf("  🌙 Low light conditions (%s)\n", f.Value(c.Value, c.Unit, 0))
+		case c.Value > 500:
+			fmt.Printf("  ☀️  Bright environment (%s)\n", f.Value(c.Value, c.Unit, 0))
+		default:
+			fmt.Printf("  💡 Moderate lighting (%s)\n", f.Value(c.Value, c.Unit, 0))
+		}
+	}
+
+	// Pressure assessment
+	if c, ok := r.Get("pressure"); ok {
+		switch {
+		case math.IsNaN(c.Value):
+			fmt.Printf("  ⚠️  Pressure sensor fault\n")
+		case c.Value < 100:
+			fmt.Printf("  📉 Low pressure (%s)\n", f.Value(c.Value, c.Unit, 1))
+		case c.Value > 102:
+			fmt.Printf("  📈 High pressure (%s)\n", f.Value(c.Value, c.Unit, 1))
+		default:
+			fmt.Printf("  ✅ Normal atmospheric pressure (%s)\n", f.Value(c.Value, c.Unit, 1))
+		}
+	}
+}
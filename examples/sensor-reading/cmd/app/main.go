@@ -1,13 +1,23 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
-	"math"
-	"math/rand"
+	"log"
+	"math/bits"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
+
+	"riscv-dev/pkg/agent"
+	"riscv-dev/pkg/buildinfo"
+	"riscv-dev/pkg/config"
+	"riscv-dev/pkg/sensor"
+	"riscv-dev/pkg/sim"
+	"riscv-dev/pkg/termout"
 )
 
 const (
@@ -27,243 +37,160 @@ const (
 	LIGHT_MAX_LUX   = 1000 // Maximum lux value
 	PRESSURE_OFFSET = 1000 // ADC offset for 0 kPa
 	PRESSURE_SCALE  = 50.0 // ADC counts per kPa
-)
-
-// SensorData represents readings from all sensors
-type SensorData struct {
-	Timestamp   time.Time
-	Temperature float64     // °C
-	LightLevel  float64     // lux
-	Pressure    float64     // kPa
-	RawADC      map[int]int // Raw ADC values
-}
 
-// SensorManager handles sensor reading and processing
-type SensorManager struct {
-	adcChannels []int
-	lastReading SensorData
-}
+	// Window of the statistics shown with every reading
+	STATS_WINDOW = time.Minute
+)
 
-// NewSensorManager creates a new sensor manager
-func NewSensorManager() *SensorManager {
-	return &SensorManager{
-		adcChannels: []int{TEMPERATURE_PIN, LIGHT_PIN, PRESSURE_PIN},
-		lastReading: SensorData{
-			RawADC: make(map[int]int),
-		},
+// defaultConfig describes the example's sensors; config.json (or
+// $RISCV_DEV_CONFIG) overrides any of it
+func defaultConfig() agent.Config {
+	cfg := agent.DefaultConfig()
+	cfg.ADC.Resolution = ADC_MAX_VALUE
+	cfg.ADC.ReferenceVoltage = ADC_REFERENCE_V
+	cfg.SampleInterval = config.Duration(SAMPLE_INTERVAL)
+	cfg.Channels = []agent.ChannelConfig{
+		{Name: "temperature", Channel: TEMPERATURE_PIN, Unit: "°C", Offset: TEMP_OFFSET, Scale: TEMP_SCALE,
+			Range: sensor.Range{Min: ptr(-40.0), Max: ptr(85.0)}},
+		{Name: "light", Channel: LIGHT_PIN, Unit: "lux", Scale: ADC_MAX_VALUE / LIGHT_MAX_LUX,
+			Range: sensor.Range{Min: ptr(0.0), Max: ptr(float64(LIGHT_MAX_LUX))}},
+		{Name: "pressure", Channel: PRESSURE_PIN, Unit: "kPa", Offset: PRESSURE_OFFSET, Scale: PRESSURE_SCALE,
+			Range: sensor.Range{Min: ptr(30.0), Max: ptr(110.0)}},
 	}
+	return cfg
 }
 
-// readADCChannel simulates reading from an ADC channel
-// In a real implementation, this would interface with actual ADC hardware
-func (sm *SensorManager) readADCChannel(channel int) int {
-	// Simulate realistic ADC noise and variation
-	baseValue := sm.getBaseValueForChannel(channel)
-	noise := rand.Intn(21) - 10 // ±10 ADC counts noise
-	value := baseValue + noise
-
-	// Clamp to valid ADC range
-	if value < 0 {
-		value = 0
+func main() {
+	driver := flag.String("driver", "", "ADC backend: auto, iio, ads1115 or sim (overrides config)")
+	device := flag.String("device", "", "ADC device (IIO device name or I2C bus path)")
+	offline := flag.Bool("offline", false, "hold network sinks and buffer their readings (overrides config)")
+	output := flag.String("output", "auto", "output format: pretty, plain (key=value), json, or auto to pick pretty on a terminal")
+	tui := flag.Bool("tui", false, "full-screen dashboard with sparklines and alerts instead of scrolling readings (needs a terminal)")
+	subscribePath := flag.String("subscribe", "", "show the readings an agent publishes on this IPC socket instead of sampling")
+	buildinfo.RegisterFlag(flag.CommandLine)
+	flag.Parse()
+
+	mode, err := termout.ParseMode(*output)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
 	}
-	if value > ADC_MAX_VALUE {
-		value = ADC_MAX_VALUE
+	if *tui && *subscribePath != "" {
+		log.Fatalf("❌ -tui needs the agent in the same process; it cannot be used with -subscribe")
 	}
-
-	return value
-}
-
-// getBaseValueForChannel returns a realistic base value for each sensor type
-func (sm *SensorManager) getBaseValueForChannel(channel int) int {
-	switch channel {
-	case TEMPERATURE_PIN:
-		// Room temperature around 20-25°C
-		tempC := 20.0 + 5.0*math.Sin(float64(time.Now().Unix())/3600.0) // Daily temperature variation
-		return int(tempC*TEMP_SCALE) + TEMP_OFFSET
-
-	case LIGHT_PIN:
-		// Light level varies based on time of day
-		hour := time.Now().Hour()
-		var lightLevel float64
-		if hour >= 6 && hour <= 18 {
-			// Daylight hours
-			lightLevel = 500 + 300*math.Sin(math.Pi*float64(hour-6)/12.0)
-		} else {
-			// Night time
-			lightLevel = 10 + rand.Float64()*20
+	if *tui {
+		if !termout.IsTerminal(os.Stdin) || !termout.IsTerminal(os.Stdout) {
+			log.Fatalf("❌ -tui needs a terminal")
 		}
-		return int((lightLevel / LIGHT_MAX_LUX) * ADC_MAX_VALUE)
-
-	case PRESSURE_PIN:
-		// Atmospheric pressure around 101.3 kPa with small variations
-		pressure := 101.3 + 2.0*math.Sin(float64(time.Now().Unix())/1800.0)
-		return int(pressure*PRESSURE_SCALE) + PRESSURE_OFFSET
-
-	default:
-		return ADC_MAX_VALUE / 2 // Mid-range value
+		mode = termout.Pretty
 	}
-}
-
-// convertADCToVoltage converts ADC reading to voltage
-func (sm *SensorManager) convertADCToVoltage(adcValue int) float64 {
-	return float64(adcValue) * ADC_REFERENCE_V / ADC_MAX_VALUE
-}
-
-// convertADCToTemperature converts ADC reading to temperature in °C
-func (sm *SensorManager) convertADCToTemperature(adcValue int) float64 {
-	return float64(adcValue-TEMP_OFFSET) / TEMP_SCALE
-}
-
-// convertADCToLightLevel converts ADC reading to light level in lux
-func (sm *SensorManager) convertADCToLightLevel(adcValue int) float64 {
-	voltage := sm.convertADCToVoltage(adcValue)
-	// Simplified light sensor conversion (would be calibrated for specific sensor)
-	return voltage * (LIGHT_MAX_LUX / ADC_REFERENCE_V)
-}
-
-// convertADCToPressure converts ADC reading to pressure in kPa
-func (sm *SensorManager) convertADCToPressure(adcValue int) float64 {
-	return float64(adcValue-PRESSURE_OFFSET) / PRESSURE_SCALE
-}
-
-// readAllSensors reads data from all configured sensors
-func (sm *SensorManager) readAllSensors() SensorData {
-	data := SensorData{
-		Timestamp: time.Now(),
-		RawADC:    make(map[int]int),
+	if mode == "" {
+		mode = termout.Detect(os.Stdout)
 	}
+	termout.SetupLog(mode)
+	pretty := mode == termout.Pretty && !*tui
 
-	// Read raw ADC values
-	for _, channel := range sm.adcChannels {
-		rawValue := sm.readADCChannel(channel)
-		data.RawADC[channel] = rawValue
+	cfg := defaultConfig()
+	if err := config.Load("config.json", &cfg); err != nil {
+		log.Fatalf("❌ %v", err)
 	}
-
-	// Convert to physical units
-	data.Temperature = sm.convertADCToTemperature(data.RawADC[TEMPERATURE_PIN])
-	data.LightLevel = sm.convertADCToLightLevel(data.RawADC[LIGHT_PIN])
-	data.Pressure = sm.convertADCToPressure(data.RawADC[PRESSURE_PIN])
-
-	sm.lastReading = data
-	return data
-}
-
-// displaySensorData formats and displays sensor readings
-func (sm *SensorManager) displaySensorData(data SensorData) {
-	fmt.Printf("\n🌡️  SENSOR READINGS (%s)\n", data.Timestamp.Format("15:04:05"))
-	fmt.Printf("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━\n")
-
-	fmt.Printf("🌡️  Temperature: %6.2f °C\n", data.Temperature)
-	fmt.Printf("💡 Light Level:  %6.0f lux\n", data.LightLevel)
-	fmt.Printf("📊 Pressure:     %6.2f kPa\n", data.Pressure)
-
-	fmt.Printf("\n🔧 RAW ADC VALUES:\n")
-	for channel, value := range data.RawADC {
-		voltage := sm.convertADCToVoltage(value)
-		sensorName := sm.getSensorName(channel)
-		fmt.Printf("  %s (Ch%d): %4d ADC (%5.3fV)\n", sensorName, channel, value, voltage)
+	if err := agent.ApplyStraps(&cfg); err != nil {
+		log.Fatalf("❌ %v", err)
 	}
-
-	// Environmental assessment
-	sm.displayEnvironmentalAssessment(data)
-}
-
-// getSensorName returns human-readable sensor name
-func (sm *SensorManager) getSensorName(channel int) string {
-	switch channel {
-	case TEMPERATURE_PIN:
-		return "Temperature"
-	case LIGHT_PIN:
-		return "Light Sensor"
-	case PRESSURE_PIN:
-		return "Pressure"
-	default:
-		return fmt.Sprintf("Sensor Ch%d", channel)
+	if *driver != "" {
+		cfg.ADC.Driver = *driver
 	}
-}
-
-// displayEnvironmentalAssessment provides environmental insights
-func (sm *SensorManager) displayEnvironmentalAssessment(data SensorData) {
-	fmt.Printf("\n🏠 ENVIRONMENTAL ASSESSMENT:\n")
-
-	// Temperature assessment
-	switch {
-	case data.Temperature < 15:
-		fmt.Printf("  ❄️  Cool environment (%.1f°C)\n", data.Temperature)
-	case data.Temperature > 25:
-		fmt.Printf("  ☀️  Warm environment (%.1f°C)\n", data.Temperature)
-	default:
-		fmt.Printf("  ✅ Comfortable temperature (%.1f°C)\n", data.Temperature)
+	if *device != "" {
+		cfg.ADC.Device = *device
+	}
+	if *offline {
+		cfg.Offline = true
 	}
 
-	// Light level assessment
-	switch {
-	case data.LightLevel < 50:
-		fmt.Printf("  🌙 Low light conditions (%.0f lux)\n", data.LightLevel)
-	case data.LightLevel > 500:
-		fmt.Printf("  ☀️  Bright environment (%.0f lux)\n", data.LightLevel)
-	default:
-		fmt.Printf("  💡 Moderate lighting (%.0f lux)\n", data.LightLevel)
+	if pretty {
+		fmt.Println("📊 RISC-V Sensor Reading Example")
+		fmt.Printf("Board: %s\n", getBoardInfo())
+	}
+	if *subscribePath != "" {
+		runSubscriber(cfg, *subscribePath, mode)
+		return
 	}
 
-	// Pressure assessment
-	switch {
-	case data.Pressure < 100:
-		fmt.Printf("  📉 Low pressure (%.1f kPa)\n", data.Pressure)
-	case data.Pressure > 102:
-		fmt.Printf("  📈 High pressure (%.1f kPa)\n", data.Pressure)
-	default:
-		fmt.Printf("  ✅ Normal atmospheric pressure (%.1f kPa)\n", data.Pressure)
+	// Open the best available ADC backend (falls back to simulation)
+	a, err := agent.New(cfg)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
 	}
-}
+	defer a.Close()
 
-func main() {
-	fmt.Println("📊 RISC-V Sensor Reading Example")
-	fmt.Printf("Board: %s\n", getBoardInfo())
-	fmt.Printf("ADC Configuration: %d-bit, %.1fV reference\n", 12, ADC_REFERENCE_V)
-	fmt.Printf("Sample Interval: %v\n", SAMPLE_INTERVAL)
+	adc := a.ADC()
+	simADC, simulated := adc.(*sim.ADC)
+	if simulated {
+		simulateSensors(simADC, cfg.Channels, cfg.Coordinates)
+	}
+	if pretty {
+		if simulated {
+			fmt.Println("⚠️  Running in simulation mode (no physical ADC access)")
+		}
+		fmt.Printf("ADC Configuration: %d-bit, %.1fV reference\n", bits.Len(uint(adc.GetResolution())), adc.GetReferenceVoltage())
+		fmt.Printf("Sample Interval: %v\n", cfg.SampleInterval.D())
 
-	// Initialize sensor manager
-	sensorMgr := NewSensorManager()
+		// Display sensor configuration
+		fmt.Printf("\n🔧 CONFIGURED SENSORS:\n")
+		for _, ch := range cfg.Channels {
+			fmt.Printf("  Channel %d: %s\n", ch.Channel, sensorLabel(ch.Name))
+		}
 
-	// Display sensor configuration
-	fmt.Printf("\n🔧 CONFIGURED SENSORS:\n")
-	for _, channel := range sensorMgr.adcChannels {
-		sensorName := sensorMgr.getSensorName(channel)
-		fmt.Printf("  Channel %d: %s\n", channel, sensorName)
+		fmt.Printf("\n📈 Starting sensor monitoring...\n")
+		fmt.Printf("Press Ctrl+C to stop\n\n")
+	} else if !*tui {
+		log.Printf("Sensor monitoring started on %s, %d channels every %v, simulated=%t",
+			getBoardInfo(), len(cfg.Channels), cfg.SampleInterval.D(), simulated)
 	}
 
-	fmt.Printf("\n📈 Starting sensor monitoring...\n")
-	fmt.Printf("Press Ctrl+C to stop\n\n")
+	var sink agent.Sink = &console{agent: a, format: a.Display(), mode: mode}
+	var dash *dashboard
+	if *tui {
+		dash = newDashboard(a)
+		sink = dash
+	}
+	if err := a.AddSink(sink); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
 
 	// Handle graceful shutdown
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-
-	// Main sensor reading loop
-	ticker := time.NewTicker(SAMPLE_INTERVAL)
-	defer ticker.Stop()
-
-	sampleCount := 0
-
-	for {
-		select {
-		case <-ticker.C:
-			sampleCount++
-			data := sensorMgr.readAllSensors()
-			sensorMgr.displaySensorData(data)
-
-			// Show sample counter
-			fmt.Printf("\n📊 Sample #%d completed\n", sampleCount)
-			fmt.Printf("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━\n")
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	ctx, quit := context.WithCancel(ctx)
+	defer quit()
+	dashDone := make(chan struct{})
+	if dash != nil {
+		go func() {
+			defer close(dashDone)
+			defer quit()
+			if err := dash.run(ctx); err != nil {
+				log.Printf("❌ %v", err)
+			}
+		}()
+	} else {
+		close(dashDone)
+	}
+	if err := a.Run(ctx); err != nil {
+		log.Printf("❌ %v", err)
+	}
+	quit()
+	<-dashDone
 
-		case <-sigChan:
-			fmt.Println("\n🛑 Shutting down sensor monitoring...")
-			fmt.Printf("Total samples collected: %d\n", sampleCount)
-			fmt.Println("✅ Sensor monitoring stopped")
-			return
-		}
+	if *tui {
+		fmt.Printf("✅ Sensor monitoring stopped after %d samples\n", a.SampleCount())
+		return
+	}
+	if !pretty {
+		log.Printf("Sensor monitoring stopped after %d samples", a.SampleCount())
+		return
 	}
+	fmt.Println("\n🛑 Shutting down sensor monitoring...")
+	fmt.Printf("Total samples collected: %d\n", a.SampleCount())
+	fmt.Println("✅ Sensor monitoring stopped")
 }
 
 // getBoardInfo attempts to identify the RISC-V board
@@ -276,9 +203,11 @@ func getBoardInfo() string {
 
 	for _, file := range boardFiles {
 		if data, err := os.ReadFile(file); err == nil {
-			return string(data)
+			return strings.TrimRight(string(data), "\x00\n ")
 		}
 	}
 
 	return "Unknown RISC-V Board"
 }
+
+func ptr(v float64) *float64 { return &v }
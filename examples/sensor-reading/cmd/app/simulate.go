@@ -0,0 +1,72 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"riscv-dev/pkg/agent"
+	"riscv-dev/pkg/schedule"
+	"riscv-dev/pkg/sim"
+)
+
+// simulateSensors feeds the simulator with realistic signals for each
+// sensor, with daylight following the sun at coords if set
+func simulateSensors(adc *sim.ADC, channels []agent.ChannelConfig, coords *schedule.Coordinates) {
+	for _, ch := range channels {
+		channel := ch.Channel
+		adc.SetSource(channel, func() int { return getBaseValueForChannel(channel, coords) })
+	}
+}
+
+// daylight returns the sunrise and sunset of t's day at coords, or 06:00
+// and 18:00 without coordinates. Without a sunrise or sunset that day,
+// both are midnight, with the day between them if the sun stays up.
+func daylight(t time.Time, coords *schedule.Coordinates) (rise, set time.Time) {
+	y, m, d := t.Date()
+	midnight := time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	if coords == nil {
+		return midnight.Add(6 * time.Hour), midnight.Add(18 * time.Hour)
+	}
+	rise, up, ok1 := coords.SunTimes(t, schedule.Sunrise)
+	set, _, ok2 := coords.SunTimes(t, schedule.Sunset)
+	switch {
+	case ok1 && ok2:
+		return rise, set
+	case up:
+		return midnight, midnight.AddDate(0, 0, 1)
+	}
+	return midnight, midnight
+}
+
+// getBaseValueForChannel returns a realistic simulated base value for each sensor type
+func getBaseValueForChannel(channel int, coords *schedule.Coordinates) int {
+	switch channel {
+	case TEMPERATURE_PIN:
+		// Room temperature around 20-25°C
+		tempC := 20.0 + 5.0*math.Sin(float64(time.Now().Unix())/3600.0) // Daily temperature variation
+		return int(tempC*TEMP_SCALE) + TEMP_OFFSET
+
+	case LIGHT_PIN:
+		// Light level follows the sun across the day
+		now := time.Now()
+		rise, set := daylight(now, coords)
+		var lightLevel float64
+		if now.After(rise) && now.Before(set) {
+			// Daylight hours
+			lightLevel = 500 + 300*math.Sin(math.Pi*now.Sub(rise).Seconds()/set.Sub(rise).Seconds())
+		} else {
+			// Night time
+			lightLevel = 10 + rand.Float64()*20
+		}
+		return int((lightLevel / LIGHT_MAX_LUX) * ADC_MAX_VALUE)
+
+	case PRESSURE_PIN:
+		// Atmospheric pressure around 101.3 kPa with small variations
+		pressure := 101.3 + 2.0*math.Sin(float64(time.Now().Unix())/1800.0)
+		return int(pressure*PRESSURE_SCALE) + PRESSURE_OFFSET
+
+	default:
+		return ADC_MAX_VALUE / 2 // Mid-range value
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"riscv-dev/pkg/agent"
+	"riscv-dev/pkg/display"
+	"riscv-dev/pkg/metrics"
+	"riscv-dev/pkg/termout"
+)
+
+// Wait between attempts to reach the agent's IPC socket
+const SUBSCRIBE_RETRY = 2 * time.Second
+
+// runSubscriber is main for -subscribe: the display process, showing
+// readings until interrupted
+func runSubscriber(cfg agent.Config, path string, mode termout.Mode) {
+	var format *display.Formatter
+	if cfg.Display != nil {
+		var err error
+		if format, err = display.New(*cfg.Display); err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+	}
+	d := &console{format: format, mode: mode}
+	dedup := agent.NewDedup(metrics.Default)
+	if mode == termout.Pretty {
+		fmt.Printf("Readings from: %s\n", path)
+		fmt.Printf("Press Ctrl+C to stop\n\n")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	missed := subscribe(ctx, path, d, dedup)
+	if mode == termout.Pretty {
+		fmt.Printf("\n✅ Showed %d readings, %d missed\n", d.count, missed)
+	} else {
+		log.Printf("Showed %d readings, %d missed", d.count, missed)
+	}
+}
+
+// subscribe shows the readings another agent process publishes on its IPC
+// socket instead of sampling, so the display can run as its own process:
+// unprivileged, or restarted without a gap in the agent's history. It
+// reconnects whenever the agent restarts, until ctx is cancelled, and
+// returns how many readings were missed, going by their sequence numbers.
+func subscribe(ctx context.Context, path string, d *console, dedup *agent.Dedup) uint64 {
+	var missed uint64
+	waiting := false
+	for ctx.Err() == nil {
+		stream, err := agent.SubscribeReadings(path)
+		if err != nil {
+			if !waiting {
+				log.Printf("⚠️  Waiting for the agent on %s: %v", path, err)
+				waiting = true
+			}
+			select {
+			case <-time.After(SUBSCRIBE_RETRY):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		waiting = false
+		log.Printf("✅ Showing readings of %s from %s", sourceName(stream.Source()), path)
+
+		stop := context.AfterFunc(ctx, func() { stream.Close() })
+		for {
+			r, err := stream.Next()
+			if err != nil {
+				if ctx.Err() == nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+					log.Printf("❌ Reading stream: %v", err)
+				} else if ctx.Err() == nil {
+					log.Printf("⚠️  The agent on %s stopped", path)
+				}
+				break
+			}
+			res, ok := dedup.Check(r)
+			if res.Missed > 0 {
+				log.Printf("⚠️  Missed %d readings before #%d", res.Missed, r.Seq)
+			}
+			missed += res.Missed
+			if ok {
+				d.Write(ctx, r)
+			}
+		}
+		stop()
+		stream.Close()
+	}
+	return missed
+}
+
+// sourceName describes the publishing agent by its namespace path
+func sourceName(source string) string {
+	if source == "" {
+		return "the agent"
+	}
+	return source
+}
@@ -2,4 +2,8 @@ module riscv-sensor-reading
 
 go 1.21
 
-// No external dependencies - uses only standard library
+require riscv-dev v0.0.0
+
+// Shared HAL from this repository (falls back to ADC simulation when no
+// hardware backend is available). No external dependencies.
+replace riscv-dev => ../..
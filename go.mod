@@ -0,0 +1,5 @@
+module riscv-dev
+
+go 1.21
+
+// No external dependencies - uses only standard library
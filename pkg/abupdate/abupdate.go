@@ -0,0 +1,372 @@
+// Package abupdate updates a board's root filesystem with an A/B scheme:
+// a new image is written to the slot not running, U-Boot is told to boot
+// it on trial, and the new system confirms itself once healthy. A slot
+// that fails to come up is abandoned by U-Boot's boot counter, and one
+// that comes up unhealthy rolls itself back.
+//
+// The handshake uses these U-Boot environment variables, which the boot
+// script reads (see the docs for a script):
+//
+//	boot_slot           slot to boot, "a" or "b"
+//	boot_slot_previous  slot to fall back to
+//	upgrade_available   1 while boot_slot is on trial
+//	bootcount           boots of the trial slot, counted by U-Boot
+//	bootlimit           boots after which U-Boot runs altbootcmd
+//	boot_slot_failed    trial slot abandoned by altbootcmd
+package abupdate
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"riscv-dev/pkg/ubootenv"
+)
+
+// U-Boot environment variables of the handshake
+const (
+	VarSlot     = "boot_slot"
+	VarPrevious = "boot_slot_previous"
+	VarUpgrade  = "upgrade_available"
+	VarCount    = "bootcount"
+	VarLimit    = "bootlimit"
+	VarFailed   = "boot_slot_failed"
+)
+
+var (
+	// ErrPending means the running slot is on trial and must be confirmed
+	// or rolled back before another update
+	ErrPending = errors.New("abupdate: the running slot is on trial; confirm or roll it back first")
+	// ErrRolledBack means U-Boot gave up on the trial slot and booted the
+	// previous one
+	ErrRolledBack = errors.New("abupdate: the update was rolled back by the boot loader")
+)
+
+// Config names the two root filesystem slots
+type Config struct {
+	// Slots maps slot names, as stored in boot_slot, to block devices:
+	// {"a": "/dev/mmcblk0p2", "b": "/dev/mmcblk0p3"}
+	Slots map[string]string `json:"slots"`
+	// FWEnvConfig locates the U-Boot environment, default
+	// /etc/fw_env.config
+	FWEnvConfig string `json:"fw_env_config,omitempty"`
+	// BootLimit is how many times U-Boot tries a new slot before falling
+	// back, default 3
+	BootLimit int `json:"boot_limit,omitempty"`
+	// StateFile, on a data partition shared by both slots, counts update
+	// attempts and their outcomes across reboots (see riscv-dev ota)
+	StateFile string `json:"state_file,omitempty"`
+}
+
+// Updater installs, confirms and rolls back updates
+type Updater struct {
+	cfg   Config
+	locs  []ubootenv.Location
+	names []string
+
+	running func() (string, error)
+}
+
+// New checks cfg and locates the U-Boot environment
+func New(cfg Config) (*Updater, error) {
+	if len(cfg.Slots) != 2 {
+		return nil, fmt.Errorf("abupdate: want two slots, have %d", len(cfg.Slots))
+	}
+	if cfg.BootLimit <= 0 {
+		cfg.BootLimit = 3
+	}
+	locs, err := ubootenv.LoadConfig(cfg.FWEnvConfig)
+	if err != nil {
+		return nil, fmt.Errorf("abupdate: %w", err)
+	}
+	u := &Updater{cfg: cfg, locs: locs}
+	for name := range cfg.Slots {
+		u.names = append(u.names, name)
+	}
+	sort.Strings(u.names)
+	u.running = u.rootSlot
+	return u, nil
+}
+
+// Status is the state of the slots
+type Status struct {
+	Running   string // slot of the running root filesystem
+	Boot      string // slot U-Boot boots next
+	Previous  string // slot U-Boot falls back to
+	Trial     bool   // Boot is on trial
+	BootCount int    // boots of the trial slot so far
+	Failed    string // slot the boot loader gave up on, until the next update
+}
+
+// Status reads the running slot and the boot loader's state
+func (u *Updater) Status(ctx context.Context) (Status, error) {
+	var s Status
+	running, err := u.running()
+	if err != nil {
+		return s, err
+	}
+	env, err := ubootenv.Read(ctx, u.locs)
+	if err != nil {
+		return s, err
+	}
+	s.Running = running
+	s.Boot, _ = env.Get(VarSlot)
+	if s.Boot == "" {
+		s.Boot = running
+	}
+	s.Previous, _ = env.Get(VarPrevious)
+	upgrade, _ := env.Get(VarUpgrade)
+	s.Trial = upgrade == "1"
+	count, _ := env.Get(VarCount)
+	s.BootCount, _ = strconv.Atoi(count)
+	s.Failed, _ = env.Get(VarFailed)
+	return s, nil
+}
+
+// other returns the slot that isn't slot
+func (u *Updater) other(slot string) string {
+	if u.names[0] == slot {
+		return u.names[1]
+	}
+	return u.names[0]
+}
+
+// rootSlot finds the slot mounted as the root filesystem
+func (u *Updater) rootSlot() (string, error) {
+	var root syscall.Stat_t
+	if err := syscall.Stat("/", &root); err != nil {
+		return "", err
+	}
+	for name, dev := range u.cfg.Slots {
+		var st syscall.Stat_t
+		if err := syscall.Stat(dev, &st); err == nil && st.Mode&syscall.S_IFMT == syscall.S_IFBLK && st.Rdev == root.Dev {
+			return name, nil
+		}
+	}
+	return "", errors.New("abupdate: the root filesystem is on neither slot")
+}
+
+// Install writes an image to the slot not running, verifies it, and has
+// U-Boot boot it on trial from the next boot on. A non-empty sum is the
+// image's expected SHA-256 in hex. progress, if not nil, is called with
+// the bytes written so far.
+func (u *Updater) Install(ctx context.Context, image io.Reader, sum string, progress func(int64)) (string, error) {
+	s, err := u.Status(ctx)
+	if err != nil {
+		return "", err
+	}
+	if s.Trial && s.Running == s.Boot {
+		return "", ErrPending
+	}
+	target := u.other(s.Running)
+	dev := u.cfg.Slots[target]
+	if mounted(dev) {
+		return "", fmt.Errorf("abupdate: slot %s (%s) is mounted", target, dev)
+	}
+
+	written, err := writeImage(ctx, dev, image, progress)
+	if err != nil {
+		return "", fmt.Errorf("abupdate: slot %s: %w", target, err)
+	}
+	got, err := hashDevice(ctx, dev, written.n)
+	if err != nil {
+		return "", fmt.Errorf("abupdate: verify slot %s: %w", target, err)
+	}
+	if got != written.sum {
+		return "", fmt.Errorf("abupdate: verify slot %s: read back %s, wrote %s", target, got, written.sum)
+	}
+	if sum != "" && !strings.EqualFold(sum, got) {
+		return "", fmt.Errorf("abupdate: image checksum %s, want %s", got, sum)
+	}
+
+	env, err := ubootenv.Read(ctx, u.locs)
+	if err != nil {
+		return "", err
+	}
+	for _, kv := range [][2]string{
+		{VarSlot, target},
+		{VarPrevious, s.Running},
+		{VarUpgrade, "1"},
+		{VarCount, "0"},
+		{VarLimit, strconv.Itoa(u.cfg.BootLimit)},
+		{VarFailed, ""},
+	} {
+		if err := env.Set(kv[0], kv[1]); err != nil {
+			return "", err
+		}
+	}
+	return target, env.Save(ctx)
+}
+
+type imageWritten struct {
+	n   int64
+	sum string
+}
+
+func writeImage(ctx context.Context, dev string, image io.Reader, progress func(int64)) (imageWritten, error) {
+	var w imageWritten
+	f, err := os.OpenFile(dev, os.O_WRONLY, 0)
+	if err != nil {
+		return w, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	buf := make([]byte, 1<<20)
+	for {
+		if err := ctx.Err(); err != nil {
+			return w, err
+		}
+		n, rerr := io.ReadFull(image, buf)
+		if n > 0 {
+			if _, err := f.Write(buf[:n]); err != nil {
+				return w, err
+			}
+			h.Write(buf[:n])
+			w.n += int64(n)
+			if progress != nil {
+				progress(w.n)
+			}
+		}
+		if rerr == io.EOF || rerr == io.ErrUnexpectedEOF {
+			break
+		}
+		if rerr != nil {
+			return w, rerr
+		}
+	}
+	if err := f.Sync(); err != nil {
+		return w, err
+	}
+	w.sum = hex.EncodeToString(h.Sum(nil))
+	return w, f.Close()
+}
+
+// hashDevice hashes the first n bytes of dev
+func hashDevice(ctx context.Context, dev string, n int64) (string, error) {
+	f, err := os.Open(dev)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, io.LimitReader(ctxReader{ctx, f}, n)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// mounted reports whether dev appears in /proc/mounts
+func mounted(dev string) bool {
+	f, err := os.Open("/proc/mounts")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) > 0 && fields[0] == dev {
+			return true
+		}
+	}
+	return false
+}
+
+// Confirm ends the trial of the running slot, making it permanent. It
+// returns ErrRolledBack if the boot loader fell back to the previous slot
+// instead, and does nothing if no slot is on trial.
+func (u *Updater) Confirm(ctx context.Context) error {
+	s, err := u.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if !s.Trial {
+		if s.Failed != "" && s.Failed != s.Running {
+			return fmt.Errorf("%w (slot %s failed to boot)", ErrRolledBack, s.Failed)
+		}
+		return nil
+	}
+	if s.Running != s.Boot {
+		return fmt.Errorf("abupdate: slot %s is on trial but slot %s is running; reboot first", s.Boot, s.Running)
+	}
+	env, err := ubootenv.Read(ctx, u.locs)
+	if err != nil {
+		return err
+	}
+	env.Set(VarUpgrade, "0")
+	env.Set(VarCount, "0")
+	return env.Save(ctx)
+}
+
+// Rollback makes the boot loader boot the other slot from the next boot
+// on, ending any trial
+func (u *Updater) Rollback(ctx context.Context) (string, error) {
+	s, err := u.Status(ctx)
+	if err != nil {
+		return "", err
+	}
+	target := s.Previous
+	if target == "" || target == s.Running {
+		target = u.other(s.Running)
+	}
+	env, err := ubootenv.Read(ctx, u.locs)
+	if err != nil {
+		return "", err
+	}
+	for _, kv := range [][2]string{{VarSlot, target}, {VarPrevious, s.Running}, {VarUpgrade, "0"}, {VarCount, "0"}} {
+		if err := env.Set(kv[0], kv[1]); err != nil {
+			return "", err
+		}
+	}
+	return target, env.Save(ctx)
+}
+
+// WaitHealthy polls a health endpoint, such as the agent's /healthz,
+// until it answers 200 OK or ctx is done
+func WaitHealthy(ctx context.Context, url string) error {
+	client := &http.Client{Timeout: 5 * time.Second}
+	var last error
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+			err = fmt.Errorf("%s answered %s", url, resp.Status)
+		}
+		last = err
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("abupdate: not healthy: %w", last)
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package abupdate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"riscv-dev/pkg/ubootenv"
+)
+
+func TestUpdate(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	envPath := filepath.Join(dir, "env.img")
+	os.WriteFile(envPath, make([]byte, 0x800), 0644)
+	u := &Updater{
+		cfg:   Config{Slots: map[string]string{"a": filepath.Join(dir, "a.img"), "b": filepath.Join(dir, "b.img")}, BootLimit: 3},
+		locs:  []ubootenv.Location{{Device: envPath, Size: 0x400}, {Device: envPath, Offset: 0x400, Size: 0x400}},
+		names: []string{"a", "b"},
+	}
+	env, _ := ubootenv.Read(ctx, u.locs) // blank: no valid copy yet
+	env.Set("bootcmd", "run ab_bootcmd")
+	if err := env.Save(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, slot := range u.cfg.Slots {
+		os.WriteFile(slot, make([]byte, 3<<20), 0644)
+	}
+	running := "a"
+	u.running = func() (string, error) { return running, nil }
+
+	image := bytes.Repeat([]byte("rootfs"), 500000)
+	if _, err := u.Install(ctx, bytes.NewReader(image), "0000", nil); err == nil {
+		t.Fatal("wrong checksum accepted")
+	}
+	slot, err := u.Install(ctx, bytes.NewReader(image), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	written, _ := os.ReadFile(u.cfg.Slots["b"])
+	if slot != "b" || !bytes.Equal(written[:len(image)], image) {
+		t.Fatalf("installed to %s", slot)
+	}
+	s, _ := u.Status(ctx)
+	if s.Boot != "b" || s.Previous != "a" || !s.Trial {
+		t.Errorf("after install: %+v", s)
+	}
+
+	// Booted into b on trial: another install must wait for the verdict
+	running = "b"
+	if _, err := u.Install(ctx, bytes.NewReader(image), "", nil); !errors.Is(err, ErrPending) {
+		t.Errorf("install during trial: got %v", err)
+	}
+	if err := u.Confirm(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := u.Status(ctx); s.Trial || s.Boot != "b" {
+		t.Errorf("after confirm: %+v", s)
+	}
+
+	// The next update goes to a; the boot loader gives up on it
+	if slot, err := u.Install(ctx, bytes.NewReader(image), "", nil); err != nil || slot != "a" {
+		t.Fatalf("second install: %s, %v", slot, err)
+	}
+	env, _ = ubootenv.Read(ctx, u.locs)
+	if err := u.Confirm(ctx); err == nil {
+		t.Error("confirm before rebooting into the trial slot succeeded")
+	}
+	env.Set(VarFailed, "a") // as altbootcmd does
+	env.Set(VarSlot, "b")
+	env.Set(VarUpgrade, "0")
+	env.Save(ctx)
+	if err := u.Confirm(ctx); !errors.Is(err, ErrRolledBack) {
+		t.Errorf("confirm after fallback: got %v", err)
+	}
+
+	if slot, err := u.Rollback(ctx); err != nil || slot != "a" {
+		t.Errorf("rollback: %s, %v", slot, err)
+	}
+	if s, _ := u.Status(ctx); s.Trial || s.Boot != "a" {
+		t.Errorf("after rollback: %+v", s)
+	}
+}
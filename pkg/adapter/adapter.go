@@ -0,0 +1,322 @@
+// Package adapter runs out-of-tree sensor drivers as subprocesses, so a
+// proprietary sensor can be integrated without forking the agent or
+// linking against it. The driver is any program speaking JSON lines on
+// stdin and stdout; what it writes to stderr goes to the agent's log.
+//
+// The agent first sends hello with the driver's options from config.json,
+// and the driver answers with the channels it provides:
+//
+//	→ {"id":1,"op":"hello","options":{"bus":"/dev/i2c-1"}}
+//	← {"id":1,"channels":[{"name":"co2","unit":"ppm","meta":{"model":"SCD41"}}]}
+//
+// Then, every sample interval, it asks for each channel's value; value
+// null (or quality "sensor-fault") marks a fault, and an error makes the
+// agent repeat the previous value as stale:
+//
+//	→ {"id":2,"op":"read","channel":"co2"}
+//	← {"id":2,"value":412.5,"quality":"ok"}
+//	← {"id":2,"error":"sensor busy"}
+//
+// Responses carry the id of their request. A driver that exits is started
+// again, and sent hello again, on a later read. Drivers written in Go can
+// use Serve instead of implementing the protocol.
+package adapter
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"riscv-dev/pkg/sensor"
+)
+
+// Channel is a channel a driver provides
+type Channel struct {
+	Name string           `json:"name"`
+	Unit string           `json:"unit,omitempty"`
+	Meta *sensor.Metadata `json:"meta,omitempty"`
+}
+
+// Request is a line the agent sends a driver
+type Request struct {
+	ID      uint64          `json:"id"`
+	Op      string          `json:"op"`                // "hello" or "read"
+	Options json.RawMessage `json:"options,omitempty"` // hello
+	Channel string          `json:"channel,omitempty"` // read
+}
+
+// Response is a driver's answer to a Request
+type Response struct {
+	ID       uint64         `json:"id"`
+	Channels []Channel      `json:"channels,omitempty"` // hello
+	Value    *float64       `json:"value,omitempty"`    // read; null for a fault
+	Quality  sensor.Quality `json:"quality,omitempty"`  // read; default ok
+	Error    string         `json:"error,omitempty"`
+}
+
+// helloTimeout bounds how long a driver may take to start up
+const helloTimeout = 10 * time.Second
+
+// restartDelay is the least time between starts of a driver that keeps
+// exiting
+const restartDelay = 5 * time.Second
+
+// Process is a running driver. It is safe for concurrent use, though
+// requests are answered one at a time.
+type Process struct {
+	command []string
+	options json.RawMessage
+
+	mu       sync.Mutex
+	channels []Channel
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	lines    chan []byte // responses, closed when the driver exits
+	nextID   uint64
+	started  time.Time
+	closed   bool
+}
+
+// Start runs command, sends it options and waits for the channels it
+// provides
+func Start(command []string, options json.RawMessage) (*Process, error) {
+	if len(command) == 0 {
+		return nil, errors.New("adapter: no command")
+	}
+	p := &Process{command: command, options: options}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.start(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Channels returns the channels the driver provides
+func (p *Process) Channels() []Channel {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.channels
+}
+
+// start runs the driver and exchanges hello; p.mu is held
+func (p *Process) start() error {
+	p.started = time.Now()
+	cmd := exec.Command(p.command[0], p.command[1:]...)
+	cmd.Stderr = os.Stderr
+	// Its own process group, so Ctrl+C stops the agent, which then stops
+	// the driver, rather than the driver under it
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("adapter %s: %w", p.command[0], err)
+	}
+	lines := make(chan []byte, 1)
+	go func() {
+		defer close(lines)
+		sc := bufio.NewScanner(stdout)
+		sc.Buffer(make([]byte, 0, 4096), 1<<20)
+		for sc.Scan() {
+			lines <- append([]byte(nil), sc.Bytes()...)
+		}
+		cmd.Wait()
+	}()
+	p.cmd, p.stdin, p.lines = cmd, stdin, lines
+
+	ctx, cancel := context.WithTimeout(context.Background(), helloTimeout)
+	defer cancel()
+	resp, err := p.call(ctx, Request{Op: "hello", Options: p.options})
+	if err == nil && resp.Error != "" {
+		err = errors.New(resp.Error)
+	}
+	if err == nil && len(resp.Channels) == 0 {
+		err = errors.New("no channels")
+	}
+	if err != nil {
+		p.stop()
+		return fmt.Errorf("adapter %s: hello: %w", p.command[0], err)
+	}
+	if p.channels == nil {
+		p.channels = resp.Channels
+	}
+	return nil
+}
+
+// call sends req and waits for its response; p.mu is held
+func (p *Process) call(ctx context.Context, req Request) (Response, error) {
+	p.nextID++
+	req.ID = p.nextID
+	b, err := json.Marshal(req)
+	if err != nil {
+		return Response{}, err
+	}
+	if _, err := p.stdin.Write(append(b, '\n')); err != nil {
+		return Response{}, fmt.Errorf("driver exited: %w", err)
+	}
+	for {
+		select {
+		case line, ok := <-p.lines:
+			if !ok {
+				return Response{}, errors.New("driver exited")
+			}
+			var resp Response
+			if err := json.Unmarshal(line, &resp); err != nil {
+				return Response{}, fmt.Errorf("invalid response %q: %w", line, err)
+			}
+			if resp.ID < req.ID {
+				continue // answer to a request that timed out
+			}
+			return resp, nil
+		case <-ctx.Done():
+			return Response{}, ctx.Err()
+		}
+	}
+}
+
+// stop ends the driver; p.mu is held
+func (p *Process) stop() {
+	if p.cmd == nil {
+		return
+	}
+	p.stdin.Close()
+	// Closing stdin asks the driver to exit; one that doesn't is killed
+	select {
+	case <-drain(p.lines):
+	case <-time.After(time.Second):
+		p.cmd.Process.Kill()
+		<-drain(p.lines)
+	}
+	p.cmd = nil
+}
+
+// drain discards lines until the driver exits, then closes the returned
+// channel
+func drain(lines chan []byte) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		for range lines {
+		}
+		close(done)
+	}()
+	return done
+}
+
+// Read asks the driver for the value of channel, restarting it first if
+// it has exited
+func (p *Process) Read(ctx context.Context, channel string) (float64, sensor.Quality, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return 0, sensor.Fault, errors.New("adapter closed")
+	}
+	if p.cmd == nil || p.exited() {
+		p.stop()
+		if time.Since(p.started) < restartDelay {
+			return 0, sensor.Fault, fmt.Errorf("driver %s exited", p.command[0])
+		}
+		if err := p.start(); err != nil {
+			return 0, sensor.Fault, err
+		}
+	}
+	resp, err := p.call(ctx, Request{Op: "read", Channel: channel})
+	if err != nil {
+		return 0, sensor.Fault, err
+	}
+	if resp.Error != "" {
+		return 0, sensor.Fault, errors.New(resp.Error)
+	}
+	if resp.Value == nil {
+		return math.NaN(), sensor.Fault, nil
+	}
+	return *resp.Value, resp.Quality, nil
+}
+
+// exited reports whether the driver has closed its stdout; p.mu is held
+func (p *Process) exited() bool {
+	select {
+	case _, ok := <-p.lines:
+		// A line nobody asked for is dropped
+		return !ok
+	default:
+		return false
+	}
+}
+
+// Close stops the driver
+func (p *Process) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	p.stop()
+	return nil
+}
+
+// Driver is a sensor driver served with Serve
+type Driver interface {
+	// Open configures the driver with the options from config.json and
+	// returns the channels it provides
+	Open(options json.RawMessage) ([]Channel, error)
+	// Read returns the current value of a channel and its quality
+	Read(ctx context.Context, channel string) (float64, sensor.Quality, error)
+}
+
+// Serve implements the protocol for d on stdin and stdout, until stdin
+// closes. A driver program's main is then just
+//
+//	if err := adapter.Serve(&myDriver{}); err != nil {
+//		log.Fatal(err)
+//	}
+func Serve(d Driver) error {
+	return serve(d, os.Stdin, os.Stdout)
+}
+
+func serve(d Driver, r io.Reader, w io.Writer) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 4096), 1<<20)
+	enc := json.NewEncoder(w)
+	for sc.Scan() {
+		var req Request
+		if err := json.Unmarshal(sc.Bytes(), &req); err != nil {
+			return fmt.Errorf("invalid request %q: %w", sc.Bytes(), err)
+		}
+		resp := Response{ID: req.ID}
+		switch req.Op {
+		case "hello":
+			channels, err := d.Open(req.Options)
+			if err != nil {
+				resp.Error = err.Error()
+			}
+			resp.Channels = channels
+		case "read":
+			v, q, err := d.Read(context.Background(), req.Channel)
+			switch {
+			case err != nil:
+				resp.Error = err.Error()
+			case !math.IsNaN(v):
+				resp.Value, resp.Quality = &v, q
+			}
+		default:
+			resp.Error = fmt.Sprintf("unknown op %q", req.Op)
+		}
+		if err := enc.Encode(resp); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
@@ -0,0 +1,134 @@
+package adapter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"riscv-dev/pkg/sensor"
+)
+
+// testDriver provides a co2 channel counting up from the "start" option,
+// and a "broken" channel. Reading "exit" makes the process exit and
+// "slow" answers late.
+type testDriver struct{ n float64 }
+
+func (d *testDriver) Open(options json.RawMessage) ([]Channel, error) {
+	var opts struct{ Start float64 }
+	if err := json.Unmarshal(options, &opts); err != nil {
+		return nil, err
+	}
+	d.n = opts.Start
+	return []Channel{{Name: "co2", Unit: "ppm"}, {Name: "broken"}}, nil
+}
+
+func (d *testDriver) Read(ctx context.Context, channel string) (float64, sensor.Quality, error) {
+	switch channel {
+	case "co2":
+		d.n++
+		return d.n, sensor.OK, nil
+	case "broken":
+		return math.NaN(), sensor.Fault, nil
+	case "exit":
+		os.Exit(0)
+	case "slow":
+		time.Sleep(200 * time.Millisecond)
+		return -1, sensor.OK, nil
+	}
+	return 0, sensor.Fault, errors.New("no such channel")
+}
+
+func TestMain(m *testing.M) {
+	if os.Getenv("ADAPTER_TEST_DRIVER") == "1" {
+		if err := Serve(&testDriver{}); err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func startTestDriver(t *testing.T) *Process {
+	t.Helper()
+	t.Setenv("ADAPTER_TEST_DRIVER", "1")
+	p, err := Start([]string{os.Args[0]}, json.RawMessage(`{"start": 400}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+func TestProcess(t *testing.T) {
+	p := startTestDriver(t)
+	if ch := p.Channels(); len(ch) != 2 || ch[0].Name != "co2" || ch[0].Unit != "ppm" {
+		t.Fatalf("channels = %+v", ch)
+	}
+	ctx := context.Background()
+	for want := 401.0; want <= 403; want++ {
+		if v, q, err := p.Read(ctx, "co2"); v != want || q != sensor.OK || err != nil {
+			t.Errorf("co2 = %v, %v, %v; want %v", v, q, err, want)
+		}
+	}
+	if v, q, err := p.Read(ctx, "broken"); !math.IsNaN(v) || q != sensor.Fault || err != nil {
+		t.Errorf("broken = %v, %v, %v", v, q, err)
+	}
+	if _, _, err := p.Read(ctx, "nope"); err == nil || err.Error() != "no such channel" {
+		t.Errorf("unknown channel: %v", err)
+	}
+
+	// An answer arriving after its read timed out is not taken for the
+	// answer to the next read
+	short, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, _, err := p.Read(short, "slow"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("slow read: %v", err)
+	}
+	if v, _, err := p.Read(ctx, "co2"); v != 404 || err != nil {
+		t.Errorf("after a timeout co2 = %v, %v", v, err)
+	}
+}
+
+func TestProcessRestart(t *testing.T) {
+	p := startTestDriver(t)
+	ctx := context.Background()
+	if _, _, err := p.Read(ctx, "exit"); err == nil {
+		t.Fatal("read from an exiting driver succeeded")
+	}
+	// Too soon to restart
+	if _, _, err := p.Read(ctx, "co2"); err == nil {
+		t.Fatal("read succeeded straight after the driver exited")
+	}
+	p.mu.Lock()
+	p.started = time.Now().Add(-restartDelay)
+	p.mu.Unlock()
+	if v, _, err := p.Read(ctx, "co2"); v != 401 || err != nil {
+		t.Errorf("after restart co2 = %v, %v; want 401", v, err)
+	}
+}
+
+func TestServe(t *testing.T) {
+	in := strings.NewReader(`{"id":1,"op":"hello","options":{"start":1}}
+{"id":2,"op":"read","channel":"co2"}
+{"id":3,"op":"read","channel":"broken"}
+{"id":4,"op":"bogus"}
+`)
+	var out bytes.Buffer
+	if err := serve(&testDriver{}, in, &out); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"id":1,"channels":[{"name":"co2","unit":"ppm"},{"name":"broken"}]}
+{"id":2,"value":2}
+{"id":3}
+{"id":4,"error":"unknown op \"bogus\""}
+`
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
@@ -0,0 +1,124 @@
+package adapter
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ParseValues extracts the numbers from the output of a program that
+// knows nothing of this protocol, such as a vendor tool or a shell
+// pipeline, by name:
+//
+//   - a JSON object gives its numeric members by key, nested ones by
+//     dotted path ("sensor.temp"); null is NaN and true and false are 1
+//     and 0
+//   - a JSON array gives its elements by position, from "1"
+//   - any other text is split at whitespace, commas and semicolons into
+//     key=value, key:value and "key: value" pairs, by key, and bare
+//     numbers, by position from "1"
+//
+// A value may carry a unit, as in temp=48.3'C or 21.5°C, which is
+// ignored. Output without a number is an error.
+func ParseValues(out []byte) (map[string]float64, error) {
+	text := strings.TrimSpace(string(out))
+	values := make(map[string]float64)
+	if strings.HasPrefix(text, "{") || strings.HasPrefix(text, "[") {
+		var v any
+		if err := json.Unmarshal([]byte(text), &v); err != nil {
+			return nil, err
+		}
+		if a, ok := v.([]any); ok {
+			for i, e := range a {
+				addJSON(values, strconv.Itoa(i+1), e)
+			}
+		} else {
+			addJSON(values, "", v)
+		}
+	} else {
+		pos, pending := 0, ""
+		for _, tok := range strings.FieldsFunc(text, func(r rune) bool { return unicode.IsSpace(r) || r == ',' || r == ';' }) {
+			key, val, ok := strings.Cut(tok, "=")
+			if !ok {
+				key, val, ok = strings.Cut(tok, ":")
+			}
+			switch {
+			case ok && val == "":
+				pending = key // "co2: 412"
+			case ok:
+				if v, ok := leadingNumber(val); ok && key != "" {
+					values[key] = v
+				}
+				pending = ""
+			default:
+				if v, ok := leadingNumber(tok); ok {
+					if pending != "" {
+						values[pending] = v
+					} else {
+						pos++
+						values[strconv.Itoa(pos)] = v
+					}
+				}
+				pending = ""
+			}
+		}
+	}
+	if len(values) == 0 {
+		return nil, errors.New("no values in output")
+	}
+	return values, nil
+}
+
+// addJSON adds a decoded JSON value under key, flattening objects
+func addJSON(values map[string]float64, key string, v any) {
+	switch v := v.(type) {
+	case float64:
+		values[key] = v
+	case nil:
+		if key != "" {
+			values[key] = math.NaN()
+		}
+	case bool:
+		values[key] = 0
+		if v {
+			values[key] = 1
+		}
+	case string:
+		if f, ok := leadingNumber(v); ok {
+			values[key] = f
+		}
+	case map[string]any:
+		for k, e := range v {
+			if key != "" {
+				k = key + "." + k
+			}
+			addJSON(values, k, e)
+		}
+	}
+}
+
+// leadingNumber parses the number at the start of s, ignoring a unit
+// after it
+func leadingNumber(s string) (float64, bool) {
+	end := 0
+	for i, r := range s {
+		if !(r >= '0' && r <= '9' || r == '.' || r == '-' || r == '+' || ((r == 'e' || r == 'E') && i > 0)) {
+			break
+		}
+		end = i + 1
+	}
+	// Back off to the longest prefix that parses, e.g. "3e" of "3eV"
+	for ; end > 0; end-- {
+		if v, err := strconv.ParseFloat(s[:end], 64); err == nil {
+			return v, true
+		}
+	}
+	switch strings.ToLower(s) {
+	case "nan", "null":
+		return math.NaN(), true
+	}
+	return 0, false
+}
@@ -0,0 +1,55 @@
+package adapter
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestParseValues(t *testing.T) {
+	nan := math.NaN()
+	tests := []struct {
+		out  string
+		want map[string]float64
+	}{
+		{"21.5\n", map[string]float64{"1": 21.5}},
+		{"temp=48.3'C\n", map[string]float64{"temp": 48.3}},
+		{"co2: 412 ppm, rh: 41.5%", map[string]float64{"co2": 412, "rh": 41.5}},
+		{"1013.2 hPa 21.5°C -3e-2", map[string]float64{"1": 1013.2, "2": 21.5, "3": -0.03}},
+		{"volts=nan ok", map[string]float64{"volts": nan}},
+		{`{"temp": 21.5, "ok": true, "sensor": {"rh": 40, "id": "x"}, "err": null}`,
+			map[string]float64{"temp": 21.5, "ok": 1, "sensor.rh": 40, "err": nan}},
+		{`[1, 2.5, "3V"]`, map[string]float64{"1": 1, "2": 2.5, "3": 3}},
+	}
+	for _, tt := range tests {
+		got, err := ParseValues([]byte(tt.out))
+		if err != nil {
+			t.Errorf("ParseValues(%q): %v", tt.out, err)
+			continue
+		}
+		if !equalValues(got, tt.want) {
+			t.Errorf("ParseValues(%q) = %v, want %v", tt.out, got, tt.want)
+		}
+	}
+
+	for _, out := range []string{"", "sensor offline", `{"temp": }`, `{"name": "x"}`} {
+		if got, err := ParseValues([]byte(out)); err == nil {
+			t.Errorf("ParseValues(%q) = %v, want an error", out, got)
+		}
+	}
+}
+
+// equalValues compares value maps, NaN equal to NaN
+func equalValues(a, b map[string]float64) bool {
+	clean := func(m map[string]float64) map[string]float64 {
+		c := make(map[string]float64, len(m))
+		for k, v := range m {
+			if math.IsNaN(v) {
+				v = -1e308
+			}
+			c[k] = v
+		}
+		return c
+	}
+	return reflect.DeepEqual(clean(a), clean(b))
+}
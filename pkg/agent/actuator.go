@@ -0,0 +1,489 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"riscv-dev/pkg/audit"
+	"riscv-dev/pkg/config"
+	"riscv-dev/pkg/hal"
+	"riscv-dev/pkg/metrics"
+	"riscv-dev/pkg/safestate"
+	"riscv-dev/pkg/schedule"
+)
+
+// ActuatorConfig drives a GPIO output: a fan, heater, pump or light. With
+// Channel set it switches automatically, on while the channel is above
+// Above (or below Below) and off again once it is back by Hysteresis.
+// With When set it is on while When matches, or with Channel too, follows
+// the channel only then. Operators can override it for a bounded time,
+// and holiday mode holds it in its Holiday state.
+type ActuatorConfig struct {
+	Name      string `json:"name"`
+	Pin       int    `json:"pin"`
+	ActiveLow bool   `json:"active_low,omitempty"`
+	// Channel, Above or Below, and Hysteresis set up automatic control
+	Channel    string   `json:"channel,omitempty"`
+	Above      *float64 `json:"above,omitempty"`
+	Below      *float64 `json:"below,omitempty"`
+	Hysteresis float64  `json:"hysteresis,omitempty"`
+	// When is a time window, such as "sunset-23:00" or "mon-fri
+	// 07:00-09:00" (see package schedule); outside it the actuator is in
+	// its Default state
+	When string `json:"when,omitempty"`
+	// Default is the state without automation, before the channel has a
+	// usable reading, while this board stands by for the elected leader,
+	// and on shutdown
+	Default bool `json:"default,omitempty"`
+	// Holiday is the state in holiday mode; unset leaves the actuator to
+	// automation
+	Holiday *bool `json:"holiday,omitempty"`
+	// MaxOverride bounds manual overrides, default 4h
+	MaxOverride config.Duration `json:"max_override,omitempty"`
+}
+
+// Actuator modes, as reported in ActuatorInfo
+const (
+	ModeDefault  = "default"
+	ModeAuto     = "auto"
+	ModeOverride = "override"
+	ModeHoliday  = "holiday"
+	ModeStandby  = "standby"
+)
+
+const defaultMaxOverride = 4 * time.Hour
+
+// stateHoliday keeps holiday mode across restarts, as a Unix time
+const stateHoliday = "actuators.holiday_until"
+
+// ActuatorInfo describes an actuator and what controls it, as served on
+// /actuators
+type ActuatorInfo struct {
+	Name  string     `json:"name"`
+	Pin   int        `json:"pin"`
+	State bool       `json:"state"`
+	Mode  string     `json:"mode"`
+	Until *time.Time `json:"until,omitempty"` // end of an override or holiday mode
+	By    string     `json:"by,omitempty"`    // who set the override or holiday mode
+}
+
+var (
+	actuatorState    = metrics.NewGauge("actuator_state", "Whether an actuator is on", "actuator")
+	actuatorOverride = metrics.NewGauge("actuator_override", "Whether an actuator is manually overridden", "actuator")
+	holidayMode      = metrics.NewGauge("agent_holiday_mode", "Whether holiday mode is on")
+)
+
+// actuator is a configured output and its control state; guarded by
+// Agent.actMu
+type actuator struct {
+	cfg     ActuatorConfig
+	auto    bool // automation's choice
+	state   bool // as last written
+	written bool
+	mode    string
+	// window is When parsed, nil without it
+	window *schedule.Window
+
+	override      bool
+	overrideState bool
+	overrideUntil time.Time
+	overrideBy    string
+}
+
+// holiday is holiday mode; zero until means off
+type holiday struct {
+	until time.Time
+	by    string
+}
+
+// addActuators opens the GPIO controller and sets every actuator to its
+// default state
+func (a *Agent) addActuators(cfg Config) error {
+	if len(cfg.Actuators) == 0 {
+		return nil
+	}
+	gpio, err := a.openGPIO()
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	for _, ac := range cfg.Actuators {
+		if ac.Name == "" || seen[ac.Name] {
+			return fmt.Errorf("actuator %q: name must be set and unique", ac.Name)
+		}
+		seen[ac.Name] = true
+		if ac.Channel != "" {
+			if a.channel(ac.Channel) == nil {
+				return fmt.Errorf("actuator %s: unknown channel %q", ac.Name, ac.Channel)
+			}
+			if (ac.Above == nil) == (ac.Below == nil) {
+				return fmt.Errorf("actuator %s: set one of above and below", ac.Name)
+			}
+		}
+		if ac.Hysteresis < 0 {
+			return fmt.Errorf("actuator %s: hysteresis must not be negative", ac.Name)
+		}
+		var window *schedule.Window
+		if ac.When != "" {
+			if window, err = schedule.ParseAt(ac.When, cfg.Coordinates); err != nil {
+				return fmt.Errorf("actuator %s: %w", ac.Name, err)
+			}
+		}
+		if err := gpio.SetMode(ac.Pin, hal.Output); err != nil {
+			return fmt.Errorf("actuator %s: %w", ac.Name, err)
+		}
+		act := &actuator{cfg: ac, window: window, auto: ac.Default}
+		if err := a.writeActuator(context.Background(), gpio, act, ac.Default); err != nil {
+			return err
+		}
+		a.actuators = append(a.actuators, act)
+	}
+	// Leave the outputs in their default states if the program dies
+	a.unsafe = safestate.Register("actuators to default", func(ctx context.Context) error {
+		return a.resetActuators(ctx)
+	})
+
+	if a.state != nil {
+		if s, ok := a.state.Get(stateHoliday); ok {
+			var secs int64
+			if _, err := fmt.Sscan(s, &secs); err == nil && time.Unix(secs, 0).After(time.Now()) {
+				a.holiday.until = time.Unix(secs, 0)
+				a.holiday.by, _ = a.state.Get(stateHoliday + ".by")
+				log.Printf("🏖️  Holiday mode until %s", a.holiday.until.Format(time.RFC3339))
+				holidayMode.Set(1)
+			}
+		}
+	}
+	return nil
+}
+
+// resetActuators writes every actuator's default state
+func (a *Agent) resetActuators(ctx context.Context) error {
+	a.actMu.Lock()
+	defer a.actMu.Unlock()
+	var errs []error
+	for _, act := range a.actuators {
+		errs = append(errs, a.writeActuator(ctx, a.gpio, act, act.cfg.Default))
+	}
+	return errors.Join(errs...)
+}
+
+// writeActuator drives the pin, auditing the change as made by the agent;
+// a.actMu must be held or act not yet shared
+func (a *Agent) writeActuator(ctx context.Context, gpio hal.GPIOController, act *actuator, on bool) error {
+	if act.written && act.state == on {
+		return nil
+	}
+	entry := audit.Entry{Action: "actuator.write", Target: act.cfg.Name, New: onOff(on)}
+	if act.written {
+		entry.Old = onOff(act.state)
+	}
+	err := gpio.Write(ctx, act.cfg.Pin, on != act.cfg.ActiveLow)
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	a.audit.Record(audit.WithActor(ctx, "agent"), entry)
+	if err != nil {
+		return fmt.Errorf("actuator %s: %w", act.cfg.Name, err)
+	}
+	act.state, act.written = on, true
+	v := 0.0
+	if on {
+		v = 1
+	}
+	actuatorState.Set(v, act.cfg.Name)
+	return nil
+}
+
+// driveActuators runs automation on a reading, reverts expired overrides
+// and holiday mode, and writes the outputs
+func (a *Agent) driveActuators(ctx context.Context, r Reading) {
+	a.actMu.Lock()
+	defer a.actMu.Unlock()
+	if len(a.actuators) == 0 {
+		return
+	}
+	now := r.Time
+	if !a.holiday.until.IsZero() && !now.Before(a.holiday.until) {
+		log.Printf("🏖️  Holiday mode over")
+		a.audit.Record(audit.WithActor(ctx, "agent"), audit.Entry{Action: "actuator.holiday", Target: "agent", Old: "on", New: "off"})
+		a.setHoliday(holiday{})
+	}
+	for _, act := range a.actuators {
+		switch {
+		case act.window != nil && !act.window.Contains(now):
+			act.auto = act.cfg.Default
+		case act.cfg.Channel != "":
+			if c, ok := r.Get(act.cfg.Channel); ok && c.Quality.Usable() && !math.IsNaN(c.Value) {
+				act.auto = act.decide(c.Value)
+			}
+		case act.window != nil:
+			act.auto = true
+		}
+		if act.override && !now.Before(act.overrideUntil) {
+			act.override = false
+			actuatorOverride.Set(0, act.cfg.Name)
+			log.Printf("↩️  %s: override expired, back to %s", act.cfg.Name, a.controlMode(act))
+			a.audit.Record(audit.WithActor(ctx, "agent"), audit.Entry{
+				Action: "actuator.revert", Target: act.cfg.Name, Old: "override " + onOff(act.overrideState), New: a.controlMode(act),
+			})
+		}
+		a.applyActuator(ctx, act)
+	}
+}
+
+// decide applies the thresholds with hysteresis
+func (act *actuator) decide(v float64) bool {
+	c := act.cfg
+	if c.Above != nil {
+		if act.auto {
+			return v > *c.Above-c.Hysteresis
+		}
+		return v > *c.Above
+	}
+	if act.auto {
+		return v < *c.Below+c.Hysteresis
+	}
+	return v < *c.Below
+}
+
+// controlMode returns what controls act; a.actMu must be held
+func (a *Agent) controlMode(act *actuator) string {
+	switch {
+	case !a.leading.Load():
+		return ModeStandby
+	case act.override:
+		return ModeOverride
+	case !a.holiday.until.IsZero() && act.cfg.Holiday != nil:
+		return ModeHoliday
+	case act.cfg.Channel != "" || act.window != nil:
+		return ModeAuto
+	default:
+		return ModeDefault
+	}
+}
+
+// applyActuator writes the state act's mode calls for; a.actMu must be
+// held
+func (a *Agent) applyActuator(ctx context.Context, act *actuator) {
+	mode := a.controlMode(act)
+	var on bool
+	switch mode {
+	case ModeOverride:
+		on = act.overrideState
+	case ModeHoliday:
+		on = *act.cfg.Holiday
+	case ModeAuto:
+		on = act.auto
+	default:
+		on = act.cfg.Default
+	}
+	was := act.state
+	if err := a.writeActuator(ctx, a.gpio, act, on); err != nil {
+		log.Printf("❌ %v", err)
+		return
+	}
+	if was != on || mode != act.mode {
+		log.Printf("🔌 %s %s (%s)", act.cfg.Name, onOff(on), mode)
+	}
+	act.mode = mode
+}
+
+// Override forces an actuator on or off for d, bounded by its
+// MaxOverride, after which it reverts to automatic control. The change is
+// audited with the actor in ctx.
+func (a *Agent) Override(ctx context.Context, name string, on bool, d time.Duration) error {
+	a.actMu.Lock()
+	defer a.actMu.Unlock()
+	act := a.actuator(name)
+	if act == nil {
+		return fmt.Errorf("unknown actuator %q", name)
+	}
+	max := act.cfg.MaxOverride.D()
+	if max <= 0 {
+		max = defaultMaxOverride
+	}
+	if d <= 0 || d > max {
+		return fmt.Errorf("actuator %s: override duration must be positive and at most %v", name, max)
+	}
+	old := a.controlMode(act) + " " + onOff(act.state)
+	act.override, act.overrideState, act.overrideUntil = true, on, time.Now().Add(d)
+	act.overrideBy = audit.Actor(ctx)
+	actuatorOverride.Set(1, name)
+	log.Printf("✋ %s overridden %s for %v by %s", name, onOff(on), d, act.overrideBy)
+	a.audit.Record(ctx, audit.Entry{Action: "actuator.override", Target: name, Old: old, New: fmt.Sprintf("%s for %v", onOff(on), d)})
+	a.applyActuator(ctx, act)
+	return nil
+}
+
+// ClearOverride ends an actuator's override early
+func (a *Agent) ClearOverride(ctx context.Context, name string) error {
+	a.actMu.Lock()
+	defer a.actMu.Unlock()
+	act := a.actuator(name)
+	if act == nil {
+		return fmt.Errorf("unknown actuator %q", name)
+	}
+	if !act.override {
+		return nil
+	}
+	act.override = false
+	actuatorOverride.Set(0, name)
+	log.Printf("↩️  %s: override cleared by %s, back to %s", name, audit.Actor(ctx), a.controlMode(act))
+	a.audit.Record(ctx, audit.Entry{Action: "actuator.revert", Target: name, Old: "override " + onOff(act.overrideState), New: a.controlMode(act)})
+	a.applyActuator(ctx, act)
+	return nil
+}
+
+// SetHoliday turns holiday mode on for d, holding actuators with a
+// Holiday state in it, or off with d <= 0. Holiday mode survives restarts
+// if there is a state file.
+func (a *Agent) SetHoliday(ctx context.Context, d time.Duration) error {
+	a.actMu.Lock()
+	defer a.actMu.Unlock()
+	if len(a.actuators) == 0 {
+		return errors.New("no actuators")
+	}
+	old, h := "off", holiday{}
+	if !a.holiday.until.IsZero() {
+		old = "until " + a.holiday.until.Format(time.RFC3339)
+	}
+	entry := audit.Entry{Action: "actuator.holiday", Target: "agent", Old: old, New: "off"}
+	if d > 0 {
+		h = holiday{until: time.Now().Add(d).Truncate(time.Second), by: audit.Actor(ctx)}
+		entry.New = "until " + h.until.Format(time.RFC3339)
+		log.Printf("🏖️  Holiday mode until %s, set by %s", h.until.Format(time.RFC3339), h.by)
+	} else {
+		log.Printf("🏖️  Holiday mode ended by %s", audit.Actor(ctx))
+	}
+	a.audit.Record(ctx, entry)
+	a.setHoliday(h)
+	for _, act := range a.actuators {
+		a.applyActuator(ctx, act)
+	}
+	return nil
+}
+
+// setHoliday records holiday mode; a.actMu must be held
+func (a *Agent) setHoliday(h holiday) {
+	a.holiday = h
+	on, until := 0.0, "0"
+	if !h.until.IsZero() {
+		on, until = 1, fmt.Sprint(h.until.Unix())
+	}
+	holidayMode.Set(on)
+	if a.state != nil {
+		a.state.Set(stateHoliday, until)
+		a.state.Set(stateHoliday+".by", h.by)
+	}
+}
+
+// Actuators describes every actuator
+func (a *Agent) Actuators() []ActuatorInfo {
+	a.actMu.Lock()
+	defer a.actMu.Unlock()
+	infos := make([]ActuatorInfo, len(a.actuators))
+	for i, act := range a.actuators {
+		info := ActuatorInfo{Name: act.cfg.Name, Pin: act.cfg.Pin, State: act.state, Mode: a.controlMode(act)}
+		switch info.Mode {
+		case ModeOverride:
+			until := act.overrideUntil
+			info.Until, info.By = &until, act.overrideBy
+		case ModeHoliday:
+			until := a.holiday.until
+			info.Until, info.By = &until, a.holiday.by
+		}
+		infos[i] = info
+	}
+	return infos
+}
+
+// actuator finds an actuator by name; a.actMu must be held
+func (a *Agent) actuator(name string) *actuator {
+	for _, act := range a.actuators {
+		if act.cfg.Name == name {
+			return act
+		}
+	}
+	return nil
+}
+
+// overrideRequest is the body of POST /actuators/<name>/override and
+// POST /holiday
+type overrideRequest struct {
+	State    bool            `json:"state"`
+	Duration config.Duration `json:"duration"`
+}
+
+func (a *Agent) serveActuators(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, a.Actuators())
+}
+
+// serveOverride handles POST and DELETE on /actuators/<name>/override
+func (a *Agent) serveOverride(w http.ResponseWriter, r *http.Request) {
+	name, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/actuators/"), "/override")
+	if !ok || name == "" || strings.Contains(name, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	var err error
+	switch r.Method {
+	case http.MethodPost:
+		var req overrideRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid override: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = a.Override(r.Context(), name, req.State, req.Duration.D())
+	case http.MethodDelete:
+		err = a.ClearOverride(r.Context(), name)
+	default:
+		http.Error(w, "POST or DELETE an override", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.serveActuators(w, r)
+}
+
+// serveHoliday handles POST and DELETE on /holiday
+func (a *Agent) serveHoliday(w http.ResponseWriter, r *http.Request) {
+	var d time.Duration
+	switch r.Method {
+	case http.MethodPost:
+		var req overrideRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid holiday mode: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if d = req.Duration.D(); d <= 0 {
+			http.Error(w, "holiday mode needs a duration", http.StatusBadRequest)
+			return
+		}
+	case http.MethodDelete:
+	default:
+		http.Error(w, "POST or DELETE holiday mode", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := a.SetHoliday(r.Context(), d); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.serveActuators(w, r)
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
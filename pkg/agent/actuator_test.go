@@ -0,0 +1,70 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"riscv-dev/pkg/audit"
+	"riscv-dev/pkg/hal"
+	"riscv-dev/pkg/sim"
+)
+
+// failingGPIO fails every write
+type failingGPIO struct{ *sim.GPIO }
+
+func (failingGPIO) Write(ctx context.Context, pin int, value bool) error {
+	return errors.New("line busy")
+}
+
+func openAudit(t *testing.T) *audit.Log {
+	t.Helper()
+	l, err := audit.Open(audit.Config{Path: filepath.Join(t.TempDir(), "audit.log")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func TestActuatorWriteAudited(t *testing.T) {
+	gpio := sim.NewGPIO()
+	gpio.SetMode(17, hal.Output)
+	a := &Agent{audit: openAudit(t), gpio: gpio}
+	act := &actuator{cfg: ActuatorConfig{Name: "fan", Pin: 17, ActiveLow: true}}
+	ctx := audit.WithActor(context.Background(), "alice")
+
+	for _, on := range []bool{true, true, false} {
+		if err := a.writeActuator(ctx, gpio, act, on); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if level, _ := gpio.Read(ctx, 17); !level {
+		t.Error("active-low fan switched off but its pin is low")
+	}
+	if err := a.writeActuator(ctx, failingGPIO{gpio}, act, true); err == nil {
+		t.Error("failed write not reported")
+	}
+
+	// Rewriting the same state records nothing, and the agent is the actor
+	// whoever asked for the change
+	got, err := a.audit.Query(audit.Filter{Action: "actuator.write"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []audit.Entry{
+		{Actor: "agent", Target: "fan", New: "on"},
+		{Actor: "agent", Target: "fan", Old: "on", New: "off"},
+		{Actor: "agent", Target: "fan", Old: "off", New: "on", Error: "line busy"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("recorded %+v", got)
+	}
+	for i, e := range got {
+		w := want[i]
+		if e.Actor != w.Actor || e.Target != w.Target || e.Old != w.Old || e.New != w.New || e.Error != w.Error {
+			t.Errorf("entry %d = %+v, want %+v", i, e, w)
+		}
+	}
+}
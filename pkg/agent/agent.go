@@ -0,0 +1,1009 @@
+// Package agent is the sensor sampling pipeline behind the sensor-reading
+// example, packaged for embedding: it opens the ADC, samples every channel
+// on a fixed interval, tracks quality, history and statistics, and hands
+// each reading to the registered sinks.
+//
+//	a, err := agent.New(cfg)
+//	...
+//	a.AddSensor(mySensor, sensor.Range{})
+//	a.AddSink(agent.SinkFunc(func(ctx context.Context, r agent.Reading) error { ... }))
+//	err = a.Run(ctx) // until ctx is cancelled
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"riscv-dev/pkg/audit"
+	"riscv-dev/pkg/auth"
+	"riscv-dev/pkg/buildinfo"
+	"riscv-dev/pkg/clockdrift"
+	"riscv-dev/pkg/crash"
+	"riscv-dev/pkg/display"
+	"riscv-dev/pkg/hal"
+	"riscv-dev/pkg/health"
+	"riscv-dev/pkg/kmsg"
+	"riscv-dev/pkg/metrics"
+	"riscv-dev/pkg/namespace"
+	"riscv-dev/pkg/netwait"
+	"riscv-dev/pkg/realip"
+	"riscv-dev/pkg/sensor"
+	"riscv-dev/pkg/state"
+	"riscv-dev/pkg/uplink"
+)
+
+// flaggedReadings counts readings whose quality is not ok
+var flaggedReadings = metrics.NewCounter("sensor_readings_flagged_total",
+	"Sensor readings flagged with a quality other than ok", "channel", "quality")
+
+// Sink receives every reading. Each sink is fed from its own queue and
+// goroutine, so Write may block or fail without affecting sampling or the
+// other sinks; failed writes are retried with backoff.
+type Sink interface {
+	Write(ctx context.Context, r Reading) error
+}
+
+// SinkFunc adapts a function to the Sink interface
+type SinkFunc func(ctx context.Context, r Reading) error
+
+// Write calls f
+func (f SinkFunc) Write(ctx context.Context, r Reading) error { return f(ctx, r) }
+
+// channel is a sensor with its range and history
+type channel struct {
+	sensor    Sensor
+	rng       sensor.Range
+	meta      *sensor.Metadata // nil if the sensor has none
+	history   *sensor.History
+	last      sensor.Sample
+	hasLast   bool
+	quality   sensor.Quality // of the latest sample, for alerts
+	forecasts []*forecaster
+	// percentiles are histograms over sliding windows, percentilesAt when
+	// their summaries were last published
+	percentiles   []*sensor.WindowedHistogram
+	percentilesAt time.Time
+	// schedules override rng by time of day; scheduled is the index of the
+	// one in effect, -1 for none
+	schedules []scheduledRange
+	scheduled int
+}
+
+// Agent samples sensors and distributes the readings
+type Agent struct {
+	cfg        Config
+	ns         namespace.Namespace
+	adc        hal.ADCController  // as opened, without middleware
+	reader     hal.ADCController  // with retry and circuit breaker
+	gpio       hal.GPIOController // nil without GPIO inputs or actuators
+	mu         sync.Mutex
+	chans      []*channel
+	sinks      []*sinkWorker
+	health     *health.Checker
+	metricsTLS *tls.Config // nil serves plain HTTP
+	online     *netGate
+	uplink     *uplink.Manager          // nil without connectivity
+	routes     map[string]*uplink.Route // by sink, for those with interfaces
+	clock      *clockdrift.Estimator    // nil without clock
+	proxies    realip.Trusted
+	auth       *auth.Authenticator // nil leaves the endpoints open
+	audit      *audit.Log          // nil records nothing
+	events     eventHub
+	kernel     []kmsg.Rule  // nil without kernel_log
+	state      *state.Store // nil without state_file
+	stateTime  time.Time    // running time up to here is in the state file
+	leading    atomic.Bool  // see Leader
+	actMu      sync.Mutex   // guards actuators and holiday
+	actuators  []*actuator
+	holiday    holiday
+	unsafe     func() // unregisters the actuators' safe state
+	signals    []*signal
+	stopSig    context.CancelFunc // ends the signals' goroutines
+	unsafeSig  func()             // unregisters the signals' safe state
+	notifiers  []*notifier
+	last       Reading
+	samples    int
+	seq        uint64       // of the last reading
+	seqLimit   uint64       // the highest the state file has handed out
+	storage    storageLevel // of the filesystem, with storage set
+	memory     memoryLevel  // with memory set
+	throttled  bool         // the CPU is, with thermal set
+	slowFactor int          // of the sample interval while throttled
+	disk       *diskWatch   // nil without disk_health
+	deviceID   string       // kept in data_dir, empty without it
+	display    *display.Formatter
+	crash      *crash.Reporter // nil without crash
+	logOut     io.Writer       // the log's output before crash took it
+}
+
+// New opens the configured ADC and sets up a channel for each entry in
+// cfg.Channels. Reads are retried on transient bus errors, and channels
+// that keep failing are reported as faults without stalling the loop.
+func New(cfg Config) (*Agent, error) {
+	if cfg.SampleInterval <= 0 {
+		return nil, fmt.Errorf("sample_interval must be positive")
+	}
+	var deviceID string
+	if cfg.DataDir != "" {
+		cfg = cfg.withDataDir()
+		if err := cfg.verifyDataDir(); err != nil {
+			return nil, err
+		}
+		id, err := cfg.deviceID()
+		if err != nil {
+			return nil, fmt.Errorf("device identity: %w", err)
+		}
+		deviceID = id
+		if cfg.Namespace != nil && cfg.Namespace.Device == "" {
+			ns := *cfg.Namespace
+			ns.Device = id
+			cfg.Namespace = &ns
+		}
+		if cfg.Election != nil && cfg.Election.ID == "" {
+			ec := *cfg.Election
+			ec.ID = id
+			cfg.Election = &ec
+		}
+	}
+	proxies, err := realip.ParseTrusted(cfg.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("trusted_proxies: %w", err)
+	}
+	authn, err := auth.New(cfg.Auth)
+	if err != nil {
+		return nil, err
+	}
+	var kernelRules []kmsg.Rule
+	if cfg.KernelLog != nil {
+		if kernelRules, err = cfg.KernelLog.rules(); err != nil {
+			return nil, err
+		}
+	}
+	var formatter *display.Formatter
+	if cfg.Display != nil {
+		if formatter, err = display.New(*cfg.Display); err != nil {
+			return nil, err
+		}
+	}
+	var ns namespace.Namespace
+	if cfg.Namespace != nil {
+		if ns, err = cfg.Namespace.Resolve(); err != nil {
+			return nil, err
+		}
+		metrics.Default.SetConstLabels(ns.Labels())
+	}
+	registerRuntimeMetrics()
+	checkBoard(cfg)
+	adc, err := hal.NewADCController(cfg.ADC)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ADC: %w", err)
+	}
+
+	var metricsTLS *tls.Config
+	if cfg.MetricsTLS != nil {
+		if metricsTLS, err = cfg.MetricsTLS.Server(); err != nil {
+			adc.Close()
+			return nil, fmt.Errorf("metrics endpoint: %w", err)
+		}
+	}
+	var auditLog *audit.Log
+	if cfg.Audit != nil {
+		if auditLog, err = audit.Open(*cfg.Audit); err != nil {
+			adc.Close()
+			return nil, err
+		}
+	}
+
+	a := &Agent{
+		cfg:        cfg,
+		ns:         ns,
+		adc:        adc,
+		reader:     hal.WrapADC(adc, hal.WithRetry(hal.DefaultRetryPolicy), hal.WithBreaker(hal.DefaultBreakerConfig)),
+		health:     health.New(),
+		metricsTLS: metricsTLS,
+		online:     newNetGate(!cfg.Offline && cfg.NetworkWait == nil && cfg.Connectivity == nil),
+		proxies:    proxies,
+		auth:       authn,
+		audit:      auditLog,
+		kernel:     kernelRules,
+		deviceID:   deviceID,
+		display:    formatter,
+	}
+	a.leading.Store(true)
+	if err := a.addCrash(cfg); err != nil {
+		a.Close()
+		return nil, err
+	}
+	if cfg.DiskHealth != nil {
+		a.disk = newDiskWatch(*cfg.DiskHealth)
+	}
+	if err := a.openState(); err != nil {
+		a.Close()
+		return nil, err
+	}
+	board, err := readCarrier(cfg.Carrier)
+	if err != nil {
+		a.Close()
+		return nil, err
+	}
+	cals, err := loadCalibration(cfg, board.calibration)
+	if err != nil {
+		a.Close()
+		return nil, err
+	}
+	for _, ch := range cfg.Channels {
+		s := &adcSensor{adc: a.reader, cfg: ch, cal: cals[ch.Name], value: a.lastValue}
+		if err := a.AddSensor(s, ch.Range); err != nil {
+			a.Close()
+			return nil, err
+		}
+	}
+	if err := a.addPulseCounters(cfg); err != nil {
+		a.Close()
+		return nil, err
+	}
+	if err := a.addFrequencyInputs(cfg); err != nil {
+		a.Close()
+		return nil, err
+	}
+	for _, sc := range cfg.Sound {
+		s, err := newSoundSensor(sc)
+		if err == nil {
+			if err = a.AddSensor(s, sc.Range); err != nil {
+				s.Close()
+			}
+		}
+		if err != nil {
+			a.Close()
+			return nil, err
+		}
+	}
+	if err := a.addDrivers(cfg); err != nil {
+		a.Close()
+		return nil, err
+	}
+	if err := a.addCommands(cfg); err != nil {
+		a.Close()
+		return nil, err
+	}
+	if err := a.addRuntime(cfg); err != nil {
+		a.Close()
+		return nil, err
+	}
+	if err := a.addConnectivity(cfg); err != nil {
+		a.Close()
+		return nil, err
+	}
+	if err := a.addProbes(cfg); err != nil {
+		a.Close()
+		return nil, err
+	}
+	if err := a.addClock(cfg); err != nil {
+		a.Close()
+		return nil, err
+	}
+	for _, dc := range cfg.Derived {
+		if err := a.addDerived(dc); err != nil {
+			a.Close()
+			return nil, err
+		}
+	}
+	if err := a.addSchedules(cfg); err != nil {
+		a.Close()
+		return nil, err
+	}
+	if err := a.addForecasts(cfg); err != nil {
+		a.Close()
+		return nil, err
+	}
+	if err := a.addPercentiles(cfg); err != nil {
+		a.Close()
+		return nil, err
+	}
+	if err := a.addActuators(cfg); err != nil {
+		a.Close()
+		return nil, err
+	}
+	if err := a.addSignals(cfg); err != nil {
+		a.Close()
+		return nil, err
+	}
+	if err := a.addNotifications(cfg); err != nil {
+		a.Close()
+		return nil, err
+	}
+	if cfg.Snapshot != nil {
+		if err := a.restoreSnapshot(); err != nil {
+			a.Close()
+			return nil, fmt.Errorf("snapshot: %w", err)
+		}
+	}
+	if err := a.addSinks(cfg); err != nil {
+		a.Close()
+		return nil, err
+	}
+	a.recordConfig()
+	return a, nil
+}
+
+// addSinks sets up the configured sinks side by side, waiting at most
+// SinkStartWait for them. Those still setting up then carry on in the
+// background and take readings once ready.
+func (a *Agent) addSinks(cfg Config) error {
+	type pending struct {
+		name string
+		typ  string
+		opts SinkOptions
+		sink *lazySink
+	}
+	var sinks []pending
+	start := time.Now()
+	for _, sc := range cfg.Sinks {
+		sc.TLS = sc.TLS.Merge(cfg.TLS)
+		sc.StaticHosts = mergeHosts(cfg.StaticHosts, sc.StaticHosts)
+		sc.Namespace = a.ns
+		sc.ServerTime = a.serverTime()
+		name := sc.Name
+		if name == "" {
+			name = sc.Type
+		}
+		if sc.Budget != nil {
+			sc.Meter = new(ByteMeter)
+		}
+		opts, err := sc.options()
+		if err == nil {
+			sc.Control, err = a.dialControl(name, sc.Interfaces)
+		}
+		if err != nil {
+			return fmt.Errorf("sink %q: %w", sc.Type, err)
+		}
+		sc := sc
+		sinks = append(sinks, pending{name, sc.Type, opts, startSink(func() (Sink, error) { return newSink(sc) })})
+	}
+
+	wait := cfg.SinkStartWait.D()
+	if wait <= 0 {
+		wait = defaultSinkStartWait
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), wait)
+	defer cancel()
+	for i, p := range sinks {
+		var s Sink = p.sink
+		var err error
+		switch {
+		case !p.sink.wait(ctx.Done()):
+			log.Printf("⚠️  Sink %s still setting up after %v; starting without it", p.name, wait)
+			go p.sink.report(p.name, start)
+		case p.sink.err != nil:
+			err = fmt.Errorf("sink %q: %w", p.typ, p.sink.err)
+		default:
+			s = p.sink.sink
+		}
+		if err == nil {
+			err = a.AddSinkWithOptions(p.name, s, p.opts)
+		}
+		if err != nil {
+			// Those added are closed with the agent
+			for _, p := range sinks[i:] {
+				p.sink.Close()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// loadCalibration merges the calibrations stored on the carrier board with
+// the calibration file, which overrides them channel by channel, and
+// checks they match the channels
+func loadCalibration(cfg Config, board *sensor.CalibrationFile) (map[string]*sensor.Calibration, error) {
+	f := sensor.CalibrationFile{Channels: make(map[string]sensor.Calibration)}
+	if board != nil {
+		for name, c := range board.Channels {
+			f.Channels[name] = c
+		}
+	}
+	if cfg.CalibrationFile != "" {
+		file, err := sensor.LoadCalibration(cfg.CalibrationFile)
+		if err != nil {
+			return nil, err
+		}
+		for name, c := range file.Channels {
+			f.Channels[name] = c
+		}
+	}
+	configured := make(map[string]bool, len(cfg.Channels))
+	for _, ch := range cfg.Channels {
+		configured[ch.Name] = true
+	}
+	cals := make(map[string]*sensor.Calibration, len(f.Channels))
+	for name, c := range f.Channels {
+		c := c
+		if !configured[name] {
+			log.Printf("⚠️  Calibration for unknown channel %q ignored", name)
+			continue
+		}
+		if c.Compensation != nil && !configured[c.Compensation.Channel] {
+			return nil, fmt.Errorf("calibration of %s: unknown compensation channel %q", name, c.Compensation.Channel)
+		}
+		cals[name] = &c
+		log.Printf("%s calibrated by %s (%s)", name, c.Produced.Method, c.Produced.Time.Format(time.DateOnly))
+	}
+	return cals, nil
+}
+
+// lastValue returns the latest usable value of a channel: from the sample
+// being taken if the channel has already been read, else from the one
+// before. It is called while sampling.
+func (a *Agent) lastValue(name string) (float64, bool) {
+	for _, ch := range a.chans {
+		if ch.sensor.Name() == name && ch.hasLast && ch.last.Quality.Usable() {
+			return ch.last.Value, true
+		}
+	}
+	return 0, false
+}
+
+// recordConfig audits the configuration the agent starts with if it
+// differs from the last one recorded. Only a fingerprint is kept, since
+// the configuration may hold secrets.
+func (a *Agent) recordConfig() {
+	if a.audit == nil {
+		return
+	}
+	b, err := json.Marshal(a.cfg)
+	if err != nil {
+		return
+	}
+	sum := sha256.Sum256(b)
+	fingerprint := hex.EncodeToString(sum[:8])
+	last, _ := a.audit.Last("config.load", "agent")
+	if last.New == fingerprint {
+		return
+	}
+	a.audit.Record(audit.WithActor(context.Background(), "agent"),
+		audit.Entry{Action: "config.load", Target: "agent", Old: last.New, New: fingerprint})
+}
+
+// ADC returns the ADC backend as opened, e.g. to feed a simulator
+func (a *Agent) ADC() hal.ADCController { return a.adc }
+
+// Display returns the formatter for showing values to people, as
+// configured by display; without it, values are shown as measured in
+// English number format
+func (a *Agent) Display() *display.Formatter { return a.display }
+
+// displayPrecision returns the decimal places a channel is shown with:
+// its metadata's precision, or 2
+func displayPrecision(meta *sensor.Metadata) int {
+	if meta != nil && meta.Precision != nil {
+		return *meta.Precision
+	}
+	return 2
+}
+
+// Config returns the configuration the agent was created with
+func (a *Agent) Config() Config { return a.cfg }
+
+// Namespace returns the agent's namespace, with Device filled in; it is
+// empty if none is configured
+func (a *Agent) Namespace() namespace.Namespace { return a.ns }
+
+// AddSensor adds a channel sampled alongside the configured ones. Names
+// must be unique; call it before Run.
+func (a *Agent) AddSensor(s Sensor, r sensor.Range) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, ch := range a.chans {
+		if ch.sensor.Name() == s.Name() {
+			return fmt.Errorf("duplicate sensor %q", s.Name())
+		}
+	}
+
+	history := sensor.NewHistory(a.cfg.historySize())
+	if a.cfg.HistoryDir != "" {
+		// Memory-mapped so the history survives restarts and crashes and
+		// is available as soon as the agent starts
+		if err := os.MkdirAll(a.cfg.HistoryDir, 0755); err != nil {
+			return err
+		}
+		var err error
+		history, err = sensor.OpenHistoryFile(filepath.Join(a.cfg.HistoryDir, s.Name()+".hist"), a.cfg.historySize())
+		if err != nil {
+			return err
+		}
+		if n := history.Len(); n > 0 {
+			log.Printf("restored %d samples of %s history", n, s.Name())
+		}
+	}
+	ch := &channel{sensor: s, rng: r, history: history, scheduled: -1}
+	if d, ok := s.(DescribedSensor); ok {
+		if meta := d.Metadata(); !meta.IsZero() {
+			ch.meta = &meta
+		}
+	}
+	a.chans = append(a.chans, ch)
+	return nil
+}
+
+// AddSink registers a sink with DefaultSinkOptions; call it before Run. It
+// is named by its Name method if it has one.
+func (a *Agent) AddSink(s Sink) error {
+	name := ""
+	if n, ok := s.(interface{ Name() string }); ok {
+		name = n.Name()
+	} else {
+		a.mu.Lock()
+		name = fmt.Sprintf("sink%d", len(a.sinks)+1)
+		a.mu.Unlock()
+	}
+	return a.AddSinkWithOptions(name, s, DefaultSinkOptions)
+}
+
+// AddSinkWithOptions registers a named sink with its own queueing and retry
+// settings; call it before Run. Its state is reported by SinkStatus and as
+// the health check "sink:<name>".
+func (a *Agent) AddSinkWithOptions(name string, s Sink, opts SinkOptions) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, w := range a.sinks {
+		if w.name == name {
+			return fmt.Errorf("duplicate sink %q", name)
+		}
+	}
+	var gate *netGate
+	if n, ok := s.(NetworkSink); ok && n.Network() {
+		gate = a.online
+	}
+	w := newSinkWorker(name, s, opts, gate)
+	if opts.Budget != nil && opts.Meter != nil {
+		w.budget = newBudget(name, *opts.Budget, opts.Meter, a.state)
+	}
+	a.sinks = append(a.sinks, w)
+	a.health.Register("sink:"+name, w.healthCheck)
+	return nil
+}
+
+// SinkStatus reports the delivery state of every sink
+func (a *Agent) SinkStatus() []SinkStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	status := make([]SinkStatus, len(a.sinks))
+	for i, w := range a.sinks {
+		status[i] = w.snapshot()
+	}
+	return status
+}
+
+// SetOnline releases network sinks, which then deliver the readings they
+// buffered, or holds them back again
+func (a *Agent) SetOnline(online bool) {
+	if a.online.set(online) {
+		a.audit.Record(context.Background(), audit.Entry{
+			Action: "agent.online", Target: "network-sinks",
+			Old: strconv.FormatBool(!online), New: strconv.FormatBool(online),
+		})
+		if online {
+			log.Printf("network sinks enabled")
+		} else {
+			log.Printf("offline: holding network sinks")
+		}
+	}
+}
+
+// waitOnline enables network sinks once the network is up, or when the
+// wait times out so they can retry on their own
+func (a *Agent) waitOnline(ctx context.Context) {
+	err := netwait.Wait(ctx, *a.cfg.NetworkWait)
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		log.Printf("⚠️  %v; enabling network sinks anyway", err)
+	}
+	a.SetOnline(true)
+}
+
+// Online reports whether network sinks are enabled
+func (a *Agent) Online() bool { return a.online.isOpen() }
+
+// Audit returns the agent's audit log, nil if none is configured. Programs
+// embedding the agent record their own changes to it, e.g. by wrapping
+// their GPIO controller with audit.WrapGPIO.
+func (a *Agent) Audit() *audit.Log { return a.audit }
+
+// Health returns the agent's health checker, for registering further checks
+func (a *Agent) Health() *health.Checker { return a.health }
+
+// Sensors returns the channel names in sampling order
+func (a *Agent) Sensors() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	names := make([]string, len(a.chans))
+	for i, ch := range a.chans {
+		names[i] = ch.sensor.Name()
+	}
+	return names
+}
+
+// ChannelInfo describes a channel and its latest value, as served on
+// /channels
+type ChannelInfo struct {
+	Name    string           `json:"name"`
+	Unit    string           `json:"unit,omitempty"`
+	Meta    *sensor.Metadata `json:"meta,omitempty"`
+	Range   sensor.Range     `json:"range"`          // as in effect now, by its schedules
+	Time    *time.Time       `json:"time,omitempty"` // of the latest sample, if any
+	Value   *float64         `json:"value,omitempty"`
+	Quality *sensor.Quality  `json:"quality,omitempty"`
+	// Display is the value as people should see it, in the configured
+	// locale and units; set with display configured
+	Display string `json:"display,omitempty"`
+}
+
+// Channels describes every channel in sampling order. It is safe to call
+// from other goroutines while sampling continues.
+func (a *Agent) Channels() []ChannelInfo {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	infos := make([]ChannelInfo, len(a.chans))
+	for i, ch := range a.chans {
+		rng, _ := ch.rangeAt(time.Now())
+		info := ChannelInfo{Name: ch.sensor.Name(), Unit: ch.sensor.Unit(), Meta: ch.meta, Range: rng}
+		if c, ok := a.last.Get(info.Name); ok {
+			t, q := a.last.Time, c.Quality
+			info.Time, info.Quality = &t, &q
+			if !math.IsNaN(c.Value) {
+				v := c.Value
+				info.Value = &v
+			}
+			if a.cfg.Display != nil {
+				info.Display = a.display.Value(c.Value, info.Unit, displayPrecision(ch.meta))
+			}
+		}
+		infos[i] = info
+	}
+	return infos
+}
+
+// serveChannels serves Channels as JSON
+func (a *Agent) serveChannels(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, a.Channels())
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// Run samples at once and then every SampleInterval, passing each reading
+// to the sinks, until ctx is cancelled. Depending on the Config it also:
+//   - serves /metrics, /healthz, /channels, /history, /stats, /events and
+//     the Grafana datasource under /grafana/ on MetricsAddr, plus
+//     /actuators, /holiday, /disk and /audit where those are set up
+//   - lets only the elected leader write to network sinks (Election)
+//   - watches the kernel log (KernelLog) and storage wear (DiskHealth)
+//   - answers provisioning requests over USB (Provisioning)
+//   - keeps disk sinks within the free space (Storage), the agent under
+//     its memory limit (Memory) and samples less while throttled (Thermal)
+//   - sends alerts by email or SMS (Notifications)
+//   - fails network sinks over between interfaces (Connectivity)
+//   - saves its working state every interval and on return (Snapshot)
+func (a *Agent) Run(ctx context.Context) error {
+	a.mu.Lock()
+	sinks := a.sinks
+	a.mu.Unlock()
+	if err := a.startElection(ctx); err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for _, w := range sinks {
+		w := w
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.run(ctx)
+		}()
+	}
+	defer wg.Wait()
+
+	if a.cfg.NetworkWait != nil && !a.cfg.Offline {
+		go a.waitOnline(ctx)
+	}
+	if a.uplink != nil {
+		go a.uplink.Run(ctx)
+	}
+	if a.kernel != nil {
+		go a.watchKernel(ctx, a.kernel)
+	}
+	if a.cfg.Provisioning != nil {
+		go a.serveProvisioning(ctx)
+	}
+	if a.cfg.Storage != nil {
+		go a.guardStorage(ctx)
+	}
+	if a.cfg.Memory != nil {
+		go a.guardMemory(ctx)
+	}
+	if a.cfg.Thermal != nil {
+		go a.guardThermal(ctx)
+	}
+	if a.disk != nil {
+		go a.watchDisk(ctx)
+	}
+	if a.crash != nil && a.cfg.Crash.URL != "" {
+		go a.uploadCrashes(ctx)
+	}
+	if len(a.notifiers) > 0 {
+		events, cancel := a.Subscribe(64)
+		go a.runNotifications(ctx, events, cancel)
+	}
+
+	if a.cfg.MetricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", a.auth.Handler(metrics.Handler()))
+		mux.Handle("/healthz", a.auth.Handler(a.health.Handler()))
+		mux.Handle("/version", a.auth.Handler(buildinfo.Handler()))
+		mux.Handle("/channels", a.auth.Handler(http.HandlerFunc(a.serveChannels)))
+		mux.Handle("/history", a.auth.Handler(http.HandlerFunc(a.serveHistory)))
+		mux.Handle("/stats", a.auth.Handler(http.HandlerFunc(a.serveStats)))
+		mux.Handle("/events", a.auth.Handler(http.HandlerFunc(a.serveEvents)))
+		if h := a.grafanaHandler(); h != nil {
+			mux.Handle("/grafana/", a.auth.Handler(http.StripPrefix("/grafana", h)))
+		}
+		if len(a.actuators) > 0 {
+			mux.Handle("/actuators", a.auth.Handler(http.HandlerFunc(a.serveActuators)))
+			mux.Handle("/actuators/", a.auth.Require(auth.Operator, http.HandlerFunc(a.serveOverride)))
+			mux.Handle("/holiday", a.auth.Require(auth.Operator, http.HandlerFunc(a.serveHoliday)))
+		}
+		if a.disk != nil {
+			mux.Handle("/disk", a.auth.Handler(http.HandlerFunc(a.serveDisk)))
+		}
+		if a.audit != nil {
+			mux.Handle("/audit", a.auth.Require(auth.Admin, a.audit.Handler()))
+		}
+		srv := &http.Server{Addr: a.cfg.MetricsAddr, Handler: realip.Handler(mux, a.proxies), TLSConfig: a.metricsTLS}
+		go func() {
+			log.Printf("metrics endpoint on %s/metrics", a.cfg.MetricsAddr)
+			var err error
+			if srv.TLSConfig != nil {
+				err = srv.ListenAndServeTLS("", "")
+			} else {
+				err = srv.ListenAndServe()
+			}
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("❌ Metrics server: %v", err)
+			}
+		}()
+		defer srv.Close()
+	}
+
+	var flush <-chan time.Time
+	if a.state != nil {
+		t := time.NewTicker(stateFlushInterval)
+		defer t.Stop()
+		flush = t.C
+	}
+
+	sample := func() {
+		r := a.Sample(ctx)
+		leader := a.Leader()
+		corrected := a.correctTime(r)
+		for _, w := range sinks {
+			switch {
+			case w.gate == nil:
+				w.enqueue(r)
+			case leader: // the leader publishes
+				w.enqueue(corrected)
+			}
+		}
+	}
+	var snapshot <-chan time.Time
+	var snapshotFailing bool
+	if a.cfg.Snapshot != nil {
+		interval := a.cfg.Snapshot.Interval.D()
+		if interval <= 0 {
+			interval = defaultSnapshotInterval
+		}
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		snapshot = t.C
+	}
+
+	// The first sample is taken at once, not an interval in, so a board
+	// sampling every minute shows a reading as soon as it starts
+	interval := a.cfg.SampleInterval.D()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	sample()
+	logStartup(a.Last().Time)
+	for {
+		select {
+		case <-ticker.C:
+			sample()
+			if d := a.sampleInterval(); d != interval {
+				interval = d
+				ticker.Reset(d)
+			}
+		case <-flush:
+			if err := a.flushState(); err != nil {
+				log.Printf("⚠️  State file: %v", err)
+			}
+		case <-snapshot:
+			a.keepSnapshot(&snapshotFailing)
+		case <-ctx.Done():
+			if snapshot != nil {
+				a.keepSnapshot(&snapshotFailing)
+			}
+			return nil
+		}
+	}
+}
+
+// Sample reads every channel once, records the result in the history and
+// publishes it, with any quality changes as alerts, to subscribers.
+// Each read may take at most one sample interval.
+func (a *Agent) Sample(ctx context.Context) Reading {
+	a.mu.Lock()
+	chans := a.chans
+	a.mu.Unlock()
+
+	r := Reading{Time: time.Now(), Source: a.ns.Path(), Channels: make([]ChannelReading, 0, len(chans))}
+	for _, ch := range chans {
+		cr := a.read(ctx, ch, r.Time)
+		r.Channels = append(r.Channels, cr)
+	}
+
+	a.mu.Lock()
+	r.Device, r.Seq = a.deviceID, a.nextSeq()
+	a.last = r
+	a.samples++
+	if a.state != nil {
+		a.state.Add(StateSamples, 1)
+	}
+	a.mu.Unlock()
+	a.driveActuators(ctx, r)
+	a.driveSignals(r, chans)
+	a.events.publish(Event{Type: EventReading, Reading: &r})
+	return r
+}
+
+func (a *Agent) read(ctx context.Context, ch *channel, now time.Time) ChannelReading {
+	name := ch.sensor.Name()
+	readCtx, cancel := context.WithTimeout(ctx, a.cfg.SampleInterval.D())
+	value, quality, err := ch.sensor.Read(readCtx)
+	cancel()
+	rng, scheduled := ch.rangeAt(now)
+	if scheduled != ch.scheduled {
+		logSchedule(name, ch, rng, scheduled)
+		ch.scheduled = scheduled
+	}
+
+	switch {
+	case errors.Is(err, hal.ErrDegraded):
+		value, quality = math.NaN(), sensor.Fault
+	case err != nil:
+		// Repeat the previous value as stale, if there is one
+		if ctx.Err() == nil {
+			log.Printf("❌ %s: %v", name, err)
+		}
+		if ch.hasLast && ch.last.Quality.Usable() {
+			value, quality = ch.last.Value, sensor.Stale
+		} else {
+			value, quality = math.NaN(), sensor.Fault
+		}
+	case quality.Usable():
+		quality = rng.Check(value, quality)
+	}
+
+	if quality != sensor.OK {
+		flaggedReadings.Inc(name, quality.String())
+	}
+	if quality != ch.quality {
+		a.events.publish(Event{Type: EventAlert, Alert: &Alert{Time: now, Channel: name, Quality: quality, Previous: ch.quality, Value: value}})
+		ch.quality = quality
+	}
+	sample := sensor.Sample{Time: now, Value: value, Quality: quality}
+	ch.history.Add(sample)
+	ch.trackPercentiles(name, sample, rng)
+	for _, f := range ch.forecasts {
+		if alert := f.update(name, sample); alert != nil {
+			logForecast(alert, ch.sensor.Unit())
+			a.events.publish(Event{Type: EventForecast, Forecast: alert})
+		}
+	}
+	if err == nil {
+		ch.last, ch.hasLast = sample, true
+	}
+
+	cr := ChannelReading{Name: name, Unit: ch.sensor.Unit(), Value: value, Quality: quality, Meta: ch.meta}
+	if s, ok := ch.sensor.(*adcSensor); ok && quality.Usable() {
+		raw := s.raw
+		cr.Raw = &raw
+	}
+	return cr
+}
+
+// Last returns the most recent reading
+func (a *Agent) Last() Reading {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.last
+}
+
+// SampleCount returns the number of readings taken
+func (a *Agent) SampleCount() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.samples
+}
+
+// GetStats summarises a channel over the last window of history. Readings
+// the channel's range suppresses are left out. With percentiles tracked
+// over the same window, p50, p95 and p99 come from that histogram, which
+// may reach further back than the history. It is safe to call from other
+// goroutines while sampling continues.
+func (a *Agent) GetStats(name string, window time.Duration) (sensor.Stats, error) {
+	ch := a.channel(name)
+	if ch == nil {
+		return sensor.Stats{}, fmt.Errorf("unknown sensor %q", name)
+	}
+	now := time.Now()
+	st := sensor.Compute(ch.history.Since(now.Add(-window)), ch.rng)
+	st.Window = window
+	if h := ch.percentile(window); h != nil {
+		if snap := h.Snapshot(now); snap.Count() > 0 {
+			st.P50, st.P95, st.P99 = snap.Quantile(0.5), snap.Quantile(0.95), snap.Quantile(0.99)
+		}
+	}
+	return st, nil
+}
+
+// Close closes sinks and sensors that implement io.Closer, flushes
+// file-backed history and closes the ADC and GPIO. Call it after Run has returned.
+func (a *Agent) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var errs []error
+	for _, w := range a.sinks {
+		if c, ok := w.sink.(io.Closer); ok {
+			errs = append(errs, c.Close())
+		}
+	}
+	for _, ch := range a.chans {
+		if c, ok := ch.sensor.(io.Closer); ok {
+			errs = append(errs, c.Close())
+		}
+		errs = append(errs, ch.history.Sync(), ch.history.Close())
+	}
+	if a.unsafe != nil {
+		a.unsafe()
+		errs = append(errs, a.resetActuators(context.Background()))
+	}
+	errs = append(errs, a.closeSignals())
+	if a.gpio != nil {
+		errs = append(errs, a.gpio.Close())
+	}
+	errs = append(errs, a.adc.Close(), a.audit.Close())
+	if a.state != nil {
+		errs = append(errs, a.addUptime(), a.saveSeq(true), a.state.Close())
+	}
+	errs = append(errs, a.closeCrash())
+	return errors.Join(errs...)
+}
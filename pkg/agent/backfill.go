@@ -0,0 +1,231 @@
+package agent
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"riscv-dev/pkg/sensor"
+)
+
+// BackfillOptions control a replay of recorded readings into a sink
+type BackfillOptions struct {
+	// From and To select the readings replayed; zero for no bound
+	From, To time.Time
+	// Speed paces the replay as a multiple of the time the readings span,
+	// e.g. 60 replays an hour a minute; 0 goes as fast as the sink takes
+	Speed float64
+	// Retries is how many times a failed write is tried again, with
+	// backoff from one second to 30s, before the replay stops
+	Retries int
+	// Source is set on readings recorded without one, as history files
+	// are
+	Source string
+	// Progress, if set, is called after each reading is written
+	Progress func(sent int, r Reading)
+}
+
+// BackfillResult is how far a replay got
+type BackfillResult struct {
+	Sent int
+	Last time.Time // of the last reading written, to resume from
+}
+
+// Backfill writes readings to s in order with their original timestamps.
+// A reading the sink still refuses after the retries stops the replay;
+// the result tells where to resume.
+func Backfill(ctx context.Context, s Sink, readings []Reading, opts BackfillOptions) (BackfillResult, error) {
+	var res BackfillResult
+	var first time.Time
+	start := time.Now()
+	for _, r := range readings {
+		if (!opts.From.IsZero() && r.Time.Before(opts.From)) || (!opts.To.IsZero() && r.Time.After(opts.To)) {
+			continue
+		}
+		if r.Source == "" {
+			r.Source = opts.Source
+		}
+		if first.IsZero() {
+			first = r.Time
+		}
+		if opts.Speed > 0 {
+			due := start.Add(time.Duration(float64(r.Time.Sub(first)) / opts.Speed))
+			select {
+			case <-time.After(time.Until(due)):
+			case <-ctx.Done():
+				return res, ctx.Err()
+			}
+		}
+		backoff := time.Second
+		for attempt := 0; ; attempt++ {
+			err := s.Write(ctx, r)
+			if err == nil {
+				break
+			}
+			if attempt >= opts.Retries || ctx.Err() != nil {
+				return res, fmt.Errorf("reading of %s: %w", r.Time.Format(time.RFC3339Nano), err)
+			}
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return res, ctx.Err()
+			}
+			backoff = min(2*backoff, 30*time.Second)
+		}
+		res.Sent++
+		res.Last = r.Time
+		if opts.Progress != nil {
+			opts.Progress(res.Sent, r)
+		}
+	}
+	return res, nil
+}
+
+// BackfillSink creates the configured sink called name (or of that type,
+// if unnamed) as the agent would, to replay readings into
+func (c Config) BackfillSink(name string) (Sink, error) {
+	ns, err := c.ResolveNamespace()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, sc := range c.Sinks {
+		n := sc.Name
+		if n == "" {
+			n = sc.Type
+		}
+		if n != name {
+			names = append(names, n)
+			continue
+		}
+		sc.TLS = sc.TLS.Merge(c.TLS)
+		sc.StaticHosts = mergeHosts(c.StaticHosts, sc.StaticHosts)
+		sc.Namespace = ns
+		sc.DataDir = c.DataDir
+		return newSink(sc)
+	}
+	return nil, fmt.Errorf("no sink %q in the configuration (have %v)", name, names)
+}
+
+// LoadRecorded reads recorded readings, oldest first, from the files the
+// agent keeps: history files (name.hist, or a directory of them), whose
+// samples are joined into readings of the channels sampled together, and
+// the JSON lines of a file sink, including rotated and gzipped ones.
+// units gives the unit of each channel, which history files don't keep.
+func LoadRecorded(paths []string, units map[string]string) ([]Reading, error) {
+	var readings []Reading
+	hist := make(map[string][]sensor.Sample)
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		files := []string{p}
+		if fi.IsDir() {
+			files, _ = filepath.Glob(filepath.Join(p, "*.hist"))
+			if len(files) == 0 {
+				return nil, fmt.Errorf("%s: no history files", p)
+			}
+		}
+		for _, f := range files {
+			if strings.HasSuffix(f, ".hist") {
+				data, err := os.ReadFile(f)
+				if err != nil {
+					return nil, err
+				}
+				samples, err := sensor.DecodeHistory(data)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", f, err)
+				}
+				name := strings.TrimSuffix(filepath.Base(f), ".hist")
+				hist[name] = append(hist[name], samples...)
+				continue
+			}
+			rs, err := readJSONLines(f)
+			if err != nil {
+				return nil, err
+			}
+			readings = append(readings, rs...)
+		}
+	}
+	// Where both were given, file sink lines are kept over the history
+	// samples of the same readings
+	recorded := make(map[int64]bool, len(readings))
+	for _, r := range readings {
+		recorded[r.Time.UnixNano()] = true
+	}
+	for _, r := range joinSamples(hist, units) {
+		if !recorded[r.Time.UnixNano()] {
+			readings = append(readings, r)
+		}
+	}
+	sort.SliceStable(readings, func(i, j int) bool { return readings[i].Time.Before(readings[j].Time) })
+	return readings, nil
+}
+
+// joinSamples groups the samples of every channel by time into readings
+func joinSamples(hist map[string][]sensor.Sample, units map[string]string) []Reading {
+	names := make([]string, 0, len(hist))
+	for name := range hist {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	byTime := make(map[int64]*Reading)
+	var readings []*Reading
+	for _, name := range names {
+		for _, s := range hist[name] {
+			r, ok := byTime[s.Time.UnixNano()]
+			if !ok {
+				r = &Reading{Time: s.Time}
+				byTime[s.Time.UnixNano()] = r
+				readings = append(readings, r)
+			}
+			r.Channels = append(r.Channels, ChannelReading{Name: name, Unit: units[name], Value: s.Value, Quality: s.Quality})
+		}
+	}
+	out := make([]Reading, len(readings))
+	for i, r := range readings {
+		out[i] = *r
+	}
+	return out
+}
+
+// readJSONLines reads the readings a file sink wrote, gzipped or not
+func readJSONLines(path string) ([]Reading, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var in io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		defer zr.Close()
+		in = zr
+	}
+	var readings []Reading
+	sc := bufio.NewScanner(in)
+	sc.Buffer(make([]byte, 64*1024), 16<<20)
+	for line := 1; sc.Scan(); line++ {
+		if len(strings.TrimSpace(sc.Text())) == 0 {
+			continue
+		}
+		var r Reading
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		readings = append(readings, r)
+	}
+	return readings, sc.Err()
+}
@@ -0,0 +1,20 @@
+package agent
+
+import (
+	"log"
+
+	"riscv-dev/pkg/boards"
+)
+
+// checkBoard warns about the features the configuration needs that the
+// board this runs on lacks or only partly has, going by the board
+// database. It only warns: the database may be behind the hardware.
+func checkBoard(cfg Config) {
+	b, _ := boards.Detect()
+	if b == nil {
+		return
+	}
+	for _, w := range b.Check(cfg.KernelFeatures()) {
+		log.Printf("⚠️  %s", w)
+	}
+}
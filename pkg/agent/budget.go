@@ -0,0 +1,213 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"riscv-dev/pkg/config"
+	"riscv-dev/pkg/metrics"
+	"riscv-dev/pkg/state"
+)
+
+// BudgetConfig caps the bytes a sink moves over the network each day, for
+// metered links such as cellular. As the day's use approaches the cap the
+// sink sends rollups (mean, min and max) instead of raw readings, less
+// often the closer it gets, and once the cap is reached it holds readings
+// until midnight, when it sends them as one rollup.
+type BudgetConfig struct {
+	// BytesPerDay is the cap, counting everything the sink's connections
+	// send and receive, TLS and HTTP overhead included, from local
+	// midnight
+	BytesPerDay int64 `json:"bytes_per_day"`
+	// Rollups are the rollup intervals taking over from raw readings as the
+	// day's use passes 50%, 75% and 90% of the cap; default 1m, 5m and 15m
+	Rollups []config.Duration `json:"rollups,omitempty"`
+}
+
+// BudgetStatus is the policy a sink's budget applies
+type BudgetStatus struct {
+	BytesPerDay int64 `json:"bytes_per_day"`
+	UsedBytes   int64 `json:"used_bytes"` // today
+	// Policy is "raw", "rollup every <interval>" or "held until midnight"
+	Policy string `json:"policy"`
+}
+
+// budgetSteps are the fractions of the cap at which each rollup interval
+// takes over
+var budgetSteps = []float64{0.5, 0.75, 0.9}
+
+var defaultBudgetRollups = []config.Duration{
+	config.Duration(time.Minute), config.Duration(5 * time.Minute), config.Duration(15 * time.Minute),
+}
+
+var (
+	sinkBudgetUsed  = metrics.NewGauge("agent_sink_budget_used_bytes", "Bytes a sink with a budget moved today", "sink")
+	sinkBudgetLevel = metrics.NewGauge("agent_sink_budget_level", "Policy a sink's budget applies: 0 raw, 1-3 ever longer rollups, 4 held", "sink")
+)
+
+func (c BudgetConfig) validate() error {
+	if c.BytesPerDay <= 0 {
+		return errors.New("budget: bytes_per_day must be positive")
+	}
+	if len(c.Rollups) != 0 && len(c.Rollups) != len(budgetSteps) {
+		return fmt.Errorf("budget: rollups needs %d intervals, for 50%%, 75%% and 90%%", len(budgetSteps))
+	}
+	for _, d := range c.Rollups {
+		if d <= 0 {
+			return errors.New("budget: rollups must be positive")
+		}
+	}
+	return nil
+}
+
+// budget tracks a sink's use of its daily cap. The day's use is kept in
+// the state file, if any, so restarts don't reset it.
+type budget struct {
+	sink  string
+	cfg   BudgetConfig
+	meter *ByteMeter
+	store *state.Store // nil without a state file
+
+	mu    sync.Mutex
+	day   time.Time // local midnight starting the day
+	base  int64     // the meter's total, less what the day used before it
+	saved int64     // use last written to the store
+	level int       // 0 raw, 1..len(budgetSteps) rollups, then held
+}
+
+func newBudget(sink string, cfg BudgetConfig, meter *ByteMeter, store *state.Store) *budget {
+	if len(cfg.Rollups) == 0 {
+		cfg.Rollups = defaultBudgetRollups
+	}
+	b := &budget{sink: sink, cfg: cfg, meter: meter, store: store, day: midnight(time.Now())}
+	if store != nil {
+		// "2024-01-02 12345": the day and its use
+		if v, ok := store.Get(b.key()); ok {
+			day, used, _ := strings.Cut(v, " ")
+			n, err := strconv.ParseInt(used, 10, 64)
+			if err == nil && day == b.day.Format(time.DateOnly) {
+				b.base, b.saved = -n, n
+			}
+		}
+	}
+	return b
+}
+
+func (b *budget) key() string { return "budget." + b.sink }
+
+func midnight(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// used returns the bytes moved since the day started
+func (b *budget) used() int64 { return b.meter.Total() - b.base }
+
+// check starts a new day if it is due and returns the rollup interval the
+// day's use calls for, 0 for raw readings, and when held, the end of the
+// hold
+func (b *budget) check(now time.Time) (every time.Duration, holdUntil time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	next := b.day.AddDate(0, 0, 1)
+	if !now.Before(next) {
+		b.day, b.base = midnight(now), b.meter.Total()
+		next = b.day.AddDate(0, 0, 1)
+	}
+	used := b.used()
+	if b.store != nil && used != b.saved {
+		b.store.Set(b.key(), b.day.Format(time.DateOnly)+" "+strconv.FormatInt(used, 10))
+		b.saved = used
+	}
+	sinkBudgetUsed.Set(float64(used), b.sink)
+
+	frac := float64(used) / float64(b.cfg.BytesPerDay)
+	level := 0
+	for i, step := range budgetSteps {
+		if frac >= step {
+			level = i + 1
+		}
+	}
+	if frac >= 1 {
+		level = len(budgetSteps) + 1
+	}
+	if level != b.level {
+		b.logLevel(level, frac)
+		b.level = level
+		sinkBudgetLevel.Set(float64(level), b.sink)
+	}
+	switch {
+	case level == 0:
+		return 0, time.Time{}
+	case level > len(budgetSteps):
+		return b.cfg.Rollups[len(budgetSteps)-1].D(), next
+	default:
+		return b.cfg.Rollups[level-1].D(), time.Time{}
+	}
+}
+
+func (b *budget) logLevel(level int, frac float64) {
+	pct := int(100 * frac)
+	switch {
+	case level == 0:
+		log.Printf("✅ Sink %s: new budget day, sending raw readings again", b.sink)
+	case level > len(budgetSteps):
+		log.Printf("⚠️  Sink %s: daily budget of %d bytes used up, holding readings until midnight", b.sink, b.cfg.BytesPerDay)
+	case level > b.level:
+		log.Printf("⚠️  Sink %s: %d%% of its daily budget used, sending one rollup every %v", b.sink, pct, b.cfg.Rollups[level-1].D())
+	default:
+		log.Printf("Sink %s: %d%% of its daily budget used, sending one rollup every %v", b.sink, pct, b.cfg.Rollups[level-1].D())
+	}
+}
+
+// status describes the policy in force
+func (b *budget) status() *BudgetStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st := &BudgetStatus{BytesPerDay: b.cfg.BytesPerDay, UsedBytes: b.used(), Policy: "raw"}
+	switch {
+	case b.level > len(budgetSteps):
+		st.Policy = "held until midnight"
+	case b.level > 0:
+		st.Policy = "rollup every " + b.cfg.Rollups[b.level-1].D().String()
+	}
+	return st
+}
+
+// ByteMeter counts the bytes a sink's connections carry, for its budget.
+// SinkConfig.Dial counts its connections; sinks moving data otherwise call
+// Add themselves.
+type ByteMeter struct {
+	n atomic.Int64
+}
+
+// Add counts n bytes
+func (m *ByteMeter) Add(n int) { m.n.Add(int64(n)) }
+
+// Total returns the bytes counted so far
+func (m *ByteMeter) Total() int64 { return m.n.Load() }
+
+// meteredConn counts what a connection reads and writes
+type meteredConn struct {
+	net.Conn
+	meter *ByteMeter
+}
+
+func (c meteredConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.meter.Add(n)
+	return n, err
+}
+
+func (c meteredConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.meter.Add(n)
+	return n, err
+}
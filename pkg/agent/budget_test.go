@@ -0,0 +1,131 @@
+package agent
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"riscv-dev/pkg/config"
+	"riscv-dev/pkg/state"
+)
+
+func TestBudgetSteps(t *testing.T) {
+	meter := &ByteMeter{}
+	b := newBudget("cellular", BudgetConfig{BytesPerDay: 1000}, meter, nil)
+	now := b.day.Add(12 * time.Hour)
+	tomorrow := b.day.AddDate(0, 0, 1)
+
+	for _, tc := range []struct {
+		used   int64
+		every  time.Duration
+		held   bool
+		policy string
+	}{
+		{0, 0, false, "raw"},
+		{499, 0, false, "raw"},
+		{500, time.Minute, false, "rollup every 1m0s"},
+		{750, 5 * time.Minute, false, "rollup every 5m0s"},
+		{900, 15 * time.Minute, false, "rollup every 15m0s"},
+		{1000, 15 * time.Minute, true, "held until midnight"},
+		{5000, 15 * time.Minute, true, "held until midnight"},
+	} {
+		meter.Add(int(tc.used - meter.Total()))
+		every, hold := b.check(now)
+		if every != tc.every || !hold.IsZero() != tc.held {
+			t.Errorf("%d bytes used: every %v, hold until %v", tc.used, every, hold)
+		}
+		if tc.held && !hold.Equal(tomorrow) {
+			t.Errorf("%d bytes used: held until %v, want midnight", tc.used, hold)
+		}
+		if st := b.status(); st.Policy != tc.policy || st.UsedBytes != tc.used {
+			t.Errorf("%d bytes used: status %+v", tc.used, st)
+		}
+	}
+
+	// A new day starts from nothing
+	every, hold := b.check(tomorrow.Add(time.Minute))
+	if every != 0 || !hold.IsZero() {
+		t.Errorf("next day: every %v, hold until %v", every, hold)
+	}
+	if st := b.status(); st.Policy != "raw" || st.UsedBytes != 0 {
+		t.Errorf("next day: status %+v", st)
+	}
+	meter.Add(600)
+	if every, _ := b.check(tomorrow.Add(time.Hour)); every != time.Minute {
+		t.Errorf("next day at 60%%: every %v", every)
+	}
+}
+
+func TestBudgetSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state")
+	store, err := state.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meter := &ByteMeter{}
+	b := newBudget("cellular", BudgetConfig{BytesPerDay: 1000}, meter, store)
+	meter.Add(800)
+	b.check(time.Now())
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The new process's meter starts at zero, but the day's use is restored
+	if store, err = state.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	b = newBudget("cellular", BudgetConfig{BytesPerDay: 1000}, &ByteMeter{}, store)
+	if every, _ := b.check(time.Now()); every != 5*time.Minute || b.used() != 800 {
+		t.Errorf("after restart: every %v with %d bytes used", every, b.used())
+	}
+}
+
+func TestSinkBudget(t *testing.T) {
+	meter := &ByteMeter{}
+	sink := &recordingSink{}
+	cfg := BudgetConfig{
+		BytesPerDay: 1000,
+		Rollups:     []config.Duration{config.Duration(30 * time.Millisecond), config.Duration(time.Hour), config.Duration(time.Hour)},
+	}
+	w := newSinkWorker("cellular", sink, SinkOptions{QueueSize: 16}, nil)
+	w.budget = newBudget("cellular", cfg, meter, nil)
+
+	// Readings queued when the budget steps down go out in the rollup
+	meter.Add(600)
+	w.enqueue(seqReading(1))
+	w.enqueue(seqReading(2))
+	w.checkBudget(time.Now())
+	if st := w.snapshot(); !st.Shedding || st.Queued != 0 || st.Aggregated != 2 || st.Budget.Policy != "rollup every 30ms" {
+		t.Errorf("status = %+v, budget %+v", st, st.Budget)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	for seq := uint64(3); seq <= 5; seq++ {
+		w.enqueue(seqReading(seq))
+	}
+	waitFor(t, "the rollup", func() bool { return w.snapshot().Delivered == 1 })
+	got := sink.got()
+	if len(got) != 1 || got[0].Span == nil || got[0].Span.Samples != 5 || got[0].Seq != 5 {
+		t.Fatalf("delivered %+v", got)
+	}
+
+	// Once the cap is reached nothing goes out until midnight
+	meter.Add(500)
+	waitFor(t, "the hold", func() bool { return w.snapshot().Budget.Policy == "held until midnight" })
+	w.enqueue(seqReading(6))
+	time.Sleep(100 * time.Millisecond)
+	if st := w.snapshot(); st.Delivered != 1 || st.Aggregated != 6 {
+		t.Errorf("held: status %+v", st)
+	}
+}
@@ -0,0 +1,73 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"riscv-dev/pkg/hal"
+	"riscv-dev/pkg/metrics"
+	"riscv-dev/pkg/nvstore"
+	"riscv-dev/pkg/sensor"
+)
+
+// Keys of the carrier board EEPROM, as written with riscv-dev eeprom
+const (
+	CarrierSerial      = "serial"
+	CarrierModel       = "model"
+	CarrierRevision    = "revision"
+	CarrierCalibration = "calibration" // sensor.CalibrationFile as JSON
+)
+
+var carrierInfo = metrics.NewGauge("agent_carrier_info", "Identity of the sensor carrier board, from its EEPROM", "model", "revision", "serial")
+
+// carrier is what the agent reads from the carrier board EEPROM
+type carrier struct {
+	serial, model, revision string
+	calibration             *sensor.CalibrationFile
+}
+
+// readCarrier reads the identity and calibration of the carrier board, if
+// its EEPROM is configured. An unreadable EEPROM fails startup rather than
+// leaving the channels uncalibrated.
+func readCarrier(cfg *hal.EEPROMConfig) (carrier, error) {
+	var c carrier
+	if cfg == nil {
+		return c, nil
+	}
+	mem, err := hal.OpenEEPROM(*cfg)
+	if err != nil {
+		return c, fmt.Errorf("carrier EEPROM: %w", err)
+	}
+	defer mem.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	store, err := nvstore.Open(ctx, mem)
+	if err != nil {
+		return c, fmt.Errorf("carrier EEPROM: %w", err)
+	}
+	c.serial, _ = store.Get(CarrierSerial)
+	c.model, _ = store.Get(CarrierModel)
+	c.revision, _ = store.Get(CarrierRevision)
+	if data, ok := store.Get(CarrierCalibration); ok {
+		f, err := sensor.ParseCalibration([]byte(data), "carrier EEPROM")
+		if err != nil {
+			return c, err
+		}
+		c.calibration = &f
+	}
+	if c.serial == "" && c.model == "" && c.calibration == nil {
+		log.Printf("⚠️  Carrier EEPROM is blank")
+		return c, nil
+	}
+	carrierInfo.Set(1, c.model, c.revision, c.serial)
+	desc := "Carrier board"
+	for _, part := range [][2]string{{"", c.model}, {"rev ", c.revision}, {"serial ", c.serial}} {
+		if part[1] != "" {
+			desc += " " + part[0] + part[1]
+		}
+	}
+	log.Print(desc)
+	return c, nil
+}
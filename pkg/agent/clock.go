@@ -0,0 +1,155 @@
+package agent
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"time"
+
+	"riscv-dev/pkg/clockdrift"
+	"riscv-dev/pkg/config"
+	"riscv-dev/pkg/sensor"
+)
+
+// ClockConfig estimates how far the board's clock is off the servers its
+// network sinks talk to, from the Date of their responses (see package
+// clockdrift), for boards with no battery RTC and unreliable NTP. The
+// offset and the drift become channels, and network sinks can have their
+// readings' times corrected.
+type ClockConfig struct {
+	// Channel names the offset channel, in seconds to add to the board's
+	// time for the server's; default "clock_offset"
+	Channel string `json:"channel,omitempty"`
+	// DriftChannel names the drift channel, in microseconds a second the
+	// board's clock loses (ppm); default "clock_drift"
+	DriftChannel string `json:"drift_channel,omitempty"`
+	// Window is how far back responses are combined; default 10m
+	Window config.Duration `json:"window,omitempty"`
+	// Correct adds the offset, extrapolated with the drift, to the times
+	// of readings sent to network sinks
+	Correct bool `json:"correct,omitempty"`
+	// Range applies to the offset channel, to alert on a clock gone astray
+	sensor.Range
+}
+
+const defaultClockWindow = 10 * time.Minute
+
+// addClock sets up the estimator and its channels
+func (a *Agent) addClock(cfg Config) error {
+	c := cfg.Clock
+	if c == nil {
+		return nil
+	}
+	window := c.Window.D()
+	if window <= 0 {
+		window = defaultClockWindow
+	}
+	a.clock = clockdrift.New(window)
+	offset := &clockSensor{name: c.Channel, unit: "s", window: window, est: a.clock}
+	if offset.name == "" {
+		offset.name = "clock_offset"
+	}
+	if err := a.AddSensor(offset, c.Range); err != nil {
+		return err
+	}
+	drift := &clockSensor{name: c.DriftChannel, unit: "ppm", window: window, est: a.clock, drift: true}
+	if drift.name == "" {
+		drift.name = "clock_drift"
+	}
+	return a.AddSensor(drift, sensor.Range{})
+}
+
+// serverTime returns the function network sinks report their exchanges
+// to, nil without clock
+func (a *Agent) serverTime() func(sent, received, server time.Time) {
+	if a.clock == nil {
+		return nil
+	}
+	return func(sent, received, server time.Time) {
+		a.clock.Add(sent, received, server, time.Second)
+	}
+}
+
+// correctTime returns r with its times moved to the server's clock, if
+// correcting
+func (a *Agent) correctTime(r Reading) Reading {
+	if a.clock == nil || !a.cfg.Clock.Correct {
+		return r
+	}
+	off, ok := a.clock.Offset(r.Time)
+	if !ok {
+		return r
+	}
+	r.Time = r.Time.Add(off)
+	if r.Span != nil {
+		span := *r.Span
+		span.Start = span.Start.Add(off)
+		r.Span = &span
+	}
+	return r
+}
+
+// clockSensor reports the estimated offset or drift. Both are faults until
+// there is an estimate, and the offset is stale while no server has
+// answered within the window.
+type clockSensor struct {
+	name, unit string
+	window     time.Duration
+	est        *clockdrift.Estimator
+	drift      bool
+}
+
+func (s *clockSensor) Name() string { return s.name }
+func (s *clockSensor) Unit() string { return s.unit }
+
+func (s *clockSensor) Read(ctx context.Context) (float64, sensor.Quality, error) {
+	est, ok := s.est.Estimate()
+	if !ok {
+		return math.NaN(), sensor.Fault, nil
+	}
+	v := est.Offset.Seconds()
+	if s.drift {
+		if v, ok = s.est.Drift(); !ok {
+			return math.NaN(), sensor.Fault, nil
+		}
+	}
+	if time.Since(est.At) > s.window {
+		return v, sensor.Stale, nil
+	}
+	return v, sensor.OK, nil
+}
+
+// serverTimeTransport reports the Date of each response, with when the
+// request went out and the response came back
+type serverTimeTransport struct {
+	base    http.RoundTripper
+	observe func(sent, received, server time.Time)
+}
+
+func (t serverTimeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	sent := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	received := time.Now()
+	if server, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+		t.observe(sent, received, server)
+	}
+	return resp, nil
+}
+
+// CloseIdleConnections closes the base transport's idle connections
+func (t serverTimeTransport) CloseIdleConnections() {
+	if c, ok := t.base.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+// withServerTime wraps rt to report server times, if observe is set
+func withServerTime(rt http.RoundTripper, observe func(sent, received, server time.Time)) http.RoundTripper {
+	if observe == nil {
+		return rt
+	}
+	return serverTimeTransport{rt, observe}
+}
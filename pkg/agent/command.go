@@ -0,0 +1,312 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"riscv-dev/pkg/adapter"
+	"riscv-dev/pkg/config"
+	"riscv-dev/pkg/sensor"
+)
+
+// CommandConfig reads channels from the output of an external program:
+// the quickest way to support hardware the agent has no driver for, e.g.
+// ["vcgencmd", "measure_temp"] or a vendor's command-line tool. The output
+// is parsed with adapter.ParseValues, so it may be JSON, key=value pairs
+// or bare numbers.
+type CommandConfig struct {
+	// Command is the program and its arguments; use ["sh", "-c", "..."]
+	// for a pipeline
+	Command []string `json:"command"`
+	// Stream keeps the program running and takes every line it prints as
+	// new values, instead of running it every Interval
+	Stream bool `json:"stream,omitempty"`
+	// Interval is how often the program runs, or how often a stream is
+	// expected to print; default the sample interval
+	Interval config.Duration `json:"interval,omitempty"`
+	// Timeout bounds each run; default Interval, at least 1s
+	Timeout config.Duration `json:"timeout,omitempty"`
+	// MaxAge is how long values stay current without new output, after
+	// which they are reported stale; default 3 intervals
+	MaxAge   config.Duration  `json:"max_age,omitempty"`
+	Channels []CommandChannel `json:"channels"`
+}
+
+// CommandChannel is a channel read from a command's output
+type CommandChannel struct {
+	Name string `json:"name"`
+	Unit string `json:"unit,omitempty"`
+	// Field is the value to take: a JSON key (dotted for nested objects),
+	// the key of a key=value pair, or the position of a bare number from
+	// "1"; default the channel's name, or the only value in the output
+	Field string `json:"field,omitempty"`
+	// Scale multiplies the value, e.g. 0.001 for millidegrees; 0 is 1
+	Scale float64 `json:"scale,omitempty"`
+	sensor.Range
+	sensor.Metadata
+}
+
+// commandSource runs a command in the background and keeps the values it
+// last printed, for its channels' sensors to read
+type commandSource struct {
+	cfg      CommandConfig
+	interval time.Duration
+	timeout  time.Duration
+	maxAge   time.Duration
+	stop     context.CancelFunc
+	done     chan struct{}
+
+	mu      sync.Mutex
+	values  map[string]float64
+	at      time.Time // of values
+	failing bool
+	refs    int
+}
+
+// addCommands starts the configured commands and adds their channels
+func (a *Agent) addCommands(cfg Config) error {
+	for _, cc := range cfg.Commands {
+		if len(cc.Command) == 0 {
+			return errors.New("commands: command is required")
+		}
+		if len(cc.Channels) == 0 {
+			return fmt.Errorf("command %s: channels are required", cc.Command[0])
+		}
+		src := newCommandSource(cc, cfg.SampleInterval.D())
+		src.refs = len(cc.Channels)
+		for i, ch := range cc.Channels {
+			if err := a.AddSensor(&commandSensor{src: src, ch: ch, single: len(cc.Channels) == 1}, ch.Range); err != nil {
+				// The agent only closes the sensors it has
+				for range cc.Channels[i:] {
+					src.release()
+				}
+				return fmt.Errorf("command %s: %w", cc.Command[0], err)
+			}
+		}
+	}
+	return nil
+}
+
+func newCommandSource(cfg CommandConfig, sampleInterval time.Duration) *commandSource {
+	s := &commandSource{cfg: cfg, interval: cfg.Interval.D(), timeout: cfg.Timeout.D(), maxAge: cfg.MaxAge.D(), done: make(chan struct{})}
+	if s.interval <= 0 {
+		s.interval = sampleInterval
+	}
+	if s.timeout <= 0 {
+		s.timeout = max(s.interval, time.Second)
+	}
+	if s.maxAge <= 0 {
+		s.maxAge = 3 * max(s.interval, sampleInterval)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stop = cancel
+	if cfg.Stream {
+		go s.stream(ctx)
+	} else {
+		go s.poll(ctx)
+	}
+	return s
+}
+
+// command prepares the program in its own process group, killed whole
+// when ctx ends, so a shell pipeline doesn't leave its children behind
+func (s *commandSource) command(ctx context.Context) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, s.cfg.Command[0], s.cfg.Command[1:]...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error { return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) }
+	cmd.WaitDelay = time.Second
+	return cmd
+}
+
+// poll runs the program every interval until stopped
+func (s *commandSource) poll(ctx context.Context) {
+	defer close(s.done)
+	t := time.NewTicker(s.interval)
+	defer t.Stop()
+	for {
+		s.run(ctx)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// run runs the program once and takes its output
+func (s *commandSource) run(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	cmd := s.command(ctx)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		err = fmt.Errorf("timed out after %v", s.timeout)
+	case err != nil:
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			first, _, _ := strings.Cut(msg, "\n")
+			err = fmt.Errorf("%w: %s", err, first)
+		}
+	}
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return // stopping
+	}
+	s.update(out, err)
+}
+
+// stream runs the program and takes each line it prints, restarting it
+// with backoff whenever it exits
+func (s *commandSource) stream(ctx context.Context) {
+	defer close(s.done)
+	backoff := time.Second
+	for {
+		started := time.Now()
+		cmd := s.command(ctx)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		stdout, err := cmd.StdoutPipe()
+		if err == nil {
+			err = cmd.Start()
+		}
+		if err == nil {
+			sc := bufio.NewScanner(stdout)
+			for sc.Scan() {
+				if line := bytes.TrimSpace(sc.Bytes()); len(line) > 0 {
+					s.update(line, nil)
+				}
+			}
+			err = cmd.Wait()
+			if err == nil {
+				err = errors.New("exited")
+			}
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				lines := strings.Split(msg, "\n")
+				err = fmt.Errorf("%w: %s", err, lines[len(lines)-1])
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) > time.Minute {
+			backoff = time.Second
+		}
+		s.update(nil, fmt.Errorf("%w (restarting in %s)", err, backoff))
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		if backoff *= 2; backoff > time.Minute {
+			backoff = time.Minute
+		}
+	}
+}
+
+// update takes the values in out, or logs err; failures are logged once
+// until the program works again
+func (s *commandSource) update(out []byte, err error) {
+	var values map[string]float64
+	if err == nil {
+		values, err = adapter.ParseValues(out)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		if !s.failing {
+			log.Printf("❌ Command %s: %v", s.cfg.Command[0], err)
+			s.failing = true
+		}
+		return
+	}
+	if s.failing {
+		log.Printf("✅ Command %s: working again", s.cfg.Command[0])
+		s.failing = false
+	}
+	if s.cfg.Stream && s.values != nil {
+		// A stream's line may carry only some of the values
+		for k, v := range values {
+			s.values[k] = v
+		}
+	} else {
+		s.values = values
+	}
+	s.at = time.Now()
+}
+
+// value returns the latest value of a field and whether it is current
+func (s *commandSource) value(field string, single bool) (v float64, current, found bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, found = s.values[field]
+	if !found && single && len(s.values) == 1 {
+		for _, only := range s.values {
+			v, found = only, true
+		}
+	}
+	return v, time.Since(s.at) <= s.maxAge, found
+}
+
+func (s *commandSource) release() {
+	s.mu.Lock()
+	s.refs--
+	last := s.refs == 0
+	s.mu.Unlock()
+	if last {
+		s.stop()
+		<-s.done
+	}
+}
+
+// commandSensor is one channel of a command's output. It never returns
+// an error, which the agent would log every sample: the source logs
+// failures once, and the channel repeats its last value as stale, or is
+// faulty if the output never had it.
+type commandSensor struct {
+	src    *commandSource
+	ch     CommandChannel
+	single bool // the command's only channel
+	closed bool
+}
+
+func (s *commandSensor) Name() string { return s.ch.Name }
+func (s *commandSensor) Unit() string { return s.ch.Unit }
+
+func (s *commandSensor) Metadata() sensor.Metadata { return s.ch.Metadata }
+
+func (s *commandSensor) Read(ctx context.Context) (float64, sensor.Quality, error) {
+	field := s.ch.Field
+	if field == "" {
+		field = s.ch.Name
+	}
+	v, current, found := s.src.value(field, s.single && s.ch.Field == "")
+	switch {
+	case !found || math.IsNaN(v):
+		return math.NaN(), sensor.Fault, nil
+	case s.ch.Scale != 0:
+		v *= s.ch.Scale
+	}
+	if !current {
+		return v, sensor.Stale, nil
+	}
+	return v, sensor.OK, nil
+}
+
+func (s *commandSensor) Close() error {
+	if !s.closed {
+		s.closed = true
+		s.src.release()
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+package agent
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"riscv-dev/pkg/audit"
+	"riscv-dev/pkg/auth"
+	"riscv-dev/pkg/config"
+	"riscv-dev/pkg/display"
+	"riscv-dev/pkg/election"
+	"riscv-dev/pkg/hal"
+	"riscv-dev/pkg/namespace"
+	"riscv-dev/pkg/netwait"
+	"riscv-dev/pkg/schedule"
+	"riscv-dev/pkg/sensor"
+	"riscv-dev/pkg/tlsconfig"
+)
+
+// ChannelConfig maps an ADC channel to a physical quantity:
+// value = (raw - offset) / scale, unless the calibration file has a curve
+// for the channel
+type ChannelConfig struct {
+	Name    string  `json:"name"`
+	Channel int     `json:"channel"`
+	Unit    string  `json:"unit"`
+	Offset  float64 `json:"offset"`
+	Scale   float64 `json:"scale"` // 0 is treated as 1
+	sensor.Range
+	sensor.Metadata
+}
+
+// DerivedConfig defines a virtual channel computed from another channel
+// each sample: its rate of change (kind "derivative") or running total
+// (kind "integral")
+type DerivedConfig struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+	Kind   string `json:"kind"`
+	Unit   string `json:"unit"`
+	// Per is the time unit of a rate (value per Per) or an integral
+	// (value × Per), e.g. "1h" for lux-hours; default 1s
+	Per config.Duration `json:"per,omitempty"`
+	// Above makes an integral count only the excess over a threshold,
+	// e.g. degree-minutes above 30
+	Above *float64 `json:"above,omitempty"`
+	// MaxGap is the longest interval between source samples that is
+	// still differentiated or integrated; default 3 sample intervals
+	MaxGap config.Duration `json:"max_gap,omitempty"`
+	sensor.Range
+}
+
+// Config holds the agent settings, usually loaded from config.json
+type Config struct {
+	ADC      hal.ADCConfig   `json:"adc"`
+	Channels []ChannelConfig `json:"channels"`
+	// Pulses counts edges on GPIO inputs and Frequencies measures signals
+	// on them, using the GPIO backend configured in GPIO (auto-selected if
+	// unset)
+	Pulses      []PulseConfig     `json:"pulses,omitempty"`
+	Frequencies []FrequencyConfig `json:"frequencies,omitempty"`
+	GPIO        *hal.GPIOConfig   `json:"gpio,omitempty"`
+	// Straps reads DIP switches on GPIO inputs as configuration, merged
+	// into the rest by ApplyStraps
+	Straps *StrapsConfig `json:"straps,omitempty"`
+	// Sound measures sound levels from audio capture devices
+	Sound []SoundConfig `json:"sound,omitempty"`
+	// Drivers are out-of-tree sensor drivers, Go plugins or subprocess
+	// adapters, each providing channels of its own
+	Drivers []DriverConfig `json:"drivers,omitempty"`
+	// Commands read channels from the output of external programs
+	Commands []CommandConfig `json:"commands,omitempty"`
+	// Runtime are virtual channels tracking the agent's own goroutines,
+	// memory and file descriptors, added before derived channels
+	Runtime []RuntimeConfig `json:"runtime,omitempty"`
+	// Derived are virtual channels computed from the channels, sampled
+	// after them
+	Derived []DerivedConfig `json:"derived,omitempty"`
+	// Forecasts raise alerts on where a channel's trend is heading, before
+	// it gets there
+	Forecasts []ForecastConfig `json:"forecasts,omitempty"`
+	// Percentiles track p50, p95 and p99 of channels over sliding windows
+	// for GetStats, /stats and Prometheus
+	Percentiles []PercentileConfig `json:"percentiles,omitempty"`
+	// Schedules vary channel ranges, and so their alerts, by time of day
+	Schedules []ThresholdSchedule `json:"threshold_schedules,omitempty"`
+	// Coordinates locate the board for schedules that follow the sun,
+	// such as "sunset-sunrise"
+	Coordinates *schedule.Coordinates `json:"coordinates,omitempty"`
+	// Actuators are GPIO outputs switched by channel thresholds, which
+	// operators can override for a while or hold in holiday mode
+	Actuators []ActuatorConfig `json:"actuators,omitempty"`
+	// Signals are GPIO outputs, such as sirens, strobes and relays, driven
+	// by channel alerts
+	Signals []SignalConfig `json:"signals,omitempty"`
+	// Notifications send alerts by email or SMS
+	Notifications []NotifyConfig `json:"notifications,omitempty"`
+	// CalibrationFile holds multi-point calibration curves by channel
+	// name (see sensor.CalibrationFile), written by riscv-dev calibrate
+	CalibrationFile string `json:"calibration_file,omitempty"`
+	// Carrier is the EEPROM of the sensor carrier board, holding its
+	// identity and calibrations written by riscv-dev eeprom; the
+	// calibration file overrides them channel by channel
+	Carrier         *hal.EEPROMConfig `json:"carrier_eeprom,omitempty"`
+	SampleInterval  config.Duration   `json:"sample_interval"`
+	HistoryDuration config.Duration   `json:"history_duration"` // kept per channel for statistics
+	HistoryDir      string            `json:"history_dir"`      // empty keeps history in memory only
+	MetricsAddr     string            `json:"metrics_addr"`     // serves /metrics, /healthz, /channels and /history; empty disables
+	Sinks           []SinkConfig      `json:"sinks"`
+	// DataDir holds everything the agent writes, for boards whose root
+	// filesystem is read-only: relative paths (history, state, audit,
+	// calibration, disk sinks) are taken from it, history and state
+	// default into it, and it keeps the device identity. It is created
+	// and checked for writes at startup.
+	DataDir string `json:"data_dir,omitempty"`
+	// StateFile keeps counters across restarts: samples taken, starts,
+	// running time and pulse totals
+	StateFile string `json:"state_file,omitempty"`
+	// Snapshot keeps alert states, forecasts, integrals and actuator
+	// state across restarts, so a power-cycled board carries on
+	Snapshot *SnapshotConfig `json:"snapshot,omitempty"`
+	// Storage watches the free space of the filesystem holding history
+	// and disk sinks, compacting them and keeping only rollups as it fills
+	Storage *StorageConfig `json:"storage,omitempty"`
+	// Memory sets the runtime's memory limit and, as the agent nears it,
+	// shrinks histories and keeps only rollups
+	Memory *MemoryConfig `json:"memory,omitempty"`
+	// DiskHealth raises alerts on eMMC wear, filesystem errors and
+	// filesystems remounted read-only
+	DiskHealth *DiskHealthConfig `json:"disk_health,omitempty"`
+	// Thermal samples less often and pauses sound capture while the CPU
+	// is throttled to keep cool, raising thermal events
+	Thermal *ThermalConfig `json:"thermal,omitempty"`
+	// KernelLog raises hardware errors from the kernel log, such as I2C
+	// timeouts, thermal trips and under-voltage, as kernel events
+	KernelLog *KernelLogConfig `json:"kernel_log,omitempty"`
+
+	// Display sets the locale and units values are shown in on the
+	// console, displays and in the display field of /channels
+	Display *display.Config `json:"display,omitempty"`
+
+	// Namespace places the device in a site/building/room/device
+	// hierarchy, applied to metric labels, sink topics and readings so
+	// several fleets can share infrastructure
+	Namespace *namespace.Namespace `json:"namespace,omitempty"`
+
+	// Offline holds network sinks back, buffering readings, until
+	// SetOnline is called, so the agent starts without the network
+	Offline bool `json:"offline"`
+	// NetworkWait, if set, holds network sinks back after startup until
+	// the network is online or its timeout passes
+	NetworkWait *netwait.Config `json:"network_wait,omitempty"`
+	// SinkStartWait bounds how long New waits for the sinks, which set up
+	// side by side. One still setting up, such as a sink connecting to a
+	// server it can't reach, carries on in the background, held like a
+	// network sink, and takes readings once ready. Default 500ms.
+	SinkStartWait config.Duration `json:"sink_start_wait,omitempty"`
+	// Connectivity fails network sinks over between interfaces, such as
+	// Ethernet, Wi-Fi and a cellular modem, holding them back while none
+	// works
+	Connectivity *ConnectivityConfig `json:"connectivity,omitempty"`
+	// Probes measure the round-trip time and loss of network paths as
+	// channels
+	Probes []ProbeConfig `json:"probes,omitempty"`
+	// Clock estimates the board's clock offset and drift from the times
+	// of the servers network sinks talk to
+	Clock *ClockConfig `json:"clock,omitempty"`
+	// Election makes this agent one of a redundant group of boards, of
+	// which only the elected leader feeds network sinks
+	Election *election.Config `json:"election,omitempty"`
+	// Provisioning offers the configuration for replacing over a USB
+	// gadget serial port
+	Provisioning *ProvisioningConfig `json:"provisioning,omitempty"`
+	// StaticHosts maps host names used by network sinks to addresses, so
+	// no DNS server is needed
+	StaticHosts map[string]string `json:"static_hosts,omitempty"`
+
+	// TLS is shared by every network sink unless a sink overrides it
+	TLS *tlsconfig.Config `json:"tls,omitempty"`
+	// MetricsTLS serves /metrics and /healthz over HTTPS; with a ca_file,
+	// scrapers must present a client certificate
+	MetricsTLS *tlsconfig.Config `json:"metrics_tls,omitempty"`
+	// TrustedProxies lists reverse proxies (addresses or CIDRs, comma
+	// separated) whose X-Forwarded-For header is believed by the HTTP
+	// endpoints
+	TrustedProxies string `json:"trusted_proxies,omitempty"`
+	// Auth requires credentials for /metrics and /healthz
+	Auth *auth.Config `json:"auth,omitempty"`
+	// Audit records configuration and output changes to an append-only
+	// log, served on /audit to admins
+	Audit *audit.Config `json:"audit,omitempty"`
+	// Crash reports panics and fatal errors after the agent is restarted
+	Crash *CrashConfig `json:"crash,omitempty"`
+}
+
+// DefaultConfig returns the settings used for anything config.json omits
+func DefaultConfig() Config {
+	return Config{
+		ADC:             hal.ADCConfig{Driver: hal.Auto},
+		SampleInterval:  config.Duration(time.Second),
+		HistoryDuration: config.Duration(10 * time.Minute),
+	}
+}
+
+// historySize returns the number of samples covering HistoryDuration
+func (c Config) historySize() int {
+	if c.SampleInterval <= 0 {
+		return 1
+	}
+	return int(c.HistoryDuration / c.SampleInterval)
+}
+
+// KernelFeatures returns the kernel features (see pkg/kmod) the
+// configured hardware needs. Auto-selected backends need none in
+// particular, as any that is present will do.
+func (c Config) KernelFeatures() []string {
+	need := make(map[string]bool)
+	switch c.ADC.Driver {
+	case "iio":
+		need["iio"] = true
+	case "ads1115":
+		need["i2c"] = true
+	}
+	if len(c.Pulses) > 0 || len(c.Frequencies) > 0 {
+		driver := hal.Auto
+		if c.GPIO != nil {
+			driver = c.GPIO.Driver
+		}
+		if driver == "gpiochip" {
+			need["gpio"] = true
+		}
+	}
+	if c.Straps != nil {
+		gc := c.Straps.GPIO
+		if gc == nil {
+			gc = c.GPIO
+		}
+		if gc != nil && gc.Driver == "gpiochip" {
+			need["gpio"] = true
+		}
+	}
+	if c.Carrier != nil && (c.Carrier.Device == "" || strings.HasPrefix(filepath.Base(c.Carrier.Device), "i2c-")) {
+		need["i2c"] = true
+	}
+	if len(c.Sound) > 0 {
+		need["sound"] = true
+	}
+	features := make([]string, 0, len(need))
+	for f := range need {
+		features = append(features, f)
+	}
+	sort.Strings(features)
+	return features
+}
@@ -0,0 +1,274 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"syscall"
+	"time"
+
+	"riscv-dev/pkg/config"
+	"riscv-dev/pkg/metrics"
+	"riscv-dev/pkg/modem"
+	"riscv-dev/pkg/sensor"
+	"riscv-dev/pkg/uplink"
+)
+
+// ConnectivityConfig keeps network sinks on the best working uplink, e.g.
+// Ethernet, then Wi-Fi, then a USB LTE modem brought up only while neither
+// works (see package uplink). Network sinks are held back while no
+// interface works, as if offline.
+//
+//	"connectivity": {
+//	  "interfaces": [
+//	    {"name": "eth0"},
+//	    {"name": "wlan0"},
+//	    {"name": "ppp0", "link": {"ppp_peer": "lte"}}
+//	  ],
+//	  "probe": "ingest.example.com:443",
+//	  "modem": {"port": "/dev/ttyUSB2"}
+//	}
+type ConnectivityConfig struct {
+	uplink.Config
+	// Modem is the cellular modem's AT port, read for its signal quality
+	// as a channel and for its network registration, which is logged
+	Modem *modem.Config `json:"modem,omitempty"`
+	// SignalChannel names the modem's signal channel, in dBm; default
+	// "cellular_signal"
+	SignalChannel string `json:"signal_channel,omitempty"`
+	// SignalInterval is how often the modem is asked; default 30s
+	SignalInterval config.Duration `json:"signal_interval,omitempty"`
+	SignalRange    sensor.Range    `json:"signal_range,omitempty"`
+}
+
+const defaultSignalInterval = 30 * time.Second
+
+var uplinkInUse = metrics.NewGauge("agent_uplink_in_use", "Whether network traffic uses an interface", "interface")
+
+// UplinkEvent reports traffic moving between interfaces, for the agent's
+// uplink or a sink with interfaces of its own
+type UplinkEvent struct {
+	Time time.Time `json:"time"`
+	Sink string    `json:"sink,omitempty"` // empty for the agent's uplink
+	From string    `json:"from"`           // empty for none
+	To   string    `json:"to"`             // empty for none
+	// Reason is why From stopped working, empty when failing back
+	Reason string `json:"reason,omitempty"`
+}
+
+// addConnectivity sets up the uplink manager, which starts with Run, and
+// the modem's signal channel
+func (a *Agent) addConnectivity(cfg Config) error {
+	c := cfg.Connectivity
+	if c == nil {
+		return nil
+	}
+	if cfg.NetworkWait != nil {
+		return errors.New("connectivity: network_wait and connectivity both decide when sinks go online; set one")
+	}
+	m, err := uplink.New(c.Config, a.uplinkChanged)
+	if err != nil {
+		return fmt.Errorf("connectivity: %w", err)
+	}
+	a.uplink = m
+	a.health.Register("uplink", func(ctx context.Context) error {
+		if m.Current() != "" {
+			return nil
+		}
+		var errs []error
+		for _, st := range m.Status() {
+			errs = append(errs, fmt.Errorf("%s: %w", st.Name, st.Err))
+		}
+		return errors.Join(errs...)
+	})
+	if c.Modem == nil {
+		return nil
+	}
+	dev, err := modem.New(*c.Modem)
+	if err != nil {
+		return fmt.Errorf("connectivity: %w", err)
+	}
+	s := newCellularSensor(dev, *c)
+	if err := a.AddSensor(s, c.SignalRange); err != nil {
+		s.Close()
+		return fmt.Errorf("connectivity: %w", err)
+	}
+	return nil
+}
+
+// dialControl returns the socket control binding a sink's connections to
+// the first working of interfaces, or to the uplink in use; nil without
+// connectivity
+func (a *Agent) dialControl(sink string, interfaces []string) (func(network, address string, c syscall.RawConn) error, error) {
+	if len(interfaces) == 0 {
+		if a.uplink == nil {
+			return nil, nil
+		}
+		return a.uplink.Control, nil
+	}
+	if a.uplink == nil {
+		return nil, errors.New("interfaces need connectivity")
+	}
+	r, err := a.uplink.Route(sink, interfaces)
+	if err != nil {
+		return nil, err
+	}
+	if a.routes == nil {
+		a.routes = make(map[string]*uplink.Route)
+	}
+	a.routes[sink] = r
+	return r.Control, nil
+}
+
+// uplinkChanged follows traffic moving to another interface: network
+// sinks are held back while the uplink has none, and sinks whose
+// interface changed reconnect
+func (a *Agent) uplinkChanged(c uplink.Change) {
+	e := UplinkEvent{Time: time.Now(), Sink: c.Route, From: c.From, To: c.To}
+	if c.Err != nil {
+		e.Reason = c.Err.Error()
+	}
+	if c.From != "" || c.To != "" {
+		a.events.publish(Event{Type: EventUplink, Uplink: &e})
+	}
+	if c.Route == "" {
+		for _, ic := range a.cfg.Connectivity.Interfaces {
+			v := 0.0
+			if ic.Name == c.To {
+				v = 1
+			}
+			uplinkInUse.Set(v, ic.Name)
+		}
+		if !a.cfg.Offline {
+			a.SetOnline(c.To != "")
+		}
+	}
+
+	a.mu.Lock()
+	sinks := a.sinks
+	a.mu.Unlock()
+	for _, w := range sinks {
+		r, ok := w.sink.(Reconnector)
+		if !ok {
+			continue
+		}
+		iface := a.uplink.Current()
+		if route := a.routes[w.name]; route != nil && route.Interface() != "" {
+			iface = route.Interface()
+		}
+		if iface != w.iface {
+			w.iface = iface
+			r.Reconnect()
+		}
+	}
+}
+
+// cellularSensor reports a modem's signal strength, which it asks for in
+// the background since a modem can take seconds to answer. Like a
+// command's channels, it repeats its last value as stale while the modem
+// doesn't answer, and logs failures once.
+type cellularSensor struct {
+	name     string
+	dev      *modem.Device
+	interval time.Duration
+	stop     context.CancelFunc
+	done     chan struct{}
+
+	mu      sync.Mutex
+	status  modem.Status
+	at      time.Time // of status
+	failing bool
+}
+
+func newCellularSensor(dev *modem.Device, cfg ConnectivityConfig) *cellularSensor {
+	s := &cellularSensor{name: cfg.SignalChannel, dev: dev, interval: cfg.SignalInterval.D(), done: make(chan struct{})}
+	if s.name == "" {
+		s.name = "cellular_signal"
+	}
+	if s.interval <= 0 {
+		s.interval = defaultSignalInterval
+	}
+	s.status.Signal = math.NaN()
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stop = cancel
+	go s.poll(ctx)
+	return s
+}
+
+func (s *cellularSensor) poll(ctx context.Context) {
+	defer close(s.done)
+	t := time.NewTicker(s.interval)
+	defer t.Stop()
+	for {
+		qctx, cancel := context.WithTimeout(ctx, s.interval)
+		st, err := s.dev.Status(qctx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		s.update(st, err)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// update keeps st, logging changes of network, or logs err
+func (s *cellularSensor) update(st modem.Status, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		if !s.failing {
+			log.Printf("❌ Cellular: %v", err)
+			s.failing = true
+		}
+		return
+	}
+	if s.failing {
+		log.Printf("✅ Cellular: modem %s answering again", s.dev.Port())
+		s.failing = false
+	}
+	switch old := s.status; {
+	case st.Registered() && (!old.Registered() || st.Operator != old.Operator):
+		log.Printf("📶 Cellular: %s on %s (%s, %s)", st.Registration, st.Operator, st.Access, formatDBm(st.Signal))
+	case !st.Registered() && (old.Registered() || old.Registration == ""):
+		log.Printf("⚠️  Cellular: not on a network (%s)", st.Registration)
+	}
+	s.status, s.at = st, time.Now()
+}
+
+func formatDBm(v float64) string {
+	if math.IsNaN(v) {
+		return "signal unknown"
+	}
+	return fmt.Sprintf("%.0f dBm", v)
+}
+
+func (s *cellularSensor) Name() string { return s.name }
+func (s *cellularSensor) Unit() string { return "dBm" }
+
+func (s *cellularSensor) Read(ctx context.Context) (float64, sensor.Quality, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if math.IsNaN(s.status.Signal) {
+		return math.NaN(), sensor.Fault, nil
+	}
+	if time.Since(s.at) > 3*s.interval {
+		return s.status.Signal, sensor.Stale, nil
+	}
+	return s.status.Signal, sensor.OK, nil
+}
+
+func (s *cellularSensor) Close() error {
+	if s.stop != nil {
+		s.stop()
+		<-s.done
+		s.stop = nil
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"riscv-dev/pkg/crash"
+	"riscv-dev/pkg/metrics"
+	"riscv-dev/pkg/tlsconfig"
+)
+
+// CrashConfig reports the agent's panics and fatal errors once it has been
+// restarted (see package crash), with the stacks of every goroutine, the
+// tail of the log and the state of the board before the crash
+//
+//	"crash": {"url": "https://hub.example.com/api/crashes", "headers": {"Authorization": "Bearer ..."}}
+type CrashConfig struct {
+	// Dir defaults to "crash" under data_dir
+	crash.Config
+	// TLS overrides fields of the agent's shared tls block for URL
+	TLS *tlsconfig.Config `json:"tls,omitempty"`
+}
+
+// crashRetry is how long a failed upload waits to be tried again
+const crashRetry = 5 * time.Minute
+
+var crashReportsPending = metrics.NewGauge("agent_crash_reports_pending", "Crash reports waiting to be uploaded")
+
+// addCrash starts capturing crashes, queueing a report of the last run's,
+// and keeps the tail of the log for them
+func (a *Agent) addCrash(cfg Config) error {
+	c := cfg.Crash
+	if c == nil {
+		return nil
+	}
+	if c.Dir == "" {
+		return errors.New("crash: dir is required without data_dir")
+	}
+	r, err := crash.Start(c.Config, a.deviceID)
+	if err != nil {
+		return err
+	}
+	a.crash = r
+	a.logOut = log.Writer()
+	log.SetOutput(io.MultiWriter(a.logOut, r.LogWriter()))
+	crashReportsPending.Set(float64(len(r.Pending())))
+	return nil
+}
+
+// uploadCrashes sends the queued crash reports once online, retrying
+// failures, until none are left
+func (a *Agent) uploadCrashes(ctx context.Context) {
+	c := a.cfg.Crash
+	tlsCfg, err := c.TLS.Merge(a.cfg.TLS).Client(c.URL)
+	if err != nil {
+		log.Printf("❌ Crash: %v", err)
+		return
+	}
+	control, _ := a.dialControl("", nil)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsCfg
+	transport.DialContext = SinkConfig{StaticHosts: a.cfg.StaticHosts, Control: control}.Dial()
+	client := &http.Client{Transport: transport, Timeout: time.Minute}
+	for len(a.crash.Pending()) > 0 {
+		select {
+		case <-a.online.wait():
+		case <-ctx.Done():
+			return
+		}
+		n, err := a.crash.Upload(ctx, client)
+		crashReportsPending.Set(float64(len(a.crash.Pending())))
+		if n > 0 {
+			log.Printf("✅ Crash: %d reports uploaded", n)
+		}
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+		log.Printf("⚠️  Crash: uploading reports: %v; retrying in %v", err, crashRetry)
+		select {
+		case <-time.After(crashRetry):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// closeCrash stops capturing crashes, as the agent is shutting down
+// cleanly, and restores the log's output
+func (a *Agent) closeCrash() error {
+	if a.crash == nil {
+		return nil
+	}
+	log.SetOutput(a.logOut)
+	return a.crash.Close()
+}
@@ -0,0 +1,187 @@
+package agent
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"riscv-dev/pkg/namespace"
+)
+
+// deviceIDFile names the file under data_dir holding the device identity
+const deviceIDFile = "device-id"
+
+// withDataDir places the files the agent writes under DataDir: relative
+// paths are taken from it, and history and state default into it
+func (c Config) withDataDir() Config {
+	if c.DataDir == "" {
+		return c
+	}
+	if c.HistoryDir == "" {
+		c.HistoryDir = "history"
+	}
+	if c.StateFile == "" {
+		c.StateFile = "state.json"
+	}
+	c.HistoryDir = c.dataPath(c.HistoryDir)
+	c.StateFile = c.dataPath(c.StateFile)
+	c.CalibrationFile = c.dataPath(c.CalibrationFile)
+	if c.Audit != nil {
+		ac := *c.Audit
+		ac.Path = c.dataPath(ac.Path)
+		c.Audit = &ac
+	}
+	if c.Snapshot != nil {
+		sc := *c.Snapshot
+		sc.Path = c.dataPath(sc.Path)
+		c.Snapshot = &sc
+	}
+	if c.Storage != nil {
+		sc := *c.Storage
+		sc.Path = c.dataPath(sc.Path)
+		c.Storage = &sc
+	}
+	if c.Crash != nil {
+		cc := *c.Crash
+		if cc.Dir == "" {
+			cc.Dir = "crash"
+		}
+		cc.Dir = c.dataPath(cc.Dir)
+		cc.RunDir = c.dataPath(cc.RunDir)
+		c.Crash = &cc
+	}
+	if c.Provisioning != nil {
+		pc := *c.Provisioning
+		pc.ConfigPath = c.dataPath(pc.ConfigPath)
+		c.Provisioning = &pc
+	}
+	sinks := make([]SinkConfig, len(c.Sinks))
+	for i, sc := range c.Sinks {
+		sc.DataDir = c.DataDir
+		sinks[i] = sc
+	}
+	c.Sinks = sinks
+	return c
+}
+
+// dataPath returns path under DataDir if it is relative
+func (c Config) dataPath(path string) string {
+	return underDir(c.DataDir, path)
+}
+
+func underDir(dir, path string) string {
+	if dir == "" || path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// verifyDataDir creates the data directory and proves it takes writes, and
+// checks that no file the agent writes was left on a read-only filesystem
+func (c Config) verifyDataDir() error {
+	if err := os.MkdirAll(c.DataDir, 0755); err != nil {
+		return fmt.Errorf("data_dir: %w", err)
+	}
+	f, err := os.CreateTemp(c.DataDir, ".probe-*")
+	if err != nil {
+		return fmt.Errorf("data_dir %s is not writable: %w", c.DataDir, err)
+	}
+	_, err = f.WriteString("probe\n")
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	os.Remove(f.Name())
+	if err != nil {
+		return fmt.Errorf("data_dir %s is not writable: %w", c.DataDir, err)
+	}
+
+	paths := [][2]string{{"history_dir", c.HistoryDir}, {"state_file", filepath.Dir(c.StateFile)}}
+	if c.Audit != nil {
+		paths = append(paths, [2]string{"audit", filepath.Dir(c.Audit.Path)})
+	}
+	for _, p := range paths {
+		if readOnly(p[1]) {
+			return fmt.Errorf("%s %s is on a read-only filesystem; move it under data_dir %s", p[0], p[1], c.DataDir)
+		}
+	}
+	if free, _, err := diskFree(c.DataDir); err == nil {
+		log.Printf("✅ Data directory %s writable, %d MiB free", c.DataDir, free>>20)
+	}
+	return nil
+}
+
+// readOnly reports whether path, or the nearest directory above it that
+// exists, is on a filesystem mounted read-only
+func readOnly(path string) bool {
+	for {
+		var st syscall.Statfs_t
+		err := syscall.Statfs(path, &st)
+		if err == nil {
+			return st.Flags&0x1 != 0 // ST_RDONLY
+		}
+		parent := filepath.Dir(path)
+		if !errors.Is(err, os.ErrNotExist) || parent == path {
+			return false
+		}
+		path = parent
+	}
+}
+
+// ResolveNamespace returns the namespace as the agent resolves it, the
+// zero Namespace if none is configured. With data_dir, an unset device is
+// the identity kept there, if it has been created.
+func (c Config) ResolveNamespace() (namespace.Namespace, error) {
+	if c.Namespace == nil {
+		return namespace.Namespace{}, nil
+	}
+	ns := *c.Namespace
+	if ns.Device == "" && c.DataDir != "" {
+		if b, err := os.ReadFile(filepath.Join(c.DataDir, deviceIDFile)); err == nil {
+			ns.Device = strings.TrimSpace(string(b))
+		}
+	}
+	return ns.Resolve()
+}
+
+// deviceID returns the identity kept in the data directory, creating it on
+// first start from the host name and a random suffix: boards booting the
+// same read-only image share a host name, but each has its own data
+// directory. Edit the file to choose a name.
+func (c Config) deviceID() (string, error) {
+	path := filepath.Join(c.DataDir, deviceIDFile)
+	b, err := os.ReadFile(path)
+	if err == nil && strings.TrimSpace(string(b)) != "" {
+		return strings.TrimSpace(string(b)), nil
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	suffix := make([]byte, 3)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	id := host + "-" + hex.EncodeToString(suffix)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(id+"\n"), 0644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	log.Printf("✅ Device identity %s saved in %s", id, path)
+	return id, nil
+}
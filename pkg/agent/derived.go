@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+
+	"riscv-dev/pkg/sensor"
+)
+
+// Derived channel kinds
+const (
+	DerivedDerivative = "derivative"
+	DerivedIntegral   = "integral"
+)
+
+// derivedSensor computes a virtual channel from the latest sample of its
+// source, which is sampled first
+type derivedSensor struct {
+	cfg    DerivedConfig
+	source *sensor.History
+	deriv  *sensor.Derivative // one of deriv and integ is set
+	integ  *sensor.Integral
+}
+
+func (s *derivedSensor) Name() string { return s.cfg.Name }
+func (s *derivedSensor) Unit() string { return s.cfg.Unit }
+
+func (s *derivedSensor) Read(ctx context.Context) (float64, sensor.Quality, error) {
+	sample, ok := s.source.Last()
+	if !ok {
+		return math.NaN(), sensor.Fault, nil
+	}
+	if s.integ != nil {
+		total, q := s.integ.Add(sample)
+		return total, q, nil
+	}
+	rate, q, ok := s.deriv.Add(sample)
+	if !ok {
+		return math.NaN(), sensor.Fault, nil
+	}
+	return rate, q, nil
+}
+
+// addDerived adds a derived channel after its source. An integral carries
+// on from the last total in its history, so with history_dir set it
+// survives restarts; the time the agent was down is not integrated.
+func (a *Agent) addDerived(dc DerivedConfig) error {
+	src := a.channel(dc.Source)
+	if src == nil {
+		return fmt.Errorf("derived channel %s: unknown source %q", dc.Name, dc.Source)
+	}
+	maxGap := dc.MaxGap.D()
+	if maxGap <= 0 {
+		maxGap = 3 * a.cfg.SampleInterval.D()
+	}
+	s := &derivedSensor{cfg: dc, source: src.history}
+	switch dc.Kind {
+	case DerivedDerivative:
+		if dc.Above != nil {
+			return fmt.Errorf("derived channel %s: above applies to integrals only", dc.Name)
+		}
+		s.deriv = &sensor.Derivative{Per: dc.Per.D(), MaxGap: maxGap}
+	case DerivedIntegral:
+		s.integ = &sensor.Integral{Per: dc.Per.D(), MaxGap: maxGap, Above: dc.Above}
+	default:
+		return fmt.Errorf("derived channel %s: unknown kind %q (want %s or %s)", dc.Name, dc.Kind, DerivedDerivative, DerivedIntegral)
+	}
+	if err := a.AddSensor(s, dc.Range); err != nil {
+		return err
+	}
+	if s.integ != nil {
+		if last, ok := a.channel(dc.Name).history.Last(); ok && last.Quality.Usable() && !math.IsNaN(last.Value) {
+			s.integ.Total = last.Value
+			log.Printf("%s continues from %g %s", dc.Name, last.Value, dc.Unit)
+		}
+	}
+	return nil
+}
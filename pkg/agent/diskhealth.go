@@ -0,0 +1,218 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"riscv-dev/pkg/config"
+	"riscv-dev/pkg/diskhealth"
+	"riscv-dev/pkg/metrics"
+)
+
+// DiskHealthConfig watches the board's storage for wear and corruption
+type DiskHealthConfig struct {
+	// Interval is the time between checks, default 10m; a storage error
+	// in the kernel log (with kernel_log set) brings the next one forward
+	Interval config.Duration `json:"interval,omitempty"`
+	// LifeWarnPercent raises an alert once an eMMC estimates it has used
+	// this much of its life; default 80
+	LifeWarnPercent int `json:"life_warn_percent,omitempty"`
+}
+
+// DiskAlert reports storage trouble: a filesystem remounted read-only,
+// new filesystem errors, or an eMMC wearing out
+type DiskAlert struct {
+	Time     time.Time `json:"time"`
+	Kind     string    `json:"kind"` // read_only, fs_errors, wear or pre_eol
+	Device   string    `json:"device"`
+	Mount    string    `json:"mount,omitempty"`
+	Severity string    `json:"severity"` // warning or critical
+	Message  string    `json:"message"`
+}
+
+var (
+	diskLife     = metrics.NewGauge("agent_disk_life_used_percent", "eMMC estimate of its life used, by memory type (a, b)", "device", "type")
+	diskPreEOL   = metrics.NewGauge("agent_disk_pre_eol", "eMMC reserved blocks: 1 normal, 2 warning (80% used), 3 urgent", "device")
+	fsErrors     = metrics.NewGauge("agent_fs_errors", "Errors ext4 has recorded since the last fsck", "device", "mount")
+	fsReadOnly   = metrics.NewGauge("agent_fs_read_only", "Whether a filesystem is mounted read-only", "device", "mount")
+	diskAlerts   = metrics.NewCounter("agent_disk_alerts_total", "Storage health alerts raised", "kind")
+	preEOLValues = map[string]float64{"normal": 1, "warning": 2, "urgent": 3}
+)
+
+// diskWatch is the state of storage health checks
+type diskWatch struct {
+	cfg       DiskHealthConfig
+	nudge     chan struct{}      // a storage error was logged by the kernel
+	last      *diskhealth.Report // nil before the first check
+	startedRW map[string]bool    // mounts writable when first seen
+
+	// guarded by Agent.mu
+	report  diskhealth.Report
+	problem string // why the disk check fails, empty if it passes
+}
+
+func newDiskWatch(cfg DiskHealthConfig) *diskWatch {
+	if cfg.Interval <= 0 {
+		cfg.Interval = config.Duration(10 * time.Minute)
+	}
+	if cfg.LifeWarnPercent <= 0 {
+		cfg.LifeWarnPercent = 80
+	}
+	return &diskWatch{cfg: cfg, nudge: make(chan struct{}, 1), startedRW: make(map[string]bool)}
+}
+
+// nudgeDisk brings the next storage check forward
+func (a *Agent) nudgeDisk() {
+	if a.disk == nil {
+		return
+	}
+	select {
+	case a.disk.nudge <- struct{}{}:
+	default:
+	}
+}
+
+// watchDisk checks storage health every interval until ctx is cancelled
+func (a *Agent) watchDisk(ctx context.Context) {
+	d := a.disk
+	a.health.Register("disk", func(ctx context.Context) error {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if d.problem != "" {
+			return fmt.Errorf("%s", d.problem)
+		}
+		return nil
+	})
+	t := time.NewTicker(d.cfg.Interval.D())
+	defer t.Stop()
+	var lastErr string
+	for {
+		rep, err := diskhealth.Check("/")
+		if err != nil {
+			if err.Error() != lastErr {
+				log.Printf("⚠️  Disk health: %v", err)
+				lastErr = err.Error()
+			}
+		} else {
+			lastErr = ""
+			for _, alert := range a.checkDisk(rep) {
+				alert := alert
+				diskAlerts.Inc(alert.Kind)
+				icon := "⚠️ "
+				if alert.Severity == "critical" {
+					icon = "❌"
+				}
+				log.Printf("%s Disk %s: %s", icon, alert.Device, alert.Message)
+				a.events.publish(Event{Type: EventDisk, Disk: &alert})
+			}
+		}
+		select {
+		case <-t.C:
+		case <-d.nudge:
+			// Let the kernel finish what it logged, e.g. a remount
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// checkDisk updates the metrics and returns the alerts raised since the
+// last check. Wear and errors already present at the first check are
+// raised then; a filesystem already read-only is taken as meant to be.
+func (a *Agent) checkDisk(rep diskhealth.Report) []DiskAlert {
+	d := a.disk
+	now := time.Now()
+	var alerts []DiskAlert
+	raise := func(kind, device, mount, severity, format string, args ...any) {
+		alerts = append(alerts, DiskAlert{Time: now, Kind: kind, Device: device, Mount: mount, Severity: severity, Message: fmt.Sprintf(format, args...)})
+	}
+	var problems []string
+
+	prevDev := make(map[string]diskhealth.Device)
+	prevFS := make(map[string]diskhealth.Filesystem)
+	if d.last != nil {
+		for _, dev := range d.last.Devices {
+			prevDev[dev.Name] = dev
+		}
+		for _, fs := range d.last.Filesystems {
+			prevFS[fs.Mount] = fs
+		}
+	}
+	for _, dev := range rep.Devices {
+		old := prevDev[dev.Name]
+		if dev.LifeA > 0 || dev.LifeB > 0 {
+			diskLife.Set(float64(dev.LifeA), dev.Name, "a")
+			diskLife.Set(float64(dev.LifeB), dev.Name, "b")
+		}
+		if v, ok := preEOLValues[dev.PreEOL]; ok {
+			diskPreEOL.Set(v, dev.Name)
+		}
+		life, warn := dev.Life(), d.cfg.LifeWarnPercent
+		switch {
+		case life > 100 && old.Life() <= 100:
+			raise("wear", dev.Name, "", "critical", "eMMC past its rated life (%s); replace it", dev.Model)
+		case life >= warn && old.Life() < warn && life <= 100:
+			raise("wear", dev.Name, "", "warning", "eMMC estimates up to %d%% of its life used (%s)", life, dev.Model)
+		}
+		if dev.PreEOL != old.PreEOL && (dev.PreEOL == "warning" || dev.PreEOL == "urgent") {
+			severity := "warning"
+			if dev.PreEOL == "urgent" {
+				severity = "critical"
+			}
+			raise("pre_eol", dev.Name, "", severity, "eMMC reserved blocks %s (%s)", dev.PreEOL, dev.Model)
+		}
+		if life > 100 || dev.PreEOL == "urgent" {
+			problems = append(problems, dev.Name+" worn out")
+		}
+	}
+	for _, fs := range rep.Filesystems {
+		old, known := prevFS[fs.Mount]
+		ro := 0.0
+		if fs.ReadOnly {
+			ro = 1
+		}
+		fsReadOnly.Set(ro, fs.Device, fs.Mount)
+		if fs.Errors >= 0 {
+			fsErrors.Set(float64(fs.Errors), fs.Device, fs.Mount)
+		}
+		if known && fs.ReadOnly && !old.ReadOnly {
+			raise("read_only", fs.Device, fs.Mount, "critical", "%s remounted read-only, most likely after I/O errors", fs.Mount)
+		}
+		switch {
+		case !known && fs.Errors > 0:
+			raise("fs_errors", fs.Device, fs.Mount, "warning", "%s has %d errors recorded since the last fsck", fs.Mount, fs.Errors)
+		case known && fs.Errors > old.Errors:
+			raise("fs_errors", fs.Device, fs.Mount, "critical", "%s: %d new filesystem errors", fs.Mount, fs.Errors-old.Errors)
+		}
+		if !known && !fs.ReadOnly {
+			d.startedRW[fs.Mount] = true
+		}
+		if fs.ReadOnly && d.startedRW[fs.Mount] {
+			problems = append(problems, fs.Mount+" read-only")
+		}
+	}
+
+	d.last = &rep
+	a.mu.Lock()
+	d.report = rep
+	d.problem = strings.Join(problems, ", ")
+	a.mu.Unlock()
+	return alerts
+}
+
+// serveDisk serves the latest storage health report as JSON
+func (a *Agent) serveDisk(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	rep := a.disk.report
+	a.mu.Unlock()
+	writeJSON(w, rep)
+}
@@ -0,0 +1,207 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"riscv-dev/pkg/adapter"
+	"riscv-dev/pkg/sensor"
+)
+
+// DriverConfig loads an out-of-tree sensor driver, which provides one or
+// more channels: either a Go plugin, a subprocess adapter speaking JSON
+// on stdin and stdout (see package adapter), or a driver type compiled
+// into the program embedding the agent
+type DriverConfig struct {
+	// Type is a driver type registered with RegisterDriverType
+	Type string `json:"type,omitempty"`
+	// Plugin is a Go plugin (.so) exporting PluginSymbol. Plugins need an
+	// agent built with -tags plugins and cgo, and must themselves be built
+	// with the same tags, Go version and riscv-dev sources.
+	Plugin string `json:"plugin,omitempty"`
+	// Command runs a subprocess adapter, e.g. ["/opt/acme/scd41-driver", "-v"]
+	Command []string `json:"command,omitempty"`
+	// Options are passed to the driver as they are
+	Options json.RawMessage `json:"options,omitempty"`
+	// Ranges are the valid ranges of the driver's channels, by name
+	Ranges map[string]sensor.Range `json:"ranges,omitempty"`
+}
+
+// PluginSymbol is the function a driver plugin exports, of type
+// PluginFunc
+const PluginSymbol = "Sensors"
+
+// PluginFunc creates a plugin's sensors from its options. Sensors that
+// implement io.Closer are closed with the agent.
+type PluginFunc = func(options json.RawMessage) ([]Sensor, error)
+
+// name describes the driver in errors
+func (c DriverConfig) name() string {
+	if c.Type != "" {
+		return c.Type
+	}
+	if c.Plugin != "" {
+		return filepath.Base(c.Plugin)
+	}
+	if len(c.Command) > 0 {
+		return filepath.Base(c.Command[0])
+	}
+	return "driver"
+}
+
+// addDrivers loads the configured drivers and adds their channels
+func (a *Agent) addDrivers(cfg Config) error {
+	for _, dc := range cfg.Drivers {
+		var sensors []Sensor
+		var err error
+		switch {
+		case countSet(dc.Type != "", dc.Plugin != "", len(dc.Command) > 0) > 1:
+			err = fmt.Errorf("type, plugin and command are mutually exclusive")
+		case dc.Type != "":
+			sensors, err = newDriver(dc.Type, dc.Options)
+		case dc.Plugin != "":
+			sensors, err = loadPlugin(dc.Plugin, dc.Options)
+		case len(dc.Command) > 0:
+			sensors, err = startAdapter(dc.Command, dc.Options)
+		default:
+			err = fmt.Errorf("type, plugin or command is required")
+		}
+		if err != nil {
+			return fmt.Errorf("driver %s: %w", dc.name(), err)
+		}
+		for i, s := range sensors {
+			if err := a.AddSensor(s, dc.Ranges[s.Name()]); err != nil {
+				// The agent only closes the sensors it has
+				for _, s := range sensors[i:] {
+					closeSensor(s)
+				}
+				return fmt.Errorf("driver %s: %w", dc.name(), err)
+			}
+		}
+	}
+	return nil
+}
+
+var (
+	driverTypesMu sync.RWMutex
+	driverTypes   = make(map[string]PluginFunc)
+)
+
+// RegisterDriverType makes a driver compiled into the program available
+// to config.json as {"type": name, "options": {...}}, so programs
+// embedding the agent add sensors of their own that thresholds, derived
+// channels and the rest can refer to. Call it from init.
+func RegisterDriverType(name string, f PluginFunc) {
+	driverTypesMu.Lock()
+	defer driverTypesMu.Unlock()
+	driverTypes[name] = f
+}
+
+// DriverTypes returns the registered driver type names
+func DriverTypes() []string {
+	driverTypesMu.RLock()
+	defer driverTypesMu.RUnlock()
+	names := make([]string, 0, len(driverTypes))
+	for name := range driverTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newDriver(name string, options json.RawMessage) ([]Sensor, error) {
+	driverTypesMu.RLock()
+	f, ok := driverTypes[name]
+	driverTypesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown driver type %q (available: %v)", name, DriverTypes())
+	}
+	if len(options) == 0 {
+		options = json.RawMessage("{}") // so drivers can take their defaults
+	}
+	return f(options)
+}
+
+// countSet returns how many of set are true
+func countSet(set ...bool) int {
+	n := 0
+	for _, b := range set {
+		if b {
+			n++
+		}
+	}
+	return n
+}
+
+// closeSensor closes s if it needs closing
+func closeSensor(s Sensor) {
+	if c, ok := s.(interface{ Close() error }); ok {
+		c.Close()
+	}
+}
+
+// startAdapter runs a subprocess adapter, with a sensor per channel it
+// provides
+func startAdapter(command []string, options json.RawMessage) ([]Sensor, error) {
+	p, err := adapter.Start(command, options)
+	if err != nil {
+		return nil, err
+	}
+	shared := &adapterProcess{Process: p}
+	var sensors []Sensor
+	for _, ch := range p.Channels() {
+		sensors = append(sensors, &adapterSensor{proc: shared, ch: ch})
+	}
+	shared.refs = len(sensors)
+	return sensors, nil
+}
+
+// adapterProcess is a driver process shared by its channels' sensors,
+// stopped when the last of them is closed
+type adapterProcess struct {
+	*adapter.Process
+	mu   sync.Mutex
+	refs int
+}
+
+func (p *adapterProcess) release() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.refs--; p.refs == 0 {
+		return p.Process.Close()
+	}
+	return nil
+}
+
+// adapterSensor is one channel of a subprocess adapter
+type adapterSensor struct {
+	proc   *adapterProcess
+	ch     adapter.Channel
+	closed bool
+}
+
+func (s *adapterSensor) Name() string { return s.ch.Name }
+func (s *adapterSensor) Unit() string { return s.ch.Unit }
+
+func (s *adapterSensor) Metadata() sensor.Metadata {
+	if s.ch.Meta == nil {
+		return sensor.Metadata{}
+	}
+	return *s.ch.Meta
+}
+
+func (s *adapterSensor) Read(ctx context.Context) (float64, sensor.Quality, error) {
+	return s.proc.Read(ctx, s.ch.Name)
+}
+
+func (s *adapterSensor) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.proc.release()
+}
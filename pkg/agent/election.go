@@ -0,0 +1,82 @@
+package agent
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"riscv-dev/pkg/audit"
+	"riscv-dev/pkg/election"
+	"riscv-dev/pkg/metrics"
+)
+
+var leaderGauge = metrics.NewGauge("agent_leader", "1 while this agent leads its redundant group or runs alone, 0 on standby")
+
+// startElection joins the election, if configured. Until elected the agent
+// stands by: it samples, keeps history and feeds local sinks, but network
+// sinks get nothing, as the leader publishes.
+func (a *Agent) startElection(ctx context.Context) error {
+	if a.cfg.Election == nil {
+		leaderGauge.Set(1)
+		return nil
+	}
+	cfg := *a.cfg.Election
+	boot, err := a.electionBoot()
+	if err != nil {
+		return err
+	}
+	cfg.Boot = boot
+	e, err := election.New(cfg, a.setLeader)
+	if err != nil {
+		return err
+	}
+	a.leading.Store(false)
+	leaderGauge.Set(0)
+	log.Printf("Standing by as %s until elected", e.ID())
+	go e.Run(ctx)
+	return nil
+}
+
+// electionBoot numbers this start for the election: its time, or with a
+// state file one more than the last start's if the clock has stepped back
+// since, as on boards without a battery-backed clock. 0 leaves it to the
+// election.
+func (a *Agent) electionBoot() (uint64, error) {
+	if a.state == nil {
+		return 0, nil
+	}
+	last := a.state.Counter(StateElectionBoot)
+	boot := max(uint64(time.Now().UnixNano()), last+1)
+	if _, err := a.state.Add(StateElectionBoot, boot-last); err != nil {
+		return 0, err
+	}
+	// Saved before any heartbeat goes out under it
+	return boot, a.state.Flush()
+}
+
+func (a *Agent) setLeader(leader bool) {
+	old := a.leading.Swap(leader)
+	if leader {
+		leaderGauge.Set(1)
+	} else {
+		leaderGauge.Set(0)
+	}
+	if old == leader {
+		return
+	}
+	a.audit.Record(context.Background(), audit.Entry{
+		Action: "agent.leader", Target: "election",
+		Old: strconv.FormatBool(old), New: strconv.FormatBool(leader),
+	})
+	if leader {
+		log.Printf("✅ Elected leader: publishing to network sinks")
+	} else {
+		log.Printf("➖ Standing by: network sinks paused")
+	}
+}
+
+// Leader reports whether this agent publishes and may actuate: always
+// without an election, otherwise while it leads. Programs embedding the
+// agent check it before driving outputs.
+func (a *Agent) Leader() bool { return a.leading.Load() }
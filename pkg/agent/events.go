@@ -0,0 +1,229 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"riscv-dev/pkg/metrics"
+	"riscv-dev/pkg/sensor"
+)
+
+// Event types
+const (
+	EventReading  = "reading"
+	EventAlert    = "alert"
+	EventKernel   = "kernel"
+	EventForecast = "forecast"
+	EventDisk     = "disk"
+	EventUplink   = "uplink"
+	EventThermal  = "thermal"
+)
+
+var eventTypes = []string{EventReading, EventAlert, EventKernel, EventForecast, EventDisk, EventUplink, EventThermal}
+
+// Event is a reading, an alert, a kernel event, a forecast alert, a
+// storage health alert, an uplink change or a thermal change, as streamed
+// on /events
+type Event struct {
+	ID       uint64         `json:"id"`
+	Type     string         `json:"type"`
+	Reading  *Reading       `json:"reading,omitempty"`
+	Alert    *Alert         `json:"alert,omitempty"`
+	Kernel   *KernelEvent   `json:"kernel,omitempty"`
+	Forecast *ForecastAlert `json:"forecast,omitempty"`
+	Disk     *DiskAlert     `json:"disk,omitempty"`
+	Uplink   *UplinkEvent   `json:"uplink,omitempty"`
+	Thermal  *ThermalEvent  `json:"thermal,omitempty"`
+}
+
+// Alert reports a channel whose quality changed, e.g. going out of range,
+// failing or recovering
+type Alert struct {
+	Time     time.Time      `json:"time"`
+	Channel  string         `json:"channel"`
+	Quality  sensor.Quality `json:"quality"`
+	Previous sensor.Quality `json:"previous"`
+	Value    float64        `json:"value"` // NaN (null in JSON) for faults
+}
+
+// MarshalJSON encodes a NaN value as null
+func (a Alert) MarshalJSON() ([]byte, error) {
+	type plain Alert
+	var value *float64
+	if v := a.Value; !math.IsNaN(v) {
+		value = &v
+	}
+	return json.Marshal(struct {
+		plain
+		Value *float64 `json:"value"`
+	}{plain(a), value})
+}
+
+var eventsDropped = metrics.NewCounter("agent_events_dropped_total", "Events not delivered to a subscriber that fell behind")
+
+// eventHub fans events out to subscribers. Publishing never blocks: a
+// subscriber whose buffer is full misses the event.
+type eventHub struct {
+	mu     sync.Mutex
+	subs   map[chan Event]struct{}
+	nextID atomic.Uint64
+}
+
+func (h *eventHub) publish(e Event) {
+	e.ID = h.nextID.Add(1)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- e:
+		default:
+			eventsDropped.Inc()
+		}
+	}
+}
+
+// Subscribe returns a channel receiving every event from now on, buffering up to buffer events, and a function ending the
+// subscription. Events that don't fit in the buffer are dropped.
+func (a *Agent) Subscribe(buffer int) (<-chan Event, func()) {
+	h := &a.events
+	ch := make(chan Event, buffer)
+	h.mu.Lock()
+	if h.subs == nil {
+		h.subs = make(map[chan Event]struct{})
+	}
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			delete(h.subs, ch)
+			h.mu.Unlock()
+		})
+	}
+}
+
+// sseHeartbeat keeps idle streams from being closed by proxies
+const sseHeartbeat = 15 * time.Second
+
+// serveEvents streams events as server-sent events, each named by its type
+// with its ID and the reading or alert as JSON data:
+//
+//	curl -N 'http://board:9100/events?types=alert'
+//
+// types (reading, alert, kernel, forecast, disk, uplink, or all by
+// default) and channel (repeatable) filter the stream; kernel, disk and
+// uplink events belong to no channel.
+func (a *Agent) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	q := r.URL.Query()
+	types := make(map[string]bool, len(eventTypes))
+	for _, name := range eventTypes {
+		types[name] = true
+	}
+	if t := q.Get("types"); t != "" {
+		types = make(map[string]bool)
+		for _, name := range strings.Split(t, ",") {
+			if !contains(eventTypes, name) {
+				http.Error(w, fmt.Sprintf("unknown event type %q (want %s)", name, strings.Join(eventTypes, ", ")), http.StatusBadRequest)
+				return
+			}
+			types[name] = true
+		}
+	}
+	channels := q["channel"]
+
+	events, cancel := a.Subscribe(64)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx
+	fmt.Fprint(w, "retry: 3000\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case e := <-events:
+			if !types[e.Type] {
+				continue
+			}
+			var data any
+			switch {
+			case e.Alert != nil:
+				if len(channels) > 0 && !contains(channels, e.Alert.Channel) {
+					continue
+				}
+				data = e.Alert
+			case e.Reading != nil:
+				reading := *e.Reading
+				if len(channels) > 0 {
+					reading.Channels = nil
+					for _, c := range e.Reading.Channels {
+						if contains(channels, c.Name) {
+							reading.Channels = append(reading.Channels, c)
+						}
+					}
+				}
+				data = reading
+			case e.Forecast != nil:
+				if len(channels) > 0 && !contains(channels, e.Forecast.Channel) {
+					continue
+				}
+				data = e.Forecast
+			case e.Kernel != nil:
+				if len(channels) > 0 {
+					continue
+				}
+				data = e.Kernel
+			case e.Disk != nil:
+				if len(channels) > 0 {
+					continue
+				}
+				data = e.Disk
+			case e.Uplink != nil:
+				if len(channels) > 0 {
+					continue
+				}
+				data = e.Uplink
+			case e.Thermal != nil:
+				if len(channels) > 0 {
+					continue
+				}
+				data = e.Thermal
+			}
+			b, err := json.Marshal(data)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, b)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
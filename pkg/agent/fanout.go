@@ -0,0 +1,486 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"riscv-dev/pkg/metrics"
+	"riscv-dev/pkg/sensor"
+)
+
+// SinkOptions controls how readings are queued and retried for one sink
+type SinkOptions struct {
+	QueueSize  int           // readings buffered while the sink is slow; oldest dropped first
+	Retries    int           // retries of a failed write before the reading is dropped
+	Backoff    time.Duration // wait before the first retry, doubled each time
+	MaxBackoff time.Duration // upper bound for the wait
+	Timeout    time.Duration // limit for a single write
+	// Rollup summarises readings that don't fit in the queue instead of
+	// dropping them: the oldest are folded into one reading of per-channel
+	// mean, min and max, delivered ahead of the queue
+	Rollup bool
+	// DiskQuota bounds the local storage used by a DiskSink; 0 for no
+	// limit but the free space
+	DiskQuota int64
+	// Budget caps the bytes the sink moves each day, as counted by Meter
+	Budget *BudgetConfig
+	Meter  *ByteMeter
+}
+
+// DefaultSinkOptions buffer about a minute of readings at 1s sampling
+var DefaultSinkOptions = SinkOptions{
+	QueueSize:  64,
+	Retries:    5,
+	Backoff:    500 * time.Millisecond,
+	MaxBackoff: 30 * time.Second,
+	Timeout:    10 * time.Second,
+}
+
+// SinkStatus reports the delivery state of one sink
+type SinkStatus struct {
+	Name        string    `json:"name"`
+	Healthy     bool      `json:"healthy"` // false while writes keep failing
+	Queued      int       `json:"queued"`
+	Delivered   uint64    `json:"delivered"`
+	Dropped     uint64    `json:"dropped"`
+	Aggregated  uint64    `json:"aggregated"` // readings folded into rollups
+	Rollups     uint64    `json:"rollups"`    // rollups delivered
+	Failures    uint64    `json:"failures"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastSuccess time.Time `json:"last_success"`
+	Held        bool      `json:"held,omitempty"` // network sink waiting for the agent to go online
+	// LastSeq is the Seq of the last reading delivered, for comparing
+	// with what the receiver has seen
+	LastSeq uint64 `json:"last_seq,omitempty"`
+	// DiskUsage is the local storage used by a DiskSink, and Shedding is
+	// set while it keeps only rollups as the disk is nearly full, its
+	// budget runs low or memory does
+	DiskUsage int64         `json:"disk_usage,omitempty"`
+	Shedding  bool          `json:"shedding,omitempty"`
+	Budget    *BudgetStatus `json:"budget,omitempty"`
+}
+
+var (
+	sinkDelivered  = metrics.NewCounter("agent_sink_delivered_total", "Readings written to a sink", "sink")
+	sinkDropped    = metrics.NewCounter("agent_sink_dropped_total", "Readings dropped because a sink was full or kept failing", "sink")
+	sinkFailures   = metrics.NewCounter("agent_sink_failures_total", "Failed sink writes, including retries", "sink")
+	sinkAggregated = metrics.NewCounter("agent_sink_aggregated_total", "Readings folded into rollups because a sink was behind", "sink")
+	sinkQueued     = metrics.NewGauge("agent_sink_queue_length", "Readings waiting to be written to a sink", "sink")
+	sinkLastSeq    = metrics.NewGauge("agent_sink_last_seq", "Sequence number of the last reading written to a sink", "sink")
+)
+
+// sinkWorker delivers readings to one sink from its own queue and
+// goroutine, so a slow or failing sink never holds up the sampling loop or
+// the other sinks
+type sinkWorker struct {
+	name  string
+	sink  Sink
+	opts  SinkOptions
+	queue chan Reading
+	gate  *netGate // nil for sinks that don't need the network
+	iface string   // the uplink its connections use, for Reconnector sinks
+
+	mu     sync.Mutex
+	status SinkStatus
+	rollup rollup // readings squeezed out of the queue, older than all queued
+	// shedDisk, shedBudget and shedMemory, while any is set, fold every
+	// reading into the rollup, which is delivered once per the longest
+	// interval
+	shedDisk   time.Duration
+	shedBudget time.Duration
+	shedMemory time.Duration
+	shedWake   chan struct{}
+	budget     *budget   // nil without a budget; used by run only
+	holdUntil  time.Time // the budget holds deliveries until then
+}
+
+func newSinkWorker(name string, s Sink, opts SinkOptions, gate *netGate) *sinkWorker {
+	if opts.QueueSize < 1 {
+		opts.QueueSize = 1
+	}
+	return &sinkWorker{
+		name:     name,
+		sink:     s,
+		opts:     opts,
+		queue:    make(chan Reading, opts.QueueSize),
+		gate:     gate,
+		status:   SinkStatus{Name: name, Healthy: true},
+		shedWake: make(chan struct{}, 1),
+	}
+}
+
+// shed makes the worker keep only rollups, one per interval, or with 0
+// every reading again, as the disk fills and is freed
+func (w *sinkWorker) shed(interval time.Duration) {
+	w.mu.Lock()
+	w.shedDisk = interval
+	w.status.Shedding = w.shedDisk > 0 || w.shedBudget > 0 || w.shedMemory > 0
+	w.mu.Unlock()
+	select {
+	case w.shedWake <- struct{}{}:
+	default:
+	}
+}
+
+// shedForMemory makes the worker keep only rollups, one per interval, or
+// with 0 every reading again, as memory runs short and recovers. Readings
+// queued when rollups take over are folded into the rollup.
+func (w *sinkWorker) shedForMemory(interval time.Duration) {
+	w.mu.Lock()
+	start := w.shedMemory == 0 && interval > 0
+	w.shedMemory = interval
+	w.status.Shedding = w.shedDisk > 0 || w.shedBudget > 0 || w.shedMemory > 0
+	w.mu.Unlock()
+	for start {
+		select {
+		case r := <-w.queue:
+			w.aggregate(r)
+		default:
+			sinkQueued.Set(0, w.name)
+			start = false
+		}
+	}
+	select {
+	case w.shedWake <- struct{}{}:
+	default:
+	}
+}
+
+// shedding returns the rollup interval while shedding, and how long the
+// pending rollup has left of it
+func (w *sinkWorker) shedding() (every, left time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	every = max(w.shedDisk, w.shedBudget, w.shedMemory)
+	if every == 0 {
+		return 0, 0
+	}
+	left = every
+	if w.rollup.samples > 0 {
+		left -= time.Since(w.rollup.start)
+	}
+	return every, left
+}
+
+// checkBudget applies the policy the budget calls for. Readings queued
+// when rollups take over are folded into the rollup, so none go out raw.
+func (w *sinkWorker) checkBudget(now time.Time) {
+	if w.budget == nil {
+		return
+	}
+	every, hold := w.budget.check(now)
+	w.holdUntil = hold
+	w.mu.Lock()
+	start := w.shedBudget == 0 && every > 0
+	w.shedBudget = every
+	w.status.Shedding = w.shedDisk > 0 || w.shedBudget > 0 || w.shedMemory > 0
+	w.mu.Unlock()
+	for start {
+		select {
+		case r := <-w.queue:
+			w.aggregate(r)
+		default:
+			sinkQueued.Set(0, w.name)
+			start = false
+		}
+	}
+}
+
+// enqueue adds r without blocking. If the queue is full the oldest queued
+// reading is dropped, or folded into the rollup.
+func (w *sinkWorker) enqueue(r Reading) {
+	if every, _ := w.shedding(); every > 0 {
+		w.aggregate(r)
+		return
+	}
+	for {
+		select {
+		case w.queue <- r:
+			sinkQueued.Set(float64(len(w.queue)), w.name)
+			return
+		default:
+		}
+		select {
+		case old := <-w.queue:
+			if w.opts.Rollup {
+				w.aggregate(old)
+			} else {
+				w.dropped()
+			}
+		default:
+		}
+	}
+}
+
+func (w *sinkWorker) aggregate(r Reading) {
+	sinkAggregated.Inc(w.name)
+	w.mu.Lock()
+	w.rollup.add(r)
+	w.status.Aggregated++
+	w.mu.Unlock()
+}
+
+// takeRollup returns the pending rollup, if any, and starts a new one
+func (w *sinkWorker) takeRollup() (Reading, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.rollup.samples == 0 {
+		return Reading{}, false
+	}
+	r := w.rollup.reading()
+	w.rollup = rollup{}
+	w.status.Rollups++
+	return r, true
+}
+
+func (w *sinkWorker) dropped() {
+	sinkDropped.Inc(w.name)
+	w.mu.Lock()
+	w.status.Dropped++
+	w.mu.Unlock()
+}
+
+// run delivers queued readings until ctx is done. A network sink only
+// takes readings off its queue while the agent is online.
+func (w *sinkWorker) run(ctx context.Context) {
+	for {
+		if w.gate != nil {
+			select {
+			case <-w.gate.wait():
+			case <-ctx.Done():
+				return
+			}
+		}
+		w.checkBudget(time.Now())
+		var due <-chan time.Time
+		queue := w.queue
+		if hold := time.Until(w.holdUntil); hold > 0 {
+			due, queue = time.After(hold), nil
+		} else if every, left := w.shedding(); every == 0 || left <= 0 {
+			if r, ok := w.takeRollup(); ok {
+				w.deliver(ctx, r)
+				continue
+			}
+		} else {
+			due = time.After(left)
+		}
+		select {
+		case r := <-queue:
+			sinkQueued.Set(float64(len(w.queue)), w.name)
+			w.deliver(ctx, r)
+		case <-due:
+		case <-w.shedWake:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (w *sinkWorker) deliver(ctx context.Context, r Reading) {
+	wait := w.opts.Backoff
+	for attempt := 0; ; attempt++ {
+		err := w.write(ctx, r)
+		if err == nil {
+			sinkDelivered.Inc(w.name)
+			w.mu.Lock()
+			w.status.Delivered++
+			w.status.Healthy = true
+			w.status.LastSuccess = time.Now()
+			if r.Seq > w.status.LastSeq {
+				w.status.LastSeq = r.Seq
+				sinkLastSeq.Set(float64(r.Seq), w.name)
+			}
+			w.mu.Unlock()
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		sinkFailures.Inc(w.name)
+		w.mu.Lock()
+		w.status.Failures++
+		w.status.LastError = err.Error()
+		wasHealthy := w.status.Healthy
+		w.status.Healthy = false
+		w.mu.Unlock()
+		if wasHealthy {
+			log.Printf("❌ Sink %s: %v (retrying)", w.name, err)
+		}
+
+		if attempt >= w.opts.Retries {
+			w.dropped()
+			return
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+		wait *= 2
+		if w.opts.MaxBackoff > 0 && wait > w.opts.MaxBackoff {
+			wait = w.opts.MaxBackoff
+		}
+	}
+}
+
+func (w *sinkWorker) write(ctx context.Context, r Reading) error {
+	if w.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.opts.Timeout)
+		defer cancel()
+	}
+	return w.sink.Write(ctx, r)
+}
+
+func (w *sinkWorker) snapshot() SinkStatus {
+	w.mu.Lock()
+	st := w.status
+	w.mu.Unlock()
+	st.Queued = len(w.queue)
+	st.Held = w.gate != nil && !w.gate.isOpen()
+	if d, ok := w.sink.(DiskSink); ok {
+		st.DiskUsage = d.DiskUsage()
+	}
+	if w.budget != nil {
+		st.Budget = w.budget.status()
+	}
+	return st
+}
+
+// healthCheck fails while the sink's writes are failing
+func (w *sinkWorker) healthCheck(ctx context.Context) error {
+	st := w.snapshot()
+	if !st.Healthy {
+		return fmt.Errorf("%s (%d queued, %d dropped)", st.LastError, st.Queued, st.Dropped)
+	}
+	return nil
+}
+
+// rollup accumulates readings into one summary reading
+type rollup struct {
+	source     string
+	device     string
+	start, end time.Time
+	first, seq uint64 // of the first and last readings
+	samples    int
+	channels   []channelRollup
+}
+
+type channelRollup struct {
+	name, unit string
+	meta       *sensor.Metadata
+	quality    sensor.Quality
+	n          int // usable values
+	sum        float64
+	min, max   float64
+}
+
+func (ru *rollup) add(r Reading) {
+	if ru.samples == 0 {
+		ru.start, ru.source, ru.device, ru.first = r.Time, r.Source, r.Device, r.Seq
+	}
+	ru.end, ru.seq = r.Time, r.Seq
+	ru.samples++
+	for _, c := range r.Channels {
+		cr := ru.channel(c)
+		cr.quality = sensor.Worst(cr.quality, c.Quality)
+		if math.IsNaN(c.Value) {
+			continue
+		}
+		if cr.n == 0 || c.Value < cr.min {
+			cr.min = c.Value
+		}
+		if cr.n == 0 || c.Value > cr.max {
+			cr.max = c.Value
+		}
+		cr.sum += c.Value
+		cr.n++
+	}
+}
+
+func (ru *rollup) channel(c ChannelReading) *channelRollup {
+	for i := range ru.channels {
+		if ru.channels[i].name == c.Name {
+			return &ru.channels[i]
+		}
+	}
+	ru.channels = append(ru.channels, channelRollup{name: c.Name, unit: c.Unit, meta: c.Meta})
+	return &ru.channels[len(ru.channels)-1]
+}
+
+// reading returns the summary: the mean of each channel, stamped with the
+// time of the last sample it covers
+func (ru *rollup) reading() Reading {
+	r := Reading{
+		Time:     ru.end,
+		Device:   ru.device,
+		Seq:      ru.seq,
+		Source:   ru.source,
+		Channels: make([]ChannelReading, 0, len(ru.channels)),
+		Span:     &Span{Start: ru.start, Samples: ru.samples, Seq: ru.first},
+	}
+	for _, cr := range ru.channels {
+		c := ChannelReading{Name: cr.name, Unit: cr.unit, Value: math.NaN(), Quality: cr.quality, Meta: cr.meta}
+		if cr.n > 0 {
+			min, max := cr.min, cr.max
+			c.Value, c.Min, c.Max = cr.sum/float64(cr.n), &min, &max
+		}
+		r.Channels = append(r.Channels, c)
+	}
+	return r
+}
+
+// netGate holds network sinks back while the agent is offline; their queues
+// keep the most recent readings until it goes online
+type netGate struct {
+	mu   sync.Mutex
+	open chan struct{} // closed while online
+}
+
+func newNetGate(online bool) *netGate {
+	g := &netGate{open: make(chan struct{})}
+	if online {
+		close(g.open)
+	}
+	return g
+}
+
+// wait returns a channel that is closed while the gate is open
+func (g *netGate) wait() <-chan struct{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.open
+}
+
+func (g *netGate) isOpen() bool {
+	select {
+	case <-g.wait():
+		return true
+	default:
+		return false
+	}
+}
+
+// set opens or closes the gate and reports whether that changed anything
+func (g *netGate) set(online bool) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	select {
+	case <-g.open:
+		if online {
+			return false
+		}
+		g.open = make(chan struct{})
+	default:
+		if !online {
+			return false
+		}
+		close(g.open)
+	}
+	return true
+}
@@ -0,0 +1,269 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"riscv-dev/pkg/sensor"
+)
+
+// recordingSink keeps what is written to it
+type recordingSink struct {
+	mu       sync.Mutex
+	readings []Reading
+}
+
+func (s *recordingSink) Write(ctx context.Context, r Reading) error {
+	s.mu.Lock()
+	s.readings = append(s.readings, r)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *recordingSink) got() []Reading {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Reading(nil), s.readings...)
+}
+
+// written returns the Seq of each reading written
+func (s *recordingSink) written() []uint64 {
+	var seqs []uint64
+	for _, r := range s.got() {
+		seqs = append(seqs, r.Seq)
+	}
+	return seqs
+}
+
+// blockingSink hangs in Write until released, as a sink behind a dead
+// connection without a timeout does
+type blockingSink struct {
+	recordingSink
+	entered chan struct{}
+	release chan struct{}
+}
+
+func newBlockingSink() *blockingSink {
+	return &blockingSink{entered: make(chan struct{}, 1000), release: make(chan struct{})}
+}
+
+func (s *blockingSink) Write(ctx context.Context, r Reading) error {
+	s.entered <- struct{}{}
+	select {
+	case <-s.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return s.recordingSink.Write(ctx, r)
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func startWorker(t *testing.T, name string, s Sink, opts SinkOptions) *sinkWorker {
+	t.Helper()
+	w := newSinkWorker(name, s, opts, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return w
+}
+
+func seqReading(seq uint64) Reading {
+	return Reading{
+		Time:     time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC).Add(time.Duration(seq) * time.Second),
+		Seq:      seq,
+		Channels: []ChannelReading{{Name: "temperature", Unit: "°C", Value: float64(seq)}},
+	}
+}
+
+func TestSinkIsolation(t *testing.T) {
+	fail := errors.New("connection refused")
+	healthy := &recordingSink{}
+	blocking := newBlockingSink()
+	failing := SinkFunc(func(ctx context.Context, r Reading) error { return fail })
+
+	opts := SinkOptions{QueueSize: 64, Retries: 2, Backoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+	hw := startWorker(t, "healthy", healthy, opts)
+	fw := startWorker(t, "failing", failing, opts)
+	small := opts
+	small.QueueSize = 4
+	bw := startWorker(t, "blocking", blocking, small)
+	workers := []*sinkWorker{hw, bw, fw}
+
+	// The first reading gets the blocking sink stuck in Write
+	for _, w := range workers {
+		w.enqueue(seqReading(1))
+	}
+	<-blocking.entered
+
+	// The sampling loop is never held up, however many readings pile up
+	start := time.Now()
+	for seq := uint64(2); seq <= 20; seq++ {
+		for _, w := range workers {
+			w.enqueue(seqReading(seq))
+		}
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("enqueueing took %v", d)
+	}
+
+	// The healthy sink gets everything while the others are stuck or failing
+	waitFor(t, "healthy sink", func() bool { return len(healthy.written()) == 20 })
+	for i, seq := range healthy.written() {
+		if seq != uint64(i+1) {
+			t.Fatalf("healthy sink got %v", healthy.written())
+		}
+	}
+	if st := hw.snapshot(); !st.Healthy || st.Delivered != 20 || st.Dropped != 0 || st.LastSeq != 20 {
+		t.Errorf("healthy status = %+v", st)
+	}
+
+	// The blocked sink keeps the newest readings, dropping the oldest
+	st := bw.snapshot()
+	if st.Queued != 4 || st.Dropped != 15 || st.Delivered != 0 {
+		t.Errorf("blocked status = %+v", st)
+	}
+	close(blocking.release)
+	waitFor(t, "blocked sink to catch up", func() bool { return len(blocking.written()) == 5 })
+	want := []uint64{1, 17, 18, 19, 20}
+	for i, seq := range blocking.written() {
+		if seq != want[i] {
+			t.Errorf("blocked sink got %v, want %v", blocking.written(), want)
+			break
+		}
+	}
+
+	// The failing sink tries each reading 1+Retries times, then drops it
+	waitFor(t, "failing sink to give up", func() bool { return fw.snapshot().Dropped == 20 })
+	st = fw.snapshot()
+	if st.Healthy || st.Delivered != 0 || st.Failures != 60 || st.LastError != fail.Error() {
+		t.Errorf("failing status = %+v", st)
+	}
+	if err := fw.healthCheck(context.Background()); err == nil {
+		t.Error("failing sink passes its health check")
+	}
+	if err := hw.healthCheck(context.Background()); err != nil {
+		t.Errorf("healthy sink fails its health check: %v", err)
+	}
+}
+
+func TestSinkRetryBackoff(t *testing.T) {
+	var mu sync.Mutex
+	var attempts []time.Time
+	flaky := SinkFunc(func(ctx context.Context, r Reading) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts = append(attempts, time.Now())
+		if len(attempts) <= 3 {
+			return errors.New("503 Service Unavailable")
+		}
+		return nil
+	})
+	w := startWorker(t, "flaky", flaky, SinkOptions{QueueSize: 4, Retries: 5, Backoff: 20 * time.Millisecond, MaxBackoff: 30 * time.Millisecond})
+	w.enqueue(seqReading(7))
+	waitFor(t, "delivery", func() bool { return w.snapshot().Delivered == 1 })
+
+	st := w.snapshot()
+	if !st.Healthy || st.Failures != 3 || st.Dropped != 0 || st.LastSeq != 7 {
+		t.Errorf("status = %+v", st)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	// Doubling from Backoff, capped at MaxBackoff
+	for i, min := range []time.Duration{20, 30, 30} {
+		if gap := attempts[i+1].Sub(attempts[i]); gap < min*time.Millisecond {
+			t.Errorf("retry %d after %v, want at least %vms", i+1, gap, min)
+		}
+	}
+}
+
+func TestSinkTimeout(t *testing.T) {
+	blocking := newBlockingSink()
+	w := startWorker(t, "hung", blocking, SinkOptions{QueueSize: 4, Timeout: 10 * time.Millisecond})
+	w.enqueue(seqReading(1))
+	waitFor(t, "the write to time out", func() bool { return w.snapshot().Dropped == 1 })
+	if st := w.snapshot(); st.Healthy || st.Failures != 1 {
+		t.Errorf("status = %+v", st)
+	}
+}
+
+func TestRollup(t *testing.T) {
+	var ru rollup
+	for i, v := range []float64{20, math.NaN(), 23, 21} {
+		r := seqReading(uint64(10 + i))
+		r.Source, r.Device = "site/board", "board-1"
+		r.Channels[0].Value = v
+		if math.IsNaN(v) {
+			r.Channels[0].Quality = sensor.Fault
+		}
+		r.Channels = append(r.Channels, ChannelReading{Name: "light", Value: math.NaN(), Quality: sensor.Fault})
+		ru.add(r)
+	}
+	r := ru.reading()
+	if r.Seq != 13 || r.Span == nil || r.Span.Seq != 10 || r.Span.Samples != 4 {
+		t.Fatalf("rollup = %+v, span %+v", r, r.Span)
+	}
+	if !r.Span.Start.Equal(seqReading(10).Time) || !r.Time.Equal(seqReading(13).Time) {
+		t.Errorf("rollup covers %v to %v", r.Span.Start, r.Time)
+	}
+	if r.Source != "site/board" || r.Device != "board-1" {
+		t.Errorf("rollup from %q/%q", r.Source, r.Device)
+	}
+	temp, ok := r.Get("temperature")
+	if !ok || temp.Value != 64.0/3 || *temp.Min != 20 || *temp.Max != 23 || temp.Unit != "°C" || temp.Quality != sensor.Fault {
+		t.Errorf("temperature = %+v", temp)
+	}
+	// A channel with no usable value has no mean, min or max
+	if light, _ := r.Get("light"); !math.IsNaN(light.Value) || light.Min != nil || light.Max != nil {
+		t.Errorf("light = %+v", light)
+	}
+}
+
+func TestSinkRollupAheadOfQueue(t *testing.T) {
+	blocking := newBlockingSink()
+	w := startWorker(t, "rollup", blocking, SinkOptions{QueueSize: 2, Rollup: true})
+	w.enqueue(seqReading(1))
+	<-blocking.entered
+	for seq := uint64(2); seq <= 8; seq++ {
+		w.enqueue(seqReading(seq))
+	}
+	// 2 to 6 were squeezed out of the queue into the rollup
+	if st := w.snapshot(); st.Dropped != 0 || st.Aggregated != 5 || st.Queued != 2 {
+		t.Errorf("status = %+v", st)
+	}
+
+	close(blocking.release)
+	waitFor(t, "delivery", func() bool { return w.snapshot().Delivered == 4 })
+
+	// The rollup goes next, so the sink still sees readings in order
+	got := blocking.got()
+	if len(got) != 4 || got[0].Seq != 1 || got[1].Span == nil || got[1].Span.Seq != 2 || got[1].Seq != 6 || got[2].Seq != 7 || got[3].Seq != 8 {
+		t.Fatalf("delivered %+v", got)
+	}
+	if temp, _ := got[1].Get("temperature"); temp.Value != 4 || *temp.Min != 2 || *temp.Max != 6 {
+		t.Errorf("rolled-up temperature = %+v", temp)
+	}
+	if st := w.snapshot(); st.Rollups != 1 || st.LastSeq != 8 {
+		t.Errorf("status = %+v", st)
+	}
+}
@@ -0,0 +1,297 @@
+package agent
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FileSinkConfig configures a FileSink in config.json
+type FileSinkConfig struct {
+	Path string `json:"path"`
+	// MaxSize rotates the file before it grows past this many bytes; 0
+	// never rotates
+	MaxSize  int64 `json:"max_size,omitempty"`
+	MaxFiles int   `json:"max_files,omitempty"` // rotated files kept; default 5
+	// Compress gzips rotated files, path.1.gz and on
+	Compress bool `json:"compress,omitempty"`
+}
+
+// FileSink appends readings to a file as JSON lines, optionally rotated by
+// size. Each reading is written as a whole line at once; a line cut short
+// by a crash, a power cut or a full disk is removed, so readers never see
+// a torn record.
+type FileSink struct {
+	cfg FileSinkConfig
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// NewFileSink opens path for appending, creating it if needed
+func NewFileSink(path string) (*FileSink, error) {
+	return OpenFileSink(FileSinkConfig{Path: path})
+}
+
+// OpenFileSink opens cfg.Path for appending, creating it if needed and
+// finishing whatever a crash interrupted: a torn last line is cut off and
+// rotated files left uncompressed are compressed
+func OpenFileSink(cfg FileSinkConfig) (*FileSink, error) {
+	if cfg.MaxFiles <= 0 {
+		cfg.MaxFiles = 5
+	}
+	if err := repairTail(cfg.Path); err != nil {
+		return nil, err
+	}
+	s := &FileSink{cfg: cfg}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	if cfg.Compress {
+		s.compressRotated()
+	}
+	return s, nil
+}
+
+func (s *FileSink) open() error {
+	f, err := os.OpenFile(s.cfg.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f, s.size = f, st.Size()
+	return nil
+}
+
+// repairTail cuts a file after its last complete line
+func repairTail(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := st.Size()
+	buf := make([]byte, 4096)
+	cut := int64(0)
+	for end := size; end > 0; {
+		n := min(int64(len(buf)), end)
+		if _, err := f.ReadAt(buf[:n], end-n); err != nil {
+			return err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			cut = end - n + int64(i) + 1
+			break
+		}
+		end -= n
+	}
+	if cut == size {
+		return nil
+	}
+	log.Printf("⚠️  %s: removed %d bytes of a line cut short", path, size-cut)
+	return f.Truncate(cut)
+}
+
+// Write appends one line, rotating the file first if it would outgrow
+// MaxSize
+func (s *FileSink) Write(ctx context.Context, r Reading) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return errors.New("file sink closed")
+	}
+	if s.cfg.MaxSize > 0 && s.size > 0 && s.size+int64(len(line)) > s.cfg.MaxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.f.Write(line)
+	if err != nil && n > 0 {
+		// Out of space part way: drop the partial line
+		s.f.Truncate(s.size)
+		return err
+	}
+	s.size += int64(n)
+	return err
+}
+
+// rotate shifts path.N to path.N+1, dropping the oldest, moves the current
+// file to path.1 and starts a new one
+func (s *FileSink) rotate() error {
+	s.f.Close()
+	s.f = nil
+	for _, ext := range []string{"", ".gz"} {
+		os.Remove(s.rotated(s.cfg.MaxFiles) + ext)
+		for i := s.cfg.MaxFiles - 1; i >= 1; i-- {
+			os.Rename(s.rotated(i)+ext, s.rotated(i+1)+ext)
+		}
+	}
+	if err := os.Rename(s.cfg.Path, s.rotated(1)); err != nil {
+		s.open()
+		return err
+	}
+	if err := s.open(); err != nil {
+		return err
+	}
+	if s.cfg.Compress {
+		if err := compressFile(s.rotated(1)); err != nil {
+			log.Printf("⚠️  Compressing %s: %v", s.rotated(1), err)
+		}
+	}
+	return nil
+}
+
+func (s *FileSink) rotated(n int) string { return s.cfg.Path + "." + strconv.Itoa(n) }
+
+// rotatedFiles returns the rotated files, newest first
+func (s *FileSink) rotatedFiles() []string {
+	matches, _ := filepath.Glob(s.cfg.Path + ".*")
+	num := make(map[string]int)
+	var files []string
+	for _, m := range matches {
+		suffix := strings.TrimSuffix(strings.TrimPrefix(m, s.cfg.Path+"."), ".gz")
+		if n, err := strconv.Atoi(suffix); err == nil && n > 0 {
+			num[m] = n
+			files = append(files, m)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return num[files[i]] < num[files[j]] })
+	return files
+}
+
+// compressRotated gzips the rotated files that aren't yet
+func (s *FileSink) compressRotated() {
+	for _, f := range s.rotatedFiles() {
+		if !strings.HasSuffix(f, ".gz") {
+			if err := compressFile(f); err != nil {
+				log.Printf("⚠️  Compressing %s: %v", f, err)
+			}
+		}
+	}
+}
+
+// compressFile replaces path by path.gz; a crash leaves one or the other
+func compressFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := path + ".gz.tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// usage returns the bytes of the current and rotated files
+func (s *FileSink) usage() int64 {
+	total := s.size
+	for _, f := range s.rotatedFiles() {
+		if st, err := os.Stat(f); err == nil {
+			total += st.Size()
+		}
+	}
+	return total
+}
+
+// DiskUsage returns the bytes of the current and rotated files
+func (s *FileSink) DiskUsage() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.usage()
+}
+
+// Compact compresses the rotated files, then deletes the oldest until the
+// sink uses at most keep bytes. If that isn't enough, the current file is
+// rotated too, and deleted as well if it must be.
+func (s *FileSink) Compact(keep int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return 0, errors.New("file sink closed")
+	}
+	before := s.usage()
+	s.compressRotated()
+	err := s.trim(keep)
+	if err == nil && s.usage() > keep && s.size > 0 {
+		// Compressed, the current file takes far less
+		compress := s.cfg.Compress
+		s.cfg.Compress = true
+		err = s.rotate()
+		s.cfg.Compress = compress
+		if err == nil {
+			err = s.trim(keep)
+		}
+	}
+	return before - s.usage(), err
+}
+
+// trim deletes rotated files, oldest first, until at most keep bytes are
+// used
+func (s *FileSink) trim(keep int64) error {
+	files := s.rotatedFiles()
+	for i := len(files) - 1; i >= 0 && s.usage() > keep; i-- {
+		if err := os.Remove(files[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the file
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
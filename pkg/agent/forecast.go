@@ -0,0 +1,140 @@
+package agent
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"riscv-dev/pkg/config"
+	"riscv-dev/pkg/metrics"
+	"riscv-dev/pkg/schedule"
+	"riscv-dev/pkg/sensor"
+)
+
+// ForecastConfig raises a forecast alert when a channel's trend says it
+// will cross a threshold within Horizon, e.g. "temperature above 80 within
+// 10 minutes". Exactly one of Above and Below is set.
+type ForecastConfig struct {
+	Channel string          `json:"channel"`
+	Above   *float64        `json:"above,omitempty"`
+	Below   *float64        `json:"below,omitempty"`
+	Horizon config.Duration `json:"horizon"`
+	// Alpha and Beta tune the smoothing (see sensor.Holt); MinSamples is
+	// how many samples are needed before forecasting, default 10
+	Alpha      float64 `json:"alpha,omitempty"`
+	Beta       float64 `json:"beta,omitempty"`
+	MinSamples int     `json:"min_samples,omitempty"`
+	// When limits the forecast to a schedule (see ThresholdSchedule), so
+	// forecasts with different thresholds can take turns, e.g. by day and
+	// by night; outside it an active alert clears
+	When string `json:"when,omitempty"`
+}
+
+// ForecastAlert reports a channel forecast to cross a threshold within
+// the horizon (Active), or no longer forecast to
+type ForecastAlert struct {
+	Time      time.Time `json:"time"`
+	Channel   string    `json:"channel"`
+	Active    bool      `json:"active"`
+	Direction string    `json:"direction"` // above or below
+	Threshold float64   `json:"threshold"`
+	Horizon   float64   `json:"horizon_seconds"`
+	In        *float64  `json:"in_seconds,omitempty"` // time to the crossing, if forecast
+	Value     float64   `json:"value"`                // smoothed current value
+	Trend     float64   `json:"trend_per_second"`
+}
+
+var forecastValue = metrics.NewGauge("sensor_forecast", "Value a channel is forecast to reach at the end of the horizon", "sensor", "horizon")
+
+// forecaster follows one ForecastConfig on its channel
+type forecaster struct {
+	cfg       ForecastConfig
+	threshold float64
+	above     bool
+	holt      sensor.Holt
+	active    bool
+	window    *schedule.Window // nil for always
+}
+
+// addForecasts attaches the forecasts to their channels
+func (a *Agent) addForecasts(cfg Config) error {
+	for _, fc := range cfg.Forecasts {
+		ch := a.channel(fc.Channel)
+		if ch == nil {
+			return fmt.Errorf("forecast: unknown channel %q", fc.Channel)
+		}
+		if (fc.Above == nil) == (fc.Below == nil) {
+			return fmt.Errorf("forecast for %s: set one of above and below", fc.Channel)
+		}
+		if fc.Horizon <= 0 {
+			return fmt.Errorf("forecast for %s: horizon must be positive", fc.Channel)
+		}
+		f := &forecaster{cfg: fc, above: fc.Above != nil}
+		if f.above {
+			f.threshold = *fc.Above
+		} else {
+			f.threshold = *fc.Below
+		}
+		if fc.When != "" {
+			w, err := schedule.ParseAt(fc.When, cfg.Coordinates)
+			if err != nil {
+				return fmt.Errorf("forecast for %s: %w", fc.Channel, err)
+			}
+			f.window = w
+		}
+		f.holt = sensor.Holt{Alpha: fc.Alpha, Beta: fc.Beta, MinSamples: fc.MinSamples, MaxGap: 3 * cfg.SampleInterval.D()}
+		a.mu.Lock()
+		ch.forecasts = append(ch.forecasts, f)
+		a.mu.Unlock()
+	}
+	return nil
+}
+
+// update feeds a sample and returns an alert if the forecast started or
+// stopped crossing the threshold within the horizon. It clears only once
+// the crossing is half a horizon further off than it raised at, so a
+// noisy trend doesn't flap, or when its schedule ends.
+func (f *forecaster) update(name string, s sensor.Sample) *ForecastAlert {
+	f.holt.Add(s)
+	if !f.holt.Ready() {
+		return nil
+	}
+	horizon := f.cfg.Horizon.D()
+	forecastValue.Set(f.holt.Forecast(horizon), name, horizon.String())
+	in, ok := f.holt.Until(f.threshold, f.above)
+	off := f.window != nil && !f.window.Contains(s.Time)
+	switch {
+	case !f.active && ok && in <= horizon && !off:
+		f.active = true
+	case f.active && (!ok || in > horizon*3/2 || off):
+		f.active = false
+	default:
+		return nil
+	}
+	alert := &ForecastAlert{
+		Time: s.Time, Channel: name, Active: f.active, Direction: "below",
+		Threshold: f.threshold, Horizon: horizon.Seconds(),
+		Value: f.holt.Level(), Trend: f.holt.Trend(),
+	}
+	if f.above {
+		alert.Direction = "above"
+	}
+	if ok {
+		secs := in.Seconds()
+		alert.In = &secs
+	}
+	return alert
+}
+
+// logForecast logs a forecast alert
+func logForecast(a *ForecastAlert, unit string) {
+	threshold := strings.TrimSpace(fmt.Sprintf("%g %s", a.Threshold, unit))
+	if !a.Active {
+		log.Printf("✅ %s no longer forecast to go %s %s", a.Channel, a.Direction, threshold)
+		return
+	}
+	in := time.Duration(*a.In * float64(time.Second)).Round(time.Second)
+	log.Printf("⚠️  %s forecast to go %s %s in %v (now %.2f, trend %+.3f/min)",
+		a.Channel, a.Direction, threshold, in, a.Value, a.Trend*60)
+}
@@ -0,0 +1,150 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"riscv-dev/pkg/config"
+	"riscv-dev/pkg/hal"
+	"riscv-dev/pkg/sensor"
+)
+
+// FrequencyConfig measures the frequency, and optionally the duty cycle,
+// of a signal on a GPIO input, e.g. a fan tachometer, a flow sensor or PWM
+// feedback
+type FrequencyConfig struct {
+	Name string `json:"name"`
+	Pin  int    `json:"pin"`
+	Unit string `json:"unit,omitempty"` // default Hz
+	// Scale converts Hz to the unit, e.g. 30 for the RPM of a fan giving
+	// two pulses per revolution; default 1
+	Scale float64 `json:"scale,omitempty"`
+	// Window is the time measured over; default the sample interval
+	Window config.Duration `json:"window,omitempty"`
+	// DutyName adds a channel with the duty cycle in percent
+	DutyName string `json:"duty_name,omitempty"`
+	sensor.Range
+}
+
+// frequencyInput timestamps both edges of a signal in the background
+type frequencyInput struct {
+	cfg    FrequencyConfig
+	failed atomic.Bool // the edge stream ended
+	stop   context.CancelFunc
+	done   chan struct{}
+
+	mu    sync.Mutex
+	meter sensor.SignalMeter
+	duty  float64 // from the latest Read, for the duty channel
+}
+
+func newFrequencyInput(cfg FrequencyConfig, gpio hal.GPIOController, window time.Duration) (*frequencyInput, error) {
+	w, ok := gpio.(hal.EdgeWatcher)
+	if !ok {
+		return nil, fmt.Errorf("frequency input %s: GPIO backend cannot watch edges", cfg.Name)
+	}
+	if cfg.Unit == "" {
+		cfg.Unit = "Hz"
+	}
+	if cfg.Scale == 0 {
+		cfg.Scale = 1
+	}
+	f := &frequencyInput{cfg: cfg, done: make(chan struct{}), meter: sensor.SignalMeter{Window: window}}
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := w.WatchEdges(ctx, cfg.Pin, hal.BothEdges)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("frequency input %s: %w", cfg.Name, err)
+	}
+	if high, err := gpio.Read(ctx, cfg.Pin); err == nil {
+		f.meter.High = high
+	}
+	f.stop = cancel
+	go f.watch(ctx, events)
+	return f, nil
+}
+
+func (f *frequencyInput) watch(ctx context.Context, events <-chan hal.EdgeEvent) {
+	defer close(f.done)
+	for ev := range events {
+		f.mu.Lock()
+		f.meter.Add(sensor.Transition{Time: ev.Time, Rising: ev.Rising})
+		f.mu.Unlock()
+	}
+	if ctx.Err() == nil {
+		f.failed.Store(true)
+	}
+}
+
+func (f *frequencyInput) Name() string { return f.cfg.Name }
+func (f *frequencyInput) Unit() string { return f.cfg.Unit }
+
+// Read measures the signal, the duty cycle included
+func (f *frequencyInput) Read(ctx context.Context) (float64, sensor.Quality, error) {
+	if f.failed.Load() {
+		return 0, sensor.Fault, fmt.Errorf("GPIO %d: edge detection stopped", f.cfg.Pin)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	freq, duty := f.meter.Measure(time.Now())
+	f.duty = duty
+	return freq * f.cfg.Scale, sensor.OK, nil
+}
+
+// Close stops watching the pin
+func (f *frequencyInput) Close() error {
+	f.stop()
+	<-f.done
+	return nil
+}
+
+// frequencyDuty is the duty cycle of a frequency input as a channel,
+// measured when the frequency is read
+type frequencyDuty struct{ f *frequencyInput }
+
+func (d frequencyDuty) Name() string { return d.f.cfg.DutyName }
+func (d frequencyDuty) Unit() string { return "%" }
+
+func (d frequencyDuty) Read(ctx context.Context) (float64, sensor.Quality, error) {
+	if d.f.failed.Load() {
+		return 0, sensor.Fault, fmt.Errorf("GPIO %d: edge detection stopped", d.f.cfg.Pin)
+	}
+	d.f.mu.Lock()
+	defer d.f.mu.Unlock()
+	return d.f.duty * 100, sensor.OK, nil
+}
+
+// addFrequencyInputs adds a channel, or two, for every configured
+// frequency input
+func (a *Agent) addFrequencyInputs(cfg Config) error {
+	if len(cfg.Frequencies) == 0 {
+		return nil
+	}
+	gpio, err := a.openGPIO()
+	if err != nil {
+		return err
+	}
+	for _, fc := range cfg.Frequencies {
+		window := fc.Window.D()
+		if window <= 0 {
+			window = cfg.SampleInterval.D()
+		}
+		f, err := newFrequencyInput(fc, gpio, window)
+		if err != nil {
+			return err
+		}
+		if err := a.AddSensor(f, fc.Range); err != nil {
+			f.Close()
+			return err
+		}
+		if fc.DutyName != "" {
+			if err := a.AddSensor(frequencyDuty{f}, sensor.Range{}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+//go:build !no_grafana && !minimal
+
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"riscv-dev/pkg/buildinfo"
+	"riscv-dev/pkg/sensor"
+)
+
+func init() { buildinfo.AddFeature("grafana") }
+
+// grafanaHandler implements the query API of Grafana's Simple JSON
+// datasource, so a Grafana instance can chart the agent's history without
+// a database in between. Targets are channel names, optionally with :min
+// or :max for those of each step instead of the mean.
+func (a *Agent) grafanaHandler() http.Handler {
+	mux := http.NewServeMux()
+	// The datasource tests the connection with GET /
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/search", a.grafanaSearch)
+	mux.HandleFunc("/metrics", a.grafanaSearch) // newer plugin versions
+	mux.HandleFunc("/query", a.grafanaQuery)
+	mux.HandleFunc("/annotations", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, []struct{}{})
+	})
+	return mux
+}
+
+// grafanaSearch lists the targets
+func (a *Agent) grafanaSearch(w http.ResponseWriter, r *http.Request) {
+	var targets []string
+	for _, name := range a.Sensors() {
+		targets = append(targets, name, name+":min", name+":max")
+	}
+	writeJSON(w, targets)
+}
+
+type grafanaQuery struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	IntervalMs    int64 `json:"intervalMs"`
+	MaxDataPoints int   `json:"maxDataPoints"`
+	Targets       []struct {
+		Target string `json:"target"`
+		RefID  string `json:"refId"`
+		Type   string `json:"type"` // timeserie (the default) or table
+	} `json:"targets"`
+}
+
+// grafanaQuery answers a query with one time series, or table, per target
+func (a *Agent) grafanaQuery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST a query", http.StatusMethodNotAllowed)
+		return
+	}
+	var q grafanaQuery
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&q); err != nil {
+		http.Error(w, "invalid query: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	from, to := q.Range.From, q.Range.To
+	step := time.Duration(q.IntervalMs) * time.Millisecond
+	if q.MaxDataPoints > 0 {
+		if min := to.Sub(from) / time.Duration(q.MaxDataPoints); step < min {
+			step = min
+		}
+	}
+	if step > 0 && step < time.Second {
+		step = step.Round(time.Millisecond)
+	}
+
+	results := make([]any, 0, len(q.Targets))
+	for _, t := range q.Targets {
+		name, agg, _ := strings.Cut(t.Target, ":")
+		series, err := a.History(name, from, to, step)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%s: %v", t.Target, err), http.StatusBadRequest)
+			return
+		}
+		pick := func(b sensor.Bucket) *float64 { return b.Mean }
+		switch agg {
+		case "", "mean":
+		case "min":
+			pick = func(b sensor.Bucket) *float64 { return b.Min }
+		case "max":
+			pick = func(b sensor.Bucket) *float64 { return b.Max }
+		default:
+			http.Error(w, fmt.Sprintf("%s: unknown aggregate %q (want min or max)", t.Target, agg), http.StatusBadRequest)
+			return
+		}
+
+		if t.Type == "table" {
+			rows := make([][]any, 0, len(series.Points))
+			for _, b := range series.Points {
+				rows = append(rows, []any{b.Time.UnixMilli(), pick(b), b.Count, b.Quality.String()})
+			}
+			results = append(results, map[string]any{
+				"type": "table",
+				"columns": []map[string]string{
+					{"text": "Time", "type": "time"},
+					{"text": t.Target + unitSuffix(series.Unit), "type": "number"},
+					{"text": "Samples", "type": "number"},
+					{"text": "Quality", "type": "string"},
+				},
+				"rows": rows,
+			})
+			continue
+		}
+		points := make([][2]any, 0, len(series.Points))
+		for _, b := range series.Points {
+			points = append(points, [2]any{pick(b), b.Time.UnixMilli()})
+		}
+		results = append(results, map[string]any{"target": t.Target, "datapoints": points})
+	}
+	writeJSON(w, results)
+}
+
+func unitSuffix(unit string) string {
+	if unit == "" {
+		return ""
+	}
+	return " (" + unit + ")"
+}
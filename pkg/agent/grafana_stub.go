@@ -0,0 +1,9 @@
+//go:build no_grafana || minimal
+
+package agent
+
+import "net/http"
+
+// grafanaHandler returns nil: the Grafana datasource API is left out of
+// binaries built with -tags no_grafana or minimal
+func (a *Agent) grafanaHandler() http.Handler { return nil }
@@ -0,0 +1,134 @@
+package agent
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"riscv-dev/pkg/sensor"
+)
+
+// History limits: a query without a step gets about defaultHistoryPoints
+// buckets, and none may ask for more than maxHistoryPoints
+const (
+	defaultHistoryRange  = time.Hour
+	defaultHistoryPoints = 300
+	maxHistoryPoints     = 5000
+)
+
+// HistorySeries is a channel's downsampled history, as served on /history
+type HistorySeries struct {
+	Channel string          `json:"channel"`
+	Unit    string          `json:"unit,omitempty"`
+	From    time.Time       `json:"from"`
+	To      time.Time       `json:"to"`
+	Step    float64         `json:"step"` // seconds
+	Points  []sensor.Bucket `json:"points"`
+}
+
+// History downsamples a channel's history over [from, to) into steps, the
+// first starting at from rounded down to a multiple of the step. A step of
+// 0 picks one giving about 300 points, never finer than the sample
+// interval. Samples the channel's range suppresses are left out.
+func (a *Agent) History(name string, from, to time.Time, step time.Duration) (HistorySeries, error) {
+	ch := a.channel(name)
+	if ch == nil {
+		return HistorySeries{}, fmt.Errorf("unknown sensor %q", name)
+	}
+	if !to.After(from) {
+		return HistorySeries{}, fmt.Errorf("from must be before to")
+	}
+	span := to.Sub(from)
+	if step <= 0 {
+		step = span / defaultHistoryPoints
+		if interval := a.cfg.SampleInterval.D(); step < interval {
+			step = interval
+		}
+		step = step.Round(time.Second)
+		if step == 0 {
+			step = time.Second
+		}
+	}
+	// Aligned steps keep buckets stable between polls
+	from = from.Truncate(step)
+	if points := (to.Sub(from) + step - 1) / step; points > maxHistoryPoints {
+		return HistorySeries{}, fmt.Errorf("%d points requested; at most %d (use a larger step)", points, maxHistoryPoints)
+	}
+	return HistorySeries{
+		Channel: name,
+		Unit:    ch.sensor.Unit(),
+		From:    from,
+		To:      to,
+		Step:    step.Seconds(),
+		Points:  sensor.Downsample(ch.history.Between(from, to), from, to, step, ch.rng),
+	}, nil
+}
+
+// channel returns the channel called name, or nil
+func (a *Agent) channel(name string) *channel {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, ch := range a.chans {
+		if ch.sensor.Name() == name {
+			return ch
+		}
+	}
+	return nil
+}
+
+// serveHistory serves /history?channel=&from=&to=&step=. Times are RFC 3339,
+// Unix seconds, or durations before now such as -15m; from defaults to an
+// hour before to, and to to now. step is a duration such as 30s or 5m.
+func (a *Agent) serveHistory(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	now := time.Now()
+	to, err := parseHistoryTime(q.Get("to"), now, now)
+	if err != nil {
+		http.Error(w, "to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	from, err := parseHistoryTime(q.Get("from"), now, to.Add(-defaultHistoryRange))
+	if err != nil {
+		http.Error(w, "from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	var step time.Duration
+	if s := q.Get("step"); s != "" {
+		if step, err = time.ParseDuration(s); err != nil || step <= 0 {
+			http.Error(w, fmt.Sprintf("step: invalid duration %q", s), http.StatusBadRequest)
+			return
+		}
+	}
+
+	name := q.Get("channel")
+	if a.channel(name) == nil {
+		http.Error(w, fmt.Sprintf("unknown channel %q", name), http.StatusNotFound)
+		return
+	}
+	series, err := a.History(name, from, to, step)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, series)
+}
+
+// parseHistoryTime parses a query time, returning def if it is empty
+func parseHistoryTime(s string, now, def time.Time) (time.Time, error) {
+	switch {
+	case s == "":
+		return def, nil
+	case strings.HasPrefix(s, "-"):
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return now.Add(d), nil
+	}
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Unix(0, int64(secs*1e9)), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
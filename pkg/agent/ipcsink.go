@@ -0,0 +1,88 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	"riscv-dev/pkg/ipc"
+	"riscv-dev/pkg/namespace"
+)
+
+// ReadingsKind is the kind of stream an IPC sink publishes
+const ReadingsKind = "readings"
+
+// DefaultReadingsSocket is where an IPC sink listens unless configured
+// otherwise, under data_dir
+const DefaultReadingsSocket = "readings.sock"
+
+// IPCSinkConfig configures an IPC sink
+type IPCSinkConfig struct {
+	// Path is the Unix socket to publish on; default readings.sock under
+	// data_dir
+	Path string `json:"path,omitempty"`
+	// Buffer is how many readings are queued for a subscriber before it
+	// misses some; default 64
+	Buffer int `json:"buffer,omitempty"`
+}
+
+// IPCSink publishes every reading on a Unix socket, for a display or a
+// network hub running as a separate process on the same board. Readings
+// go out gob-encoded (see package ipc); subscribers use SubscribeReadings.
+// With nobody subscribed a reading costs nothing.
+type IPCSink struct {
+	pub *ipc.Publisher
+}
+
+// OpenIPCSink creates the socket and starts accepting subscribers
+func OpenIPCSink(cfg IPCSinkConfig, ns namespace.Namespace) (*IPCSink, error) {
+	if cfg.Path == "" {
+		cfg.Path = DefaultReadingsSocket
+	}
+	pub, err := ipc.Listen(cfg.Path, ipc.Hello{Kind: ReadingsKind, Source: ns.Path()}, cfg.Buffer)
+	if err != nil {
+		return nil, fmt.Errorf("ipc sink: %w", err)
+	}
+	return &IPCSink{pub: pub}, nil
+}
+
+// Name identifies the sink in status and health reports
+func (s *IPCSink) Name() string { return "ipc" }
+
+// Write publishes the reading to the current subscribers
+func (s *IPCSink) Write(ctx context.Context, r Reading) error {
+	s.pub.Publish(r)
+	return nil
+}
+
+// Subscribers returns the number of processes subscribed
+func (s *IPCSink) Subscribers() int { return s.pub.Subscribers() }
+
+// Close ends the stream and removes the socket
+func (s *IPCSink) Close() error { return s.pub.Close() }
+
+// ReadingStream receives the readings an IPC sink publishes
+type ReadingStream struct {
+	sub *ipc.Subscriber
+}
+
+// SubscribeReadings connects to the IPC sink listening on path
+func SubscribeReadings(path string) (*ReadingStream, error) {
+	sub, err := ipc.Dial(path, ReadingsKind)
+	if err != nil {
+		return nil, err
+	}
+	return &ReadingStream{sub: sub}, nil
+}
+
+// Source returns the namespace path of the publishing agent
+func (s *ReadingStream) Source() string { return s.sub.Hello.Source }
+
+// Next waits for the next reading. It returns io.EOF when the agent stops.
+func (s *ReadingStream) Next() (Reading, error) {
+	var r Reading
+	err := s.sub.Receive(&r)
+	return r, err
+}
+
+// Close unsubscribes
+func (s *ReadingStream) Close() error { return s.sub.Close() }
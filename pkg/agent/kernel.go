@@ -0,0 +1,123 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+
+	"riscv-dev/pkg/kmsg"
+	"riscv-dev/pkg/metrics"
+)
+
+// KernelLogConfig watches the kernel log for hardware errors
+type KernelLogConfig struct {
+	Path string `json:"path,omitempty"` // default /dev/kmsg
+	// Rules classify further messages, checked before kmsg.DefaultRules
+	Rules []KernelRule `json:"rules,omitempty"`
+}
+
+// KernelRule raises messages matching a regular expression, at Level or
+// more severe (default warning), as kernel events of Class
+type KernelRule struct {
+	Class string `json:"class"`
+	Match string `json:"match"`
+	Level string `json:"level,omitempty"` // emerg ... debug
+}
+
+// KernelEvent is a kernel log message reporting hardware trouble
+type KernelEvent struct {
+	Time    time.Time `json:"time"`
+	Class   string    `json:"class"` // i2c, spi, thermal, power, storage, memory or a configured class
+	Level   string    `json:"level"`
+	Device  string    `json:"device,omitempty"`
+	Message string    `json:"message"`
+}
+
+var kernelEvents = metrics.NewCounter("agent_kernel_events_total", "Hardware errors reported in the kernel log", "class")
+
+// kernelLogInterval limits how often each class is logged; every event is
+// still published and counted
+const kernelLogInterval = time.Minute
+
+// rules compiles the configured rules ahead of the defaults
+func (c *KernelLogConfig) rules() ([]kmsg.Rule, error) {
+	var rules []kmsg.Rule
+	for i, r := range c.Rules {
+		if r.Class == "" {
+			return nil, fmt.Errorf("kernel_log rule %d: class is required", i)
+		}
+		re, err := regexp.Compile(r.Match)
+		if err != nil {
+			return nil, fmt.Errorf("kernel_log rule %q: %w", r.Class, err)
+		}
+		level := kmsg.LevelWarning
+		if r.Level != "" {
+			level = -1
+			for l := kmsg.LevelEmerg; l <= kmsg.LevelDebug; l++ {
+				if kmsg.LevelName(l) == r.Level {
+					level = l
+				}
+			}
+			if level < 0 {
+				return nil, fmt.Errorf("kernel_log rule %q: unknown level %q", r.Class, r.Level)
+			}
+		}
+		rules = append(rules, kmsg.Rule{Class: r.Class, Match: re, MaxLevel: level})
+	}
+	return append(rules, kmsg.DefaultRules...), nil
+}
+
+// watchKernel publishes hardware errors from the kernel log until ctx is
+// cancelled. The agent carries on without them if the log can't be read.
+func (a *Agent) watchKernel(ctx context.Context, rules []kmsg.Rule) {
+	r, err := kmsg.Open(a.cfg.KernelLog.Path, false)
+	if err != nil {
+		log.Printf("⚠️  Kernel log unavailable, hardware errors won't be reported: %v", err)
+		return
+	}
+	go func() {
+		<-ctx.Done()
+		r.Close()
+	}()
+
+	logged := make(map[string]time.Time)
+	suppressed := make(map[string]int)
+	for {
+		rec, err := r.Read()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("⚠️  Kernel log: %v", err)
+			}
+			return
+		}
+		class, ok := kmsg.Classify(rules, rec)
+		if !ok {
+			continue
+		}
+		e := &KernelEvent{
+			Time:    time.Now(), // records are read as they are logged
+			Class:   class,
+			Level:   kmsg.LevelName(rec.Level),
+			Device:  rec.Device,
+			Message: rec.Message,
+		}
+		kernelEvents.Inc(class)
+		a.events.publish(Event{Type: EventKernel, Kernel: e})
+		if class == "storage" {
+			a.nudgeDisk()
+		}
+
+		if time.Since(logged[class]) < kernelLogInterval {
+			suppressed[class]++
+			continue
+		}
+		msg := fmt.Sprintf("⚠️  Kernel %s error: %s", class, rec.Message)
+		if n := suppressed[class]; n > 0 {
+			msg += fmt.Sprintf(" (%d more since last reported)", n)
+		}
+		log.Print(msg)
+		logged[class], suppressed[class] = time.Now(), 0
+	}
+}
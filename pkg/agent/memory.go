@@ -0,0 +1,197 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"runtime/debug"
+	"time"
+
+	"riscv-dev/pkg/config"
+	"riscv-dev/pkg/metrics"
+	"riscv-dev/pkg/procstat"
+)
+
+// MemoryConfig guards the agent's memory, so on a board with little RAM
+// it sheds history and raw readings before the kernel's OOM killer ends it
+type MemoryConfig struct {
+	// Limit is the soft limit, in bytes, the Go runtime collects garbage
+	// harder to stay under (GOMEMLIMIT), and which the guard measures
+	// against; default LimitPercent of the RAM available
+	Limit int64 `json:"limit,omitempty"`
+	// LimitPercent is the share of the board's RAM, or of the cgroup's
+	// limit under one, for the default Limit (default 25)
+	LimitPercent float64 `json:"limit_percent,omitempty"`
+	// GCPercent sets GOGC, the heap growth between collections in percent;
+	// lower trades CPU for memory. Default the runtime's, 100.
+	GCPercent int             `json:"gc_percent,omitempty"`
+	Interval  config.Duration `json:"interval,omitempty"` // default 10s
+	// PressurePercent of Limit in use shrinks in-memory histories to half
+	// their size (default 80)
+	PressurePercent float64 `json:"pressure_percent,omitempty"`
+	// CriticalPercent of Limit in use shrinks them to a quarter and makes
+	// sinks keep only rollups (default 95)
+	CriticalPercent float64 `json:"critical_percent,omitempty"`
+	// RollupInterval is how often a sink sends a rollup while memory is
+	// critical; default 1m
+	RollupInterval config.Duration `json:"rollup_interval,omitempty"`
+}
+
+// memoryLevel is how short of memory the agent is
+type memoryLevel int
+
+const (
+	memoryOK memoryLevel = iota
+	memoryPressure
+	memoryCritical
+)
+
+func (l memoryLevel) String() string {
+	return [...]string{"ok", "pressure", "critical"}[l]
+}
+
+// memoryHysteresis is how many percentage points below a level's
+// threshold use must fall to leave it, so the guard doesn't flap
+const memoryHysteresis = 5
+
+var (
+	memoryLimitG = metrics.NewGauge("agent_memory_limit_bytes", "Soft memory limit the memory guard holds the agent to")
+	memoryUsed   = metrics.NewGauge("agent_memory_used_bytes", "Resident memory of the agent not backed by files")
+	memoryLevelG = metrics.NewGauge("agent_memory_level", "Memory pressure: 0 ok, 1 histories shrunk, 2 keeping only rollups")
+)
+
+// memoryLimit returns the limit to hold the agent to, and describes how it
+// was chosen
+func (mc MemoryConfig) memoryLimit() (int64, string) {
+	if mc.Limit > 0 {
+		return mc.Limit, "configured"
+	}
+	avail := procstat.MemoryLimit()
+	if avail <= 0 {
+		return 0, "RAM unknown"
+	}
+	pct := mc.LimitPercent
+	if pct <= 0 {
+		pct = 25
+	}
+	return int64(float64(avail) * pct / 100), fmt.Sprintf("%g%% of %s available", pct, mib(avail))
+}
+
+func mib(n int64) string { return fmt.Sprintf("%.0f MiB", float64(n)/(1<<20)) }
+
+// guardMemory sets the runtime's memory limit and GOGC, then checks the
+// agent's memory every interval until ctx is cancelled. On entering
+// pressure, and again on entering critical, it shrinks in-memory histories
+// and returns freed memory to the kernel; while critical sinks keep only
+// rollups. Everything returns to normal once use falls back.
+func (a *Agent) guardMemory(ctx context.Context) {
+	mc := *a.cfg.Memory
+	if mc.Interval <= 0 {
+		mc.Interval = config.Duration(10 * time.Second)
+	}
+	if mc.PressurePercent <= 0 {
+		mc.PressurePercent = 80
+	}
+	if mc.CriticalPercent <= 0 {
+		mc.CriticalPercent = 95
+	}
+	if mc.RollupInterval <= 0 {
+		mc.RollupInterval = config.Duration(time.Minute)
+	}
+	limit, how := mc.memoryLimit()
+	if limit <= 0 {
+		log.Printf("⚠️  Memory guard disabled: %s; set memory.limit", how)
+		return
+	}
+	// GOMEMLIMIT in the environment wins over a default limit, as it
+	// would without the guard
+	if env := os.Getenv("GOMEMLIMIT"); env != "" && mc.Limit <= 0 {
+		limit, how = debug.SetMemoryLimit(-1), "GOMEMLIMIT"
+	} else {
+		debug.SetMemoryLimit(limit)
+	}
+	gc := "GOGC unchanged"
+	if mc.GCPercent > 0 {
+		debug.SetGCPercent(mc.GCPercent)
+		gc = fmt.Sprintf("GOGC %d", mc.GCPercent)
+	}
+	memoryLimitG.Set(float64(limit))
+	log.Printf("🧠 Memory limit %s (%s), %s", mib(limit), how, gc)
+
+	a.health.Register("memory", func(ctx context.Context) error {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if a.memory == memoryCritical {
+			return fmt.Errorf("memory nearly exhausted, keeping only rollups")
+		}
+		return nil
+	})
+
+	t := time.NewTicker(mc.Interval.D())
+	defer t.Stop()
+	level := memoryOK
+	for {
+		if used := procstat.Read().RSSAnonBytes; used >= 0 {
+			level = a.checkMemory(mc, limit, used, level)
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// checkMemory acts on the memory in use and returns the new level
+func (a *Agent) checkMemory(mc MemoryConfig, limit, used int64, prev memoryLevel) memoryLevel {
+	memoryUsed.Set(float64(used))
+	pct := 100 * float64(used) / float64(limit)
+	level := prev
+	switch {
+	case pct >= mc.CriticalPercent:
+		level = memoryCritical
+	case pct >= mc.PressurePercent:
+		level = max(level, memoryPressure)
+	}
+	// Stepping down takes use clear of the threshold
+	if level == memoryCritical && pct < mc.CriticalPercent-memoryHysteresis {
+		level = memoryPressure
+	}
+	if level == memoryPressure && pct < mc.PressurePercent-memoryHysteresis {
+		level = memoryOK
+	}
+	memoryLevelG.Set(float64(level))
+
+	a.mu.Lock()
+	chans, sinks := a.chans, a.sinks
+	a.memory = level
+	a.mu.Unlock()
+	if level != prev {
+		full := a.cfg.historySize()
+		size := [...]int{full, full / 2, full / 4}[level]
+		freed := 0
+		for _, ch := range chans {
+			freed += ch.history.Resize(size)
+		}
+		if level > prev {
+			debug.FreeOSMemory()
+		}
+		switch level {
+		case memoryCritical:
+			log.Printf("❌ Memory: %s of %s in use, histories cut to %d samples and sinks keep only rollups every %v", mib(used), mib(limit), size, mc.RollupInterval.D())
+		case memoryPressure:
+			log.Printf("⚠️  Memory: %s of %s in use, histories cut to %d samples (%s freed)", mib(used), mib(limit), size, mib(int64(freed)))
+		default:
+			log.Printf("✅ Memory: %s of %s in use, histories back to %d samples", mib(used), mib(limit), size)
+		}
+		for _, w := range sinks {
+			if level == memoryCritical {
+				w.shedForMemory(mc.RollupInterval.D())
+			} else if prev == memoryCritical {
+				w.shedForMemory(0)
+			}
+		}
+	}
+	return level
+}
@@ -0,0 +1,209 @@
+//go:build !no_notify && !minimal
+
+package agent
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"riscv-dev/pkg/buildinfo"
+	"riscv-dev/pkg/metrics"
+	"riscv-dev/pkg/notify"
+	"riscv-dev/pkg/schedule"
+	"riscv-dev/pkg/sensor"
+)
+
+func init() { buildinfo.AddFeature("notify") }
+
+var notificationsDropped = metrics.NewCounter("agent_notifications_dropped_total", "Alerts not queued for notification because the queue was full", "notifier")
+
+// notifier turns events into messages for a notify.Notifier
+type notifier struct {
+	cfg       NotifyConfig
+	channels  map[string]bool // nil for every channel
+	qualities map[sensor.Quality]bool
+	urgent    map[sensor.Quality]bool
+	out       *notify.Notifier
+}
+
+// addNotifications sets up the configured notifications, which start with
+// Run
+func (a *Agent) addNotifications(cfg Config) error {
+	names := make(map[string]bool)
+	for _, nc := range cfg.Notifications {
+		if nc.Name == "" {
+			nc.Name = "sms"
+			if nc.Email != nil {
+				nc.Name = "email"
+			}
+		}
+		var sender notify.Sender
+		var err error
+		control, _ := a.dialControl("", nil)
+		dial := SinkConfig{StaticHosts: cfg.StaticHosts, Control: control}.Dial()
+		switch {
+		case (nc.Email == nil) == (nc.SMS == nil):
+			err = fmt.Errorf("set email or sms")
+		case nc.Email != nil:
+			var tlsCfg *tls.Config
+			if tlsCfg, err = nc.TLS.Merge(cfg.TLS).Client(nc.Email.Server); err == nil {
+				sender, err = notify.NewEmail(*nc.Email, tlsCfg, notify.DialFunc(dial))
+			}
+		case (nc.SMS.Modem == nil) == (nc.SMS.Gateway == nil):
+			err = fmt.Errorf("set sms modem or gateway")
+		case nc.SMS.Modem != nil:
+			sender, err = notify.NewModem(*nc.SMS.Modem, nc.SMS.To)
+		default:
+			var tlsCfg *tls.Config
+			if tlsCfg, err = nc.TLS.Merge(cfg.TLS).Client(nc.SMS.Gateway.URL); err == nil {
+				sender, err = notify.NewGateway(*nc.SMS.Gateway, nc.SMS.To, tlsCfg, notify.DialFunc(dial))
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("notifications %s: %w", nc.Name, err)
+		}
+		if names[nc.Name] {
+			return fmt.Errorf("notifications %s: duplicate name", nc.Name)
+		}
+		names[nc.Name] = true
+
+		policy := notify.Policy{MaxPerHour: nc.MaxPerHour}
+		if nc.QuietHours != "" {
+			if policy.Quiet, err = schedule.ParseAt(nc.QuietHours, cfg.Coordinates); err != nil {
+				return fmt.Errorf("notifications %s: quiet_hours: %w", nc.Name, err)
+			}
+		}
+		n := &notifier{cfg: nc, qualities: make(map[sensor.Quality]bool), urgent: make(map[sensor.Quality]bool), out: notify.New(nc.Name, sender, policy)}
+		if len(nc.Channels) > 0 {
+			n.channels = make(map[string]bool)
+			for _, name := range nc.Channels {
+				if a.channel(name) == nil {
+					return fmt.Errorf("notifications %s: unknown channel %q", nc.Name, name)
+				}
+				n.channels[name] = true
+			}
+		}
+		qualities := nc.Qualities
+		if len(qualities) == 0 {
+			qualities = defaultSignalQualities
+		}
+		for _, q := range qualities {
+			n.qualities[q] = true
+		}
+		for _, q := range nc.Urgent {
+			n.urgent[q] = true
+		}
+		a.notifiers = append(a.notifiers, n)
+	}
+	return nil
+}
+
+// runNotifications sends the notifications for events until ctx ends.
+// Like the actuators, a board standing by leaves them to the leader.
+func (a *Agent) runNotifications(ctx context.Context, events <-chan Event, cancel func()) {
+	defer cancel()
+	for _, n := range a.notifiers {
+		go n.out.Run(ctx)
+	}
+	source := a.ns.Path()
+	if source == "" {
+		source, _ = os.Hostname()
+	}
+	for {
+		select {
+		case e := <-events:
+			if !a.Leader() {
+				continue
+			}
+			for _, n := range a.notifiers {
+				m, ok := a.notification(n, e)
+				if !ok {
+					continue
+				}
+				m.Source = source
+				if !n.out.Notify(m) {
+					notificationsDropped.Inc(n.cfg.Name)
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// notification returns the message n sends for e, if any
+func (a *Agent) notification(n *notifier, e Event) (notify.Message, bool) {
+	switch {
+	case e.Alert != nil:
+		al := e.Alert
+		if n.channels != nil && !n.channels[al.Channel] {
+			break
+		}
+		m := notify.Message{Time: al.Time, Urgent: n.urgent[al.Quality]}
+		switch {
+		case n.qualities[al.Quality]:
+			m.Subject = fmt.Sprintf("%s %s", al.Channel, al.Quality)
+		case al.Quality == sensor.OK && n.qualities[al.Previous] && n.cfg.Recoveries:
+			m.Subject = fmt.Sprintf("%s ok again", al.Channel)
+		default:
+			return m, false
+		}
+		value := "none"
+		if !math.IsNaN(al.Value) {
+			value = a.formatValue(al.Channel, al.Value)
+			m.Subject += " at " + value
+		}
+		m.Body = fmt.Sprintf("Channel: %s\nQuality: %s (was %s)\nValue: %s\nTime: %s\n",
+			al.Channel, al.Quality, al.Previous, value, al.Time.Format(time.RFC1123))
+		return m, true
+	case e.Forecast != nil && n.cfg.Forecasts:
+		f := e.Forecast
+		if n.channels != nil && !n.channels[f.Channel] {
+			break
+		}
+		threshold := a.formatValue(f.Channel, f.Threshold)
+		m := notify.Message{Time: f.Time}
+		switch {
+		case f.Active:
+			in := time.Duration(*f.In * float64(time.Second)).Round(time.Second)
+			m.Subject = fmt.Sprintf("%s forecast to go %s %s in %v", f.Channel, f.Direction, threshold, in)
+		case n.cfg.Recoveries:
+			m.Subject = fmt.Sprintf("%s no longer forecast to go %s %s", f.Channel, f.Direction, threshold)
+		default:
+			return m, false
+		}
+		m.Body = fmt.Sprintf("Channel: %s\nNow: %s\nTime: %s\n", f.Channel, a.formatValue(f.Channel, f.Value), f.Time.Format(time.RFC1123))
+		return m, true
+	case e.Disk != nil && n.cfg.Disk:
+		d := e.Disk
+		subject := fmt.Sprintf("storage %s: %s", d.Severity, d.Message)
+		body := fmt.Sprintf("Device: %s\nMount: %s\nKind: %s\nTime: %s\n", d.Device, d.Mount, d.Kind, d.Time.Format(time.RFC1123))
+		return notify.Message{Time: d.Time, Subject: subject, Body: body, Urgent: d.Severity == "critical"}, true
+	case e.Thermal != nil && n.cfg.Thermal:
+		th := e.Thermal
+		if !th.Throttled && !n.cfg.Recoveries {
+			break
+		}
+		subject := th.Message
+		if th.Throttled {
+			subject = "throttled: " + subject
+		}
+		body := fmt.Sprintf("Temperature: %.1f°C\nTime: %s\n", th.Celsius, th.Time.Format(time.RFC1123))
+		return notify.Message{Time: th.Time, Subject: subject, Body: body}, true
+	}
+	return notify.Message{}, false
+}
+
+// formatValue formats a channel's value with its unit for people
+func (a *Agent) formatValue(name string, v float64) string {
+	ch := a.channel(name)
+	if ch == nil {
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return a.display.Value(v, ch.sensor.Unit(), displayPrecision(ch.meta))
+}
@@ -0,0 +1,22 @@
+//go:build no_notify || minimal
+
+package agent
+
+import (
+	"context"
+	"errors"
+)
+
+// notifier is left out with notifications
+type notifier struct{}
+
+// addNotifications fails if notifications are configured: sending email
+// and SMS is left out of binaries built with -tags no_notify or minimal
+func (a *Agent) addNotifications(cfg Config) error {
+	if len(cfg.Notifications) > 0 {
+		return errors.New("notifications: this agent was built without them (-tags no_notify or minimal)")
+	}
+	return nil
+}
+
+func (a *Agent) runNotifications(ctx context.Context, events <-chan Event, cancel func()) { cancel() }
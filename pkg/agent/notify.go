@@ -0,0 +1,55 @@
+package agent
+
+import (
+	"riscv-dev/pkg/modem"
+	"riscv-dev/pkg/notify"
+	"riscv-dev/pkg/sensor"
+	"riscv-dev/pkg/tlsconfig"
+)
+
+// NotifyConfig sends alerts to people by email or SMS, for deployments
+// without a dashboard anyone watches. Each sends channel alerts, and
+// optionally forecast, storage health and thermal alerts, within a rate limit and
+// outside quiet hours. Binaries built with -tags no_notify or minimal leave
+// notifications out (see notifier.go).
+type NotifyConfig struct {
+	// Name identifies the notifications in the log and on /metrics;
+	// default "email" or "sms"
+	Name  string              `json:"name,omitempty"`
+	Email *notify.EmailConfig `json:"email,omitempty"`
+	SMS   *SMSConfig          `json:"sms,omitempty"`
+	// Channels are those whose alerts are sent; default every channel
+	Channels []string `json:"channels,omitempty"`
+	// Qualities are those alerted; default out-of-range, saturated and
+	// sensor-fault
+	Qualities []sensor.Quality `json:"qualities,omitempty"`
+	// Recoveries sends when an alerted channel is OK again, and a forecast
+	// alert ends
+	Recoveries bool `json:"recoveries,omitempty"`
+	// Forecasts, Disk and Thermal send forecast, storage health and CPU
+	// throttling alerts too
+	Forecasts bool `json:"forecasts,omitempty"`
+	Disk      bool `json:"disk,omitempty"`
+	Thermal   bool `json:"thermal,omitempty"`
+	// MaxPerHour bounds the messages sent in any hour; the rest go out
+	// together when the hour allows. Default 6.
+	MaxPerHour int `json:"max_per_hour,omitempty"`
+	// QuietHours holds alerts while it matches, e.g. "22:00-07:00" (see
+	// package schedule), to send them together when it ends
+	QuietHours string `json:"quiet_hours,omitempty"`
+	// Urgent are qualities alerted even in quiet hours, e.g.
+	// ["sensor-fault"]; critical storage alerts always are
+	Urgent []sensor.Quality `json:"urgent,omitempty"`
+	// TLS overrides fields of the agent's shared tls block for the SMTP
+	// server or SMS gateway
+	TLS *tlsconfig.Config `json:"tls,omitempty"`
+}
+
+// SMSConfig texts alerts through a cellular modem or an HTTP gateway
+type SMSConfig struct {
+	// To are the recipients' numbers in international format, e.g.
+	// "+4915112345678"
+	To      []string              `json:"to"`
+	Modem   *modem.Config         `json:"modem,omitempty"`
+	Gateway *notify.GatewayConfig `json:"gateway,omitempty"`
+}
@@ -0,0 +1,166 @@
+package agent
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"riscv-dev/pkg/config"
+	"riscv-dev/pkg/metrics"
+	"riscv-dev/pkg/sensor"
+)
+
+// PercentileConfig tracks the distribution of a channel over sliding
+// windows in histograms, for its p50, p95 and p99: on a noisy channel the
+// mean hides how often, and how far, it strays
+type PercentileConfig struct {
+	// Channel is the channel to track, or "*" for every channel
+	Channel string `json:"channel"`
+	// Windows are the periods to track, e.g. ["1m", "1h", "24h"]; default
+	// 1m and 1h. Windows may be longer than history_duration: a
+	// histogram's size depends on the spread of the values, not their
+	// number.
+	Windows []config.Duration `json:"windows,omitempty"`
+	// RelativeError is the accuracy of the percentiles as a fraction of
+	// the value; default 0.001, e.g. ±0.1 kPa at 101 kPa
+	RelativeError float64 `json:"relative_error,omitempty"`
+}
+
+// percentileSlots is how many slots a window's histogram expires in
+const percentileSlots = 12
+
+// percentileQuantiles are the quantiles reported
+var percentileQuantiles = []float64{0.5, 0.95, 0.99}
+
+var valueDistribution = metrics.NewSummary("sensor_value_distribution", "Distribution of a channel's values over a sliding window", "sensor", "window")
+
+// addPercentiles attaches the percentile histograms to their channels
+func (a *Agent) addPercentiles(cfg Config) error {
+	for _, pc := range cfg.Percentiles {
+		windows := pc.Windows
+		if len(windows) == 0 {
+			windows = []config.Duration{config.Duration(time.Minute), config.Duration(time.Hour)}
+		}
+		for _, w := range windows {
+			if w <= 0 {
+				return fmt.Errorf("percentiles for %s: windows must be positive", pc.Channel)
+			}
+		}
+		if pc.RelativeError < 0 || pc.RelativeError >= 1 {
+			return fmt.Errorf("percentiles for %s: relative_error must be between 0 and 1", pc.Channel)
+		}
+		var chans []*channel
+		if pc.Channel == "*" {
+			a.mu.Lock()
+			chans = a.chans
+			a.mu.Unlock()
+		} else if ch := a.channel(pc.Channel); ch != nil {
+			chans = []*channel{ch}
+		} else {
+			return fmt.Errorf("percentiles: unknown channel %q", pc.Channel)
+		}
+		a.mu.Lock()
+		for _, ch := range chans {
+			for _, w := range windows {
+				if ch.percentile(w.D()) == nil {
+					ch.percentiles = append(ch.percentiles, sensor.NewWindowedHistogram(w.D(), percentileSlots, pc.RelativeError))
+				}
+			}
+		}
+		a.mu.Unlock()
+	}
+	return nil
+}
+
+// percentile returns the channel's histogram over window, nil if none
+func (ch *channel) percentile(window time.Duration) *sensor.WindowedHistogram {
+	for _, h := range ch.percentiles {
+		if h.Window() == window {
+			return h
+		}
+	}
+	return nil
+}
+
+// trackPercentiles adds a sample to the channel's histograms, unless its
+// range leaves it out of statistics, and updates the Prometheus summaries
+// at most once a second
+func (ch *channel) trackPercentiles(name string, s sensor.Sample, rng sensor.Range) {
+	if len(ch.percentiles) == 0 {
+		return
+	}
+	if !rng.Excluded(s.Quality) && !math.IsNaN(s.Value) {
+		for _, h := range ch.percentiles {
+			h.Add(s.Time, s.Value)
+		}
+	}
+	if s.Time.Sub(ch.percentilesAt) < time.Second {
+		return
+	}
+	ch.percentilesAt = s.Time
+	for _, h := range ch.percentiles {
+		snap := h.Snapshot(s.Time)
+		if snap.Count() == 0 {
+			continue
+		}
+		quantiles := make(map[float64]float64, len(percentileQuantiles))
+		for _, q := range percentileQuantiles {
+			quantiles[q] = snap.Quantile(q)
+		}
+		valueDistribution.Set(quantiles, snap.Count(), snap.Sum(), name, windowLabel(h.Window()))
+	}
+}
+
+// windowLabel formats a window as people write it: "1h" rather than
+// "1h0m0s"
+func windowLabel(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
+
+// ChannelStats is a channel's statistics, as served on /stats
+type ChannelStats struct {
+	Channel string `json:"channel"`
+	sensor.Stats
+}
+
+// serveStats serves /stats?channel=&window=: statistics with percentiles
+// of one channel, or of every channel without channel. window is a
+// duration such as 1m (the default) or 24h.
+func (a *Agent) serveStats(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	window := time.Minute
+	if s := q.Get("window"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			http.Error(w, fmt.Sprintf("window: invalid duration %q", s), http.StatusBadRequest)
+			return
+		}
+		window = d
+	}
+	names := a.Sensors()
+	if name := q.Get("channel"); name != "" {
+		if a.channel(name) == nil {
+			http.Error(w, fmt.Sprintf("unknown channel %q", name), http.StatusNotFound)
+			return
+		}
+		names = []string{name}
+	}
+	stats := make([]ChannelStats, 0, len(names))
+	for _, name := range names {
+		st, err := a.GetStats(name, window)
+		if err != nil {
+			continue // removed meanwhile
+		}
+		stats = append(stats, ChannelStats{Channel: name, Stats: st})
+	}
+	writeJSON(w, stats)
+}
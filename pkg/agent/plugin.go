@@ -0,0 +1,34 @@
+//go:build plugins
+
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"plugin"
+
+	"riscv-dev/pkg/buildinfo"
+)
+
+func init() { buildinfo.AddFeature("plugins") }
+
+// loadPlugin opens a driver plugin and creates its sensors
+func loadPlugin(path string, options json.RawMessage) ([]Sensor, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := p.Lookup(PluginSymbol)
+	if err != nil {
+		return nil, err
+	}
+	f, ok := sym.(PluginFunc)
+	if !ok {
+		if pf, ok := sym.(*PluginFunc); ok {
+			f = *pf
+		} else {
+			return nil, fmt.Errorf("%s is a %T, not a %T", PluginSymbol, sym, f)
+		}
+	}
+	return f(options)
+}
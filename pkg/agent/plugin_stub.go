@@ -0,0 +1,14 @@
+//go:build !plugins
+
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// loadPlugin fails: loading Go plugins links the agent dynamically, so it
+// is left out unless built with -tags plugins
+func loadPlugin(path string, options json.RawMessage) ([]Sensor, error) {
+	return nil, errors.New("this agent was built without Go plugin support (build with -tags plugins and CGO_ENABLED=1), use a subprocess adapter instead")
+}
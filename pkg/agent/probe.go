@@ -0,0 +1,26 @@
+package agent
+
+import (
+	"riscv-dev/pkg/config"
+	"riscv-dev/pkg/netprobe"
+	"riscv-dev/pkg/sensor"
+)
+
+// ProbeConfig measures a network path from the board (see package
+// netprobe) as two channels, <name>_rtt in milliseconds and <name>_loss
+// in percent, so a remote site's connection is recorded beside its
+// sensors
+//
+//	"probes": [{"name": "hub", "type": "icmp", "target": "hub.example.com", "interval": "1m"}]
+type ProbeConfig struct {
+	// Name prefixes the channels; default net_<target>
+	Name string `json:"name,omitempty"`
+	netprobe.Config
+	// Interval between rounds; default 30s
+	Interval config.Duration `json:"interval,omitempty"`
+	// Interfaces are the network interfaces to probe through, in order
+	// of preference as for sinks; default the uplink in use
+	Interfaces []string     `json:"interfaces,omitempty"`
+	RTTRange   sensor.Range `json:"rtt_range,omitempty"`
+	LossRange  sensor.Range `json:"loss_range,omitempty"`
+}
@@ -0,0 +1,167 @@
+//go:build !no_probes && !minimal
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"riscv-dev/pkg/buildinfo"
+	"riscv-dev/pkg/netprobe"
+	"riscv-dev/pkg/sensor"
+)
+
+func init() { buildinfo.AddFeature("probes") }
+
+const defaultProbeInterval = 30 * time.Second
+
+// addProbes starts the network probes and adds their channels
+func (a *Agent) addProbes(cfg Config) error {
+	for _, pc := range cfg.Probes {
+		if pc.Name == "" {
+			pc.Name = "net_" + strings.Map(func(r rune) rune {
+				if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+					return r
+				}
+				return '_'
+			}, pc.Target)
+		}
+		control, err := a.dialControl("probe "+pc.Name, pc.Interfaces)
+		if err != nil {
+			return fmt.Errorf("probe %s: %w", pc.Name, err)
+		}
+		opts := netprobe.Options{Control: control}
+		if pc.Type == "http" {
+			if opts.TLS, err = a.cfg.TLS.Client(pc.Target); err != nil {
+				return fmt.Errorf("probe %s: %w", pc.Name, err)
+			}
+		}
+		prober, err := netprobe.New(pc.Config, opts)
+		if err != nil {
+			return fmt.Errorf("probe %s: %w", pc.Name, err)
+		}
+		p := newNetProbe(pc, prober)
+		rtt := &probeSensor{name: pc.Name + "_rtt", unit: "ms", probe: p}
+		loss := &probeSensor{name: pc.Name + "_loss", unit: "%", probe: p, loss: true}
+		if err := a.AddSensor(rtt, pc.RTTRange); err != nil {
+			p.close()
+			return err
+		}
+		if err := a.AddSensor(loss, pc.LossRange); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// netProbe runs rounds of probes in the background, since a round takes
+// seconds, keeping the last result for its channels. It logs the path
+// going down and coming back.
+type netProbe struct {
+	name     string
+	prober   *netprobe.Prober
+	interval time.Duration
+	stop     context.CancelFunc
+	done     chan struct{}
+	once     sync.Once
+
+	mu   sync.Mutex
+	last netprobe.Result
+	at   time.Time // of last, zero before the first round
+}
+
+func newNetProbe(cfg ProbeConfig, prober *netprobe.Prober) *netProbe {
+	p := &netProbe{name: cfg.Name, prober: prober, interval: cfg.Interval.D(), done: make(chan struct{})}
+	if p.interval <= 0 {
+		p.interval = defaultProbeInterval
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p.stop = cancel
+	go p.run(ctx)
+	return p
+}
+
+func (p *netProbe) run(ctx context.Context) {
+	defer close(p.done)
+	t := time.NewTicker(p.interval)
+	defer t.Stop()
+	for {
+		r := p.prober.Round(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		p.update(r)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// update keeps r, logging when every probe of a round is lost and when
+// answers come back
+func (p *netProbe) update(r netprobe.Result) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	down, wasDown := r.Received == 0, !p.at.IsZero() && p.last.Received == 0
+	switch {
+	case down && !wasDown:
+		log.Printf("⚠️  Probe %s: %s unreachable: %v", p.name, p.prober.Target(), r.Err)
+	case !down && wasDown:
+		log.Printf("✅ Probe %s: %s answering again (%.1f ms)", p.name, p.prober.Target(), ms(r.Mean))
+	}
+	p.last, p.at = r, time.Now()
+}
+
+func (p *netProbe) close() {
+	p.once.Do(func() {
+		p.stop()
+		<-p.done
+	})
+}
+
+func ms(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+
+// probeSensor reports a probe's mean round-trip time or its loss. The
+// time is a fault while every probe is lost, and both are stale once the
+// rounds stop completing.
+type probeSensor struct {
+	name, unit string
+	probe      *netProbe
+	loss       bool
+}
+
+func (s *probeSensor) Name() string { return s.name }
+func (s *probeSensor) Unit() string { return s.unit }
+
+func (s *probeSensor) Read(ctx context.Context) (float64, sensor.Quality, error) {
+	p := s.probe
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.at.IsZero() {
+		return math.NaN(), sensor.Fault, nil
+	}
+	v := p.last.Loss()
+	if !s.loss {
+		if p.last.Received == 0 {
+			return math.NaN(), sensor.Fault, nil
+		}
+		v = ms(p.last.Mean)
+	}
+	if time.Since(p.at) > 3*p.interval {
+		return v, sensor.Stale, nil
+	}
+	return v, sensor.OK, nil
+}
+
+// Close stops the probe, for either of its channels
+func (s *probeSensor) Close() error {
+	s.probe.close()
+	return nil
+}
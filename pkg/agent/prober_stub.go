@@ -0,0 +1,14 @@
+//go:build no_probes || minimal
+
+package agent
+
+import "errors"
+
+// addProbes fails if probes are configured: network probes are left out of
+// binaries built with -tags no_probes or minimal
+func (a *Agent) addProbes(cfg Config) error {
+	if len(cfg.Probes) > 0 {
+		return errors.New("probes: this agent was built without them (-tags no_probes or minimal)")
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"riscv-dev/pkg/audit"
+	"riscv-dev/pkg/config"
+	"riscv-dev/pkg/hal"
+	"riscv-dev/pkg/sensor"
+	"riscv-dev/pkg/usbgadget"
+)
+
+// ProvisioningConfig offers the configuration for replacing over a USB
+// gadget serial port, so a board plugged into a laptop can be set up
+// before it has a network (see riscv-dev usb provision)
+type ProvisioningConfig struct {
+	// Port is the serial port, default "auto": the first gadget port,
+	// /dev/ttyGS0 once the gadget is set up
+	Port string `json:"port,omitempty"`
+	// Token, if set, must accompany every request
+	Token string `json:"token,omitempty"`
+	// ConfigPath is the configuration file offered as "config", default
+	// $RISCV_DEV_CONFIG or config.json
+	ConfigPath string `json:"config_path,omitempty"`
+}
+
+// provisionRetry is how often a missing or unplugged port is tried again
+const provisionRetry = 5 * time.Second
+
+// provisioner offers the configuration and, if set, the calibration file.
+// New contents take effect on the next start.
+func (a *Agent) provisioner() *usbgadget.Provisioner {
+	pc := a.cfg.Provisioning
+	path := pc.ConfigPath
+	if path == "" {
+		path = os.Getenv(config.EnvPath)
+	}
+	if path == "" {
+		path = "config.json"
+	}
+	changed := func(name, path string) func() {
+		return func() {
+			log.Printf("🔌 %s replaced over USB; restart to apply", path)
+			a.audit.Record(audit.WithActor(context.Background(), "usb"), audit.Entry{Action: name + ".provision", Target: path, New: "replaced"})
+		}
+	}
+	files := map[string]usbgadget.ProvisionFile{
+		"config": {
+			Path: path,
+			Validate: func(data []byte) error {
+				var cfg Config
+				return json.Unmarshal(data, &cfg)
+			},
+			Changed: changed("config", path),
+		},
+	}
+	if a.cfg.CalibrationFile != "" {
+		files["calibration"] = usbgadget.ProvisionFile{
+			Path: a.cfg.CalibrationFile,
+			Validate: func(data []byte) error {
+				_, err := sensor.ParseCalibration(data, "upload")
+				return err
+			},
+			Changed: changed("calibration", a.cfg.CalibrationFile),
+		}
+	}
+	return &usbgadget.Provisioner{Files: files, Info: a.provisionInfo, Token: pc.Token}
+}
+
+// provisionInfo tells the host what board it reached and how else to
+// reach it, by the addresses on the gadget's network link
+func (a *Agent) provisionInfo() map[string]string {
+	info := map[string]string{"channels": fmt.Sprint(len(a.Channels()))}
+	if host, err := os.Hostname(); err == nil {
+		info["hostname"] = host
+	}
+	if a.deviceID != "" {
+		info["device_id"] = a.deviceID
+	}
+	if a.cfg.MetricsAddr != "" {
+		info["metrics_addr"] = a.cfg.MetricsAddr
+	}
+	st, err := usbgadget.Detect("/")
+	if err != nil {
+		return info
+	}
+	for _, name := range st.Net {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			continue
+		}
+		addrs, _ := iface.Addrs()
+		var list []string
+		for _, addr := range addrs {
+			list = append(list, addr.String())
+		}
+		info["net."+name] = strings.Join(list, " ")
+	}
+	return info
+}
+
+// serveProvisioning answers provisioning requests on the gadget serial
+// port until ctx is cancelled, reopening the port whenever the cable is
+// unplugged or the gadget set up again
+func (a *Agent) serveProvisioning(ctx context.Context) {
+	p := a.provisioner()
+	var lastErr string
+	report := func(err error) {
+		if err.Error() != lastErr {
+			log.Printf("⚠️  USB provisioning: %v; retrying every %v", err, provisionRetry)
+			lastErr = err.Error()
+		}
+	}
+	for {
+		if err := a.provisionOnce(ctx, p); err != nil && ctx.Err() == nil {
+			report(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(provisionRetry):
+		}
+	}
+}
+
+func (a *Agent) provisionOnce(ctx context.Context, p *usbgadget.Provisioner) error {
+	port := a.cfg.Provisioning.Port
+	if port == "" || port == "auto" {
+		st, err := usbgadget.Detect("/")
+		if err != nil {
+			return err
+		}
+		if len(st.Serial) == 0 {
+			return errors.New("no gadget serial port (see riscv-dev usb setup)")
+		}
+		port = st.Serial[0]
+	}
+	u, err := hal.OpenUART(port, 115200)
+	if err != nil {
+		return err
+	}
+	defer u.Close()
+	stop := context.AfterFunc(ctx, func() { u.Close() })
+	defer stop()
+	log.Printf("🔌 Provisioning on %s", port)
+	return p.Serve(ctx, u)
+}
@@ -0,0 +1,280 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"riscv-dev/pkg/config"
+	"riscv-dev/pkg/hal"
+	"riscv-dev/pkg/sensor"
+	"riscv-dev/pkg/state"
+)
+
+// PulseConfig counts edges on a GPIO input, e.g. from an S0 energy meter,
+// a flow meter or an anemometer. The channel reports the pulse rate over
+// each sample interval; TotalName adds a channel with the running total.
+type PulseConfig struct {
+	Name string `json:"name"`
+	Pin  int    `json:"pin"`
+	Edge string `json:"edge,omitempty"` // rising (default), falling or both
+	Unit string `json:"unit"`
+	// PerPulse is what one pulse stands for, e.g. 0.001 (kWh) for a meter
+	// giving 1000 impulses per kWh; default 1
+	PerPulse float64 `json:"per_pulse,omitempty"`
+	// Per is the time unit of the rate: with PerPulse in kWh, "1h" gives kW.
+	// Default 1s.
+	Per config.Duration `json:"per,omitempty"`
+	// Debounce ignores edges sooner than this after the last one counted,
+	// for mechanical contacts such as reed switches
+	Debounce  config.Duration `json:"debounce,omitempty"`
+	TotalName string          `json:"total_name,omitempty"`
+	TotalUnit string          `json:"total_unit,omitempty"`
+	sensor.Range
+}
+
+// pulseCounter counts edges on one pin in the background. The count is a
+// 64-bit total, kept in the state file, or else saved under history_dir,
+// so it carries on after a restart.
+type pulseCounter struct {
+	cfg    PulseConfig
+	count  atomic.Uint64
+	failed atomic.Bool // the edge stream ended
+	stop   context.CancelFunc
+	done   chan struct{}
+
+	mu        sync.Mutex // guards the fields below, used by Read
+	prevCount uint64
+	prevTime  time.Time
+	state     *state.Store // nil saves to statePath
+	statePath string       // empty keeps the count in memory only
+	saved     time.Time
+}
+
+// pulseSaveInterval bounds how often the count is written, to spare flash
+const pulseSaveInterval = time.Minute
+
+type pulseState struct {
+	Count uint64    `json:"count"`
+	Time  time.Time `json:"time"`
+}
+
+func parseEdge(s string) (hal.Edge, error) {
+	switch s {
+	case "", "rising":
+		return hal.RisingEdge, nil
+	case "falling":
+		return hal.FallingEdge, nil
+	case "both":
+		return hal.BothEdges, nil
+	}
+	return 0, fmt.Errorf("unknown edge %q (want rising, falling or both)", s)
+}
+
+// pulseStateKey is the key of a pulse count in the state file
+func pulseStateKey(name string) string { return "pulses." + name }
+
+// newPulseCounter restores the saved count, if any, and starts counting. A
+// count saved under stateDir by an agent without a state file is taken
+// over by the state file.
+func newPulseCounter(cfg PulseConfig, gpio hal.GPIOController, store *state.Store, stateDir string) (*pulseCounter, error) {
+	edge, err := parseEdge(cfg.Edge)
+	if err != nil {
+		return nil, fmt.Errorf("pulse counter %s: %w", cfg.Name, err)
+	}
+	w, ok := gpio.(hal.EdgeWatcher)
+	if !ok {
+		return nil, fmt.Errorf("pulse counter %s: GPIO backend cannot watch edges", cfg.Name)
+	}
+	if cfg.PerPulse == 0 {
+		cfg.PerPulse = 1
+	}
+	p := &pulseCounter{cfg: cfg, done: make(chan struct{}), state: store}
+	if store != nil {
+		if _, ok := store.Get(pulseStateKey(cfg.Name)); ok {
+			p.count.Store(store.Counter(pulseStateKey(cfg.Name)))
+			log.Printf("%s continues from %d pulses", cfg.Name, p.count.Load())
+			stateDir = ""
+		}
+	}
+	if stateDir != "" {
+		p.statePath = filepath.Join(stateDir, cfg.Name+".pulses")
+		if b, err := os.ReadFile(p.statePath); err == nil {
+			var st pulseState
+			if err := json.Unmarshal(b, &st); err != nil {
+				return nil, fmt.Errorf("pulse counter %s: %s: %w", cfg.Name, p.statePath, err)
+			}
+			p.count.Store(st.Count)
+			log.Printf("%s continues from %d pulses", cfg.Name, st.Count)
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := w.WatchEdges(ctx, cfg.Pin, edge)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("pulse counter %s: %w", cfg.Name, err)
+	}
+	p.stop = cancel
+	p.prevCount, p.prevTime = p.count.Load(), time.Now()
+	go p.watch(ctx, events)
+	return p, nil
+}
+
+func (p *pulseCounter) watch(ctx context.Context, events <-chan hal.EdgeEvent) {
+	defer close(p.done)
+	debounce := p.cfg.Debounce.D()
+	var last time.Time
+	for ev := range events {
+		if debounce > 0 && !last.IsZero() && ev.Time.Sub(last) < debounce {
+			continue
+		}
+		last = ev.Time
+		p.count.Add(1)
+	}
+	if ctx.Err() == nil {
+		p.failed.Store(true)
+	}
+}
+
+// total returns the count in units
+func (p *pulseCounter) total() float64 {
+	return float64(p.count.Load()) * p.cfg.PerPulse
+}
+
+func (p *pulseCounter) Name() string { return p.cfg.Name }
+func (p *pulseCounter) Unit() string { return p.cfg.Unit }
+
+// Read returns the rate since the previous read
+func (p *pulseCounter) Read(ctx context.Context) (float64, sensor.Quality, error) {
+	if p.failed.Load() {
+		return 0, sensor.Fault, fmt.Errorf("GPIO %d: edge detection stopped", p.cfg.Pin)
+	}
+	now, count := time.Now(), p.count.Load()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// Unsigned subtraction stays right across a wrap of the counter
+	pulses := count - p.prevCount
+	elapsed := now.Sub(p.prevTime)
+	p.prevCount, p.prevTime = count, now
+	if now.Sub(p.saved) >= pulseSaveInterval {
+		p.save(count, now)
+	}
+	if elapsed <= 0 {
+		return 0, sensor.OK, nil
+	}
+	per := p.cfg.Per.D()
+	if per <= 0 {
+		per = time.Second
+	}
+	return float64(pulses) * p.cfg.PerPulse * float64(per) / float64(elapsed), sensor.OK, nil
+}
+
+// save writes the count; p.mu must be held
+func (p *pulseCounter) save(count uint64, now time.Time) {
+	if p.state != nil {
+		// Written to the file when the agent flushes its state
+		if err := p.state.Set(pulseStateKey(p.cfg.Name), strconv.FormatUint(count, 10)); err != nil {
+			log.Printf("⚠️  %s: saving count: %v", p.cfg.Name, err)
+		}
+		p.saved = now
+		return
+	}
+	if p.statePath == "" {
+		return
+	}
+	p.saved = now
+	b, _ := json.Marshal(pulseState{Count: count, Time: now})
+	tmp := p.statePath + ".tmp"
+	err := os.WriteFile(tmp, append(b, '\n'), 0644)
+	if err == nil {
+		err = os.Rename(tmp, p.statePath)
+	}
+	if err != nil {
+		log.Printf("⚠️  %s: saving count: %v", p.cfg.Name, err)
+	}
+}
+
+// Close stops counting and saves the count
+func (p *pulseCounter) Close() error {
+	p.stop()
+	<-p.done
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.save(p.count.Load(), time.Now())
+	return nil
+}
+
+// pulseTotal is the running total of a pulse counter as a channel
+type pulseTotal struct{ p *pulseCounter }
+
+func (t pulseTotal) Name() string { return t.p.cfg.TotalName }
+func (t pulseTotal) Unit() string { return t.p.cfg.TotalUnit }
+
+func (t pulseTotal) Read(ctx context.Context) (float64, sensor.Quality, error) {
+	q := sensor.OK
+	if t.p.failed.Load() {
+		q = sensor.Stale
+	}
+	return t.p.total(), q, nil
+}
+
+// openGPIO opens the configured GPIO controller the first time a channel
+// needs it
+func (a *Agent) openGPIO() (hal.GPIOController, error) {
+	if a.gpio != nil {
+		return a.gpio, nil
+	}
+	cfg := hal.GPIOConfig{Driver: hal.Auto}
+	if a.cfg.GPIO != nil {
+		cfg = *a.cfg.GPIO
+	}
+	gpio, err := hal.NewGPIOController(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GPIO: %w", err)
+	}
+	a.gpio = gpio
+	return gpio, nil
+}
+
+// addPulseCounters opens the GPIO controller and adds a channel, or two,
+// for every configured pulse counter
+func (a *Agent) addPulseCounters(cfg Config) error {
+	if len(cfg.Pulses) == 0 {
+		return nil
+	}
+	gpio, err := a.openGPIO()
+	if err != nil {
+		return err
+	}
+	if cfg.HistoryDir != "" {
+		if err := os.MkdirAll(cfg.HistoryDir, 0755); err != nil {
+			return err
+		}
+	}
+	for _, pc := range cfg.Pulses {
+		p, err := newPulseCounter(pc, gpio, a.state, cfg.HistoryDir)
+		if err != nil {
+			return err
+		}
+		if err := a.AddSensor(p, pc.Range); err != nil {
+			p.Close()
+			return err
+		}
+		if pc.TotalName != "" {
+			if err := a.AddSensor(pulseTotal{p}, sensor.Range{}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
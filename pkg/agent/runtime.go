@@ -0,0 +1,104 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"riscv-dev/pkg/buildinfo"
+	"riscv-dev/pkg/metrics"
+	"riscv-dev/pkg/procstat"
+	"riscv-dev/pkg/sensor"
+)
+
+// RuntimeConfig defines a virtual channel tracking the agent's own resource
+// usage, so leaks get the same history, alerts and sinks as sensors
+type RuntimeConfig struct {
+	Stat string `json:"stat"`           // see runtimeStats
+	Name string `json:"name,omitempty"` // default runtime_<stat>
+	sensor.Range
+}
+
+// runtimeStats are the statistics a runtime channel can track, with their
+// units
+var runtimeStats = map[string]struct {
+	unit string
+	get  func(procstat.Stats) float64
+}{
+	"goroutines":           {"", func(s procstat.Stats) float64 { return float64(s.Goroutines) }},
+	"heap_bytes":           {"B", func(s procstat.Stats) float64 { return float64(s.HeapBytes) }},
+	"rss_bytes":            {"B", func(s procstat.Stats) float64 { return float64(s.RSSBytes) }},
+	"gc_pause":             {"s", func(s procstat.Stats) float64 { return s.GCPause.Seconds() }},
+	"open_fds":             {"", func(s procstat.Stats) float64 { return float64(s.OpenFDs) }},
+	"fd_usage":             {"%", fdUsage},
+	"voluntary_switches":   {"", func(s procstat.Stats) float64 { return float64(s.VoluntarySwitches) }},
+	"involuntary_switches": {"", func(s procstat.Stats) float64 { return float64(s.InvoluntarySwitches) }},
+}
+
+func fdUsage(s procstat.Stats) float64 {
+	if s.OpenFDs < 0 || s.MaxFDs <= 0 {
+		return -1
+	}
+	return 100 * float64(s.OpenFDs) / float64(s.MaxFDs)
+}
+
+var registerRuntime sync.Once
+
+// runtimeSensor reads one statistic; the channels share a sampler so each
+// sample takes one snapshot
+type runtimeSensor struct {
+	name, unit string
+	get        func(procstat.Stats) float64
+	sampler    *procstat.Sampler
+}
+
+func (s *runtimeSensor) Name() string { return s.name }
+func (s *runtimeSensor) Unit() string { return s.unit }
+
+func (s *runtimeSensor) Read(ctx context.Context) (float64, sensor.Quality, error) {
+	v := s.get(s.sampler.Get())
+	if v < 0 {
+		return 0, sensor.Fault, fmt.Errorf("%s unavailable", s.name)
+	}
+	return v, sensor.OK, nil
+}
+
+// addRuntime adds the runtime channels
+func (a *Agent) addRuntime(cfg Config) error {
+	sampler := &procstat.Sampler{MaxAge: cfg.SampleInterval.D() / 2}
+	for _, rc := range cfg.Runtime {
+		stat, ok := runtimeStats[rc.Stat]
+		if !ok {
+			names := make([]string, 0, len(runtimeStats))
+			for name := range runtimeStats {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("runtime channel: unknown stat %q (want one of %s)", rc.Stat, strings.Join(names, ", "))
+		}
+		name := rc.Name
+		if name == "" {
+			name = "runtime_" + rc.Stat
+		}
+		s := &runtimeSensor{name: name, unit: stat.unit, get: stat.get, sampler: sampler}
+		if err := a.AddSensor(s, rc.Range); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var buildInfo = metrics.NewGauge("agent_build_info", "Always 1, labelled with the build of the agent's binary",
+	"version", "commit", "goarch", "profile", "features")
+
+// registerRuntimeMetrics exposes the process statistics and the build on
+// /metrics, once per process however many agents it runs
+func registerRuntimeMetrics() {
+	registerRuntime.Do(func() {
+		procstat.Register(metrics.Default)
+		b := buildinfo.Get()
+		buildInfo.Set(1, b.Version, b.Commit, b.GOARCH, b.Profile, strings.Join(b.Features, ","))
+	})
+}
@@ -0,0 +1,388 @@
+//go:build !no_s3 && !minimal
+
+package agent
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"riscv-dev/pkg/buildinfo"
+	"riscv-dev/pkg/config"
+	"riscv-dev/pkg/metrics"
+	"riscv-dev/pkg/namespace"
+	"riscv-dev/pkg/s3"
+)
+
+var (
+	s3Uploaded  = metrics.NewCounter("agent_s3_chunks_uploaded_total", "Chunks uploaded to S3", "sink")
+	s3Failures  = metrics.NewCounter("agent_s3_upload_failures_total", "Failed chunk uploads, including retries", "sink")
+	s3Discarded = metrics.NewCounter("agent_s3_chunks_discarded_total", "Chunks deleted unsent to stay within the retention limits", "sink")
+	s3Pending   = metrics.NewGauge("agent_s3_pending_bytes", "Compressed chunks waiting to be uploaded", "sink")
+)
+
+// The s3 sink is left out of binaries built with -tags no_s3 or minimal
+func init() {
+	buildinfo.AddFeature("s3")
+	RegisterSinkType("s3", func(cfg SinkConfig) (Sink, error) {
+		var sc S3Config
+		if err := cfg.Decode(&sc); err != nil {
+			return nil, err
+		}
+		sc.Dir = cfg.Path(sc.Dir)
+		tlsCfg, err := cfg.TLS.Client(sc.Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsCfg
+		transport.DialContext = cfg.Dial()
+		name := cfg.Name
+		if name == "" {
+			name = cfg.Type
+		}
+		return NewS3Sink(name, sc, cfg.Namespace, &http.Client{Transport: withServerTime(transport, cfg.ServerTime)})
+	})
+}
+
+// S3Config configures an S3Sink in config.json
+type S3Config struct {
+	s3.Config
+	Prefix string `json:"prefix,omitempty"` // key prefix, before the namespace
+	// Dir holds the chunk being written and those waiting for upload
+	Dir           string          `json:"dir"`
+	Interval      config.Duration `json:"interval,omitempty"`        // default 5m
+	MaxChunkBytes int64           `json:"max_chunk_bytes,omitempty"` // uncompressed, default 8 MiB
+	// MaxPendingBytes and Retention bound the chunks kept while uploads
+	// fail; the oldest are deleted first. Defaults 64 MiB and 7 days.
+	MaxPendingBytes int64           `json:"max_pending_bytes,omitempty"`
+	Retention       config.Duration `json:"retention,omitempty"`
+}
+
+// S3Sink collects readings as JSON lines in a local chunk file and, every
+// interval, compresses the chunk and uploads it to S3 or MinIO under
+// prefix/namespace/YYYY/MM/DD/. Chunks wait on disk until they are
+// uploaded, surviving restarts and outages within the retention limits.
+// Writes only touch the local disk, so the sink keeps accepting readings
+// while offline.
+type S3Sink struct {
+	name   string
+	cfg    S3Config
+	client *s3.Client
+	hc     *http.Client
+	keyDir string // prefix and namespace
+
+	mu      sync.Mutex
+	cur     *os.File
+	started time.Time // of the current chunk
+	size    int64
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+const s3Current = "current.jsonl"
+
+// NewS3Sink opens the chunk directory and starts uploading, including
+// chunks left by an earlier run. name labels the sink's metrics and log
+// lines; keys include the namespace path, or the hostname if it is empty.
+func NewS3Sink(name string, cfg S3Config, ns namespace.Namespace, hc *http.Client) (*S3Sink, error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("s3 sink: dir is required")
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = config.Duration(5 * time.Minute)
+	}
+	if cfg.MaxChunkBytes <= 0 {
+		cfg.MaxChunkBytes = 8 << 20
+	}
+	if cfg.MaxPendingBytes <= 0 {
+		cfg.MaxPendingBytes = 64 << 20
+	}
+	if cfg.Retention <= 0 {
+		cfg.Retention = config.Duration(7 * 24 * time.Hour)
+	}
+	client, err := s3.New(cfg.Config, hc)
+	if err != nil {
+		return nil, err
+	}
+	if ns.Path() == "" {
+		// Keys must still tell devices apart
+		if ns, err = ns.Resolve(); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	s := &S3Sink{
+		name:   name,
+		cfg:    cfg,
+		client: client,
+		hc:     hc,
+		keyDir: strings.Trim(path.Join(cfg.Prefix, ns.Path()), "/"),
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	// A chunk left by a crash is sealed as it is
+	if st, err := os.Stat(filepath.Join(cfg.Dir, s3Current)); err == nil && st.Size() > 0 {
+		if err := s.seal(st.ModTime()); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	go s.upload()
+	return s, nil
+}
+
+func (s *S3Sink) open() error {
+	f, err := os.OpenFile(filepath.Join(s.cfg.Dir, s3Current), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	s.cur, s.started, s.size = f, time.Now(), 0
+	return nil
+}
+
+// Write appends r to the current chunk, sealing it once it is due
+func (s *S3Sink) Write(ctx context.Context, r Reading) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cur == nil {
+		return errors.New("s3 sink closed")
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	n, err := s.cur.Write(append(b, '\n'))
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+	if s.size >= s.cfg.MaxChunkBytes || time.Since(s.started) >= s.cfg.Interval.D() {
+		return s.rotate()
+	}
+	return nil
+}
+
+// rotate seals the current chunk and starts a new one
+func (s *S3Sink) rotate() error {
+	if err := s.cur.Close(); err != nil {
+		return err
+	}
+	s.cur = nil
+	if err := s.seal(s.started); err != nil {
+		return err
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return s.open()
+}
+
+// seal compresses the current chunk file into a pending chunk named by
+// its start time
+func (s *S3Sink) seal(started time.Time) error {
+	current := filepath.Join(s.cfg.Dir, s3Current)
+	data, err := os.ReadFile(current)
+	if err != nil || len(data) == 0 {
+		return err
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	name := started.UTC().Format("20060102T150405.000Z") + ".jsonl.gz"
+	tmp := filepath.Join(s.cfg.Dir, name+".tmp")
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.cfg.Dir, name)); err != nil {
+		return err
+	}
+	return os.Truncate(current, 0)
+}
+
+// pending lists sealed chunks, oldest first
+func (s *S3Sink) pending() []os.FileInfo {
+	entries, _ := os.ReadDir(s.cfg.Dir)
+	var chunks []os.FileInfo
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".jsonl.gz") {
+			if info, err := e.Info(); err == nil {
+				chunks = append(chunks, info)
+			}
+		}
+	}
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].Name() < chunks[j].Name() })
+	return chunks
+}
+
+// enforceRetention deletes the oldest chunks beyond the limits and returns
+// those left
+func (s *S3Sink) enforceRetention() []os.FileInfo {
+	chunks := s.pending()
+	var total int64
+	for _, c := range chunks {
+		total += c.Size()
+	}
+	cutoff := time.Now().Add(-s.cfg.Retention.D())
+	for len(chunks) > 0 && (total > s.cfg.MaxPendingBytes || chunks[0].ModTime().Before(cutoff)) {
+		c := chunks[0]
+		if err := os.Remove(filepath.Join(s.cfg.Dir, c.Name())); err != nil {
+			break
+		}
+		log.Printf("⚠️  Sink %s: discarded unsent chunk %s to stay within retention limits", s.name, c.Name())
+		s3Discarded.Inc(s.name)
+		total -= c.Size()
+		chunks = chunks[1:]
+	}
+	s3Pending.Set(float64(total), s.name)
+	return chunks
+}
+
+// DiskUsage returns the bytes of the current and pending chunks
+func (s *S3Sink) DiskUsage() int64 {
+	s.mu.Lock()
+	total := s.size
+	s.mu.Unlock()
+	for _, c := range s.pending() {
+		total += c.Size()
+	}
+	return total
+}
+
+// Compact deletes the oldest pending chunks, unsent, until the sink uses
+// at most keep bytes; the chunk being written is kept
+func (s *S3Sink) Compact(keep int64) (int64, error) {
+	before := s.DiskUsage()
+	total := before
+	chunks := s.pending()
+	for len(chunks) > 0 && total > keep {
+		c := chunks[0]
+		if err := os.Remove(filepath.Join(s.cfg.Dir, c.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return before - total, err
+		}
+		log.Printf("⚠️  Sink %s: discarded unsent chunk %s to free disk space", s.name, c.Name())
+		s3Discarded.Inc(s.name)
+		s3Pending.Add(-float64(c.Size()), s.name)
+		total -= c.Size()
+		chunks = chunks[1:]
+	}
+	return before - total, nil
+}
+
+// key returns the object key of a chunk
+func (s *S3Sink) key(chunk string) string {
+	day := "unknown"
+	if t, err := time.Parse("20060102T150405.000Z", strings.TrimSuffix(chunk, ".jsonl.gz")); err == nil {
+		day = t.Format("2006/01/02")
+	}
+	return strings.TrimPrefix(s.keyDir+"/"+day+"/"+chunk, "/")
+}
+
+// upload sends pending chunks whenever one is sealed, retrying failures
+// with backoff
+func (s *S3Sink) upload() {
+	defer close(s.done)
+	const minBackoff, maxBackoff = 5 * time.Second, 5 * time.Minute
+	backoff := minBackoff
+	failing := false
+	retry := time.NewTimer(0)
+	defer retry.Stop()
+	for {
+		select {
+		case <-s.wake:
+		case <-retry.C:
+		case <-s.stop:
+			return
+		}
+		err := s.flush(context.Background())
+		if err == nil {
+			if failing {
+				log.Printf("✅ Sink %s: uploads recovered", s.name)
+			}
+			failing, backoff = false, minBackoff
+			continue
+		}
+		if !failing {
+			log.Printf("❌ Sink %s: %v (retrying)", s.name, err)
+		}
+		failing = true
+		retry.Reset(backoff)
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// flush uploads pending chunks, oldest first, stopping at the first failure
+func (s *S3Sink) flush(ctx context.Context) error {
+	for _, c := range s.enforceRetention() {
+		data, err := os.ReadFile(filepath.Join(s.cfg.Dir, c.Name()))
+		if err != nil {
+			return err
+		}
+		putCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		err = s.client.Put(putCtx, s.key(c.Name()), data, "application/gzip")
+		cancel()
+		if err != nil {
+			s3Failures.Inc(s.name)
+			return err
+		}
+		os.Remove(filepath.Join(s.cfg.Dir, c.Name()))
+		s3Uploaded.Inc(s.name)
+		s3Pending.Add(-float64(c.Size()), s.name)
+	}
+	return nil
+}
+
+// Reconnect drops idle connections, so uploads dial the new uplink
+func (s *S3Sink) Reconnect() {
+	if s.hc != nil {
+		s.hc.CloseIdleConnections()
+	}
+}
+
+// Close seals the current chunk and makes one last attempt, for up to ten
+// seconds, to upload what is pending; the rest is sent after the next start
+func (s *S3Sink) Close() error {
+	close(s.stop)
+	<-s.done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cur == nil {
+		return nil
+	}
+	err := s.cur.Close()
+	s.cur = nil
+	if err == nil {
+		err = s.seal(s.started)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if ferr := s.flush(ctx); ferr != nil {
+		log.Printf("⚠️  Sink %s: %d chunks left for the next start: %v", s.name, len(s.pending()), ferr)
+	}
+	return err
+}
@@ -0,0 +1,89 @@
+package agent
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"riscv-dev/pkg/schedule"
+	"riscv-dev/pkg/sensor"
+)
+
+// ThresholdSchedule overrides a channel's range while When matches, e.g.
+// a lower light limit at night. When is a time window such as
+// "22:00-06:00", "mon-fri 08:00-18:00" or, with the config's coordinates,
+// "sunset-sunrise", or a cron expression (see package schedule), in local
+// time. Bounds left unset keep the channel's own.
+type ThresholdSchedule struct {
+	Channel string   `json:"channel"`
+	When    string   `json:"when"`
+	Min     *float64 `json:"min,omitempty"`
+	Max     *float64 `json:"max,omitempty"`
+}
+
+// scheduledRange is a parsed ThresholdSchedule
+type scheduledRange struct {
+	window   *schedule.Window
+	min, max *float64
+}
+
+// addSchedules attaches the threshold schedules to their channels, in
+// order; the first that matches wins
+func (a *Agent) addSchedules(cfg Config) error {
+	for _, ts := range cfg.Schedules {
+		ch := a.channel(ts.Channel)
+		if ch == nil {
+			return fmt.Errorf("threshold schedule: unknown channel %q", ts.Channel)
+		}
+		w, err := schedule.ParseAt(ts.When, cfg.Coordinates)
+		if err != nil {
+			return fmt.Errorf("threshold schedule for %s: %w", ts.Channel, err)
+		}
+		if ts.Min == nil && ts.Max == nil {
+			return fmt.Errorf("threshold schedule for %s: set min, max or both", ts.Channel)
+		}
+		a.mu.Lock()
+		ch.schedules = append(ch.schedules, scheduledRange{window: w, min: ts.Min, max: ts.Max})
+		a.mu.Unlock()
+	}
+	return nil
+}
+
+// rangeAt returns the channel's range in effect at t and the index of the
+// schedule that set it, -1 for the channel's own
+func (ch *channel) rangeAt(t time.Time) (sensor.Range, int) {
+	for i, s := range ch.schedules {
+		if !s.window.Contains(t) {
+			continue
+		}
+		r := ch.rng
+		if s.min != nil {
+			r.Min = s.min
+		}
+		if s.max != nil {
+			r.Max = s.max
+		}
+		return r, i
+	}
+	return ch.rng, -1
+}
+
+// logSchedule logs a change of the schedule in effect on a channel
+func logSchedule(name string, ch *channel, r sensor.Range, i int) {
+	var limits []string
+	if r.Min != nil {
+		limits = append(limits, fmt.Sprintf("min %g", *r.Min))
+	}
+	if r.Max != nil {
+		limits = append(limits, fmt.Sprintf("max %g", *r.Max))
+	}
+	if len(limits) == 0 {
+		limits = append(limits, "no limits")
+	}
+	if i < 0 {
+		log.Printf("🕒 %s: default limits in effect (%s)", name, strings.Join(limits, ", "))
+		return
+	}
+	log.Printf("🕒 %s: limits for %q in effect (%s)", name, ch.schedules[i].window, strings.Join(limits, ", "))
+}
@@ -0,0 +1,148 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"riscv-dev/pkg/hal"
+	"riscv-dev/pkg/sensor"
+)
+
+// Sensor is a source of values for one named channel. Sensors added with
+// Agent.AddSensor go through the same history, statistics, metrics and
+// sinks as the configured ADC channels.
+type Sensor interface {
+	Name() string
+	Unit() string
+	// Read returns the current value and its quality. On error the agent
+	// repeats the previous value as stale, or reports a fault.
+	Read(ctx context.Context) (float64, sensor.Quality, error)
+}
+
+// DescribedSensor is implemented by sensors that carry metadata, which the
+// agent passes on with every reading
+type DescribedSensor interface {
+	Sensor
+	Metadata() sensor.Metadata
+}
+
+// ChannelReading is the value of one channel in a Reading
+type ChannelReading struct {
+	Name    string           `json:"name"`
+	Unit    string           `json:"unit,omitempty"`
+	Value   float64          `json:"value"` // NaN (null in JSON) for faults
+	Quality sensor.Quality   `json:"quality"`
+	Meta    *sensor.Metadata `json:"meta,omitempty"`
+	Raw     *int             `json:"raw,omitempty"` // raw ADC count, for ADC channels
+	Min     *float64         `json:"min,omitempty"` // lowest value, in rollups
+	Max     *float64         `json:"max,omitempty"` // highest value, in rollups
+}
+
+// MarshalJSON encodes a NaN value as null
+func (c ChannelReading) MarshalJSON() ([]byte, error) {
+	type plain ChannelReading
+	if !math.IsNaN(c.Value) {
+		return json.Marshal(plain(c))
+	}
+	return json.Marshal(struct {
+		plain
+		Value *float64 `json:"value"`
+	}{plain: plain(c)})
+}
+
+// UnmarshalJSON decodes a null value as NaN
+func (c *ChannelReading) UnmarshalJSON(b []byte) error {
+	type plain ChannelReading
+	var v struct {
+		plain
+		Value *float64 `json:"value"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*c = ChannelReading(v.plain)
+	c.Value = math.NaN()
+	if v.Value != nil {
+		c.Value = *v.Value
+	}
+	return nil
+}
+
+// Reading holds one sample of every channel, taken together. A rollup
+// stands in for several samples a slow sink couldn't keep up with: its
+// values are means, with Min and Max set, and Span says what it covers.
+//
+// Device and Seq identify a reading for receivers deduplicating retries:
+// Seq counts the agent's readings from 1, kept across restarts in the
+// state file, so a number skipped is a reading missed (see package
+// seqtrack). Readings made up later, such as backfills, have no Seq.
+type Reading struct {
+	Time     time.Time        `json:"time"`
+	Device   string           `json:"device,omitempty"` // the device identity in data_dir
+	Seq      uint64           `json:"seq,omitempty"`
+	Source   string           `json:"source,omitempty"` // the agent's namespace path
+	Channels []ChannelReading `json:"channels"`
+	Span     *Span            `json:"span,omitempty"`
+}
+
+// Span is the time range and number of samples summarised by a rollup,
+// and the Seq of the first; the rollup's own Seq is the last's
+type Span struct {
+	Start   time.Time `json:"start"`
+	Samples int       `json:"samples"`
+	Seq     uint64    `json:"seq,omitempty"`
+}
+
+// Get returns the channel called name
+func (r Reading) Get(name string) (ChannelReading, bool) {
+	for _, c := range r.Channels {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return ChannelReading{}, false
+}
+
+// adcSensor converts an ADC channel to physical units
+type adcSensor struct {
+	adc hal.ADCController
+	cfg ChannelConfig
+	cal *sensor.Calibration // nil converts with offset and scale
+	// value returns the latest usable value of another channel, for
+	// compensation
+	value func(name string) (float64, bool)
+	raw   int
+}
+
+func (s *adcSensor) Name() string { return s.cfg.Name }
+func (s *adcSensor) Unit() string { return s.cfg.Unit }
+
+func (s *adcSensor) Metadata() sensor.Metadata { return s.cfg.Metadata }
+
+func (s *adcSensor) Read(ctx context.Context) (float64, sensor.Quality, error) {
+	raw, err := s.adc.ReadChannel(ctx, s.cfg.Channel)
+	if err != nil {
+		return 0, sensor.Fault, err
+	}
+	s.raw = raw
+	quality := sensor.RawQuality(raw, s.adc.GetResolution())
+	if s.cal != nil {
+		comp := 0.0
+		if c := s.cal.Compensation; c != nil {
+			v, ok := s.value(c.Channel)
+			if !ok {
+				return 0, sensor.Fault, fmt.Errorf("no %s value to compensate with", c.Channel)
+			}
+			comp = v
+		}
+		return s.cal.Apply(float64(raw), comp), quality, nil
+	}
+	scale := s.cfg.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	return (float64(raw) - s.cfg.Offset) / scale, quality, nil
+}
@@ -0,0 +1,118 @@
+package agent
+
+import (
+	"log"
+	"strconv"
+
+	"riscv-dev/pkg/metrics"
+	"riscv-dev/pkg/seqtrack"
+	"riscv-dev/pkg/state"
+)
+
+// seqBlock is how many sequence numbers the state file hands out at a
+// time, so it is written once a block rather than once a reading. After a
+// crash or power cut numbering resumes past the block, skipping what the
+// crashed run didn't use, as numbers must never be used twice.
+const seqBlock = 1000
+
+var readingSeq = metrics.NewGauge("agent_reading_seq", "Sequence number of the last reading")
+
+// openSeq resumes numbering readings where the state file says the last
+// run stopped
+func (a *Agent) openSeq() {
+	if a.state == nil {
+		return
+	}
+	last, reserved := a.state.Counter(StateSeq), a.state.Counter(StateSeqReserved)
+	if reserved > last {
+		log.Printf("⚠️  Sequence: the last run stopped uncleanly after about reading %d; numbering resumes at %d", last, reserved+1)
+	}
+	a.seq, a.seqLimit = reserved, reserved
+}
+
+// nextSeq numbers a reading, reserving another block in the state file
+// when the last is used up; a.mu must be held
+func (a *Agent) nextSeq() uint64 {
+	a.seq++
+	readingSeq.Set(float64(a.seq))
+	if a.state == nil || a.seq <= a.seqLimit {
+		return a.seq
+	}
+	a.seqLimit = a.seq + seqBlock - 1
+	err := a.state.Set(StateSeqReserved, strconv.FormatUint(a.seqLimit, 10))
+	if err == nil {
+		err = a.state.Flush()
+	}
+	if err != nil {
+		log.Printf("⚠️  Sequence: reserving numbers in the state file: %v; a crash may reuse them", err)
+	}
+	return a.seq
+}
+
+// saveSeq records the last number used, for the next start to tell whether
+// this run stopped cleanly; a.mu must be held
+func (a *Agent) saveSeq(clean bool) error {
+	return a.state.Update(func(tx *state.Tx) error {
+		tx.Set(StateSeq, strconv.FormatUint(a.seq, 10))
+		if clean {
+			tx.Set(StateSeqReserved, strconv.FormatUint(a.seq, 10))
+		}
+		return nil
+	})
+}
+
+// Dedup follows the Seq of readings received from agents, for a hub or a
+// subscriber: it tells retried readings from new ones and counts the ones
+// missed, in metrics labelled by device (see package seqtrack). Readings
+// without a Device are told apart by Source.
+type Dedup struct {
+	tracker    *seqtrack.Tracker
+	received   *metrics.Counter
+	duplicates *metrics.Counter
+	missed     *metrics.Counter
+	late       *metrics.Counter
+	restarts   *metrics.Counter
+	last       *metrics.Gauge
+}
+
+// NewDedup returns a Dedup keeping its metrics in reg
+func NewDedup(reg *metrics.Registry) *Dedup {
+	return &Dedup{
+		tracker:    seqtrack.New(0),
+		received:   reg.Counter("readings_received_total", "Readings received from a device, duplicates included", "device"),
+		duplicates: reg.Counter("readings_duplicate_total", "Readings received again, as the device retried", "device"),
+		missed:     reg.Counter("readings_missed_total", "Readings skipped in a device's sequence", "device"),
+		late:       reg.Counter("readings_late_total", "Readings counted missed that arrived after all", "device"),
+		restarts:   reg.Counter("readings_seq_restarts_total", "Times a device numbered its readings from 1 again", "device"),
+		last:       reg.Gauge("readings_last_seq", "Highest sequence number received from a device", "device"),
+	}
+}
+
+// Check records r, returning false if it is a duplicate to discard, and
+// what its numbers showed. Readings without a Seq are always kept.
+func (d *Dedup) Check(r Reading) (seqtrack.Result, bool) {
+	device := r.Device
+	if device == "" {
+		device = r.Source
+	}
+	var first uint64
+	if r.Span != nil {
+		first = r.Span.Seq
+	}
+	res := d.tracker.Add(device, first, r.Seq)
+	if r.Seq == 0 {
+		return res, true
+	}
+	d.received.Inc(device)
+	d.duplicates.Add(float64(res.Duplicate), device)
+	d.missed.Add(float64(res.Missed), device)
+	d.late.Add(float64(res.Late), device)
+	if res.Restarted {
+		d.restarts.Inc(device)
+	}
+	d.last.Set(float64(d.tracker.Stats(device).Last), device)
+	return res, !res.IsDuplicate()
+}
+
+// Stats returns the totals for a device
+func (d *Dedup) Stats(device string) seqtrack.Stats { return d.tracker.Stats(device) }
@@ -0,0 +1,318 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"riscv-dev/pkg/audit"
+	"riscv-dev/pkg/config"
+	"riscv-dev/pkg/hal"
+	"riscv-dev/pkg/metrics"
+	"riscv-dev/pkg/safestate"
+	"riscv-dev/pkg/sensor"
+)
+
+// SignalConfig drives a GPIO output, such as a siren, strobe or relay,
+// when channels raise alerts
+type SignalConfig struct {
+	Name      string `json:"name"`
+	Pin       int    `json:"pin"`
+	ActiveLow bool   `json:"active_low,omitempty"`
+	// Channels raise the signal; default every channel
+	Channels []string `json:"channels,omitempty"`
+	// Qualities are those that raise it; default out-of-range, saturated
+	// and sensor-fault
+	Qualities []sensor.Quality `json:"qualities,omitempty"`
+	// Forecasts raises it also while a forecast alert of the channels is
+	// active
+	Forecasts bool `json:"forecasts,omitempty"`
+	// Pattern is "hold" (the default) to keep the output on until the
+	// alerts clear, "pulse" to pulse it Count times when they are raised,
+	// or "assert" to switch it on for Duration
+	Pattern string `json:"pattern,omitempty"`
+	// Count is the number of pulses; default 3
+	Count int `json:"count,omitempty"`
+	// On and Off are a pulse's length and the gap after it; default 500ms
+	// each
+	On  config.Duration `json:"on,omitempty"`
+	Off config.Duration `json:"off,omitempty"`
+	// Duration is how long assert keeps the output on, default 10s, and
+	// the longest hold keeps it on however long the alerts last (0 for no
+	// limit), so a siren doesn't sound all night
+	Duration config.Duration `json:"duration,omitempty"`
+}
+
+// Signal patterns
+const (
+	PatternHold   = "hold"
+	PatternPulse  = "pulse"
+	PatternAssert = "assert"
+)
+
+var signalState = metrics.NewGauge("alert_signal_state", "Whether an alert signal's output is on", "signal")
+
+// defaultSignalQualities raise a signal unless configured otherwise
+var defaultSignalQualities = []sensor.Quality{sensor.OutOfRange, sensor.Saturated, sensor.Fault}
+
+// signal is a configured signal output and the goroutine driving it
+type signal struct {
+	cfg      SignalConfig
+	channels map[string]bool // nil for every channel
+	raise    map[sensor.Quality]bool
+	active   bool        // raised by the last reading
+	reason   string      // of raising it, for the log and audit
+	updates  chan string // the reason it is raised, "" once cleared
+	done     chan struct{}
+}
+
+// addSignals opens the GPIO controller, switches every signal off and
+// starts driving them
+func (a *Agent) addSignals(cfg Config) error {
+	if len(cfg.Signals) == 0 {
+		return nil
+	}
+	gpio, err := a.openGPIO()
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	for _, sc := range cfg.Signals {
+		if sc.Name == "" || seen[sc.Name] {
+			return fmt.Errorf("signal %q: name must be set and unique", sc.Name)
+		}
+		seen[sc.Name] = true
+		switch sc.Pattern {
+		case "":
+			sc.Pattern = PatternHold
+		case PatternHold, PatternPulse, PatternAssert:
+		default:
+			return fmt.Errorf("signal %s: unknown pattern %q (want hold, pulse or assert)", sc.Name, sc.Pattern)
+		}
+		if sc.Count < 0 || sc.On < 0 || sc.Off < 0 || sc.Duration < 0 {
+			return fmt.Errorf("signal %s: count and durations must not be negative", sc.Name)
+		}
+		if sc.Count == 0 {
+			sc.Count = 3
+		}
+		if sc.On == 0 {
+			sc.On = config.Duration(500 * time.Millisecond)
+		}
+		if sc.Off == 0 {
+			sc.Off = sc.On
+		}
+		if sc.Duration == 0 && sc.Pattern == PatternAssert {
+			sc.Duration = config.Duration(10 * time.Second)
+		}
+		s := &signal{cfg: sc, raise: make(map[sensor.Quality]bool), updates: make(chan string, 1), done: make(chan struct{})}
+		if len(sc.Channels) > 0 {
+			s.channels = make(map[string]bool)
+			for _, name := range sc.Channels {
+				if a.channel(name) == nil {
+					return fmt.Errorf("signal %s: unknown channel %q", sc.Name, name)
+				}
+				s.channels[name] = true
+			}
+		}
+		qualities := sc.Qualities
+		if len(qualities) == 0 {
+			qualities = defaultSignalQualities
+		}
+		for _, q := range qualities {
+			s.raise[q] = true
+		}
+		if err := gpio.SetMode(sc.Pin, hal.Output); err != nil {
+			return fmt.Errorf("signal %s: %w", sc.Name, err)
+		}
+		if err := a.writeSignal(context.Background(), s, false, "starting"); err != nil {
+			return err
+		}
+		a.signals = append(a.signals, s)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	a.stopSig = cancel
+	for _, s := range a.signals {
+		go a.runSignal(ctx, s)
+	}
+	// Silence the outputs if the program dies
+	a.unsafeSig = safestate.Register("alert signals off", func(ctx context.Context) error {
+		return a.signalsOff(ctx, "safe state")
+	})
+	return nil
+}
+
+// signalsOff switches every signal output off
+func (a *Agent) signalsOff(ctx context.Context, reason string) error {
+	var errs []error
+	for _, s := range a.signals {
+		errs = append(errs, a.writeSignal(ctx, s, false, reason))
+	}
+	return errors.Join(errs...)
+}
+
+// closeSignals stops driving the signals and switches them off
+func (a *Agent) closeSignals() error {
+	if a.stopSig == nil {
+		return nil
+	}
+	a.stopSig()
+	for _, s := range a.signals {
+		<-s.done
+	}
+	a.unsafeSig()
+	return a.signalsOff(context.Background(), "stopping")
+}
+
+// writeSignal drives the pin, auditing the write as made by the agent for
+// reason, such as the alerts raising the signal
+func (a *Agent) writeSignal(ctx context.Context, s *signal, on bool, reason string) error {
+	err := a.gpio.Write(ctx, s.cfg.Pin, on != s.cfg.ActiveLow)
+	entry := audit.Entry{Action: "signal.write", Target: s.cfg.Name, New: onOff(on), Reason: reason}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	a.audit.Record(audit.WithActor(ctx, "agent"), entry)
+	if err != nil {
+		return fmt.Errorf("signal %s: %w", s.cfg.Name, err)
+	}
+	v := 0.0
+	if on {
+		v = 1
+	}
+	signalState.Set(v, s.cfg.Name)
+	return nil
+}
+
+// driveSignals raises or clears the signals by a reading's qualities and
+// the forecast alerts active; it runs on the sampling goroutine, which
+// owns the forecasters
+func (a *Agent) driveSignals(r Reading, chans []*channel) {
+	for _, s := range a.signals {
+		var reasons []string
+		for i, c := range r.Channels {
+			if s.channels != nil && !s.channels[c.Name] {
+				continue
+			}
+			if s.raise[c.Quality] {
+				reasons = append(reasons, c.Name+" "+c.Quality.String())
+			}
+			if s.cfg.Forecasts && i < len(chans) {
+				for _, f := range chans[i].forecasts {
+					if f.active {
+						reasons = append(reasons, c.Name+" forecast")
+						break
+					}
+				}
+			}
+		}
+		// Like the actuators, a board standing by leaves the outputs to the
+		// leader
+		active := len(reasons) > 0 && a.Leader()
+		if active == s.active {
+			continue
+		}
+		s.active = active
+		s.reason = ""
+		if active {
+			s.reason = strings.Join(reasons, ", ")
+			log.Printf("🚨 Signal %s raised: %s", s.cfg.Name, s.reason)
+		} else {
+			log.Printf("✅ Signal %s cleared", s.cfg.Name)
+		}
+		// Only the latest state matters to the driver
+		select {
+		case <-s.updates:
+		default:
+		}
+		s.updates <- s.reason
+	}
+}
+
+// runSignal plays a signal's pattern as it is raised and cleared
+func (a *Agent) runSignal(ctx context.Context, s *signal) {
+	defer close(s.done)
+	on, off := s.cfg.On.D(), s.cfg.Off.D()
+	// raised is the reason the signal is raised, "" while it isn't, and
+	// cause the one that started the pattern playing
+	var raised, cause string
+	write := func(v bool, reason string) {
+		if err := a.writeSignal(ctx, s, v, reason); err != nil && ctx.Err() == nil {
+			log.Printf("❌ %v", err)
+		}
+	}
+	// wait sleeps for d, returning false if stopped; raised alerts meanwhile
+	// are taken as part of the pattern playing
+	wait := func(d time.Duration) bool {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		for {
+			select {
+			case raised = <-s.updates:
+				if raised == "" && s.cfg.Pattern == PatternHold {
+					return true
+				}
+			case <-t.C:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+	}
+	for {
+		select {
+		case raised = <-s.updates:
+		case <-ctx.Done():
+			return
+		}
+		if raised == "" {
+			continue
+		}
+		cause = raised
+		switch s.cfg.Pattern {
+		case PatternHold:
+			write(true, cause)
+			limit := s.cfg.Duration.D()
+			if limit == 0 {
+				limit = time.Duration(1<<63 - 1)
+			}
+			if !wait(limit) {
+				return
+			}
+			if raised == "" {
+				write(false, "cleared")
+				continue
+			}
+			log.Printf("🔇 Signal %s silenced after %v, though still raised", s.cfg.Name, limit)
+			write(false, fmt.Sprintf("silenced after %v", limit))
+			// Held long enough: wait for the alerts to clear before sounding
+			// again
+			for raised != "" {
+				select {
+				case raised = <-s.updates:
+				case <-ctx.Done():
+					return
+				}
+			}
+		case PatternPulse:
+			for i := 0; i < s.cfg.Count; i++ {
+				write(true, cause)
+				if !wait(on) {
+					return
+				}
+				write(false, cause)
+				if !wait(off) {
+					return
+				}
+			}
+		case PatternAssert:
+			write(true, cause)
+			if !wait(s.cfg.Duration.D()) {
+				return
+			}
+			write(false, cause)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"riscv-dev/pkg/audit"
+	"riscv-dev/pkg/config"
+	"riscv-dev/pkg/hal"
+	"riscv-dev/pkg/sim"
+)
+
+func TestSignalWritesAudited(t *testing.T) {
+	gpio := sim.NewGPIO()
+	gpio.SetMode(5, hal.Output)
+	a := &Agent{audit: openAudit(t), gpio: gpio}
+	s := &signal{
+		cfg:     SignalConfig{Name: "siren", Pin: 5, Pattern: PatternHold, Duration: config.Duration(20 * time.Millisecond)},
+		updates: make(chan string, 1),
+		done:    make(chan struct{}),
+	}
+	a.signals = []*signal{s}
+	ctx, cancel := context.WithCancel(context.Background())
+	go a.runSignal(ctx, s)
+
+	writes := func() []audit.Entry {
+		got, err := a.audit.Query(audit.Filter{Action: "signal.write", Target: "siren"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	s.updates <- "temperature out-of-range"
+	waitFor(t, "the hold to be silenced", func() bool { return len(writes()) == 2 })
+	// Raised again once cleared, and cleared while held
+	s.updates <- ""
+	s.updates <- "temperature forecast"
+	s.updates <- ""
+	waitFor(t, "the signal cleared", func() bool { return len(writes()) == 4 })
+	cancel()
+	<-s.done
+	if err := a.signalsOff(context.Background(), "stopping"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct{ new, reason string }{
+		{"on", "temperature out-of-range"},
+		{"off", "silenced after 20ms"},
+		{"on", "temperature forecast"},
+		{"off", "cleared"},
+		{"off", "stopping"},
+	}
+	got := writes()
+	if len(got) != len(want) {
+		t.Fatalf("recorded %+v", got)
+	}
+	for i, e := range got {
+		if e.Actor != "agent" || e.New != want[i].new || e.Reason != want[i].reason {
+			t.Errorf("entry %d = %+v, want %s for %q", i, e, want[i].new, want[i].reason)
+		}
+	}
+}
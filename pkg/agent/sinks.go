@@ -0,0 +1,358 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"riscv-dev/pkg/metrics"
+	"riscv-dev/pkg/namespace"
+	"riscv-dev/pkg/sensor"
+	"riscv-dev/pkg/tlsconfig"
+)
+
+// SinkConfig configures an output sink in config.json. Fields specific to
+// a sink type sit alongside the common ones:
+//
+//	{"type": "file", "path": "/var/log/readings.jsonl", "queue_size": 600}
+type SinkConfig struct {
+	Type      string `json:"type"`
+	Name      string `json:"name,omitempty"` // defaults to the type
+	QueueSize int    `json:"queue_size,omitempty"`
+	Retries   *int   `json:"retries,omitempty"`
+	// Overflow is what happens to the oldest reading when the queue is
+	// full: "drop" (the default) or "rollup"
+	Overflow string `json:"overflow,omitempty"`
+	// DiskQuota bounds the bytes a sink keeping readings on local storage
+	// (file, s3) may use; the storage guardian trims it to fit
+	DiskQuota int64 `json:"disk_quota,omitempty"`
+
+	// TLS overrides fields of the agent's shared tls block for this sink
+	TLS *tlsconfig.Config `json:"tls,omitempty"`
+	// StaticHosts adds to the agent's static_hosts for this sink
+	StaticHosts map[string]string `json:"static_hosts,omitempty"`
+	// Namespace is the agent's, for sinks to prefix their topics or
+	// registrations with Namespace.Topic
+	Namespace namespace.Namespace `json:"-"`
+	// DataDir is the agent's data_dir, under which relative paths are
+	// taken (see Path)
+	DataDir string `json:"-"`
+	// Budget caps the bytes the sink moves each day, for metered links
+	Budget *BudgetConfig `json:"budget,omitempty"`
+	// Meter counts the bytes of connections made with Dial, for Budget;
+	// set by the agent
+	Meter *ByteMeter `json:"-"`
+	// ServerTime, if set, takes the time of each server response, with
+	// when the request went out and the response came back, for the
+	// agent's clock estimate; set by the agent
+	ServerTime func(sent, received, server time.Time) `json:"-"`
+	// Interfaces are the network interfaces the sink prefers, in order,
+	// e.g. ["wlan0", "eth0"]; it uses the first that works, or the uplink
+	// in use while none does. They must be among connectivity's.
+	Interfaces []string `json:"interfaces,omitempty"`
+	// Control is the agent's net.Dialer.Control binding connections to
+	// the sink's interface, nil without connectivity
+	Control func(network, address string, c syscall.RawConn) error `json:"-"`
+
+	// Raw is the complete JSON object, for decoding type-specific fields
+	Raw json.RawMessage `json:"-"`
+}
+
+// UnmarshalJSON decodes the common fields and keeps the raw object
+func (c *SinkConfig) UnmarshalJSON(b []byte) error {
+	type plain SinkConfig
+	if err := json.Unmarshal(b, (*plain)(c)); err != nil {
+		return err
+	}
+	c.Raw = append(json.RawMessage(nil), b...)
+	return nil
+}
+
+// MarshalJSON encodes the original object, including type-specific fields
+func (c SinkConfig) MarshalJSON() ([]byte, error) {
+	if len(c.Raw) > 0 {
+		return c.Raw, nil
+	}
+	type plain SinkConfig
+	return json.Marshal(plain(c))
+}
+
+// Decode unmarshals the type-specific fields into v
+func (c SinkConfig) Decode(v any) error {
+	if len(c.Raw) == 0 {
+		return nil
+	}
+	return json.Unmarshal(c.Raw, v)
+}
+
+// Path returns a type-specific path under DataDir if it is relative
+func (c SinkConfig) Path(path string) string { return underDir(c.DataDir, path) }
+
+// options applies the common fields to the defaults
+func (c SinkConfig) options() (SinkOptions, error) {
+	opts := DefaultSinkOptions
+	if c.QueueSize > 0 {
+		opts.QueueSize = c.QueueSize
+	}
+	if c.Retries != nil {
+		opts.Retries = *c.Retries
+	}
+	opts.DiskQuota = c.DiskQuota
+	if c.Budget != nil {
+		if err := c.Budget.validate(); err != nil {
+			return opts, err
+		}
+		opts.Budget, opts.Meter = c.Budget, c.Meter
+	}
+	switch c.Overflow {
+	case "", "drop":
+	case "rollup":
+		opts.Rollup = true
+	default:
+		return opts, fmt.Errorf("unknown overflow %q (want drop or rollup)", c.Overflow)
+	}
+	return opts, nil
+}
+
+// Dial returns the dial function network sinks should use: host names in
+// StaticHosts are replaced by their address without a DNS lookup, and
+// connections, DNS lookups included, use the sink's interface and count
+// towards its budget
+func (c SinkConfig) Dial() DialFunc {
+	d := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second, Control: c.Control}
+	if c.Control != nil {
+		dns := &net.Dialer{Timeout: 5 * time.Second, Control: c.Control}
+		d.Resolver = &net.Resolver{PreferGo: true, Dial: dns.DialContext}
+	}
+	if len(c.StaticHosts) == 0 && c.Meter == nil {
+		return d.DialContext
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if host, port, err := net.SplitHostPort(addr); err == nil {
+			if ip, ok := c.StaticHosts[host]; ok {
+				addr = net.JoinHostPort(ip, port)
+			}
+		}
+		conn, err := d.DialContext(ctx, network, addr)
+		if err != nil || c.Meter == nil {
+			return conn, err
+		}
+		return meteredConn{conn, c.Meter}, nil
+	}
+}
+
+// mergeHosts returns the shared static hosts with a sink's own entries on top
+func mergeHosts(shared, own map[string]string) map[string]string {
+	if len(own) == 0 {
+		return shared
+	}
+	m := make(map[string]string, len(shared)+len(own))
+	for h, ip := range shared {
+		m[h] = ip
+	}
+	for h, ip := range own {
+		m[h] = ip
+	}
+	return m
+}
+
+// DialFunc opens a network connection, as net.Dialer.DialContext
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// NetworkSink is implemented by sinks that need the network. While the
+// agent is offline they are held back and their queues keep the most
+// recent readings.
+type NetworkSink interface {
+	Sink
+	Network() bool
+}
+
+// Reconnector is implemented by sinks keeping connections open. When the
+// uplink a sink uses changes, they are told to drop them, so the next ones
+// are dialled, with host names resolved afresh, over the new one.
+type Reconnector interface {
+	Reconnect()
+}
+
+// SinkFactory creates a sink from its configuration
+type SinkFactory func(cfg SinkConfig) (Sink, error)
+
+var (
+	sinkTypesMu sync.RWMutex
+	sinkTypes   = make(map[string]SinkFactory)
+)
+
+// RegisterSinkType makes a sink type available to config.json. Sink
+// packages call it from init.
+func RegisterSinkType(name string, f SinkFactory) {
+	sinkTypesMu.Lock()
+	defer sinkTypesMu.Unlock()
+	sinkTypes[name] = f
+}
+
+// SinkTypes returns the registered sink type names
+func SinkTypes() []string {
+	sinkTypesMu.RLock()
+	defer sinkTypesMu.RUnlock()
+	names := make([]string, 0, len(sinkTypes))
+	for name := range sinkTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newSink(cfg SinkConfig) (Sink, error) {
+	sinkTypesMu.RLock()
+	f, ok := sinkTypes[cfg.Type]
+	sinkTypesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown sink type %q (available: %v)", cfg.Type, SinkTypes())
+	}
+	return f(cfg)
+}
+
+func init() {
+	RegisterSinkType("file", func(cfg SinkConfig) (Sink, error) {
+		var fc FileSinkConfig
+		if err := cfg.Decode(&fc); err != nil {
+			return nil, err
+		}
+		if fc.Path == "" {
+			return nil, fmt.Errorf("file sink: path is required")
+		}
+		fc.Path = cfg.Path(fc.Path)
+		return OpenFileSink(fc)
+	})
+	RegisterSinkType("http", func(cfg SinkConfig) (Sink, error) {
+		var hc struct {
+			URL     string            `json:"url"`
+			Headers map[string]string `json:"headers"`
+		}
+		if err := cfg.Decode(&hc); err != nil {
+			return nil, err
+		}
+		if hc.URL == "" {
+			return nil, fmt.Errorf("http sink: url is required")
+		}
+		tlsCfg, err := cfg.TLS.Client(hc.URL)
+		if err != nil {
+			return nil, err
+		}
+		s := NewHTTPSink(hc.URL, tlsCfg, cfg.Dial())
+		s.Headers = hc.Headers
+		s.client.Transport = withServerTime(s.client.Transport, cfg.ServerTime)
+		return s, nil
+	})
+	RegisterSinkType("ipc", func(cfg SinkConfig) (Sink, error) {
+		var ic IPCSinkConfig
+		if err := cfg.Decode(&ic); err != nil {
+			return nil, err
+		}
+		if ic.Path == "" {
+			ic.Path = DefaultReadingsSocket
+		}
+		ic.Path = cfg.Path(ic.Path)
+		return OpenIPCSink(ic, cfg.Namespace)
+	})
+	RegisterSinkType("prometheus", func(cfg SinkConfig) (Sink, error) {
+		return NewPrometheusSink(metrics.Default), nil
+	})
+}
+
+// HTTPSink POSTs each reading as JSON to a URL
+type HTTPSink struct {
+	URL     string
+	Headers map[string]string // e.g. an Authorization header
+
+	client *http.Client
+}
+
+// NewHTTPSink creates a sink posting to url. tlsCfg applies to https URLs
+// and dial to connections; either may be nil for the defaults. Nothing is
+// resolved or dialled until the first Write.
+func NewHTTPSink(url string, tlsCfg *tls.Config, dial DialFunc) *HTTPSink {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsCfg
+	if dial != nil {
+		transport.DialContext = dial
+	}
+	return &HTTPSink{URL: url, client: &http.Client{Transport: transport}}
+}
+
+// Write posts one reading; any status other than 2xx is an error
+func (s *HTTPSink) Write(ctx context.Context, r Reading) error {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", s.URL, resp.Status)
+	}
+	return nil
+}
+
+// Network marks the sink as needing the network
+func (s *HTTPSink) Network() bool { return true }
+
+// Reconnect drops idle connections, so the next Write dials the new
+// uplink
+func (s *HTTPSink) Reconnect() { s.client.CloseIdleConnections() }
+
+// Close drops idle connections
+func (s *HTTPSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+// PrometheusSink publishes the latest value and quality of every channel as
+// gauges, for scraping from /metrics. The sensor's model, location and
+// serial are labels of both, empty if not configured.
+type PrometheusSink struct {
+	value   *metrics.Gauge
+	quality *metrics.Gauge
+}
+
+// NewPrometheusSink registers the sensor gauges in reg
+func NewPrometheusSink(reg *metrics.Registry) *PrometheusSink {
+	return &PrometheusSink{
+		value:   reg.Gauge("sensor_value", "Latest value of a sensor channel", "sensor", "unit", "model", "location", "serial"),
+		quality: reg.Gauge("sensor_quality", "Latest quality of a sensor channel (0 = ok, higher is worse)", "sensor", "model", "location", "serial"),
+	}
+}
+
+// Write updates the gauges
+func (s *PrometheusSink) Write(ctx context.Context, r Reading) error {
+	for _, c := range r.Channels {
+		var meta sensor.Metadata
+		if c.Meta != nil {
+			meta = *c.Meta
+		}
+		s.value.Set(c.Value, c.Name, c.Unit, meta.Model, meta.Location, meta.Serial)
+		s.quality.Set(float64(c.Quality), c.Name, meta.Model, meta.Location, meta.Serial)
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"riscv-dev/pkg/config"
+	"riscv-dev/pkg/sensor"
+)
+
+// SnapshotConfig keeps the agent's working state in a file, written every
+// interval and on shutdown and taken up again at startup, so a board that
+// lost power resumes where it was: alerts that were raised stay raised
+// instead of firing again, and forecasts, integrals, actuator hysteresis
+// and overrides carry on. Counters are kept in the state file.
+type SnapshotConfig struct {
+	// Path is the snapshot file; default snapshot.json, under data_dir
+	// if relative
+	Path     string          `json:"path,omitempty"`
+	Interval config.Duration `json:"interval,omitempty"` // default 1m
+	// MaxAge is how old a snapshot may be and still be restored, as after
+	// a long outage the state it holds no longer applies; default 24h
+	MaxAge config.Duration `json:"max_age,omitempty"`
+}
+
+const (
+	defaultSnapshotPath     = "snapshot.json"
+	defaultSnapshotInterval = time.Minute
+	defaultSnapshotMaxAge   = 24 * time.Hour
+)
+
+// Snapshot is the agent's working state, as kept in the snapshot file
+type Snapshot struct {
+	Time      time.Time                   `json:"time"`
+	Channels  map[string]ChannelSnapshot  `json:"channels,omitempty"`
+	Actuators map[string]ActuatorSnapshot `json:"actuators,omitempty"`
+	Signals   map[string]bool             `json:"signals,omitempty"` // raised or not
+}
+
+// ChannelSnapshot is the state of a channel
+type ChannelSnapshot struct {
+	Quality sensor.Quality `json:"quality"` // as last alerted on
+	Last    *sensor.Sample `json:"last,omitempty"`
+	// Scheduled is the index of the range schedule in effect, -1 for none
+	Scheduled int `json:"scheduled"`
+	// Forecasts are in the order configured
+	Forecasts []ForecastSnapshot `json:"forecasts,omitempty"`
+	Integral  *float64           `json:"integral,omitempty"` // total of an integral channel
+}
+
+// ForecastSnapshot is the state of a forecast
+type ForecastSnapshot struct {
+	Active bool             `json:"active"`
+	Holt   sensor.HoltState `json:"holt"`
+}
+
+// ActuatorSnapshot is the state of an actuator: automation's choice, which
+// its hysteresis depends on, and an override still running
+type ActuatorSnapshot struct {
+	Auto          bool      `json:"auto"`
+	Override      bool      `json:"override,omitempty"`
+	OverrideState bool      `json:"override_state,omitempty"`
+	OverrideUntil time.Time `json:"override_until,omitempty"`
+	OverrideBy    string    `json:"override_by,omitempty"`
+}
+
+// snapshotPath returns the snapshot file's path
+func (c Config) snapshotPath() string {
+	if c.Snapshot.Path == "" {
+		return c.dataPath(defaultSnapshotPath)
+	}
+	return c.Snapshot.Path
+}
+
+// takeSnapshot captures the agent's working state. It runs on the sampling
+// goroutine, which owns the channels' state.
+func (a *Agent) takeSnapshot() Snapshot {
+	a.mu.Lock()
+	chans := a.chans
+	a.mu.Unlock()
+
+	snap := Snapshot{Time: time.Now(), Channels: make(map[string]ChannelSnapshot, len(chans))}
+	for _, ch := range chans {
+		cs := ChannelSnapshot{Quality: ch.quality, Scheduled: ch.scheduled}
+		if ch.hasLast && !math.IsNaN(ch.last.Value) {
+			last := ch.last
+			cs.Last = &last
+		}
+		for _, f := range ch.forecasts {
+			cs.Forecasts = append(cs.Forecasts, ForecastSnapshot{Active: f.active, Holt: f.holt.State()})
+		}
+		if d, ok := ch.sensor.(*derivedSensor); ok && d.integ != nil {
+			total := d.integ.Total
+			cs.Integral = &total
+		}
+		snap.Channels[ch.sensor.Name()] = cs
+	}
+	if len(a.signals) > 0 {
+		snap.Signals = make(map[string]bool, len(a.signals))
+		for _, s := range a.signals {
+			snap.Signals[s.cfg.Name] = s.active
+		}
+	}
+
+	a.actMu.Lock()
+	defer a.actMu.Unlock()
+	if len(a.actuators) > 0 {
+		snap.Actuators = make(map[string]ActuatorSnapshot, len(a.actuators))
+		for _, act := range a.actuators {
+			snap.Actuators[act.cfg.Name] = ActuatorSnapshot{
+				Auto: act.auto, Override: act.override, OverrideState: act.overrideState,
+				OverrideUntil: act.overrideUntil, OverrideBy: act.overrideBy,
+			}
+		}
+	}
+	return snap
+}
+
+// saveSnapshot writes the agent's working state to the snapshot file,
+// replacing it atomically so a power cut leaves the previous one
+func (a *Agent) saveSnapshot() error {
+	path := a.cfg.snapshotPath()
+	data, err := json.MarshalIndent(a.takeSnapshot(), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(append(data, '\n'))
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// restoreSnapshot takes up the state in the snapshot file, if there is a
+// recent enough one. Channels, actuators and signals no longer configured
+// are skipped, as are forecasts if their number changed.
+func (a *Agent) restoreSnapshot() error {
+	path := a.cfg.snapshotPath()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var snap Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		log.Printf("⚠️  Snapshot %s unreadable, starting afresh: %v", path, err)
+		return nil
+	}
+	maxAge := a.cfg.Snapshot.MaxAge.D()
+	if maxAge <= 0 {
+		maxAge = defaultSnapshotMaxAge
+	}
+	if age := time.Since(snap.Time); age > maxAge || age < 0 {
+		log.Printf("Snapshot %s is from %s, too long ago to restore", path, snap.Time.Format(time.RFC3339))
+		return nil
+	}
+
+	a.mu.Lock()
+	chans := a.chans
+	a.mu.Unlock()
+	alerts := 0
+	for _, ch := range chans {
+		name := ch.sensor.Name()
+		cs, ok := snap.Channels[name]
+		if !ok {
+			continue
+		}
+		ch.quality = cs.Quality
+		if cs.Quality != sensor.OK {
+			alerts++
+		}
+		if cs.Last != nil {
+			ch.last, ch.hasLast = *cs.Last, true
+		}
+		if cs.Scheduled < len(ch.schedules) {
+			ch.scheduled = cs.Scheduled
+		}
+		if len(cs.Forecasts) == len(ch.forecasts) {
+			for i, f := range ch.forecasts {
+				f.active = cs.Forecasts[i].Active
+				f.holt.Restore(cs.Forecasts[i].Holt)
+			}
+		}
+		if d, ok := ch.sensor.(*derivedSensor); ok && d.integ != nil && cs.Integral != nil {
+			d.integ.Total = *cs.Integral
+		}
+	}
+	for _, s := range a.signals {
+		if active, ok := snap.Signals[s.cfg.Name]; ok && active {
+			s.active = true
+			// A held signal stays on while its alerts last; patterns played
+			// when raised are not played again
+			if s.cfg.Pattern == PatternHold {
+				s.reason = "raised before restart"
+				s.updates <- s.reason
+			}
+		}
+	}
+
+	a.actMu.Lock()
+	for _, act := range a.actuators {
+		as, ok := snap.Actuators[act.cfg.Name]
+		if !ok {
+			continue
+		}
+		act.auto = as.Auto
+		if as.Override && time.Now().Before(as.OverrideUntil) {
+			act.override, act.overrideState = true, as.OverrideState
+			act.overrideUntil, act.overrideBy = as.OverrideUntil, as.OverrideBy
+			actuatorOverride.Set(1, act.cfg.Name)
+		}
+	}
+	a.actMu.Unlock()
+
+	log.Printf("♻️  Restored state from %s, %v old; alerting channels: %d", path, time.Since(snap.Time).Round(time.Second), alerts)
+	return nil
+}
+
+// keepSnapshot saves the snapshot on the sampling goroutine, logging a
+// failure once until a save succeeds again
+func (a *Agent) keepSnapshot(failing *bool) {
+	err := a.saveSnapshot()
+	switch {
+	case err != nil && !*failing:
+		log.Printf("⚠️  Snapshot: %v", err)
+	case err == nil && *failing:
+		log.Printf("✅ Snapshot saved again")
+	}
+	*failing = err != nil
+}
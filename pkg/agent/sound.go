@@ -0,0 +1,157 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"riscv-dev/pkg/hal"
+	"riscv-dev/pkg/sensor"
+	"riscv-dev/pkg/sound"
+)
+
+// SoundConfig measures the sound level from an audio capture device, such
+// as an I2S MEMS microphone. The channel reports the equivalent continuous
+// level over each sample interval.
+type SoundConfig struct {
+	Name string `json:"name"`
+	sound.CaptureConfig
+	Weighting string `json:"weighting,omitempty"` // A (the default) or Z
+	// Sensitivity is the microphone's output in dBFS at 94 dB SPL, from its
+	// datasheet, e.g. -26 for the INMP441; without it levels are in dBFS
+	Sensitivity *float64 `json:"sensitivity,omitempty"`
+	sensor.Range
+}
+
+// soundSensor captures audio in the background and reports its level
+type soundSensor struct {
+	cfg   SoundConfig
+	meter *sound.Meter
+	stop  context.CancelFunc
+	done  chan struct{}
+
+	mu        sync.Mutex
+	cancelRun context.CancelFunc // ends the running capture
+	resumed   chan struct{}      // closed on resuming; nil unless paused
+}
+
+func newSoundSensor(cfg SoundConfig) (*soundSensor, error) {
+	if cfg.Weighting == "" {
+		cfg.Weighting = sound.A
+	}
+	cfg.CaptureConfig = cfg.CaptureConfig.WithDefaults()
+	meter, err := sound.NewMeter(cfg.Weighting, cfg.Rate)
+	if err != nil {
+		return nil, fmt.Errorf("sound %s: %w", cfg.Name, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &soundSensor{cfg: cfg, meter: meter, stop: cancel, done: make(chan struct{})}
+	go s.capture(ctx)
+	return s, nil
+}
+
+// capture records until stopped, restarting the recorder with backoff
+// when it fails, e.g. while the device is busy, and waiting while paused
+func (s *soundSensor) capture(ctx context.Context) {
+	defer close(s.done)
+	backoff := time.Second
+	for {
+		s.mu.Lock()
+		run, cancel := context.WithCancel(ctx)
+		s.cancelRun = cancel
+		resumed := s.resumed
+		s.mu.Unlock()
+		if resumed != nil {
+			cancel()
+			select {
+			case <-resumed:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		started := time.Now()
+		err := sound.Capture(run, s.cfg.CaptureConfig, s.meter.Write)
+		paused := run.Err() != nil
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if paused {
+			continue
+		}
+		if time.Since(started) > time.Minute {
+			backoff = time.Second
+		}
+		log.Printf("❌ %s: %v (retrying in %s)", s.cfg.Name, err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		if backoff *= 2; backoff > time.Minute {
+			backoff = time.Minute
+		}
+	}
+}
+
+func (s *soundSensor) Name() string { return s.cfg.Name }
+
+func (s *soundSensor) Unit() string {
+	if s.cfg.Sensitivity == nil {
+		return "dBFS"
+	}
+	return "dB(" + s.cfg.Weighting + ")"
+}
+
+// pause stops capturing audio until called with false, freeing the CPU
+// the meter's filtering takes
+func (s *soundSensor) pause(on bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case on && s.resumed == nil:
+		s.resumed = make(chan struct{})
+		if s.cancelRun != nil {
+			s.cancelRun()
+		}
+	case !on && s.resumed != nil:
+		close(s.resumed)
+		s.resumed = nil
+	}
+}
+
+var (
+	errNoAudio = errors.New("no audio captured")
+	errPaused  = fmt.Errorf("%w: capture paused while the CPU is throttled", hal.ErrDegraded)
+)
+
+// Read returns the level of the audio captured since the previous read.
+// While paused it reports a fault without an error being logged.
+func (s *soundSensor) Read(ctx context.Context) (float64, sensor.Quality, error) {
+	s.mu.Lock()
+	paused := s.resumed != nil
+	s.mu.Unlock()
+	if paused {
+		s.meter.Level() // drops what was captured before pausing
+		return 0, sensor.Fault, errPaused
+	}
+	level, ok := s.meter.Level()
+	if !ok {
+		return 0, sensor.Fault, errNoAudio
+	}
+	if s.cfg.Sensitivity != nil {
+		level = sound.SPL(level, *s.cfg.Sensitivity)
+	}
+	return level, sensor.OK, nil
+}
+
+// Close stops capturing
+func (s *soundSensor) Close() error {
+	s.stop()
+	<-s.done
+	return nil
+}
@@ -0,0 +1,122 @@
+package agent
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"riscv-dev/pkg/metrics"
+	"riscv-dev/pkg/procstat"
+)
+
+// defaultSinkStartWait is how long New waits for sinks to set up
+const defaultSinkStartWait = 500 * time.Millisecond
+
+var startupSeconds = metrics.NewGauge("agent_startup_seconds", "Time from the process starting to the agent's first sample")
+
+// logStartup records how long the process took to take its first sample
+func logStartup(first time.Time) {
+	started, err := procstat.StartTime()
+	if err != nil {
+		return
+	}
+	d := max(first.Sub(started), 0) // StartTime is to within 10ms
+	startupSeconds.Set(d.Seconds())
+	if d > time.Second {
+		log.Printf("⚠️  First sample %v after start", d.Round(10*time.Millisecond))
+	} else {
+		log.Printf("First sample %v after start", d.Round(10*time.Millisecond))
+	}
+}
+
+// lazySink sets up a sink in the background, so that one connecting to a
+// server, or stuck on a network that is down, doesn't hold up sampling.
+// Until the sink is ready Write waits, leaving readings in the worker's
+// queue, and the sink counts as a network sink, held while offline.
+type lazySink struct {
+	ready chan struct{} // closed once sink or err is set
+
+	mu     sync.Mutex
+	sink   Sink
+	err    error
+	closed bool // closes the sink as soon as it is ready
+}
+
+// startSink calls newSink in the background
+func startSink(newSink func() (Sink, error)) *lazySink {
+	l := &lazySink{ready: make(chan struct{})}
+	go func() {
+		s, err := newSink()
+		l.mu.Lock()
+		l.sink, l.err = s, err
+		closed := l.closed
+		l.mu.Unlock()
+		close(l.ready)
+		if c, ok := s.(io.Closer); ok && closed && err == nil {
+			c.Close()
+		}
+	}()
+	return l
+}
+
+// wait waits until the sink is ready or done is closed, and reports
+// whether it is
+func (l *lazySink) wait(done <-chan struct{}) bool {
+	select {
+	case <-l.ready:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// report logs how setting up the sink ends, once it does
+func (l *lazySink) report(name string, start time.Time) {
+	<-l.ready
+	if l.err != nil {
+		log.Printf("❌ Sink %s: %v", name, l.err)
+		return
+	}
+	log.Printf("✅ Sink %s ready after %v", name, time.Since(start).Round(time.Millisecond))
+}
+
+// Write waits for the sink to be ready and writes r to it
+func (l *lazySink) Write(ctx context.Context, r Reading) error {
+	select {
+	case <-l.ready:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if l.err != nil {
+		return l.err
+	}
+	return l.sink.Write(ctx, r)
+}
+
+// Network marks the sink as needing the network: most sinks slow to set
+// up are connecting to something
+func (l *lazySink) Network() bool { return true }
+
+// Reconnect passes the change of uplink on to a ready sink
+func (l *lazySink) Reconnect() {
+	select {
+	case <-l.ready:
+		if r, ok := l.sink.(Reconnector); ok {
+			r.Reconnect()
+		}
+	default:
+	}
+}
+
+// Close closes the sink, or has it closed when it is ready
+func (l *lazySink) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	if c, ok := l.sink.(io.Closer); ok && l.err == nil {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package agent
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"riscv-dev/pkg/metrics"
+	"riscv-dev/pkg/state"
+)
+
+// Keys of the agent's counters in the state file
+const (
+	StateStarts  = "agent.starts"
+	StateSamples = "agent.samples"
+	StateUptime  = "agent.uptime_seconds" // time spent running, across restarts
+	// StateSeq is the Seq of the last reading as of the last flush, and
+	// StateSeqReserved the highest handed out (see seqBlock)
+	StateSeq         = "agent.seq"
+	StateSeqReserved = "agent.seq_reserved"
+	// StateElectionBoot numbers the last start in the election
+	StateElectionBoot = "agent.election_boot"
+)
+
+// stateFlushInterval bounds how often the state file is written, to spare
+// flash; at most this much counting is lost on a power cut
+const stateFlushInterval = time.Minute
+
+// openState opens the state file, if configured, and counts a start
+func (a *Agent) openState() error {
+	if a.cfg.StateFile == "" {
+		return nil
+	}
+	s, err := state.Open(a.cfg.StateFile)
+	if err != nil {
+		return err
+	}
+	if s.Recovered() {
+		log.Printf("⚠️  State file %s was corrupt, kept as %s.corrupt; counters start again from zero", a.cfg.StateFile, a.cfg.StateFile)
+	}
+	starts, err := s.Add(StateStarts, 1)
+	if err == nil {
+		err = s.Flush()
+	}
+	if err != nil {
+		s.Close()
+		return err
+	}
+	a.state, a.stateTime = s, time.Now()
+	a.openSeq()
+	log.Printf("Start %d, %d samples and %s of running time so far", starts,
+		s.Counter(StateSamples), (time.Duration(s.Counter(StateUptime)) * time.Second).String())
+
+	counter := func(key string) func() float64 {
+		return func() float64 { return float64(s.Counter(key)) }
+	}
+	metrics.Default.CounterFunc("agent_starts_total", "Times the agent started, across restarts", counter(StateStarts))
+	metrics.Default.CounterFunc("agent_lifetime_samples_total", "Readings taken, across restarts", counter(StateSamples))
+	metrics.Default.CounterFunc("agent_lifetime_uptime_seconds_total", "Time the agent ran, across restarts", func() float64 {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		return float64(s.Counter(StateUptime)) + time.Since(a.stateTime).Seconds()
+	})
+	return nil
+}
+
+// flushState adds the running time since the last flush and writes the
+// state file
+func (a *Agent) flushState() error {
+	if a.state == nil {
+		return nil
+	}
+	a.mu.Lock()
+	err := errors.Join(a.addUptime(), a.saveSeq(false))
+	a.mu.Unlock()
+	return errors.Join(err, a.state.Flush())
+}
+
+// addUptime adds the running time since the last call to the state, in
+// whole seconds, the remainder carrying over; a.mu must be held
+func (a *Agent) addUptime() error {
+	secs := uint64(time.Since(a.stateTime) / time.Second)
+	if _, err := a.state.Add(StateUptime, secs); err != nil {
+		return err
+	}
+	a.stateTime = a.stateTime.Add(time.Duration(secs) * time.Second)
+	return nil
+}
+
+// StateCounter returns a counter from the state file, or 0 without one
+func (a *Agent) StateCounter(key string) uint64 {
+	if a.state == nil {
+		return 0
+	}
+	return a.state.Counter(key)
+}
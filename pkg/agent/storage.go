@@ -0,0 +1,202 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"riscv-dev/pkg/config"
+	"riscv-dev/pkg/metrics"
+)
+
+// StorageConfig guards the filesystem holding the agent's files, so a
+// filling SD card degrades what is kept instead of taking the system down
+type StorageConfig struct {
+	// Path is on the filesystem watched; default data_dir, history_dir,
+	// the state file's directory or the working directory
+	Path     string          `json:"path,omitempty"`
+	Interval config.Duration `json:"interval,omitempty"` // default 30s
+	// PressurePercent is the free space, as a percentage of the
+	// filesystem, below which disk sinks are compacted (default 10)
+	PressurePercent float64 `json:"pressure_percent,omitempty"`
+	// CriticalPercent is the free space below which disk sinks are
+	// compacted again and keep only rollups (default 5)
+	CriticalPercent float64 `json:"critical_percent,omitempty"`
+	// RollupInterval is how often a disk sink writes a rollup while
+	// storage is critical; default 1m
+	RollupInterval config.Duration `json:"rollup_interval,omitempty"`
+}
+
+// DiskSink is a sink keeping readings on local storage, which the storage
+// guardian holds to its disk quota and shrinks when the disk fills
+type DiskSink interface {
+	Sink
+	// DiskUsage returns the bytes the sink keeps on disk
+	DiskUsage() int64
+	// Compact frees space, where it can, until the sink uses at most keep
+	// bytes, and returns the bytes freed
+	Compact(keep int64) (int64, error)
+}
+
+// storageLevel is how short of space the filesystem is
+type storageLevel int
+
+const (
+	storageOK storageLevel = iota
+	storagePressure
+	storageCritical
+)
+
+func (l storageLevel) String() string {
+	return [...]string{"ok", "pressure", "critical"}[l]
+}
+
+var (
+	storageFree    = metrics.NewGauge("agent_storage_free_bytes", "Free space on the filesystem holding the agent's files")
+	storageLevelG  = metrics.NewGauge("agent_storage_level", "Storage pressure: 0 ok, 1 compacting, 2 keeping only rollups")
+	storageFreed   = metrics.NewCounter("agent_storage_freed_bytes_total", "Bytes a disk sink freed to fit its quota or the free space", "sink")
+	storageSinkUse = metrics.NewGauge("agent_storage_sink_bytes", "Local storage used by a disk sink", "sink")
+)
+
+// storagePath returns the path whose filesystem is watched
+func (c Config) storagePath() string {
+	switch {
+	case c.Storage != nil && c.Storage.Path != "":
+		return c.Storage.Path
+	case c.DataDir != "":
+		return c.DataDir
+	case c.HistoryDir != "":
+		return c.HistoryDir
+	case c.StateFile != "":
+		return filepath.Dir(c.StateFile)
+	}
+	return "."
+}
+
+// diskFree returns the space available to the agent and the size of the
+// filesystem holding path
+func diskFree(path string) (free, total uint64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	// Bavail is signed on FreeBSD, so convert both sides
+	return uint64(st.Bavail) * uint64(st.Bsize), uint64(st.Blocks) * uint64(st.Bsize), nil
+}
+
+// guardStorage checks the free space and the disk sinks' quotas every
+// interval until ctx is cancelled. On entering pressure, and again on
+// entering critical, every disk sink is compacted to half of what it
+// uses; while critical they keep only rollups.
+func (a *Agent) guardStorage(ctx context.Context) {
+	sc := *a.cfg.Storage
+	if sc.Interval <= 0 {
+		sc.Interval = config.Duration(30 * time.Second)
+	}
+	if sc.PressurePercent <= 0 {
+		sc.PressurePercent = 10
+	}
+	if sc.CriticalPercent <= 0 {
+		sc.CriticalPercent = 5
+	}
+	if sc.RollupInterval <= 0 {
+		sc.RollupInterval = config.Duration(time.Minute)
+	}
+	path := a.cfg.storagePath()
+	level := storageOK
+	a.health.Register("storage", func(ctx context.Context) error {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if a.storage == storageCritical {
+			return fmt.Errorf("%s nearly full, keeping only rollups", path)
+		}
+		return nil
+	})
+
+	t := time.NewTicker(sc.Interval.D())
+	defer t.Stop()
+	var lastErr string
+	for {
+		free, total, err := diskFree(path)
+		if err != nil {
+			if err.Error() != lastErr {
+				log.Printf("⚠️  Storage: %v", err)
+				lastErr = err.Error()
+			}
+		} else {
+			lastErr = ""
+			level = a.checkStorage(sc, path, free, total, level)
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// checkStorage acts on the free space and returns the new level
+func (a *Agent) checkStorage(sc StorageConfig, path string, free, total uint64, prev storageLevel) storageLevel {
+	storageFree.Set(float64(free))
+	level := storageOK
+	pct := 100 * float64(free) / float64(max(total, 1))
+	switch {
+	case pct < sc.CriticalPercent:
+		level = storageCritical
+	case pct < sc.PressurePercent:
+		level = storagePressure
+	}
+	storageLevelG.Set(float64(level))
+	if level != prev {
+		switch level {
+		case storageCritical:
+			log.Printf("❌ Storage: %.1f%% free on %s, disk sinks keep only rollups every %v", pct, path, sc.RollupInterval.D())
+		case storagePressure:
+			log.Printf("⚠️  Storage: %.1f%% free on %s, compacting disk sinks", pct, path)
+		default:
+			log.Printf("✅ Storage: %.1f%% free on %s", pct, path)
+		}
+		a.mu.Lock()
+		a.storage = level
+		a.mu.Unlock()
+	}
+
+	a.mu.Lock()
+	sinks := a.sinks
+	a.mu.Unlock()
+	for _, w := range sinks {
+		d, ok := w.sink.(DiskSink)
+		if !ok {
+			continue
+		}
+		usage := d.DiskUsage()
+		keep := w.opts.DiskQuota
+		if level > prev {
+			keep = usage / 2
+			if w.opts.DiskQuota > 0 {
+				keep = min(keep, w.opts.DiskQuota)
+			}
+		}
+		if keep > 0 && usage > keep {
+			freed, err := d.Compact(keep)
+			if err != nil {
+				log.Printf("❌ Sink %s: freeing disk space: %v", w.name, err)
+			}
+			if freed > 0 {
+				storageFreed.Add(float64(freed), w.name)
+				log.Printf("🧹 Sink %s: freed %d bytes, %d left", w.name, freed, usage-freed)
+			}
+			usage -= freed
+		}
+		storageSinkUse.Set(float64(usage), w.name)
+		if level == storageCritical && prev != storageCritical {
+			w.shed(sc.RollupInterval.D())
+		} else if level != storageCritical && prev == storageCritical {
+			w.shed(0)
+		}
+	}
+	return level
+}
@@ -0,0 +1,180 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"riscv-dev/pkg/hal"
+	"riscv-dev/pkg/metrics"
+)
+
+// StrapsConfig reads DIP switches or jumpers on GPIO inputs at startup as
+// a configuration word, so boards running identical SD images tell
+// themselves apart, e.g. by site or by mode. Each field of the word
+// merges configuration fragments over the loaded config (see
+// ApplyStraps).
+type StrapsConfig struct {
+	// GPIO is the backend the switches are on; default the agent's gpio
+	GPIO *hal.GPIOConfig `json:"gpio,omitempty"`
+	// Bias sets the lines' pull resistors, e.g. "pull-up" for switches to
+	// ground, on backends that can; default as the kernel set them
+	Bias hal.Bias `json:"bias,omitempty"`
+	// ActiveLow counts a line reading low as a 1, as a closed switch to
+	// ground reads
+	ActiveLow bool         `json:"active_low,omitempty"`
+	Fields    []StrapField `json:"fields"`
+}
+
+// StrapField is a number read from one or more lines
+type StrapField struct {
+	Name string `json:"name"`
+	// Pins are the lines of the field's bits, least significant first
+	Pins []int `json:"pins"`
+	// Set is a config fragment merged whatever the field reads, with
+	// "{value}" in its strings replaced by the value in decimal, e.g.
+	// {"namespace": {"site": "site-{value}"}}
+	Set json.RawMessage `json:"set,omitempty"`
+	// Values are config fragments merged after Set for particular values
+	// of the field, keyed by the value in decimal, or "default" for any
+	// value without its own
+	Values map[string]json.RawMessage `json:"values,omitempty"`
+}
+
+var strapValue = metrics.NewGauge("agent_strap_value", "Value of a configuration strap field read at startup", "field")
+
+// strapSettle is how long lines are left after setting their bias, and
+// between the reads that must agree, so a switch being moved isn't read
+// half way
+const strapSettle = 10 * time.Millisecond
+
+// ApplyStraps reads cfg.Straps, if set, and merges each field's fragments
+// over cfg in the order the fields are listed. Fragments decode like
+// config.json, so objects merge field by field and lists are replaced.
+// Call it after loading the config and before applying command-line
+// overrides.
+func ApplyStraps(cfg *Config) error {
+	if cfg.Straps == nil {
+		return nil
+	}
+	// Fragments may set straps too; they don't change what is read
+	sc := *cfg.Straps
+	sc.Fields = append([]StrapField(nil), sc.Fields...)
+	gc := hal.GPIOConfig{Driver: hal.Auto}
+	switch {
+	case sc.GPIO != nil:
+		gc = *sc.GPIO
+	case cfg.GPIO != nil:
+		gc = *cfg.GPIO
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	values, err := sc.read(ctx, gc)
+	if err != nil {
+		return fmt.Errorf("straps: %w", err)
+	}
+
+	desc := make([]string, len(sc.Fields))
+	for i, f := range sc.Fields {
+		v := values[i]
+		strapValue.Set(float64(v), f.Name)
+		desc[i] = fmt.Sprintf("%s=%d", f.Name, v)
+		key := strconv.Itoa(v)
+		if len(f.Set) > 0 {
+			set := strings.ReplaceAll(string(f.Set), "{value}", key)
+			if err := json.Unmarshal([]byte(set), cfg); err != nil {
+				return fmt.Errorf("straps: %s set: %w", f.Name, err)
+			}
+		}
+		frag, ok := f.Values[key]
+		if !ok {
+			frag, ok = f.Values["default"]
+		}
+		if ok {
+			if err := json.Unmarshal(frag, cfg); err != nil {
+				return fmt.Errorf("straps: %s value %s: %w", f.Name, key, err)
+			}
+		}
+	}
+	log.Printf("🎯 Straps: %s", strings.Join(desc, " "))
+	return nil
+}
+
+// read returns the value of each field, reading the lines until two reads
+// agree
+func (sc StrapsConfig) read(ctx context.Context, gc hal.GPIOConfig) ([]int, error) {
+	var pins []int
+	for _, f := range sc.Fields {
+		if len(f.Pins) == 0 {
+			return nil, fmt.Errorf("field %q has no pins", f.Name)
+		}
+		if len(f.Pins) > 16 {
+			return nil, fmt.Errorf("field %q has %d pins, at most 16", f.Name, len(f.Pins))
+		}
+		pins = append(pins, f.Pins...)
+	}
+	gpio, err := hal.NewGPIOController(gc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GPIO: %w", err)
+	}
+	defer gpio.Close()
+	ls, canBias := gpio.(hal.LineStater)
+	if sc.Bias != hal.BiasDefault && !canBias {
+		log.Printf("⚠️  Straps: the GPIO backend can't set %s; relying on external resistors", sc.Bias)
+	}
+	for _, pin := range pins {
+		if canBias && sc.Bias != hal.BiasDefault {
+			err = ls.SetLineState(ctx, hal.LineState{Pin: pin, Mode: hal.Input, Bias: sc.Bias})
+		} else {
+			err = gpio.SetMode(pin, hal.Input)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	levels := func() (map[int]bool, error) {
+		time.Sleep(strapSettle)
+		m := make(map[int]bool, len(pins))
+		for _, pin := range pins {
+			v, err := gpio.Read(ctx, pin)
+			if err != nil {
+				return nil, err
+			}
+			m[pin] = v != sc.ActiveLow
+		}
+		return m, nil
+	}
+	prev, err := levels()
+	if err != nil {
+		return nil, err
+	}
+	for agreed := false; !agreed; {
+		cur, err := levels()
+		if err != nil {
+			return nil, err
+		}
+		agreed = true
+		for pin, v := range cur {
+			agreed = agreed && prev[pin] == v
+		}
+		prev = cur
+		if !agreed && ctx.Err() != nil {
+			return nil, fmt.Errorf("the switches didn't settle: %w", ctx.Err())
+		}
+	}
+
+	values := make([]int, len(sc.Fields))
+	for i, f := range sc.Fields {
+		for bit, pin := range f.Pins {
+			if prev[pin] {
+				values[i] |= 1 << bit
+			}
+		}
+	}
+	return values, nil
+}
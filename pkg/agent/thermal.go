@@ -0,0 +1,260 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"riscv-dev/pkg/config"
+	"riscv-dev/pkg/metrics"
+)
+
+// ThermalConfig watches for the CPU throttling itself to keep cool, as a
+// passively cooled board in a hot enclosure does, and while it lasts
+// lightens the agent's work so sampling keeps up
+type ThermalConfig struct {
+	Interval config.Duration `json:"interval,omitempty"` // default 10s
+	// HotCelsius is the CPU temperature from which a lowered frequency
+	// counts as throttling (default 70)
+	HotCelsius float64 `json:"hot_celsius,omitempty"`
+	// FreqPercent is the share of its maximum frequency a CPU must be
+	// capped below to count as throttled (default 90)
+	FreqPercent float64 `json:"freq_percent,omitempty"`
+	// Sustained is how long throttling must last before the agent reacts,
+	// and how long the CPU must stay cool before it goes back; default 1m
+	Sustained config.Duration `json:"sustained,omitempty"`
+	// SlowFactor multiplies the sample interval while throttled (default 2)
+	SlowFactor int `json:"slow_factor,omitempty"`
+	// KeepSound keeps sound level channels measuring while throttled;
+	// by default their capture is paused
+	KeepSound bool `json:"keep_sound,omitempty"`
+}
+
+// ThermalEvent reports the CPU starting or ending sustained throttling
+type ThermalEvent struct {
+	Time      time.Time `json:"time"`
+	Throttled bool      `json:"throttled"`
+	Celsius   float64   `json:"celsius"`
+	// FreqPercent is the lowest cap of a CPU's frequency, in percent of
+	// its maximum; omitted without cpufreq
+	FreqPercent *float64 `json:"freq_percent,omitempty"`
+	Message     string   `json:"message"`
+}
+
+// thermalHysteresis is how many degrees below HotCelsius the CPU must
+// cool to count as cool again, so the guard doesn't flap
+const thermalHysteresis = 5
+
+var (
+	cpuTemperature = metrics.NewGauge("agent_cpu_temperature_celsius", "Temperature of the hottest thermal zone")
+	cpuFreqCap     = metrics.NewGauge("agent_cpu_freq_cap_percent", "Lowest CPU frequency cap, in percent of the CPU's maximum")
+	thermalG       = metrics.NewGauge("agent_thermal_throttled", "Whether the CPU is throttled and the agent has cut its work")
+)
+
+// Where the kernel reports temperatures and cpufreq policies
+var (
+	thermalZones = "/sys/class/thermal/thermal_zone*/temp"
+	cpufreqDirs  = "/sys/devices/system/cpu/cpufreq/policy*"
+)
+
+// thermalState is what the guard reads each interval
+type thermalState struct {
+	celsius float64
+	// freqPercent is the lowest scaling_max_freq of the policies as a
+	// percentage of cpuinfo_max_freq, -1 without cpufreq. Thermal cooling
+	// lowers scaling_max_freq, which the idle governor leaves alone.
+	freqPercent float64
+}
+
+// readThermal returns the CPU's temperature and frequency cap, and false
+// without a thermal zone
+func readThermal() (thermalState, bool) {
+	st := thermalState{celsius: -273, freqPercent: -1}
+	zones, _ := filepath.Glob(thermalZones)
+	found := false
+	for _, z := range zones {
+		if milli, err := readSysInt(z); err == nil {
+			st.celsius, found = max(st.celsius, float64(milli)/1000), true
+		}
+	}
+	policies, _ := filepath.Glob(cpufreqDirs)
+	for _, p := range policies {
+		limit, err := readSysInt(filepath.Join(p, "scaling_max_freq"))
+		if err != nil {
+			continue
+		}
+		full, err := readSysInt(filepath.Join(p, "cpuinfo_max_freq"))
+		if err != nil || full <= 0 {
+			continue
+		}
+		pct := 100 * float64(limit) / float64(full)
+		if st.freqPercent < 0 || pct < st.freqPercent {
+			st.freqPercent = pct
+		}
+	}
+	return st, found
+}
+
+func readSysInt(path string) (int64, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+}
+
+func (st thermalState) String() string {
+	switch {
+	case st.freqPercent < 0:
+		return fmt.Sprintf("CPU at %.1f°C", st.celsius)
+	case st.freqPercent >= 100:
+		return fmt.Sprintf("CPU at %.1f°C, full frequency", st.celsius)
+	}
+	return fmt.Sprintf("CPU at %.1f°C, frequency capped at %.0f%%", st.celsius, st.freqPercent)
+}
+
+// guardThermal checks the CPU every interval until ctx is cancelled. Once
+// it has been hot and capped below its maximum frequency for Sustained, it
+// raises a thermal event, samples SlowFactor times less often and pauses
+// sound capture, until the CPU has been cool for Sustained. Without
+// cpufreq, heat alone counts.
+func (a *Agent) guardThermal(ctx context.Context) {
+	tc := *a.cfg.Thermal
+	if tc.Interval <= 0 {
+		tc.Interval = config.Duration(10 * time.Second)
+	}
+	if tc.HotCelsius <= 0 {
+		tc.HotCelsius = 70
+	}
+	if tc.FreqPercent <= 0 {
+		tc.FreqPercent = 90
+	}
+	if tc.Sustained <= 0 {
+		tc.Sustained = config.Duration(time.Minute)
+	}
+	if tc.SlowFactor <= 0 {
+		tc.SlowFactor = 2
+	}
+	st, ok := readThermal()
+	if !ok {
+		log.Printf("⚠️  Thermal guard disabled: no thermal zone in /sys/class/thermal")
+		return
+	}
+	log.Printf("🌡️ Thermal guard: %s, throttling from %g°C", st, tc.HotCelsius)
+
+	a.health.Register("thermal", func(ctx context.Context) error {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if a.throttled {
+			return fmt.Errorf("CPU throttled, sampling every %v", a.cfg.SampleInterval.D()*time.Duration(tc.SlowFactor))
+		}
+		return nil
+	})
+
+	t := time.NewTicker(tc.Interval.D())
+	defer t.Stop()
+	var since time.Time // when the CPU started to differ from the guard's state
+	throttled := false
+	for {
+		if st, ok := readThermal(); ok {
+			throttled = a.checkThermal(tc, st, time.Now(), throttled, &since)
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			if throttled {
+				a.shedForHeat(tc, false)
+			}
+			return
+		}
+	}
+}
+
+// checkThermal acts on the CPU's state and returns whether the agent is
+// now throttled
+func (a *Agent) checkThermal(tc ThermalConfig, st thermalState, now time.Time, throttled bool, since *time.Time) bool {
+	cpuTemperature.Set(st.celsius)
+	capped := st.freqPercent < 0 || st.freqPercent < tc.FreqPercent
+	if st.freqPercent >= 0 {
+		cpuFreqCap.Set(st.freqPercent)
+	}
+	changing := st.celsius >= tc.HotCelsius && capped
+	if throttled {
+		changing = st.celsius < tc.HotCelsius-thermalHysteresis || !capped
+	}
+	switch {
+	case !changing:
+		*since = time.Time{}
+		return throttled
+	case since.IsZero():
+		*since = now
+	}
+	if now.Sub(*since) < tc.Sustained.D() {
+		return throttled
+	}
+	*since = time.Time{}
+	throttled = !throttled
+	if throttled {
+		thermalG.Set(1)
+	} else {
+		thermalG.Set(0)
+	}
+
+	e := ThermalEvent{Time: now, Throttled: throttled, Celsius: st.celsius}
+	if st.freqPercent >= 0 {
+		pct := st.freqPercent
+		e.FreqPercent = &pct
+	}
+	if throttled {
+		e.Message = fmt.Sprintf("%s for %v", st, tc.Sustained.D())
+		log.Printf("❌ Thermal: %s, sampling every %v%s", e.Message, a.cfg.SampleInterval.D()*time.Duration(tc.SlowFactor), a.soundNote(tc, " and sound capture paused"))
+	} else {
+		e.Message = fmt.Sprintf("%s, no longer throttled", st)
+		log.Printf("✅ Thermal: %s, sampling every %v%s", e.Message, a.cfg.SampleInterval.D(), a.soundNote(tc, " and sound capture resumed"))
+	}
+	a.shedForHeat(tc, throttled)
+	a.events.publish(Event{Type: EventThermal, Thermal: &e})
+	return throttled
+}
+
+// soundNote returns note if the guard pauses sound capture
+func (a *Agent) soundNote(tc ThermalConfig, note string) string {
+	if tc.KeepSound || len(a.cfg.Sound) == 0 {
+		return ""
+	}
+	return note
+}
+
+// shedForHeat slows sampling and pauses sound capture while throttled,
+// and restores both after
+func (a *Agent) shedForHeat(tc ThermalConfig, throttled bool) {
+	a.mu.Lock()
+	a.throttled = throttled
+	a.slowFactor = tc.SlowFactor
+	chans := a.chans
+	a.mu.Unlock()
+	if tc.KeepSound {
+		return
+	}
+	for _, ch := range chans {
+		if s, ok := ch.sensor.(*soundSensor); ok {
+			s.pause(throttled)
+		}
+	}
+}
+
+// sampleInterval returns the time between samples, longer while the CPU
+// is throttled
+func (a *Agent) sampleInterval() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.throttled {
+		return a.cfg.SampleInterval.D() * time.Duration(a.slowFactor)
+	}
+	return a.cfg.SampleInterval.D()
+}
@@ -0,0 +1,293 @@
+// Package audit keeps an append-only record of changes to the physical
+// world and to configuration: who changed what, when, from which value to
+// which. Entries are JSON lines in a file that is rotated by size, and can
+// be queried back, e.g. over HTTP.
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"riscv-dev/pkg/auth"
+)
+
+// Config sets where the log is kept and how much of it
+type Config struct {
+	Path     string `json:"path"`
+	MaxSize  int64  `json:"max_size,omitempty"`  // bytes before rotating; default 1 MiB
+	MaxFiles int    `json:"max_files,omitempty"` // rotated files kept; default 5
+}
+
+// Entry is one recorded change
+type Entry struct {
+	Time   time.Time `json:"time"`
+	Actor  string    `json:"actor"`            // who made the change
+	Action string    `json:"action"`           // e.g. "gpio.write", "config.load"
+	Target string    `json:"target"`           // e.g. "gpio17"
+	Old    string    `json:"old,omitempty"`    // value before, if known
+	New    string    `json:"new"`              // value after
+	Reason string    `json:"reason,omitempty"` // why, if not the actor's choice
+	Error  string    `json:"error,omitempty"`  // set if the change failed
+}
+
+// Log is an append-only audit log. A nil *Log records nothing, so callers
+// don't need to check whether auditing is enabled.
+type Log struct {
+	cfg Config
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// Open opens or creates the log at cfg.Path
+func Open(cfg Config) (*Log, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("audit: path is required")
+	}
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = 1 << 20
+	}
+	if cfg.MaxFiles <= 0 {
+		cfg.MaxFiles = 5
+	}
+	l := &Log{cfg: cfg}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Log) open() error {
+	f, err := os.OpenFile(l.cfg.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("audit: %w", err)
+	}
+	l.f, l.size = f, st.Size()
+	return nil
+}
+
+type actorKey struct{}
+
+// WithActor names who acts through ctx, for changes made without an
+// authenticated request, e.g. by a schedule or a local program
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// Actor returns who acts through ctx: the caller authenticated by
+// auth.Authenticator, a name set with WithActor, or "local"
+func Actor(ctx context.Context) string {
+	if id, ok := auth.FromContext(ctx); ok {
+		return id.Name
+	}
+	if a, ok := ctx.Value(actorKey{}).(string); ok && a != "" {
+		return a
+	}
+	return "local"
+}
+
+// Record appends an entry, filling in Time and, from ctx, Actor if they
+// are unset. Each entry is synced to disk before Record returns. Errors
+// are logged rather than returned, so recording never blocks a change.
+func (l *Log) Record(ctx context.Context, e Entry) {
+	if l == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	if e.Actor == "" {
+		e.Actor = Actor(ctx)
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("❌ audit: %v", err)
+		return
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.size+int64(len(line)) > l.cfg.MaxSize && l.size > 0 {
+		if err := l.rotate(); err != nil {
+			log.Printf("❌ audit: rotating %s: %v", l.cfg.Path, err)
+		}
+	}
+	if l.f == nil {
+		log.Printf("❌ audit: %s not open, lost %s", l.cfg.Path, line)
+		return
+	}
+	n, err := l.f.Write(line)
+	l.size += int64(n)
+	if err == nil {
+		err = l.f.Sync()
+	}
+	if err != nil {
+		log.Printf("❌ audit: writing %s: %v", l.cfg.Path, err)
+	}
+}
+
+// rotate shifts path.N to path.N+1, dropping the oldest, moves the current
+// file to path.1 and starts a new one
+func (l *Log) rotate() error {
+	l.f.Close()
+	l.f = nil
+	os.Remove(l.rotated(l.cfg.MaxFiles))
+	for i := l.cfg.MaxFiles - 1; i >= 1; i-- {
+		os.Rename(l.rotated(i), l.rotated(i+1))
+	}
+	if err := os.Rename(l.cfg.Path, l.rotated(1)); err != nil {
+		l.open()
+		return err
+	}
+	return l.open()
+}
+
+func (l *Log) rotated(n int) string { return l.cfg.Path + "." + strconv.Itoa(n) }
+
+// Filter selects entries for Query; zero fields match everything
+type Filter struct {
+	Since  time.Time
+	Until  time.Time
+	Actor  string
+	Action string
+	Target string
+	Limit  int // most recent entries returned; 0 for all
+}
+
+func (f Filter) match(e Entry) bool {
+	return (f.Since.IsZero() || !e.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || e.Time.Before(f.Until)) &&
+		(f.Actor == "" || e.Actor == f.Actor) &&
+		(f.Action == "" || e.Action == f.Action) &&
+		(f.Target == "" || e.Target == f.Target)
+}
+
+// Query returns the matching entries, oldest first, from the current and
+// rotated files
+func (l *Log) Query(f Filter) ([]Entry, error) {
+	if l == nil {
+		return nil, nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var entries []Entry
+	for i := l.cfg.MaxFiles; i >= 0; i-- {
+		path := l.cfg.Path
+		if i > 0 {
+			path = l.rotated(i)
+		}
+		file, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 0, 4096), 1<<20)
+		for scanner.Scan() {
+			var e Entry
+			if json.Unmarshal(scanner.Bytes(), &e) == nil && f.match(e) {
+				entries = append(entries, e)
+			}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+	}
+	if f.Limit > 0 && len(entries) > f.Limit {
+		entries = entries[len(entries)-f.Limit:]
+	}
+	return entries, nil
+}
+
+// Last returns the most recent entry for action and target
+func (l *Log) Last(action, target string) (Entry, bool) {
+	entries, err := l.Query(Filter{Action: action, Target: target, Limit: 1})
+	if err != nil || len(entries) == 0 {
+		return Entry{}, false
+	}
+	return entries[0], true
+}
+
+// Handler serves the log as a JSON array. Query parameters since and
+// until (RFC 3339 or a duration back from now, e.g. "24h"), actor, action,
+// target and limit (default 100) filter the entries.
+func (l *Log) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		f := Filter{Actor: q.Get("actor"), Action: q.Get("action"), Target: q.Get("target"), Limit: 100}
+		var err error
+		if f.Since, err = parseTime(q.Get("since")); err != nil {
+			http.Error(w, "since: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if f.Until, err = parseTime(q.Get("until")); err != nil {
+			http.Error(w, "until: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if s := q.Get("limit"); s != "" {
+			if f.Limit, err = strconv.Atoi(s); err != nil || f.Limit < 0 {
+				http.Error(w, "limit: not a count", http.StatusBadRequest)
+				return
+			}
+		}
+		entries, err := l.Query(f)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if entries == nil {
+			entries = []Entry{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	})
+}
+
+// parseTime accepts RFC 3339 or a duration before now
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("want RFC 3339 time or duration, got %q", s)
+	}
+	return time.Now().Add(-d), nil
+}
+
+// Close closes the log file
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
@@ -0,0 +1,140 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"riscv-dev/pkg/hal"
+)
+
+// GPIO records every mode change and output write made through a
+// GPIOController. Reads pass straight through.
+type GPIO struct {
+	hal.GPIOController
+	log  *Log
+	name string // prefix for targets, e.g. "gpio" gives "gpio17"
+
+	mu    sync.Mutex
+	modes map[int]hal.PinMode
+	last  map[int]bool // last value written to each pin
+}
+
+// WrapGPIO returns g recording to l. name prefixes the pin number in the
+// Target of entries; use it to tell several controllers apart.
+func WrapGPIO(g hal.GPIOController, l *Log, name string) *GPIO {
+	if name == "" {
+		name = "gpio"
+	}
+	return &GPIO{GPIOController: g, log: l, name: name, modes: make(map[int]hal.PinMode), last: make(map[int]bool)}
+}
+
+// SetMode records the direction change
+func (g *GPIO) SetMode(pin int, mode hal.PinMode) error {
+	err := g.GPIOController.SetMode(pin, mode)
+	g.mu.Lock()
+	old, known := g.modes[pin]
+	if err == nil {
+		g.modes[pin] = mode
+	}
+	g.mu.Unlock()
+
+	e := Entry{Action: "gpio.mode", Target: g.target(pin), New: mode.String()}
+	if known {
+		if old == mode && err == nil {
+			return nil
+		}
+		e.Old = old.String()
+	}
+	g.record(context.Background(), e, err)
+	return err
+}
+
+// Write records the level change. The previous level is the last one
+// written, or read back from the pin the first time.
+func (g *GPIO) Write(ctx context.Context, pin int, value bool) error {
+	g.mu.Lock()
+	old, known := g.last[pin]
+	g.mu.Unlock()
+	if !known {
+		if v, err := g.GPIOController.Read(ctx, pin); err == nil {
+			old, known = v, true
+		}
+	}
+
+	err := g.GPIOController.Write(ctx, pin, value)
+	if err == nil {
+		g.mu.Lock()
+		g.last[pin] = value
+		g.mu.Unlock()
+	}
+	e := Entry{Action: "gpio.write", Target: g.target(pin), New: level(value)}
+	if known {
+		e.Old = level(old)
+	}
+	g.record(ctx, e, err)
+	return err
+}
+
+// LineStates passes through to the controller, see hal.SnapshotGPIO
+func (g *GPIO) LineStates(ctx context.Context) ([]hal.LineState, error) {
+	s, ok := g.GPIOController.(hal.LineStater)
+	if !ok {
+		return nil, fmt.Errorf("%T can't report line states", g.GPIOController)
+	}
+	return s.LineStates(ctx)
+}
+
+// SetLineState records the line's new state, e.g. when restoring a
+// snapshot, as "gpio.state"
+func (g *GPIO) SetLineState(ctx context.Context, s hal.LineState) error {
+	st, ok := g.GPIOController.(hal.LineStater)
+	if !ok {
+		return fmt.Errorf("%T can't set line states", g.GPIOController)
+	}
+	err := st.SetLineState(ctx, s)
+	g.mu.Lock()
+	if err == nil {
+		g.modes[s.Pin] = s.Mode
+		if s.Mode == hal.Output {
+			g.last[s.Pin] = s.Value
+		}
+	}
+	g.mu.Unlock()
+
+	state := s.Mode.String()
+	if s.Mode == hal.Output {
+		state += " " + level(s.Value)
+	}
+	if s.Bias != hal.BiasDefault {
+		state += " " + s.Bias.String()
+	}
+	g.record(ctx, Entry{Action: "gpio.state", Target: g.target(s.Pin), New: state}, err)
+	return err
+}
+
+func (g *GPIO) record(ctx context.Context, e Entry, err error) {
+	if err != nil {
+		e.Error = err.Error()
+	}
+	g.log.Record(ctx, e)
+}
+
+func (g *GPIO) target(pin int) string { return g.name + strconv.Itoa(pin) }
+
+// WatchEdges passes through to the controller if it supports edges
+func (g *GPIO) WatchEdges(ctx context.Context, pin int, edge hal.Edge) (<-chan hal.EdgeEvent, error) {
+	w, ok := g.GPIOController.(hal.EdgeWatcher)
+	if !ok {
+		return nil, fmt.Errorf("%T does not report edges", g.GPIOController)
+	}
+	return w.WatchEdges(ctx, pin, edge)
+}
+
+func level(v bool) string {
+	if v {
+		return "high"
+	}
+	return "low"
+}
@@ -0,0 +1,254 @@
+// Package auth authenticates requests to the board's HTTP endpoints with
+// static bearer tokens, HTTP basic credentials or JWTs (signed with a
+// shared secret or by keys from a JWKS URL). The same Authenticator checks
+// a raw Authorization value, so other transports can reuse it.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"riscv-dev/pkg/metrics"
+)
+
+// Config lists the accepted credentials; any one of them grants access
+type Config struct {
+	Tokens []Token     `json:"tokens,omitempty"`
+	Basic  []BasicUser `json:"basic,omitempty"`
+	JWT    *JWTConfig  `json:"jwt,omitempty"`
+	Realm  string      `json:"realm,omitempty"` // shown by browsers for basic auth
+}
+
+// Token is a static bearer token, sent as "Authorization: Bearer <token>"
+type Token struct {
+	Name  string `json:"name"` // identifies the holder in logs
+	Token string `json:"token"`
+	Role  Role   `json:"role,omitempty"` // default viewer
+}
+
+// BasicUser is a user name and password for HTTP basic authentication
+type BasicUser struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+	Role     Role   `json:"role,omitempty"` // default viewer
+}
+
+// Identity is an authenticated caller
+type Identity struct {
+	Name   string // token name, user name or JWT subject
+	Method string // "token", "basic" or "jwt"
+	Role   Role
+}
+
+// Role grants a caller a set of operations. Roles are ordered: each may do
+// everything the ones before it can.
+type Role uint8
+
+const (
+	// Viewer reads telemetry, metrics and status
+	Viewer Role = iota + 1
+	// Operator also controls outputs, e.g. toggles GPIO pins
+	Operator
+	// Admin also changes configuration and triggers updates
+	Admin
+)
+
+var roleNames = [...]string{Viewer: "viewer", Operator: "operator", Admin: "admin"}
+
+func (r Role) String() string {
+	if r >= Viewer && r <= Admin {
+		return roleNames[r]
+	}
+	return fmt.Sprintf("role(%d)", r)
+}
+
+// MarshalText encodes the role by name, e.g. "operator"
+func (r Role) MarshalText() ([]byte, error) { return []byte(r.String()), nil }
+
+// UnmarshalText decodes a role name
+func (r *Role) UnmarshalText(b []byte) error {
+	role, err := ParseRole(string(b))
+	if err != nil {
+		return err
+	}
+	*r = role
+	return nil
+}
+
+// ParseRole returns the role called name
+func ParseRole(name string) (Role, error) {
+	for r := Viewer; r <= Admin; r++ {
+		if strings.EqualFold(name, roleNames[r]) {
+			return r, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown role %q (want viewer, operator or admin)", name)
+}
+
+// Allows reports whether the caller may perform operations needing role
+func (id *Identity) Allows(role Role) bool { return id != nil && id.Role >= role }
+
+var (
+	// ErrNoCredentials is returned for requests without an Authorization header
+	ErrNoCredentials = errors.New("no credentials")
+	// ErrInvalid is returned for credentials that don't match
+	ErrInvalid = errors.New("invalid credentials")
+)
+
+var authFailures = metrics.NewCounter("auth_failures_total", "Rejected requests to authenticated endpoints", "reason")
+
+// Authenticator checks credentials against a Config. A nil Authenticator
+// lets every request through.
+type Authenticator struct {
+	tokens map[[sha256.Size]byte]Identity // by hashed token
+	basic  map[string]basicEntry
+	jwt    *jwtVerifier
+	realm  string
+}
+
+// New creates an Authenticator. It returns nil, and no error, for a nil
+// Config or one without any credentials.
+func New(cfg *Config) (*Authenticator, error) {
+	if cfg == nil || (len(cfg.Tokens) == 0 && len(cfg.Basic) == 0 && cfg.JWT == nil) {
+		return nil, nil
+	}
+	a := &Authenticator{
+		tokens: make(map[[sha256.Size]byte]Identity),
+		basic:  make(map[string]basicEntry),
+		realm:  cfg.Realm,
+	}
+	if a.realm == "" {
+		a.realm = "riscv-dev"
+	}
+	for _, t := range cfg.Tokens {
+		if t.Token == "" {
+			return nil, fmt.Errorf("auth: token %q is empty", t.Name)
+		}
+		a.tokens[sha256.Sum256([]byte(t.Token))] = Identity{Name: t.Name, Method: "token", Role: orViewer(t.Role)}
+	}
+	for _, u := range cfg.Basic {
+		if u.User == "" || u.Password == "" {
+			return nil, fmt.Errorf("auth: basic user %q needs a password", u.User)
+		}
+		a.basic[u.User] = basicEntry{hash: sha256.Sum256([]byte(u.Password)), role: orViewer(u.Role)}
+	}
+	if cfg.JWT != nil {
+		v, err := newJWTVerifier(*cfg.JWT)
+		if err != nil {
+			return nil, fmt.Errorf("auth: %w", err)
+		}
+		a.jwt = v
+	}
+	return a, nil
+}
+
+// Authenticate checks an Authorization header value: "Bearer <token>",
+// where the token is a static token or a JWT, or "Basic <credentials>".
+// A nil Authenticator accepts anything as an anonymous admin.
+func (a *Authenticator) Authenticate(ctx context.Context, authorization string) (*Identity, error) {
+	if a == nil {
+		return &Identity{Name: "anonymous", Role: Admin}, nil
+	}
+	scheme, cred, _ := strings.Cut(strings.TrimSpace(authorization), " ")
+	cred = strings.TrimSpace(cred)
+	switch {
+	case authorization == "":
+		return nil, ErrNoCredentials
+	case strings.EqualFold(scheme, "Bearer") && cred != "":
+		// Hashing first makes the lookup take the same time for any token
+		if id, ok := a.tokens[sha256.Sum256([]byte(cred))]; ok {
+			return &id, nil
+		}
+		if a.jwt != nil && strings.Count(cred, ".") == 2 {
+			sub, role, err := a.jwt.verify(ctx, cred)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+			}
+			return &Identity{Name: sub, Method: "jwt", Role: role}, nil
+		}
+	case strings.EqualFold(scheme, "Basic") && len(a.basic) > 0:
+		r := http.Request{Header: http.Header{"Authorization": {"Basic " + cred}}}
+		user, pass, ok := r.BasicAuth()
+		if !ok {
+			break
+		}
+		want, known := a.basic[user]
+		got := sha256.Sum256([]byte(pass))
+		if subtle.ConstantTimeCompare(got[:], want.hash[:]) == 1 && known {
+			return &Identity{Name: user, Method: "basic", Role: want.role}, nil
+		}
+	}
+	return nil, ErrInvalid
+}
+
+// Handler only passes requests from authenticated viewers on to h, see
+// Require
+func (a *Authenticator) Handler(h http.Handler) http.Handler { return a.Require(Viewer, h) }
+
+// Require only passes requests from callers with at least role on to h.
+// Requests without valid credentials get 401 Unauthorized and those with
+// too weak a role 403 Forbidden. The caller's Identity is available to h
+// through FromContext. Every request to an endpoint needing more than
+// Viewer is logged, allowed or not. A nil Authenticator returns h
+// unchanged.
+func (a *Authenticator) Require(role Role, h http.Handler) http.Handler {
+	if a == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := a.Authenticate(r.Context(), r.Header.Get("Authorization"))
+		if err != nil {
+			reason := "invalid"
+			if errors.Is(err, ErrNoCredentials) {
+				reason = "missing"
+			}
+			authFailures.Inc(reason)
+			if len(a.basic) > 0 {
+				w.Header().Add("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", a.realm))
+			}
+			w.Header().Add("WWW-Authenticate", fmt.Sprintf("Bearer realm=%q", a.realm))
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			if role > Viewer {
+				log.Printf("🔐 %s %s from %s: unauthorized (%v)", r.Method, r.URL.Path, r.RemoteAddr, err)
+			}
+			return
+		}
+		if !id.Allows(role) {
+			authFailures.Inc("forbidden")
+			http.Error(w, "forbidden", http.StatusForbidden)
+			log.Printf("🔐 %s %s by %s (%s): denied, needs %s", r.Method, r.URL.Path, id.Name, id.Role, role)
+			return
+		}
+		if role > Viewer {
+			log.Printf("🔐 %s %s by %s (%s)", r.Method, r.URL.Path, id.Name, id.Role)
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, id)))
+	})
+}
+
+type basicEntry struct {
+	hash [sha256.Size]byte
+	role Role
+}
+
+// orViewer defaults an unset role to Viewer, the least privileged
+func orViewer(r Role) Role {
+	if r == 0 {
+		return Viewer
+	}
+	return r
+}
+
+type identityKey struct{}
+
+// FromContext returns the caller authenticated by Handler
+func FromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(*Identity)
+	return id, ok
+}
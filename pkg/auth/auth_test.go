@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func basic(user, pass string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+pass))
+}
+
+func testAuthenticator(t *testing.T) *Authenticator {
+	t.Helper()
+	a, err := New(&Config{
+		Tokens: []Token{{Name: "ci", Token: "s3cret-token", Role: Operator}, {Name: "grafana", Token: "read-only"}},
+		Basic:  []BasicUser{{User: "admin", Password: "hunter2", Role: Admin}},
+		JWT:    &JWTConfig{Secret: testSecret},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestAuthenticate(t *testing.T) {
+	a := testAuthenticator(t)
+	jwt := makeToken(t, map[string]any{"alg": "HS256"}, map[string]any{"sub": "alice", "role": "admin", "exp": time.Now().Unix() + 60}, []byte(testSecret))
+
+	for _, tc := range []struct {
+		name          string
+		authorization string
+		who           string
+		method        string
+		role          Role
+		err           error
+	}{
+		{"token", "Bearer s3cret-token", "ci", "token", Operator, nil},
+		{"token scheme in lower case", "bearer  s3cret-token ", "ci", "token", Operator, nil},
+		{"token without a role", "Bearer read-only", "grafana", "token", Viewer, nil},
+		{"basic", basic("admin", "hunter2"), "admin", "basic", Admin, nil},
+		{"jwt", "Bearer " + jwt, "alice", "jwt", Admin, nil},
+
+		{"nothing", "", "", "", 0, ErrNoCredentials},
+		{"unknown token", "Bearer s3cret-tokem", "", "", 0, ErrInvalid},
+		{"empty bearer", "Bearer ", "", "", 0, ErrInvalid},
+		{"token without a scheme", "s3cret-token", "", "", 0, ErrInvalid},
+		{"token as basic password", basic("ci", "s3cret-token"), "", "", 0, ErrInvalid},
+		{"wrong password", basic("admin", "hunter3"), "", "", 0, ErrInvalid},
+		{"unknown user", basic("root", "hunter2"), "", "", 0, ErrInvalid},
+		{"empty password", basic("admin", ""), "", "", 0, ErrInvalid},
+		{"malformed basic", "Basic !!!", "", "", 0, ErrInvalid},
+		{"bad jwt", "Bearer " + jwt[:len(jwt)-2], "", "", 0, ErrInvalid},
+		{"unknown scheme", "Digest s3cret-token", "", "", 0, ErrInvalid},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			id, err := a.Authenticate(context.Background(), tc.authorization)
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) || id != nil {
+					t.Errorf("got %+v, %v; want %v", id, err, tc.err)
+				}
+				return
+			}
+			if err != nil || id.Name != tc.who || id.Method != tc.method || id.Role != tc.role {
+				t.Errorf("got %+v, %v", id, err)
+			}
+		})
+	}
+}
+
+func TestRequire(t *testing.T) {
+	a := testAuthenticator(t)
+	var seen *Identity
+	h := a.Require(Operator, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = FromContext(r.Context())
+	}))
+	for _, tc := range []struct {
+		authorization string
+		status        int
+		who           string
+	}{
+		{"", http.StatusUnauthorized, ""},
+		{"Bearer nope", http.StatusUnauthorized, ""},
+		{"Bearer read-only", http.StatusForbidden, ""},
+		{"Bearer s3cret-token", http.StatusOK, "ci"},
+		{basic("admin", "hunter2"), http.StatusOK, "admin"},
+	} {
+		seen = nil
+		req := httptest.NewRequest(http.MethodPost, "/gpio/17", nil)
+		if tc.authorization != "" {
+			req.Header.Set("Authorization", tc.authorization)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Errorf("%q: status %d, want %d", tc.authorization, rec.Code, tc.status)
+		}
+		if tc.status == http.StatusUnauthorized && len(rec.Header().Values("WWW-Authenticate")) != 2 {
+			t.Errorf("%q: challenges %q", tc.authorization, rec.Header().Values("WWW-Authenticate"))
+		}
+		if (seen == nil) != (tc.who == "") || seen != nil && seen.Name != tc.who {
+			t.Errorf("%q: handler saw %+v", tc.authorization, seen)
+		}
+	}
+
+	// Without credentials configured everything is let through
+	none, err := New(&Config{})
+	if none != nil || err != nil {
+		t.Fatalf("New(empty) = %v, %v", none, err)
+	}
+	called := false
+	none.Require(Admin, http.HandlerFunc(func(http.ResponseWriter, *http.Request) { called = true })).
+		ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+	if !called {
+		t.Error("nil Authenticator blocked a request")
+	}
+	if id, err := none.Authenticate(context.Background(), ""); err != nil || id == nil || id.Role != Admin {
+		t.Errorf("nil Authenticator: got %+v, %v", id, err)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	var cfg Config
+	if err := json.Unmarshal([]byte(`{"tokens": [{"name": "ci", "token": "x", "role": "Operator"}]}`), &cfg); err != nil || cfg.Tokens[0].Role != Operator {
+		t.Errorf("role from JSON: %v, %v", cfg.Tokens[0].Role, err)
+	}
+	if err := json.Unmarshal([]byte(`{"tokens": [{"name": "ci", "token": "x", "role": "root"}]}`), &cfg); err == nil {
+		t.Error("unknown role accepted")
+	}
+	for _, bad := range []*Config{
+		{Tokens: []Token{{Name: "empty"}}},
+		{Basic: []BasicUser{{User: "admin"}}},
+		{JWT: &JWTConfig{}},
+	} {
+		if _, err := New(bad); err == nil {
+			t.Errorf("New(%+v) accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha512" // SHA-384 and SHA-512
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"riscv-dev/pkg/config"
+)
+
+// JWTConfig accepts JSON Web Tokens signed with Secret (HS256/384/512) or
+// by a key published at JWKSURL (RS256/384/512, ES256/384)
+type JWTConfig struct {
+	Secret   string `json:"secret,omitempty"`
+	JWKSURL  string `json:"jwks_url,omitempty"`
+	Issuer   string `json:"issuer,omitempty"`   // required "iss", if set
+	Audience string `json:"audience,omitempty"` // required in "aud", if set
+	// NameClaim names the claim identifying the caller; default "sub"
+	NameClaim string `json:"name_claim,omitempty"`
+	// RoleClaim names the claim holding the caller's role, a string;
+	// default "role". Tokens without it are viewers.
+	RoleClaim string `json:"role_claim,omitempty"`
+	// Leeway allows for clock skew when checking exp and nbf; default 1m
+	Leeway config.Duration `json:"leeway,omitempty"`
+}
+
+// The JWKS is fetched again when a token names an unknown key or the set
+// is older than jwksMaxAge, but never more than once per jwksMinInterval
+const (
+	jwksMinInterval = time.Minute
+	jwksMaxAge      = time.Hour
+)
+
+var algHashes = map[string]crypto.Hash{
+	"HS256": crypto.SHA256, "HS384": crypto.SHA384, "HS512": crypto.SHA512,
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384,
+}
+
+type jwtVerifier struct {
+	cfg    JWTConfig
+	client *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey // by key ID
+	fetched time.Time                   // last successful fetch
+	tried   time.Time                   // last attempt
+}
+
+func newJWTVerifier(cfg JWTConfig) (*jwtVerifier, error) {
+	if cfg.Secret == "" && cfg.JWKSURL == "" {
+		return nil, errors.New("jwt needs a secret or a jwks_url")
+	}
+	if cfg.NameClaim == "" {
+		cfg.NameClaim = "sub"
+	}
+	if cfg.RoleClaim == "" {
+		cfg.RoleClaim = "role"
+	}
+	if cfg.Leeway == 0 {
+		cfg.Leeway = config.Duration(time.Minute)
+	}
+	return &jwtVerifier{cfg: cfg, client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+// verify checks the token's signature and claims and returns the caller's
+// name and role
+func (v *jwtVerifier) verify(ctx context.Context, token string) (string, Role, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", 0, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", 0, fmt.Errorf("token header: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", 0, fmt.Errorf("token signature: %w", err)
+	}
+	if err := v.checkSignature(ctx, header.Alg, header.Kid, parts[0]+"."+parts[1], sig); err != nil {
+		return "", 0, err
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", 0, fmt.Errorf("token claims: %w", err)
+	}
+	if err := v.checkClaims(claims); err != nil {
+		return "", 0, err
+	}
+	name, _ := claims[v.cfg.NameClaim].(string)
+	if name == "" {
+		return "", 0, fmt.Errorf("token has no %q claim", v.cfg.NameClaim)
+	}
+	role := Viewer
+	if c, ok := claims[v.cfg.RoleClaim]; ok {
+		s, ok := c.(string)
+		if !ok {
+			return "", 0, fmt.Errorf("token's %q claim is not a string", v.cfg.RoleClaim)
+		}
+		var err error
+		if role, err = ParseRole(s); err != nil {
+			return "", 0, err
+		}
+	}
+	return name, role, nil
+}
+
+// checkSignature verifies sig over signed. The algorithm decides the key:
+// HMAC only ever uses the shared secret and RSA/ECDSA only JWKS keys, so a
+// public key can't be passed off as an HMAC secret.
+func (v *jwtVerifier) checkSignature(ctx context.Context, alg, kid, signed string, sig []byte) error {
+	hash, ok := algHashes[alg]
+	if !ok {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	if alg[0] == 'H' {
+		if v.cfg.Secret == "" {
+			return fmt.Errorf("algorithm %s not accepted", alg)
+		}
+		mac := hmac.New(hash.New, []byte(v.cfg.Secret))
+		mac.Write([]byte(signed))
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return errors.New("bad signature")
+		}
+		return nil
+	}
+
+	if v.cfg.JWKSURL == "" {
+		return fmt.Errorf("algorithm %s not accepted", alg)
+	}
+	key, err := v.key(ctx, kid)
+	if err != nil {
+		return err
+	}
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if alg[0] != 'R' {
+			return fmt.Errorf("key %q is RSA, token uses %s", kid, alg)
+		}
+		if rsa.VerifyPKCS1v15(k, hash, digest, sig) != nil {
+			return errors.New("bad signature")
+		}
+	case *ecdsa.PublicKey:
+		// ES256 is P-256 with SHA-256, ES384 P-384 with SHA-384
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if alg[0] != 'E' || size != hash.Size() || len(sig) != 2*size {
+			return fmt.Errorf("key %q doesn't match %s signature", kid, alg)
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return errors.New("bad signature")
+		}
+	default:
+		return fmt.Errorf("unsupported key type %T", key)
+	}
+	return nil
+}
+
+func (v *jwtVerifier) checkClaims(claims map[string]any) error {
+	now := time.Now()
+	leeway := v.cfg.Leeway.D()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("token has no expiry")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(leeway)) {
+		return errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(leeway).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("token not valid yet")
+	}
+	if v.cfg.Issuer != "" && claims["iss"] != v.cfg.Issuer {
+		return fmt.Errorf("token issuer %v not accepted", claims["iss"])
+	}
+	if v.cfg.Audience != "" && !hasAudience(claims["aud"], v.cfg.Audience) {
+		return errors.New("token not meant for this audience")
+	}
+	return nil
+}
+
+// hasAudience reports whether aud, a string or list of strings, has want
+func hasAudience(aud any, want string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == want
+	case []any:
+		for _, s := range a {
+			if s == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// key returns the JWKS key with ID kid, fetching the set if it's unknown
+// or old
+func (v *jwtVerifier) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	key, ok := v.keys[kid]
+	stale := time.Since(v.fetched) > jwksMaxAge
+	if (!ok || stale) && time.Since(v.tried) > jwksMinInterval {
+		v.tried = time.Now()
+		keys, err := fetchJWKS(ctx, v.client, v.cfg.JWKSURL)
+		if err != nil {
+			if ok {
+				return key, nil // keep using the old set until it can be refreshed
+			}
+			return nil, err
+		}
+		v.keys, v.fetched = keys, time.Now()
+		key, ok = keys[kid]
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	return key, nil
+}
+
+// fetchJWKS downloads a JSON Web Key Set and returns its RSA and EC keys
+func fetchJWKS(ctx context.Context, client *http.Client, url string) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching JWKS: %s", resp.Status)
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&set); err != nil {
+		return nil, fmt.Errorf("decoding JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err1 != nil || err2 != nil || len(e) == 0 || len(e) > 4 {
+				continue
+			}
+			key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+			if key.N.BitLen() < 2048 {
+				continue
+			}
+			keys[k.Kid] = key
+		case "EC":
+			if key := ecKey(k.Crv, k.X, k.Y); key != nil {
+				keys[k.Kid] = key
+			}
+		}
+	}
+	return keys, nil
+}
+
+// ecKey decodes a P-256 or P-384 public key, or returns nil if it isn't a
+// valid point on the curve
+func ecKey(crv, x, y string) *ecdsa.PublicKey {
+	var curve elliptic.Curve
+	var check ecdh.Curve
+	switch crv {
+	case "P-256":
+		curve, check = elliptic.P256(), ecdh.P256()
+	case "P-384":
+		curve, check = elliptic.P384(), ecdh.P384()
+	default:
+		return nil
+	}
+	xb, err1 := base64.RawURLEncoding.DecodeString(x)
+	yb, err2 := base64.RawURLEncoding.DecodeString(y)
+	size := (curve.Params().BitSize + 7) / 8
+	if err1 != nil || err2 != nil || len(xb) != size || len(yb) != size {
+		return nil
+	}
+	point := append(append([]byte{4}, xb...), yb...)
+	if _, err := check.NewPublicKey(point); err != nil {
+		return nil
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(xb), Y: new(big.Int).SetBytes(yb)}
+}
+
+func decodeSegment(seg string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,246 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testSecret = "correct horse battery staple"
+
+var (
+	rsaKey, _  = rsa.GenerateKey(rand.Reader, 2048)
+	p256Key, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384Key, _ = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+)
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+// makeToken encodes header and claims and signs them with key: an HMAC
+// secret as []byte, an RSA or ECDSA private key, or nil for no signature
+func makeToken(t *testing.T, header, claims map[string]any, key any) string {
+	t.Helper()
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	signed := b64(h) + "." + b64(c)
+	alg, _ := header["alg"].(string)
+	hash := algHashes[alg]
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(hash.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		d := hash.New()
+		d.Write([]byte(signed))
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, hash, d.Sum(nil)); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		d := hash.New()
+		d.Write([]byte(signed))
+		r, s, err := ecdsa.Sign(rand.Reader, k, d.Sum(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		sig = append(r.FillBytes(make([]byte, size)), s.FillBytes(make([]byte, size))...)
+	}
+	return signed + "." + b64(sig)
+}
+
+func ecJWK(kid string, k *ecdsa.PrivateKey) map[string]any {
+	size := (k.Curve.Params().BitSize + 7) / 8
+	return map[string]any{
+		"kty": "EC", "kid": kid, "crv": k.Curve.Params().Name,
+		"x": b64(k.X.FillBytes(make([]byte, size))), "y": b64(k.Y.FillBytes(make([]byte, size))),
+	}
+}
+
+// jwksServer publishes the test keys and counts how often they are fetched
+func jwksServer(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var fetches atomic.Int32
+	keys := []map[string]any{
+		{"kty": "RSA", "kid": "rsa", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		ecJWK("p256", p256Key),
+		ecJWK("p384", p384Key),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &fetches
+}
+
+func TestJWT(t *testing.T) {
+	srv, _ := jwksServer(t)
+	cfg := JWTConfig{Secret: testSecret, JWKSURL: srv.URL, Issuer: "https://idp.example", Audience: "board"}
+	now := time.Now().Unix()
+	claims := func(extra ...any) map[string]any {
+		c := map[string]any{"sub": "alice", "iss": "https://idp.example", "aud": "board", "exp": now + 300}
+		for i := 0; i < len(extra); i += 2 {
+			if extra[i+1] == nil {
+				delete(c, extra[i].(string))
+			} else {
+				c[extra[i].(string)] = extra[i+1]
+			}
+		}
+		return c
+	}
+	hs := func(alg string) map[string]any { return map[string]any{"alg": alg, "typ": "JWT"} }
+	kid := func(alg, kid string) map[string]any { return map[string]any{"alg": alg, "kid": kid} }
+	secret := []byte(testSecret)
+	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: must(x509.MarshalPKIXPublicKey(&rsaKey.PublicKey))})
+
+	for _, tc := range []struct {
+		name  string
+		cfg   *JWTConfig // default cfg
+		token string
+		role  Role // 0 for rejected
+		err   string
+	}{
+		{name: "HS256", token: makeToken(t, hs("HS256"), claims("role", "operator"), secret), role: Operator},
+		{name: "HS512", token: makeToken(t, hs("HS512"), claims(), secret), role: Viewer},
+		{name: "RS256", token: makeToken(t, kid("RS256", "rsa"), claims("role", "admin"), rsaKey), role: Admin},
+		{name: "RS384", token: makeToken(t, kid("RS384", "rsa"), claims(), rsaKey), role: Viewer},
+		{name: "ES256", token: makeToken(t, kid("ES256", "p256"), claims(), p256Key), role: Viewer},
+		{name: "ES384", token: makeToken(t, kid("ES384", "p384"), claims(), p384Key), role: Viewer},
+		{name: "audience in a list", token: makeToken(t, hs("HS256"), claims("aud", []string{"other", "board"}), secret), role: Viewer},
+		{name: "expired within leeway", token: makeToken(t, hs("HS256"), claims("exp", now-30), secret), role: Viewer},
+		{name: "valid soon within leeway", token: makeToken(t, hs("HS256"), claims("nbf", now+30), secret), role: Viewer},
+
+		{name: "alg none", token: makeToken(t, hs("none"), claims("role", "admin"), nil), err: "unsupported algorithm"},
+		{name: "alg None", token: makeToken(t, hs("None"), claims("role", "admin"), nil), err: "unsupported algorithm"},
+		{name: "HS256 signed with the JWKS public key", token: makeToken(t, kid("HS256", "rsa"), claims("role", "admin"), pubPEM), err: "bad signature"},
+		{name: "HS256 without a secret", cfg: &JWTConfig{JWKSURL: srv.URL}, token: makeToken(t, kid("HS256", "rsa"), claims("role", "admin"), pubPEM), err: "not accepted"},
+		{name: "RS256 without a JWKS", cfg: &JWTConfig{Secret: testSecret}, token: makeToken(t, kid("RS256", "rsa"), claims(), rsaKey), err: "not accepted"},
+		{name: "RSA key for ES256", token: makeToken(t, kid("ES256", "rsa"), claims(), p256Key), err: "is RSA"},
+		{name: "EC key for RS256", token: makeToken(t, kid("RS256", "p256"), claims(), rsaKey), err: "doesn't match"},
+		{name: "P-256 key for ES384", token: makeToken(t, kid("ES384", "p256"), claims(), p256Key), err: "doesn't match"},
+		{name: "wrong secret", token: makeToken(t, hs("HS256"), claims(), []byte("guess")), err: "bad signature"},
+		{name: "someone else's RSA key", token: makeToken(t, kid("RS256", "rsa"), claims(), must(rsa.GenerateKey(rand.Reader, 2048))), err: "bad signature"},
+		{name: "unknown key", token: makeToken(t, kid("ES256", "other"), claims(), p256Key), err: "unknown key"},
+		{name: "expired", token: makeToken(t, hs("HS256"), claims("exp", now-120), secret), err: "expired"},
+		{name: "no expiry", token: makeToken(t, hs("HS256"), claims("exp", nil), secret), err: "no expiry"},
+		{name: "not valid yet", token: makeToken(t, hs("HS256"), claims("nbf", now+120), secret), err: "not valid yet"},
+		{name: "wrong issuer", token: makeToken(t, hs("HS256"), claims("iss", "https://evil.example"), secret), err: "issuer"},
+		{name: "wrong audience", token: makeToken(t, hs("HS256"), claims("aud", "other"), secret), err: "audience"},
+		{name: "no audience", token: makeToken(t, hs("HS256"), claims("aud", nil), secret), err: "audience"},
+		{name: "no subject", token: makeToken(t, hs("HS256"), claims("sub", nil), secret), err: `no "sub" claim`},
+		{name: "unknown role", token: makeToken(t, hs("HS256"), claims("role", "root"), secret), err: "unknown role"},
+		{name: "numeric role", token: makeToken(t, hs("HS256"), claims("role", 3), secret), err: "not a string"},
+		{name: "role list", token: makeToken(t, hs("HS256"), claims("role", []string{"admin"}), secret), err: "not a string"},
+		{name: "two parts", token: "eyJhbGciOiJIUzI1NiJ9.e30", err: "malformed"},
+		{name: "bad signature encoding", token: makeToken(t, hs("HS256"), claims(), secret) + "!", err: "signature"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := cfg
+			if tc.cfg != nil {
+				c = *tc.cfg
+			}
+			v, err := newJWTVerifier(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			name, role, err := v.verify(context.Background(), tc.token)
+			if tc.role == 0 {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("got %s as %v, %v; want error containing %q", name, role, err, tc.err)
+				}
+				return
+			}
+			if err != nil || name != "alice" || role != tc.role {
+				t.Errorf("got %s as %v, %v; want alice as %v", name, role, err, tc.role)
+			}
+		})
+	}
+}
+
+func TestJWTTamperedClaims(t *testing.T) {
+	v, _ := newJWTVerifier(JWTConfig{Secret: testSecret})
+	token := makeToken(t, map[string]any{"alg": "HS256"}, map[string]any{"sub": "alice", "exp": time.Now().Unix() + 60}, []byte(testSecret))
+	parts := strings.Split(token, ".")
+	claims, _ := json.Marshal(map[string]any{"sub": "alice", "exp": time.Now().Unix() + 60, "role": "admin"})
+	if _, _, err := v.verify(context.Background(), parts[0]+"."+b64(claims)+"."+parts[2]); err == nil {
+		t.Error("accepted claims changed after signing")
+	}
+}
+
+func TestJWTUnknownKeyType(t *testing.T) {
+	v, _ := newJWTVerifier(JWTConfig{JWKSURL: "http://jwks.invalid"})
+	// fetchJWKS skips key types it doesn't know, so plant one directly
+	pub, _, _ := ed25519.GenerateKey(rand.Reader)
+	v.keys = map[string]crypto.PublicKey{"ed": pub}
+	v.fetched, v.tried = time.Now(), time.Now()
+	token := makeToken(t, map[string]any{"alg": "RS256", "kid": "ed"}, map[string]any{"sub": "alice", "exp": time.Now().Unix() + 60}, rsaKey)
+	if _, _, err := v.verify(context.Background(), token); err == nil || !strings.Contains(err.Error(), "unsupported key type") {
+		t.Errorf("got %v, want unsupported key type", err)
+	}
+}
+
+func TestJWKSRefresh(t *testing.T) {
+	srv, fetches := jwksServer(t)
+	v, _ := newJWTVerifier(JWTConfig{JWKSURL: srv.URL})
+	ctx := context.Background()
+
+	if _, err := v.key(ctx, "p256"); err != nil || fetches.Load() != 1 {
+		t.Fatalf("first key: %v after %d fetches", err, fetches.Load())
+	}
+	// Known keys come from the cached set
+	if _, err := v.key(ctx, "rsa"); err != nil || fetches.Load() != 1 {
+		t.Errorf("cached key: %v after %d fetches", err, fetches.Load())
+	}
+	// Tokens naming unknown keys can't make it fetch over and over
+	for i := 0; i < 10; i++ {
+		if _, err := v.key(ctx, "unknown"); err == nil {
+			t.Fatal("unknown key accepted")
+		}
+	}
+	if fetches.Load() != 1 {
+		t.Errorf("%d fetches for unknown keys within a minute", fetches.Load())
+	}
+	// but once jwksMinInterval has passed an unknown key is looked up
+	v.tried = time.Now().Add(-jwksMinInterval - time.Second)
+	v.key(ctx, "unknown")
+	if fetches.Load() != 2 {
+		t.Errorf("%d fetches after the interval, want 2", fetches.Load())
+	}
+
+	// An old set is refreshed, and kept if the refresh fails
+	v.fetched = time.Now().Add(-jwksMaxAge - time.Second)
+	v.tried = v.fetched
+	srv.Close()
+	if _, err := v.key(ctx, "p256"); err != nil {
+		t.Errorf("key from the old set while the JWKS is down: %v", err)
+	}
+	if _, err := v.key(ctx, "other"); err == nil {
+		t.Error("unknown key accepted while the JWKS is down")
+	}
+}
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
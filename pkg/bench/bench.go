@@ -0,0 +1,271 @@
+// Package bench measures how fast, and at what energy cost, a build runs
+// the agent's hot path on a board: a standard workload that samples
+// simulated channels, filters them, encodes readings as JSON and
+// broadcasts them to local subscribers, as the agent does with an IPC
+// sink. Comparing builds (GORISCV64 profiles, inlining) and boards on the
+// same workload shows what a profile buys before it ships.
+//
+// The workload runs on the sim backend so it is CPU-bound and repeatable:
+// a real ADC would mostly measure its own conversion time. A run is timed
+// per stage; the counters in perf.go add cycles and instructions, and an
+// INA219 on the board's supply adds energy (see energy.go).
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"riscv-dev/pkg/agent"
+	"riscv-dev/pkg/ipc"
+	"riscv-dev/pkg/sensor"
+	"riscv-dev/pkg/sim"
+)
+
+// Workload sizes the standard workload. Results are only comparable
+// between runs of the same workload.
+type Workload struct {
+	Samples     int   `json:"samples"`     // readings produced, default 20000
+	Channels    int   `json:"channels"`    // channels per reading, default 8
+	Subscribers int   `json:"subscribers"` // local subscribers to every reading, default 4
+	Seed        int64 `json:"seed"`        // for the channels' signals, default 1
+}
+
+// withDefaults fills in the zero fields
+func (w Workload) withDefaults() Workload {
+	if w.Samples <= 0 {
+		w.Samples = 20000
+	}
+	if w.Channels <= 0 {
+		w.Channels = 8
+	}
+	if w.Subscribers < 0 {
+		w.Subscribers = 0
+	} else if w.Subscribers == 0 {
+		w.Subscribers = 4
+	}
+	if w.Seed == 0 {
+		w.Seed = 1
+	}
+	return w
+}
+
+// Timing is where a run of the workload spent its time, in seconds
+type Timing struct {
+	Sample    float64 `json:"sample"`    // reading and scaling the ADC channels
+	Filter    float64 `json:"filter"`    // spike rejection, smoothing, history and statistics
+	Encode    float64 `json:"encode"`    // JSON encoding of the readings
+	Broadcast float64 `json:"broadcast"` // publishing until every subscriber has every reading
+	Total     float64 `json:"total"`
+	Bytes     int64   `json:"bytes"`     // JSON encoded
+	Delivered int     `json:"delivered"` // readings received, over all subscribers
+}
+
+// statsEvery is how many samples pass between statistics over a channel's
+// history, as a dashboard or rollup would ask for them
+const statsEvery = 500
+
+// channel is one channel's filtering state
+type channel struct {
+	name    string
+	rng     sensor.Range
+	recent  [5]float64 // for the median
+	n       int
+	holt    sensor.Holt
+	history *sensor.History
+}
+
+// median returns the median of the last five values, rejecting spikes
+func (c *channel) median(v float64) float64 {
+	c.recent[c.n%len(c.recent)] = v
+	c.n++
+	m := min(c.n, len(c.recent))
+	var sorted [5]float64
+	copy(sorted[:], c.recent[:m])
+	for i := 1; i < m; i++ {
+		for j := i; j > 0 && sorted[j] < sorted[j-1]; j-- {
+			sorted[j], sorted[j-1] = sorted[j-1], sorted[j]
+		}
+	}
+	return sorted[m/2]
+}
+
+// Run runs the workload once. dir holds the broadcast socket; empty uses a
+// temporary directory.
+func Run(ctx context.Context, w Workload, dir string) (Timing, error) {
+	w = w.withDefaults()
+	if dir == "" {
+		tmp, err := os.MkdirTemp("", "riscv-dev-bench-")
+		if err != nil {
+			return Timing{}, err
+		}
+		defer os.RemoveAll(tmp)
+		dir = tmp
+	}
+
+	// Signals: a sine per channel with its own period and phase, plus
+	// occasional spikes for the median to reject
+	rnd := rand.New(rand.NewSource(w.Seed))
+	adc := sim.NewADC(4095, 3.3)
+	chans := make([]*channel, w.Channels)
+	var step int
+	for i := range chans {
+		period := 200 + rnd.Intn(800)
+		phase := rnd.Float64() * 2 * math.Pi
+		spikes := rand.New(rand.NewSource(w.Seed + int64(i) + 1))
+		adc.SetSource(i, func() int {
+			v := 2048 + 1500*math.Sin(2*math.Pi*float64(step)/float64(period)+phase)
+			if spikes.Intn(100) == 0 {
+				v = 4095
+			}
+			return int(v)
+		})
+		lo, hi := -40.0, 125.0
+		chans[i] = &channel{
+			name:    "ch" + strconv.Itoa(i),
+			rng:     sensor.Range{Min: &lo, Max: &hi},
+			history: sensor.NewHistory(2 * statsEvery),
+		}
+	}
+
+	pub, err := ipc.Listen(filepath.Join(dir, "bench.sock"), ipc.Hello{Kind: agent.ReadingsKind, Source: "bench"}, w.Samples)
+	if err != nil {
+		return Timing{}, err
+	}
+	received := make([]int, w.Subscribers)
+	subErr := make([]error, w.Subscribers)
+	var wg sync.WaitGroup
+	for i := range received {
+		sub, err := ipc.Dial(filepath.Join(dir, "bench.sock"), agent.ReadingsKind)
+		if err != nil {
+			pub.Close()
+			return Timing{}, err
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer sub.Close()
+			for {
+				var r agent.Reading
+				if err := sub.Receive(&r); err != nil {
+					// The stream ends when the publisher closes
+					if received[i] < w.Samples {
+						subErr[i] = err
+					}
+					return
+				}
+				received[i]++
+			}
+		}(i)
+	}
+	// Publish only once everyone is listening, or early readings are lost
+	for deadline := time.Now().Add(5 * time.Second); pub.Subscribers() < w.Subscribers; {
+		if time.Now().After(deadline) {
+			pub.Close()
+			wg.Wait()
+			return Timing{}, fmt.Errorf("only %d of %d subscribers connected", pub.Subscribers(), w.Subscribers)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	var t Timing
+	var sample, filter, encode, broadcast time.Duration
+	start := time.Now()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	raw := make([]int, w.Channels)
+	values := make([]float64, w.Channels)
+	for step = 0; step < w.Samples; step++ {
+		if err := ctx.Err(); err != nil {
+			pub.Close()
+			wg.Wait()
+			return Timing{}, err
+		}
+		now = now.Add(100 * time.Millisecond)
+
+		t0 := time.Now()
+		for i := range chans {
+			if raw[i], err = adc.ReadChannel(ctx, i); err != nil {
+				pub.Close()
+				wg.Wait()
+				return Timing{}, err
+			}
+			// A TMP36: 10mV/°C from 500mV
+			values[i] = (float64(raw[i])*3.3/4095 - 0.5) * 100
+		}
+
+		t1 := time.Now()
+		r := agent.Reading{Time: now, Seq: uint64(step + 1), Source: "bench", Channels: make([]agent.ChannelReading, len(chans))}
+		for i, c := range chans {
+			v := c.median(values[i])
+			q := sensor.OK
+			if !c.rng.Contains(v) {
+				q = sensor.OutOfRange
+			}
+			s := sensor.Sample{Time: now, Value: v, Quality: q}
+			c.holt.Add(s)
+			c.history.Add(s)
+			if step%statsEvery == statsEvery-1 {
+				sensor.Compute(c.history.Since(now.Add(-time.Minute)), c.rng)
+			}
+			rawCount := raw[i]
+			r.Channels[i] = agent.ChannelReading{Name: c.name, Unit: "°C", Value: v, Quality: q, Raw: &rawCount}
+		}
+
+		t2 := time.Now()
+		data, err := json.Marshal(r)
+		if err != nil {
+			pub.Close()
+			wg.Wait()
+			return Timing{}, err
+		}
+		t.Bytes += int64(len(data))
+
+		t3 := time.Now()
+		pub.Publish(r)
+		t4 := time.Now()
+
+		sample += t1.Sub(t0)
+		filter += t2.Sub(t1)
+		encode += t3.Sub(t2)
+		broadcast += t4.Sub(t3)
+	}
+	// Delivery is part of the broadcast: Close flushes every queue
+	t4 := time.Now()
+	pub.Close()
+	wg.Wait()
+	broadcast += time.Since(t4)
+	t.Total = time.Since(start).Seconds()
+
+	t.Sample, t.Filter, t.Encode, t.Broadcast = sample.Seconds(), filter.Seconds(), encode.Seconds(), broadcast.Seconds()
+	for i, n := range received {
+		t.Delivered += n
+		if subErr[i] != nil {
+			return t, fmt.Errorf("subscriber %d: %w after %d readings", i, subErr[i], n)
+		}
+	}
+	if want := w.Samples * w.Subscribers; t.Delivered != want {
+		return t, fmt.Errorf("subscribers received %d of %d readings", t.Delivered, want)
+	}
+	return t, nil
+}
+
+// median returns the median of values, which it sorts
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return math.NaN()
+	}
+	sort.Float64s(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
+}
@@ -0,0 +1,84 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	w := Workload{Samples: 200, Channels: 3, Subscribers: 2}
+	timing, err := Run(context.Background(), w, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if timing.Delivered != 400 {
+		t.Errorf("delivered %d readings, want 400", timing.Delivered)
+	}
+	if timing.Bytes == 0 || timing.Total <= 0 {
+		t.Errorf("nothing measured: %+v", timing)
+	}
+	if stages := timing.Sample + timing.Filter + timing.Encode + timing.Broadcast; stages > timing.Total {
+		t.Errorf("stages took %gs, more than the %gs total", stages, timing.Total)
+	}
+}
+
+func TestIntegrate(t *testing.T) {
+	ms := time.Millisecond
+	// 1W rising to 3W over a second, then holding for another
+	e := integrate([]powerPoint{{0, 1}, {500 * ms, 2}, {1000 * ms, 3}}, 2*time.Second)
+	if math.Abs(e.Joules-5) > 1e-9 || math.Abs(e.MeanWatts-2.5) > 1e-9 || e.PeakWatts != 3 || e.Samples != 3 {
+		t.Errorf("got %+v, want 5J at 2.5W mean, 3W peak", e)
+	}
+	// The first reading holds back to the start
+	if e := integrate([]powerPoint{{time.Second, 2}}, time.Second); e.Joules != 2 {
+		t.Errorf("got %gJ from a single reading, want 2", e.Joules)
+	}
+	if e := integrate(nil, time.Second); e.Joules != 0 || e.Samples != 0 {
+		t.Errorf("got %+v without readings", e)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	idle := 1.0
+	results := []Result{
+		{Board: "A", Variant: "rva20u64", IdleWatts: &idle, Runs: []Measurement{
+			{Wall: 2, Counters: &Counters{Cycles: 2e9, Instructions: 1e9}, Energy: &Energy{Joules: 6}},
+			{Wall: 2.2, Counters: &Counters{Cycles: 2.2e9, Instructions: 1e9}, Energy: &Energy{Joules: 6.6}},
+			{Wall: 1.8, Counters: &Counters{Cycles: 1.8e9, Instructions: 1e9}, Energy: &Energy{Joules: 5.4}},
+		}},
+		{Board: "A", Variant: "rva22u64", IdleWatts: &idle, Runs: []Measurement{
+			{Wall: 1, Counters: &Counters{Cycles: 1e9, Instructions: 0.8e9}, Energy: &Energy{Joules: 2}},
+		}},
+		{Board: "B", Variant: "rva20u64", Runs: []Measurement{{Wall: 4}}},
+	}
+	rows := Compare(results)
+	if len(rows) != 3 {
+		t.Fatalf("got %d rows", len(rows))
+	}
+	if s := rows[0].Summary; s.Wall != 2 || s.Cycles != 2e9 || s.IPC != 0.5 || s.ActiveJoules != 4 || math.Abs(s.Spread-0.2) > 1e-9 {
+		t.Errorf("baseline summary %+v", s)
+	}
+	if r := rows[1]; r.Speedup != 2 || r.EnergyRatio != 0.25 {
+		t.Errorf("rva22u64: speedup %g, energy ratio %g; want 2 and 0.25", r.Speedup, r.EnergyRatio)
+	}
+	// Another board is its own baseline, and has no energy
+	if r := rows[2]; r.Speedup != 1 || !math.IsNaN(r.EnergyRatio) || !math.IsNaN(r.Summary.Cycles) {
+		t.Errorf("board B: %+v", r)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteTable(&buf, rows, true); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 5 || !strings.HasPrefix(lines[1], "|---|") {
+		t.Fatalf("markdown table:\n%s", buf.String())
+	}
+	if !strings.Contains(lines[3], "| 2.00x |") || !strings.Contains(lines[4], "| - |") {
+		t.Errorf("markdown rows:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,121 @@
+package bench
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"riscv-dev/pkg/hal"
+)
+
+// PowerMeter reads a supply's power, e.g. a *hal.INA219 in series with the
+// board's supply
+type PowerMeter interface {
+	Read(ctx context.Context) (hal.PowerReading, error)
+}
+
+// DefaultPowerInterval is how often a Recorder reads the meter: an INA219
+// at 12-bit takes about 0.5ms per conversion, and reading it over I2C at
+// 100kHz about as long
+const DefaultPowerInterval = 10 * time.Millisecond
+
+// Energy is what a power meter measured over an interval
+type Energy struct {
+	Joules    float64 `json:"joules"`
+	MeanWatts float64 `json:"mean_watts"`
+	PeakWatts float64 `json:"peak_watts"`
+	Samples   int     `json:"samples"`
+}
+
+// powerPoint is one reading of the meter
+type powerPoint struct {
+	t     time.Duration // since the recording started
+	watts float64
+}
+
+// integrate sums the energy under the readings by the trapezoid rule,
+// taking the first and last readings to hold to the ends of the interval
+func integrate(points []powerPoint, interval time.Duration) Energy {
+	e := Energy{Samples: len(points)}
+	if len(points) == 0 {
+		return e
+	}
+	e.PeakWatts = points[0].watts
+	e.Joules = points[0].watts * points[0].t.Seconds()
+	for i := 1; i < len(points); i++ {
+		dt := (points[i].t - points[i-1].t).Seconds()
+		e.Joules += (points[i].watts + points[i-1].watts) / 2 * dt
+		e.PeakWatts = max(e.PeakWatts, points[i].watts)
+	}
+	last := points[len(points)-1]
+	if rest := interval - last.t; rest > 0 {
+		e.Joules += last.watts * rest.Seconds()
+	}
+	if interval > 0 {
+		e.MeanWatts = e.Joules / interval.Seconds()
+	}
+	return e
+}
+
+// Recorder reads a power meter in the background until stopped. The
+// readings cost CPU time in the recording process, so the process under
+// measurement should be another.
+type Recorder struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	start  time.Time
+
+	mu     sync.Mutex
+	points []powerPoint
+	err    error
+}
+
+// Record starts reading m every interval (DefaultPowerInterval if zero)
+func Record(ctx context.Context, m PowerMeter, interval time.Duration) *Recorder {
+	if interval <= 0 {
+		interval = DefaultPowerInterval
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	r := &Recorder{cancel: cancel, done: make(chan struct{}), start: time.Now()}
+	go func() {
+		defer close(r.done)
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+		for {
+			p, err := m.Read(ctx)
+			r.mu.Lock()
+			if err != nil {
+				if ctx.Err() == nil && r.err == nil {
+					r.err = err
+				}
+			} else {
+				r.points = append(r.points, powerPoint{t: time.Since(r.start), watts: p.Watts})
+			}
+			r.mu.Unlock()
+			select {
+			case <-tick.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return r
+}
+
+// Stop ends the recording and returns the energy used since it started.
+// An error is the first failed reading; the energy is then from the
+// readings that succeeded.
+func (r *Recorder) Stop() (Energy, error) {
+	elapsed := time.Since(r.start)
+	r.cancel()
+	<-r.done
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var kept []powerPoint
+	for _, p := range r.points {
+		if p.t <= elapsed {
+			kept = append(kept, p)
+		}
+	}
+	return integrate(kept, elapsed), r.err
+}
@@ -0,0 +1,128 @@
+package bench
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+)
+
+// ErrCountersUnavailable is returned by CountProcess when the kernel won't
+// count cycles and instructions: no PMU driver (on RISC-V, SBI PMU support
+// in firmware and kernel), or perf_event_paranoid set above 2
+var ErrCountersUnavailable = errors.New("perf counters unavailable")
+
+// Counters are the CPU counters of a process over its lifetime
+type Counters struct {
+	Cycles       uint64 `json:"cycles"`
+	Instructions uint64 `json:"instructions"`
+	// Kernel is set when the counts include time in the kernel, as on PMUs
+	// that can't tell the privilege modes apart
+	Kernel bool `json:"kernel,omitempty"`
+	// Scaled is set when the kernel multiplexed more events than the PMU
+	// has counters, so the counts are estimates scaled up from the time
+	// each event was counted
+	Scaled bool `json:"scaled,omitempty"`
+}
+
+// IPC returns the instructions retired per cycle
+func (c Counters) IPC() float64 {
+	if c.Cycles == 0 {
+		return 0
+	}
+	return float64(c.Instructions) / float64(c.Cycles)
+}
+
+// perf_event_open(2)
+const (
+	perfTypeHardware         = 0
+	perfCountHWCPUCycles     = 0
+	perfCountHWInstructions  = 1
+	perfFormatTotalEnabled   = 1 << 0
+	perfFormatTotalRunning   = 1 << 1
+	perfFlagDisabled         = 1 << 0
+	perfFlagInherit          = 1 << 1
+	perfFlagExcludeKernel    = 1 << 5
+	perfFlagExcludeHV        = 1 << 6
+	perfFlagEnableOnExec     = 1 << 12
+	perfEventOpenFlagCloexec = 1 << 3
+)
+
+// perfEventAttr is struct perf_event_attr up to its first published size
+type perfEventAttr struct {
+	Type         uint32
+	Size         uint32
+	Config       uint64
+	SamplePeriod uint64
+	SampleType   uint64
+	ReadFormat   uint64
+	Flags        uint64
+	WakeupEvents uint32
+	BPType       uint32
+	Config1      uint64
+}
+
+// readCounter returns a counter's value, scaled if it was multiplexed
+func readCounter(f *os.File) (value uint64, scaled bool, err error) {
+	var buf [24]byte
+	if _, err := f.Read(buf[:]); err != nil {
+		return 0, false, err
+	}
+	value = binary.NativeEndian.Uint64(buf[0:])
+	enabled := binary.NativeEndian.Uint64(buf[8:])
+	running := binary.NativeEndian.Uint64(buf[16:])
+	if running > 0 && running < enabled {
+		return uint64(float64(value) * float64(enabled) / float64(running)), true, nil
+	}
+	return value, false, nil
+}
+
+// CountProcess runs cmd to completion and returns the cycles and
+// instructions it took in user space. Without counters it returns an
+// error wrapping ErrCountersUnavailable before starting cmd, so that the
+// caller can run it uncounted.
+func CountProcess(cmd *exec.Cmd) (Counters, error) {
+	// The counters follow this thread's children, so it must be the
+	// thread that forks
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	var c Counters
+	open := func() (cycles, instructions *os.File, err error) {
+		if cycles, err = openCounter(perfCountHWCPUCycles, c.Kernel); err != nil {
+			return nil, nil, fmt.Errorf("%w: cycles: %w", ErrCountersUnavailable, err)
+		}
+		if instructions, err = openCounter(perfCountHWInstructions, c.Kernel); err != nil {
+			cycles.Close()
+			return nil, nil, fmt.Errorf("%w: instructions: %w", ErrCountersUnavailable, err)
+		}
+		return cycles, instructions, nil
+	}
+	cycles, instructions, err := open()
+	if errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.EINVAL) {
+		// The PMU can't exclude the kernel; count it too
+		c.Kernel = true
+		cycles, instructions, err = open()
+	}
+	if err != nil {
+		return Counters{}, err
+	}
+	defer cycles.Close()
+	defer instructions.Close()
+
+	if err := cmd.Run(); err != nil {
+		return Counters{}, err
+	}
+	var scaledCycles, scaledInstructions bool
+	if c.Cycles, scaledCycles, err = readCounter(cycles); err != nil {
+		return Counters{}, fmt.Errorf("reading cycles: %w", err)
+	}
+	if c.Instructions, scaledInstructions, err = readCounter(instructions); err != nil {
+		return Counters{}, fmt.Errorf("reading instructions: %w", err)
+	}
+	c.Scaled = scaledCycles || scaledInstructions
+	return c, nil
+}
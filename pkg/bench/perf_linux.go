@@ -0,0 +1,29 @@
+package bench
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// openCounter opens a counter for the calling thread and the processes it
+// starts from now on. It stays disabled in this process and is enabled in
+// a child when the child execs, so the counts are the child's alone.
+func openCounter(config uint64, kernel bool) (*os.File, error) {
+	attr := perfEventAttr{
+		Type:       perfTypeHardware,
+		Size:       uint32(unsafe.Sizeof(perfEventAttr{})),
+		Config:     config,
+		ReadFormat: perfFormatTotalEnabled | perfFormatTotalRunning,
+		Flags:      perfFlagDisabled | perfFlagInherit | perfFlagEnableOnExec,
+	}
+	if !kernel {
+		attr.Flags |= perfFlagExcludeKernel | perfFlagExcludeHV
+	}
+	fd, _, errno := syscall.Syscall6(syscall.SYS_PERF_EVENT_OPEN, uintptr(unsafe.Pointer(&attr)),
+		0, ^uintptr(0), ^uintptr(0), perfEventOpenFlagCloexec, 0)
+	if errno != 0 {
+		return nil, errno
+	}
+	return os.NewFile(fd, "perf_event"), nil
+}
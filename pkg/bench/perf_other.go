@@ -0,0 +1,13 @@
+//go:build !linux
+
+package bench
+
+import (
+	"os"
+	"syscall"
+)
+
+// openCounter fails off Linux, which alone has perf_event_open
+func openCounter(config uint64, kernel bool) (*os.File, error) {
+	return nil, syscall.ENOSYS
+}
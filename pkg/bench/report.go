@@ -0,0 +1,223 @@
+package bench
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"text/tabwriter"
+
+	"riscv-dev/pkg/buildinfo"
+)
+
+// Measurement is one measured run of the workload in a separate process
+type Measurement struct {
+	Wall     float64   `json:"wall"` // seconds, from starting the process to its exit
+	Timing   Timing    `json:"timing"`
+	Counters *Counters `json:"counters,omitempty"`
+	Energy   *Energy   `json:"energy,omitempty"`
+}
+
+// Result is a build's runs of the workload on a board
+type Result struct {
+	Board    string         `json:"board"`             // device-tree model, or the host name
+	Host     string         `json:"host,omitempty"`    // as the board was reached
+	Variant  string         `json:"variant,omitempty"` // the build's name, e.g. rva22u64
+	Build    buildinfo.Info `json:"build"`
+	Workload Workload       `json:"workload"`
+	// IdleWatts is the board's draw before the runs, with nothing running
+	IdleWatts *float64      `json:"idle_watts,omitempty"`
+	Runs      []Measurement `json:"runs"`
+	// Why counters or energy are missing from the runs
+	CountersError string `json:"counters_error,omitempty"`
+	EnergyError   string `json:"energy_error,omitempty"`
+}
+
+// Summary condenses a result's runs to their medians. Values that weren't
+// measured are NaN.
+type Summary struct {
+	Wall         float64 // seconds
+	Workload     float64 // the workload's own time, without process start
+	Cycles       float64
+	Instructions float64
+	IPC          float64
+	Joules       float64
+	// ActiveJoules is the energy above the idle draw, what the workload
+	// itself cost
+	ActiveJoules float64
+	MeanWatts    float64
+	// Spread is the difference between the slowest and fastest run as a
+	// fraction of the median wall time: differences between builds
+	// smaller than this are noise
+	Spread float64
+}
+
+// Summarize returns the medians of the runs
+func (r Result) Summarize() Summary {
+	pick := func(f func(Measurement) (float64, bool)) float64 {
+		var values []float64
+		for _, run := range r.Runs {
+			if v, ok := f(run); ok {
+				values = append(values, v)
+			}
+		}
+		return median(values)
+	}
+	s := Summary{
+		Wall:     pick(func(run Measurement) (float64, bool) { return run.Wall, true }),
+		Workload: pick(func(run Measurement) (float64, bool) { return run.Timing.Total, true }),
+		Cycles: pick(func(run Measurement) (float64, bool) {
+			return counter(run, func(c Counters) uint64 { return c.Cycles })
+		}),
+		Instructions: pick(func(run Measurement) (float64, bool) {
+			return counter(run, func(c Counters) uint64 { return c.Instructions })
+		}),
+		IPC: pick(func(run Measurement) (float64, bool) {
+			if run.Counters == nil || run.Counters.Cycles == 0 {
+				return 0, false
+			}
+			return run.Counters.IPC(), true
+		}),
+		Joules: pick(func(run Measurement) (float64, bool) {
+			return energy(run, func(e Energy) float64 { return e.Joules })
+		}),
+		MeanWatts: pick(func(run Measurement) (float64, bool) {
+			return energy(run, func(e Energy) float64 { return e.MeanWatts })
+		}),
+		ActiveJoules: math.NaN(),
+	}
+	if r.IdleWatts != nil {
+		s.ActiveJoules = pick(func(run Measurement) (float64, bool) {
+			if run.Energy == nil {
+				return 0, false
+			}
+			return run.Energy.Joules - *r.IdleWatts*run.Wall, true
+		})
+	}
+	if len(r.Runs) > 1 && s.Wall > 0 {
+		lo, hi := math.Inf(1), math.Inf(-1)
+		for _, run := range r.Runs {
+			lo, hi = math.Min(lo, run.Wall), math.Max(hi, run.Wall)
+		}
+		s.Spread = (hi - lo) / s.Wall
+	}
+	return s
+}
+
+func counter(run Measurement, f func(Counters) uint64) (float64, bool) {
+	if run.Counters == nil {
+		return 0, false
+	}
+	return float64(f(*run.Counters)), true
+}
+
+func energy(run Measurement, f func(Energy) float64) (float64, bool) {
+	if run.Energy == nil {
+		return 0, false
+	}
+	return f(*run.Energy), true
+}
+
+// Row is a result in a comparison
+type Row struct {
+	Board   string
+	Variant string
+	Summary Summary
+	// Speedup is the wall time of the board's first result over this
+	// one's: above 1 is faster
+	Speedup float64
+	// EnergyRatio is this result's energy over the board's first result's,
+	// active energy if the idle draw is known: below 1 is cheaper. NaN
+	// without a power meter.
+	EnergyRatio float64
+}
+
+// Compare summarises results in order, each against the first result for
+// the same board, so that builds are compared on equal hardware
+func Compare(results []Result) []Row {
+	first := make(map[string]Summary)
+	var rows []Row
+	for _, r := range results {
+		s := r.Summarize()
+		base, ok := first[r.Board]
+		if !ok {
+			first[r.Board], base = s, s
+		}
+		variant := r.Variant
+		if variant == "" {
+			variant = r.Build.Profile
+		}
+		joules, baseJoules := s.Joules, base.Joules
+		if !math.IsNaN(s.ActiveJoules) && !math.IsNaN(base.ActiveJoules) {
+			joules, baseJoules = s.ActiveJoules, base.ActiveJoules
+		}
+		rows = append(rows, Row{
+			Board:       r.Board,
+			Variant:     variant,
+			Summary:     s,
+			Speedup:     base.Wall / s.Wall,
+			EnergyRatio: joules / baseJoules,
+		})
+	}
+	return rows
+}
+
+// WriteTable writes a comparison as an aligned text table, or as a
+// Markdown table
+func WriteTable(w io.Writer, rows []Row, markdown bool) error {
+	header := []string{"Board", "Build", "Wall", "±", "Speedup", "Cycles", "Instr", "IPC", "Energy", "Power", "Energy ratio"}
+	lines := [][]string{header}
+	for _, r := range rows {
+		s := r.Summary
+		joules := s.Joules
+		if !math.IsNaN(s.ActiveJoules) {
+			joules = s.ActiveJoules
+		}
+		lines = append(lines, []string{
+			r.Board,
+			r.Variant,
+			format(s.Wall, "%.3fs"),
+			format(s.Spread*100, "%.1f%%"),
+			format(r.Speedup, "%.2fx"),
+			formatCount(s.Cycles),
+			formatCount(s.Instructions),
+			format(s.IPC, "%.2f"),
+			format(joules, "%.2fJ"),
+			format(s.MeanWatts, "%.2fW"),
+			format(r.EnergyRatio, "%.2f"),
+		})
+	}
+
+	if markdown {
+		for i, line := range lines {
+			if _, err := fmt.Fprintf(w, "| %s |\n", strings.Join(line, " | ")); err != nil {
+				return err
+			}
+			if i == 0 {
+				fmt.Fprintf(w, "|%s\n", strings.Repeat("---|", len(line)))
+			}
+		}
+		return nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, line := range lines {
+		fmt.Fprintln(tw, strings.Join(line, "\t"))
+	}
+	return tw.Flush()
+}
+
+// format formats v, or a dash if it wasn't measured
+func format(v float64, verb string) string {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return "-"
+	}
+	return fmt.Sprintf(verb, v)
+}
+
+// formatCount formats a counter in millions
+func formatCount(v float64) string {
+	if math.IsNaN(v) {
+		return "-"
+	}
+	return fmt.Sprintf("%.1fM", v/1e6)
+}
@@ -0,0 +1,226 @@
+// Package boards is the database of RISC-V boards the tools know: how to
+// recognise each one from its device-tree model, and which HAL features
+// work on it. riscv-dev capabilities prints it as a capability matrix,
+// for scripts and for the docs, and the agent warns at startup when its
+// configuration needs a feature the board it runs on doesn't have.
+//
+// Board names match the simulator's profiles (see riscv-dev/pkg/sim), and
+// feature names the kernel features preflight checks (see
+// riscv-dev/pkg/kmod), plus "overlay" for device-tree overlays.
+package boards
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"riscv-dev/pkg/kmod"
+)
+
+// Support says whether a feature works on a board
+type Support string
+
+const (
+	Yes     Support = "yes"
+	Partial Support = "partial" // with a caveat, given in the note
+	No      Support = "no"
+)
+
+// Capability is a board's support for one feature
+type Capability struct {
+	Support Support `json:"support"`
+	Note    string  `json:"note,omitempty"`
+}
+
+// Board is a board in the database
+type Board struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	SoC         string `json:"soc"`
+	// Models are what /proc/device-tree/model starts with on the board
+	Models   []string              `json:"models"`
+	Features map[string]Capability `json:"features"`
+}
+
+// Overlay is the feature name of device-tree overlays (see
+// riscv-dev/pkg/overlay), which kmod doesn't check
+const Overlay = "overlay"
+
+// Features returns the features the database covers, in matrix order
+func Features() []string {
+	names := make([]string, 0, len(kmod.Requirements)+1)
+	for _, r := range kmod.Requirements {
+		names = append(names, r.Feature)
+	}
+	return append(names, Overlay)
+}
+
+// Describe returns what a feature is, e.g. "I2C character devices"
+func Describe(feature string) string {
+	if feature == Overlay {
+		return "Device-tree overlays"
+	}
+	for _, r := range kmod.Requirements {
+		if r.Feature == feature {
+			return r.What
+		}
+	}
+	return feature
+}
+
+var (
+	yes = Capability{Support: Yes}
+	no  = Capability{Support: No}
+)
+
+func partial(note string) Capability { return Capability{Support: Partial, Note: note} }
+func none(note string) Capability    { return Capability{Support: No, Note: note} }
+
+// db holds the boards by name
+var db = map[string]*Board{
+	"visionfive2": {
+		Name:        "visionfive2",
+		Description: "StarFive VisionFive 2",
+		SoC:         "JH7110",
+		Models:      []string{"StarFive VisionFive 2"},
+		Features: map[string]Capability{
+			"gpio":       yes,
+			"i2c":        yes,
+			"spi":        yes,
+			"iio":        none("no ADC on the header; use an ADS1115 on I2C"),
+			"pwm":        yes,
+			"mtd":        yes,
+			"sound":      partial("no microphone input on the board; use a USB audio adapter"),
+			"usb-gadget": none("the USB-C port only takes power"),
+			Overlay:      yes,
+		},
+	},
+	"milkv-duo": {
+		Name:        "milkv-duo",
+		Description: "Milk-V Duo",
+		SoC:         "CV1800B",
+		Models:      []string{"Milk-V Duo", "Cvitek. CV180X"},
+		Features: map[string]Capability{
+			"gpio":       yes,
+			"i2c":        partial("most buses share pins with other functions; set them with duo-pinmux"),
+			"spi":        partial("SPI2 shares pins with other functions; set them with duo-pinmux"),
+			"iio":        {Support: Yes, Note: "3 channels at 1.8 V full scale"},
+			"pwm":        yes,
+			"mtd":        none("boots from the SD card; there is no SPI NOR flash"),
+			"sound":      none("no audio input is routed out"),
+			"usb-gadget": yes,
+			Overlay:      none("the images configure pin functions with duo-pinmux instead"),
+		},
+	},
+	"hifive-unmatched": {
+		Name:        "hifive-unmatched",
+		Description: "SiFive HiFive Unmatched",
+		SoC:         "FU740",
+		Models:      []string{"SiFive HiFive Unmatched"},
+		Features: map[string]Capability{
+			"gpio":       yes,
+			"i2c":        yes,
+			"spi":        partial("the controllers serve the boot flash and the microSD slot; nothing is on a header"),
+			"iio":        none("no ADC; use an ADS1115 on I2C"),
+			"pwm":        partial("both controllers drive the board's LEDs"),
+			"mtd":        yes,
+			"sound":      none("no audio hardware; use a USB audio adapter"),
+			"usb-gadget": none("the USB ports are host only"),
+			Overlay:      no,
+		},
+	},
+	"qemu-virt": {
+		Name:        "qemu-virt",
+		Description: "QEMU virt machine",
+		SoC:         "virt",
+		Models:      []string{"riscv-virtio"},
+		Features: map[string]Capability{
+			"gpio":       none("no GPIO controller; use the simulator (RISCV_DEV_SIM_BOARD)"),
+			"i2c":        none("no I2C controller; use the simulator"),
+			"spi":        no,
+			"iio":        none("no ADC; use the simulator"),
+			"pwm":        no,
+			"mtd":        partial("only with flash images attached as -drive if=pflash"),
+			"sound":      no,
+			"usb-gadget": no,
+			Overlay:      no,
+		},
+	},
+}
+
+// All returns the boards sorted by name
+func All() []*Board {
+	all := make([]*Board, 0, len(db))
+	for _, b := range db {
+		all = append(all, b)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
+}
+
+// Lookup returns the board with the given name
+func Lookup(name string) (*Board, bool) {
+	b, ok := db[strings.ToLower(name)]
+	return b, ok
+}
+
+// Match returns the board whose device-tree model is model, or nil if it
+// isn't in the database
+func Match(model string) *Board {
+	model = strings.ToLower(strings.TrimSpace(strings.TrimRight(model, "\x00")))
+	for _, b := range All() {
+		for _, m := range b.Models {
+			if strings.HasPrefix(model, strings.ToLower(m)) {
+				return b
+			}
+		}
+	}
+	return nil
+}
+
+// modelFiles hold the device-tree model, on boards that have one
+var modelFiles = []string{"/proc/device-tree/model", "/sys/firmware/devicetree/base/model"}
+
+// Detect returns the board this runs on and its device-tree model. The
+// board is nil if the model isn't in the database or there is none (the
+// model is then empty), e.g. on a PC.
+func Detect() (*Board, string) {
+	for _, path := range modelFiles {
+		if data, err := os.ReadFile(path); err == nil {
+			model := strings.TrimSpace(strings.TrimRight(string(data), "\x00"))
+			return Match(model), model
+		}
+	}
+	return nil, ""
+}
+
+// Support returns the board's support for feature. Features the database
+// doesn't cover for the board count as supported, so they are never
+// warned about.
+func (b *Board) Support(feature string) Capability {
+	if c, ok := b.Features[feature]; ok {
+		return c
+	}
+	return yes
+}
+
+// Check returns a warning for each of features the board lacks or only
+// partly supports
+func (b *Board) Check(features []string) []string {
+	var warnings []string
+	for _, f := range features {
+		c := b.Support(f)
+		switch c.Support {
+		case No:
+			w := fmt.Sprintf("%s has no %s (%s)", b.Description, Describe(f), f)
+			if c.Note != "" {
+				w += ": " + c.Note
+			}
+			warnings = append(warnings, w)
+		case Partial:
+			warnings = append(warnings, fmt.Sprintf("%s has limited %s (%s): %s", b.Description, Describe(f), f, c.Note))
+		}
+	}
+	return warnings
+}
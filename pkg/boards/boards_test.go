@@ -0,0 +1,67 @@
+package boards
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	for model, want := range map[string]string{
+		"StarFive VisionFive 2 v1.3B\x00": "visionfive2",
+		"Milk-V Duo":                      "milkv-duo",
+		"Cvitek. CV180X ASIC. C906.":      "milkv-duo",
+		"riscv-virtio,qemu":               "qemu-virt",
+	} {
+		if b := Match(model); b == nil || b.Name != want {
+			t.Errorf("Match(%q) = %v, want %s", model, b, want)
+		}
+	}
+	if b := Match("Raspberry Pi 4 Model B"); b != nil {
+		t.Errorf("matched %s", b.Name)
+	}
+}
+
+// TestComplete makes sure every board says something about every feature
+func TestComplete(t *testing.T) {
+	for _, b := range All() {
+		for _, f := range Features() {
+			c, ok := b.Features[f]
+			if !ok {
+				t.Errorf("%s: no entry for %s", b.Name, f)
+			}
+			if c.Support == Partial && c.Note == "" {
+				t.Errorf("%s: %s is partial without a note", b.Name, f)
+			}
+		}
+		if len(b.Features) != len(Features()) {
+			t.Errorf("%s has entries for unknown features", b.Name)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	b, _ := Lookup("milkv-duo")
+	got := b.Check([]string{"gpio", "i2c", "mtd"})
+	if len(got) != 2 || !strings.Contains(got[0], "limited I2C") || !strings.Contains(got[1], "no MTD") {
+		t.Errorf("Check = %q", got)
+	}
+}
+
+// TestDocs fails when the matrix in the docs is out of date; regenerate
+// it with make docs
+func TestDocs(t *testing.T) {
+	const path = "../../docs/setup/board-capabilities.md"
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got bytes.Buffer
+	if err := NewMatrix().WriteMarkdown(&got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Errorf("%s is out of date; run make docs", path)
+	}
+}
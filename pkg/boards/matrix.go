@@ -0,0 +1,99 @@
+package boards
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Matrix is the capability matrix: every board's support for every
+// feature, as riscv-dev capabilities prints it
+type Matrix struct {
+	Features []Feature `json:"features"`
+	Boards   []*Board  `json:"boards"`
+}
+
+// Feature is a column of the matrix
+type Feature struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// NewMatrix returns the matrix of the whole database. Every board has
+// every feature, so consumers need no defaults.
+func NewMatrix() Matrix {
+	var m Matrix
+	for _, f := range Features() {
+		m.Features = append(m.Features, Feature{Name: f, Description: Describe(f)})
+	}
+	for _, b := range All() {
+		full := *b
+		full.Features = make(map[string]Capability, len(m.Features))
+		for _, f := range m.Features {
+			full.Features[f.Name] = b.Support(f.Name)
+		}
+		m.Boards = append(m.Boards, &full)
+	}
+	return m
+}
+
+var marks = map[Support]string{Yes: "✅", Partial: "⚠️", No: "❌"}
+
+// WriteMarkdown writes the matrix as a Markdown page: a table of boards
+// by feature, with the notes numbered below it
+func (m Matrix) WriteMarkdown(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("# Board Capabilities\n\n")
+	b.WriteString("<!-- Generated by `riscv-dev capabilities -format markdown`; edit pkg/boards instead. -->\n\n")
+	b.WriteString("Which HAL features work on which board. The agent warns at startup when\n")
+	b.WriteString("its configuration needs a feature marked ❌ or ⚠️ for the board it runs on,\n")
+	b.WriteString("and `riscv-dev capabilities` prints this as JSON for scripts.\n\n")
+
+	b.WriteString("| Board | SoC |")
+	for _, f := range m.Features {
+		fmt.Fprintf(&b, " %s |", f.Name)
+	}
+	b.WriteString("\n|---|---|")
+	b.WriteString(strings.Repeat(":---:|", len(m.Features)))
+	b.WriteString("\n")
+	var notes []string
+	for _, board := range m.Boards {
+		fmt.Fprintf(&b, "| %s (`%s`) | %s |", board.Description, board.Name, board.SoC)
+		for _, f := range m.Features {
+			c := board.Features[f.Name]
+			cell := marks[c.Support]
+			if c.Note != "" {
+				notes = append(notes, fmt.Sprintf("%s, %s: %s", board.Description, f.Name, c.Note))
+				cell += fmt.Sprintf(" <sup>%d</sup>", len(notes))
+			}
+			fmt.Fprintf(&b, " %s |", cell)
+		}
+		b.WriteString("\n")
+	}
+
+	b.WriteString("\n✅ supported, ⚠️ with limits, ❌ not available\n\n")
+	for i, n := range notes {
+		fmt.Fprintf(&b, "%d. %s\n", i+1, n)
+	}
+
+	b.WriteString("\n## Features\n\n")
+	for _, f := range m.Features {
+		fmt.Fprintf(&b, "- `%s`: %s\n", f.Name, f.Description)
+	}
+	b.WriteString("\n## Boards\n\n")
+	b.WriteString("Boards are recognised by the start of their device-tree model\n")
+	b.WriteString("(`/proc/device-tree/model`):\n\n")
+	for _, board := range m.Boards {
+		fmt.Fprintf(&b, "- `%s`: %s\n", board.Name, quoteAll(board.Models))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func quoteAll(s []string) string {
+	q := make([]string, len(s))
+	for i, v := range s {
+		q[i] = fmt.Sprintf("%q", v)
+	}
+	return strings.Join(q, ", ")
+}
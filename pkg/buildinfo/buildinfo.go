@@ -0,0 +1,142 @@
+// Package buildinfo holds the version, commit and build time stamped into
+// binaries by `riscv-dev build`, which sets the variables with -ldflags -X.
+// Binaries built with plain `go build` fall back to what the Go toolchain
+// records: the module version and, inside a git checkout, the commit.
+//
+// Every binary reports its build the same way, for telling apart the
+// builds running across a fleet: a -version flag (see RegisterFlag) and,
+// where it serves HTTP, a /version endpoint (see Handler).
+package buildinfo
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// Set by `riscv-dev build` with -ldflags "-X riscv-dev/pkg/buildinfo.Version=..."
+var (
+	// Version is `git describe` of the checkout, e.g. v0.4.1-3-gabc1234-dirty
+	Version string
+	// Commit is the full commit hash
+	Commit string
+	// Date is the build time, RFC 3339 in UTC
+	Date string
+	// Target is the build target: board, qemu or host
+	Target string
+)
+
+// Info describes a binary's build
+type Info struct {
+	Version   string    `json:"version"`
+	Commit    string    `json:"commit,omitempty"`
+	Date      time.Time `json:"date"`
+	Target    string    `json:"target,omitempty"`
+	GoVersion string    `json:"go_version"`
+	GOOS      string    `json:"goos"`
+	GOARCH    string    `json:"goarch"`
+	// Profile is the instruction set the code generation assumed, e.g.
+	// GORISCV64=rva22u64 on riscv64 or GOAMD64=v3 on amd64
+	Profile string `json:"profile,omitempty"`
+	// Tags are the build tags the binary was built with
+	Tags []string `json:"tags,omitempty"`
+	// Features are the optional subsystems compiled in (see AddFeature)
+	Features []string `json:"features"`
+}
+
+// Get returns the build's description, with the stamped values where set
+// and the toolchain's otherwise
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		Target:    Target,
+		GoVersion: runtime.Version(),
+		GOOS:      runtime.GOOS,
+		GOARCH:    runtime.GOARCH,
+		Features:  Features(),
+	}
+	if t, err := time.Parse(time.RFC3339, Date); err == nil {
+		info.Date = t
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+			info.Version = bi.Main.Version
+		}
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "GO" + strings.ToUpper(runtime.GOARCH):
+				info.Profile = s.Value
+			case "-tags":
+				info.Tags = strings.Split(s.Value, ",")
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = s.Value
+				}
+			case "vcs.time":
+				if t, err := time.Parse(time.RFC3339, s.Value); err == nil && info.Date.IsZero() {
+					info.Date = t
+				}
+			}
+		}
+	}
+	if info.Version == "" {
+		info.Version = "devel"
+	}
+	return info
+}
+
+// String is a one-line summary, e.g. "v0.4.1 (abc1234,
+// 2024-05-01T10:00:00Z) go1.22.3 linux/riscv64 rva22u64 tags=minimal
+// features=s3"
+func (i Info) String() string {
+	s := i.Version
+	switch {
+	case i.Commit != "" && !i.Date.IsZero():
+		s += fmt.Sprintf(" (%s, %s)", short(i.Commit), i.Date.UTC().Format(time.RFC3339))
+	case i.Commit != "":
+		s += fmt.Sprintf(" (%s)", short(i.Commit))
+	}
+	s += fmt.Sprintf(" %s %s/%s", i.GoVersion, i.GOOS, i.GOARCH)
+	if i.Profile != "" {
+		s += " " + i.Profile
+	}
+	if len(i.Tags) > 0 {
+		s += " tags=" + strings.Join(i.Tags, ",")
+	}
+	if len(i.Features) > 0 {
+		s += " features=" + strings.Join(i.Features, ",")
+	}
+	return s
+}
+
+// RegisterFlag adds -version to fs, which prints the build and exits
+func RegisterFlag(fs *flag.FlagSet) {
+	fs.BoolFunc("version", "print the version, commit and build date, and exit", func(string) error {
+		fmt.Printf("%s %s\n", filepath.Base(fs.Name()), Get())
+		os.Exit(0)
+		return nil
+	})
+}
+
+// Handler serves the build as JSON, for a /version endpoint
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Get())
+	})
+}
+
+func short(commit string) string {
+	if len(commit) > 7 {
+		return commit[:7]
+	}
+	return commit
+}
@@ -0,0 +1,36 @@
+package buildinfo
+
+import (
+	"sort"
+	"sync"
+)
+
+// Optional subsystems that make a binary noticeably bigger sit behind build
+// tags, so a minimal binary for a board with little flash can leave them
+// out. Those built in by default are dropped with -tags no_<feature>, or
+// all at once with -tags minimal; those pulling in large dependencies are
+// opt-in, with -tags <feature>. Either way the subsystem's file registers
+// the feature from init, so the binary reports what it has.
+var (
+	featuresMu sync.Mutex
+	features   = make(map[string]bool)
+)
+
+// AddFeature records that an optional subsystem was compiled in
+func AddFeature(name string) {
+	featuresMu.Lock()
+	defer featuresMu.Unlock()
+	features[name] = true
+}
+
+// Features returns the optional subsystems compiled in, sorted
+func Features() []string {
+	featuresMu.Lock()
+	defer featuresMu.Unlock()
+	names := make([]string, 0, len(features))
+	for name := range features {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
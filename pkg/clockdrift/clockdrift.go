@@ -0,0 +1,174 @@
+// Package clockdrift estimates how far the local clock is off a server's,
+// and how fast it drifts, from the timestamps of the server's responses,
+// for boards with no battery-backed RTC and no reliable NTP.
+//
+// Each exchange bounds the offset: the server stamped its response, to its
+// resolution, at some point between sending the request and receiving the
+// response. Intersecting the bounds of recent exchanges narrows the
+// estimate well below the resolution, e.g. the 1s of an HTTP Date header,
+// as request times fall at different points within the server's second.
+package clockdrift
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Estimate is the offset of the server's clock from the local one, which
+// is added to local times to get the server's
+type Estimate struct {
+	Offset time.Duration
+	// Uncertainty bounds the error of Offset either way
+	Uncertainty time.Duration
+	// At is the local time of the latest exchange
+	At time.Time
+}
+
+// exchange bounds the offset
+type exchange struct {
+	at     time.Time
+	lo, hi time.Duration
+}
+
+// point is an estimate kept for the drift
+type point struct {
+	at     time.Time
+	offset time.Duration
+}
+
+// Estimator collects exchanges. It is safe for concurrent use.
+type Estimator struct {
+	window time.Duration
+
+	mu        sync.Mutex
+	exchanges []exchange // within window of the latest
+	points    []point    // one per window, within maxHistory
+	est       Estimate   // of the exchanges
+	ok        bool       // est is set
+	drift     float64    // seconds per second
+	driftOK   bool       // drift is set
+	lastPoint time.Time  // when a point was last kept
+}
+
+// maxHistory is how far back the drift looks, and minPoints how many
+// estimates it needs
+const (
+	maxHistory = 24 * time.Hour
+	minPoints  = 3
+)
+
+// New returns an estimator intersecting the exchanges within window of the
+// latest; a longer window narrows the estimate, a shorter one follows
+// clock steps and drift more closely
+func New(window time.Duration) *Estimator {
+	return &Estimator{window: window}
+}
+
+// Add takes an exchange: a request sent and its response received at
+// local times sent and received, stamped by the server with server,
+// truncated to resolution. Exchanges taking longer than the window are
+// ignored.
+func (e *Estimator) Add(sent, received, server time.Time, resolution time.Duration) {
+	if received.Before(sent) || received.Sub(sent) > e.window {
+		return
+	}
+	x := exchange{at: received, lo: server.Sub(received), hi: server.Add(resolution).Sub(sent)}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.exchanges = append(e.exchanges, x)
+	n := 0
+	for _, old := range e.exchanges {
+		if received.Sub(old.at) <= e.window {
+			e.exchanges[n] = old
+			n++
+		}
+	}
+	e.exchanges = e.exchanges[:n]
+	e.estimate()
+}
+
+// estimate intersects the exchanges from the latest back, stopping at one
+// that doesn't agree: after either clock was stepped, older exchanges no
+// longer apply, nor do the estimates kept for the drift
+func (e *Estimator) estimate() {
+	latest := e.exchanges[len(e.exchanges)-1]
+	lo, hi := latest.lo, latest.hi
+	for i := len(e.exchanges) - 2; i >= 0; i-- {
+		x := e.exchanges[i]
+		if x.lo > hi || x.hi < lo {
+			e.exchanges = e.exchanges[i+1:]
+			e.points, e.lastPoint, e.driftOK = nil, time.Time{}, false
+			break
+		}
+		lo, hi = max(lo, x.lo), min(hi, x.hi)
+	}
+	e.est = Estimate{Offset: (lo + hi) / 2, Uncertainty: (hi - lo) / 2, At: latest.at}
+	e.ok = true
+
+	if e.lastPoint.IsZero() || latest.at.Sub(e.lastPoint) >= e.window {
+		e.points = append(e.points, point{latest.at, e.est.Offset})
+		e.lastPoint = latest.at
+		n := 0
+		for _, p := range e.points {
+			if latest.at.Sub(p.at) <= maxHistory {
+				e.points[n] = p
+				n++
+			}
+		}
+		e.points = e.points[:n]
+		e.drift, e.driftOK = slope(e.points)
+	}
+}
+
+// slope fits a line to the points by least squares, returning its slope
+// in seconds per second
+func slope(points []point) (float64, bool) {
+	if len(points) < minPoints {
+		return 0, false
+	}
+	t0 := points[0].at
+	var sx, sy, sxx, sxy float64
+	for _, p := range points {
+		x := p.at.Sub(t0).Seconds()
+		y := p.offset.Seconds()
+		sx, sy, sxx, sxy = sx+x, sy+y, sxx+x*x, sxy+x*y
+	}
+	n := float64(len(points))
+	d := n*sxx - sx*sx
+	if d == 0 {
+		return 0, false
+	}
+	return (n*sxy - sx*sy) / d, true
+}
+
+// Estimate returns the latest estimate, if there is one
+func (e *Estimator) Estimate() (Estimate, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.est, e.ok
+}
+
+// Drift returns how fast the offset changes, in parts per million: how
+// many microseconds a second the local clock loses against the server's.
+// It needs estimates over minPoints windows.
+func (e *Estimator) Drift() (float64, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.drift * 1e6, e.driftOK
+}
+
+// Offset returns the offset at local time t, extrapolating the latest
+// estimate with the drift
+func (e *Estimator) Offset(t time.Time) (time.Duration, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.ok {
+		return 0, false
+	}
+	off := e.est.Offset
+	if e.driftOK {
+		off += time.Duration(math.Round(e.drift * float64(t.Sub(e.est.At))))
+	}
+	return off, true
+}
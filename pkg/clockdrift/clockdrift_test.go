@@ -0,0 +1,91 @@
+package clockdrift
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// server simulates a server whose clock is offset from the local one and
+// drifts, answering an exchange with an HTTP Date header's whole seconds
+type server struct {
+	offset time.Duration
+	ppm    float64
+	start  time.Time
+	rtt    time.Duration
+}
+
+func (s server) exchange(e *Estimator, sent time.Time, r *rand.Rand) {
+	stamped := sent.Add(time.Duration(r.Int63n(int64(s.rtt))))
+	drift := time.Duration(s.ppm * float64(stamped.Sub(s.start)) / 1e6)
+	e.Add(sent, sent.Add(s.rtt), stamped.Add(s.offset+drift).Truncate(time.Second), time.Second)
+}
+
+func TestOffset(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	s := server{offset: 2345 * time.Millisecond, start: start, rtt: 80 * time.Millisecond}
+	e := New(10 * time.Minute)
+	if _, ok := e.Estimate(); ok {
+		t.Fatal("estimate without exchanges")
+	}
+	now := start
+	for i := 0; i < 40; i++ {
+		s.exchange(e, now, r)
+		now = now.Add(time.Duration(5000+r.Intn(2000)) * time.Millisecond)
+	}
+	est, ok := e.Estimate()
+	if !ok {
+		t.Fatal("no estimate")
+	}
+	if err := est.Offset - s.offset; err.Abs() > 100*time.Millisecond || est.Uncertainty > 100*time.Millisecond {
+		t.Errorf("offset %v ± %v, want %v to within a resolution's tenth", est.Offset, est.Uncertainty, s.offset)
+	}
+	if err := est.Offset - s.offset; err.Abs() > est.Uncertainty {
+		t.Errorf("offset %v ± %v doesn't cover %v", est.Offset, est.Uncertainty, s.offset)
+	}
+
+	// A clock step: the old exchanges no longer agree and are dropped
+	s.offset = -30 * time.Second
+	for i := 0; i < 20; i++ {
+		s.exchange(e, now, r)
+		now = now.Add(time.Duration(5000+r.Intn(2000)) * time.Millisecond)
+	}
+	est, _ = e.Estimate()
+	if err := est.Offset - s.offset; err.Abs() > 200*time.Millisecond {
+		t.Errorf("offset %v after a step, want %v", est.Offset, s.offset)
+	}
+}
+
+func TestDrift(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	s := server{offset: 500 * time.Millisecond, ppm: 50, start: start, rtt: 60 * time.Millisecond}
+	e := New(10 * time.Minute)
+	now := start
+	for now.Sub(start) < 4*time.Hour {
+		s.exchange(e, now, r)
+		now = now.Add(time.Duration(25000+r.Intn(10000)) * time.Millisecond)
+	}
+	ppm, ok := e.Drift()
+	if !ok || math.Abs(ppm-50) > 10 {
+		t.Errorf("drift %.1f ppm (%v), want 50", ppm, ok)
+	}
+	// An hour on, the extrapolated offset has drifted with the clock
+	later := now.Add(time.Hour)
+	off, _ := e.Offset(later)
+	want := s.offset + time.Duration(50*float64(later.Sub(start))/1e6)
+	if err := off - want; err.Abs() > 150*time.Millisecond {
+		t.Errorf("offset an hour on %v, want %v", off, want)
+	}
+}
+
+func TestSlowExchangeIgnored(t *testing.T) {
+	e := New(time.Minute)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	e.Add(now, now.Add(2*time.Minute), now, time.Second)
+	if _, ok := e.Estimate(); ok {
+		t.Error("estimate from an exchange longer than the window")
+	}
+}
@@ -0,0 +1,69 @@
+// Package config loads JSON application configuration files shared by the
+// riscv-dev applications.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// EnvPath names the environment variable that overrides the config path
+const EnvPath = "RISCV_DEV_CONFIG"
+
+// Load decodes the JSON file at path into v. Fields missing from the file
+// keep the values already set in v, so callers fill in defaults first. A
+// missing file is not an error unless the path was given explicitly via
+// the RISCV_DEV_CONFIG environment variable.
+func Load(path string, v any) error {
+	explicit := false
+	if env := os.Getenv(EnvPath); env != "" {
+		path = env
+		explicit = true
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && !explicit {
+			return nil
+		}
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return nil
+}
+
+// Duration is a time.Duration that encodes as a string such as "500ms"
+type Duration time.Duration
+
+// MarshalJSON encodes the duration in time.Duration string form
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON accepts "1m30s" style strings or integer nanoseconds
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		var n int64
+		if err := json.Unmarshal(b, &n); err != nil {
+			return fmt.Errorf("invalid duration %s", b)
+		}
+		*d = Duration(n)
+		return nil
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// D returns the value as a time.Duration
+func (d Duration) D() time.Duration { return time.Duration(d) }
@@ -0,0 +1,382 @@
+// Package crash keeps field crashes from being lost. While a program runs,
+// the Go runtime's crash output (the panic or fatal error, with every
+// goroutine's stack) goes to a file beside a tail of the program's log and
+// a periodic snapshot of the system's state. After a crash the files are
+// still there when the program is restarted: Start turns them into a
+// report, queued on disk until Upload delivers it to a webhook.
+//
+// The runtime only writes its crash output to a file from Go 1.23; built
+// with an older toolchain, Start reports that crashes can't be captured.
+package crash
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"riscv-dev/pkg/buildinfo"
+	"riscv-dev/pkg/procstat"
+)
+
+// Config sets where reports are kept and sent
+type Config struct {
+	// Dir keeps reports until they are uploaded
+	Dir string `json:"dir"`
+	// RunDir holds the running program's crash output, log tail and system
+	// snapshot, rewritten as it runs; a tmpfs such as /run spares the
+	// flash. Default Dir/current.
+	RunDir string `json:"run_dir,omitempty"`
+	// URL receives each report as a JSON POST; without it reports stay in
+	// Dir
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// LogLines is how much of the log a report carries; default 200
+	LogLines int `json:"log_lines,omitempty"`
+	// MaxReports bounds the reports kept while uploads fail, deleting the
+	// oldest; default 10
+	MaxReports int `json:"max_reports,omitempty"`
+}
+
+// Report describes one crash
+type Report struct {
+	ID     string    `json:"id"`
+	Device string    `json:"device,omitempty"`
+	Time   time.Time `json:"time"` // when the crash output was written
+	// Reason is the first line of the crash output, e.g. "panic: runtime
+	// error: index out of range [3] with length 3"
+	Reason string `json:"reason"`
+	// Trace is the runtime's crash output, with every goroutine's stack
+	Trace string `json:"trace"`
+	// Log is the tail of the log up to the crash
+	Log []string `json:"log"`
+	// System is the last snapshot before the crash, nil if there was none
+	System *System `json:"system,omitempty"`
+}
+
+// System is a snapshot of the crashed program and the board it ran on
+type System struct {
+	Time     time.Time      `json:"time"`
+	Build    buildinfo.Info `json:"build"`
+	Hostname string         `json:"hostname"`
+	Kernel   string         `json:"kernel,omitempty"`
+	// Uptime is the board's, Running the program's
+	Uptime  float64    `json:"uptime_seconds,omitempty"`
+	Running float64    `json:"running_seconds"`
+	Load    [3]float64 `json:"load"`
+	// MemAvailable and MemTotal are the board's memory, from /proc/meminfo
+	MemAvailable int64          `json:"mem_available_bytes,omitempty"`
+	MemTotal     int64          `json:"mem_total_bytes,omitempty"`
+	Process      procstat.Stats `json:"process"`
+}
+
+// Files in RunDir
+const (
+	outputFile   = "crash.out"
+	logFile      = "log"
+	snapshotFile = "system.json"
+)
+
+// snapshotInterval is how often the system snapshot is rewritten
+const snapshotInterval = time.Minute
+
+const (
+	defaultLogLines   = 200
+	defaultMaxReports = 10
+)
+
+// Reporter captures crashes of the running program and holds the reports
+// of earlier ones
+type Reporter struct {
+	cfg     Config
+	device  string
+	started time.Time
+	out     *os.File // the runtime's crash output
+
+	mu    sync.Mutex
+	lines []string // the log tail, also in logf
+	logf  *os.File
+	n     int // lines in logf
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// Start queues a report if the last run crashed and captures this run's
+// crash. device identifies the board in reports. Route the log through
+// LogWriter for reports to carry it.
+func Start(cfg Config, device string) (*Reporter, error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("crash: dir is required")
+	}
+	if cfg.RunDir == "" {
+		cfg.RunDir = filepath.Join(cfg.Dir, "current")
+	}
+	if cfg.LogLines <= 0 {
+		cfg.LogLines = defaultLogLines
+	}
+	if cfg.MaxReports <= 0 {
+		cfg.MaxReports = defaultMaxReports
+	}
+	if err := os.MkdirAll(cfg.RunDir, 0755); err != nil {
+		return nil, fmt.Errorf("crash: %w", err)
+	}
+	r := &Reporter{cfg: cfg, device: device, started: time.Now(), stop: make(chan struct{}), done: make(chan struct{})}
+	if rep, err := r.collect(); err != nil {
+		log.Printf("⚠️  Crash: the last run crashed, but its report was lost: %v", err)
+	} else if rep != nil {
+		log.Printf("⚠️  Crash: the last run crashed at %s (%s), report %s queued", rep.Time.Format(time.RFC3339), rep.Reason, rep.ID)
+	}
+
+	out, err := os.Create(r.path(outputFile))
+	if err != nil {
+		return nil, fmt.Errorf("crash: %w", err)
+	}
+	if err := setCrashOutput(out); err != nil {
+		out.Close()
+		return nil, fmt.Errorf("crash: %w", err)
+	}
+	r.out = out
+	// Every goroutine's stack, not only the crashing one's
+	debug.SetTraceback("all")
+	if r.logf, err = os.Create(r.path(logFile)); err != nil {
+		setCrashOutput(nil)
+		out.Close()
+		return nil, fmt.Errorf("crash: %w", err)
+	}
+	r.snapshot()
+	go r.run()
+	return r, nil
+}
+
+func (r *Reporter) path(name string) string { return filepath.Join(r.cfg.RunDir, name) }
+
+// collect turns a previous run's crash output into a queued report, nil if
+// it didn't crash
+func (r *Reporter) collect() (*Report, error) {
+	fi, err := os.Stat(r.path(outputFile))
+	if err != nil || fi.Size() == 0 {
+		return nil, nil
+	}
+	trace, err := os.ReadFile(r.path(outputFile))
+	if err != nil {
+		return nil, err
+	}
+	rep := &Report{
+		ID:     fi.ModTime().UTC().Format("20060102T150405.000Z"),
+		Device: r.device,
+		Time:   fi.ModTime(),
+		Trace:  string(trace),
+		Log:    []string{},
+	}
+	rep.Reason, _, _ = strings.Cut(strings.TrimLeft(rep.Trace, "\n"), "\n")
+	if data, err := os.ReadFile(r.path(logFile)); err == nil {
+		lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+		if len(lines) > r.cfg.LogLines {
+			lines = lines[len(lines)-r.cfg.LogLines:]
+		}
+		if len(lines) > 1 || lines[0] != "" {
+			rep.Log = lines
+		}
+	}
+	if data, err := os.ReadFile(r.path(snapshotFile)); err == nil {
+		var sys System
+		if json.Unmarshal(data, &sys) == nil {
+			rep.System = &sys
+		}
+	}
+	data, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(r.cfg.Dir, 0755); err != nil {
+		return nil, err
+	}
+	tmp := filepath.Join(r.cfg.Dir, "."+rep.ID+".json")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, filepath.Join(r.cfg.Dir, rep.ID+".json")); err != nil {
+		return nil, err
+	}
+	r.trim()
+	return rep, nil
+}
+
+// trim deletes the oldest reports beyond MaxReports
+func (r *Reporter) trim() {
+	pending := r.Pending()
+	for len(pending) > r.cfg.MaxReports {
+		os.Remove(pending[0])
+		pending = pending[1:]
+	}
+}
+
+// Pending lists the paths of the queued reports, oldest first
+func (r *Reporter) Pending() []string {
+	paths, _ := filepath.Glob(filepath.Join(r.cfg.Dir, "[0-9]*.json"))
+	sort.Strings(paths)
+	return paths
+}
+
+// LogWriter returns a writer keeping the tail of what is written, for
+// log.SetOutput together with the log's usual destination
+func (r *Reporter) LogWriter() io.Writer { return logWriter{r} }
+
+type logWriter struct{ r *Reporter }
+
+func (w logWriter) Write(p []byte) (int, error) {
+	r := w.r
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.logf == nil {
+		return len(p), nil
+	}
+	s := bufio.NewScanner(bytes.NewReader(p))
+	for s.Scan() {
+		r.lines = append(r.lines, s.Text())
+	}
+	if len(r.lines) > r.cfg.LogLines {
+		r.lines = append(r.lines[:0], r.lines[len(r.lines)-r.cfg.LogLines:]...)
+	}
+	// Appended as it comes, so it is on file when the runtime crashes, and
+	// rewritten from the tail now and then to keep it short
+	r.n += bytes.Count(p, []byte("\n"))
+	if r.n <= 2*r.cfg.LogLines {
+		r.logf.Write(p)
+		return len(p), nil
+	}
+	r.logf.Truncate(0)
+	r.logf.Seek(0, io.SeekStart)
+	r.logf.WriteString(strings.Join(r.lines, "\n") + "\n")
+	r.n = len(r.lines)
+	return len(p), nil
+}
+
+// run rewrites the system snapshot until Close
+func (r *Reporter) run() {
+	defer close(r.done)
+	t := time.NewTicker(snapshotInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			r.snapshot()
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// snapshot writes the system's state for a crash report
+func (r *Reporter) snapshot() {
+	sys := System{Time: time.Now(), Build: buildinfo.Get(), Running: time.Since(r.started).Seconds(), Process: procstat.Read()}
+	sys.Hostname, _ = os.Hostname()
+	sys.Kernel = kernelRelease()
+	if data, err := os.ReadFile("/proc/uptime"); err == nil {
+		if f := strings.Fields(string(data)); len(f) > 0 {
+			sys.Uptime, _ = strconv.ParseFloat(f[0], 64)
+		}
+	}
+	if data, err := os.ReadFile("/proc/loadavg"); err == nil {
+		for i, f := range strings.Fields(string(data)) {
+			if i < len(sys.Load) {
+				sys.Load[i], _ = strconv.ParseFloat(f, 64)
+			}
+		}
+	}
+	if data, err := os.ReadFile("/proc/meminfo"); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			key, value, _ := strings.Cut(line, ":")
+			kb, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimSpace(value), " kB"), 10, 64)
+			switch {
+			case err != nil:
+			case key == "MemTotal":
+				sys.MemTotal = kb * 1024
+			case key == "MemAvailable":
+				sys.MemAvailable = kb * 1024
+			}
+		}
+	}
+	data, err := json.Marshal(sys)
+	if err != nil {
+		return
+	}
+	tmp := r.path("." + snapshotFile)
+	if os.WriteFile(tmp, data, 0644) == nil {
+		os.Rename(tmp, r.path(snapshotFile))
+	}
+}
+
+// Upload sends the queued reports to the webhook, oldest first, deleting
+// each once accepted, and returns how many were sent. It stops at the first
+// failure, to retry later.
+func (r *Reporter) Upload(ctx context.Context, client *http.Client) (int, error) {
+	if r.cfg.URL == "" {
+		return 0, nil
+	}
+	sent := 0
+	for _, path := range r.Pending() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return sent, err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.cfg.URL, bytes.NewReader(data))
+		if err != nil {
+			return sent, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range r.cfg.Headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return sent, err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return sent, fmt.Errorf("%s: %s", filepath.Base(path), resp.Status)
+		}
+		if err := os.Remove(path); err != nil {
+			return sent, err
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// Close stops capturing crashes and removes this run's files, as it ended
+// without crashing
+func (r *Reporter) Close() error {
+	if r.out == nil {
+		return nil
+	}
+	setCrashOutput(nil)
+	r.out.Close()
+	r.out = nil
+	close(r.stop)
+	<-r.done
+	r.mu.Lock()
+	if r.logf != nil {
+		r.logf.Close()
+		r.logf = nil
+	}
+	r.mu.Unlock()
+	for _, name := range []string{outputFile, logFile, snapshotFile} {
+		os.Remove(r.path(name))
+	}
+	return nil
+}
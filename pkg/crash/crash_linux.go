@@ -0,0 +1,25 @@
+package crash
+
+import "syscall"
+
+// kernelRelease returns the running kernel's release, as uname -r prints it
+func kernelRelease() string {
+	var uts syscall.Utsname
+	if syscall.Uname(&uts) != nil {
+		return ""
+	}
+	return utsString(uts.Release[:])
+}
+
+// utsString converts a NUL-terminated utsname field, whose element type
+// varies by architecture
+func utsString[T int8 | uint8](field []T) string {
+	b := make([]byte, 0, len(field))
+	for _, c := range field {
+		if c == 0 {
+			break
+		}
+		b = append(b, byte(c))
+	}
+	return string(b)
+}
@@ -0,0 +1,6 @@
+//go:build !linux
+
+package crash
+
+// kernelRelease is left out of reports off Linux
+func kernelRelease() string { return "" }
@@ -0,0 +1,113 @@
+//go:build go1.23
+
+package crash
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMain lets TestCrashReported run this test binary as a program that
+// crashes
+func TestMain(m *testing.M) {
+	if dir := os.Getenv("CRASH_TEST_DIR"); dir != "" {
+		r, err := Start(Config{Dir: dir}, "board-1")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		w := r.LogWriter()
+		for i := 0; i < 500; i++ {
+			fmt.Fprintf(w, "line %d\n", i)
+		}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			var s []int
+			_ = s[3]
+		}()
+		<-done
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestCrashReported(t *testing.T) {
+	dir := t.TempDir()
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Env = append(os.Environ(), "CRASH_TEST_DIR="+dir)
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("crashing program exited cleanly: %s", out)
+	}
+
+	var received []Report
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var rep Report
+		data, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(data, &rep); err != nil {
+			t.Errorf("report: %v", err)
+		}
+		received = append(received, rep)
+	}))
+	defer srv.Close()
+
+	r, err := Start(Config{Dir: dir, URL: srv.URL}, "board-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if n := len(r.Pending()); n != 1 {
+		t.Fatalf("%d reports queued, want 1", n)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if n, err := r.Upload(ctx, srv.Client()); n != 1 || err != nil {
+		t.Fatalf("Upload = %d, %v", n, err)
+	}
+	if n := len(r.Pending()); n != 0 {
+		t.Errorf("%d reports left after upload", n)
+	}
+
+	rep := received[0]
+	if !strings.HasPrefix(rep.Reason, "panic: runtime error: index out of range") {
+		t.Errorf("reason %q", rep.Reason)
+	}
+	if !strings.Contains(rep.Trace, "goroutine ") || rep.Device != "board-1" {
+		t.Errorf("trace %q, device %q", rep.Trace, rep.Device)
+	}
+	if len(rep.Log) != defaultLogLines || rep.Log[len(rep.Log)-1] != "line 499" {
+		t.Errorf("log has %d lines ending %q", len(rep.Log), rep.Log[len(rep.Log)-1])
+	}
+	if rep.System == nil || rep.System.Hostname == "" {
+		t.Errorf("system %+v", rep.System)
+	}
+}
+
+func TestCleanExitNotReported(t *testing.T) {
+	dir := t.TempDir()
+	r, err := Start(Config{Dir: dir}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintln(r.LogWriter(), "hello")
+	r.Close()
+
+	r, err = Start(Config{Dir: dir}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if n := len(r.Pending()); n != 0 {
+		t.Errorf("%d reports after a clean exit", n)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build go1.23
+
+package crash
+
+import (
+	"os"
+	"runtime/debug"
+)
+
+// setCrashOutput has the runtime write its crash output to f too, or
+// stop with nil
+func setCrashOutput(f *os.File) error {
+	return debug.SetCrashOutput(f, debug.CrashOptions{})
+}
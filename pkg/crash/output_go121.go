@@ -0,0 +1,16 @@
+//go:build !go1.23
+
+package crash
+
+import (
+	"errors"
+	"os"
+)
+
+// setCrashOutput fails: only Go 1.23 and later write crash output to a file
+func setCrashOutput(f *os.File) error {
+	if f == nil {
+		return nil
+	}
+	return errors.New("capturing crashes needs a binary built with Go 1.23 or later")
+}
@@ -0,0 +1,237 @@
+// Package devaccess checks whether the current user can reach the device
+// nodes used by the HAL (GPIO, I2C, SPI, IIO, PWM) and produces the udev
+// rules that grant non-root access through dedicated groups.
+package devaccess
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Class describes a family of device nodes and how access is granted
+type Class struct {
+	Name     string
+	Patterns []string
+	Group    string
+	Rules    []string
+}
+
+// Classes lists the device families used by the riscv-dev HAL
+var Classes = []Class{
+	{
+		Name:     "gpio",
+		Patterns: []string{"/dev/gpiochip*", "/sys/class/gpio/export"},
+		Group:    "gpio",
+		Rules: []string{
+			`SUBSYSTEM=="gpio", KERNEL=="gpiochip*", GROUP="gpio", MODE="0660"`,
+			`SUBSYSTEM=="gpio", KERNEL=="gpiochip*", ACTION=="add", PROGRAM="/bin/sh -c 'chgrp gpio /sys/class/gpio/export /sys/class/gpio/unexport && chmod g+w /sys/class/gpio/export /sys/class/gpio/unexport'"`,
+			`SUBSYSTEM=="gpio", KERNEL=="gpio*", ACTION=="add", PROGRAM="/bin/sh -c 'chgrp -R gpio /sys%p && chmod -R g+w /sys%p'"`,
+		},
+	},
+	{
+		Name:     "i2c",
+		Patterns: []string{"/dev/i2c-*"},
+		Group:    "i2c",
+		Rules:    []string{`SUBSYSTEM=="i2c-dev", GROUP="i2c", MODE="0660"`},
+	},
+	{
+		Name:     "spi",
+		Patterns: []string{"/dev/spidev*"},
+		Group:    "spi",
+		Rules:    []string{`SUBSYSTEM=="spidev", GROUP="spi", MODE="0660"`},
+	},
+	{
+		Name:     "iio",
+		Patterns: []string{"/dev/iio:device*", "/sys/bus/iio/devices/iio:device*/in_voltage*_raw"},
+		Group:    "iio",
+		Rules:    []string{`SUBSYSTEM=="iio", GROUP="iio", MODE="0660"`},
+	},
+	{
+		Name:     "pwm",
+		Patterns: []string{"/sys/class/pwm/pwmchip*/export"},
+		Group:    "pwm",
+		Rules: []string{
+			`SUBSYSTEM=="pwm", ACTION=="add", PROGRAM="/bin/sh -c 'chgrp -R pwm /sys%p && chmod -R g+w /sys%p'"`,
+		},
+	},
+}
+
+// Status summarises a device class check
+type Status int
+
+const (
+	OK      Status = iota // every node is accessible
+	Missing               // no nodes present (driver not loaded or not on this board)
+	Denied                // nodes present but not accessible to this user
+)
+
+func (s Status) String() string {
+	switch s {
+	case OK:
+		return "ok"
+	case Missing:
+		return "missing"
+	default:
+		return "denied"
+	}
+}
+
+// Node is the access state of a single device node
+type Node struct {
+	Path     string
+	Group    string
+	Mode     os.FileMode
+	Readable bool
+	Writable bool
+}
+
+// Result is the outcome of checking one device class
+type Result struct {
+	Class  Class
+	Status Status
+	Nodes  []Node
+	Advice []string
+}
+
+// Check inspects every device class for the current user
+func Check() []Result {
+	u, _ := user.Current()
+	memberOf := userGroups(u)
+
+	results := make([]Result, 0, len(Classes))
+	for _, class := range Classes {
+		results = append(results, checkClass(class, u, memberOf))
+	}
+	return results
+}
+
+func checkClass(class Class, u *user.User, memberOf map[string]bool) Result {
+	res := Result{Class: class, Status: OK}
+	for _, pattern := range class.Patterns {
+		matches, _ := filepath.Glob(pattern)
+		for _, path := range matches {
+			res.Nodes = append(res.Nodes, inspect(path))
+		}
+	}
+
+	if len(res.Nodes) == 0 {
+		res.Status = Missing
+		res.Advice = append(res.Advice, fmt.Sprintf("no %s device nodes found; check that the kernel driver is enabled and the pins are muxed for %s", class.Name, class.Name))
+		return res
+	}
+
+	groups := make(map[string]bool)
+	for _, n := range res.Nodes {
+		if !n.Readable || !n.Writable {
+			res.Status = Denied
+			groups[n.Group] = true
+		}
+	}
+	if res.Status == OK {
+		return res
+	}
+
+	_, groupErr := user.LookupGroup(class.Group)
+	switch {
+	case groups[class.Group] && !memberOf[class.Group]:
+		res.Advice = append(res.Advice, fmt.Sprintf("add your user to the %q group: sudo usermod -aG %s %s (then log in again)", class.Group, class.Group, username(u)))
+	case groups[class.Group]:
+		res.Advice = append(res.Advice, fmt.Sprintf("you are in %q but the group membership is not active yet; log out and back in", class.Group))
+	default:
+		if groupErr != nil {
+			res.Advice = append(res.Advice, fmt.Sprintf("create the %q group: sudo groupadd --system %s", class.Group, class.Group))
+		}
+		res.Advice = append(res.Advice,
+			fmt.Sprintf("device nodes are owned by group %s; install the udev rules so they belong to %q: sudo riscv-dev udev install", strings.Join(sortedKeys(groups), ","), class.Group),
+			fmt.Sprintf("add your user to the %q group: sudo usermod -aG %s %s", class.Group, class.Group, username(u)))
+	}
+	return res
+}
+
+// access(2) mode bits
+const (
+	accessRead  = 0x4 // R_OK
+	accessWrite = 0x2 // W_OK
+)
+
+func inspect(path string) Node {
+	n := Node{
+		Path:     path,
+		Readable: syscall.Access(path, accessRead) == nil,
+		Writable: syscall.Access(path, accessWrite) == nil,
+	}
+	if fi, err := os.Stat(path); err == nil {
+		n.Mode = fi.Mode()
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+			n.Group = groupName(st.Gid)
+		}
+	}
+	// sysfs attributes like in_voltage0_raw are read-only by design
+	if strings.HasPrefix(path, "/sys/bus/iio/") {
+		n.Writable = true
+	}
+	return n
+}
+
+func userGroups(u *user.User) map[string]bool {
+	groups := make(map[string]bool)
+	if u == nil {
+		return groups
+	}
+	ids, _ := u.GroupIds()
+	for _, id := range ids {
+		if g, err := user.LookupGroupId(id); err == nil {
+			groups[g.Name] = true
+		}
+	}
+	return groups
+}
+
+func groupName(gid uint32) string {
+	id := strconv.FormatUint(uint64(gid), 10)
+	if g, err := user.LookupGroupId(id); err == nil {
+		return g.Name
+	}
+	return id
+}
+
+func username(u *user.User) string {
+	if u == nil {
+		return "$USER"
+	}
+	return u.Username
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// RulesFile is the conventional file name for the generated udev rules
+const RulesFile = "99-riscv-dev.rules"
+
+// UdevRules renders a udev rules file granting group access to every class
+func UdevRules() string {
+	var b strings.Builder
+	b.WriteString("# udev rules generated by riscv-dev: grant non-root access to\n")
+	b.WriteString("# GPIO, I2C, SPI, IIO and PWM devices through dedicated groups.\n")
+	b.WriteString("# Install to /etc/udev/rules.d/" + RulesFile + " and run:\n")
+	b.WriteString("#   sudo udevadm control --reload-rules && sudo udevadm trigger\n")
+	for _, class := range Classes {
+		fmt.Fprintf(&b, "\n# %s (group %q)\n", class.Name, class.Group)
+		for _, rule := range class.Rules {
+			b.WriteString(rule + "\n")
+		}
+	}
+	return b.String()
+}
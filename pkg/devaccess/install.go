@@ -0,0 +1,71 @@
+package devaccess
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+)
+
+// DefaultRulesDir is where locally administered udev rules live
+const DefaultRulesDir = "/etc/udev/rules.d"
+
+// InstallRules writes the generated rules into dir (DefaultRulesDir when
+// empty), replacing any previous version atomically, and returns the path.
+func InstallRules(dir string) (string, error) {
+	if dir == "" {
+		dir = DefaultRulesDir
+	}
+	path := filepath.Join(dir, RulesFile)
+
+	tmp, err := os.CreateTemp(dir, "."+RulesFile+".*")
+	if err != nil {
+		return "", fmt.Errorf("failed to write udev rules: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(UdevRules()); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to write udev rules: %w", err)
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("failed to install udev rules: %w", err)
+	}
+	return path, nil
+}
+
+// EnsureGroups creates any missing access group and returns the ones created
+func EnsureGroups() ([]string, error) {
+	var created []string
+	for _, class := range Classes {
+		if _, err := user.LookupGroup(class.Group); err == nil {
+			continue
+		}
+		if out, err := exec.Command("groupadd", "--system", class.Group).CombinedOutput(); err != nil {
+			return created, fmt.Errorf("groupadd %s: %v: %s", class.Group, err, out)
+		}
+		created = append(created, class.Group)
+	}
+	return created, nil
+}
+
+// ReloadUdev asks udev to reload its rules and re-apply them to existing devices
+func ReloadUdev() error {
+	for _, args := range [][]string{
+		{"control", "--reload-rules"},
+		{"trigger"},
+	} {
+		if out, err := exec.Command("udevadm", args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("udevadm %s: %v: %s", args[0], err, out)
+		}
+	}
+	return nil
+}
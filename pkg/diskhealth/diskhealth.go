@@ -0,0 +1,194 @@
+// Package diskhealth reports the health of a board's storage: the wear of
+// eMMC devices (life-time estimates and the state of their reserved
+// blocks, where the device exposes them), errors recorded by ext4 and
+// filesystems the kernel has remounted read-only. A worn or corrupted SD
+// card is the most common way these boards fail in the field.
+//
+// Paths are taken relative to a root, "/" for the running system, so the
+// checks can be tried against a copy of sysfs and procfs.
+package diskhealth
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Device is an MMC block device: an eMMC chip or an SD card
+type Device struct {
+	Name  string `json:"name"`            // e.g. "mmcblk0"
+	Type  string `json:"type"`            // "MMC" for eMMC, "SD" or "SDIO"
+	Model string `json:"model,omitempty"` // the card's product name
+	// LifeA and LifeB are the eMMC's estimates of the wear of its two
+	// kinds of memory, as the upper end of a 10% step (10 to 100, 110 once
+	// exceeded); 0 where not exposed, as by SD cards
+	LifeA int `json:"life_a,omitempty"`
+	LifeB int `json:"life_b,omitempty"`
+	// PreEOL is the state of the reserved blocks, "normal", "warning" (80%
+	// used) or "urgent" (90%); empty where not exposed
+	PreEOL string `json:"pre_eol,omitempty"`
+}
+
+// Life returns the worse of the two wear estimates, 0 if unknown
+func (d Device) Life() int { return max(d.LifeA, d.LifeB) }
+
+// Filesystem is a mounted filesystem on a block device
+type Filesystem struct {
+	Device   string `json:"device"` // e.g. "mmcblk0p2"
+	Mount    string `json:"mount"`
+	Type     string `json:"type"`
+	ReadOnly bool   `json:"read_only"`
+	// Errors is the count of errors ext4 has recorded in the superblock,
+	// which persists across boots until fsck clears it; -1 for other
+	// filesystems
+	Errors    int        `json:"errors"`
+	LastError *time.Time `json:"last_error,omitempty"` // nil if none`
+}
+
+// Report is the state of the storage
+type Report struct {
+	Devices     []Device     `json:"devices"`
+	Filesystems []Filesystem `json:"filesystems"`
+}
+
+var mmcName = regexp.MustCompile(`^mmcblk\d+$`) // not its boot or rpmb partitions
+
+// Check reads the storage state under root
+func Check(root string) (Report, error) {
+	var rep Report
+	blocks, _ := filepath.Glob(filepath.Join(root, "sys/block/mmcblk*"))
+	for _, b := range blocks {
+		name := filepath.Base(b)
+		if !mmcName.MatchString(name) {
+			continue
+		}
+		dev := filepath.Join(b, "device")
+		d := Device{Name: name, Type: readAttr(filepath.Join(dev, "type")), Model: readAttr(filepath.Join(dev, "name"))}
+		d.LifeA, d.LifeB = parseLifeTime(readAttr(filepath.Join(dev, "life_time")))
+		d.PreEOL = parsePreEOL(readAttr(filepath.Join(dev, "pre_eol_info")))
+		rep.Devices = append(rep.Devices, d)
+	}
+
+	f, err := os.Open(filepath.Join(root, "proc/mounts"))
+	if err != nil {
+		return rep, err
+	}
+	defer f.Close()
+	seen := make(map[string]bool)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 4 || !strings.HasPrefix(fields[0], "/dev/") {
+			continue
+		}
+		mount := unescape(fields[1])
+		dev := strings.TrimPrefix(fields[0], "/dev/")
+		if dev == "root" {
+			dev = blockName(root, mount)
+		}
+		if seen[dev+" "+mount] {
+			continue
+		}
+		seen[dev+" "+mount] = true
+		fs := Filesystem{Device: dev, Mount: mount, Type: fields[2], Errors: -1}
+		for _, opt := range strings.Split(fields[3], ",") {
+			if opt == "ro" {
+				fs.ReadOnly = true
+			}
+		}
+		if fs.Type == "ext4" {
+			ext4 := filepath.Join(root, "sys/fs/ext4", dev)
+			if n, err := strconv.Atoi(readAttr(filepath.Join(ext4, "errors_count"))); err == nil {
+				fs.Errors = n
+			}
+			if t, err := strconv.ParseInt(readAttr(filepath.Join(ext4, "last_error_time")), 10, 64); err == nil && t > 0 {
+				last := time.Unix(t, 0).UTC()
+				fs.LastError = &last
+			}
+		}
+		rep.Filesystems = append(rep.Filesystems, fs)
+	}
+	sort.Slice(rep.Filesystems, func(i, j int) bool { return rep.Filesystems[i].Mount < rep.Filesystems[j].Mount })
+	return rep, sc.Err()
+}
+
+// parseLifeTime reads life_time, "0x01 0x02": each estimate is a 10% step,
+// 0x0b once the device is past its rated life
+func parseLifeTime(s string) (a, b int) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return 0, 0
+	}
+	step := func(f string) int {
+		n, err := strconv.ParseUint(strings.TrimPrefix(f, "0x"), 16, 8)
+		if err != nil || n < 1 || n > 0x0b {
+			return 0
+		}
+		return int(n) * 10
+	}
+	return step(fields[0]), step(fields[1])
+}
+
+// parsePreEOL reads pre_eol_info, 0x01 to 0x03
+func parsePreEOL(s string) string {
+	switch strings.TrimPrefix(s, "0x") {
+	case "01":
+		return "normal"
+	case "02":
+		return "warning"
+	case "03":
+		return "urgent"
+	}
+	return ""
+}
+
+// blockName finds the device of a filesystem mounted from /dev/root, as
+// the kernel names the root filesystem it mounted itself
+func blockName(root, mount string) string {
+	var st syscall.Stat_t
+	if err := syscall.Stat(filepath.Join(root, mount), &st); err != nil {
+		return "root"
+	}
+	dev := uint64(st.Dev) // only 32 bits on some systems
+	major, minor := (dev>>8)&0xfff|(dev>>32)&^0xfff, dev&0xff|(dev>>12)&^0xff
+	link, err := os.Readlink(filepath.Join(root, "sys/dev/block", fmt.Sprintf("%d:%d", major, minor)))
+	if err != nil {
+		return "root"
+	}
+	return filepath.Base(link)
+}
+
+// unescape decodes the octal escapes /proc/mounts uses for spaces and the
+// like in paths
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func readAttr(path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
@@ -0,0 +1,76 @@
+package diskhealth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheck(t *testing.T) {
+	root := t.TempDir()
+	write := func(path, content string) {
+		path = filepath.Join(root, path)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("sys/block/mmcblk0/device/type", "MMC\n")
+	write("sys/block/mmcblk0/device/name", "8GTF4R\n")
+	write("sys/block/mmcblk0/device/life_time", "0x02 0x09\n")
+	write("sys/block/mmcblk0/device/pre_eol_info", "0x02\n")
+	write("sys/block/mmcblk0boot0/device/type", "MMC\n")
+	write("sys/block/mmcblk1/device/type", "SD\n")
+	write("proc/mounts", `/dev/mmcblk0p2 / ext4 ro,relatime 0 0
+proc /proc proc rw,nosuid 0 0
+/dev/mmcblk1p1 /media/sd\040card vfat rw,relatime 0 0
+/dev/mmcblk0p3 /data ext4 rw,relatime 0 0
+`)
+	write("sys/fs/ext4/mmcblk0p2/errors_count", "3\n")
+	write("sys/fs/ext4/mmcblk0p2/last_error_time", "1760000000\n")
+	write("sys/fs/ext4/mmcblk0p3/errors_count", "0\n")
+	write("sys/fs/ext4/mmcblk0p3/last_error_time", "0\n")
+
+	rep, err := Check(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Devices) != 2 {
+		t.Fatalf("devices: %+v", rep.Devices)
+	}
+	emmc, sd := rep.Devices[0], rep.Devices[1]
+	if emmc.LifeA != 20 || emmc.LifeB != 90 || emmc.Life() != 90 || emmc.PreEOL != "warning" || emmc.Model != "8GTF4R" {
+		t.Errorf("eMMC: %+v", emmc)
+	}
+	if sd.Type != "SD" || sd.Life() != 0 || sd.PreEOL != "" {
+		t.Errorf("SD: %+v", sd)
+	}
+
+	last := time.Unix(1760000000, 0).UTC()
+	want := []Filesystem{
+		{Device: "mmcblk0p2", Mount: "/", Type: "ext4", ReadOnly: true, Errors: 3, LastError: &last},
+		{Device: "mmcblk0p3", Mount: "/data", Type: "ext4", Errors: 0},
+		{Device: "mmcblk1p1", Mount: "/media/sd card", Type: "vfat", Errors: -1},
+	}
+	if len(rep.Filesystems) != len(want) {
+		t.Fatalf("filesystems: %+v", rep.Filesystems)
+	}
+	for i, fs := range rep.Filesystems {
+		if (fs.LastError == nil) != (want[i].LastError == nil) || fs.LastError != nil && !fs.LastError.Equal(*want[i].LastError) {
+			t.Errorf("filesystem %d: last error %v, want %v", i, fs.LastError, want[i].LastError)
+		}
+		fs.LastError, want[i].LastError = nil, nil
+		if fs != want[i] {
+			t.Errorf("filesystem %d: %+v, want %+v", i, fs, want[i])
+		}
+	}
+}
+
+func TestParseLifeTime(t *testing.T) {
+	for s, want := range map[string][2]int{"0x01 0x01": {10, 10}, "0x0b 0x0a": {110, 100}, "0x00 0x00": {0, 0}, "": {0, 0}} {
+		if a, b := parseLifeTime(s); a != want[0] || b != want[1] {
+			t.Errorf("%q: %d %d, want %v", s, a, b, want)
+		}
+	}
+}
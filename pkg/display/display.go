@@ -0,0 +1,308 @@
+// Package display formats readings for people: numbers with the decimal
+// and grouping separators of a locale, values converted to the units a
+// user prefers (°F and inHg in the US) and clock times in 12 or 24 hours.
+// Configured once, the same Formatter serves the console, small displays
+// and web pages alike; values sent to sinks and APIs are left untouched.
+package display
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config selects the locale and units
+type Config struct {
+	// Locale is a language tag such as "en-US", "de-DE" or "fr"; default
+	// $LANG, or "en" if unset or C
+	Locale string `json:"locale,omitempty"`
+	// Units is "metric" (as measured) or "us"; default the locale's, "us"
+	// for en-US
+	Units string `json:"units,omitempty"`
+	// Convert overrides the unit a unit is shown in, e.g. {"kPa": "hPa"};
+	// an empty target keeps the unit as measured
+	Convert map[string]string `json:"convert,omitempty"`
+}
+
+// Formatter formats values for display. The zero value is not usable; a
+// nil *Formatter formats like New(Config{Locale: "en"}).
+type Formatter struct {
+	locale  locale
+	tag     string
+	convert map[string]string
+}
+
+// locale holds the conventions of a language or region
+type locale struct {
+	decimal, group string
+	units          string // "metric" or "us"
+	clock12        bool
+}
+
+// locales are the known conventions, by language and by language-region
+// where a region differs
+var locales = map[string]locale{
+	"en":    {".", ",", "metric", false},
+	"en-US": {".", ",", "us", true},
+	"en-GB": {".", ",", "metric", false},
+	"en-AU": {".", ",", "metric", true},
+	"en-CA": {".", ",", "metric", true},
+	"en-IN": {".", ",", "metric", true},
+	"de":    {",", ".", "metric", false},
+	"de-CH": {".", "’", "metric", false},
+	"fr":    {",", "\u202f", "metric", false},
+	"fr-CH": {",", "\u202f", "metric", false},
+	"es":    {",", ".", "metric", false},
+	"es-MX": {".", ",", "metric", true},
+	"es-US": {".", ",", "us", true},
+	"it":    {",", ".", "metric", false},
+	"nl":    {",", ".", "metric", false},
+	"pt":    {",", ".", "metric", false},
+	"pl":    {",", "\u00a0", "metric", false},
+	"sv":    {",", "\u00a0", "metric", false},
+	"fi":    {",", "\u00a0", "metric", false},
+	"nb":    {",", "\u00a0", "metric", false},
+	"da":    {",", ".", "metric", false},
+	"cs":    {",", "\u00a0", "metric", false},
+	"ru":    {",", "\u00a0", "metric", false},
+	"uk":    {",", "\u00a0", "metric", false},
+	"tr":    {",", ".", "metric", false},
+	"ja":    {".", ",", "metric", false},
+	"zh":    {".", ",", "metric", false},
+	"ko":    {".", ",", "metric", true},
+	"hi":    {".", ",", "metric", true},
+}
+
+// conversion turns a value in one unit into another, v*scale + offset,
+// shown with extra decimal places more than in the unit measured
+type conversion struct {
+	scale, offset float64
+	extra         int
+}
+
+// conversions are the supported pairs of units
+var conversions = map[[2]string]conversion{
+	{"°C", "°F"}:     {1.8, 32, 0},
+	{"°C", "K"}:      {1, 273.15, 0},
+	{"kPa", "inHg"}:  {0.29529983, 0, 1},
+	{"kPa", "hPa"}:   {10, 0, -1},
+	{"kPa", "psi"}:   {0.14503774, 0, 1},
+	{"kPa", "mmHg"}:  {7.5006158, 0, -1},
+	{"hPa", "inHg"}:  {0.029529983, 0, 2},
+	{"hPa", "kPa"}:   {0.1, 0, 1},
+	{"Pa", "inHg"}:   {0.00029529983, 0, 2},
+	{"mm", "in"}:     {1 / 25.4, 0, 1},
+	{"cm", "in"}:     {1 / 2.54, 0, 1},
+	{"m", "ft"}:      {1 / 0.3048, 0, 0},
+	{"km", "mi"}:     {1 / 1.609344, 0, 0},
+	{"m/s", "mph"}:   {3600 / 1609.344, 0, 0},
+	{"km/h", "mph"}:  {1 / 1.609344, 0, 0},
+	{"m/s", "km/h"}:  {3.6, 0, 0},
+	{"L", "gal"}:     {1 / 3.785411784, 0, 1},
+	{"L/min", "gpm"}: {1 / 3.785411784, 0, 1},
+	{"kg", "lb"}:     {1 / 0.45359237, 0, 0},
+	{"g", "oz"}:      {1 / 28.349523125, 0, 1},
+}
+
+// usUnits are the units shown in US customary units
+var usUnits = map[string]string{
+	"°C":    "°F",
+	"kPa":   "inHg",
+	"hPa":   "inHg",
+	"Pa":    "inHg",
+	"mm":    "in",
+	"cm":    "in",
+	"m":     "ft",
+	"km":    "mi",
+	"m/s":   "mph",
+	"km/h":  "mph",
+	"L":     "gal",
+	"L/min": "gpm",
+	"kg":    "lb",
+	"g":     "oz",
+}
+
+// New checks cfg and returns its Formatter
+func New(cfg Config) (*Formatter, error) {
+	tag := cfg.Locale
+	if tag == "" {
+		tag = envLocale()
+	}
+	tag = normalize(tag)
+	loc, ok := locales[tag]
+	if !ok {
+		lang, _, _ := strings.Cut(tag, "-")
+		if loc, ok = locales[lang]; !ok {
+			return nil, fmt.Errorf("display: unknown locale %q", cfg.Locale)
+		}
+	}
+	units := cfg.Units
+	if units == "" {
+		units = loc.units
+	}
+	f := &Formatter{locale: loc, tag: tag, convert: make(map[string]string)}
+	switch units {
+	case "metric":
+	case "us":
+		for from, to := range usUnits {
+			f.convert[from] = to
+		}
+	default:
+		return nil, fmt.Errorf("display: units %q (want metric or us)", units)
+	}
+	for from, to := range cfg.Convert {
+		if to == "" || to == from {
+			delete(f.convert, from)
+			continue
+		}
+		if _, ok := conversions[[2]string{from, to}]; !ok {
+			return nil, fmt.Errorf("display: no conversion from %s to %s", from, to)
+		}
+		f.convert[from] = to
+	}
+	return f, nil
+}
+
+// envLocale returns the locale of $LANG, "en" if unset or C
+func envLocale() string {
+	for _, env := range []string{"LC_ALL", "LC_NUMERIC", "LANG"} {
+		if v := os.Getenv(env); v != "" {
+			if v == "C" || v == "POSIX" || strings.HasPrefix(v, "C.") {
+				return "en"
+			}
+			return v
+		}
+	}
+	return "en"
+}
+
+// normalize turns "en_US.UTF-8" or "EN-us" into "en-US"
+func normalize(tag string) string {
+	tag, _, _ = strings.Cut(tag, ".")
+	tag, _, _ = strings.Cut(tag, "@")
+	lang, region, _ := strings.Cut(strings.ReplaceAll(tag, "_", "-"), "-")
+	if region == "" {
+		return strings.ToLower(lang)
+	}
+	return strings.ToLower(lang) + "-" + strings.ToUpper(region)
+}
+
+var defaultFormatter, _ = New(Config{Locale: "en"})
+
+func (f *Formatter) get() *Formatter {
+	if f == nil {
+		return defaultFormatter
+	}
+	return f
+}
+
+// Locale returns the locale tag in use, e.g. "en-US"
+func (f *Formatter) Locale() string { return f.get().tag }
+
+// Unit returns the unit values in unit are shown in
+func (f *Formatter) Unit(unit string) string {
+	if to, ok := f.get().convert[unit]; ok {
+		return to
+	}
+	return unit
+}
+
+// Convert returns v, measured in unit, in the unit it is shown in
+func (f *Formatter) Convert(v float64, unit string) (float64, string) {
+	to := f.Unit(unit)
+	if to == unit {
+		return v, unit
+	}
+	c := conversions[[2]string{unit, to}]
+	return v*c.scale + c.offset, to
+}
+
+// ConvertDelta converts a difference or a rate, which the offset of a
+// conversion such as °C to °F doesn't apply to
+func (f *Formatter) ConvertDelta(v float64, unit string) (float64, string) {
+	to := f.Unit(unit)
+	if to == unit {
+		return v, unit
+	}
+	return v * conversions[[2]string{unit, to}].scale, to
+}
+
+// Precision returns the decimal places to show a value in unit with, for
+// a precision suited to the unit as measured
+func (f *Formatter) Precision(unit string, precision int) int {
+	to := f.Unit(unit)
+	if to == unit {
+		return precision
+	}
+	return max(precision+conversions[[2]string{unit, to}].extra, 0)
+}
+
+// Number formats v with precision decimal places and the locale's
+// separators; NaN is shown as "—"
+func (f *Formatter) Number(v float64, precision int) string {
+	if math.IsNaN(v) {
+		return "—"
+	}
+	if math.IsInf(v, 0) {
+		if v < 0 {
+			return "-∞"
+		}
+		return "∞"
+	}
+	s := strconv.FormatFloat(math.Abs(v), 'f', max(precision, 0), 64)
+	whole, frac, _ := strings.Cut(s, ".")
+	loc := f.get().locale
+	var b strings.Builder
+	if v < 0 && strings.Trim(s, "0.") != "" {
+		b.WriteByte('-')
+	}
+	for i, d := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteString(loc.group)
+		}
+		b.WriteRune(d)
+	}
+	if frac != "" {
+		b.WriteString(loc.decimal)
+		b.WriteString(frac)
+	}
+	return b.String()
+}
+
+// Value converts v, measured in unit, and formats it with its unit, e.g.
+// "72.5°F" or "101,3 kPa"
+func (f *Formatter) Value(v float64, unit string, precision int) string {
+	p := f.Precision(unit, precision)
+	v, unit = f.Convert(v, unit)
+	return f.Number(v, p) + unitSuffix(unit)
+}
+
+// Delta formats a difference or a rate of a value measured in unit
+func (f *Formatter) Delta(v float64, unit string, precision int) string {
+	p := f.Precision(unit, precision)
+	v, unit = f.ConvertDelta(v, unit)
+	return f.Number(v, p) + unitSuffix(unit)
+}
+
+// unitSuffix joins a unit to a number: degrees and percent directly, other
+// units after a space
+func unitSuffix(unit string) string {
+	switch {
+	case unit == "":
+		return ""
+	case strings.HasPrefix(unit, "°") || unit == "%":
+		return unit
+	}
+	return " " + unit
+}
+
+// Clock formats the time of day, in 12 or 24 hours as the locale has it
+func (f *Formatter) Clock(t time.Time) string {
+	if f.get().locale.clock12 {
+		return t.Format("3:04:05 PM")
+	}
+	return t.Format("15:04:05")
+}
@@ -0,0 +1,90 @@
+package display
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestNumber(t *testing.T) {
+	tests := []struct {
+		locale    string
+		v         float64
+		precision int
+		want      string
+	}{
+		{"en", 1234567.891, 2, "1,234,567.89"},
+		{"en", -0.004, 2, "0.00"},
+		{"en", -12.5, 1, "-12.5"},
+		{"de-DE", 1234.5, 1, "1.234,5"},
+		{"fr_FR.UTF-8", 101325, 0, "101\u202f325"},
+		{"de-CH", 9876.54, 2, "9’876.54"},
+		{"en", 999, 0, "999"},
+		{"en", math.NaN(), 2, "—"},
+	}
+	for _, tt := range tests {
+		f, err := New(Config{Locale: tt.locale})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := f.Number(tt.v, tt.precision); got != tt.want {
+			t.Errorf("%s: Number(%v, %d) = %q, want %q", tt.locale, tt.v, tt.precision, got, tt.want)
+		}
+	}
+}
+
+func TestUnits(t *testing.T) {
+	us, err := New(Config{Locale: "en-US"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := us.Value(22.5, "°C", 1); got != "72.5°F" {
+		t.Errorf("temperature = %q", got)
+	}
+	if got := us.Value(101.3, "kPa", 1); got != "29.91 inHg" {
+		t.Errorf("pressure = %q", got)
+	}
+	if got := us.Delta(1, "°C", 1); got != "1.8°F" {
+		t.Errorf("delta = %q", got)
+	}
+	if got := us.Value(500, "lux", 0); got != "500 lux" {
+		t.Errorf("unconverted = %q", got)
+	}
+
+	de, err := New(Config{Locale: "de", Convert: map[string]string{"kPa": "hPa"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := de.Value(101.3, "kPa", 1); got != "1.013 hPa" {
+		t.Errorf("override = %q", got)
+	}
+	if got := de.Value(22.5, "°C", 1); got != "22,5°C" {
+		t.Errorf("metric = %q", got)
+	}
+	metric, _ := New(Config{Locale: "en-US", Units: "metric"})
+	if got := metric.Value(22.5, "°C", 1); got != "22.5°C" {
+		t.Errorf("units override = %q", got)
+	}
+
+	for _, cfg := range []Config{{Locale: "xx"}, {Units: "nautical"}, {Convert: map[string]string{"°C": "inHg"}}} {
+		if _, err := New(cfg); err == nil {
+			t.Errorf("New(%+v) accepted", cfg)
+		}
+	}
+}
+
+func TestClock(t *testing.T) {
+	at := time.Date(2026, 3, 1, 15, 4, 5, 0, time.UTC)
+	us, _ := New(Config{Locale: "en-US"})
+	gb, _ := New(Config{Locale: "en-GB"})
+	if got := us.Clock(at); got != "3:04:05 PM" {
+		t.Errorf("en-US clock = %q", got)
+	}
+	if got := gb.Clock(at); got != "15:04:05" {
+		t.Errorf("en-GB clock = %q", got)
+	}
+	var none *Formatter
+	if got := none.Value(1234.5, "kPa", 1); got != "1,234.5 kPa" {
+		t.Errorf("nil formatter = %q", got)
+	}
+}
@@ -0,0 +1,363 @@
+// Package election picks one leader among redundant boards on a LAN, so
+// that only one publishes and actuates while the others stand by,
+// monitoring, ready to take over.
+//
+// Every node sends a heartbeat to its peers each Interval over UDP,
+// saying whether it leads. A node that hears no leader for Timeout takes
+// over if it is the best candidate it can hear: highest priority, then
+// lowest ID. A leader keeps leading while it is heard, even if a better
+// candidate appears, unless Preempt is set; when two leaders hear each
+// other, as after a network split heals, the worse one steps down.
+//
+// Heartbeats are signed with a shared secret so a stray packet can't
+// depose the leader, and numbered by the sender's start (Config.Boot) and
+// within it, so a node ignores heartbeats recorded earlier and replayed
+// once it has heard a later one from the same peer, including the one a
+// peer sends when it leaves. A node that has heard nothing from a peer
+// since it started has nothing to compare against, and accepts its first
+// validly signed heartbeat. As with any election over an unreliable
+// network, a split lets both sides lead until it heals; the Timeout should
+// exceed the longest expected outage of heartbeats.
+package election
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"riscv-dev/pkg/config"
+)
+
+// Config is one node's view of the election
+type Config struct {
+	// ID names this node; it must differ between nodes. Default: the
+	// hostname.
+	ID string `json:"id,omitempty"`
+	// Listen is the UDP address heartbeats are received on, default
+	// ":7946"
+	Listen string `json:"listen,omitempty"`
+	// Peers are the UDP addresses of the other nodes, or a broadcast
+	// address such as "192.168.1.255:7946"
+	Peers []string `json:"peers"`
+	// Secret signs heartbeats; every node needs the same one
+	Secret string `json:"secret"`
+	// Priority ranks candidates, highest first; default 0
+	Priority int `json:"priority,omitempty"`
+	// Preempt lets this node take over from a live leader of lower
+	// priority, e.g. to hand leadership back to a preferred board
+	Preempt bool `json:"preempt,omitempty"`
+	// Interval between heartbeats, default 1s; Timeout without hearing
+	// the leader before taking over, default 5 intervals
+	Interval config.Duration `json:"interval,omitempty"`
+	Timeout  config.Duration `json:"timeout,omitempty"`
+	// Boot numbers this start of the node and must exceed that of every
+	// earlier start, e.g. by a counter kept in a state file. Default: the
+	// time of the start in nanoseconds, which needs a clock that doesn't
+	// step back across restarts.
+	Boot uint64 `json:"-"`
+}
+
+// heartbeat is the message nodes exchange
+type heartbeat struct {
+	ID       string `json:"id"`
+	Priority int    `json:"priority"`
+	Leader   bool   `json:"leader"`
+	Leaving  bool   `json:"leaving,omitempty"` // the node is shutting down
+	// Boot grows with every start of the node and Seq counts heartbeats
+	// within it, so replayed heartbeats are ignored
+	Boot uint64 `json:"boot"`
+	Seq  uint64 `json:"seq"`
+}
+
+// envelope carries a heartbeat with its signature
+type envelope struct {
+	Heartbeat json.RawMessage `json:"hb"`
+	MAC       string          `json:"mac"`
+}
+
+// peer is what a node last heard from another. A peer that left is kept,
+// with Leaving set, so its earlier heartbeats can't be replayed.
+type peer struct {
+	heartbeat
+	heard time.Time
+}
+
+// Elector takes part in the election
+type Elector struct {
+	cfg      Config
+	interval time.Duration
+	timeout  time.Duration
+	conn     net.PacketConn
+	peers    []net.Addr
+	boot     uint64
+
+	mu       sync.Mutex
+	seq      uint64
+	leader   bool
+	started  time.Time
+	known    map[string]*peer
+	onChange func(leader bool)
+}
+
+// New checks cfg and opens the UDP socket. onChange, if not nil, is called
+// from Run whenever this node gains or loses leadership.
+func New(cfg Config, onChange func(leader bool)) (*Elector, error) {
+	if cfg.ID == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("election: id: %w", err)
+		}
+		cfg.ID = host
+	}
+	if cfg.Secret == "" {
+		return nil, errors.New("election: secret is required")
+	}
+	if len(cfg.Peers) == 0 {
+		return nil, errors.New("election: no peers")
+	}
+	if cfg.Listen == "" {
+		cfg.Listen = ":7946"
+	}
+	e := &Elector{cfg: cfg, interval: cfg.Interval.D(), timeout: cfg.Timeout.D(), known: make(map[string]*peer), onChange: onChange}
+	if e.interval <= 0 {
+		e.interval = time.Second
+	}
+	if e.timeout <= 0 {
+		e.timeout = 5 * e.interval
+	}
+	if e.timeout <= e.interval {
+		return nil, fmt.Errorf("election: timeout %v must exceed the interval %v", e.timeout, e.interval)
+	}
+	for _, p := range cfg.Peers {
+		addr, err := net.ResolveUDPAddr("udp", p)
+		if err != nil {
+			return nil, fmt.Errorf("election: peer %s: %w", p, err)
+		}
+		e.peers = append(e.peers, addr)
+	}
+	e.boot = cfg.Boot
+	if e.boot == 0 {
+		e.boot = uint64(time.Now().UnixNano())
+	}
+
+	lc := net.ListenConfig{Control: func(network, address string, c syscall.RawConn) error {
+		var serr error
+		err := c.Control(func(fd uintptr) {
+			// Peers may be a broadcast address
+			serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_BROADCAST, 1)
+		})
+		if err != nil {
+			return err
+		}
+		return serr
+	}}
+	conn, err := lc.ListenPacket(context.Background(), "udp", cfg.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("election: %w", err)
+	}
+	e.conn = conn
+	return e, nil
+}
+
+// ID returns this node's ID
+func (e *Elector) ID() string { return e.cfg.ID }
+
+// IsLeader reports whether this node leads
+func (e *Elector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader
+}
+
+// Leader returns the ID of the leader this node knows of, empty if none
+func (e *Elector) Leader() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.leader {
+		return e.cfg.ID
+	}
+	now := time.Now()
+	for id, p := range e.known {
+		if p.Leader && !p.Leaving && now.Sub(p.heard) < e.timeout {
+			return id
+		}
+	}
+	return ""
+}
+
+// Run takes part in the election until ctx is cancelled, then steps down
+// and tells the peers, so a standby takes over without waiting for the
+// timeout.
+func (e *Elector) Run(ctx context.Context) error {
+	e.mu.Lock()
+	e.started = time.Now()
+	e.mu.Unlock()
+	received, done := make(chan heartbeat), make(chan struct{})
+	go e.receive(received, done)
+	defer close(done)
+	defer e.conn.Close()
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	e.send()
+	for {
+		select {
+		case hb := <-received:
+			e.heard(hb, time.Now())
+		case <-ticker.C:
+			e.decide(time.Now())
+			e.send()
+		case <-ctx.Done():
+			e.setLeader(false)
+			e.sendLeaving()
+			return nil
+		}
+	}
+}
+
+// receive passes valid heartbeats from other nodes to Run until the
+// socket is closed
+func (e *Elector) receive(out chan<- heartbeat, done <-chan struct{}) {
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := e.conn.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("⚠️  Election: %v", err)
+			}
+			return
+		}
+		hb, err := e.open(buf[:n])
+		if err != nil || hb.ID == e.cfg.ID {
+			continue // not ours, tampered with, or our own broadcast
+		}
+		select {
+		case out <- hb:
+		case <-done:
+			return
+		}
+	}
+}
+
+// heard records a heartbeat, and steps down before a better leader
+func (e *Elector) heard(hb heartbeat, now time.Time) {
+	e.mu.Lock()
+	p, ok := e.known[hb.ID]
+	if ok && (hb.Boot < p.Boot || hb.Boot == p.Boot && hb.Seq <= p.Seq) {
+		e.mu.Unlock()
+		return // replayed or reordered
+	}
+	if !ok {
+		p = &peer{}
+		e.known[hb.ID] = p
+	}
+	p.heartbeat, p.heard = hb, now
+	stepDown := e.leader && hb.Leader && !hb.Leaving && e.better(hb.Priority, hb.ID)
+	e.mu.Unlock()
+	if stepDown {
+		e.setLeader(false)
+	}
+}
+
+// better reports whether a candidate beats this node; e.mu must be held
+func (e *Elector) better(priority int, id string) bool {
+	if priority != e.cfg.Priority {
+		return priority > e.cfg.Priority
+	}
+	return id < e.cfg.ID
+}
+
+// decide takes over if no leader has been heard for the timeout and this
+// node is the best candidate heard, or preempts a worse leader
+func (e *Elector) decide(now time.Time) {
+	e.mu.Lock()
+	if e.leader {
+		e.mu.Unlock()
+		return
+	}
+	leaderAlive, bestHere, preempt := false, true, false
+	for id, p := range e.known {
+		if p.Leaving || now.Sub(p.heard) >= e.timeout {
+			continue
+		}
+		if p.Leader {
+			leaderAlive = true
+			preempt = e.cfg.Preempt && p.Priority < e.cfg.Priority
+		}
+		if e.better(p.Priority, id) {
+			bestHere = false
+		}
+	}
+	// Give a running leader a chance to be heard after starting
+	waited := now.Sub(e.started) >= e.timeout
+	take := (!leaderAlive && waited && bestHere) || (preempt && bestHere)
+	e.mu.Unlock()
+	if take {
+		e.setLeader(true)
+	}
+}
+
+func (e *Elector) setLeader(leader bool) {
+	e.mu.Lock()
+	changed := e.leader != leader
+	e.leader = leader
+	e.mu.Unlock()
+	if changed && e.onChange != nil {
+		e.onChange(leader)
+	}
+}
+
+// send sends a heartbeat to every peer
+func (e *Elector) send() { e.sendHeartbeat(false) }
+
+// sendLeaving tells the peers this node is going, so they needn't wait
+// for it to time out
+func (e *Elector) sendLeaving() { e.sendHeartbeat(true) }
+
+func (e *Elector) sendHeartbeat(leaving bool) {
+	e.mu.Lock()
+	e.seq++
+	hb := heartbeat{ID: e.cfg.ID, Priority: e.cfg.Priority, Leader: e.leader, Leaving: leaving, Boot: e.boot, Seq: e.seq}
+	e.mu.Unlock()
+	msg := e.seal(hb)
+	for _, addr := range e.peers {
+		// Errors are transient here (no route yet, peer down) and the
+		// next heartbeat retries
+		e.conn.WriteTo(msg, addr)
+	}
+}
+
+func (e *Elector) mac(b []byte) []byte {
+	m := hmac.New(sha256.New, []byte(e.cfg.Secret))
+	m.Write(b)
+	return m.Sum(nil)
+}
+
+func (e *Elector) seal(hb heartbeat) []byte {
+	b, _ := json.Marshal(hb)
+	msg, _ := json.Marshal(envelope{Heartbeat: b, MAC: hex.EncodeToString(e.mac(b))})
+	return msg
+}
+
+func (e *Elector) open(msg []byte) (heartbeat, error) {
+	var env envelope
+	var hb heartbeat
+	if err := json.Unmarshal(msg, &env); err != nil {
+		return hb, err
+	}
+	mac, err := hex.DecodeString(env.MAC)
+	if err != nil || !hmac.Equal(mac, e.mac(env.Heartbeat)) {
+		return hb, errors.New("election: bad signature")
+	}
+	return hb, json.Unmarshal(env.Heartbeat, &hb)
+}
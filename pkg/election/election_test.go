@@ -0,0 +1,129 @@
+package election
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"riscv-dev/pkg/config"
+)
+
+func TestElection(t *testing.T) {
+	newNode := func(id string, priority int) *Elector {
+		e, err := New(Config{
+			ID: id, Listen: "127.0.0.1:0", Peers: []string{"127.0.0.1:1"}, Secret: "s3cret", Priority: priority,
+			Interval: config.Duration(20 * time.Millisecond), Timeout: config.Duration(100 * time.Millisecond),
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+	a := newNode("a", 1)
+	b := newNode("b", 0)
+	a.peers = []net.Addr{b.conn.LocalAddr()}
+	b.peers = []net.Addr{a.conn.LocalAddr()}
+	ctxA, stopA := context.WithCancel(context.Background())
+	ctxB, stopB := context.WithCancel(context.Background())
+	defer stopB()
+	doneA := make(chan struct{})
+	go func() {
+		a.Run(ctxA)
+		close(doneA)
+	}()
+	go b.Run(ctxB)
+
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitFor("a to lead", a.IsLeader)
+	if b.IsLeader() {
+		t.Fatal("both lead")
+	}
+	waitFor("b to know the leader", func() bool { return b.Leader() == "a" })
+
+	// The standby takes over when the leader leaves
+	stopA()
+	<-doneA
+	waitFor("b to take over", b.IsLeader)
+}
+
+func TestSignature(t *testing.T) {
+	e := &Elector{cfg: Config{Secret: "one"}}
+	other := &Elector{cfg: Config{Secret: "two"}}
+	msg := e.seal(heartbeat{ID: "x", Leader: true})
+	if _, err := e.open(msg); err != nil {
+		t.Error(err)
+	}
+	if _, err := other.open(msg); err == nil {
+		t.Error("heartbeat with another secret accepted")
+	}
+}
+
+func TestReplay(t *testing.T) {
+	t0 := time.Now() // Leader judges by the clock
+	e := &Elector{
+		cfg:     Config{ID: "b", Secret: "s3cret"},
+		timeout: time.Second,
+		started: t0.Add(-time.Hour),
+		known:   make(map[string]*peer),
+	}
+	// Heartbeats an attacker recorded: a leading in its first start
+	var recorded [][]byte
+	for seq := uint64(1); seq <= 3; seq++ {
+		recorded = append(recorded, e.seal(heartbeat{ID: "a", Priority: 1, Leader: true, Boot: 1, Seq: seq}))
+	}
+	replay := func(now time.Time) {
+		for _, msg := range recorded {
+			hb, err := e.open(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e.heard(hb, now)
+		}
+	}
+
+	// a restarted as a standby and b leads; a's old heartbeats as leader,
+	// though validly signed and of a better candidate, don't depose b
+	e.heard(heartbeat{ID: "a", Priority: 1, Boot: 2, Seq: 1}, t0)
+	e.leader = true
+	replay(t0.Add(10 * time.Millisecond))
+	if !e.IsLeader() || e.known["a"].Boot != 2 {
+		t.Fatalf("deposed by a replay from an earlier start: known %+v", e.known["a"].heartbeat)
+	}
+
+	// a leads, then leaves; neither its heartbeats from before it left nor
+	// older ones make b believe a leader is still there
+	e.leader = false
+	e.heard(heartbeat{ID: "a", Priority: 1, Leader: true, Boot: 2, Seq: 2}, t0.Add(20*time.Millisecond))
+	if e.Leader() != "a" {
+		t.Fatalf("leader %q, want a", e.Leader())
+	}
+	e.heard(heartbeat{ID: "a", Priority: 1, Leaving: true, Boot: 2, Seq: 3}, t0.Add(30*time.Millisecond))
+	if e.Leader() != "" {
+		t.Errorf("leader %q after a left", e.Leader())
+	}
+	e.heard(heartbeat{ID: "a", Priority: 1, Leader: true, Boot: 2, Seq: 2}, t0.Add(40*time.Millisecond))
+	replay(t0.Add(40 * time.Millisecond))
+	if e.Leader() != "" || !e.known["a"].Leaving {
+		t.Errorf("replays after a left: leader %q", e.Leader())
+	}
+	e.decide(t0.Add(50 * time.Millisecond))
+	if !e.IsLeader() {
+		t.Error("b didn't take over after the leader left")
+	}
+
+	// a starting again is heard as usual
+	e.heard(heartbeat{ID: "a", Priority: 1, Boot: 3, Seq: 1}, t0.Add(60*time.Millisecond))
+	if p := e.known["a"]; p.Leaving || p.Boot != 3 {
+		t.Errorf("a's next start not heard: %+v", p.heartbeat)
+	}
+}
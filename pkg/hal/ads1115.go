@@ -0,0 +1,190 @@
+package hal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+)
+
+// ADS1115 register map and configuration bits
+const (
+	ads1115DefaultAddress = 0x48
+	ads1115RegConversion  = 0x00
+	ads1115RegConfig      = 0x01
+
+	ads1115StartSingle = 1 << 15 // OS: start a single conversion / conversion done
+	ads1115MuxSingle0  = 0x4     // MUX: AIN0 vs GND, +channel for AIN1-3
+	ads1115PGA4096     = 0x1     // PGA: ±4.096V full scale
+	ads1115ModeSingle  = 1 << 8  // MODE: single-shot
+	ads1115Rate128     = 0x4     // DR: 128 samples/s
+	ads1115CompDisable = 0x3     // COMP_QUE: comparator disabled
+	ads1115ConfigReset = 0x8583  // config register after power-on
+
+	ads1115FullScale  = 4.096
+	ads1115MaxCount   = 32767
+	ads1115Conversion = 8 * time.Millisecond // 1/128 s
+)
+
+func init() {
+	RegisterADCDriver(ADCDriver{
+		Name:     "ads1115",
+		Priority: 20,
+		Probe: func(cfg ADCConfig) error {
+			adc, err := findADS1115(cfg)
+			if err != nil {
+				return err
+			}
+			return adc.Close()
+		},
+		Open: func(cfg ADCConfig) (ADCController, error) {
+			if cfg.Device == "" {
+				return findADS1115(cfg)
+			}
+			return openADS1115(cfg)
+		},
+	})
+}
+
+// ADS1115 drives a TI ADS1115 16-bit I2C ADC in single-ended, single-shot mode
+type ADS1115 struct {
+	bus     I2CController
+	addr    byte
+	ownsBus bool
+}
+
+// NewADS1115 uses an already opened bus; Close does not close the bus
+func NewADS1115(bus I2CController, addr byte) *ADS1115 {
+	return &ADS1115{bus: bus, addr: addr}
+}
+
+func openADS1115(cfg ADCConfig) (*ADS1115, error) {
+	bus, err := NewLinuxI2C(cfg.Device)
+	if err != nil {
+		return nil, err
+	}
+	addr := byte(ads1115DefaultAddress)
+	if cfg.Address != 0 {
+		addr = byte(cfg.Address)
+	}
+	adc := NewADS1115(bus, addr)
+	adc.ownsBus = true
+	return adc, nil
+}
+
+// findADS1115 opens the ADS1115 on cfg.Device or, without one, on the
+// first /dev/i2c-* bus where one answers
+func findADS1115(cfg ADCConfig) (*ADS1115, error) {
+	buses := []string{cfg.Device}
+	if cfg.Device == "" {
+		buses, _ = filepath.Glob("/dev/i2c-*")
+		if len(buses) == 0 {
+			return nil, &Error{Op: "i2c open", Kind: ErrNotSupported, Err: errors.New("no I2C bus found (is the i2c-dev module loaded?)")}
+		}
+	}
+	var errs []error
+	for _, dev := range buses {
+		cfg.Device = dev
+		adc, err := openADS1115(cfg)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		err = adc.identify(ctx)
+		cancel()
+		if err == nil {
+			return adc, nil
+		}
+		adc.Close()
+		errs = append(errs, fmt.Errorf("%s: %w", dev, err))
+	}
+	return nil, errors.Join(errs...)
+}
+
+// identify checks that the chip at a.addr is an ADS1115 still in its
+// power-on configuration. The OS bit is ignored: it reads 0 while a
+// conversion is running.
+func (a *ADS1115) identify(ctx context.Context) error {
+	config, err := a.readRegister(ctx, ads1115RegConfig)
+	if err != nil {
+		return fmt.Errorf("no ADS1115 at 0x%02x: %w", a.addr, err)
+	}
+	if config&^ads1115StartSingle != ads1115ConfigReset&^ads1115StartSingle {
+		return fmt.Errorf("no ADS1115 at 0x%02x: config register reads %#04x, want %#04x", a.addr, config, ads1115ConfigReset)
+	}
+	return nil
+}
+
+func (a *ADS1115) readRegister(ctx context.Context, reg byte) (uint16, error) {
+	data, err := a.bus.WriteRead(ctx, a.addr, []byte{reg}, 2)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) != 2 {
+		return 0, opError(fmt.Sprintf("ads1115 read register 0x%02x", reg), io.ErrUnexpectedEOF)
+	}
+	return uint16(data[0])<<8 | uint16(data[1]), nil
+}
+
+// ReadChannel performs a single-shot conversion of AIN0-AIN3 against GND.
+// Negative readings (input below ground) are clamped to zero.
+func (a *ADS1115) ReadChannel(ctx context.Context, channel int) (int, error) {
+	if channel < 0 || channel > 3 {
+		return 0, fmt.Errorf("ADS1115 channel %d out of range (0-3)", channel)
+	}
+
+	config := uint16(ads1115StartSingle |
+		(ads1115MuxSingle0+channel)<<12 |
+		ads1115PGA4096<<9 |
+		ads1115ModeSingle |
+		ads1115Rate128<<5 |
+		ads1115CompDisable)
+	if err := a.bus.Write(ctx, a.addr, []byte{ads1115RegConfig, byte(config >> 8), byte(config)}); err != nil {
+		return 0, err
+	}
+
+	wait := ads1115Conversion
+	for i := 0; ; i++ {
+		if err := sleepCtx(ctx, wait); err != nil {
+			return 0, err
+		}
+		status, err := a.readRegister(ctx, ads1115RegConfig)
+		if err != nil {
+			return 0, err
+		}
+		if status&ads1115StartSingle != 0 {
+			break
+		}
+		if i == 10 {
+			return 0, fmt.Errorf("ADS1115 channel %d: conversion did not complete: %w", channel, ErrTimeout)
+		}
+		wait = time.Millisecond
+	}
+
+	raw, err := a.readRegister(ctx, ads1115RegConversion)
+	if err != nil {
+		return 0, err
+	}
+	value := int(int16(raw))
+	if value < 0 {
+		value = 0
+	}
+	return value, nil
+}
+
+// GetResolution returns the positive full-scale count
+func (a *ADS1115) GetResolution() int { return ads1115MaxCount }
+
+// GetReferenceVoltage returns the programmed full-scale range
+func (a *ADS1115) GetReferenceVoltage() float64 { return ads1115FullScale }
+
+// Close releases the bus if it was opened by the driver
+func (a *ADS1115) Close() error {
+	if a.ownsBus {
+		return a.bus.Close()
+	}
+	return nil
+}
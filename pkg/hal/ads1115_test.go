@@ -0,0 +1,44 @@
+package hal
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// fakeRegister is an I2C bus answering every register read with value
+type fakeRegister struct {
+	value uint16
+	err   error
+}
+
+func (f *fakeRegister) Write(ctx context.Context, addr byte, data []byte) error { return f.err }
+
+func (f *fakeRegister) Read(ctx context.Context, addr byte, length int) ([]byte, error) {
+	return nil, errors.New("unused")
+}
+
+func (f *fakeRegister) WriteRead(ctx context.Context, addr byte, data []byte, n int) ([]byte, error) {
+	return []byte{byte(f.value >> 8), byte(f.value)}, f.err
+}
+
+func (f *fakeRegister) Close() error { return nil }
+
+func TestADS1115Identify(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		bus  *fakeRegister
+		ok   bool
+	}{
+		{"power-on config", &fakeRegister{value: 0x8583}, true},
+		{"converting", &fakeRegister{value: 0x0583}, true},
+		{"INA219 config", &fakeRegister{value: 0x399F}, false},
+		{"reconfigured", &fakeRegister{value: 0xC383}, false},
+		{"no answer", &fakeRegister{err: ErrTimeout}, false},
+	} {
+		err := NewADS1115(tc.bus, ads1115DefaultAddress).identify(context.Background())
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: identify = %v", tc.name, err)
+		}
+	}
+}
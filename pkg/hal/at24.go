@@ -0,0 +1,274 @@
+package hal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	at24DefaultAddress = 0x50
+	at24DefaultModel   = "24c32"
+	at24WriteTimeout   = 25 * time.Millisecond // longest write cycle in the datasheets is 10ms
+	at24PollInterval   = 500 * time.Microsecond
+	at24MaxTransfer    = 128
+)
+
+// at24Model describes one memory of the AT24Cxx family or a compatible
+// FRAM. Memories addressing more than 8 or 16 bits with their address bytes
+// take the high bits in the low bits of the device address.
+type at24Model struct {
+	size      int
+	pageSize  int
+	addrBytes int
+	fram      bool // writes complete immediately
+}
+
+var at24Models = map[string]at24Model{
+	"24c01":  {128, 8, 1, false},
+	"24c02":  {256, 8, 1, false},
+	"24c04":  {512, 16, 1, false},
+	"24c08":  {1024, 16, 1, false},
+	"24c16":  {2048, 16, 1, false},
+	"24c32":  {4096, 32, 2, false},
+	"24c64":  {8192, 32, 2, false},
+	"24c128": {16384, 64, 2, false},
+	"24c256": {32768, 64, 2, false},
+	"24c512": {65536, 128, 2, false},
+	"24cm01": {131072, 256, 2, false},
+
+	"fm24cl04b":  {512, at24MaxTransfer, 1, true},
+	"fm24cl16b":  {2048, at24MaxTransfer, 1, true},
+	"fm24cl64b":  {8192, at24MaxTransfer, 2, true},
+	"mb85rc256v": {32768, at24MaxTransfer, 2, true},
+}
+
+// AT24Models returns the memory models known to NewAT24
+func AT24Models() []string {
+	names := make([]string, 0, len(at24Models))
+	for name := range at24Models {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// EEPROM is a small byte-addressed non-volatile memory
+type EEPROM interface {
+	// Size returns the capacity in bytes
+	Size() int
+	// ReadAt fills p from offset off
+	ReadAt(ctx context.Context, p []byte, off int) error
+	// WriteAt stores p at offset off, returning once it is non-volatile
+	WriteAt(ctx context.Context, p []byte, off int) error
+	Close() error
+}
+
+// OpenEEPROM opens a memory on an I2C bus with the AT24 driver, or the
+// eeprom file of the kernel's at24 driver when cfg.Device is a file other
+// than an i2c-dev node (the kernel driver claims the address, so both
+// can't be used at once)
+func OpenEEPROM(cfg EEPROMConfig) (EEPROM, error) {
+	if cfg.Device != "" && !strings.HasPrefix(filepath.Base(cfg.Device), "i2c-") {
+		return OpenSysfsEEPROM(cfg.Device)
+	}
+	bus, err := NewLinuxI2C(cfg.Device)
+	if err != nil {
+		return nil, err
+	}
+	addr := byte(at24DefaultAddress)
+	if cfg.Address != 0 {
+		addr = byte(cfg.Address)
+	}
+	e, err := NewAT24(bus, addr, cfg.Model)
+	if err != nil {
+		bus.Close()
+		return nil, err
+	}
+	e.ownsBus = true
+	return e, nil
+}
+
+// AT24 drives an AT24Cxx I2C EEPROM or a pin-compatible FRAM. Writes are
+// split at page boundaries, since a page write wraps around within its
+// page, and each page is polled until the chip acknowledges again at the
+// end of its write cycle. A write-protect pin held high makes writes appear
+// to succeed without changing the memory.
+type AT24 struct {
+	model   at24Model
+	bus     I2CController
+	addr    byte
+	ownsBus bool
+}
+
+// NewAT24 uses an already opened bus; Close does not close the bus. An
+// empty model is a 24c32.
+func NewAT24(bus I2CController, addr byte, model string) (*AT24, error) {
+	if model == "" {
+		model = at24DefaultModel
+	}
+	m, ok := at24Models[strings.ToLower(model)]
+	if !ok {
+		return nil, &Error{Op: "at24 open", Kind: ErrNotSupported,
+			Err: fmt.Errorf("unknown model %q (known: %s)", model, strings.Join(AT24Models(), ", "))}
+	}
+	return &AT24{model: m, bus: bus, addr: addr}, nil
+}
+
+// Size returns the capacity in bytes
+func (e *AT24) Size() int { return e.model.size }
+
+// PageSize returns the largest write the chip takes at once
+func (e *AT24) PageSize() int { return e.model.pageSize }
+
+// address returns the device address and address bytes selecting off
+func (e *AT24) address(off int) (byte, []byte) {
+	dev := e.addr | byte(off>>(8*e.model.addrBytes))
+	if e.model.addrBytes == 1 {
+		return dev, []byte{byte(off)}
+	}
+	return dev, []byte{byte(off >> 8), byte(off)}
+}
+
+// span returns how many bytes of n from off one transfer can cover: a
+// transfer can't cross a page (writes) or the range of the address bytes
+// (reads, which otherwise wrap within it)
+func (e *AT24) span(off, n, unit int) int {
+	if rest := unit - off%unit; n > rest {
+		n = rest
+	}
+	if n > at24MaxTransfer {
+		n = at24MaxTransfer
+	}
+	return n
+}
+
+func (e *AT24) check(op string, off, n int) error {
+	if off < 0 || off+n > e.model.size {
+		return &Error{Op: "at24 " + op, Kind: ErrNotSupported,
+			Err: fmt.Errorf("%d bytes at %d outside the %d byte memory", n, off, e.model.size)}
+	}
+	return nil
+}
+
+// ReadAt fills p from offset off
+func (e *AT24) ReadAt(ctx context.Context, p []byte, off int) error {
+	if err := e.check("read", off, len(p)); err != nil {
+		return err
+	}
+	for len(p) > 0 {
+		n := e.span(off, len(p), 1<<(8*e.model.addrBytes))
+		dev, addr := e.address(off)
+		data, err := e.bus.WriteRead(ctx, dev, addr, n)
+		if err != nil {
+			return err
+		}
+		if len(data) != n {
+			return &Error{Op: "at24 read", Err: fmt.Errorf("short read at %d: %d of %d bytes", off, len(data), n)}
+		}
+		copy(p, data)
+		p, off = p[n:], off+n
+	}
+	return nil
+}
+
+// WriteAt stores p at offset off, page by page
+func (e *AT24) WriteAt(ctx context.Context, p []byte, off int) error {
+	if err := e.check("write", off, len(p)); err != nil {
+		return err
+	}
+	for len(p) > 0 {
+		n := e.span(off, len(p), e.model.pageSize)
+		dev, addr := e.address(off)
+		if err := e.bus.Write(ctx, dev, append(addr, p[:n]...)); err != nil {
+			return err
+		}
+		if err := e.wait(ctx, off); err != nil {
+			return err
+		}
+		p, off = p[n:], off+n
+	}
+	return nil
+}
+
+// wait polls the chip until it acknowledges its address after a write,
+// which it doesn't while programming the page
+func (e *AT24) wait(ctx context.Context, off int) error {
+	if e.model.fram {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, at24WriteTimeout)
+	defer cancel()
+	dev, addr := e.address(off)
+	for {
+		err := e.bus.Write(ctx, dev, addr)
+		if err == nil || !errors.Is(err, ErrNack) {
+			return err
+		}
+		if err := sleepCtx(ctx, at24PollInterval); err != nil {
+			return &Error{Op: "at24 write", Kind: ErrTimeout, Err: fmt.Errorf("write cycle at %d did not complete", off)}
+		}
+	}
+}
+
+// Close releases the bus if it was opened by OpenEEPROM
+func (e *AT24) Close() error {
+	if e.ownsBus {
+		return e.bus.Close()
+	}
+	return nil
+}
+
+// SysfsEEPROM is a memory bound to the kernel's at24 driver, through its
+// eeprom file (/sys/bus/i2c/devices/1-0050/eeprom)
+type SysfsEEPROM struct {
+	file *os.File
+	size int
+}
+
+// OpenSysfsEEPROM opens the eeprom file of a memory
+func OpenSysfsEEPROM(path string) (*SysfsEEPROM, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if errors.Is(err, os.ErrPermission) {
+		file, err = os.Open(path)
+	}
+	if err != nil {
+		return nil, opError("eeprom open "+path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, opError("eeprom open "+path, err)
+	}
+	return &SysfsEEPROM{file: file, size: int(info.Size())}, nil
+}
+
+// Size returns the capacity in bytes
+func (s *SysfsEEPROM) Size() int { return s.size }
+
+// ReadAt fills p from offset off
+func (s *SysfsEEPROM) ReadAt(ctx context.Context, p []byte, off int) error {
+	if err := ctx.Err(); err != nil {
+		return opError("eeprom read "+s.file.Name(), err)
+	}
+	_, err := s.file.ReadAt(p, int64(off))
+	return opError("eeprom read "+s.file.Name(), err)
+}
+
+// WriteAt stores p at offset off; the kernel driver handles pages and
+// write cycles
+func (s *SysfsEEPROM) WriteAt(ctx context.Context, p []byte, off int) error {
+	if err := ctx.Err(); err != nil {
+		return opError("eeprom write "+s.file.Name(), err)
+	}
+	_, err := s.file.WriteAt(p, int64(off))
+	return opError("eeprom write "+s.file.Name(), err)
+}
+
+// Close closes the eeprom file
+func (s *SysfsEEPROM) Close() error { return s.file.Close() }
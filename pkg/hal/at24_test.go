@@ -0,0 +1,89 @@
+package hal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// fakeAT24 is an I2C bus with an AT24C16 behind it: 8 blocks of 256 bytes
+// selected by the low device address bits, 16 byte pages, and no
+// acknowledge for a few polls after each write
+type fakeAT24 struct {
+	mem    [2048]byte
+	busy   int
+	writes []int // bytes per page write
+}
+
+func (f *fakeAT24) Write(ctx context.Context, addr byte, data []byte) error {
+	if f.busy > 0 {
+		f.busy--
+		return &Error{Op: "i2c write", Kind: ErrNack, Err: errors.New("busy")}
+	}
+	if len(data) == 1 {
+		return nil // address only
+	}
+	base := int(addr&0x07)<<8 | int(data[0])
+	page := base &^ 15
+	for i, b := range data[1:] {
+		f.mem[page+(base+i)%16] = b
+	}
+	f.writes = append(f.writes, len(data)-1)
+	f.busy = 3
+	return nil
+}
+
+func (f *fakeAT24) Read(ctx context.Context, addr byte, length int) ([]byte, error) {
+	return nil, errors.New("unused")
+}
+
+func (f *fakeAT24) WriteRead(ctx context.Context, addr byte, data []byte, n int) ([]byte, error) {
+	block := int(addr&0x07) << 8
+	out := make([]byte, n)
+	for i := range out {
+		out[i] = f.mem[block+(int(data[0])+i)%256]
+	}
+	return out, nil
+}
+
+func (f *fakeAT24) Close() error { return nil }
+
+func TestAT24(t *testing.T) {
+	ctx := context.Background()
+	bus := &fakeAT24{}
+	e, err := NewAT24(bus, 0x50, "24c16")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 40 bytes at 250 cross pages at 256 and 272 and the block at 256
+	data := make([]byte, 40)
+	for i := range data {
+		data[i] = byte(i + 1)
+	}
+	if err := e.WriteAt(ctx, data, 250); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{6, 16, 16, 2}; fmt.Sprint(bus.writes) != fmt.Sprint(want) {
+		t.Errorf("page writes %v, want %v", bus.writes, want)
+	}
+	if !bytes.Equal(bus.mem[250:290], data) {
+		t.Errorf("memory % x", bus.mem[250:290])
+	}
+	got := make([]byte, 40)
+	if err := e.ReadAt(ctx, got, 250); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("read % x", got)
+	}
+
+	if err := e.WriteAt(ctx, data, 2040); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("write past the end: got %v", err)
+	}
+	if _, err := NewAT24(bus, 0x50, "24c99"); err == nil {
+		t.Error("unknown model accepted")
+	}
+}
@@ -0,0 +1,97 @@
+package hal
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// Failure classes reported by the HAL backends. Backend errors match these
+// with errors.Is, while still unwrapping to the underlying cause (errno,
+// context error, ...), so callers can decide between retrying and failing
+// fast without inspecting error strings.
+var (
+	// ErrBusBusy means the bus or line is held by someone else, or bus
+	// arbitration was lost
+	ErrBusBusy = errors.New("bus busy")
+	// ErrNack means the addressed device did not acknowledge
+	ErrNack = errors.New("no acknowledge from device")
+	// ErrTimeout means the operation did not complete in time
+	ErrTimeout = errors.New("timeout")
+	// ErrNotSupported means the backend, kernel or device lacks the feature
+	ErrNotSupported = errors.New("not supported")
+	// ErrPermission means the device node or sysfs file is not accessible
+	ErrPermission = errors.New("permission denied")
+	// ErrPeriodConflict means a PWM output cannot have its own period
+	// because it shares the period generator with another output in use
+	ErrPeriodConflict = errors.New("period conflicts with another output on the same generator")
+)
+
+// Error describes a failed hardware operation
+type Error struct {
+	Op   string // operation and device, e.g. "i2c write /dev/i2c-1@0x48"
+	Kind error  // one of the Err* classes above, nil if unclassified
+	Err  error  // underlying cause
+}
+
+func (e *Error) Error() string {
+	return e.Op + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying cause
+func (e *Error) Unwrap() error { return e.Err }
+
+// Is reports whether target is the failure class of e
+func (e *Error) Is(target error) bool {
+	return e.Kind != nil && target == e.Kind
+}
+
+// Transient reports whether err is worth retrying: a busy bus, a missing
+// acknowledge (often caused by noise or a device that is still waking up)
+// or a timeout. Cancellation and an open circuit breaker are never transient.
+func Transient(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, ErrDegraded) {
+		return false
+	}
+	return errors.Is(err, ErrBusBusy) || errors.Is(err, ErrNack) || errors.Is(err, ErrTimeout)
+}
+
+// opError wraps err for op, classifying it from its errno or context error.
+// It returns nil for a nil err and leaves already wrapped errors alone.
+func opError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+	return &Error{Op: op, Kind: classify(err), Err: err}
+}
+
+func classify(err error) error {
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		switch errno {
+		case syscall.EBUSY, syscall.EAGAIN:
+			return ErrBusBusy
+		case syscall.ENXIO, errRemoteIO:
+			return ErrNack
+		case syscall.ETIMEDOUT:
+			return ErrTimeout
+		case syscall.ENOTTY, syscall.EOPNOTSUPP, syscall.ENOSYS:
+			return ErrNotSupported
+		case syscall.EACCES, syscall.EPERM:
+			return ErrPermission
+		}
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return ErrTimeout
+	case errors.Is(err, fs.ErrPermission):
+		return ErrPermission
+	}
+	return nil
+}
@@ -0,0 +1,7 @@
+package hal
+
+import "syscall"
+
+// errRemoteIO is what an I2C adapter reports when a device doesn't
+// acknowledge
+const errRemoteIO = syscall.EREMOTEIO
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package hal
+
+import "syscall"
+
+// errRemoteIO is Linux's EREMOTEIO, which no other system returns
+const errRemoteIO = syscall.Errno(121)
@@ -0,0 +1,263 @@
+package hal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// MTD character device ioctls (mtd/mtd-abi.h)
+var (
+	mtdMemGetInfo = ioc(iocRead, 'M', 1, 32)
+	mtdMemErase   = ioc(iocWrite, 'M', 2, 8)
+)
+
+// Flash is a NOR flash memory or one of its partitions. Program can only
+// clear bits, so a region is erased (set to 0xFF) before it is written.
+type Flash interface {
+	// Size returns the capacity in bytes
+	Size() int64
+	// EraseSize returns the smallest erasable block; Erase takes whole
+	// blocks
+	EraseSize() int64
+	ReadAt(ctx context.Context, p []byte, off int64) error
+	Erase(ctx context.Context, off, length int64) error
+	Program(ctx context.Context, p []byte, off int64) error
+	Close() error
+}
+
+// FlashConfig locates a flash memory for OpenFlash
+type FlashConfig struct {
+	// Device is an MTD device ("/dev/mtd0"), an MTD partition name from
+	// /proc/mtd ("u-boot"), or a spidev device ("/dev/spidev0.0") with
+	// the chip on it
+	Device string `json:"device"`
+	Mode   int    `json:"mode,omitempty"`  // SPI mode for spidev
+	Speed  int    `json:"speed,omitempty"` // SPI clock in Hz for spidev, default 1 MHz
+}
+
+// OpenFlash opens a flash memory through MTD, which is preferred when the
+// kernel already drives the chip, or directly over spidev
+func OpenFlash(ctx context.Context, cfg FlashConfig) (Flash, error) {
+	dev := cfg.Device
+	if strings.HasPrefix(filepath.Base(dev), "spidev") {
+		spi, err := NewLinuxSPI(dev, cfg.Mode, cfg.Speed)
+		if err != nil {
+			return nil, err
+		}
+		f, err := NewSPINOR(ctx, spi)
+		if err != nil {
+			spi.Close()
+			return nil, err
+		}
+		f.ownsBus = true
+		return f, nil
+	}
+	if !strings.Contains(dev, "/") {
+		parts, err := MTDPartitions()
+		if err != nil {
+			return nil, err
+		}
+		found := false
+		for _, p := range parts {
+			if p.Name == dev {
+				dev, found = p.Device, true
+				break
+			}
+		}
+		if !found {
+			return nil, &Error{Op: "flash open", Kind: ErrNotSupported, Err: fmt.Errorf("no MTD partition named %q", cfg.Device)}
+		}
+	}
+	return OpenMTD(dev)
+}
+
+// MTDPartition is an entry of /proc/mtd
+type MTDPartition struct {
+	Device    string // e.g. "/dev/mtd0"
+	Name      string
+	Size      int64
+	EraseSize int64
+}
+
+// MTDPartitions lists the MTD devices and partitions known to the kernel
+func MTDPartitions() ([]MTDPartition, error) {
+	file, err := os.Open("/proc/mtd")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, opError("flash list", err)
+	}
+	defer file.Close()
+
+	// mtd0: 00100000 00010000 "spl"
+	var parts []MTDPartition
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || !strings.HasPrefix(fields[0], "mtd") {
+			continue
+		}
+		size, err1 := strconv.ParseInt(fields[1], 16, 64)
+		erase, err2 := strconv.ParseInt(fields[2], 16, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		name := strings.Trim(strings.Join(fields[3:], " "), `"`)
+		parts = append(parts, MTDPartition{Device: "/dev/" + strings.TrimSuffix(fields[0], ":"), Name: name, Size: size, EraseSize: erase})
+	}
+	return parts, scanner.Err()
+}
+
+// MTD is a flash device or partition exposed by the kernel as an MTD
+// character device
+type MTD struct {
+	file      *os.File
+	size      int64
+	eraseSize int64
+}
+
+// mtdInfo is struct mtd_info_user
+type mtdInfo struct {
+	typ       uint8
+	_         [3]uint8
+	flags     uint32
+	size      uint32
+	eraseSize uint32
+	writeSize uint32
+	oobSize   uint32
+	_         uint64
+}
+
+// OpenMTD opens an MTD character device, read-only if it isn't writable
+func OpenMTD(device string) (*MTD, error) {
+	file, err := os.OpenFile(device, os.O_RDWR, 0)
+	if errors.Is(err, os.ErrPermission) {
+		file, err = os.Open(device)
+	}
+	if err != nil {
+		return nil, opError("mtd open", err)
+	}
+	var info mtdInfo
+	if err := ioctl(file.Fd(), mtdMemGetInfo, uintptr(unsafe.Pointer(&info))); err != nil {
+		file.Close()
+		return nil, opError("mtd info "+device, err)
+	}
+	return &MTD{file: file, size: int64(info.size), eraseSize: int64(info.eraseSize)}, nil
+}
+
+// Size returns the capacity in bytes
+func (m *MTD) Size() int64 { return m.size }
+
+// EraseSize returns the erase block size
+func (m *MTD) EraseSize() int64 { return m.eraseSize }
+
+func (m *MTD) check(op string, off, n int64) error {
+	if off < 0 || off+n > m.size {
+		return &Error{Op: "mtd " + op + " " + m.file.Name(), Kind: ErrNotSupported,
+			Err: fmt.Errorf("%d bytes at 0x%x outside the 0x%x byte device", n, off, m.size)}
+	}
+	return nil
+}
+
+// ReadAt fills p from offset off
+func (m *MTD) ReadAt(ctx context.Context, p []byte, off int64) error {
+	if err := m.check("read", off, int64(len(p))); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return opError("mtd read "+m.file.Name(), err)
+	}
+	_, err := m.file.ReadAt(p, off)
+	return opError("mtd read "+m.file.Name(), err)
+}
+
+// Erase erases whole erase blocks
+func (m *MTD) Erase(ctx context.Context, off, length int64) error {
+	op := "mtd erase " + m.file.Name()
+	if err := m.check("erase", off, length); err != nil {
+		return err
+	}
+	if off%m.eraseSize != 0 || length%m.eraseSize != 0 {
+		return &Error{Op: op, Kind: ErrNotSupported, Err: fmt.Errorf("0x%x bytes at 0x%x not aligned to 0x%x byte blocks", length, off, m.eraseSize)}
+	}
+	for ; length > 0; off, length = off+m.eraseSize, length-m.eraseSize {
+		if err := ctx.Err(); err != nil {
+			return opError(op, err)
+		}
+		arg := [2]uint32{uint32(off), uint32(m.eraseSize)}
+		if err := ioctl(m.file.Fd(), mtdMemErase, uintptr(unsafe.Pointer(&arg))); err != nil {
+			return opError(fmt.Sprintf("%s at 0x%x", op, off), err)
+		}
+	}
+	return nil
+}
+
+// Program writes p at offset off, which must have been erased
+func (m *MTD) Program(ctx context.Context, p []byte, off int64) error {
+	if err := m.check("program", off, int64(len(p))); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return opError("mtd program "+m.file.Name(), err)
+	}
+	_, err := m.file.WriteAt(p, off)
+	return opError("mtd program "+m.file.Name(), err)
+}
+
+// Close closes the device
+func (m *MTD) Close() error { return m.file.Close() }
+
+// UpdateFlash writes data at offset off, erase block by erase block:
+// blocks already holding the data are skipped, and the rest of a block
+// only partly covered by data is kept. It returns how many blocks were
+// rewritten and skipped.
+func UpdateFlash(ctx context.Context, f Flash, data []byte, off int64) (written, skipped int, err error) {
+	if off < 0 || off+int64(len(data)) > f.Size() {
+		return 0, 0, &Error{Op: "flash update", Kind: ErrNotSupported,
+			Err: fmt.Errorf("%d bytes at 0x%x don't fit in 0x%x bytes", len(data), off, f.Size())}
+	}
+	bs := f.EraseSize()
+	block := make([]byte, bs)
+	want := make([]byte, bs)
+	for start := off - off%bs; start < off+int64(len(data)); start += bs {
+		if err := f.ReadAt(ctx, block, start); err != nil {
+			return written, skipped, err
+		}
+		copy(want, block)
+		lo, hi := start, start+bs
+		if lo < off {
+			lo = off
+		}
+		if end := off + int64(len(data)); hi > end {
+			hi = end
+		}
+		copy(want[lo-start:], data[lo-off:hi-off])
+		if bytes.Equal(block, want) {
+			skipped++
+			continue
+		}
+		if err := f.Erase(ctx, start, bs); err != nil {
+			return written, skipped, err
+		}
+		if err := f.Program(ctx, want, start); err != nil {
+			return written, skipped, err
+		}
+		if err := f.ReadAt(ctx, block, start); err != nil {
+			return written, skipped, err
+		}
+		if !bytes.Equal(block, want) {
+			return written, skipped, &Error{Op: "flash update", Err: fmt.Errorf("verify failed in block at 0x%x", start)}
+		}
+		written++
+	}
+	return written, skipped, nil
+}
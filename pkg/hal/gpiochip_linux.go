@@ -0,0 +1,396 @@
+package hal
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// GPIO character device uAPI v2 (linux/gpio.h)
+const (
+	gpioLineFlagActiveLow = 1 << 1
+	gpioLineFlagInput     = 1 << 2
+	gpioLineFlagOutput    = 1 << 3
+	gpioLineFlagEdgeRise  = 1 << 4
+	gpioLineFlagEdgeFall  = 1 << 5
+	gpioLineFlagPullUp    = 1 << 8
+	gpioLineFlagPullDown  = 1 << 9
+	gpioLineFlagBiasOff   = 1 << 10
+	gpioLineFlagBiasMask  = gpioLineFlagPullUp | gpioLineFlagPullDown | gpioLineFlagBiasOff
+
+	gpioLineAttrOutputValues = 2 // GPIO_V2_LINE_ATTR_ID_OUTPUT_VALUES
+
+	gpioLineEventRisingEdge = 1
+	gpioLineEventSize       = 48 // struct gpio_v2_line_event
+
+	gpioMaxLines    = 64
+	gpioMaxAttrs    = 10
+	gpioMaxNameSize = 32
+)
+
+type gpioChipInfo struct {
+	Name  [gpioMaxNameSize]byte
+	Label [gpioMaxNameSize]byte
+	Lines uint32
+}
+
+type gpioLineAttribute struct {
+	ID      uint32
+	Padding uint32
+	Value   uint64
+}
+
+type gpioLineConfigAttribute struct {
+	Attr gpioLineAttribute
+	Mask uint64
+}
+
+type gpioLineConfig struct {
+	Flags    uint64
+	NumAttrs uint32
+	Padding  [5]uint32
+	Attrs    [gpioMaxAttrs]gpioLineConfigAttribute
+}
+
+type gpioLineRequest struct {
+	Offsets         [gpioMaxLines]uint32
+	Consumer        [gpioMaxNameSize]byte
+	Config          gpioLineConfig
+	NumLines        uint32
+	EventBufferSize uint32
+	Padding         [5]uint32
+	Fd              int32
+}
+
+type gpioLineValues struct {
+	Bits uint64
+	Mask uint64
+}
+
+var (
+	gpioGetChipInfoIoctl    = ioc(iocRead, 0xB4, 0x01, unsafe.Sizeof(gpioChipInfo{}))
+	gpioV2GetLineIoctl      = ioc(iocRead|iocWrite, 0xB4, 0x07, unsafe.Sizeof(gpioLineRequest{}))
+	gpioV2SetConfigIoctl    = ioc(iocRead|iocWrite, 0xB4, 0x0D, unsafe.Sizeof(gpioLineConfig{}))
+	gpioV2GetValuesIoctl    = ioc(iocRead|iocWrite, 0xB4, 0x0E, unsafe.Sizeof(gpioLineValues{}))
+	gpioV2SetValuesIoctl    = ioc(iocRead|iocWrite, 0xB4, 0x0F, unsafe.Sizeof(gpioLineValues{}))
+	defaultGPIOChip         = "/dev/gpiochip0"
+	gpioConsumer            = "riscv-dev"
+	errGPIOLineNotRequested = errors.New("line not configured; call SetMode first")
+)
+
+func init() {
+	RegisterGPIODriver(GPIODriver{
+		Name:     "gpiochip",
+		Priority: 30,
+		Probe: func(cfg GPIOConfig) error {
+			chip, err := NewGPIOChip(cfg.Chip)
+			if err != nil {
+				return err
+			}
+			return chip.Close()
+		},
+		Open: func(cfg GPIOConfig) (GPIOController, error) {
+			return NewGPIOChip(cfg.Chip)
+		},
+	})
+}
+
+// GPIOChip implements GPIO through the Linux GPIO character device
+// (/dev/gpiochipN). Pin numbers are line offsets on the chip.
+type GPIOChip struct {
+	mu    sync.Mutex
+	path  string
+	chip  *os.File
+	lines map[int]*os.File
+	flags map[int]uint64 // as last configured, by line
+	Name  string
+	Label string
+	Lines int
+}
+
+// NewGPIOChip opens a GPIO chip device; an empty path selects /dev/gpiochip0
+func NewGPIOChip(path string) (*GPIOChip, error) {
+	if path == "" {
+		path = defaultGPIOChip
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join("/dev", path)
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, opError("gpiochip", err)
+	}
+
+	var info gpioChipInfo
+	if err := ioctl(f.Fd(), gpioGetChipInfoIoctl, uintptr(unsafe.Pointer(&info))); err != nil {
+		f.Close()
+		return nil, opError("gpiochip info "+path, err)
+	}
+
+	return &GPIOChip{
+		path:  path,
+		chip:  f,
+		lines: make(map[int]*os.File),
+		flags: make(map[int]uint64),
+		Name:  cString(info.Name[:]),
+		Label: cString(info.Label[:]),
+		Lines: int(info.Lines),
+	}, nil
+}
+
+func modeFlags(mode PinMode) uint64 {
+	if mode == Output {
+		return gpioLineFlagOutput
+	}
+	return gpioLineFlagInput
+}
+
+func edgeFlags(edge Edge) uint64 {
+	switch edge {
+	case RisingEdge:
+		return gpioLineFlagEdgeRise
+	case FallingEdge:
+		return gpioLineFlagEdgeFall
+	default:
+		return gpioLineFlagEdgeRise | gpioLineFlagEdgeFall
+	}
+}
+
+func biasFlags(b Bias) uint64 {
+	switch b {
+	case PullUp:
+		return gpioLineFlagPullUp
+	case PullDown:
+		return gpioLineFlagPullDown
+	case BiasDisabled:
+		return gpioLineFlagBiasOff
+	}
+	return 0
+}
+
+// SetMode requests the line from the kernel, or reconfigures it if already
+// held, keeping its bias
+func (c *GPIOChip) SetMode(pin int, mode PinMode) error {
+	return c.configure(pin, modeFlags(mode), nil)
+}
+
+// configure requests or reconfigures a line with flags, plus the bias it
+// already has; value, if set, is the initial level of an output
+func (c *GPIOChip) configure(pin int, flags uint64, value *bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if pin < 0 || pin >= c.Lines {
+		return fmt.Errorf("%s: line %d out of range (0-%d)", c.path, pin, c.Lines-1)
+	}
+	if flags&gpioLineFlagBiasMask == 0 {
+		flags |= c.flags[pin] & gpioLineFlagBiasMask
+	}
+	cfg := gpioLineConfig{Flags: flags}
+	if value != nil {
+		cfg.NumAttrs = 1
+		cfg.Attrs[0] = gpioLineConfigAttribute{Attr: gpioLineAttribute{ID: gpioLineAttrOutputValues}, Mask: 1}
+		if *value {
+			cfg.Attrs[0].Attr.Value = 1
+		}
+	}
+
+	if line, ok := c.lines[pin]; ok {
+		if err := ioctl(line.Fd(), gpioV2SetConfigIoctl, uintptr(unsafe.Pointer(&cfg))); err != nil {
+			return c.opError("configure", pin, err)
+		}
+		c.flags[pin] = flags
+		return nil
+	}
+
+	req := gpioLineRequest{NumLines: 1, Config: cfg}
+	req.Offsets[0] = uint32(pin)
+	copy(req.Consumer[:], gpioConsumer)
+	if err := ioctl(c.chip.Fd(), gpioV2GetLineIoctl, uintptr(unsafe.Pointer(&req))); err != nil {
+		// EBUSY: the line is held by another consumer
+		return c.opError("request", pin, err)
+	}
+	// Non-blocking so edge event reads go through the runtime poller and
+	// can be interrupted with a read deadline
+	syscall.SetNonblock(int(req.Fd), true)
+	c.lines[pin] = os.NewFile(uintptr(req.Fd), fmt.Sprintf("%s:%d", c.path, pin))
+	c.flags[pin] = flags
+	return nil
+}
+
+// LineStates returns the state of every requested line
+func (c *GPIOChip) LineStates(ctx context.Context) ([]LineState, error) {
+	c.mu.Lock()
+	states := make([]LineState, 0, len(c.flags))
+	for pin, flags := range c.flags {
+		s := LineState{Pin: pin, Mode: Input}
+		if flags&gpioLineFlagOutput != 0 {
+			s.Mode = Output
+		}
+		switch {
+		case flags&gpioLineFlagPullUp != 0:
+			s.Bias = PullUp
+		case flags&gpioLineFlagPullDown != 0:
+			s.Bias = PullDown
+		case flags&gpioLineFlagBiasOff != 0:
+			s.Bias = BiasDisabled
+		}
+		states = append(states, s)
+	}
+	c.mu.Unlock()
+
+	for i := range states {
+		v, err := c.Read(ctx, states[i].Pin)
+		if err != nil {
+			return nil, err
+		}
+		states[i].Value = v
+	}
+	return states, nil
+}
+
+// SetLineState requests or reconfigures a line in one step; an output
+// starts at s.Value
+func (c *GPIOChip) SetLineState(ctx context.Context, s LineState) error {
+	if err := ctx.Err(); err != nil {
+		return c.opError("configure", s.Pin, err)
+	}
+	flags := modeFlags(s.Mode) | biasFlags(s.Bias) // BiasDefault keeps the current bias
+	if s.Mode == Output {
+		return c.configure(s.Pin, flags, &s.Value)
+	}
+	return c.configure(s.Pin, flags, nil)
+}
+
+// Write drives a requested output line
+func (c *GPIOChip) Write(ctx context.Context, pin int, value bool) error {
+	if err := ctx.Err(); err != nil {
+		return c.opError("write", pin, err)
+	}
+	line, err := c.line(pin)
+	if err != nil {
+		return err
+	}
+	vals := gpioLineValues{Mask: 1}
+	if value {
+		vals.Bits = 1
+	}
+	return c.opError("write", pin, ioctl(line.Fd(), gpioV2SetValuesIoctl, uintptr(unsafe.Pointer(&vals))))
+}
+
+// Read returns the level of a requested line
+func (c *GPIOChip) Read(ctx context.Context, pin int) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, c.opError("read", pin, err)
+	}
+	line, err := c.line(pin)
+	if err != nil {
+		return false, err
+	}
+	vals := gpioLineValues{Mask: 1}
+	if err := ioctl(line.Fd(), gpioV2GetValuesIoctl, uintptr(unsafe.Pointer(&vals))); err != nil {
+		return false, c.opError("read", pin, err)
+	}
+	return vals.Bits&1 == 1, nil
+}
+
+// WatchEdges reconfigures the line as an edge-detecting input and streams
+// kernel-timestamped edge events until ctx is done
+func (c *GPIOChip) WatchEdges(ctx context.Context, pin int, edge Edge) (<-chan EdgeEvent, error) {
+	if err := c.configure(pin, gpioLineFlagInput|edgeFlags(edge), nil); err != nil {
+		return nil, err
+	}
+	line, err := c.line(pin)
+	if err != nil {
+		return nil, err
+	}
+	line.SetReadDeadline(time.Time{})
+
+	events := make(chan EdgeEvent, 16)
+	stop := context.AfterFunc(ctx, func() { line.SetReadDeadline(time.Now()) })
+	go func() {
+		defer close(events)
+		defer stop()
+
+		buf := make([]byte, 16*gpioLineEventSize)
+		for {
+			n, err := line.Read(buf)
+			if err != nil {
+				if ctx.Err() == nil && !errors.Is(err, io.EOF) && !errors.Is(err, os.ErrClosed) {
+					log.Printf("hal: %s: line %d: edge read: %v", c.path, pin, err)
+				}
+				return
+			}
+			for off := 0; off+gpioLineEventSize <= n; off += gpioLineEventSize {
+				ev := buf[off : off+gpioLineEventSize]
+				// timestamp_ns is CLOCK_MONOTONIC; report arrival wall time
+				// offset by the kernel's measured latency
+				ts := time.Duration(binary.LittleEndian.Uint64(ev[0:8]))
+				select {
+				case events <- EdgeEvent{
+					Pin:    pin,
+					Rising: binary.LittleEndian.Uint32(ev[8:12]) == gpioLineEventRisingEdge,
+					Time:   time.Now().Add(ts - monotonicNow()),
+				}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return events, nil
+}
+
+// monotonicNow reads CLOCK_MONOTONIC, the clock used for GPIO event timestamps
+func monotonicNow() time.Duration {
+	var ts syscall.Timespec
+	syscall.Syscall(syscall.SYS_CLOCK_GETTIME, 1 /* CLOCK_MONOTONIC */, uintptr(unsafe.Pointer(&ts)), 0)
+	return time.Duration(ts.Nano())
+}
+
+func (c *GPIOChip) opError(op string, pin int, err error) error {
+	return opError(fmt.Sprintf("gpiochip %s %s:%d", op, c.path, pin), err)
+}
+
+func (c *GPIOChip) line(pin int) (*os.File, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	line, ok := c.lines[pin]
+	if !ok {
+		return nil, fmt.Errorf("%s: line %d: %w", c.path, pin, errGPIOLineNotRequested)
+	}
+	return line, nil
+}
+
+// Close releases all requested lines and the chip
+func (c *GPIOChip) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var errs []error
+	for pin, line := range c.lines {
+		errs = append(errs, line.Close())
+		delete(c.lines, pin)
+		delete(c.flags, pin)
+	}
+	errs = append(errs, c.chip.Close())
+	return errors.Join(errs...)
+}
+
+func cString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,274 @@
+// Package hal provides the hardware abstraction layer shared by the
+// riscv-dev applications: small controller interfaces for GPIO, ADC and
+// PWM access plus a driver registry so applications can select a backend
+// (sysfs, IIO, simulation, ...) by name from their configuration.
+package hal
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// PinMode selects the direction of a GPIO pin
+type PinMode int
+
+const (
+	Input PinMode = iota
+	Output
+)
+
+func (m PinMode) String() string {
+	switch m {
+	case Input:
+		return "in"
+	case Output:
+		return "out"
+	default:
+		return fmt.Sprintf("PinMode(%d)", int(m))
+	}
+}
+
+// GPIOController drives digital pins. Read and Write honour ctx
+// cancellation and deadlines.
+type GPIOController interface {
+	SetMode(pin int, mode PinMode) error
+	Write(ctx context.Context, pin int, value bool) error
+	Read(ctx context.Context, pin int) (bool, error)
+	Close() error
+}
+
+// Edge selects which transitions an EdgeWatcher reports
+type Edge int
+
+const (
+	RisingEdge Edge = iota + 1
+	FallingEdge
+	BothEdges
+)
+
+func (e Edge) String() string {
+	switch e {
+	case RisingEdge:
+		return "rising"
+	case FallingEdge:
+		return "falling"
+	default:
+		return "both"
+	}
+}
+
+// EdgeEvent is a level transition observed on an input pin
+type EdgeEvent struct {
+	Pin    int
+	Rising bool
+	Time   time.Time
+}
+
+// EdgeWatcher is implemented by GPIO backends that can report edges. The
+// pin is configured as an input; events are delivered on the returned
+// channel until ctx is done, after which the channel is closed.
+type EdgeWatcher interface {
+	WatchEdges(ctx context.Context, pin int, edge Edge) (<-chan EdgeEvent, error)
+}
+
+// ADCController reads raw conversions from an analog-to-digital converter.
+// GetResolution returns the full-scale raw value (4095 for a 12-bit ADC).
+type ADCController interface {
+	ReadChannel(ctx context.Context, channel int) (int, error)
+	GetResolution() int
+	GetReferenceVoltage() float64
+	Close() error
+}
+
+// PWMController drives pulse-width modulated outputs. Outputs may share
+// a period generator, as the 16 of a PCA9685 do: SetPeriod then fails with
+// ErrPeriodConflict while another enabled output runs at a different
+// period. Periods are rounded to what the hardware can produce.
+type PWMController interface {
+	Channels() int
+	SetPeriod(ctx context.Context, channel int, period time.Duration) error
+	// SetDuty sets how long the output is high each period
+	SetDuty(ctx context.Context, channel int, duty time.Duration) error
+	Enable(ctx context.Context, channel int, on bool) error
+	Close() error
+}
+
+// GPIOConfig selects and configures a GPIO backend
+type GPIOConfig struct {
+	Driver string `json:"driver"`
+	Chip   string `json:"chip,omitempty"`
+}
+
+// PWMConfig selects and configures a PWM backend
+type PWMConfig struct {
+	Driver  string `json:"driver"`
+	Chip    string `json:"chip,omitempty"`    // sysfs pwmchip, e.g. "pwmchip0"
+	Device  string `json:"device,omitempty"`  // I2C bus of an expander
+	Address int    `json:"address,omitempty"` // I2C address of an expander
+}
+
+// EEPROMConfig locates a configuration memory (see OpenEEPROM)
+type EEPROMConfig struct {
+	Device  string `json:"device,omitempty"`  // I2C bus, or the kernel driver's eeprom file
+	Address int    `json:"address,omitempty"` // I2C address, default 0x50
+	Model   string `json:"model,omitempty"`   // e.g. "24c02", default "24c32"
+}
+
+// ADCConfig selects and configures an ADC backend
+type ADCConfig struct {
+	Driver           string  `json:"driver"`
+	Device           string  `json:"device,omitempty"`
+	Address          int     `json:"address,omitempty"`
+	Resolution       int     `json:"resolution,omitempty"`
+	ReferenceVoltage float64 `json:"reference_voltage,omitempty"`
+}
+
+// GPIODriver is a registered GPIO backend. Probe reports whether the
+// backend can be used on this system for cfg; Priority orders backends
+// during auto-selection (higher is preferred).
+type GPIODriver struct {
+	Name     string
+	Priority int
+	Probe    func(cfg GPIOConfig) error
+	Open     func(cfg GPIOConfig) (GPIOController, error)
+}
+
+// ADCDriver is a registered ADC backend, see GPIODriver
+type ADCDriver struct {
+	Name     string
+	Priority int
+	Probe    func(cfg ADCConfig) error
+	Open     func(cfg ADCConfig) (ADCController, error)
+}
+
+// PWMDriver is a registered PWM backend, see GPIODriver
+type PWMDriver struct {
+	Name     string
+	Priority int
+	Probe    func(cfg PWMConfig) error
+	Open     func(cfg PWMConfig) (PWMController, error)
+}
+
+var (
+	driversMu   sync.RWMutex
+	gpioDrivers = make(map[string]GPIODriver)
+	adcDrivers  = make(map[string]ADCDriver)
+	pwmDrivers  = make(map[string]PWMDriver)
+)
+
+// RegisterGPIODriver makes a GPIO backend available by name
+func RegisterGPIODriver(d GPIODriver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	gpioDrivers[d.Name] = d
+}
+
+// RegisterADCDriver makes an ADC backend available by name
+func RegisterADCDriver(d ADCDriver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	adcDrivers[d.Name] = d
+}
+
+// RegisterPWMDriver makes a PWM backend available by name
+func RegisterPWMDriver(d PWMDriver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	pwmDrivers[d.Name] = d
+}
+
+// NewGPIOController opens the GPIO backend named in cfg. An empty driver
+// or "auto" probes the registered backends and picks the best one.
+func NewGPIOController(cfg GPIOConfig) (GPIOController, error) {
+	if cfg.Driver == "" || cfg.Driver == Auto {
+		name, err := SelectGPIODriver(cfg)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Driver = name
+	}
+
+	driversMu.RLock()
+	d, ok := gpioDrivers[cfg.Driver]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, &Error{Op: "hal", Kind: ErrNotSupported, Err: fmt.Errorf("unsupported GPIO driver: %q (available: %v)", cfg.Driver, GPIODrivers())}
+	}
+	return d.Open(cfg)
+}
+
+// NewADCController opens the ADC backend named in cfg, see NewGPIOController
+func NewADCController(cfg ADCConfig) (ADCController, error) {
+	if cfg.Driver == "" || cfg.Driver == Auto {
+		name, err := SelectADCDriver(cfg)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Driver = name
+	}
+
+	driversMu.RLock()
+	d, ok := adcDrivers[cfg.Driver]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, &Error{Op: "hal", Kind: ErrNotSupported, Err: fmt.Errorf("unsupported ADC driver: %q (available: %v)", cfg.Driver, ADCDrivers())}
+	}
+	return d.Open(cfg)
+}
+
+// NewPWMController opens the PWM backend named in cfg, see NewGPIOController
+func NewPWMController(cfg PWMConfig) (PWMController, error) {
+	if cfg.Driver == "" || cfg.Driver == Auto {
+		name, err := SelectPWMDriver(cfg)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Driver = name
+	}
+
+	driversMu.RLock()
+	d, ok := pwmDrivers[cfg.Driver]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, &Error{Op: "hal", Kind: ErrNotSupported, Err: fmt.Errorf("unsupported PWM driver: %q (available: %v)", cfg.Driver, PWMDrivers())}
+	}
+	return d.Open(cfg)
+}
+
+// GPIODrivers returns the names of the registered GPIO backends
+func GPIODrivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	return sortedKeys(gpioDrivers)
+}
+
+// ADCDrivers returns the names of the registered ADC backends
+func ADCDrivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	return sortedKeys(adcDrivers)
+}
+
+// PWMDrivers returns the names of the registered PWM backends
+func PWMDrivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	return sortedKeys(pwmDrivers)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ToVoltage converts a raw ADC reading to volts
+func ToVoltage(adc ADCController, raw int) float64 {
+	return float64(raw) * adc.GetReferenceVoltage() / float64(adc.GetResolution())
+}
@@ -0,0 +1,74 @@
+//go:build hil
+
+// Hardware-in-the-loop tests, run on a board with `riscv-dev hiltest`.
+// They talk to the real backends, so they only build with the hil tag.
+
+package hal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestHILGPIOLoopback drives an output pin wired to an input pin, named by
+// RISCV_DEV_HIL_GPIO as "out:in", and checks the input follows it
+func TestHILGPIOLoopback(t *testing.T) {
+	var out, in int
+	if _, err := fmt.Sscanf(os.Getenv("RISCV_DEV_HIL_GPIO"), "%d:%d", &out, &in); err != nil {
+		t.Skip("set RISCV_DEV_HIL_GPIO=out:in to pins wired together")
+	}
+	gpio, err := NewGPIOController(GPIOConfig{Chip: os.Getenv("RISCV_DEV_HIL_GPIO_CHIP")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gpio.Close()
+	if err := gpio.SetMode(out, Output); err != nil {
+		t.Fatal(err)
+	}
+	if err := gpio.SetMode(in, Input); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for _, level := range []bool{true, false, true, false} {
+		if err := gpio.Write(ctx, out, level); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+		got, err := gpio.Read(ctx, in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != level {
+			t.Errorf("wrote %v to GPIO%d, GPIO%d reads %v", level, out, in, got)
+		}
+	}
+}
+
+// TestHILADC reads every channel of the auto-selected ADC
+func TestHILADC(t *testing.T) {
+	adc, err := NewADCController(ADCConfig{Device: os.Getenv("RISCV_DEV_HIL_ADC_DEVICE")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adc.Close()
+
+	channels := 1
+	fmt.Sscanf(os.Getenv("RISCV_DEV_HIL_ADC_CHANNELS"), "%d", &channels)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for ch := 0; ch < channels; ch++ {
+		raw, err := adc.ReadChannel(ctx, ch)
+		if err != nil {
+			t.Errorf("channel %d: %v", ch, err)
+			continue
+		}
+		if raw < 0 || raw > adc.GetResolution() {
+			t.Errorf("channel %d: %d outside 0..%d", ch, raw, adc.GetResolution())
+		}
+		t.Logf("channel %d: %d (%.3fV)", ch, raw, ToVoltage(adc, raw))
+	}
+}
@@ -0,0 +1,143 @@
+package hal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	i2cTimeoutIoctl = 0x0702 // I2C_TIMEOUT, in units of 10ms
+	i2cSlaveIoctl   = 0x0703 // I2C_SLAVE
+
+	i2cDefaultTimeout = time.Second
+)
+
+// I2CController performs transactions with devices on an I2C bus. A ctx
+// deadline bounds how long the kernel waits for a stuck transfer.
+type I2CController interface {
+	Write(ctx context.Context, addr byte, data []byte) error
+	Read(ctx context.Context, addr byte, length int) ([]byte, error)
+	WriteRead(ctx context.Context, addr byte, data []byte, readLength int) ([]byte, error)
+	Close() error
+}
+
+// LinuxI2C implements I2C using the Linux i2c-dev interface
+type LinuxI2C struct {
+	mu     sync.Mutex
+	file   *os.File
+	device string
+	addr   int
+	ticks  uintptr // adapter timeout last programmed, in 10ms units
+	ioctl  func(fd, req, arg uintptr) error
+}
+
+// NewLinuxI2C opens an i2c-dev bus such as "/dev/i2c-1"; an empty device
+// selects the first bus found.
+func NewLinuxI2C(device string) (*LinuxI2C, error) {
+	if device == "" {
+		matches, _ := filepath.Glob("/dev/i2c-*")
+		if len(matches) == 0 {
+			return nil, &Error{Op: "i2c open", Kind: ErrNotSupported, Err: errors.New("no I2C bus found (is the i2c-dev module loaded?)")}
+		}
+		device = matches[0]
+	}
+
+	file, err := os.OpenFile(device, os.O_RDWR, 0)
+	if err != nil {
+		return nil, opError("i2c", err)
+	}
+	return &LinuxI2C{file: file, device: device, addr: -1, ioctl: ioctl}, nil
+}
+
+// begin prepares a transaction: it fails fast if ctx is already done and
+// programs the adapter timeout from the ctx deadline so a hung transfer
+// returns instead of blocking the caller indefinitely.
+func (b *LinuxI2C) begin(ctx context.Context, addr byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	timeout := i2cDefaultTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+		if timeout <= 0 {
+			return context.DeadlineExceeded
+		}
+	}
+	// Compared in the ioctl's units, so transfers sharing a deadline don't
+	// reprogram the adapter every time
+	ticks := uintptr((timeout + 10*time.Millisecond - 1) / (10 * time.Millisecond))
+	if ticks != b.ticks {
+		if err := b.ioctl(b.file.Fd(), i2cTimeoutIoctl, ticks); err == nil {
+			b.ticks = ticks
+		}
+	}
+	return b.setAddress(addr)
+}
+
+// Device returns the bus device path
+func (b *LinuxI2C) Device() string { return b.device }
+
+func (b *LinuxI2C) setAddress(addr byte) error {
+	if b.addr == int(addr) {
+		return nil
+	}
+	if err := b.ioctl(b.file.Fd(), i2cSlaveIoctl, uintptr(addr)); err != nil {
+		// EBUSY here means a kernel driver has claimed the address
+		return err
+	}
+	b.addr = int(addr)
+	return nil
+}
+
+// Write sends data to the device at addr
+func (b *LinuxI2C) Write(ctx context.Context, addr byte, data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.begin(ctx, addr); err != nil {
+		return b.opError("write", addr, err)
+	}
+	_, err := b.file.Write(data)
+	return b.opError("write", addr, err)
+}
+
+// Read receives length bytes from the device at addr
+func (b *LinuxI2C) Read(ctx context.Context, addr byte, length int) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.begin(ctx, addr); err != nil {
+		return nil, b.opError("read", addr, err)
+	}
+	data := make([]byte, length)
+	n, err := b.file.Read(data)
+	return data[:n], b.opError("read", addr, err)
+}
+
+// WriteRead writes a register address (or command) then reads the reply
+func (b *LinuxI2C) WriteRead(ctx context.Context, addr byte, data []byte, readLength int) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.begin(ctx, addr); err != nil {
+		return nil, b.opError("write-read", addr, err)
+	}
+	if _, err := b.file.Write(data); err != nil {
+		return nil, b.opError("write-read", addr, err)
+	}
+	buf := make([]byte, readLength)
+	n, err := b.file.Read(buf)
+	return buf[:n], b.opError("write-read", addr, err)
+}
+
+func (b *LinuxI2C) opError(op string, addr byte, err error) error {
+	return opError(fmt.Sprintf("i2c %s %s@0x%02x", op, b.device, addr), err)
+}
+
+// Close releases the bus
+func (b *LinuxI2C) Close() error {
+	return b.file.Close()
+}
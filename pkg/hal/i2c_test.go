@@ -0,0 +1,46 @@
+package hal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestI2CTimeoutIoctl(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "i2c-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var timeouts []uintptr
+	b := &LinuxI2C{file: f, device: f.Name(), addr: -1, ioctl: func(fd, req, arg uintptr) error {
+		if req == i2cTimeoutIoctl {
+			timeouts = append(timeouts, arg)
+		}
+		return nil
+	}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	for i := 0; i < 20; i++ {
+		if err := b.Write(ctx, 0x48, []byte{0x01}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Once, or twice if the transfers straddled a 10ms boundary
+	if len(timeouts) == 0 || len(timeouts) > 2 || timeouts[0] != 360000 {
+		t.Errorf("timeout ioctls %v for 20 transfers with one deadline", timeouts)
+	}
+
+	timeouts = nil
+	for _, d := range []time.Duration{time.Second, 50 * time.Millisecond} {
+		ctx, cancel := context.WithTimeout(context.Background(), d)
+		b.Write(ctx, 0x48, []byte{0x01})
+		cancel()
+	}
+	if len(timeouts) != 2 || timeouts[0] != 100 || timeouts[1] != 5 {
+		t.Errorf("timeout ioctls %v for 1s and 50ms deadlines", timeouts)
+	}
+}
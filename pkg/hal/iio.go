@@ -0,0 +1,91 @@
+package hal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const iioRoot = "/sys/bus/iio/devices"
+
+func init() {
+	RegisterADCDriver(ADCDriver{
+		Name:     "iio",
+		Priority: 30,
+		Probe: func(cfg ADCConfig) error {
+			adc, err := NewIIOADC(cfg.Device, cfg.ReferenceVoltage, cfg.Resolution)
+			if err != nil {
+				return err
+			}
+			return adc.Close()
+		},
+		Open: func(cfg ADCConfig) (ADCController, error) {
+			return NewIIOADC(cfg.Device, cfg.ReferenceVoltage, cfg.Resolution)
+		},
+	})
+}
+
+// IIOADC implements ADC access through the Linux Industrial I/O subsystem
+type IIOADC struct {
+	basePath         string
+	referenceVoltage float64
+	resolution       int
+}
+
+// NewIIOADC opens an IIO device such as "iio:device0". An empty device
+// selects the first IIO device exposing voltage channels.
+func NewIIOADC(device string, refVoltage float64, resolution int) (*IIOADC, error) {
+	if device == "" {
+		matches, _ := filepath.Glob(iioRoot + "/iio:device*/in_voltage*_raw")
+		if len(matches) == 0 {
+			return nil, &Error{Op: "iio open", Kind: ErrNotSupported, Err: fmt.Errorf("no IIO ADC device found under %s", iioRoot)}
+		}
+		device = filepath.Base(filepath.Dir(matches[0]))
+	}
+
+	basePath := filepath.Join(iioRoot, device)
+	if _, err := os.Stat(basePath); err != nil {
+		return nil, opError("iio", err)
+	}
+	if refVoltage == 0 {
+		refVoltage = 3.3
+	}
+	if resolution == 0 {
+		resolution = 4095
+	}
+
+	return &IIOADC{
+		basePath:         basePath,
+		referenceVoltage: refVoltage,
+		resolution:       resolution,
+	}, nil
+}
+
+// ReadChannel returns the raw conversion for a voltage channel
+func (a *IIOADC) ReadChannel(ctx context.Context, channel int) (int, error) {
+	op := fmt.Sprintf("iio read %s channel %d", filepath.Base(a.basePath), channel)
+	if err := ctx.Err(); err != nil {
+		return 0, opError(op, err)
+	}
+	data, err := os.ReadFile(fmt.Sprintf("%s/in_voltage%d_raw", a.basePath, channel))
+	if err != nil {
+		return 0, opError(op, err)
+	}
+	value, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid ADC value: %w", err)
+	}
+	return value, nil
+}
+
+// GetResolution returns the full-scale raw value
+func (a *IIOADC) GetResolution() int { return a.resolution }
+
+// GetReferenceVoltage returns the configured reference voltage
+func (a *IIOADC) GetReferenceVoltage() float64 { return a.referenceVoltage }
+
+// Close is a no-op for sysfs-based IIO access
+func (a *IIOADC) Close() error { return nil }
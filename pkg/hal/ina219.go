@@ -0,0 +1,117 @@
+package hal
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// INA219 register map and configuration
+const (
+	ina219DefaultAddress = 0x40
+	ina219RegConfig      = 0x00
+	ina219RegShunt       = 0x01
+	ina219RegBus         = 0x02
+
+	// 32V bus range, ±320mV shunt range (PGA /8), 12-bit conversions of
+	// both, continuously: the power-on default, written in case something
+	// else changed it
+	ina219Config = 0x399F
+
+	ina219ShuntLSB = 10e-6 // volts per count of the shunt register
+	ina219BusLSB   = 4e-3  // volts per count of the bus register, above its 3 status bits
+	ina219Overflow = 1 << 0
+
+	// ina219DefaultShunt is the shunt on the common breakout boards, in ohms
+	ina219DefaultShunt = 0.1
+)
+
+// PowerReading is a supply's voltage, current and power
+type PowerReading struct {
+	Volts float64 `json:"volts"`
+	Amps  float64 `json:"amps"`
+	Watts float64 `json:"watts"`
+}
+
+// INA219 reads a TI INA219 current and power monitor: the voltage on the
+// load side of a shunt resistor and the current through it
+type INA219 struct {
+	bus     I2CController
+	addr    byte
+	shunt   float64 // ohms
+	ownsBus bool
+}
+
+// NewINA219 uses an already opened bus; Close does not close the bus. A
+// zero shunt is the breakout boards' 0.1Ω.
+func NewINA219(bus I2CController, addr byte, shunt float64) *INA219 {
+	if shunt <= 0 {
+		shunt = ina219DefaultShunt
+	}
+	return &INA219{bus: bus, addr: addr, shunt: shunt}
+}
+
+// OpenINA219 opens the I2C bus device (the first one if empty) and
+// configures the INA219 at addr (0x40 if zero)
+func OpenINA219(ctx context.Context, device string, addr byte, shunt float64) (*INA219, error) {
+	bus, err := NewLinuxI2C(device)
+	if err != nil {
+		return nil, err
+	}
+	if addr == 0 {
+		addr = ina219DefaultAddress
+	}
+	m := NewINA219(bus, addr, shunt)
+	m.ownsBus = true
+	if err := m.Configure(ctx); err != nil {
+		m.Close()
+		return nil, fmt.Errorf("no INA219 at 0x%02x: %w", addr, err)
+	}
+	return m, nil
+}
+
+// Configure sets the INA219 to measure continuously over its full ranges
+func (m *INA219) Configure(ctx context.Context) error {
+	return m.bus.Write(ctx, m.addr, []byte{ina219RegConfig, ina219Config >> 8, ina219Config & 0xFF})
+}
+
+func (m *INA219) readRegister(ctx context.Context, reg byte) (uint16, error) {
+	data, err := m.bus.WriteRead(ctx, m.addr, []byte{reg}, 2)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) != 2 {
+		return 0, opError(fmt.Sprintf("ina219 read register 0x%02x", reg), io.ErrUnexpectedEOF)
+	}
+	return uint16(data[0])<<8 | uint16(data[1]), nil
+}
+
+// Read returns the latest conversion. The power is the load's, the bus
+// voltage times the current; the shunt's own drop is left out.
+func (m *INA219) Read(ctx context.Context) (PowerReading, error) {
+	shunt, err := m.readRegister(ctx, ina219RegShunt)
+	if err != nil {
+		return PowerReading{}, err
+	}
+	bus, err := m.readRegister(ctx, ina219RegBus)
+	if err != nil {
+		return PowerReading{}, err
+	}
+	if bus&ina219Overflow != 0 {
+		return PowerReading{}, fmt.Errorf("INA219 at 0x%02x: current beyond the shunt's range", m.addr)
+	}
+	r := PowerReading{
+		Volts: float64(bus>>3) * ina219BusLSB,
+		Amps:  float64(int16(shunt)) * ina219ShuntLSB / m.shunt,
+	}
+	r.Watts = r.Volts * r.Amps
+	return r, nil
+}
+
+// Close releases the bus if it was opened by the driver
+func (m *INA219) Close() error {
+	if m.ownsBus {
+		return m.bus.Close()
+	}
+	return nil
+}
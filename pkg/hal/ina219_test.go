@@ -0,0 +1,67 @@
+package hal
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+)
+
+// fakeINA219 is an I2C bus with an INA219's 16-bit registers behind it
+type fakeINA219 struct {
+	regs [6]uint16
+}
+
+func (f *fakeINA219) Write(ctx context.Context, addr byte, data []byte) error {
+	if len(data) == 3 {
+		f.regs[data[0]] = uint16(data[1])<<8 | uint16(data[2])
+	}
+	return nil
+}
+
+func (f *fakeINA219) Read(ctx context.Context, addr byte, length int) ([]byte, error) {
+	return nil, errors.New("unused")
+}
+
+func (f *fakeINA219) WriteRead(ctx context.Context, addr byte, data []byte, n int) ([]byte, error) {
+	v := f.regs[data[0]]
+	return []byte{byte(v >> 8), byte(v)}, nil
+}
+
+func (f *fakeINA219) Close() error { return nil }
+
+func TestINA219(t *testing.T) {
+	ctx := context.Background()
+	bus := &fakeINA219{}
+	m := NewINA219(bus, ina219DefaultAddress, 0)
+	if err := m.Configure(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if bus.regs[ina219RegConfig] != 0x399F {
+		t.Errorf("config %#04x, want 0x399F", bus.regs[ina219RegConfig])
+	}
+
+	for _, tc := range []struct {
+		shunt, bus uint16
+		want       PowerReading
+	}{
+		// 40mV across 0.1Ω at 12V, conversion ready
+		{0x0FA0, 3000<<3 | 2, PowerReading{Volts: 12, Amps: 0.4, Watts: 4.8}},
+		// Current flowing back
+		{0xF060, 1250 << 3, PowerReading{Volts: 5, Amps: -0.4, Watts: -2}},
+	} {
+		bus.regs[ina219RegShunt], bus.regs[ina219RegBus] = tc.shunt, tc.bus
+		got, err := m.Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(got.Volts-tc.want.Volts) > 1e-9 || math.Abs(got.Amps-tc.want.Amps) > 1e-9 || math.Abs(got.Watts-tc.want.Watts) > 1e-9 {
+			t.Errorf("shunt %#04x, bus %#04x: got %+v, want %+v", tc.shunt, tc.bus, got, tc.want)
+		}
+	}
+
+	bus.regs[ina219RegBus] |= ina219Overflow
+	if _, err := m.Read(ctx); err == nil {
+		t.Error("no error on overflow")
+	}
+}
@@ -0,0 +1,42 @@
+package hal
+
+import (
+	"context"
+	"syscall"
+	"time"
+)
+
+// access(2) mode bits
+const (
+	accessRead  = 0x4 // R_OK
+	accessWrite = 0x2 // W_OK
+)
+
+// Linux ioctl request encoding (asm-generic, shared by riscv64)
+const (
+	iocWrite = 1
+	iocRead  = 2
+)
+
+func ioc(dir, typ, nr, size uintptr) uintptr {
+	return dir<<30 | size<<16 | typ<<8 | nr
+}
+
+func ioctl(fd, req, arg uintptr) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, arg); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// sleepCtx waits for d or until ctx is done
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,146 @@
+package hal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrDegraded is returned without touching the hardware while a channel's
+// circuit breaker is open
+var ErrDegraded = errors.New("sensor degraded")
+
+// ADCMiddleware adds behaviour around an ADCController's reads
+type ADCMiddleware func(ADCController) ADCController
+
+// WrapADC applies middleware in order, so the last one is outermost:
+//
+//	adc = hal.WrapADC(adc, hal.WithRetry(hal.DefaultRetryPolicy), hal.WithBreaker(hal.DefaultBreakerConfig))
+//
+// retries each read and trips the breaker only once retries are exhausted.
+func WrapADC(adc ADCController, mw ...ADCMiddleware) ADCController {
+	for _, m := range mw {
+		adc = m(adc)
+	}
+	return adc
+}
+
+// RetryPolicy bounds retries of transient failures (see Transient)
+type RetryPolicy struct {
+	Attempts   int           // total attempts including the first
+	Backoff    time.Duration // wait before the first retry, doubled each time
+	MaxBackoff time.Duration // upper bound for the wait, 0 for none
+}
+
+// DefaultRetryPolicy suits I2C/SPI sensors sampled a few times per second
+var DefaultRetryPolicy = RetryPolicy{Attempts: 3, Backoff: 5 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+
+// WithRetry retries reads that fail with a transient error, backing off
+// between attempts. Other errors and cancellation are returned at once.
+func WithRetry(p RetryPolicy) ADCMiddleware {
+	return func(next ADCController) ADCController {
+		return &retryADC{ADCController: next, policy: p}
+	}
+}
+
+type retryADC struct {
+	ADCController
+	policy RetryPolicy
+}
+
+func (r *retryADC) ReadChannel(ctx context.Context, channel int) (int, error) {
+	wait := r.policy.Backoff
+	for attempt := 1; ; attempt++ {
+		value, err := r.ADCController.ReadChannel(ctx, channel)
+		if err == nil || attempt >= r.policy.Attempts || !Transient(err) {
+			return value, err
+		}
+		if sleepCtx(ctx, wait) != nil {
+			return value, err
+		}
+		wait *= 2
+		if r.policy.MaxBackoff > 0 && wait > r.policy.MaxBackoff {
+			wait = r.policy.MaxBackoff
+		}
+	}
+}
+
+// BreakerConfig controls when a channel is considered degraded
+type BreakerConfig struct {
+	Failures int           // consecutive failed reads that open the breaker
+	Cooldown time.Duration // time before a single trial read is let through
+}
+
+// DefaultBreakerConfig gives up on a channel after 5 failures in a row and
+// probes it again every 10 seconds
+var DefaultBreakerConfig = BreakerConfig{Failures: 5, Cooldown: 10 * time.Second}
+
+// WithBreaker tracks consecutive failures per channel. Once a channel
+// reaches cfg.Failures, reads fail immediately with ErrDegraded instead of
+// waiting on the bus, until a trial read after the cooldown succeeds.
+func WithBreaker(cfg BreakerConfig) ADCMiddleware {
+	return func(next ADCController) ADCController {
+		return &breakerADC{ADCController: next, cfg: cfg, channels: make(map[int]*breakerState)}
+	}
+}
+
+type breakerState struct {
+	failures  int
+	openUntil time.Time
+	trial     bool
+	lastErr   error
+}
+
+type breakerADC struct {
+	ADCController
+	cfg      BreakerConfig
+	mu       sync.Mutex
+	channels map[int]*breakerState
+}
+
+func (b *breakerADC) ReadChannel(ctx context.Context, channel int) (int, error) {
+	b.mu.Lock()
+	st, ok := b.channels[channel]
+	if !ok {
+		st = &breakerState{}
+		b.channels[channel] = st
+	}
+	if st.failures >= b.cfg.Failures {
+		// Open: fail fast until the cooldown ends, then let one read through
+		if st.trial || time.Now().Before(st.openUntil) {
+			err := &Error{Op: fmt.Sprintf("adc channel %d", channel), Kind: ErrDegraded, Err: st.lastErr}
+			b.mu.Unlock()
+			return 0, err
+		}
+		st.trial = true
+	}
+	b.mu.Unlock()
+
+	value, err := b.ADCController.ReadChannel(ctx, channel)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st.trial = false
+	switch {
+	case err == nil:
+		if st.failures >= b.cfg.Failures {
+			log.Printf("hal: ADC channel %d recovered", channel)
+		}
+		st.failures = 0
+	case ctx.Err() != nil:
+		// The caller gave up; that says nothing about the sensor
+	default:
+		st.failures++
+		st.lastErr = err
+		if st.failures >= b.cfg.Failures {
+			if st.failures == b.cfg.Failures {
+				log.Printf("hal: ADC channel %d degraded after %d failures: %v", channel, st.failures, err)
+			}
+			st.openUntil = time.Now().Add(b.cfg.Cooldown)
+		}
+	}
+	return value, err
+}
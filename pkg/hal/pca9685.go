@@ -0,0 +1,269 @@
+package hal
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// PCA9685 register map and mode bits
+const (
+	pca9685DefaultAddress = 0x40
+	pca9685RegMode1       = 0x00
+	pca9685RegMode2       = 0x01
+	pca9685RegLED0        = 0x06 // ON_L, ON_H, OFF_L, OFF_H per output
+	pca9685RegPrescale    = 0xFE
+
+	pca9685Restart = 0x80
+	pca9685AutoInc = 0x20
+	pca9685Sleep   = 0x10
+	pca9685AllCall = 0x01
+	pca9685OutDrv  = 0x04 // MODE2: totem-pole outputs
+	pca9685Full    = 0x10 // in ON_H or OFF_H: always on or off
+
+	pca9685Channels    = 16
+	pca9685Steps       = 4096
+	pca9685Oscillator  = 25000000
+	pca9685MinPrescale = 3
+	pca9685MaxPrescale = 255
+	pca9685Wake        = 500 * time.Microsecond // oscillator start-up
+)
+
+func init() {
+	RegisterPWMDriver(PWMDriver{
+		Name:     "pca9685",
+		Priority: 20,
+		Probe: func(cfg PWMConfig) error {
+			p, err := openPCA9685(cfg)
+			if err != nil {
+				return err
+			}
+			defer p.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			if _, err := p.readRegister(ctx, pca9685RegMode1); err != nil {
+				return fmt.Errorf("no PCA9685 at 0x%02x: %w", p.addr, err)
+			}
+			return nil
+		},
+		Open: func(cfg PWMConfig) (PWMController, error) {
+			return openPCA9685(cfg)
+		},
+	})
+}
+
+// PCA9685 drives the 16 outputs of an NXP PCA9685 I2C PWM expander, as on
+// common servo and LED driver boards. All outputs share one period, from
+// about 0.65ms to 41ms (1526 to 24 Hz) with the internal oscillator, so
+// an output can only change it while no other output is enabled. Outputs
+// start their pulses at staggered points of the period to spread the
+// switching current of LED arrays.
+type PCA9685 struct {
+	// Oscillator is the clock in Hz, 25 MHz internally; set it before use
+	// to correct for a particular chip or an external clock
+	Oscillator int
+
+	bus     I2CController
+	addr    byte
+	ownsBus bool
+
+	mu       sync.Mutex
+	ready    bool
+	prescale int
+	outputs  [pca9685Channels]pca9685Output
+}
+
+type pca9685Output struct {
+	duty    time.Duration
+	enabled bool
+}
+
+// NewPCA9685 uses an already opened bus; Close does not close the bus
+func NewPCA9685(bus I2CController, addr byte) *PCA9685 {
+	return &PCA9685{Oscillator: pca9685Oscillator, bus: bus, addr: addr}
+}
+
+func openPCA9685(cfg PWMConfig) (*PCA9685, error) {
+	bus, err := NewLinuxI2C(cfg.Device)
+	if err != nil {
+		return nil, err
+	}
+	addr := byte(pca9685DefaultAddress)
+	if cfg.Address != 0 {
+		addr = byte(cfg.Address)
+	}
+	p := NewPCA9685(bus, addr)
+	p.ownsBus = true
+	return p, nil
+}
+
+func (p *PCA9685) readRegister(ctx context.Context, reg byte) (byte, error) {
+	data, err := p.bus.WriteRead(ctx, p.addr, []byte{reg}, 1)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) != 1 {
+		return 0, fmt.Errorf("pca9685 read register 0x%02x: short read", reg)
+	}
+	return data[0], nil
+}
+
+func (p *PCA9685) writeRegister(ctx context.Context, reg, value byte) error {
+	return p.bus.Write(ctx, p.addr, []byte{reg, value})
+}
+
+// setup wakes the chip with auto-increment and totem-pole outputs and
+// reads the period it runs at; p.mu must be held
+func (p *PCA9685) setup(ctx context.Context) error {
+	if p.ready {
+		return nil
+	}
+	if err := p.writeRegister(ctx, pca9685RegMode2, pca9685OutDrv); err != nil {
+		return err
+	}
+	if err := p.writeRegister(ctx, pca9685RegMode1, pca9685AutoInc|pca9685AllCall); err != nil {
+		return err
+	}
+	prescale, err := p.readRegister(ctx, pca9685RegPrescale)
+	if err != nil {
+		return err
+	}
+	if err := sleepCtx(ctx, pca9685Wake); err != nil {
+		return err
+	}
+	p.prescale, p.ready = int(prescale), true
+	return nil
+}
+
+// period returns the period produced by a prescale value
+func (p *PCA9685) period(prescale int) time.Duration {
+	return time.Duration(float64(prescale+1) * pca9685Steps / float64(p.Oscillator) * float64(time.Second))
+}
+
+// Channels returns 16
+func (p *PCA9685) Channels() int { return pca9685Channels }
+
+func (p *PCA9685) checkChannel(op string, channel int) error {
+	if channel < 0 || channel >= pca9685Channels {
+		return &Error{Op: fmt.Sprintf("pca9685 %s", op), Kind: ErrNotSupported, Err: fmt.Errorf("channel %d out of range (0-15)", channel)}
+	}
+	return nil
+}
+
+// SetPeriod programs the shared period, rounded to the nearest the chip
+// can produce. It fails with ErrPeriodConflict if that differs from the
+// current period while another output is enabled.
+func (p *PCA9685) SetPeriod(ctx context.Context, channel int, period time.Duration) error {
+	if err := p.checkChannel("set period", channel); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.setup(ctx); err != nil {
+		return err
+	}
+	prescale := int(math.Round(float64(p.Oscillator)*period.Seconds()/pca9685Steps)) - 1
+	if prescale < pca9685MinPrescale || prescale > pca9685MaxPrescale {
+		return &Error{Op: "pca9685 set period", Kind: ErrNotSupported,
+			Err: fmt.Errorf("%v outside %v to %v", period, p.period(pca9685MinPrescale), p.period(pca9685MaxPrescale))}
+	}
+	if prescale == p.prescale {
+		return nil
+	}
+	for i, o := range p.outputs {
+		if i != channel && o.enabled {
+			return &Error{Op: "pca9685 set period", Kind: ErrPeriodConflict,
+				Err: fmt.Errorf("channel %d: output %d runs at the shared period of %v", channel, i, p.period(p.prescale))}
+		}
+	}
+
+	// The prescaler can only be written while the oscillator sleeps
+	mode, err := p.readRegister(ctx, pca9685RegMode1)
+	if err != nil {
+		return err
+	}
+	mode &^= pca9685Restart
+	if err := p.writeRegister(ctx, pca9685RegMode1, mode|pca9685Sleep); err != nil {
+		return err
+	}
+	if err := p.writeRegister(ctx, pca9685RegPrescale, byte(prescale)); err != nil {
+		return err
+	}
+	if err := p.writeRegister(ctx, pca9685RegMode1, mode&^pca9685Sleep); err != nil {
+		return err
+	}
+	if err := sleepCtx(ctx, pca9685Wake); err != nil {
+		return err
+	}
+	if err := p.writeRegister(ctx, pca9685RegMode1, mode&^pca9685Sleep|pca9685Restart); err != nil {
+		return err
+	}
+	p.prescale = prescale
+	if p.outputs[channel].enabled {
+		return p.update(ctx, channel)
+	}
+	return nil
+}
+
+// SetDuty sets the output's high time, in steps of 1/4096 of the period
+func (p *PCA9685) SetDuty(ctx context.Context, channel int, duty time.Duration) error {
+	if err := p.checkChannel("set duty", channel); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.setup(ctx); err != nil {
+		return err
+	}
+	p.outputs[channel].duty = duty
+	if p.outputs[channel].enabled {
+		return p.update(ctx, channel)
+	}
+	return nil
+}
+
+// Enable starts or stops an output; a stopped output is held low
+func (p *PCA9685) Enable(ctx context.Context, channel int, on bool) error {
+	if err := p.checkChannel("enable", channel); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.setup(ctx); err != nil {
+		return err
+	}
+	p.outputs[channel].enabled = on
+	return p.update(ctx, channel)
+}
+
+// update writes an output's on and off counts; p.mu must be held
+func (p *PCA9685) update(ctx context.Context, channel int) error {
+	o := p.outputs[channel]
+	var on, off int
+	count := 0
+	if o.enabled {
+		count = int(math.Round(float64(o.duty) / float64(p.period(p.prescale)) * pca9685Steps))
+	}
+	switch {
+	case count <= 0:
+		off = pca9685Full << 8
+	case count >= pca9685Steps:
+		on = pca9685Full << 8
+	default:
+		on = channel * pca9685Steps / pca9685Channels
+		off = (on + count) % pca9685Steps
+	}
+	return p.bus.Write(ctx, p.addr, []byte{byte(pca9685RegLED0 + 4*channel),
+		byte(on), byte(on >> 8), byte(off), byte(off >> 8)})
+}
+
+// Close releases the bus if it was opened by the driver. The outputs keep
+// running, so servos hold their positions.
+func (p *PCA9685) Close() error {
+	if p.ownsBus {
+		return p.bus.Close()
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package hal
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakePCA9685 is an I2C bus with a PCA9685's registers behind it
+type fakePCA9685 struct {
+	regs [256]byte
+}
+
+func (f *fakePCA9685) Write(ctx context.Context, addr byte, data []byte) error {
+	for i, b := range data[1:] {
+		f.regs[int(data[0])+i] = b
+	}
+	return nil
+}
+
+func (f *fakePCA9685) Read(ctx context.Context, addr byte, length int) ([]byte, error) {
+	return nil, errors.New("unused")
+}
+
+func (f *fakePCA9685) WriteRead(ctx context.Context, addr byte, data []byte, n int) ([]byte, error) {
+	return append([]byte(nil), f.regs[data[0]:int(data[0])+n]...), nil
+}
+
+func (f *fakePCA9685) Close() error { return nil }
+
+func (f *fakePCA9685) counts(channel int) (on, off int) {
+	r := f.regs[pca9685RegLED0+4*channel:]
+	return int(r[0]) | int(r[1])<<8, int(r[2]) | int(r[3])<<8
+}
+
+func TestPCA9685(t *testing.T) {
+	ctx := context.Background()
+	bus := &fakePCA9685{}
+	bus.regs[pca9685RegPrescale] = 30 // power-on default
+	p := NewPCA9685(bus, pca9685DefaultAddress)
+
+	// 50 Hz: 25 MHz / (4096 * 50) - 1 = 121
+	if err := p.SetPeriod(ctx, 0, 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if got := bus.regs[pca9685RegPrescale]; got != 121 {
+		t.Errorf("prescale %d, want 121", got)
+	}
+	if bus.regs[pca9685RegMode1]&pca9685Sleep != 0 {
+		t.Error("left asleep")
+	}
+
+	// 1.5ms of 19.99ms is 307 steps, starting at channel 3's offset of 768
+	if err := p.SetDuty(ctx, 3, 1500*time.Microsecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Enable(ctx, 3, true); err != nil {
+		t.Fatal(err)
+	}
+	if on, off := bus.counts(3); on != 768 || off != 768+307 {
+		t.Errorf("channel 3: on %d off %d, want 768 %d", on, off, 768+307)
+	}
+
+	// The same period rounds to the same prescale; another one conflicts
+	if err := p.SetPeriod(ctx, 4, 20*time.Millisecond); err != nil {
+		t.Errorf("same period: %v", err)
+	}
+	if err := p.SetPeriod(ctx, 4, 5*time.Millisecond); !errors.Is(err, ErrPeriodConflict) {
+		t.Errorf("other period: got %v, want ErrPeriodConflict", err)
+	}
+
+	if err := p.Enable(ctx, 3, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, off := bus.counts(3); off != pca9685Full<<8 {
+		t.Errorf("disabled channel 3: off 0x%04x, want full off", off)
+	}
+	if err := p.SetPeriod(ctx, 4, 5*time.Millisecond); err != nil {
+		t.Errorf("period with no other output enabled: %v", err)
+	}
+}
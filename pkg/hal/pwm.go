@@ -0,0 +1,169 @@
+package hal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const sysfsPWMRoot = "/sys/class/pwm"
+
+func init() {
+	RegisterPWMDriver(PWMDriver{
+		Name:     "sysfs",
+		Priority: 10,
+		Probe: func(cfg PWMConfig) error {
+			dir, err := sysfsPWMChip(cfg.Chip)
+			if err != nil {
+				return err
+			}
+			if err := syscall.Access(dir+"/export", accessWrite); err != nil {
+				return opError("sysfs access "+dir+"/export", err)
+			}
+			return nil
+		},
+		Open: func(cfg PWMConfig) (PWMController, error) {
+			return NewSysfsPWM(cfg.Chip)
+		},
+	})
+}
+
+// sysfsPWMChip returns the directory of a pwmchip, the first if chip is
+// empty
+func sysfsPWMChip(chip string) (string, error) {
+	if chip == "" {
+		matches, _ := filepath.Glob(sysfsPWMRoot + "/pwmchip*")
+		if len(matches) == 0 {
+			return "", &Error{Op: "sysfs pwm open", Kind: ErrNotSupported, Err: errors.New("no PWM chip found")}
+		}
+		return matches[0], nil
+	}
+	dir := chip
+	if !strings.Contains(chip, "/") {
+		dir = filepath.Join(sysfsPWMRoot, chip)
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return "", &Error{Op: "sysfs pwm open", Kind: ErrNotSupported, Err: err}
+	}
+	return dir, nil
+}
+
+// SysfsPWM implements PWM with the Linux sysfs interface of one pwmchip.
+// Whether outputs share a period depends on the controller; the kernel
+// refusing a period with EBUSY is reported as ErrPeriodConflict.
+type SysfsPWM struct {
+	dir      string
+	channels int
+
+	mu       sync.Mutex
+	exported map[int]bool
+	duty     map[int]time.Duration
+}
+
+// NewSysfsPWM opens a pwmchip by name ("pwmchip0") or path; an empty chip
+// selects the first one found
+func NewSysfsPWM(chip string) (*SysfsPWM, error) {
+	dir, err := sysfsPWMChip(chip)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(dir + "/npwm")
+	if err != nil {
+		return nil, opError("sysfs pwm open "+dir, err)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, &Error{Op: "sysfs pwm open " + dir, Err: err}
+	}
+	return &SysfsPWM{dir: dir, channels: n, exported: make(map[int]bool), duty: make(map[int]time.Duration)}, nil
+}
+
+// Channels returns the number of outputs of the chip
+func (s *SysfsPWM) Channels() int { return s.channels }
+
+// attr returns the path of an output's attribute, exporting the output if
+// needed; s.mu must be held
+func (s *SysfsPWM) attr(channel int, name string) (string, error) {
+	if channel < 0 || channel >= s.channels {
+		return "", &Error{Op: "sysfs pwm", Kind: ErrNotSupported, Err: fmt.Errorf("channel %d out of range (0-%d)", channel, s.channels-1)}
+	}
+	dir := fmt.Sprintf("%s/pwm%d", s.dir, channel)
+	if !s.exported[channel] {
+		if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+			if err := writeFile(s.dir+"/export", strconv.Itoa(channel)); err != nil {
+				return "", opError(fmt.Sprintf("sysfs export PWM%d", channel), err)
+			}
+		}
+		s.exported[channel] = true
+	}
+	return dir + "/" + name, nil
+}
+
+func (s *SysfsPWM) write(ctx context.Context, op string, channel int, name string, value string) error {
+	if err := ctx.Err(); err != nil {
+		return opError(op, err)
+	}
+	path, err := s.attr(channel, name)
+	if err != nil {
+		return err
+	}
+	if err := writeFile(path, value); err != nil {
+		if errors.Is(err, syscall.EBUSY) && name == "period" {
+			return &Error{Op: op, Kind: ErrPeriodConflict, Err: err}
+		}
+		return opError(op, err)
+	}
+	return nil
+}
+
+// SetPeriod sets the output's period, lowering its duty cycle first if it
+// would exceed the new period
+func (s *SysfsPWM) SetPeriod(ctx context.Context, channel int, period time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	op := fmt.Sprintf("sysfs set period PWM%d", channel)
+	if d := s.duty[channel]; d > period {
+		if err := s.write(ctx, op, channel, "duty_cycle", strconv.FormatInt(int64(period), 10)); err != nil {
+			return err
+		}
+		s.duty[channel] = period
+	}
+	return s.write(ctx, op, channel, "period", strconv.FormatInt(int64(period), 10))
+}
+
+// SetDuty sets the output's high time, which must not exceed its period
+func (s *SysfsPWM) SetDuty(ctx context.Context, channel int, duty time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.write(ctx, fmt.Sprintf("sysfs set duty PWM%d", channel), channel, "duty_cycle", strconv.FormatInt(int64(duty), 10))
+	if err == nil {
+		s.duty[channel] = duty
+	}
+	return err
+}
+
+// Enable starts or stops an output
+func (s *SysfsPWM) Enable(ctx context.Context, channel int, on bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	val := "0"
+	if on {
+		val = "1"
+	}
+	return s.write(ctx, fmt.Sprintf("sysfs enable PWM%d", channel), channel, "enable", val)
+}
+
+// Close leaves the outputs exported and running
+func (s *SysfsPWM) Close() error { return nil }
+
+// writeFile writes a sysfs attribute
+func writeFile(path, data string) error {
+	return os.WriteFile(path, []byte(data), 0644)
+}
@@ -0,0 +1,131 @@
+package hal
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// Auto is the driver name that requests capability-based backend selection
+const Auto = "auto"
+
+// ProbeResult records why a backend was or was not usable
+type ProbeResult struct {
+	Driver   string
+	Priority int
+	Err      error
+}
+
+// ProbeGPIODrivers probes every registered GPIO backend, best first
+func ProbeGPIODrivers(cfg GPIOConfig) []ProbeResult {
+	driversMu.RLock()
+	drivers := make([]GPIODriver, 0, len(gpioDrivers))
+	for _, d := range gpioDrivers {
+		drivers = append(drivers, d)
+	}
+	driversMu.RUnlock()
+
+	sort.Slice(drivers, func(i, j int) bool {
+		if drivers[i].Priority != drivers[j].Priority {
+			return drivers[i].Priority > drivers[j].Priority
+		}
+		return drivers[i].Name < drivers[j].Name
+	})
+
+	results := make([]ProbeResult, 0, len(drivers))
+	for _, d := range drivers {
+		r := ProbeResult{Driver: d.Name, Priority: d.Priority}
+		if d.Probe != nil {
+			r.Err = d.Probe(cfg)
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+// ProbeADCDrivers probes every registered ADC backend, best first
+func ProbeADCDrivers(cfg ADCConfig) []ProbeResult {
+	driversMu.RLock()
+	drivers := make([]ADCDriver, 0, len(adcDrivers))
+	for _, d := range adcDrivers {
+		drivers = append(drivers, d)
+	}
+	driversMu.RUnlock()
+
+	sort.Slice(drivers, func(i, j int) bool {
+		if drivers[i].Priority != drivers[j].Priority {
+			return drivers[i].Priority > drivers[j].Priority
+		}
+		return drivers[i].Name < drivers[j].Name
+	})
+
+	results := make([]ProbeResult, 0, len(drivers))
+	for _, d := range drivers {
+		r := ProbeResult{Driver: d.Name, Priority: d.Priority}
+		if d.Probe != nil {
+			r.Err = d.Probe(cfg)
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+// ProbePWMDrivers probes every registered PWM backend, best first
+func ProbePWMDrivers(cfg PWMConfig) []ProbeResult {
+	driversMu.RLock()
+	drivers := make([]PWMDriver, 0, len(pwmDrivers))
+	for _, d := range pwmDrivers {
+		drivers = append(drivers, d)
+	}
+	driversMu.RUnlock()
+
+	sort.Slice(drivers, func(i, j int) bool {
+		if drivers[i].Priority != drivers[j].Priority {
+			return drivers[i].Priority > drivers[j].Priority
+		}
+		return drivers[i].Name < drivers[j].Name
+	})
+
+	results := make([]ProbeResult, 0, len(drivers))
+	for _, d := range drivers {
+		r := ProbeResult{Driver: d.Name, Priority: d.Priority}
+		if d.Probe != nil {
+			r.Err = d.Probe(cfg)
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+// SelectGPIODriver returns the best usable GPIO backend and logs the decision
+func SelectGPIODriver(cfg GPIOConfig) (string, error) {
+	return choose("GPIO", ProbeGPIODrivers(cfg))
+}
+
+// SelectADCDriver returns the best usable ADC backend and logs the decision
+func SelectADCDriver(cfg ADCConfig) (string, error) {
+	return choose("ADC", ProbeADCDrivers(cfg))
+}
+
+// SelectPWMDriver returns the best usable PWM backend and logs the decision
+func SelectPWMDriver(cfg PWMConfig) (string, error) {
+	return choose("PWM", ProbePWMDrivers(cfg))
+}
+
+func choose(kind string, results []ProbeResult) (string, error) {
+	var skipped []string
+	for _, r := range results {
+		if r.Err != nil {
+			skipped = append(skipped, fmt.Sprintf("%s: %v", r.Driver, r.Err))
+			continue
+		}
+		if len(skipped) > 0 {
+			log.Printf("hal: %s backend %q selected (skipped %s)", kind, r.Driver, strings.Join(skipped, "; "))
+		} else {
+			log.Printf("hal: %s backend %q selected", kind, r.Driver)
+		}
+		return r.Driver, nil
+	}
+	return "", &Error{Op: "hal", Kind: ErrNotSupported, Err: fmt.Errorf("no usable %s backend (%s)", kind, strings.Join(skipped, "; "))}
+}
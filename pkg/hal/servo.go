@@ -0,0 +1,64 @@
+package hal
+
+import (
+	"context"
+	"time"
+)
+
+// Servo positions a hobby servo on a PWM output: pulses from MinPulse to
+// MaxPulse, repeated every Period, move it from 0 to Range degrees. Many
+// servos can share a PCA9685, since they all run at the same period.
+type Servo struct {
+	PWM      PWMController
+	Channel  int
+	MinPulse time.Duration // default 1ms
+	MaxPulse time.Duration // default 2ms
+	Period   time.Duration // default 20ms (50 Hz)
+	Range    float64       // degrees, default 180
+
+	started bool
+}
+
+// SetAngle moves the servo, clamping the angle to its range. The first
+// call sets the period and enables the output.
+func (s *Servo) SetAngle(ctx context.Context, degrees float64) error {
+	min, max, period, rng := s.MinPulse, s.MaxPulse, s.Period, s.Range
+	if min == 0 {
+		min = time.Millisecond
+	}
+	if max == 0 {
+		max = 2 * time.Millisecond
+	}
+	if period == 0 {
+		period = 20 * time.Millisecond
+	}
+	if rng == 0 {
+		rng = 180
+	}
+	if degrees < 0 {
+		degrees = 0
+	} else if degrees > rng {
+		degrees = rng
+	}
+	pulse := min + time.Duration(degrees/rng*float64(max-min))
+	if err := s.PWM.SetDuty(ctx, s.Channel, pulse); err != nil {
+		return err
+	}
+	if s.started {
+		return nil
+	}
+	if err := s.PWM.SetPeriod(ctx, s.Channel, period); err != nil {
+		return err
+	}
+	if err := s.PWM.Enable(ctx, s.Channel, true); err != nil {
+		return err
+	}
+	s.started = true
+	return nil
+}
+
+// Release stops the pulses, letting the servo move freely
+func (s *Servo) Release(ctx context.Context) error {
+	s.started = false
+	return s.PWM.Enable(ctx, s.Channel, false)
+}
@@ -0,0 +1,180 @@
+package hal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Bias selects a GPIO line's internal pull resistor
+type Bias int
+
+const (
+	// BiasDefault leaves the bias as the kernel or bootloader set it
+	BiasDefault Bias = iota
+	BiasDisabled
+	PullUp
+	PullDown
+)
+
+var biasNames = [...]string{BiasDefault: "", BiasDisabled: "disabled", PullUp: "pull-up", PullDown: "pull-down"}
+
+func (b Bias) String() string {
+	if b >= BiasDefault && b <= PullDown {
+		if b == BiasDefault {
+			return "default"
+		}
+		return biasNames[b]
+	}
+	return fmt.Sprintf("Bias(%d)", int(b))
+}
+
+// MarshalText encodes the bias by name, empty for the default
+func (b Bias) MarshalText() ([]byte, error) {
+	if b < BiasDefault || b > PullDown {
+		return nil, fmt.Errorf("invalid bias %d", int(b))
+	}
+	return []byte(biasNames[b]), nil
+}
+
+// UnmarshalText decodes a bias name
+func (b *Bias) UnmarshalText(text []byte) error {
+	for i, name := range biasNames {
+		if string(text) == name || (i == int(BiasDefault) && string(text) == "default") {
+			*b = Bias(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown bias %q (want pull-up, pull-down or disabled)", text)
+}
+
+// MarshalText encodes the mode as "in" or "out"
+func (m PinMode) MarshalText() ([]byte, error) { return []byte(m.String()), nil }
+
+// UnmarshalText decodes "in" or "out"
+func (m *PinMode) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "in":
+		*m = Input
+	case "out":
+		*m = Output
+	default:
+		return fmt.Errorf("unknown pin mode %q (want in or out)", text)
+	}
+	return nil
+}
+
+// LineState is the configuration of a GPIO line
+type LineState struct {
+	Pin   int     `json:"pin"`
+	Mode  PinMode `json:"mode"`
+	Value bool    `json:"value"` // level driven on an output, or read on an input
+	Bias  Bias    `json:"bias,omitempty"`
+}
+
+// LineStater is implemented by GPIO backends that can report and fully
+// configure the lines they hold
+type LineStater interface {
+	// LineStates returns the state of every line configured through
+	// the controller, ordered by pin
+	LineStates(ctx context.Context) ([]LineState, error)
+	// SetLineState configures a line. An output starts at its Value
+	// without first glitching to another level where the backend allows.
+	SetLineState(ctx context.Context, s LineState) error
+}
+
+// GPIOSnapshot records the state of a controller's lines at one time
+type GPIOSnapshot struct {
+	Time  time.Time   `json:"time"`
+	Lines []LineState `json:"lines"`
+}
+
+// SnapshotGPIO returns the state of every line g holds
+func SnapshotGPIO(ctx context.Context, g GPIOController) (GPIOSnapshot, error) {
+	s, ok := g.(LineStater)
+	if !ok {
+		return GPIOSnapshot{}, &Error{Op: "gpio snapshot", Kind: ErrNotSupported, Err: fmt.Errorf("%T can't report line states", g)}
+	}
+	lines, err := s.LineStates(ctx)
+	if err != nil {
+		return GPIOSnapshot{}, err
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i].Pin < lines[j].Pin })
+	return GPIOSnapshot{Time: time.Now(), Lines: lines}, nil
+}
+
+// RestoreGPIO puts every line in snap back in its recorded state. It tries
+// every line, returning the errors of those that failed.
+func RestoreGPIO(ctx context.Context, g GPIOController, snap GPIOSnapshot) error {
+	s, ok := g.(LineStater)
+	if !ok {
+		return &Error{Op: "gpio restore", Kind: ErrNotSupported, Err: fmt.Errorf("%T can't restore line states", g)}
+	}
+	var errs []error
+	for _, line := range snap.Lines {
+		if err := ctx.Err(); err != nil {
+			return errors.Join(append(errs, err)...)
+		}
+		errs = append(errs, s.SetLineState(ctx, line))
+	}
+	return errors.Join(errs...)
+}
+
+// WithGPIOSnapshot runs fn, e.g. a self-test, and then restores the lines
+// to their state before it, also if fn panics
+func WithGPIOSnapshot(ctx context.Context, g GPIOController, fn func() error) (err error) {
+	snap, err := SnapshotGPIO(ctx, g)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rerr := RestoreGPIO(context.WithoutCancel(ctx), g, snap); rerr != nil {
+			err = errors.Join(err, fmt.Errorf("restoring GPIO lines: %w", rerr))
+		}
+	}()
+	return fn()
+}
+
+// SaveGPIOSnapshot writes snap to path as JSON, replacing the file
+// atomically so a crash never leaves half a snapshot
+func SaveGPIOSnapshot(path string, snap GPIOSnapshot) error {
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadGPIOSnapshot reads a snapshot written by SaveGPIOSnapshot
+func LoadGPIOSnapshot(path string) (GPIOSnapshot, error) {
+	var snap GPIOSnapshot
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return snap, err
+	}
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return snap, fmt.Errorf("GPIO snapshot %s: %w", path, err)
+	}
+	return snap, nil
+}
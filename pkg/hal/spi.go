@@ -0,0 +1,140 @@
+package hal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+// spidev ioctls (linux/spi/spidev.h)
+const (
+	spiIocMagic     = 'k'
+	spiTransferSize = 32 // struct spi_ioc_transfer
+	spiDefaultSpeed = 1000000
+	spiMaxMessage   = 4096 // spidev's default bufsiz, shared by the transfers of a message
+)
+
+var (
+	spiIocWrMode        = ioc(iocWrite, spiIocMagic, 1, 1)
+	spiIocWrBitsPerWord = ioc(iocWrite, spiIocMagic, 3, 1)
+	spiIocWrMaxSpeedHz  = ioc(iocWrite, spiIocMagic, 4, 4)
+)
+
+func spiIocMessage(n uintptr) uintptr {
+	return ioc(iocWrite, spiIocMagic, 0, n*spiTransferSize)
+}
+
+// spiTransfer is struct spi_ioc_transfer
+type spiTransfer struct {
+	txBuf       uint64
+	rxBuf       uint64
+	length      uint32
+	speedHz     uint32
+	delayUsecs  uint16
+	bitsPerWord uint8
+	csChange    uint8
+	txNbits     uint8
+	rxNbits     uint8
+	wordDelay   uint8
+	pad         uint8
+}
+
+// SPIController performs transactions with one device on an SPI bus
+type SPIController interface {
+	// Tx writes w then reads n bytes, with chip select held throughout
+	Tx(ctx context.Context, w []byte, n int) ([]byte, error)
+	Close() error
+}
+
+// LinuxSPI implements SPI using the Linux spidev interface. A message is
+// limited to spidev's buffer, 4096 bytes unless the bufsiz module
+// parameter raises it.
+type LinuxSPI struct {
+	mu     sync.Mutex
+	file   *os.File
+	device string
+	speed  uint32
+}
+
+// NewLinuxSPI opens a spidev device such as "/dev/spidev0.0" in SPI mode
+// 0 to 3, at speedHz (1 MHz if 0); an empty device selects the first one
+// found
+func NewLinuxSPI(device string, mode, speedHz int) (*LinuxSPI, error) {
+	if device == "" {
+		matches, _ := filepath.Glob("/dev/spidev*")
+		if len(matches) == 0 {
+			return nil, &Error{Op: "spi open", Kind: ErrNotSupported, Err: errors.New("no spidev device found (is the spidev module loaded and bound?)")}
+		}
+		device = matches[0]
+	}
+	if mode < 0 || mode > 3 {
+		return nil, &Error{Op: "spi open " + device, Kind: ErrNotSupported, Err: fmt.Errorf("invalid mode %d", mode)}
+	}
+	if speedHz == 0 {
+		speedHz = spiDefaultSpeed
+	}
+	file, err := os.OpenFile(device, os.O_RDWR, 0)
+	if err != nil {
+		return nil, opError("spi open", err)
+	}
+	s := &LinuxSPI{file: file, device: device, speed: uint32(speedHz)}
+	m, bits, speed := uint8(mode), uint8(8), uint32(speedHz)
+	for _, set := range []struct {
+		req uintptr
+		arg unsafe.Pointer
+	}{{spiIocWrMode, unsafe.Pointer(&m)}, {spiIocWrBitsPerWord, unsafe.Pointer(&bits)}, {spiIocWrMaxSpeedHz, unsafe.Pointer(&speed)}} {
+		if err := ioctl(file.Fd(), set.req, uintptr(set.arg)); err != nil {
+			file.Close()
+			return nil, opError("spi setup "+device, err)
+		}
+	}
+	return s, nil
+}
+
+// Device returns the spidev device path
+func (s *LinuxSPI) Device() string { return s.device }
+
+// Tx writes w then reads n bytes in one message
+func (s *LinuxSPI) Tx(ctx context.Context, w []byte, n int) ([]byte, error) {
+	op := "spi tx " + s.device
+	if err := ctx.Err(); err != nil {
+		return nil, opError(op, err)
+	}
+	if len(w)+n > spiMaxMessage {
+		return nil, &Error{Op: op, Kind: ErrNotSupported, Err: fmt.Errorf("%d byte message over the %d byte buffer", len(w)+n, spiMaxMessage)}
+	}
+	r := make([]byte, n)
+	var xfers [2]spiTransfer
+	count := 0
+	if len(w) > 0 {
+		xfers[count] = spiTransfer{txBuf: uint64(uintptr(unsafe.Pointer(&w[0]))), length: uint32(len(w)), speedHz: s.speed, bitsPerWord: 8}
+		count++
+	}
+	if n > 0 {
+		xfers[count] = spiTransfer{rxBuf: uint64(uintptr(unsafe.Pointer(&r[0]))), length: uint32(n), speedHz: s.speed, bitsPerWord: 8}
+		count++
+	}
+	if count == 0 {
+		return r, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := ioctl(s.file.Fd(), spiIocMessage(uintptr(count)), uintptr(unsafe.Pointer(&xfers[0])))
+	runtime.KeepAlive(w)
+	runtime.KeepAlive(r)
+	if err != nil {
+		return nil, opError(op, err)
+	}
+	return r, nil
+}
+
+// Close releases the device
+func (s *LinuxSPI) Close() error {
+	return s.file.Close()
+}
@@ -0,0 +1,206 @@
+package hal
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// SPI NOR commands common to the 25-series chips
+const (
+	norReadID      = 0x9F
+	norReadStatus  = 0x05
+	norWriteEnable = 0x06
+	norRead        = 0x03
+	norRead4       = 0x13
+	norProgram     = 0x02
+	norProgram4    = 0x12
+	norErase4K     = 0x20
+	norErase4K4    = 0x21
+	norErase64K    = 0xD8
+	norErase64K4   = 0xDC
+
+	norStatusBusy = 0x01 // WIP
+	norStatusWEL  = 0x02
+
+	norPageSize     = 256
+	norSectorSize   = 4096
+	norBlockSize    = 65536
+	norReadChunk    = 2048
+	norPollInterval = 100 * time.Microsecond
+
+	norProgramTimeout = 50 * time.Millisecond
+	norEraseTimeout   = 3 * time.Second // 64K blocks take up to 2s
+)
+
+// SPINOR drives a 25-series SPI NOR flash directly, for chips the kernel
+// doesn't bind an MTD driver to. The size comes from the capacity byte of
+// the JEDEC ID; chips over 16 MB use the 4-byte address commands. The
+// status register's block protection bits are left alone, so protected
+// regions fail to erase or program.
+type SPINOR struct {
+	spi     SPIController
+	id      [3]byte
+	size    int64
+	ownsBus bool
+}
+
+// NewSPINOR identifies the chip on an opened bus; Close does not close
+// the bus
+func NewSPINOR(ctx context.Context, spi SPIController) (*SPINOR, error) {
+	id, err := spi.Tx(ctx, []byte{norReadID}, 3)
+	if err != nil {
+		return nil, err
+	}
+	f := &SPINOR{spi: spi}
+	copy(f.id[:], id)
+	if id[0] == 0x00 || id[0] == 0xFF {
+		return nil, &Error{Op: "spi nor open", Kind: ErrNotSupported, Err: fmt.Errorf("no flash answering (JEDEC ID % x)", id)}
+	}
+	if id[2] < 0x10 || id[2] > 0x21 {
+		return nil, &Error{Op: "spi nor open", Kind: ErrNotSupported, Err: fmt.Errorf("unknown capacity in JEDEC ID % x", id)}
+	}
+	f.size = 1 << id[2]
+	return f, nil
+}
+
+// ID returns the JEDEC manufacturer, memory type and capacity bytes
+func (f *SPINOR) ID() [3]byte { return f.id }
+
+// Size returns the capacity in bytes
+func (f *SPINOR) Size() int64 { return f.size }
+
+// EraseSize returns the 4 KB sector size
+func (f *SPINOR) EraseSize() int64 { return norSectorSize }
+
+// command builds an opcode with an address, picking the 4-byte form of the
+// opcode on chips over 16 MB
+func (f *SPINOR) command(op3, op4 byte, addr int64) []byte {
+	if f.size > 1<<24 {
+		return []byte{op4, byte(addr >> 24), byte(addr >> 16), byte(addr >> 8), byte(addr)}
+	}
+	return []byte{op3, byte(addr >> 16), byte(addr >> 8), byte(addr)}
+}
+
+func (f *SPINOR) check(op string, off, n int64) error {
+	if off < 0 || off+n > f.size {
+		return &Error{Op: "spi nor " + op, Kind: ErrNotSupported,
+			Err: fmt.Errorf("%d bytes at 0x%x outside the 0x%x byte chip", n, off, f.size)}
+	}
+	return nil
+}
+
+// ReadAt fills p from offset off
+func (f *SPINOR) ReadAt(ctx context.Context, p []byte, off int64) error {
+	if err := f.check("read", off, int64(len(p))); err != nil {
+		return err
+	}
+	for len(p) > 0 {
+		n := len(p)
+		if n > norReadChunk {
+			n = norReadChunk
+		}
+		data, err := f.spi.Tx(ctx, f.command(norRead, norRead4, off), n)
+		if err != nil {
+			return err
+		}
+		copy(p, data)
+		p, off = p[n:], off+int64(n)
+	}
+	return nil
+}
+
+// writeEnable sets the write enable latch, which the chip clears after
+// every erase or program
+func (f *SPINOR) writeEnable(ctx context.Context, op string) error {
+	if _, err := f.spi.Tx(ctx, []byte{norWriteEnable}, 0); err != nil {
+		return err
+	}
+	status, err := f.spi.Tx(ctx, []byte{norReadStatus}, 1)
+	if err != nil {
+		return err
+	}
+	if status[0]&norStatusWEL == 0 {
+		return &Error{Op: "spi nor " + op, Kind: ErrPermission, Err: fmt.Errorf("write enable not latched (status 0x%02x; WP# held low?)", status[0])}
+	}
+	return nil
+}
+
+// wait polls the status register until the chip finishes an erase or
+// program
+func (f *SPINOR) wait(ctx context.Context, op string, off int64, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		status, err := f.spi.Tx(ctx, []byte{norReadStatus}, 1)
+		if err != nil {
+			return err
+		}
+		if status[0]&norStatusBusy == 0 {
+			return nil
+		}
+		if err := sleepCtx(ctx, norPollInterval); err != nil {
+			return &Error{Op: "spi nor " + op, Kind: ErrTimeout, Err: fmt.Errorf("at 0x%x: still busy after %v", off, timeout)}
+		}
+	}
+}
+
+// Erase erases whole 4 KB sectors, using 64 KB block erases where aligned
+func (f *SPINOR) Erase(ctx context.Context, off, length int64) error {
+	if err := f.check("erase", off, length); err != nil {
+		return err
+	}
+	if off%norSectorSize != 0 || length%norSectorSize != 0 {
+		return &Error{Op: "spi nor erase", Kind: ErrNotSupported, Err: fmt.Errorf("0x%x bytes at 0x%x not aligned to 4 KB sectors", length, off)}
+	}
+	for length > 0 {
+		cmd, n := f.command(norErase4K, norErase4K4, off), int64(norSectorSize)
+		if off%norBlockSize == 0 && length >= norBlockSize {
+			cmd, n = f.command(norErase64K, norErase64K4, off), norBlockSize
+		}
+		if err := f.writeEnable(ctx, "erase"); err != nil {
+			return err
+		}
+		if _, err := f.spi.Tx(ctx, cmd, 0); err != nil {
+			return err
+		}
+		if err := f.wait(ctx, "erase", off, norEraseTimeout); err != nil {
+			return err
+		}
+		off, length = off+n, length-n
+	}
+	return nil
+}
+
+// Program writes p at offset off, page by page; a page program wraps
+// around within its 256 byte page, so writes are split at page boundaries
+func (f *SPINOR) Program(ctx context.Context, p []byte, off int64) error {
+	if err := f.check("program", off, int64(len(p))); err != nil {
+		return err
+	}
+	for len(p) > 0 {
+		n := norPageSize - int(off%norPageSize)
+		if n > len(p) {
+			n = len(p)
+		}
+		if err := f.writeEnable(ctx, "program"); err != nil {
+			return err
+		}
+		if _, err := f.spi.Tx(ctx, append(f.command(norProgram, norProgram4, off), p[:n]...), 0); err != nil {
+			return err
+		}
+		if err := f.wait(ctx, "program", off, norProgramTimeout); err != nil {
+			return err
+		}
+		p, off = p[n:], off+int64(n)
+	}
+	return nil
+}
+
+// Close releases the bus if it was opened by OpenFlash
+func (f *SPINOR) Close() error {
+	if f.ownsBus {
+		return f.spi.Close()
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package hal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// fakeNOR is a 1 MB W25Q80-like chip on an SPI bus
+type fakeNOR struct {
+	mem      []byte
+	wel      bool
+	busy     int
+	programs []int // bytes per page program
+	erases   []int // bytes per erase
+	protect  bool  // ignore write enable, as with WP# low
+}
+
+func newFakeNOR() *fakeNOR {
+	f := &fakeNOR{mem: make([]byte, 1<<20)}
+	for i := range f.mem {
+		f.mem[i] = 0xFF
+	}
+	return f
+}
+
+func (f *fakeNOR) Tx(ctx context.Context, w []byte, n int) ([]byte, error) {
+	addr := func() int { return int(w[1])<<16 | int(w[2])<<8 | int(w[3]) }
+	if w[0] != norReadStatus && f.busy > 0 {
+		return nil, errors.New("command while busy")
+	}
+	switch w[0] {
+	case norReadID:
+		return []byte{0xEF, 0x40, 0x14}, nil
+	case norReadStatus:
+		var s byte
+		if f.busy > 0 {
+			f.busy--
+			s |= norStatusBusy
+		}
+		if f.wel {
+			s |= norStatusWEL
+		}
+		return []byte{s}, nil
+	case norWriteEnable:
+		f.wel = !f.protect
+		return nil, nil
+	case norRead:
+		return append([]byte(nil), f.mem[addr():addr()+n]...), nil
+	}
+	if !f.wel {
+		return nil, nil // ignored
+	}
+	f.wel, f.busy = false, 2
+	switch w[0] {
+	case norProgram:
+		page := addr() &^ (norPageSize - 1)
+		for i, b := range w[4:] {
+			f.mem[page+(addr()+i)%norPageSize] &= b
+		}
+		f.programs = append(f.programs, len(w)-4)
+	case norErase4K, norErase64K:
+		size := norSectorSize
+		if w[0] == norErase64K {
+			size = norBlockSize
+		}
+		for i := addr(); i < addr()+size; i++ {
+			f.mem[i] = 0xFF
+		}
+		f.erases = append(f.erases, size)
+	}
+	return nil, nil
+}
+
+func (f *fakeNOR) Close() error { return nil }
+
+func TestSPINOR(t *testing.T) {
+	ctx := context.Background()
+	chip := newFakeNOR()
+	f, err := NewSPINOR(ctx, chip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Size() != 1<<20 {
+		t.Fatalf("size %d", f.Size())
+	}
+
+	// 0x1F000 to 0x31000: a sector, a 64 KB block, then a sector
+	if err := f.Erase(ctx, 0x1F000, 0x12000); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{norSectorSize, norBlockSize, norSectorSize}; fmt.Sprint(chip.erases) != fmt.Sprint(want) {
+		t.Errorf("erases %v, want %v", chip.erases, want)
+	}
+
+	data := make([]byte, 600)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	chip.mem[0x20000] = 0 // programmed before the erase
+	if err := f.Erase(ctx, 0x20000, norSectorSize); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Program(ctx, data, 0x20080); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{128, 256, 216}; fmt.Sprint(chip.programs) != fmt.Sprint(want) {
+		t.Errorf("page programs %v, want %v", chip.programs, want)
+	}
+	got := make([]byte, len(data))
+	if err := f.ReadAt(ctx, got, 0x20080); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) || chip.mem[0x20000] != 0xFF {
+		t.Error("read back differs")
+	}
+
+	if err := f.Erase(ctx, 0x100, norSectorSize); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("unaligned erase: got %v", err)
+	}
+	chip.protect = true
+	if err := f.Program(ctx, data, 0); !errors.Is(err, ErrPermission) {
+		t.Errorf("protected program: got %v", err)
+	}
+}
+
+func TestUpdateFlash(t *testing.T) {
+	ctx := context.Background()
+	chip := newFakeNOR()
+	f, err := NewSPINOR(ctx, chip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chip.mem[0x1000] = 0x42 // outside the image, in its first block
+	image := bytes.Repeat([]byte{0xA5}, 2*norSectorSize)
+	written, skipped, err := UpdateFlash(ctx, f, image, 0x1800)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != 3 || skipped != 0 {
+		t.Errorf("first update: %d written, %d skipped", written, skipped)
+	}
+	if chip.mem[0x1000] != 0x42 || !bytes.Equal(chip.mem[0x1800:0x3800], image) || chip.mem[0x3800] != 0xFF {
+		t.Error("memory differs from the image")
+	}
+
+	image[norSectorSize] = 0 // in the second block only
+	if written, skipped, err = UpdateFlash(ctx, f, image, 0x1800); err != nil || written != 1 || skipped != 2 {
+		t.Errorf("second update: %d written, %d skipped, %v", written, skipped, err)
+	}
+}
@@ -0,0 +1,224 @@
+package hal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const sysfsGPIORoot = "/sys/class/gpio"
+
+func init() {
+	RegisterGPIODriver(GPIODriver{
+		Name:     "sysfs",
+		Priority: 20,
+		Probe: func(cfg GPIOConfig) error {
+			if err := syscall.Access(sysfsGPIORoot+"/export", accessWrite); err != nil {
+				return opError("sysfs access "+sysfsGPIORoot+"/export", err)
+			}
+			return nil
+		},
+		Open: func(cfg GPIOConfig) (GPIOController, error) {
+			return NewSysfsGPIO()
+		},
+	})
+}
+
+// SysfsGPIO implements GPIO using the legacy Linux sysfs interface
+type SysfsGPIO struct {
+	mu       sync.Mutex
+	exported map[int]bool
+}
+
+// NewSysfsGPIO creates a sysfs GPIO controller
+func NewSysfsGPIO() (*SysfsGPIO, error) {
+	if _, err := os.Stat(sysfsGPIORoot + "/export"); err != nil {
+		return nil, &Error{Op: "sysfs open", Kind: ErrNotSupported, Err: err}
+	}
+	return &SysfsGPIO{exported: make(map[int]bool)}, nil
+}
+
+func (g *SysfsGPIO) export(pin int) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.exported[pin] {
+		return nil
+	}
+	if _, err := os.Stat(fmt.Sprintf("%s/gpio%d", sysfsGPIORoot, pin)); errors.Is(err, os.ErrNotExist) {
+		if err := writeFile(sysfsGPIORoot+"/export", strconv.Itoa(pin)); err != nil {
+			return opError(fmt.Sprintf("sysfs export GPIO%d", pin), err)
+		}
+	}
+	g.exported[pin] = true
+	return nil
+}
+
+// SetMode exports the pin if needed and sets its direction
+func (g *SysfsGPIO) SetMode(pin int, mode PinMode) error {
+	if err := g.export(pin); err != nil {
+		return err
+	}
+	err := writeFile(fmt.Sprintf("%s/gpio%d/direction", sysfsGPIORoot, pin), mode.String())
+	return opError(fmt.Sprintf("sysfs set direction GPIO%d", pin), err)
+}
+
+// Write drives an output pin high or low
+func (g *SysfsGPIO) Write(ctx context.Context, pin int, value bool) error {
+	if err := ctx.Err(); err != nil {
+		return opError(fmt.Sprintf("sysfs write GPIO%d", pin), err)
+	}
+	val := "0"
+	if value {
+		val = "1"
+	}
+	err := writeFile(fmt.Sprintf("%s/gpio%d/value", sysfsGPIORoot, pin), val)
+	return opError(fmt.Sprintf("sysfs write GPIO%d", pin), err)
+}
+
+// Read returns the current level of a pin
+func (g *SysfsGPIO) Read(ctx context.Context, pin int) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, opError(fmt.Sprintf("sysfs read GPIO%d", pin), err)
+	}
+	data, err := os.ReadFile(fmt.Sprintf("%s/gpio%d/value", sysfsGPIORoot, pin))
+	if err != nil {
+		return false, opError(fmt.Sprintf("sysfs read GPIO%d", pin), err)
+	}
+	return strings.TrimSpace(string(data)) == "1", nil
+}
+
+// WatchEdges configures edge detection on an input pin and waits for value
+// changes with epoll, re-checking ctx at least every pollInterval
+func (g *SysfsGPIO) WatchEdges(ctx context.Context, pin int, edge Edge) (<-chan EdgeEvent, error) {
+	if err := g.SetMode(pin, Input); err != nil {
+		return nil, err
+	}
+	if err := writeFile(fmt.Sprintf("%s/gpio%d/edge", sysfsGPIORoot, pin), edge.String()); err != nil {
+		kind := ErrNotSupported
+		if errors.Is(err, fs.ErrPermission) {
+			kind = ErrPermission
+		}
+		return nil, &Error{Op: fmt.Sprintf("sysfs set edge GPIO%d", pin), Kind: kind, Err: err}
+	}
+
+	op := fmt.Sprintf("sysfs watch GPIO%d", pin)
+	fd, err := syscall.Open(fmt.Sprintf("%s/gpio%d/value", sysfsGPIORoot, pin), syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, opError(op, err)
+	}
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		syscall.Close(fd)
+		return nil, opError(op, err)
+	}
+	ev := syscall.EpollEvent{Events: syscall.EPOLLPRI | syscall.EPOLLERR, Fd: int32(fd)}
+	if err := syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, fd, &ev); err != nil {
+		syscall.Close(fd)
+		syscall.Close(epfd)
+		return nil, opError(op, err)
+	}
+
+	events := make(chan EdgeEvent, 16)
+	go func() {
+		defer close(events)
+		defer syscall.Close(fd)
+		defer syscall.Close(epfd)
+
+		buf := make([]byte, 2)
+		ready := make([]syscall.EpollEvent, 1)
+		// The first read clears the initial "changed" state
+		syscall.Pread(fd, buf, 0)
+		for ctx.Err() == nil {
+			n, err := syscall.EpollWait(epfd, ready, int(pollInterval/time.Millisecond))
+			if err != nil && err != syscall.EINTR {
+				return
+			}
+			if n == 0 {
+				continue
+			}
+			if _, err := syscall.Pread(fd, buf, 0); err != nil {
+				return
+			}
+			select {
+			case events <- EdgeEvent{Pin: pin, Rising: buf[0] == '1', Time: time.Now()}:
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return events, nil
+}
+
+// LineStates returns the direction and level of every exported pin
+func (g *SysfsGPIO) LineStates(ctx context.Context) ([]LineState, error) {
+	g.mu.Lock()
+	pins := make([]int, 0, len(g.exported))
+	for pin := range g.exported {
+		pins = append(pins, pin)
+	}
+	g.mu.Unlock()
+
+	states := make([]LineState, 0, len(pins))
+	for _, pin := range pins {
+		dir, err := os.ReadFile(fmt.Sprintf("%s/gpio%d/direction", sysfsGPIORoot, pin))
+		if err != nil {
+			return nil, opError(fmt.Sprintf("sysfs read direction GPIO%d", pin), err)
+		}
+		s := LineState{Pin: pin, Mode: Input}
+		if strings.TrimSpace(string(dir)) == "out" {
+			s.Mode = Output
+		}
+		if s.Value, err = g.Read(ctx, pin); err != nil {
+			return nil, err
+		}
+		states = append(states, s)
+	}
+	return states, nil
+}
+
+// SetLineState exports and configures a pin. Outputs are switched with
+// "high" or "low" as the direction, so they start at s.Value. sysfs has no
+// bias control.
+func (g *SysfsGPIO) SetLineState(ctx context.Context, s LineState) error {
+	op := fmt.Sprintf("sysfs set direction GPIO%d", s.Pin)
+	if err := ctx.Err(); err != nil {
+		return opError(op, err)
+	}
+	if s.Bias != BiasDefault {
+		return &Error{Op: op, Kind: ErrNotSupported, Err: fmt.Errorf("sysfs can't set %s bias", s.Bias)}
+	}
+	if err := g.export(s.Pin); err != nil {
+		return err
+	}
+	dir := "in"
+	if s.Mode == Output {
+		dir = "low"
+		if s.Value {
+			dir = "high"
+		}
+	}
+	return opError(op, writeFile(fmt.Sprintf("%s/gpio%d/direction", sysfsGPIORoot, s.Pin), dir))
+}
+
+// pollInterval bounds how long blocking sysfs waits go without checking ctx
+const pollInterval = 100 * time.Millisecond
+
+// Close unexports every pin exported by this controller
+func (g *SysfsGPIO) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var errs []error
+	for pin := range g.exported {
+		if err := writeFile(sysfsGPIORoot+"/unexport", strconv.Itoa(pin)); err != nil {
+			errs = append(errs, fmt.Errorf("unexport GPIO%d: %w", pin, err))
+		}
+		delete(g.exported, pin)
+	}
+	return errors.Join(errs...)
+}
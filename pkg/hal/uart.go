@@ -0,0 +1,30 @@
+package hal
+
+import (
+	"os"
+	"time"
+)
+
+// UART is a serial port in raw mode: 8 data bits, no parity, one stop
+// bit, no flow control, and no translation of line endings or control
+// characters
+type UART struct {
+	f    *os.File
+	path string
+}
+
+// Read reads what has arrived, waiting for at least one byte
+func (u *UART) Read(p []byte) (int, error) { return u.f.Read(p) }
+
+// Write writes p to the line
+func (u *UART) Write(p []byte) (int, error) { return u.f.Write(p) }
+
+// SetReadDeadline makes pending and future reads fail with
+// os.ErrDeadlineExceeded after t; the zero time waits forever
+func (u *UART) SetReadDeadline(t time.Time) error { return u.f.SetReadDeadline(t) }
+
+// Path returns the device path
+func (u *UART) Path() string { return u.path }
+
+// Close closes the port
+func (u *UART) Close() error { return u.f.Close() }
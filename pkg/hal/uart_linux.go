@@ -0,0 +1,64 @@
+package hal
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// termios bits missing from package syscall (asm-generic)
+const (
+	termCBAUD   = 0010017
+	termCRTSCTS = 020000000000
+)
+
+var uartBauds = map[int]uint32{
+	9600: syscall.B9600, 19200: syscall.B19200, 38400: syscall.B38400,
+	57600: syscall.B57600, 115200: syscall.B115200, 230400: syscall.B230400,
+	460800: syscall.B460800, 921600: syscall.B921600, 1500000: syscall.B1500000,
+}
+
+// OpenUART opens a serial device such as /dev/ttyUSB0, /dev/ttyS0 or the
+// USB gadget port /dev/ttyGS0 at baud. Reads honour SetReadDeadline.
+func OpenUART(path string, baud int) (*UART, error) {
+	speed, ok := uartBauds[baud]
+	if !ok {
+		return nil, &Error{Op: "uart", Kind: ErrNotSupported, Err: fmt.Errorf("unsupported baud rate %d", baud)}
+	}
+	// Non-blocking, so the runtime poller can apply deadlines; and not as
+	// a controlling terminal, so the line's hangups don't signal us
+	f, err := os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, &Error{Op: "uart", Kind: classify(err), Err: err}
+	}
+	raw, err := f.SyscallConn()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	var ioErr error
+	err = raw.Control(func(fd uintptr) {
+		var t syscall.Termios
+		if ioErr = ioctl(fd, syscall.TCGETS, uintptr(unsafe.Pointer(&t))); ioErr != nil {
+			return
+		}
+		t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+			syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON | syscall.IXOFF
+		t.Oflag &^= syscall.OPOST
+		t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+		t.Cflag &^= syscall.CSIZE | syscall.PARENB | syscall.CSTOPB | termCBAUD | termCRTSCTS
+		t.Cflag |= syscall.CS8 | syscall.CREAD | syscall.CLOCAL | speed
+		t.Ispeed, t.Ospeed = speed, speed
+		t.Cc[syscall.VMIN], t.Cc[syscall.VTIME] = 1, 0
+		ioErr = ioctl(fd, syscall.TCSETS, uintptr(unsafe.Pointer(&t)))
+	})
+	if err == nil {
+		err = ioErr
+	}
+	if err != nil {
+		f.Close()
+		return nil, &Error{Op: "uart", Kind: classify(err), Err: fmt.Errorf("%s: %w", path, err)}
+	}
+	return &UART{f: f, path: path}, nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package hal
+
+import "errors"
+
+// OpenUART is only implemented on Linux
+func OpenUART(path string, baud int) (*UART, error) {
+	return nil, &Error{Op: "uart", Kind: ErrNotSupported, Err: errors.New(path + ": serial ports are only supported on Linux")}
+}
@@ -0,0 +1,95 @@
+// Package health aggregates named health checks and serves them over HTTP
+// for supervisors, load balancers and fleet tooling.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CheckFunc reports a component's health; a nil error means healthy
+type CheckFunc func(ctx context.Context) error
+
+// Checker holds the registered health checks
+type Checker struct {
+	mu      sync.RWMutex
+	checks  map[string]CheckFunc
+	timeout time.Duration
+}
+
+// Report is the result of running all checks
+type Report struct {
+	Status    string            `json:"status"`
+	Checks    map[string]string `json:"checks"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// Healthy reports whether every check passed
+func (r Report) Healthy() bool { return r.Status == "ok" }
+
+// New creates an empty Checker
+func New() *Checker {
+	return &Checker{
+		checks:  make(map[string]CheckFunc),
+		timeout: 2 * time.Second,
+	}
+}
+
+// Register adds or replaces a named check
+func (c *Checker) Register(name string, fn CheckFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks[name] = fn
+}
+
+// Names returns the registered check names in sorted order
+func (c *Checker) Names() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	names := make([]string, 0, len(c.checks))
+	for name := range c.checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Check runs every registered check with a bounded timeout
+func (c *Checker) Check(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	c.mu.RLock()
+	checks := make(map[string]CheckFunc, len(c.checks))
+	for name, fn := range c.checks {
+		checks[name] = fn
+	}
+	c.mu.RUnlock()
+
+	report := Report{Status: "ok", Checks: make(map[string]string), Timestamp: time.Now()}
+	for name, fn := range checks {
+		if err := fn(ctx); err != nil {
+			report.Status = "unhealthy"
+			report.Checks[name] = err.Error()
+		} else {
+			report.Checks[name] = "ok"
+		}
+	}
+	return report
+}
+
+// Handler serves the report as JSON, answering 503 when unhealthy
+func (c *Checker) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := c.Check(r.Context())
+		w.Header().Set("Content-Type", "application/json")
+		if !report.Healthy() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	})
+}
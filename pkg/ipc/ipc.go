@@ -0,0 +1,258 @@
+// Package ipc streams values from one process to others on the same board
+// over a Unix domain socket, so that the sensor agent, a display and a
+// network hub can run as separate processes. Values are gob-encoded: a
+// type is described once per connection, after which each value costs
+// only its fields in binary (a three-channel reading takes about 120
+// bytes, against 300 as JSON) and nothing is parsed as text. Access is
+// governed by the socket's file permissions.
+package ipc
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Version is the protocol version sent in every Hello
+const Version = 1
+
+// DefaultBuffer is how many values are queued for a subscriber before it
+// starts missing them
+const DefaultBuffer = 64
+
+// writeTimeout is how long a subscriber may take to accept a batch of
+// values before it is disconnected
+const writeTimeout = 5 * time.Second
+
+// Hello opens every stream, so that a subscriber can tell it is reading
+// what it expects
+type Hello struct {
+	Version int
+	Kind    string // what the stream carries, e.g. "readings"
+	Source  string // the publisher, e.g. the agent's namespace path
+}
+
+// Publisher sends values to every process subscribed on its socket. A
+// subscriber that falls behind misses values rather than holding up the
+// publisher.
+type Publisher struct {
+	path    string
+	hello   Hello
+	buffer  int
+	ln      net.Listener
+	dropped atomic.Uint64
+
+	mu     sync.Mutex
+	subs   map[*subscriber]struct{}
+	closed bool
+}
+
+type subscriber struct {
+	conn  net.Conn
+	queue chan any
+}
+
+// Listen creates the socket at path, replacing one left behind by a
+// publisher that is no longer running, and accepts subscribers; each is
+// sent hello, then the values published. buffer is the values queued per
+// subscriber, 0 for DefaultBuffer.
+func Listen(path string, hello Hello, buffer int) (*Publisher, error) {
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+	hello.Version = Version
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another process", path)
+		}
+		os.Remove(path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// Owner and group only, like the files the values would otherwise go to
+	if err := os.Chmod(path, 0660); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	p := &Publisher{path: path, hello: hello, buffer: buffer, ln: ln, subs: make(map[*subscriber]struct{})}
+	go p.accept()
+	return p, nil
+}
+
+func (p *Publisher) accept() {
+	for {
+		conn, err := p.ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			log.Printf("❌ IPC socket %s: %v", p.path, err)
+			time.Sleep(time.Second)
+			continue
+		}
+		s := &subscriber{conn: conn, queue: make(chan any, p.buffer)}
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			conn.Close()
+			return
+		}
+		p.subs[s] = struct{}{}
+		p.mu.Unlock()
+		go p.serve(s)
+	}
+}
+
+// serve writes values to a subscriber until it goes away or the publisher
+// closes, flushing whenever the queue runs dry
+func (p *Publisher) serve(s *subscriber) {
+	defer p.remove(s)
+	w := bufio.NewWriter(s.conn)
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(p.hello); err != nil {
+		return
+	}
+	// Subscribers never send anything: reading tells when one hangs up
+	gone := make(chan struct{})
+	go func() {
+		var b [1]byte
+		s.conn.Read(b[:])
+		close(gone)
+	}()
+	for {
+		if w.Buffered() > 0 {
+			s.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+		select {
+		case v, ok := <-s.queue:
+			if !ok {
+				return
+			}
+			if err := enc.Encode(v); err != nil {
+				log.Printf("❌ IPC socket %s: %v", p.path, err)
+				return
+			}
+			// Encode the rest of a burst before flushing
+			for len(s.queue) > 0 {
+				if v, ok = <-s.queue; !ok {
+					w.Flush()
+					return
+				}
+				if err := enc.Encode(v); err != nil {
+					return
+				}
+			}
+		case <-gone:
+			return
+		}
+	}
+}
+
+func (p *Publisher) remove(s *subscriber) {
+	s.conn.Close()
+	p.mu.Lock()
+	delete(p.subs, s)
+	p.mu.Unlock()
+}
+
+// Publish queues v for every subscriber, dropping it for any whose queue
+// is full. All values published should be of the same type.
+func (p *Publisher) Publish(v any) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	for s := range p.subs {
+		select {
+		case s.queue <- v:
+		default:
+			p.dropped.Add(1)
+		}
+	}
+}
+
+// Subscribers returns the number of processes subscribed
+func (p *Publisher) Subscribers() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.subs)
+}
+
+// Dropped returns the number of values subscribers missed by falling
+// behind
+func (p *Publisher) Dropped() uint64 { return p.dropped.Load() }
+
+// Close stops accepting subscribers, sends those connected what is
+// already queued, and removes the socket
+func (p *Publisher) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	for s := range p.subs {
+		close(s.queue)
+	}
+	p.mu.Unlock()
+	err := p.ln.Close()
+	os.Remove(p.path)
+	return err
+}
+
+// Subscriber receives the values a Publisher sends
+type Subscriber struct {
+	Hello Hello
+	conn  net.Conn
+	dec   *gob.Decoder
+}
+
+// Dial subscribes to the publisher on path, checking that it publishes
+// kind
+func Dial(path, kind string) (*Subscriber, error) {
+	conn, err := net.DialTimeout("unix", path, 2*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	s := &Subscriber{conn: conn, dec: gob.NewDecoder(bufio.NewReader(conn))}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := s.dec.Decode(&s.Hello); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("%s: no hello: %w", path, err)
+	}
+	conn.SetReadDeadline(time.Time{})
+	if s.Hello.Version != Version {
+		conn.Close()
+		return nil, fmt.Errorf("%s: protocol version %d, want %d", path, s.Hello.Version, Version)
+	}
+	if s.Hello.Kind != kind {
+		conn.Close()
+		return nil, fmt.Errorf("%s publishes %q, not %q", path, s.Hello.Kind, kind)
+	}
+	return s, nil
+}
+
+// Receive waits for the next value and decodes it into v, a pointer to the
+// type published. It returns io.EOF once the publisher closes.
+func (s *Subscriber) Receive(v any) error { return s.dec.Decode(v) }
+
+// Close unsubscribes
+func (s *Subscriber) Close() error { return s.conn.Close() }
@@ -0,0 +1,191 @@
+package ipc
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type reading struct {
+	Time   time.Time
+	Name   string
+	Value  float64
+	Counts []int
+}
+
+func waitSubscribers(t *testing.T, p *Publisher, n int) {
+	t.Helper()
+	for i := 0; p.Subscribers() != n; i++ {
+		if i == 200 {
+			t.Fatalf("%d subscribers, want %d", p.Subscribers(), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run", "readings.sock")
+	p, err := Listen(path, Hello{Kind: "readings", Source: "site/board"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	subs := make([]*Subscriber, 2)
+	for i := range subs {
+		if subs[i], err = Dial(path, "readings"); err != nil {
+			t.Fatal(err)
+		}
+		defer subs[i].Close()
+		if subs[i].Hello.Source != "site/board" || subs[i].Hello.Version != Version {
+			t.Errorf("hello = %+v", subs[i].Hello)
+		}
+	}
+	waitSubscribers(t, p, 2)
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		p.Publish(reading{Time: now.Add(time.Duration(i) * time.Second), Name: "temperature", Value: 20 + float64(i), Counts: []int{i}})
+	}
+	p.Publish(reading{Time: now, Name: "light", Value: math.NaN()})
+	for _, s := range subs {
+		for i := 0; i < 3; i++ {
+			var r reading
+			if err := s.Receive(&r); err != nil {
+				t.Fatal(err)
+			}
+			if !r.Time.Equal(now.Add(time.Duration(i)*time.Second)) || r.Value != 20+float64(i) || len(r.Counts) != 1 || r.Counts[0] != i {
+				t.Errorf("reading %d = %+v", i, r)
+			}
+		}
+		var r reading
+		if err := s.Receive(&r); err != nil || !math.IsNaN(r.Value) {
+			t.Errorf("NaN reading = %+v, %v", r, err)
+		}
+	}
+
+	// A subscriber hanging up is forgotten
+	subs[1].Close()
+	waitSubscribers(t, p, 1)
+
+	// Closing the publisher ends the stream and removes the socket
+	p.Close()
+	var r reading
+	if err := subs[0].Receive(&r); !errors.Is(err, io.EOF) {
+		t.Errorf("after Close: %v, want EOF", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("socket left behind: %v", err)
+	}
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "readings.sock")
+	p, err := Listen(path, Hello{Kind: "readings"}, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	s, err := Dial(path, "readings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	waitSubscribers(t, p, 1)
+
+	// Far more than the socket and queue hold, with nobody reading
+	big := reading{Name: string(bytes.Repeat([]byte("x"), 4096))}
+	for i := 0; i < 1000; i++ {
+		p.Publish(big)
+	}
+	if p.Dropped() == 0 {
+		t.Error("nothing dropped for a subscriber that doesn't read")
+	}
+	var r reading
+	if err := s.Receive(&r); err != nil || r.Name != big.Name {
+		t.Errorf("after drops: %v", err)
+	}
+}
+
+func TestListenStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "readings.sock")
+	// A socket file nobody listens on, as a crashed publisher leaves
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+
+	p, err := Listen(path, Hello{Kind: "readings"}, 0)
+	if err != nil {
+		t.Fatalf("stale socket: %v", err)
+	}
+	defer p.Close()
+	if _, err := Listen(path, Hello{Kind: "readings"}, 0); err == nil {
+		t.Error("second publisher on a socket in use")
+	}
+	if _, err := Dial(path, "events"); err == nil {
+		t.Error("Dial accepted the wrong kind of stream")
+	}
+}
+
+// TestCompact checks that what a Publisher writes to the socket is
+// smaller than the same values as JSON lines, which is the point of gob
+func TestCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "readings.sock")
+	p, err := Listen(path, Hello{Kind: "readings", Source: "site/board"}, 128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	// A raw connection, to count the bytes rather than decode them
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	waitSubscribers(t, p, 1)
+
+	var jsonBuf bytes.Buffer
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 100; i++ {
+		r := reading{Time: now.Add(time.Duration(i) * 100 * time.Millisecond), Name: "pressure", Value: 101.325 + float64(i)/1000, Counts: []int{2000 + i}}
+		p.Publish(r)
+		json.NewEncoder(&jsonBuf).Encode(r)
+	}
+	p.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	sent, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Dropped() != 0 {
+		t.Fatalf("%d values dropped", p.Dropped())
+	}
+
+	// Everything sent decodes back to the values published
+	dec := gob.NewDecoder(bytes.NewReader(sent))
+	var hello Hello
+	if err := dec.Decode(&hello); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		var r reading
+		if err := dec.Decode(&r); err != nil || r.Counts[0] != 2000+i {
+			t.Fatalf("value %d = %+v, %v", i, r, err)
+		}
+	}
+	// Hello and the type description included, well under the JSON
+	if len(sent)*3 > jsonBuf.Len()*2 {
+		t.Errorf("%d bytes on the socket, %d as JSON", len(sent), jsonBuf.Len())
+	}
+}
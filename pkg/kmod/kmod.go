@@ -0,0 +1,220 @@
+// Package kmod checks that the running kernel provides the drivers the HAL
+// relies on (GPIO character devices, i2c-dev, spidev, IIO, PWM, ALSA) and
+// says exactly what is missing: a module to load, a kernel option the
+// image was built without, or a controller not enabled in the device tree.
+package kmod
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Requirement is a kernel feature used by the HAL
+type Requirement struct {
+	Feature string   // name used in configuration, e.g. "i2c"
+	What    string   // e.g. "I2C character devices"
+	Options []string // kernel options providing it, any of which will do
+	Module  string   // module providing it when built as one
+	Nodes   []string // glob patterns of the nodes present once it works
+}
+
+// Requirements lists the features, in the order they are reported
+var Requirements = []Requirement{
+	{"gpio", "GPIO character devices", []string{"CONFIG_GPIO_CDEV"}, "", []string{"/dev/gpiochip*"}},
+	{"i2c", "I2C character devices", []string{"CONFIG_I2C_CHARDEV"}, "i2c-dev", []string{"/dev/i2c-*"}},
+	{"spi", "SPI character devices", []string{"CONFIG_SPI_SPIDEV"}, "spidev", []string{"/dev/spidev*"}},
+	{"iio", "Industrial I/O (ADCs)", []string{"CONFIG_IIO"}, "industrialio", []string{"/sys/bus/iio/devices/iio:device*"}},
+	{"pwm", "PWM sysfs interface", []string{"CONFIG_PWM"}, "", []string{"/sys/class/pwm/pwmchip*"}},
+	{"mtd", "MTD flash character devices", []string{"CONFIG_MTD_CHAR"}, "mtdchar", []string{"/dev/mtd[0-9]*"}},
+	{"sound", "ALSA audio capture", []string{"CONFIG_SND_PCM"}, "snd-pcm", []string{"/dev/snd/pcmC*D*c"}},
+	{"usb-gadget", "USB gadget (device) mode", []string{"CONFIG_USB_LIBCOMPOSITE"}, "libcomposite", []string{"/sys/class/udc/*"}},
+}
+
+// Status is the outcome of checking a requirement
+type Status int
+
+const (
+	Present  Status = iota // device nodes exist
+	NoDevice               // the driver is there but no device uses it
+	Loadable               // the module is installed but not loaded
+	Missing                // the kernel lacks the driver
+	Unknown                // the kernel configuration can't be read
+)
+
+func (s Status) String() string {
+	return [...]string{"present", "no device", "loadable", "missing", "unknown"}[s]
+}
+
+// Result is the check of one requirement
+type Result struct {
+	Requirement
+	Status Status
+	Detail string // what was found
+	Fix    string // what to do about it, empty if Present
+}
+
+// Checker inspects a kernel through a root directory, "/" for the running
+// system
+type Checker struct {
+	Root    string
+	Release string // kernel release, from uname if empty
+
+	config  map[string]string
+	modules map[string]bool // installed modules, by name with underscores
+	builtin map[string]bool
+}
+
+// Check checks the named features, or all of them if none are named;
+// unknown names are ignored
+func Check(features ...string) []Result {
+	c := &Checker{Root: "/"}
+	return c.Check(features...)
+}
+
+// Check checks the named features, or all of them if none are named
+func (c *Checker) Check(features ...string) []Result {
+	c.load()
+	want := make(map[string]bool, len(features))
+	for _, f := range features {
+		want[f] = true
+	}
+	var results []Result
+	for _, req := range Requirements {
+		if len(features) > 0 && !want[req.Feature] {
+			continue
+		}
+		results = append(results, c.check(req))
+	}
+	return results
+}
+
+func (c *Checker) path(p string) string { return filepath.Join(c.Root, p) }
+
+// load reads the kernel configuration and module lists
+func (c *Checker) load() {
+	if c.Release == "" {
+		c.Release = kernelRelease()
+	}
+	c.config = readConfig(c.path("/proc/config.gz"), true)
+	if c.config == nil {
+		c.config = readConfig(c.path("/boot/config-"+c.Release), false)
+	}
+	modDir := c.path("/lib/modules/" + c.Release)
+	c.modules = readModuleList(filepath.Join(modDir, "modules.dep"))
+	c.builtin = readModuleList(filepath.Join(modDir, "modules.builtin"))
+}
+
+// readConfig parses a kernel .config, returning nil if it can't be read
+func readConfig(path string, gzipped bool) map[string]string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if gzipped {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil
+		}
+		r = gz
+	}
+	config := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if k, v, ok := strings.Cut(scanner.Text(), "="); ok && strings.HasPrefix(k, "CONFIG_") {
+			config[k] = v
+		}
+	}
+	if scanner.Err() != nil {
+		return nil
+	}
+	return config
+}
+
+// readModuleList reads the module names of modules.dep or
+// modules.builtin, returning nil if it can't be read
+func readModuleList(path string) map[string]bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	mods := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		file, _, _ := strings.Cut(scanner.Text(), ":")
+		name := filepath.Base(file)
+		if i := strings.Index(name, ".ko"); i > 0 {
+			mods[moduleName(name[:i])] = true
+		}
+	}
+	return mods
+}
+
+// moduleName normalises a module name as the kernel does, to underscores
+func moduleName(name string) string { return strings.ReplaceAll(name, "-", "_") }
+
+func (c *Checker) check(req Requirement) Result {
+	res := Result{Requirement: req}
+	for _, pattern := range req.Nodes {
+		if matches, _ := filepath.Glob(c.path(pattern)); len(matches) > 0 {
+			res.Status = Present
+			res.Detail = strings.TrimPrefix(matches[0], strings.TrimSuffix(c.Root, "/"))
+			if len(matches) > 1 {
+				res.Detail += " and others"
+			}
+			return res
+		}
+	}
+
+	noDevice := func(how string) Result {
+		res.Status = NoDevice
+		res.Detail = how + ", but no device uses it"
+		res.Fix = "enable the controller in the device tree (riscv-dev overlay) or connect the device"
+		return res
+	}
+	loaded := req.Module != "" && exists(c.path("/sys/module/"+moduleName(req.Module)))
+	value := ""
+	for _, opt := range req.Options {
+		if v := c.config[opt]; v == "y" || v == "m" {
+			value = v
+			break
+		}
+	}
+	switch {
+	case loaded:
+		return noDevice("module " + req.Module + " is loaded")
+	case value == "y" || (req.Module != "" && c.builtin[moduleName(req.Module)]):
+		return noDevice("built into the kernel")
+	case value == "m" || (req.Module != "" && c.modules[moduleName(req.Module)]):
+		if req.Module != "" && c.modules != nil && !c.modules[moduleName(req.Module)] {
+			res.Status = Missing
+			res.Detail = req.Options[0] + "=m, but module " + req.Module + " is not installed"
+			res.Fix = "install the kernel modules package matching " + c.Release
+			return res
+		}
+		res.Status = Loadable
+		res.Detail = "module " + req.Module + " is not loaded"
+		res.Fix = "modprobe " + req.Module + " && echo " + req.Module + " >> /etc/modules-load.d/riscv-dev.conf"
+		return res
+	case c.config != nil:
+		res.Status = Missing
+		res.Detail = "kernel " + c.Release + " built without " + strings.Join(req.Options, " or ")
+		res.Fix = "rebuild the kernel with " + req.Options[0] + " (Buildroot: make linux-menuconfig)"
+		return res
+	}
+	res.Status = Unknown
+	res.Detail = "no nodes, and the kernel configuration is unreadable"
+	res.Fix = "check for " + req.Options[0] + " in the kernel's .config (CONFIG_IKCONFIG_PROC provides /proc/config.gz)"
+	return res
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,22 @@
+package kmod
+
+import (
+	"strings"
+	"syscall"
+)
+
+// kernelRelease returns the running kernel's release, as uname -r prints it
+func kernelRelease() string {
+	var u syscall.Utsname
+	if err := syscall.Uname(&u); err != nil {
+		return ""
+	}
+	var b strings.Builder
+	for _, ch := range u.Release {
+		if ch == 0 {
+			break
+		}
+		b.WriteByte(byte(ch))
+	}
+	return b.String()
+}
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package kmod
+
+// kernelRelease is empty off Linux, where there are no kernel modules to
+// check
+func kernelRelease() string { return "" }
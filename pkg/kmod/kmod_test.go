@@ -0,0 +1,48 @@
+package kmod
+
+import (
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	root := t.TempDir()
+	write := func(path, content string) {
+		path = filepath.Join(root, path)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.MkdirAll(filepath.Join(root, "proc"), 0755)
+	f, _ := os.Create(filepath.Join(root, "proc/config.gz"))
+	gz := gzip.NewWriter(f)
+	gz.Write([]byte("CONFIG_GPIO_CDEV=y\nCONFIG_I2C_CHARDEV=m\n# CONFIG_SPI_SPIDEV is not set\nCONFIG_IIO=m\nCONFIG_PWM=y\nCONFIG_MTD_CHAR=m\n"))
+	gz.Close()
+	f.Close()
+	write("dev/gpiochip0", "")
+	write("sys/module/industrialio/refcnt", "")
+	write("lib/modules/6.1.0/modules.dep", "kernel/drivers/i2c/i2c-dev.ko.xz:\nkernel/drivers/iio/industrialio.ko: \n")
+
+	c := &Checker{Root: root, Release: "6.1.0"}
+	want := map[string]Status{
+		"gpio":       Present,
+		"i2c":        Loadable,
+		"spi":        Missing,
+		"iio":        NoDevice, // loaded, no device
+		"pwm":        NoDevice, // built in, no chip
+		"mtd":        Missing,  // =m but not installed
+		"sound":      Missing,
+		"usb-gadget": Missing,
+	}
+	for _, res := range c.Check() {
+		if res.Status != want[res.Feature] {
+			t.Errorf("%s: %v (%s), want %v", res.Feature, res.Status, res.Detail, want[res.Feature])
+		}
+	}
+	if res := c.Check("i2c"); len(res) != 1 || res[0].Fix == "" {
+		t.Errorf("i2c only: %+v", res)
+	}
+}
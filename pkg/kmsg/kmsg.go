@@ -0,0 +1,188 @@
+// Package kmsg follows the kernel log through /dev/kmsg and picks out the
+// messages that matter to hardware, such as I2C timeouts, thermal trips
+// and under-voltage warnings, by classifying them with rules.
+package kmsg
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Kernel log levels
+const (
+	LevelEmerg = iota
+	LevelAlert
+	LevelCrit
+	LevelErr
+	LevelWarning
+	LevelNotice
+	LevelInfo
+	LevelDebug
+)
+
+var levelNames = [...]string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// LevelName returns the syslog name of a level, e.g. "warning"
+func LevelName(level int) string {
+	if level < 0 || level >= len(levelNames) {
+		return strconv.Itoa(level)
+	}
+	return levelNames[level]
+}
+
+// Record is one kernel log message
+type Record struct {
+	Level   int
+	Seq     uint64
+	Uptime  time.Duration // since boot
+	Message string
+	// Subsystem and Device come from the record's dictionary, when the
+	// driver logged through dev_printk
+	Subsystem string
+	Device    string
+}
+
+// Parse decodes a /dev/kmsg record:
+//
+//	3,1234,5678901,-;i2c_designware 10030000.i2c: controller timed out
+//	 SUBSYSTEM=platform
+//	 DEVICE=+platform:10030000.i2c
+func Parse(data []byte) (Record, error) {
+	var r Record
+	head, rest, ok := strings.Cut(string(data), ";")
+	if !ok {
+		return r, errors.New("kmsg: record without a header")
+	}
+	fields := strings.Split(head, ",")
+	if len(fields) < 3 {
+		return r, fmt.Errorf("kmsg: malformed header %q", head)
+	}
+	prio, err1 := strconv.Atoi(fields[0])
+	seq, err2 := strconv.ParseUint(fields[1], 10, 64)
+	usec, err3 := strconv.ParseInt(fields[2], 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return r, fmt.Errorf("kmsg: malformed header %q", head)
+	}
+	r.Level, r.Seq, r.Uptime = prio&7, seq, time.Duration(usec)*time.Microsecond
+
+	lines := strings.Split(strings.TrimRight(rest, "\n"), "\n")
+	r.Message = unescape(lines[0])
+	for _, line := range lines[1:] {
+		k, v, ok := strings.Cut(strings.TrimPrefix(line, " "), "=")
+		if !ok {
+			continue
+		}
+		switch k {
+		case "SUBSYSTEM":
+			r.Subsystem = v
+		case "DEVICE":
+			// +subsystem:name, c/b major:minor, n ifindex
+			if i := strings.LastIndexByte(v, ':'); i >= 0 && strings.HasPrefix(v, "+") {
+				v = v[i+1:]
+			}
+			r.Device = v
+		}
+	}
+	return r, nil
+}
+
+// unescape decodes the \xNN escapes the kernel uses for non-printable
+// bytes
+func unescape(s string) string {
+	if !strings.Contains(s, `\x`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && s[i+1] == 'x' {
+			if v, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// Rule classifies messages matching a pattern, at or above a level
+type Rule struct {
+	Class    string
+	Match    *regexp.Regexp
+	MaxLevel int // least severe level matched, e.g. LevelWarning
+}
+
+// DefaultRules pick out the hardware trouble the agent cares about
+var DefaultRules = []Rule{
+	{"i2c", regexp.MustCompile(`(?i)i2c.*(tim(ed|e)\s*out|timeout|arbitration lost|bus (busy|recovery)|nack)`), LevelInfo},
+	{"spi", regexp.MustCompile(`(?i)spi.*(tim(ed|e)\s*out|timeout)`), LevelWarning},
+	{"thermal", regexp.MustCompile(`(?i)(thermal|temperature).*(critical|trip|throttl|shutdown|above threshold)|(critical|trip|throttl).*(thermal|temperature)`), LevelWarning},
+	{"power", regexp.MustCompile(`(?i)under-?voltage|brown-?out|over-?current`), LevelInfo},
+	{"storage", regexp.MustCompile(`(?i)(mmc\d+|mmcblk\d+|sd[a-z]).*(error|timeout|timed out)|I/O error, dev (mmcblk|sd)|EXT4-fs error|Remounting filesystem read-only`), LevelErr},
+	{"memory", regexp.MustCompile(`(?i)out of memory: kill|oom-kill`), LevelErr},
+}
+
+// Classify returns the class of the first rule matching r
+func Classify(rules []Rule, r Record) (string, bool) {
+	for _, rule := range rules {
+		if r.Level <= rule.MaxLevel && rule.Match.MatchString(r.Message) {
+			return rule.Class, true
+		}
+	}
+	return "", false
+}
+
+// Reader reads records from /dev/kmsg. Close interrupts a blocked Read.
+type Reader struct {
+	f   *os.File
+	buf []byte
+}
+
+// Open opens the kernel log, positioned after the messages already logged
+// unless fromStart is set
+func Open(path string, fromStart bool) (*Reader, error) {
+	if path == "" {
+		path = "/dev/kmsg"
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fromStart {
+		if _, err := f.Seek(0, io.SeekEnd); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return &Reader{f: f, buf: make([]byte, 8192)}, nil
+}
+
+// Read returns the next record, blocking until one is logged. Records
+// overwritten in the kernel's buffer before they were read are skipped.
+func (r *Reader) Read() (Record, error) {
+	for {
+		n, err := r.f.Read(r.buf)
+		if errors.Is(err, syscall.EPIPE) {
+			continue // overrun: the next read resumes at the oldest record
+		}
+		if err != nil {
+			return Record{}, err
+		}
+		rec, err := Parse(r.buf[:n])
+		if err != nil {
+			continue
+		}
+		return rec, nil
+	}
+}
+
+// Close closes the log
+func (r *Reader) Close() error { return r.f.Close() }
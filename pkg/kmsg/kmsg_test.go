@@ -0,0 +1,50 @@
+package kmsg
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	r, err := Parse([]byte("3,1234,5678901,-;i2c_designware 10030000.i2c: controller timed out\\x0a\n SUBSYSTEM=platform\n DEVICE=+platform:10030000.i2c\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Level != LevelErr || r.Seq != 1234 || r.Uptime != 5678901*time.Microsecond {
+		t.Errorf("header: %+v", r)
+	}
+	if r.Message != "i2c_designware 10030000.i2c: controller timed out\n" || r.Subsystem != "platform" || r.Device != "10030000.i2c" {
+		t.Errorf("message %q, subsystem %q, device %q", r.Message, r.Subsystem, r.Device)
+	}
+	// Facility bits above the level
+	if r, _ := Parse([]byte("14,1,0,-;user message")); r.Level != LevelInfo {
+		t.Errorf("level %d, want info", r.Level)
+	}
+	if _, err := Parse([]byte("garbage")); err == nil {
+		t.Error("garbage parsed")
+	}
+}
+
+func TestClassify(t *testing.T) {
+	for _, c := range []struct {
+		level int
+		msg   string
+		class string
+	}{
+		{LevelErr, "i2c_designware 10030000.i2c: controller timed out", "i2c"},
+		{LevelErr, "i2c i2c-1: sendbytes: NACK bailout.", "i2c"},
+		{LevelCrit, "thermal thermal_zone0: critical temperature reached (105 C), shutting down", "thermal"},
+		{LevelWarning, "hwmon hwmon1: Undervoltage detected!", "power"},
+		{LevelErr, "mmc0: Timeout waiting for hardware interrupt.", "storage"},
+		{LevelErr, "I/O error, dev mmcblk0, sector 12345 op 0x1:(WRITE)", "storage"},
+		{LevelCrit, "EXT4-fs (mmcblk0p2): Remounting filesystem read-only", "storage"},
+		{LevelErr, "Out of memory: Killed process 412 (app)", "memory"},
+		{LevelInfo, "mmc0: new high speed SDHC card at address 1234", ""},
+		{LevelDebug, "i2c i2c-1: timeout in debug output", ""},
+	} {
+		class, _ := Classify(DefaultRules, Record{Level: c.level, Message: c.msg})
+		if class != c.class {
+			t.Errorf("%q: class %q, want %q", c.msg, class, c.class)
+		}
+	}
+}
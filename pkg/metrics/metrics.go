@@ -0,0 +1,346 @@
+// Package metrics provides counters, gauges and summaries exposed in the
+// Prometheus text format, without external dependencies.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry holds named metric families
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+	constant [][2]string // labels added to every series, sorted by name
+}
+
+// Default is the registry used by the package-level helpers
+var Default = NewRegistry()
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+type family struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+	fn     func() float64 // set for GaugeFunc and CounterFunc
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	quantiles   map[float64]float64 // summaries only; value is the sum
+	count       uint64
+}
+
+func (r *Registry) register(name, help, typ string, labels []string) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if f, ok := r.families[name]; ok {
+		if f.typ != typ || len(f.labels) != len(labels) {
+			panic(fmt.Sprintf("metrics: %s re-registered with a different type or labels", name))
+		}
+		return f
+	}
+	f := &family{name: name, help: help, typ: typ, labels: labels, series: make(map[string]*series)}
+	r.families[name] = f
+	return f
+}
+
+func (f *family) add(delta float64, set bool, labelValues []string) {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", f.name, len(f.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		f.series[key] = s
+	}
+	if set {
+		s.value = delta
+	} else {
+		s.value += delta
+	}
+}
+
+func (f *family) setSummary(quantiles map[float64]float64, count uint64, sum float64, labelValues []string) {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", f.name, len(f.labels), len(labelValues)))
+	}
+	q := make(map[float64]float64, len(quantiles))
+	for k, v := range quantiles {
+		q[k] = v
+	}
+	key := strings.Join(labelValues, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.series[key] = &series{labelValues: append([]string(nil), labelValues...), value: sum, quantiles: q, count: count}
+}
+
+func (f *family) get(labelValues []string) float64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s, ok := f.series[strings.Join(labelValues, "\xff")]; ok {
+		return s.value
+	}
+	return 0
+}
+
+// SetConstLabels adds labels with fixed values to every series the
+// registry serves, e.g. to tell devices sharing a Prometheus server apart.
+// A family's own label of the same name takes precedence.
+func (r *Registry) SetConstLabels(labels map[string]string) {
+	constant := make([][2]string, 0, len(labels))
+	for name, value := range labels {
+		constant = append(constant, [2]string{name, value})
+	}
+	sort.Slice(constant, func(i, j int) bool { return constant[i][0] < constant[j][0] })
+	r.mu.Lock()
+	r.constant = constant
+	r.mu.Unlock()
+}
+
+// Counter is a monotonically increasing value, optionally split by labels
+type Counter struct{ f *family }
+
+// Counter returns the counter called name, creating it on first use
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	return &Counter{r.register(name, help, "counter", labels)}
+}
+
+// Inc adds one to the series identified by labelValues
+func (c *Counter) Inc(labelValues ...string) { c.f.add(1, false, labelValues) }
+
+// Add adds v (which must not be negative) to the series
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	c.f.add(v, false, labelValues)
+}
+
+// Value returns the current value of the series
+func (c *Counter) Value(labelValues ...string) float64 { return c.f.get(labelValues) }
+
+// Gauge is a value that can go up and down, optionally split by labels
+type Gauge struct{ f *family }
+
+// Gauge returns the gauge called name, creating it on first use
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r.register(name, help, "gauge", labels)}
+}
+
+// Set replaces the value of the series
+func (g *Gauge) Set(v float64, labelValues ...string) { g.f.add(v, true, labelValues) }
+
+// Add changes the value of the series by v
+func (g *Gauge) Add(v float64, labelValues ...string) { g.f.add(v, false, labelValues) }
+
+// Value returns the current value of the series
+func (g *Gauge) Value(labelValues ...string) float64 { return g.f.get(labelValues) }
+
+// Summary is a distribution given as quantiles, a count and a sum,
+// optionally split by labels. Unlike a Prometheus client's summary it
+// doesn't observe values itself: the quantiles are computed elsewhere,
+// e.g. over a sliding window, and set whole.
+type Summary struct{ f *family }
+
+// Summary returns the summary called name, creating it on first use
+func (r *Registry) Summary(name, help string, labels ...string) *Summary {
+	return &Summary{r.register(name, help, "summary", labels)}
+}
+
+// Set replaces the quantiles (by q, e.g. 0.99), count and sum of the series
+func (s *Summary) Set(quantiles map[float64]float64, count uint64, sum float64, labelValues ...string) {
+	s.f.setSummary(quantiles, count, sum, labelValues)
+}
+
+// GaugeFunc registers an unlabelled gauge whose value is read from fn at
+// scrape time
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	f := r.register(name, help, "gauge", nil)
+	f.mu.Lock()
+	f.fn = fn
+	f.mu.Unlock()
+}
+
+// CounterFunc registers an unlabelled counter whose value is read from fn
+// at scrape time, for totals kept elsewhere such as by the runtime
+func (r *Registry) CounterFunc(name, help string, fn func() float64) {
+	f := r.register(name, help, "counter", nil)
+	f.mu.Lock()
+	f.fn = fn
+	f.mu.Unlock()
+}
+
+// NewCounter registers a counter in the Default registry
+func NewCounter(name, help string, labels ...string) *Counter {
+	return Default.Counter(name, help, labels...)
+}
+
+// NewGauge registers a gauge in the Default registry
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return Default.Gauge(name, help, labels...)
+}
+
+// NewSummary registers a summary in the Default registry
+func NewSummary(name, help string, labels ...string) *Summary {
+	return Default.Summary(name, help, labels...)
+}
+
+// WriteText writes all metrics in the Prometheus text exposition format
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	constant := r.constant
+	r.mu.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(bw, constant)
+	}
+	return bw.Flush()
+}
+
+func (f *family) write(w *bufio.Writer, constant [][2]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escape(f.help, false))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.typ)
+
+	var extra [][2]string
+	for _, c := range constant {
+		if !f.hasLabel(c[0]) {
+			extra = append(extra, c)
+		}
+	}
+	if f.fn != nil {
+		w.WriteString(f.name)
+		writeLabels(w, extra, nil, nil)
+		fmt.Fprintf(w, " %s\n", formatValue(f.fn()))
+		return
+	}
+
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := f.series[key]
+		if f.typ == "summary" {
+			f.writeSummary(w, extra, s)
+			continue
+		}
+		w.WriteString(f.name)
+		writeLabels(w, extra, f.labels, s.labelValues)
+		fmt.Fprintf(w, " %s\n", formatValue(s.value))
+	}
+}
+
+// writeSummary writes a summary series: a line per quantile, then the sum
+// and count
+func (f *family) writeSummary(w *bufio.Writer, constant [][2]string, s *series) {
+	qs := make([]float64, 0, len(s.quantiles))
+	for q := range s.quantiles {
+		qs = append(qs, q)
+	}
+	sort.Float64s(qs)
+	names := append(append([]string(nil), f.labels...), "quantile")
+	for _, q := range qs {
+		w.WriteString(f.name)
+		writeLabels(w, constant, names, append(append([]string(nil), s.labelValues...), formatValue(q)))
+		fmt.Fprintf(w, " %s\n", formatValue(s.quantiles[q]))
+	}
+	w.WriteString(f.name + "_sum")
+	writeLabels(w, constant, f.labels, s.labelValues)
+	fmt.Fprintf(w, " %s\n", formatValue(s.value))
+	w.WriteString(f.name + "_count")
+	writeLabels(w, constant, f.labels, s.labelValues)
+	fmt.Fprintf(w, " %d\n", s.count)
+}
+
+func (f *family) hasLabel(name string) bool {
+	for _, l := range f.labels {
+		if l == name {
+			return true
+		}
+	}
+	return false
+}
+
+// writeLabels writes the constant labels followed by the series' own, if
+// there are any
+func writeLabels(w *bufio.Writer, constant [][2]string, names, values []string) {
+	if len(constant)+len(names) == 0 {
+		return
+	}
+	w.WriteByte('{')
+	for i, c := range constant {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		fmt.Fprintf(w, "%s=\"%s\"", c[0], escape(c[1], true))
+	}
+	for i, label := range names {
+		if i > 0 || len(constant) > 0 {
+			w.WriteByte(',')
+		}
+		fmt.Fprintf(w, "%s=\"%s\"", label, escape(values[i], true))
+	}
+	w.WriteByte('}')
+}
+
+// Handler serves the registry for Prometheus scraping
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteText(w)
+	})
+}
+
+// Handler serves the Default registry
+func Handler() http.Handler { return Default.Handler() }
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escape(s string, quotes bool) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	if quotes {
+		s = strings.ReplaceAll(s, `"`, `\"`)
+	}
+	return s
+}
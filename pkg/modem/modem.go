@@ -0,0 +1,350 @@
+// Package modem talks AT commands to a cellular modem over its serial
+// port, to send SMS and to read its signal quality and network
+// registration. USB LTE modems offer several ports; the AT port is
+// usually not the one pppd uses for data, e.g. /dev/ttyUSB2 beside
+// /dev/ttyUSB3, so both can be used at once.
+package modem
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"riscv-dev/pkg/hal"
+)
+
+// Config is a modem's AT port
+type Config struct {
+	// Port is the modem's AT port, e.g. /dev/ttyUSB2 or /dev/ttyACM0
+	Port string `json:"port"`
+	// Baud defaults to 115200; USB modems ignore it
+	Baud int `json:"baud,omitempty"`
+	// PIN unlocks the SIM if it asks for one
+	PIN string `json:"pin,omitempty"`
+}
+
+// Device is a modem, opened for each conversation so a modem that was
+// reset or replugged in between is found again. Devices on the same port
+// take turns.
+type Device struct {
+	cfg Config
+	mu  *sync.Mutex
+}
+
+var (
+	portsMu sync.Mutex
+	ports   = make(map[string]*sync.Mutex)
+)
+
+// New returns the modem on cfg.Port
+func New(cfg Config) (*Device, error) {
+	if cfg.Port == "" {
+		return nil, errors.New("modem: port is required")
+	}
+	if cfg.Baud == 0 {
+		cfg.Baud = 115200
+	}
+	portsMu.Lock()
+	defer portsMu.Unlock()
+	mu := ports[cfg.Port]
+	if mu == nil {
+		mu = new(sync.Mutex)
+		ports[cfg.Port] = mu
+	}
+	return &Device{cfg: cfg, mu: mu}, nil
+}
+
+// Port returns the AT port's path
+func (d *Device) Port() string { return d.cfg.Port }
+
+// Do opens the port, readies the modem and its SIM, and runs f
+func (d *Device) Do(ctx context.Context, f func(at *AT) error) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	u, err := hal.OpenUART(d.cfg.Port, d.cfg.Baud)
+	if err != nil {
+		return fmt.Errorf("modem: %w", err)
+	}
+	defer u.Close()
+	at := NewAT(u)
+	if err := at.Setup(ctx, d.cfg.PIN); err != nil {
+		return fmt.Errorf("modem %s: %w", d.cfg.Port, err)
+	}
+	if err := f(at); err != nil {
+		return fmt.Errorf("modem %s: %w", d.cfg.Port, err)
+	}
+	return nil
+}
+
+// SendSMS texts each of numbers, in international format such as
+// +4915112345678
+func (d *Device) SendSMS(ctx context.Context, text string, numbers ...string) error {
+	return d.Do(ctx, func(at *AT) error {
+		var errs []error
+		for _, n := range numbers {
+			if err := at.SendSMS(ctx, n, text); err != nil {
+				errs = append(errs, fmt.Errorf("to %s: %w", n, err))
+			}
+		}
+		return errors.Join(errs...)
+	})
+}
+
+// Status reads the signal quality and network registration
+func (d *Device) Status(ctx context.Context) (Status, error) {
+	var s Status
+	err := d.Do(ctx, func(at *AT) (err error) {
+		s, err = at.Status(ctx)
+		return err
+	})
+	return s, err
+}
+
+// ValidNumber accepts a phone number of digits with an optional leading +
+func ValidNumber(n string) bool {
+	digits := strings.TrimPrefix(n, "+")
+	if digits == "" || len(digits) > 20 {
+		return false
+	}
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Port is the serial line to a modem
+type Port interface {
+	io.ReadWriter
+	SetReadDeadline(t time.Time) error
+}
+
+// AT talks AT commands to a modem
+type AT struct {
+	port Port
+	buf  []byte
+}
+
+// NewAT starts a conversation on port
+func NewAT(port Port) *AT { return &AT{port: port} }
+
+// AT command timeouts: most answer at once, but sending waits on the
+// network
+const (
+	atTimeout   = 5 * time.Second
+	cmgsTimeout = 60 * time.Second
+)
+
+// Setup switches echo off and unlocks the SIM
+func (at *AT) Setup(ctx context.Context, pin string) error {
+	// A modem left waiting for a message's text is freed by ESC
+	if err := at.write("\x1b\r"); err != nil {
+		return err
+	}
+	at.expect(ctx, 500*time.Millisecond)
+	at.buf = nil
+	if _, err := at.Command(ctx, "ATE0"); err != nil {
+		return err
+	}
+	state, err := at.Command(ctx, "AT+CPIN?")
+	if err != nil {
+		return err
+	}
+	switch {
+	case strings.Contains(state, "READY"):
+	case strings.Contains(state, "SIM PIN") && pin != "":
+		if _, err := at.Command(ctx, fmt.Sprintf("AT+CPIN=%q", pin)); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("SIM not ready (%s)", strings.TrimSpace(state))
+	}
+	return nil
+}
+
+// SendSMS sends text to number in text mode; the modem answers with a
+// prompt for the text, which ends with Ctrl+Z
+func (at *AT) SendSMS(ctx context.Context, number, text string) error {
+	if _, err := at.Command(ctx, "AT+CMGF=1"); err != nil {
+		return err
+	}
+	if err := at.write(fmt.Sprintf("AT+CMGS=%q\r", number)); err != nil {
+		return err
+	}
+	if _, err := at.expect(ctx, atTimeout, "> "); err != nil {
+		at.write("\x1b")
+		return fmt.Errorf("AT+CMGS: %w", err)
+	}
+	if err := at.write(text + "\x1a"); err != nil {
+		return err
+	}
+	if _, err := at.expect(ctx, cmgsTimeout); err != nil {
+		return fmt.Errorf("AT+CMGS: %w", err)
+	}
+	return nil
+}
+
+// Command sends an AT command and returns its response up to OK, one line
+// per line of it
+func (at *AT) Command(ctx context.Context, cmd string) (string, error) {
+	if err := at.write(cmd + "\r"); err != nil {
+		return "", err
+	}
+	resp, err := at.expect(ctx, atTimeout)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", cmd, err)
+	}
+	return resp, nil
+}
+
+func (at *AT) write(s string) error {
+	_, err := at.port.Write([]byte(s))
+	return err
+}
+
+// expect reads until a line with a final result, OK or an error, or one
+// of prompts, returning what came before it
+func (at *AT) expect(ctx context.Context, timeout time.Duration, prompts ...string) (string, error) {
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	at.port.SetReadDeadline(deadline)
+	defer at.port.SetReadDeadline(time.Time{})
+	stop := context.AfterFunc(ctx, func() { at.port.SetReadDeadline(time.Now()) })
+	defer stop()
+	chunk := make([]byte, 256)
+	var resp strings.Builder
+	for {
+		for _, p := range prompts {
+			if i := bytes.Index(at.buf, []byte(p)); i >= 0 {
+				resp.Write(at.buf[:i])
+				at.buf = at.buf[i+len(p):]
+				return resp.String(), nil
+			}
+		}
+		for {
+			i := bytes.IndexByte(at.buf, '\n')
+			if i < 0 {
+				break
+			}
+			line := strings.TrimSpace(string(at.buf[:i]))
+			at.buf = at.buf[i+1:]
+			switch {
+			case line == "OK":
+				return resp.String(), nil
+			case line == "ERROR" || strings.HasPrefix(line, "+CME ERROR") || strings.HasPrefix(line, "+CMS ERROR"):
+				return "", errors.New(line)
+			case line != "":
+				resp.WriteString(line + "\n")
+			}
+		}
+		n, err := at.port.Read(chunk)
+		at.buf = append(at.buf, chunk[:n]...)
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return "", errors.New("no answer from modem")
+			}
+			return "", err
+		}
+	}
+}
+
+// Status is what a modem reports of its radio link
+type Status struct {
+	// Signal is the received signal strength in dBm, NaN if unknown
+	Signal float64
+	// Registration is "home", "roaming", "searching", "denied" or
+	// "unregistered"
+	Registration string
+	Operator     string
+	// Access is the radio technology in use, e.g. "LTE"
+	Access string
+}
+
+// Registered reports whether the modem is on a network
+func (s Status) Registered() bool {
+	return s.Registration == "home" || s.Registration == "roaming"
+}
+
+// registrations by the stat of +CREG and +CEREG
+var registrations = map[string]string{
+	"0": "unregistered", "1": "home", "2": "searching", "3": "denied", "5": "roaming",
+}
+
+// accessTech names the AcT of +COPS
+var accessTech = map[string]string{
+	"0": "GSM", "2": "UMTS", "3": "EDGE", "4": "HSDPA", "5": "HSUPA", "6": "HSPA", "7": "LTE", "8": "EC-GSM", "9": "NB-IoT", "13": "NR",
+}
+
+// Status reads the signal quality (+CSQ), the registration on an LTE
+// network (+CEREG) or, failing that, any network (+CREG), and the
+// operator (+COPS)
+func (at *AT) Status(ctx context.Context) (Status, error) {
+	s := Status{Signal: math.NaN()}
+	resp, err := at.Command(ctx, "AT+CSQ")
+	if err != nil {
+		return s, err
+	}
+	if s.Signal, err = ParseCSQ(resp); err != nil {
+		return s, err
+	}
+	s.Registration = "unregistered"
+	for _, cmd := range []string{"AT+CEREG?", "AT+CREG?"} {
+		resp, err := at.Command(ctx, cmd)
+		if err != nil {
+			continue
+		}
+		// "+CEREG: <n>,<stat>[,...]"
+		_, fields, _ := strings.Cut(resp, ":")
+		parts := strings.Split(strings.TrimSpace(fields), ",")
+		if len(parts) >= 2 {
+			if r, ok := registrations[parts[1]]; ok {
+				s.Registration = r
+			}
+		}
+		if s.Registered() {
+			break
+		}
+	}
+	if resp, err := at.Command(ctx, "AT+COPS?"); err == nil {
+		// "+COPS: <mode>[,<format>,"<oper>"[,<AcT>]]"
+		_, fields, _ := strings.Cut(strings.TrimSpace(resp), ":")
+		parts := strings.Split(strings.TrimSpace(fields), ",")
+		if len(parts) >= 3 {
+			s.Operator = strings.Trim(parts[2], `"`)
+		}
+		if len(parts) >= 4 {
+			s.Access = accessTech[parts[3]]
+		}
+	}
+	return s, nil
+}
+
+// ParseCSQ returns the signal strength in dBm of a +CSQ response,
+// "+CSQ: <rssi>,<ber>": rssi 0 is -113 dBm or less, up to 31 for -51 dBm
+// or more, in steps of 2 dBm, and 99 unknown, which is NaN
+func ParseCSQ(resp string) (float64, error) {
+	_, fields, ok := strings.Cut(resp, "+CSQ:")
+	rssi, _, _ := strings.Cut(strings.TrimSpace(fields), ",")
+	n, err := strconv.Atoi(strings.TrimSpace(rssi))
+	if !ok || err != nil || n < 0 || (n > 31 && n != 99) {
+		return math.NaN(), fmt.Errorf("unexpected signal quality %q", strings.TrimSpace(resp))
+	}
+	if n == 99 {
+		return math.NaN(), nil
+	}
+	return float64(-113 + 2*n), nil
+}
@@ -0,0 +1,109 @@
+package modem
+
+import (
+	"bufio"
+	"context"
+	"math"
+	"net"
+	"strings"
+	"testing"
+)
+
+// fakeModem answers AT commands on a pipe, with a SIM asking for PIN 1234
+func fakeModem(conn net.Conn, got chan<- string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	unlocked := false
+	for {
+		line, err := r.ReadString('\r')
+		if err != nil {
+			return
+		}
+		cmd := strings.TrimSpace(strings.TrimPrefix(line, "\x1b"))
+		switch {
+		case cmd == "" || cmd == "ATE0" || cmd == "AT+CMGF=1":
+			conn.Write([]byte("\r\nOK\r\n"))
+		case cmd == "AT+CPIN?" && unlocked:
+			conn.Write([]byte("\r\n+CPIN: READY\r\n\r\nOK\r\n"))
+		case cmd == "AT+CPIN?":
+			conn.Write([]byte("\r\n+CPIN: SIM PIN\r\n\r\nOK\r\n"))
+		case cmd == `AT+CPIN="1234"`:
+			unlocked = true
+			conn.Write([]byte("\r\nOK\r\n"))
+		case cmd == "AT+CSQ":
+			conn.Write([]byte("\r\n+CSQ: 18,99\r\n\r\nOK\r\n"))
+		case cmd == "AT+CEREG?":
+			conn.Write([]byte("\r\n+CEREG: 0,5\r\n\r\nOK\r\n"))
+		case cmd == "AT+COPS?":
+			conn.Write([]byte("\r\n+COPS: 0,0,\"Vodafone.de\",7\r\n\r\nOK\r\n"))
+		case cmd == `AT+CMGS="+15550100"`:
+			conn.Write([]byte("\r\n> "))
+			text, err := r.ReadString('\x1a')
+			if err != nil {
+				return
+			}
+			got <- strings.TrimSuffix(text, "\x1a")
+			conn.Write([]byte("\r\n+CMGS: 7\r\n\r\nOK\r\n"))
+		case strings.HasPrefix(cmd, "AT+CMGS="):
+			conn.Write([]byte("\r\n> "))
+			r.ReadString('\x1a')
+			conn.Write([]byte("\r\n+CMS ERROR: 330\r\n"))
+		default:
+			conn.Write([]byte("\r\nERROR\r\n"))
+		}
+	}
+}
+
+func TestConversation(t *testing.T) {
+	host, modem := net.Pipe()
+	defer host.Close()
+	got := make(chan string, 1)
+	go fakeModem(modem, got)
+
+	ctx := context.Background()
+	at := NewAT(host)
+	if err := at.Setup(ctx, "1234"); err != nil {
+		t.Fatal(err)
+	}
+	s, err := at.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Status{Signal: -77, Registration: "roaming", Operator: "Vodafone.de", Access: "LTE"}
+	if s != want {
+		t.Errorf("Status = %+v, want %+v", s, want)
+	}
+	if err := at.SendSMS(ctx, "+15550100", "pressure saturated at 61.9 kPa"); err != nil {
+		t.Fatal(err)
+	}
+	if text := <-got; text != "pressure saturated at 61.9 kPa" {
+		t.Errorf("modem got %q", text)
+	}
+	if err := at.SendSMS(ctx, "+15550199", "x"); err == nil || !strings.Contains(err.Error(), "+CMS ERROR: 330") {
+		t.Errorf("SendSMS = %v, want the modem's error", err)
+	}
+	if _, err := at.Command(ctx, "AT+BOGUS"); err == nil {
+		t.Error("unknown command succeeded")
+	}
+}
+
+func TestParseCSQ(t *testing.T) {
+	for _, tc := range []struct {
+		resp string
+		want float64
+	}{
+		{"+CSQ: 0,99\n", -113},
+		{"+CSQ: 31,0\n", -51},
+		{"+CSQ: 99,99\n", math.NaN()},
+	} {
+		got, err := ParseCSQ(tc.resp)
+		if err != nil || got != tc.want && !(math.IsNaN(got) && math.IsNaN(tc.want)) {
+			t.Errorf("ParseCSQ(%q) = %v, %v; want %v", tc.resp, got, err, tc.want)
+		}
+	}
+	for _, resp := range []string{"", "+CSQ: 40,0", "+CREG: 0,1"} {
+		if _, err := ParseCSQ(resp); err == nil {
+			t.Errorf("ParseCSQ(%q) succeeded", resp)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// Package namespace places a device in a site/building/room/device
+// hierarchy. Applied the same way to topics, metric labels and readings, it
+// lets fleets from several projects share a broker, a Prometheus server or
+// a collector without their names colliding.
+package namespace
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Namespace locates a device. Empty levels are left out, and Device
+// defaults to the host name.
+type Namespace struct {
+	Site     string `json:"site,omitempty"`
+	Building string `json:"building,omitempty"`
+	Room     string `json:"room,omitempty"`
+	Device   string `json:"device,omitempty"`
+}
+
+// levels pairs each level's label name with its value
+func (n Namespace) levels() [][2]string {
+	return [][2]string{{"site", n.Site}, {"building", n.Building}, {"room", n.Room}, {"device", n.Device}}
+}
+
+// Resolve fills in Device from the host name if it is empty and checks
+// every level can be used in a topic
+func (n Namespace) Resolve() (Namespace, error) {
+	if n.Device == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return n, fmt.Errorf("namespace: no device given and %w", err)
+		}
+		n.Device = host
+	}
+	for _, l := range n.levels() {
+		if strings.ContainsAny(l[1], "/+#") || strings.TrimSpace(l[1]) != l[1] {
+			return n, fmt.Errorf("namespace: %s %q may not contain /, +, # or surrounding spaces", l[0], l[1])
+		}
+	}
+	return n, nil
+}
+
+// Path returns the levels that are set joined with slashes, e.g.
+// "acme/hq/lab-2/board-7"
+func (n Namespace) Path() string {
+	var parts []string
+	for _, l := range n.levels() {
+		if l[1] != "" {
+			parts = append(parts, l[1])
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// Topic returns a topic under the namespace, e.g. Topic("sensors",
+// "temperature") is "acme/hq/lab-2/board-7/sensors/temperature"
+func (n Namespace) Topic(parts ...string) string {
+	if p := n.Path(); p != "" {
+		parts = append([]string{p}, parts...)
+	}
+	return strings.Join(parts, "/")
+}
+
+// Labels returns the levels that are set as metric labels named site,
+// building, room and device
+func (n Namespace) Labels() map[string]string {
+	labels := make(map[string]string)
+	for _, l := range n.levels() {
+		if l[1] != "" {
+			labels[l[0]] = l[1]
+		}
+	}
+	return labels
+}
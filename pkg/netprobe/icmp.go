@@ -0,0 +1,165 @@
+package netprobe
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// ICMP echo message types
+const (
+	echoRequest4 = 8
+	echoReply4   = 0
+	echoRequest6 = 128
+	echoReply6   = 129
+)
+
+// icmpConn sends echo requests. It is a datagram ICMP socket where the
+// kernel allows unprivileged ping (net.ipv4.ping_group_range), which
+// replaces the identifier with its own and only passes on the answers to
+// its own requests, or else a raw socket, which needs CAP_NET_RAW.
+type icmpConn struct {
+	conn  net.PacketConn
+	raw   bool
+	v6    bool
+	id    uint16
+	seq   uint16
+	token [8]byte // identifies this conn's requests among a raw socket's
+}
+
+func listenICMP(ip net.IP, control func(network, address string, c syscall.RawConn) error) (*icmpConn, error) {
+	c := &icmpConn{v6: ip.To4() == nil, id: uint16(os.Getpid())}
+	if _, err := rand.Read(c.token[:]); err != nil {
+		return nil, err
+	}
+	network := "ip4:icmp"
+	if c.v6 {
+		network = "ip6:ipv6-icmp"
+	}
+	conn, err := datagramICMP(c.v6)
+	if errors.Is(err, syscall.EACCES) || errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EPROTONOSUPPORT) {
+		conn, err = net.ListenPacket(network, "")
+		c.raw = true
+	}
+	if err != nil {
+		return nil, fmt.Errorf("icmp socket (unprivileged ping not allowed, and no CAP_NET_RAW): %w", err)
+	}
+	c.conn = conn
+	if control != nil {
+		sc, ok := conn.(syscall.Conn)
+		if !ok {
+			conn.Close()
+			return nil, errors.New("icmp socket can't be bound")
+		}
+		rc, err := sc.SyscallConn()
+		if err == nil {
+			err = control(network, ip.String(), rc)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// datagramICMP opens an unprivileged ping socket
+func datagramICMP(v6 bool) (net.PacketConn, error) {
+	family, proto := syscall.AF_INET, syscall.IPPROTO_ICMP
+	var sa syscall.Sockaddr = &syscall.SockaddrInet4{}
+	if v6 {
+		family, proto, sa = syscall.AF_INET6, syscall.IPPROTO_ICMPV6, &syscall.SockaddrInet6{}
+	}
+	fd, err := socket(family, syscall.SOCK_DGRAM, proto)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if err := syscall.Bind(fd, sa); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	f := os.NewFile(uintptr(fd), "icmp")
+	defer f.Close()
+	return net.FilePacketConn(f)
+}
+
+func (c *icmpConn) Close() error { return c.conn.Close() }
+
+// echo sends an echo request to ip and waits up to timeout for the reply
+func (c *icmpConn) echo(ctx context.Context, ip net.IP, timeout time.Duration) (time.Duration, error) {
+	c.seq++
+	typ := byte(echoRequest4)
+	if c.v6 {
+		typ = echoRequest6
+	}
+	msg := make([]byte, 8+len(c.token))
+	msg[0] = typ
+	binary.BigEndian.PutUint16(msg[4:], c.id)
+	binary.BigEndian.PutUint16(msg[6:], c.seq)
+	copy(msg[8:], c.token[:])
+	if !c.v6 {
+		// The kernel computes ICMPv6 checksums, which cover the addresses
+		binary.BigEndian.PutUint16(msg[2:], checksum(msg))
+	}
+
+	var dst net.Addr = &net.IPAddr{IP: ip}
+	if !c.raw {
+		dst = &net.UDPAddr{IP: ip}
+	}
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	c.conn.SetDeadline(deadline)
+	start := time.Now()
+	if _, err := c.conn.WriteTo(msg, dst); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := c.conn.ReadFrom(buf)
+		if err != nil {
+			return 0, err
+		}
+		if c.isReply(buf[:n]) {
+			return time.Since(start), nil
+		}
+	}
+}
+
+// isReply reports whether b is the reply to the last request
+func (c *icmpConn) isReply(b []byte) bool {
+	want := byte(echoReply4)
+	if c.v6 {
+		want = echoReply6
+	}
+	if len(b) < 8+len(c.token) || b[0] != want || binary.BigEndian.Uint16(b[6:]) != c.seq {
+		return false
+	}
+	if c.raw && binary.BigEndian.Uint16(b[4:]) != c.id {
+		return false
+	}
+	return bytes.Equal(b[8:8+len(c.token)], c.token[:])
+}
+
+// checksum is the Internet checksum of RFC 1071
+func checksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,8 @@
+package netprobe
+
+import "syscall"
+
+// socket opens a socket that isn't inherited by child processes
+func socket(family, typ, proto int) (int, error) {
+	return syscall.Socket(family, typ|syscall.SOCK_CLOEXEC, proto)
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package netprobe
+
+import "syscall"
+
+// socket opens a socket that isn't inherited by child processes, holding
+// ForkLock until close-on-exec is set as package net does where
+// SOCK_CLOEXEC is missing
+func socket(family, typ, proto int) (int, error) {
+	syscall.ForkLock.RLock()
+	defer syscall.ForkLock.RUnlock()
+	fd, err := syscall.Socket(family, typ, proto)
+	if err != nil {
+		return -1, err
+	}
+	syscall.CloseOnExec(fd)
+	return fd, nil
+}
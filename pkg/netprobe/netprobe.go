@@ -0,0 +1,242 @@
+// Package netprobe measures the round-trip time and loss of a network
+// path, by ICMP echo, TCP connect or HTTP request, for recording how the
+// connection of a remote site degrades alongside its sensor data.
+//
+// A round sends Count probes a little apart and waits up to Timeout for
+// each; the result is the round's loss and the spread of the answered
+// probes' round-trip times. For icmp and tcp host names are resolved once
+// a round, leaving DNS out of the times; an http probe times what a client
+// sees, DNS, connecting and TLS included.
+package netprobe
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+
+	"riscv-dev/pkg/config"
+)
+
+// Config is a path to probe
+type Config struct {
+	// Type is icmp (echo requests, as ping), tcp (connecting) or http (a
+	// GET on a fresh connection, as a client would see it)
+	Type string `json:"type"`
+	// Target is a host or address for icmp, host:port for tcp and a URL
+	// for http
+	Target string `json:"target"`
+	// Count is the probes per round; default 5
+	Count int `json:"count,omitempty"`
+	// Timeout is how long a probe waits for its answer; default 2s
+	Timeout config.Duration `json:"timeout,omitempty"`
+}
+
+// Defaults, and the spacing of the probes in a round
+const (
+	DefaultCount   = 5
+	DefaultTimeout = 2 * time.Second
+	spacing        = 200 * time.Millisecond
+)
+
+// Options connect a prober to the network
+type Options struct {
+	// Control binds the prober's sockets, e.g. to an interface
+	Control func(network, address string, c syscall.RawConn) error
+	// TLS is used for https targets; nil for the defaults
+	TLS *tls.Config
+}
+
+// Result is a round of probes
+type Result struct {
+	Sent     int           `json:"sent"`
+	Received int           `json:"received"`
+	Min      time.Duration `json:"min"`
+	Mean     time.Duration `json:"mean"`
+	Max      time.Duration `json:"max"`
+	// Err is why the last probe that failed did, nil if none did
+	Err error `json:"-"`
+}
+
+// Loss is the percentage of probes unanswered
+func (r Result) Loss() float64 {
+	if r.Sent == 0 {
+		return 100
+	}
+	return 100 * float64(r.Sent-r.Received) / float64(r.Sent)
+}
+
+// Prober probes one path
+type Prober struct {
+	cfg      Config
+	timeout  time.Duration
+	host     string // resolved each round
+	port     string // tcp only
+	control  func(network, address string, c syscall.RawConn) error
+	resolver *net.Resolver
+	client   *http.Client // http only
+}
+
+// New checks cfg and returns its prober
+func New(cfg Config, opts Options) (*Prober, error) {
+	if cfg.Target == "" {
+		return nil, errors.New("netprobe: target is required")
+	}
+	if cfg.Count <= 0 {
+		cfg.Count = DefaultCount
+	}
+	p := &Prober{cfg: cfg, timeout: cfg.Timeout.D(), control: opts.Control, resolver: net.DefaultResolver}
+	if p.timeout <= 0 {
+		p.timeout = DefaultTimeout
+	}
+	if opts.Control != nil {
+		dns := &net.Dialer{Timeout: p.timeout, Control: opts.Control}
+		p.resolver = &net.Resolver{PreferGo: true, Dial: dns.DialContext}
+	}
+	switch cfg.Type {
+	case "icmp":
+		p.host = cfg.Target
+	case "tcp":
+		host, port, err := net.SplitHostPort(cfg.Target)
+		if err != nil {
+			return nil, fmt.Errorf("netprobe: tcp target %q: %w", cfg.Target, err)
+		}
+		p.host, p.port = host, port
+	case "http":
+		u, err := url.Parse(cfg.Target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("netprobe: http target %q is not an http or https URL", cfg.Target)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = opts.TLS
+		transport.DisableKeepAlives = true
+		transport.DialContext = (&net.Dialer{Timeout: p.timeout, Control: opts.Control, Resolver: p.resolver}).DialContext
+		p.client = &http.Client{
+			Transport: transport,
+			Timeout:   p.timeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	default:
+		return nil, fmt.Errorf("netprobe: unknown type %q (want icmp, tcp or http)", cfg.Type)
+	}
+	return p, nil
+}
+
+// Target returns the configured target
+func (p *Prober) Target() string { return p.cfg.Target }
+
+// Round sends a round of probes. Failing to resolve the target, or to open
+// a socket, loses the whole round.
+func (p *Prober) Round(ctx context.Context) Result {
+	r := Result{Sent: p.cfg.Count}
+	var probe func(ctx context.Context) (time.Duration, error)
+	switch p.cfg.Type {
+	case "icmp":
+		ip, err := p.resolve(ctx)
+		if err != nil {
+			r.Err = err
+			return r
+		}
+		conn, err := listenICMP(ip, p.control)
+		if err != nil {
+			r.Err = err
+			return r
+		}
+		defer conn.Close()
+		probe = func(ctx context.Context) (time.Duration, error) { return conn.echo(ctx, ip, p.timeout) }
+	case "tcp":
+		ip, err := p.resolve(ctx)
+		if err != nil {
+			r.Err = err
+			return r
+		}
+		addr := net.JoinHostPort(ip.String(), p.port)
+		d := net.Dialer{Timeout: p.timeout, Control: p.control}
+		probe = func(ctx context.Context) (time.Duration, error) {
+			start := time.Now()
+			conn, err := d.DialContext(ctx, "tcp", addr)
+			if err != nil {
+				return 0, err
+			}
+			rtt := time.Since(start)
+			conn.Close()
+			return rtt, nil
+		}
+	case "http":
+		probe = p.get
+	}
+
+	var total time.Duration
+	for i := 0; i < p.cfg.Count; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(spacing):
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			r.Err = ctx.Err()
+			break
+		}
+		rtt, err := probe(ctx)
+		if err != nil {
+			r.Err = err
+			continue
+		}
+		if r.Received == 0 || rtt < r.Min {
+			r.Min = rtt
+		}
+		r.Max = max(r.Max, rtt)
+		total += rtt
+		r.Received++
+	}
+	if r.Received > 0 {
+		r.Mean = total / time.Duration(r.Received)
+	}
+	return r
+}
+
+// resolve looks up the target host, preferring IPv4
+func (p *Prober) resolve(ctx context.Context) (net.IP, error) {
+	if ip := net.ParseIP(p.host); ip != nil {
+		return ip, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	ips, err := p.resolver.LookupIP(ctx, "ip", p.host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			return ip, nil
+		}
+	}
+	return ips[0], nil
+}
+
+// get times a GET of the target up to its response's headers. Any
+// response counts, since the path worked.
+func (p *Prober) get(ctx context.Context) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.cfg.Target, nil)
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	rtt := time.Since(start)
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	return rtt, nil
+}
@@ -0,0 +1,96 @@
+package netprobe
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"riscv-dev/pkg/config"
+)
+
+func TestChecksum(t *testing.T) {
+	// An echo request, id 1 seq 1, as sent by ping
+	msg := []byte{8, 0, 0, 0, 0, 1, 0, 1, 'a', 'b'}
+	sum := checksum(msg)
+	msg[2], msg[3] = byte(sum>>8), byte(sum)
+	if checksum(msg) != 0 {
+		t.Errorf("checksum %#04x doesn't verify", sum)
+	}
+}
+
+func TestTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	p, err := New(Config{Type: "tcp", Target: ln.Addr().String(), Count: 3}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := p.Round(context.Background())
+	if r.Received != 3 || r.Loss() != 0 || r.Min <= 0 || r.Min > r.Mean || r.Mean > r.Max {
+		t.Errorf("round to a listener: %+v", r)
+	}
+
+	addr := ln.Addr().String()
+	ln.Close()
+	p, _ = New(Config{Type: "tcp", Target: addr, Count: 2}, Options{})
+	if r := p.Round(context.Background()); r.Received != 0 || r.Loss() != 100 || r.Err == nil {
+		t.Errorf("round to a closed port: %+v", r)
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+	p, err := New(Config{Type: "http", Target: srv.URL, Count: 2}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := p.Round(context.Background()); r.Received != 2 || r.Min < 10*time.Millisecond {
+		t.Errorf("round: %+v", r)
+	}
+}
+
+func TestICMP(t *testing.T) {
+	p, err := New(Config{Type: "icmp", Target: "127.0.0.1", Count: 2, Timeout: config.Duration(time.Second)}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := listenICMP(net.ParseIP("127.0.0.1"), nil)
+	if err != nil {
+		t.Skipf("no ICMP socket here: %v", err)
+	}
+	conn.Close()
+	if r := p.Round(context.Background()); r.Received != 2 {
+		t.Errorf("round to localhost: %+v, %v", r, r.Err)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	for _, cfg := range []Config{
+		{Type: "tcp", Target: "example.com"},
+		{Type: "http", Target: "example.com"},
+		{Type: "udp", Target: "example.com:53"},
+		{Type: "icmp"},
+	} {
+		if _, err := New(cfg, Options{}); err == nil {
+			t.Errorf("%+v accepted", cfg)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+// Package netwait waits, for a bounded time, until the network is usable:
+// an interface has an address and, optionally, a default route. Boards
+// often start applications before DHCP or the Wi-Fi link has finished.
+package netwait
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"riscv-dev/pkg/config"
+)
+
+// Config selects what counts as online and how long to wait for it
+type Config struct {
+	Interface    string          `json:"interface,omitempty"` // empty accepts any interface except loopback
+	RequireRoute bool            `json:"require_route"`       // also wait for a default route
+	Timeout      config.Duration `json:"timeout"`             // 0 waits until ctx is done
+}
+
+// routeFile lists the kernel routing table
+const routeFile = "/proc/net/route"
+
+// pollInterval and progressInterval pace the checks and the log messages
+const (
+	pollInterval     = time.Second
+	progressInterval = 5 * time.Second
+)
+
+// Check reports whether the network is online; if not, the error says what
+// is missing
+func Check(cfg Config) error {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return err
+	}
+
+	withAddr := ""
+	for _, ifi := range ifaces {
+		if cfg.Interface != "" && ifi.Name != cfg.Interface {
+			continue
+		}
+		if ifi.Flags&net.FlagLoopback != 0 || ifi.Flags&net.FlagUp == 0 {
+			continue
+		}
+		if hasAddress(ifi) {
+			withAddr = ifi.Name
+			break
+		}
+	}
+	if withAddr == "" {
+		if cfg.Interface != "" {
+			return fmt.Errorf("%s has no address", cfg.Interface)
+		}
+		return fmt.Errorf("no interface has an address")
+	}
+
+	if cfg.RequireRoute {
+		ok, err := defaultRoute(cfg.Interface)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("no default route")
+		}
+	}
+	return nil
+}
+
+// Wait polls Check until the network is online, logging progress. It
+// returns an error if Timeout passes first or ctx is done.
+func Wait(ctx context.Context, cfg Config) error {
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout.D())
+		defer cancel()
+	}
+
+	start := time.Now()
+	lastLog := start
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		err := Check(cfg)
+		if err == nil {
+			if time.Since(start) > pollInterval {
+				log.Printf("network online after %v", time.Since(start).Round(time.Second))
+			}
+			return nil
+		}
+		if time.Since(lastLog) >= progressInterval {
+			lastLog = time.Now()
+			log.Printf("waiting for network: %v (%v elapsed)", err, time.Since(start).Round(time.Second))
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("network not online after %v: %w", time.Since(start).Round(time.Second), err)
+		}
+	}
+}
+
+// hasAddress reports whether ifi has a global unicast address
+func hasAddress(ifi net.Interface) bool {
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return false
+	}
+	for _, a := range addrs {
+		if ipn, ok := a.(*net.IPNet); ok && ipn.IP.IsGlobalUnicast() {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultRoute reports whether the IPv4 routing table has a default route,
+// via iface if it is set
+func defaultRoute(iface string) (bool, error) {
+	f, err := os.Open(routeFile)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 || fields[1] != "00000000" || fields[7] != "00000000" {
+			continue
+		}
+		if iface == "" || fields[0] == iface {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
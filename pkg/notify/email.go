@@ -0,0 +1,143 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// EmailConfig sends messages through an SMTP server, which must offer
+// STARTTLS: credentials and alerts are never sent in the clear
+type EmailConfig struct {
+	// Server is the mail submission server as host:port; port 587 if left
+	// out
+	Server string   `json:"server"`
+	From   string   `json:"from"`
+	To     []string `json:"to"`
+	// Username and Password log in with PLAIN after STARTTLS; Password
+	// defaults to $SMTP_PASSWORD
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// Email sends messages by email
+type Email struct {
+	cfg  EmailConfig
+	host string
+	tls  *tls.Config
+	dial DialFunc
+}
+
+// NewEmail creates an email sender. tlsCfg, which may be nil, is used for
+// STARTTLS and dial, which may be nil too, to connect.
+func NewEmail(cfg EmailConfig, tlsCfg *tls.Config, dial DialFunc) (*Email, error) {
+	if cfg.Server == "" || cfg.From == "" || len(cfg.To) == 0 {
+		return nil, errors.New("email: server, from and to are required")
+	}
+	host, _, err := net.SplitHostPort(cfg.Server)
+	if err != nil {
+		host = cfg.Server
+		cfg.Server = net.JoinHostPort(host, "587")
+	}
+	if cfg.Username != "" && cfg.Password == "" {
+		cfg.Password = os.Getenv("SMTP_PASSWORD")
+	}
+	if tlsCfg == nil {
+		tlsCfg = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	tlsCfg = tlsCfg.Clone()
+	if tlsCfg.ServerName == "" {
+		tlsCfg.ServerName = host
+	}
+	if dial == nil {
+		d := &net.Dialer{Timeout: 30 * time.Second}
+		dial = d.DialContext
+	}
+	return &Email{cfg: cfg, host: host, tls: tlsCfg, dial: dial}, nil
+}
+
+// Send mails m to every recipient
+func (e *Email) Send(ctx context.Context, m Message) error {
+	conn, err := e.dial(ctx, "tcp", e.cfg.Server)
+	if err != nil {
+		return fmt.Errorf("email: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	// Unblock the exchange if ctx ends first
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	c, err := smtp.NewClient(conn, e.host)
+	if err != nil {
+		return fmt.Errorf("email: %s: %w", e.cfg.Server, err)
+	}
+	defer c.Close()
+	if hostname, err := os.Hostname(); err == nil {
+		if err := c.Hello(hostname); err != nil {
+			return fmt.Errorf("email: %s: %w", e.cfg.Server, err)
+		}
+	}
+	if ok, _ := c.Extension("STARTTLS"); !ok {
+		return fmt.Errorf("email: %s does not offer STARTTLS", e.cfg.Server)
+	}
+	if err := c.StartTLS(e.tls); err != nil {
+		return fmt.Errorf("email: %s: STARTTLS: %w", e.cfg.Server, err)
+	}
+	if e.cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", e.cfg.Username, e.cfg.Password, e.host)); err != nil {
+			return fmt.Errorf("email: %s: %w", e.cfg.Server, err)
+		}
+	}
+	if err := c.Mail(e.cfg.From); err != nil {
+		return fmt.Errorf("email: %s: %w", e.cfg.Server, err)
+	}
+	for _, to := range e.cfg.To {
+		if err := c.Rcpt(to); err != nil {
+			return fmt.Errorf("email: %s: %s: %w", e.cfg.Server, to, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("email: %s: %w", e.cfg.Server, err)
+	}
+	if _, err := w.Write(e.message(m)); err != nil {
+		return fmt.Errorf("email: %s: %w", e.cfg.Server, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("email: %s: %w", e.cfg.Server, err)
+	}
+	return c.Quit()
+}
+
+// message formats m as a plain text mail
+func (e *Email) message(m Message) []byte {
+	var b bytes.Buffer
+	header := func(name, value string) { fmt.Fprintf(&b, "%s: %s\r\n", name, value) }
+	header("From", e.cfg.From)
+	header("To", strings.Join(e.cfg.To, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Title()))
+	header("Date", m.Time.Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "8bit")
+	b.WriteString("\r\n")
+	body := m.Body
+	if body == "" {
+		body = m.Title()
+	}
+	for _, line := range strings.Split(strings.TrimRight(body, "\n"), "\n") {
+		b.WriteString(line + "\r\n")
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,221 @@
+// Package notify sends alerts to people, by email over SMTP or by SMS
+// through a cellular modem or an HTTP SMS gateway, for deployments that
+// nobody watches on a dashboard.
+//
+// A Notifier queues messages for a Sender and keeps it from flooding its
+// recipients: it sends at most MaxPerHour messages in any hour, folding
+// what doesn't fit into one digest, and holds messages during quiet hours
+// to send them as a digest once they end.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"riscv-dev/pkg/schedule"
+)
+
+// Message is a notification: a subject line that says it all, as an SMS
+// carries nothing else, and details for email
+type Message struct {
+	Time time.Time
+	// Source is the device the message is about, e.g. its namespace path
+	Source  string
+	Subject string
+	Body    string
+	// Urgent messages are sent during quiet hours
+	Urgent bool
+}
+
+// Title returns the subject with the source before it
+func (m Message) Title() string {
+	if m.Source == "" {
+		return m.Subject
+	}
+	return m.Source + ": " + m.Subject
+}
+
+// Sender delivers a message to its recipients
+type Sender interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// DialFunc opens network connections, e.g. to resolve static hosts
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// Policy limits what a Notifier sends
+type Policy struct {
+	// MaxPerHour is the most messages sent in any hour; default 6
+	MaxPerHour int
+	// Quiet holds messages that aren't urgent while it matches, to be sent
+	// as one digest when it ends; nil for none
+	Quiet *schedule.Window
+}
+
+// Queue sizes: messages waiting to be taken by the Notifier, and held
+// ones, of which the oldest are dropped
+const (
+	queueSize = 64
+	maxHeld   = 100
+)
+
+// retryInterval is how often held messages are tried again, and quiet
+// hours and the hourly limit checked
+const retryInterval = time.Minute
+
+// sendTimeout bounds one delivery
+const sendTimeout = 2 * time.Minute
+
+// Notifier queues messages for a sender under a policy
+type Notifier struct {
+	name   string
+	sender Sender
+	policy Policy
+	queue  chan Message
+
+	now     func() time.Time
+	held    []Message
+	sent    []time.Time // in the last hour
+	digest  bool        // held messages go out as one, after quiet hours
+	failing bool
+}
+
+// New creates a notifier for sender, named for the log
+func New(name string, sender Sender, policy Policy) *Notifier {
+	if policy.MaxPerHour <= 0 {
+		policy.MaxPerHour = 6
+	}
+	return &Notifier{name: name, sender: sender, policy: policy, queue: make(chan Message, queueSize), now: time.Now}
+}
+
+// Notify queues m without blocking; it returns false if the queue is full
+func (n *Notifier) Notify(m Message) bool {
+	if m.Time.IsZero() {
+		m.Time = time.Now()
+	}
+	select {
+	case n.queue <- m:
+		return true
+	default:
+		return false
+	}
+}
+
+// Run sends queued messages until ctx ends. Messages still held then are
+// lost, which is logged.
+func (n *Notifier) Run(ctx context.Context) {
+	t := time.NewTicker(retryInterval)
+	defer t.Stop()
+	for {
+		select {
+		case m := <-n.queue:
+			n.hold(m)
+		case <-t.C:
+		case <-ctx.Done():
+			if len(n.held) > 0 {
+				log.Printf("⚠️  Notifications %s: %d messages not sent", n.name, len(n.held))
+			}
+			return
+		}
+		n.flush(ctx)
+	}
+}
+
+// hold adds m to the messages waiting to be sent
+func (n *Notifier) hold(m Message) {
+	if len(n.held) == maxHeld {
+		n.held = n.held[1:]
+	}
+	n.held = append(n.held, m)
+}
+
+// flush sends what the policy allows of the held messages
+func (n *Notifier) flush(ctx context.Context) {
+	now := n.now()
+	cutoff := now.Add(-time.Hour)
+	for len(n.sent) > 0 && !n.sent[0].After(cutoff) {
+		n.sent = n.sent[1:]
+	}
+	quiet := n.policy.Quiet != nil && n.policy.Quiet.Contains(now)
+	for {
+		// Send held[lo:hi]
+		lo, hi := 0, 0
+		switch {
+		case len(n.sent) >= n.policy.MaxPerHour:
+			// What is held goes out together when the hour allows
+			n.digest = len(n.held) > 0
+			return
+		case quiet:
+			// Only urgent messages, one at a time
+			for lo = 0; lo < len(n.held) && !n.held[lo].Urgent; lo++ {
+			}
+			if lo == len(n.held) {
+				if len(n.held) > 0 {
+					n.digest = true
+				}
+				return
+			}
+			hi = lo + 1
+		case len(n.held) == 0:
+			n.digest = false
+			return
+		case n.digest || len(n.sent) == n.policy.MaxPerHour-1:
+			// After quiet hours or the hourly limit, or with the hour's
+			// last message, send everything together
+			hi = len(n.held)
+		default:
+			hi = 1
+		}
+		if !n.send(ctx, Digest(n.held[lo:hi])) {
+			return
+		}
+		n.sent = append(n.sent, now)
+		n.held = append(n.held[:lo], n.held[hi:]...)
+	}
+}
+
+// send delivers m, logging failures once until a message goes out again
+func (n *Notifier) send(ctx context.Context, m Message) bool {
+	sctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+	err := n.sender.Send(sctx, m)
+	switch {
+	case err == nil:
+		if n.failing {
+			log.Printf("✅ Notifications %s: sending again", n.name)
+			n.failing = false
+		}
+		return true
+	case ctx.Err() != nil:
+		// Stopping
+	case !n.failing:
+		log.Printf("❌ Notifications %s: %v (retrying every %v)", n.name, err, retryInterval)
+		n.failing = true
+	}
+	return false
+}
+
+// Digest folds messages from one source into one, with their subjects
+// joined, latest first, and listed with their times; a single message is
+// returned as it is
+func Digest(msgs []Message) Message {
+	if len(msgs) == 1 {
+		return msgs[0]
+	}
+	last := msgs[len(msgs)-1]
+	d := Message{Time: last.Time, Source: last.Source}
+	subjects := make([]string, 0, len(msgs))
+	var b strings.Builder
+	for i, m := range msgs {
+		subjects = append(subjects, msgs[len(msgs)-1-i].Subject)
+		fmt.Fprintf(&b, "%s %s\n", m.Time.Format("Jan 2 15:04"), m.Subject)
+		d.Urgent = d.Urgent || m.Urgent
+	}
+	d.Subject = fmt.Sprintf("%d alerts: %s", len(msgs), strings.Join(subjects, "; "))
+	d.Body = b.String()
+	return d
+}
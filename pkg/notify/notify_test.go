@@ -0,0 +1,145 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"riscv-dev/pkg/schedule"
+)
+
+type recorder struct {
+	sent []Message
+	err  error
+}
+
+func (r *recorder) Send(ctx context.Context, m Message) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.sent = append(r.sent, m)
+	return nil
+}
+
+func TestNotifierRateLimit(t *testing.T) {
+	rec := &recorder{}
+	n := New("test", rec, Policy{MaxPerHour: 3})
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	n.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for _, s := range []string{"a", "b", "c", "d", "e"} {
+		n.hold(Message{Time: now, Subject: s})
+	}
+	n.flush(ctx)
+	if len(rec.sent) != 3 || rec.sent[0].Subject != "a" || rec.sent[1].Subject != "b" {
+		t.Fatalf("sent %+v, want a, b and a digest", rec.sent)
+	}
+	if d := rec.sent[2]; !strings.HasPrefix(d.Subject, "3 alerts") || strings.Count(d.Body, "\n") != 3 {
+		t.Errorf("digest %+v, want c, d and e", d)
+	}
+
+	// The hour is used up: held until it allows, then sent together
+	n.hold(Message{Time: now, Subject: "f"})
+	n.hold(Message{Time: now, Subject: "g"})
+	n.flush(ctx)
+	if len(rec.sent) != 3 {
+		t.Fatalf("sent %d messages over the limit", len(rec.sent)-3)
+	}
+	now = now.Add(time.Hour)
+	n.flush(ctx)
+	if len(rec.sent) != 4 || !strings.HasPrefix(rec.sent[3].Subject, "2 alerts") {
+		t.Fatalf("sent %+v after the hour, want one digest", rec.sent[3:])
+	}
+
+	// Failures keep the message for the next try
+	rec.err = errors.New("down")
+	n.hold(Message{Time: now, Subject: "h"})
+	n.flush(ctx)
+	rec.err = nil
+	n.flush(ctx)
+	if len(rec.sent) != 5 || rec.sent[4].Subject != "h" {
+		t.Errorf("sent %+v after a failure, want h", rec.sent[4:])
+	}
+}
+
+func TestNotifierQuietHours(t *testing.T) {
+	quiet, err := schedule.Parse("22:00-06:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &recorder{}
+	n := New("test", rec, Policy{Quiet: quiet})
+	now := time.Date(2024, 1, 1, 23, 0, 0, 0, time.UTC)
+	n.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	n.hold(Message{Time: now, Subject: "a"})
+	n.hold(Message{Time: now, Subject: "urgent", Urgent: true})
+	n.flush(ctx)
+	if len(rec.sent) != 1 || rec.sent[0].Subject != "urgent" {
+		t.Fatalf("sent %+v during quiet hours, want only the urgent one", rec.sent)
+	}
+	n.hold(Message{Time: now, Subject: "b"})
+	n.flush(ctx)
+
+	now = now.Add(8 * time.Hour)
+	n.flush(ctx)
+	if len(rec.sent) != 2 || rec.sent[1].Subject != "2 alerts: b; a" {
+		t.Fatalf("sent %+v after quiet hours, want one digest", rec.sent[1:])
+	}
+	n.hold(Message{Time: now, Subject: "c"})
+	n.flush(ctx)
+	if len(rec.sent) != 3 || rec.sent[2].Subject != "c" {
+		t.Errorf("sent %+v, want c on its own", rec.sent[2:])
+	}
+}
+
+func TestSMSText(t *testing.T) {
+	m := Message{Source: "lab-2", Subject: "temperature out-of-range at 35.2°C " + strings.Repeat("x", 200), Body: "details"}
+	got := smsText(m, true)
+	if !strings.HasPrefix(got, "lab-2: temperature out-of-range at 35.2C xxx") || len(got) != smsLimit || !strings.HasSuffix(got, "...") {
+		t.Errorf("smsText = %q", got)
+	}
+	if got := smsText(Message{Subject: "21.5°C ✓"}, true); got != "21.5C ?" {
+		t.Errorf("smsText = %q, want ASCII", got)
+	}
+}
+
+func TestGateway(t *testing.T) {
+	var form []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		if user != "AC1" || pass != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		r.ParseForm()
+		form = append(form, r.PostForm.Get("To")+"|"+r.PostForm.Get("From")+"|"+r.PostForm.Get("Body"))
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	cfg := GatewayConfig{URL: srv.URL, Form: true, ToField: "To", TextField: "Body", Fields: map[string]string{"From": "+15550000"}, Username: "AC1", Password: "secret"}
+	g, err := NewGateway(cfg, []string{"+15550100", "+15550101"}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Send(context.Background(), Message{Subject: "light sensor-fault"}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"+15550100|+15550000|light sensor-fault", "+15550101|+15550000|light sensor-fault"}
+	if strings.Join(form, ",") != strings.Join(want, ",") {
+		t.Errorf("gateway got %q, want %q", form, want)
+	}
+
+	cfg.Password = "wrong"
+	g, _ = NewGateway(cfg, []string{"+15550100"}, nil, nil)
+	if err := g.Send(context.Background(), Message{Subject: "x"}); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("Send = %v, want the gateway's status", err)
+	}
+}
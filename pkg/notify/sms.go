@@ -0,0 +1,177 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"riscv-dev/pkg/modem"
+)
+
+// smsLimit is the length of a single SMS in the GSM alphabet; longer
+// texts are cut, since not every modem or gateway joins parts
+const smsLimit = 160
+
+// smsText returns m's title as the text of one SMS. With ascii, for a
+// modem in text mode, characters outside ASCII are dropped or replaced,
+// as in "21.5C".
+func smsText(m Message, ascii bool) string {
+	text := m.Title()
+	if ascii {
+		text = strings.Map(func(r rune) rune {
+			switch {
+			case r == '°':
+				return -1
+			case r >= ' ' && r < 0x7f:
+				return r
+			}
+			return '?'
+		}, text)
+	}
+	if runes := []rune(text); len(runes) > smsLimit {
+		text = string(runes[:smsLimit-3]) + "..."
+	}
+	return text
+}
+
+// Modem sends SMS through a cellular modem
+type Modem struct {
+	dev *modem.Device
+	to  []string
+}
+
+// NewModem creates a sender texting the numbers in to, in international
+// format such as +4915112345678
+func NewModem(cfg modem.Config, to []string) (*Modem, error) {
+	if len(to) == 0 {
+		return nil, errors.New("sms: recipients are required")
+	}
+	for _, n := range to {
+		if !modem.ValidNumber(n) {
+			return nil, fmt.Errorf("sms: invalid number %q", n)
+		}
+	}
+	dev, err := modem.New(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("sms: %w", err)
+	}
+	return &Modem{dev: dev, to: to}, nil
+}
+
+// Send texts m to every recipient
+func (md *Modem) Send(ctx context.Context, m Message) error {
+	if err := md.dev.SendSMS(ctx, smsText(m, true), md.to...); err != nil {
+		return fmt.Errorf("sms: %w", err)
+	}
+	return nil
+}
+
+// GatewayConfig sends SMS through an HTTP gateway, one request per
+// recipient. The defaults post {"to": ..., "text": ...} as JSON; Twilio's
+// API, for one, takes form fields To, Body and From with basic auth.
+type GatewayConfig struct {
+	URL string `json:"url"`
+	// Form posts form fields instead of a JSON object
+	Form bool `json:"form,omitempty"`
+	// ToField and TextField name the recipient's and the text's fields;
+	// default "to" and "text"
+	ToField   string `json:"to_field,omitempty"`
+	TextField string `json:"text_field,omitempty"`
+	// Fields are sent along, e.g. {"From": "+4415112345678"}
+	Fields map[string]string `json:"fields,omitempty"`
+	// Username and Password log in with basic auth, or Token as a bearer
+	// token
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Token    string `json:"token,omitempty"`
+}
+
+// Gateway sends SMS through an HTTP gateway
+type Gateway struct {
+	cfg    GatewayConfig
+	to     []string
+	client *http.Client
+}
+
+// NewGateway creates a sender texting the numbers in to. tlsCfg and dial,
+// either of which may be nil, apply to the requests.
+func NewGateway(cfg GatewayConfig, to []string, tlsCfg *tls.Config, dial DialFunc) (*Gateway, error) {
+	if cfg.URL == "" || len(to) == 0 {
+		return nil, errors.New("sms: gateway url and recipients are required")
+	}
+	if u, err := url.Parse(cfg.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("sms: invalid gateway url %q", cfg.URL)
+	}
+	if cfg.ToField == "" {
+		cfg.ToField = "to"
+	}
+	if cfg.TextField == "" {
+		cfg.TextField = "text"
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsCfg
+	if dial != nil {
+		transport.DialContext = dial
+	}
+	return &Gateway{cfg: cfg, to: to, client: &http.Client{Transport: transport}}, nil
+}
+
+// Send texts m to every recipient
+func (g *Gateway) Send(ctx context.Context, m Message) error {
+	text := smsText(m, false)
+	var errs []error
+	for _, n := range g.to {
+		if err := g.post(ctx, n, text); err != nil {
+			errs = append(errs, fmt.Errorf("sms: to %s: %w", n, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (g *Gateway) post(ctx context.Context, number, text string) error {
+	fields := map[string]string{g.cfg.ToField: number, g.cfg.TextField: text}
+	for k, v := range g.cfg.Fields {
+		fields[k] = v
+	}
+	var body []byte
+	contentType := "application/json"
+	if g.cfg.Form {
+		form := url.Values{}
+		for k, v := range fields {
+			form.Set(k, v)
+		}
+		body, contentType = []byte(form.Encode()), "application/x-www-form-urlencoded"
+	} else {
+		body, _ = json.Marshal(fields)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	switch {
+	case g.cfg.Token != "":
+		req.Header.Set("Authorization", "Bearer "+g.cfg.Token)
+	case g.cfg.Username != "":
+		req.SetBasicAuth(g.cfg.Username, g.cfg.Password)
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+		first, _, _ := strings.Cut(strings.TrimSpace(string(msg)), "\n")
+		return fmt.Errorf("gateway: %s: %s", resp.Status, first)
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
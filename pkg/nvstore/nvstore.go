@@ -0,0 +1,240 @@
+// Package nvstore keeps a few small key-value records, such as calibration
+// constants and a board's identity, in an EEPROM on the board they
+// describe, so they travel with it rather than with the SD card.
+//
+// The memory is split in two slots written alternately. Each holds a
+// complete copy of the records with a sequence number and a checksum, so a
+// write interrupted by a power loss leaves the previous copy to fall back
+// on. A blank memory reads as an empty store.
+package nvstore
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sort"
+)
+
+// Memory is the storage below a store; hal.EEPROM implements it
+type Memory interface {
+	Size() int
+	ReadAt(ctx context.Context, p []byte, off int) error
+	WriteAt(ctx context.Context, p []byte, off int) error
+}
+
+// Slot header: magic, sequence number, payload length, CRC-32 of the
+// sequence number, length and payload
+const (
+	magic      = "NVS1"
+	headerSize = 4 + 4 + 2 + 4
+	maxKey     = 255
+	maxValue   = 65535
+)
+
+// ErrFull means the records don't fit in a slot
+var ErrFull = errors.New("nvstore: records don't fit in the memory")
+
+// Store holds the records of a memory. Changes are kept in memory until
+// Commit.
+type Store struct {
+	mem     Memory
+	slot    int // slot holding the current copy, -1 if none
+	seq     uint32
+	records map[string]string
+	dirty   bool
+}
+
+// Open reads the newest valid copy of the records from mem
+func Open(ctx context.Context, mem Memory) (*Store, error) {
+	s := &Store{mem: mem, slot: -1, records: make(map[string]string)}
+	if s.slotSize() <= headerSize {
+		return nil, fmt.Errorf("nvstore: a %d byte memory is too small", mem.Size())
+	}
+	var best []byte
+	for i := 0; i < 2; i++ {
+		seq, payload, err := s.readSlot(ctx, i)
+		if err != nil {
+			return nil, err
+		}
+		if payload == nil {
+			continue
+		}
+		if s.slot < 0 || int32(seq-s.seq) > 0 {
+			s.slot, s.seq, best = i, seq, payload
+		}
+	}
+	if s.slot >= 0 {
+		records, err := decode(best)
+		if err != nil {
+			return nil, fmt.Errorf("nvstore: slot %d: %w", s.slot, err)
+		}
+		s.records = records
+	}
+	return s, nil
+}
+
+func (s *Store) slotSize() int { return s.mem.Size() / 2 }
+
+// readSlot returns the sequence number and payload of a slot, or a nil
+// payload if it holds no valid copy
+func (s *Store) readSlot(ctx context.Context, i int) (uint32, []byte, error) {
+	base := i * s.slotSize()
+	header := make([]byte, headerSize)
+	if err := s.mem.ReadAt(ctx, header, base); err != nil {
+		return 0, nil, err
+	}
+	if string(header[:4]) != magic {
+		return 0, nil, nil
+	}
+	seq := binary.BigEndian.Uint32(header[4:])
+	n := int(binary.BigEndian.Uint16(header[8:]))
+	if n > s.slotSize()-headerSize {
+		return 0, nil, nil
+	}
+	payload := make([]byte, n)
+	if err := s.mem.ReadAt(ctx, payload, base+headerSize); err != nil {
+		return 0, nil, err
+	}
+	if checksum(header[4:10], payload) != binary.BigEndian.Uint32(header[10:]) {
+		return 0, nil, nil
+	}
+	return seq, payload, nil
+}
+
+func checksum(header, payload []byte) uint32 {
+	return crc32.Update(crc32.ChecksumIEEE(header), crc32.IEEETable, payload)
+}
+
+// Get returns the value of a key
+func (s *Store) Get(key string) (string, bool) {
+	v, ok := s.records[key]
+	return v, ok
+}
+
+// Keys returns the keys in order
+func (s *Store) Keys() []string {
+	keys := make([]string, 0, len(s.records))
+	for k := range s.records {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Set adds or replaces a record, failing with ErrFull if the records would
+// no longer fit
+func (s *Store) Set(key, value string) error {
+	if key == "" || len(key) > maxKey {
+		return fmt.Errorf("nvstore: key must be 1 to %d bytes", maxKey)
+	}
+	if len(value) > maxValue {
+		return fmt.Errorf("nvstore: value of %s over %d bytes", key, maxValue)
+	}
+	old, had := s.records[key]
+	if had && old == value {
+		return nil
+	}
+	s.records[key] = value
+	if s.Free() < 0 {
+		if had {
+			s.records[key] = old
+		} else {
+			delete(s.records, key)
+		}
+		return fmt.Errorf("%w: %s needs %d bytes", ErrFull, key, 3+len(key)+len(value))
+	}
+	s.dirty = true
+	return nil
+}
+
+// Delete removes a record
+func (s *Store) Delete(key string) {
+	if _, ok := s.records[key]; ok {
+		delete(s.records, key)
+		s.dirty = true
+	}
+}
+
+// Free returns the bytes left for records, counting 3 bytes of overhead
+// per record
+func (s *Store) Free() int {
+	used := 0
+	for k, v := range s.records {
+		used += 3 + len(k) + len(v)
+	}
+	return s.slotSize() - headerSize - used
+}
+
+// Commit writes the records to the slot not holding the current copy,
+// which stays valid until the write completes. It does nothing if there
+// are no changes.
+func (s *Store) Commit(ctx context.Context) error {
+	if !s.dirty {
+		return nil
+	}
+	payload := encode(s.records)
+	slot, seq := 0, s.seq+1
+	if s.slot == 0 {
+		slot = 1
+	}
+	buf := make([]byte, headerSize, headerSize+len(payload))
+	copy(buf, magic)
+	binary.BigEndian.PutUint32(buf[4:], seq)
+	binary.BigEndian.PutUint16(buf[8:], uint16(len(payload)))
+	binary.BigEndian.PutUint32(buf[10:], checksum(buf[4:10], payload))
+	buf = append(buf, payload...)
+	if err := s.mem.WriteAt(ctx, buf, slot*s.slotSize()); err != nil {
+		return err
+	}
+
+	// Read back, as a write-protected memory acknowledges writes it ignores
+	got, gotPayload, err := s.readSlot(ctx, slot)
+	if err != nil {
+		return err
+	}
+	if gotPayload == nil || got != seq {
+		return errors.New("nvstore: verify failed (is the memory write-protected?)")
+	}
+	s.slot, s.seq, s.dirty = slot, seq, false
+	return nil
+}
+
+// encode lays out records as key length (1 byte), key, value length
+// (2 bytes), value, in key order
+func encode(records map[string]string) []byte {
+	keys := make([]string, 0, len(records))
+	for k := range records {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b []byte
+	for _, k := range keys {
+		v := records[k]
+		b = append(b, byte(len(k)))
+		b = append(b, k...)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(v)))
+		b = append(b, v...)
+	}
+	return b
+}
+
+func decode(b []byte) (map[string]string, error) {
+	records := make(map[string]string)
+	for len(b) > 0 {
+		n := int(b[0])
+		if len(b) < 1+n+2 {
+			return nil, errors.New("truncated record")
+		}
+		key := string(b[1 : 1+n])
+		b = b[1+n:]
+		m := int(binary.BigEndian.Uint16(b))
+		if len(b) < 2+m {
+			return nil, fmt.Errorf("truncated value of %s", key)
+		}
+		records[key] = string(b[2 : 2+m])
+		b = b[2+m:]
+	}
+	return records, nil
+}
@@ -0,0 +1,111 @@
+package nvstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// memory is a blank EEPROM that can fail writes after a number of bytes
+type memory struct {
+	data      []byte
+	failAfter int // bytes to write before failing, 0 for never
+}
+
+func newMemory(size int) *memory {
+	m := &memory{data: make([]byte, size)}
+	for i := range m.data {
+		m.data[i] = 0xFF
+	}
+	return m
+}
+
+func (m *memory) Size() int { return len(m.data) }
+
+func (m *memory) ReadAt(ctx context.Context, p []byte, off int) error {
+	copy(p, m.data[off:])
+	return nil
+}
+
+func (m *memory) WriteAt(ctx context.Context, p []byte, off int) error {
+	if m.failAfter > 0 && len(p) > m.failAfter {
+		copy(m.data[off:], p[:m.failAfter])
+		return errors.New("power lost")
+	}
+	copy(m.data[off:], p)
+	return nil
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	mem := newMemory(256)
+	s, err := Open(ctx, mem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Keys()) != 0 {
+		t.Fatalf("blank memory has keys %v", s.Keys())
+	}
+	if err := s.Set("serial", "CB-0042"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set("revision", "B"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Open(ctx, mem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := s.Get("serial"); v != "CB-0042" {
+		t.Errorf("serial %q", v)
+	}
+	if keys := s.Keys(); len(keys) != 2 || keys[0] != "revision" {
+		t.Errorf("keys %v", keys)
+	}
+
+	// A second copy goes to the other slot, and wins once complete
+	s.Delete("revision")
+	if err := s.Set("serial", "CB-0043"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	s, _ = Open(ctx, mem)
+	if v, _ := s.Get("serial"); v != "CB-0043" || len(s.Keys()) != 1 {
+		t.Errorf("after update: serial %q, keys %v", v, s.Keys())
+	}
+
+	// An interrupted write leaves the previous copy
+	s.Set("serial", "CB-0044")
+	mem.failAfter = 10
+	if err := s.Commit(ctx); err == nil {
+		t.Fatal("interrupted commit succeeded")
+	}
+	mem.failAfter = 0
+	s, _ = Open(ctx, mem)
+	if v, _ := s.Get("serial"); v != "CB-0043" {
+		t.Errorf("after interrupted write: serial %q, want CB-0043", v)
+	}
+}
+
+func TestStoreFull(t *testing.T) {
+	s, err := Open(context.Background(), newMemory(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 32 byte slots leave 18 bytes: one record of 15 fits
+	if err := s.Set("k", string(make([]byte, 12))); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set("x", "y"); !errors.Is(err, ErrFull) {
+		t.Fatalf("got %v, want ErrFull", err)
+	}
+	if _, ok := s.Get("x"); ok {
+		t.Error("record kept after ErrFull")
+	}
+}
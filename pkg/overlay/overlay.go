@@ -0,0 +1,266 @@
+// Package overlay enables and disables device-tree overlays on distributions
+// that support them, so the I2C/SPI/PWM pin functions needed by the HAL can
+// be switched on without hand-editing boot files.
+package overlay
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrUnsupported is returned when no overlay mechanism is found on this system
+var ErrUnsupported = errors.New("device-tree overlays are not supported on this system")
+
+// Manager is a distribution-specific overlay mechanism
+type Manager interface {
+	// Name identifies the mechanism, e.g. "armbianEnv.txt"
+	Name() string
+	// Available lists overlays that can be enabled
+	Available() ([]string, error)
+	// Enabled lists overlays currently enabled
+	Enabled() ([]string, error)
+	Enable(name string) error
+	Disable(name string) error
+	// NeedsReboot reports whether changes take effect only after a reboot
+	NeedsReboot() bool
+}
+
+// envFiles are boot environment files using an "overlays=a b c" line
+var envFiles = []struct {
+	path   string
+	dtbDir string
+}{
+	{"/boot/armbianEnv.txt", "/boot/dtb"},
+	{"/boot/uEnv.txt", "/boot/dtbs"},
+	{"/boot/extlinux/uEnv.txt", "/boot/dtbs"},
+}
+
+// Detect returns the overlay mechanism available on this system. Runtime
+// configfs overlays are preferred; otherwise a boot environment file is used.
+func Detect() (Manager, error) {
+	if fi, err := os.Stat(configfsRoot); err == nil && fi.IsDir() {
+		return &configfs{root: configfsRoot, dtbDirs: []string{"/lib/firmware", "/boot/overlays"}}, nil
+	}
+	for _, f := range envFiles {
+		if _, err := os.Stat(f.path); err == nil {
+			return NewEnvFile(f.path, f.dtbDir), nil
+		}
+	}
+	if board, err := os.ReadFile("/proc/device-tree/model"); err == nil && strings.Contains(strings.ToLower(string(board)), "milk-v duo") {
+		return nil, fmt.Errorf("%w: Milk-V Duo images configure pin functions with duo-pinmux instead", ErrUnsupported)
+	}
+	return nil, ErrUnsupported
+}
+
+// EnvFile manages the "overlays=" entry of a U-Boot environment text file
+type EnvFile struct {
+	path   string
+	dtbDir string
+}
+
+// NewEnvFile manages overlays listed in path, with .dtbo files under dtbDir
+func NewEnvFile(path, dtbDir string) *EnvFile {
+	return &EnvFile{path: path, dtbDir: dtbDir}
+}
+
+// Name returns the environment file name
+func (e *EnvFile) Name() string { return filepath.Base(e.path) }
+
+// NeedsReboot is always true: the bootloader applies overlays at boot
+func (e *EnvFile) NeedsReboot() bool { return true }
+
+// Available lists .dtbo files below the dtb directory
+func (e *EnvFile) Available() ([]string, error) {
+	return findOverlays(e.dtbDir)
+}
+
+// Enabled returns the overlays named on the overlays= line
+func (e *EnvFile) Enabled() ([]string, error) {
+	lines, err := e.read()
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		if v, ok := strings.CutPrefix(line, "overlays="); ok {
+			return strings.Fields(v), nil
+		}
+	}
+	return nil, nil
+}
+
+// Enable appends name to the overlays= line
+func (e *EnvFile) Enable(name string) error {
+	return e.update(func(set []string) []string {
+		for _, s := range set {
+			if s == name {
+				return set
+			}
+		}
+		return append(set, name)
+	})
+}
+
+// Disable removes name from the overlays= line
+func (e *EnvFile) Disable(name string) error {
+	return e.update(func(set []string) []string {
+		out := set[:0]
+		for _, s := range set {
+			if s != name {
+				out = append(out, s)
+			}
+		}
+		return out
+	})
+}
+
+func (e *EnvFile) read() ([]string, error) {
+	data, err := os.ReadFile(e.path)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimRight(string(data), "\n"), "\n"), nil
+}
+
+func (e *EnvFile) update(change func([]string) []string) error {
+	lines, err := e.read()
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for i, line := range lines {
+		if v, ok := strings.CutPrefix(line, "overlays="); ok {
+			lines[i] = "overlays=" + strings.Join(change(strings.Fields(v)), " ")
+			found = true
+		}
+	}
+	if !found {
+		lines = append(lines, "overlays="+strings.Join(change(nil), " "))
+	}
+
+	// Keep a backup: a broken boot environment is hard to recover remotely
+	if err := copyFile(e.path, e.path+".bak"); err != nil {
+		return fmt.Errorf("failed to back up %s: %w", e.path, err)
+	}
+	tmp := e.path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, e.path)
+}
+
+const configfsRoot = "/sys/kernel/config/device-tree/overlays"
+
+// configfs applies overlays at runtime through the device-tree configfs interface
+type configfs struct {
+	root    string
+	dtbDirs []string
+}
+
+func (c *configfs) Name() string      { return "configfs" }
+func (c *configfs) NeedsReboot() bool { return false }
+
+func (c *configfs) Available() ([]string, error) {
+	var all []string
+	for _, dir := range c.dtbDirs {
+		names, _ := findOverlays(dir)
+		all = append(all, names...)
+	}
+	sort.Strings(all)
+	return all, nil
+}
+
+func (c *configfs) Enabled() ([]string, error) {
+	entries, err := os.ReadDir(c.root)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names, nil
+}
+
+func (c *configfs) Enable(name string) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	dtbo, err := c.find(name)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(c.root, name)
+	if err := os.Mkdir(dir, 0755); err != nil && !errors.Is(err, os.ErrExist) {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "dtbo"), dtbo, 0644); err != nil {
+		os.Remove(dir)
+		return fmt.Errorf("kernel rejected overlay %s: %w", name, err)
+	}
+	return nil
+}
+
+func (c *configfs) Disable(name string) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	return os.Remove(filepath.Join(c.root, name))
+}
+
+// find reads name.dtbo from the first dtbDir holding it, directly or one
+// directory down
+func (c *configfs) find(name string) ([]byte, error) {
+	for _, dir := range c.dtbDirs {
+		matches, _ := filepath.Glob(filepath.Join(dir, "*", name+".dtbo"))
+		matches = append([]string{filepath.Join(dir, name+".dtbo")}, matches...)
+		for _, m := range matches {
+			if data, err := os.ReadFile(m); err == nil {
+				return data, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("overlay %s.dtbo not found in %v", name, c.dtbDirs)
+}
+
+// checkName rejects overlay names that would reach outside the configfs
+// and dtb directories once joined to them, or match other files as a glob
+func checkName(name string) error {
+	if name == "" || name == "." || strings.Contains(name, "..") || strings.ContainsAny(name, "/*?[\x00") {
+		return fmt.Errorf("invalid overlay name %q", name)
+	}
+	return nil
+}
+
+// findOverlays returns overlay names (without .dtbo) found below dir
+func findOverlays(dir string) ([]string, error) {
+	seen := make(map[string]bool)
+	err := filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !d.IsDir() && strings.HasSuffix(p, ".dtbo") {
+			seen[strings.TrimSuffix(d.Name(), ".dtbo")] = true
+		}
+		return nil
+	})
+	names := make([]string, 0, len(seen))
+	for n := range seen {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names, err
+}
+
+func copyFile(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, 0644)
+}
@@ -0,0 +1,268 @@
+// Package procstat reports the resource usage of the running process: Go
+// runtime statistics and, from /proc, open file descriptors, resident
+// memory and context switches. Leaks in long-running daemons show up here
+// long before the board runs out of memory or descriptors.
+package procstat
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"riscv-dev/pkg/metrics"
+)
+
+// Stats is a snapshot of the process's resource usage. Values read from
+// /proc are -1 where it is unavailable.
+type Stats struct {
+	Goroutines   int
+	HeapBytes    uint64        // allocated heap objects
+	SysBytes     uint64        // obtained from the OS by the runtime
+	GCCount      uint32        // completed GC cycles
+	GCPause      time.Duration // the most recent stop-the-world pause
+	GCPauseTotal time.Duration
+	RSSBytes     int64
+	RSSAnonBytes int64 // resident memory not backed by files
+	OpenFDs      int
+	MaxFDs       int64 // soft RLIMIT_NOFILE
+	// Context switches since the process started: voluntary ones are
+	// waits for I/O or locks, involuntary ones are preemptions
+	VoluntarySwitches   int64
+	InvoluntarySwitches int64
+}
+
+// Read takes a snapshot. It briefly stops the world to read memory
+// statistics, so frequent callers should share one through a Sampler.
+func Read() Stats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	s := Stats{
+		Goroutines:   runtime.NumGoroutine(),
+		HeapBytes:    m.HeapAlloc,
+		SysBytes:     m.Sys,
+		GCCount:      m.NumGC,
+		GCPauseTotal: time.Duration(m.PauseTotalNs),
+		RSSBytes:     -1,
+		RSSAnonBytes: -1,
+		OpenFDs:      -1,
+		MaxFDs:       -1,
+
+		VoluntarySwitches:   -1,
+		InvoluntarySwitches: -1,
+	}
+	if m.NumGC > 0 {
+		s.GCPause = time.Duration(m.PauseNs[(m.NumGC+255)%256])
+	}
+	if entries, err := os.ReadDir("/proc/self/fd"); err == nil {
+		s.OpenFDs = len(entries) - 1 // less the one reading the directory
+	}
+	var lim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &lim); err == nil {
+		s.MaxFDs = int64(lim.Cur)
+	}
+	readStatus(&s)
+	return s
+}
+
+// readStatus fills in the fields read from /proc: resident memory for
+// the process, and context switches summed over its threads as the Go
+// runtime spreads work across several (those of exited threads are lost)
+func readStatus(s *Stats) {
+	status := statusFields("/proc/self/status")
+	if v, ok := status["VmRSS"]; ok {
+		s.RSSBytes = v * 1024 // in kB
+	}
+	if v, ok := status["RssAnon"]; ok {
+		s.RSSAnonBytes = v * 1024
+	}
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return
+	}
+	s.VoluntarySwitches, s.InvoluntarySwitches = 0, 0
+	for _, t := range tasks {
+		fields := statusFields("/proc/self/task/" + t.Name() + "/status")
+		s.VoluntarySwitches += fields["voluntary_ctxt_switches"]
+		s.InvoluntarySwitches += fields["nonvoluntary_ctxt_switches"]
+	}
+}
+
+// MemoryLimit returns the RAM the process may use: the memory limit of
+// its cgroup, if it has one, or else the board's total memory. It returns
+// -1 if neither can be read.
+func MemoryLimit() int64 {
+	limit := int64(-1)
+	if v, ok := statusFields("/proc/meminfo")["MemTotal"]; ok {
+		limit = v * 1024
+	}
+	if v := cgroupMemoryLimit(); v > 0 && (limit < 0 || v < limit) {
+		limit = v
+	}
+	return limit
+}
+
+// cgroupMemoryLimit returns the memory limit of the process's cgroup, from
+// memory.max (cgroup v2) or memory.limit_in_bytes (v1), or -1 for none
+func cgroupMemoryLimit() int64 {
+	b, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return -1
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		// hierarchy-ID:controllers:path; v2 has the ID 0 and no controllers
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		var path string
+		switch {
+		case parts[0] == "0" && parts[1] == "":
+			path = "/sys/fs/cgroup" + parts[2] + "/memory.max"
+		case strings.Contains(","+parts[1]+",", ",memory,"):
+			path = "/sys/fs/cgroup/memory" + parts[2] + "/memory.limit_in_bytes"
+		default:
+			continue
+		}
+		v, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		// "max", or for v1 a huge number, means no limit
+		if n, err := strconv.ParseInt(strings.TrimSpace(string(v)), 10, 64); err == nil && n < 1<<62 {
+			return n
+		}
+	}
+	return -1
+}
+
+// clockTicks is the unit of times in /proc/<pid>/stat, USER_HZ, which is
+// 100 on every architecture Linux runs on
+const clockTicks = 100
+
+// StartTime returns when the process was started, to within 10ms, from
+// its start after boot in /proc/self/stat and the time since boot in
+// /proc/uptime
+func StartTime() (time.Time, error) {
+	now := time.Now()
+	stat, err := os.ReadFile("/proc/self/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+	// The command may contain spaces and parentheses; the fields after
+	// it start with the state, the third field, and starttime is 22nd
+	var fields []string
+	if i := strings.LastIndexByte(string(stat), ')'); i >= 0 {
+		fields = strings.Fields(string(stat[i+1:]))
+	}
+	if len(fields) < 20 {
+		return time.Time{}, fmt.Errorf("procstat: malformed /proc/self/stat")
+	}
+	ticks, err := strconv.ParseInt(fields[19], 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("procstat: start time: %w", err)
+	}
+	b, err := os.ReadFile("/proc/uptime")
+	if err != nil {
+		return time.Time{}, err
+	}
+	f := strings.Fields(string(b))
+	if len(f) == 0 {
+		return time.Time{}, fmt.Errorf("procstat: malformed /proc/uptime")
+	}
+	up, err := strconv.ParseFloat(f[0], 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("procstat: uptime: %w", err)
+	}
+	running := time.Duration(up*float64(time.Second)) - time.Duration(ticks)*time.Second/clockTicks
+	return now.Add(-running), nil
+}
+
+// statusFields returns the numeric fields of a /proc status file
+func statusFields(path string) map[string]int64 {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	values := make(map[string]int64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			continue
+		}
+		if n, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+			values[key] = n
+		}
+	}
+	return values
+}
+
+// Sampler caches snapshots so that several readers within MaxAge of each
+// other share one
+type Sampler struct {
+	MaxAge time.Duration
+
+	mu    sync.Mutex
+	last  Stats
+	taken time.Time
+}
+
+// Get returns a snapshot no older than MaxAge
+func (s *Sampler) Get() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.taken.IsZero() || time.Since(s.taken) >= s.MaxAge {
+		s.last, s.taken = Read(), time.Now()
+	}
+	return s.last
+}
+
+// Register exposes the statistics in r under the usual Prometheus client
+// names (go_goroutines, process_open_fds and so on), read at scrape time
+func Register(r *metrics.Registry) {
+	s := &Sampler{MaxAge: time.Second}
+	r.GaugeFunc("go_goroutines", "Number of goroutines that currently exist", func() float64 {
+		return float64(s.Get().Goroutines)
+	})
+	r.GaugeFunc("go_memstats_heap_alloc_bytes", "Bytes of allocated heap objects", func() float64 {
+		return float64(s.Get().HeapBytes)
+	})
+	r.GaugeFunc("go_memstats_sys_bytes", "Bytes obtained from the OS by the Go runtime", func() float64 {
+		return float64(s.Get().SysBytes)
+	})
+	r.CounterFunc("go_gc_cycles_total", "Completed garbage collection cycles", func() float64 {
+		return float64(s.Get().GCCount)
+	})
+	r.GaugeFunc("go_gc_last_pause_seconds", "Duration of the most recent garbage collection pause", func() float64 {
+		return s.Get().GCPause.Seconds()
+	})
+	r.CounterFunc("go_gc_pause_seconds_total", "Time spent in garbage collection pauses", func() float64 {
+		return s.Get().GCPauseTotal.Seconds()
+	})
+	r.GaugeFunc("process_resident_memory_bytes", "Resident memory size in bytes", func() float64 {
+		return float64(s.Get().RSSBytes)
+	})
+	r.GaugeFunc("process_open_fds", "Number of open file descriptors", func() float64 {
+		return float64(s.Get().OpenFDs)
+	})
+	r.GaugeFunc("process_max_fds", "Maximum number of open file descriptors", func() float64 {
+		return float64(s.Get().MaxFDs)
+	})
+	r.CounterFunc("process_voluntary_context_switches_total", "Context switches while waiting for I/O or locks", func() float64 {
+		return float64(s.Get().VoluntarySwitches)
+	})
+	r.CounterFunc("process_involuntary_context_switches_total", "Context switches by preemption", func() float64 {
+		return float64(s.Get().InvoluntarySwitches)
+	})
+}
@@ -0,0 +1,186 @@
+package realip
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoHeader is returned for connections from a trusted proxy that don't
+// start with a PROXY protocol header
+var ErrNoHeader = errors.New("missing PROXY protocol header")
+
+// v2Signature starts every PROXY protocol v2 header
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// headerTimeout bounds the wait for a proxy to send its header
+const headerTimeout = 5 * time.Second
+
+// Listener accepts connections carrying a PROXY protocol header. Only
+// connections from trusted proxies must (and may) carry one; others are
+// passed through unchanged.
+type Listener struct {
+	net.Listener
+	Trusted Trusted
+}
+
+// NewListener wraps l to honour PROXY protocol headers from trusted
+func NewListener(l net.Listener, trusted Trusted) *Listener {
+	return &Listener{Listener: l, Trusted: trusted}
+}
+
+// Accept returns the next connection. The header is read on the first
+// call to RemoteAddr or Read, not here, so a slow proxy doesn't hold up
+// other connections.
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.Trusted.containsAddr(conn.RemoteAddr().String()) {
+		return conn, nil
+	}
+	return &Conn{Conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+// Conn is a connection from a trusted proxy
+type Conn struct {
+	net.Conn
+	r      *bufio.Reader
+	once   sync.Once
+	remote net.Addr // client address from the header; nil for LOCAL/UNKNOWN
+	err    error
+}
+
+func (c *Conn) init() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(headerTimeout))
+		c.remote, c.err = readHeader(c.r)
+		c.Conn.SetReadDeadline(time.Time{})
+	})
+}
+
+// Read reads data following the header
+func (c *Conn) Read(p []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(p)
+}
+
+// RemoteAddr returns the client address from the header, or the proxy's
+// own address if the header didn't carry one
+func (c *Conn) RemoteAddr() net.Addr {
+	c.init()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// ProxyAddr returns the address of the proxy itself
+func (c *Conn) ProxyAddr() net.Addr { return c.Conn.RemoteAddr() }
+
+// NetConn returns the underlying connection, e.g. to set TCP options
+func (c *Conn) NetConn() net.Conn { return c.Conn }
+
+// Err reads the header if needed and returns the error if it was invalid
+// or missing. It returns nil for connections not accepted through a
+// Listener, so servers can check every connection.
+func Err(conn net.Conn) error {
+	c, ok := conn.(*Conn)
+	if !ok {
+		return nil
+	}
+	c.init()
+	return c.err
+}
+
+// readHeader parses a v1 or v2 header and returns the source address
+func readHeader(r *bufio.Reader) (net.Addr, error) {
+	peek, err := r.Peek(len(v2Signature))
+	if err != nil && len(peek) < 5 {
+		return nil, fmt.Errorf("PROXY header: %w", err)
+	}
+	switch {
+	case bytes.Equal(peek, v2Signature):
+		return readV2(r)
+	case bytes.HasPrefix(peek, []byte("PROXY")):
+		return readV1(r)
+	}
+	return nil, ErrNoHeader
+}
+
+// readV1 parses "PROXY TCP4 src dst sport dport\r\n"
+func readV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < 107 { // the longest valid v1 header
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("PROXY v1 header: %w", err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("PROXY v1 header too long")
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid PROXY v1 header %q", strings.TrimSpace(string(line)))
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil || port < 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid PROXY v1 source %s:%s", fields[2], fields[4])
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// readV2 parses the binary header
+func readV2(r *bufio.Reader) (net.Addr, error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, fmt.Errorf("PROXY v2 header: %w", err)
+	}
+	verCmd, family := hdr[12], hdr[13]
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("PROXY v2 header: %w", err)
+	}
+
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("unsupported PROXY protocol version %d", verCmd>>4)
+	}
+	if verCmd&0x0f == 0 { // LOCAL: health check from the proxy itself
+		return nil, nil
+	}
+	switch family >> 4 {
+	case 1: // IPv4
+		if len(body) < 12 {
+			return nil, fmt.Errorf("short PROXY v2 IPv4 address block")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}, nil
+	case 2: // IPv6
+		if len(body) < 36 {
+			return nil, fmt.Errorf("short PROXY v2 IPv6 address block")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}, nil
+	}
+	return nil, nil // UNSPEC or Unix sockets: keep the proxy's address
+}
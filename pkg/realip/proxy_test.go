@@ -0,0 +1,63 @@
+package realip
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+// v2Header builds a PROXY v2 header for the seed corpus
+func v2Header(cmd, family byte, body []byte) []byte {
+	h := append([]byte{}, v2Signature...)
+	h = append(h, 0x20|cmd, family, 0, 0)
+	binary.BigEndian.PutUint16(h[14:], uint16(len(body)))
+	return append(h, body...)
+}
+
+// FuzzReadHeader feeds arbitrary bytes to the PROXY protocol parser. Only
+// trusted proxies reach it, but a misbehaving or compromised one must not
+// be able to crash the server or smuggle in a bogus address.
+func FuzzReadHeader(f *testing.F) {
+	v4 := []byte{192, 0, 2, 1, 10, 0, 0, 1, 0x30, 0x39, 0x1f, 0x90}
+	v6 := make([]byte, 36)
+	copy(v6, net.ParseIP("2001:db8::1"))
+	for _, seed := range [][]byte{
+		[]byte("PROXY TCP4 192.0.2.1 10.0.0.1 12345 8080\r\nhello"),
+		[]byte("PROXY TCP6 2001:db8::1 ::1 12345 8080\r\n"),
+		[]byte("PROXY UNKNOWN\r\n"),
+		[]byte("PROXY TCP4 192.0.2.1 10.0.0.1 99999 8080\r\n"),
+		[]byte("PROXY TCP4 192.0.2.1\r\n"),
+		[]byte("PROXY " + string(bytes.Repeat([]byte("x"), 200))),
+		[]byte("GET / HTTP/1.1\r\n"),
+		v2Header(1, 0x11, v4),
+		v2Header(1, 0x21, v6),
+		v2Header(0, 0x00, nil),
+		v2Header(1, 0x11, v4[:4]),
+		v2Header(1, 0x31, make([]byte, 216)),
+		v2Signature,
+		{},
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		addr, err := readHeader(bufio.NewReader(bytes.NewReader(data)))
+		if err != nil {
+			if addr != nil {
+				t.Fatalf("address %v returned with error %v", addr, err)
+			}
+			return
+		}
+		if addr == nil {
+			return
+		}
+		tcp, ok := addr.(*net.TCPAddr)
+		if !ok {
+			t.Fatalf("unexpected address type %T", addr)
+		}
+		if tcp.IP == nil || tcp.Port < 0 || tcp.Port > 65535 {
+			t.Fatalf("invalid address %v", tcp)
+		}
+	})
+}
@@ -0,0 +1,111 @@
+// Package realip recovers the real client address of connections that
+// arrive through a load balancer or reverse proxy: from the PROXY protocol
+// (v1 and v2) on TCP listeners, and from X-Forwarded-For on HTTP servers.
+// Both are only believed when the immediate peer is a trusted proxy, since
+// anyone else could send them to spoof their address.
+package realip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Trusted is a set of proxy networks whose forwarding information is
+// believed
+type Trusted []*net.IPNet
+
+// ParseTrusted parses a comma-separated list of CIDRs or single addresses,
+// e.g. "10.0.0.0/8, 192.168.1.1"
+func ParseTrusted(list string) (Trusted, error) {
+	var t Trusted
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid proxy address %q", s)
+			}
+			bits := 8 * len(ip.To4())
+			if bits == 0 {
+				bits = 128
+			}
+			s = fmt.Sprintf("%s/%d", s, bits)
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy network %q", s)
+		}
+		t = append(t, n)
+	}
+	return t, nil
+}
+
+// Contains reports whether ip belongs to a trusted proxy
+func (t Trusted) Contains(ip net.IP) bool {
+	for _, n := range t {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsAddr is Contains for a "host:port" or bare host string
+func (t Trusted) containsAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && t.Contains(ip)
+}
+
+// ClientIP returns the address of the client behind r. If the request
+// came from a trusted proxy, X-Forwarded-For is walked from the right,
+// skipping further trusted proxies, to the first address that isn't one.
+func ClientIP(r *http.Request, trusted Trusted) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !trusted.containsAddr(host) {
+		return host
+	}
+
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break // garbage: stop at the last address we can vouch for
+		}
+		host = hop
+		if !trusted.containsAddr(hop) {
+			break
+		}
+	}
+	return host
+}
+
+// Handler sets r.RemoteAddr to the client address found by ClientIP, so
+// handlers and logs further down see the real client
+func Handler(h http.Handler, trusted Trusted) http.Handler {
+	if len(trusted) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := ClientIP(r, trusted); ip != "" {
+			r2 := r.Clone(r.Context())
+			r2.RemoteAddr = net.JoinHostPort(ip, "0")
+			r = r2
+		}
+		h.ServeHTTP(w, r)
+	})
+}
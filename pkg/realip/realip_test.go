@@ -0,0 +1,45 @@
+package realip
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// FuzzClientIP feeds arbitrary X-Forwarded-For headers to ClientIP, which
+// must only ever return the peer or a well-formed address from the header
+func FuzzClientIP(f *testing.F) {
+	for _, seed := range []string{
+		"", "203.0.113.7", "203.0.113.7, 10.0.0.2", "evil, 203.0.113.7",
+		"203.0.113.7,,10.0.0.2", "2001:db8::7, 10.0.0.2", "[::1]:80", " , , ",
+		"10.0.0.2, 10.0.0.3", "unknown",
+	} {
+		f.Add(seed, "10.0.0.1:4321")
+	}
+	f.Add("203.0.113.7", "198.51.100.1:80")
+	f.Add("203.0.113.7", "not an address")
+	trusted, err := ParseTrusted("10.0.0.0/8, ::1")
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Fuzz(func(t *testing.T, xff, remote string) {
+		r := &http.Request{RemoteAddr: remote, Header: http.Header{}}
+		r.Header.Set("X-Forwarded-For", xff)
+		ip := ClientIP(r, trusted)
+
+		peer, _, err := net.SplitHostPort(remote)
+		if err != nil {
+			peer = remote
+		}
+		if ip == peer {
+			return
+		}
+		if !trusted.containsAddr(peer) {
+			t.Fatalf("untrusted peer %q: header %q believed, got %q", remote, xff, ip)
+		}
+		if net.ParseIP(ip) == nil || !strings.Contains(xff, ip) {
+			t.Fatalf("ClientIP = %q, not an address from %q", ip, xff)
+		}
+	})
+}
@@ -0,0 +1,258 @@
+// Package safestate puts the hardware into a safe state when the program
+// stops abnormally. Components register actions (LED off, relays open, PWM
+// to 0, motor stopped) that run, once and within a bounded time, on a
+// panic, on SIGTERM or SIGINT, and when a watchdog is about to reset the
+// board.
+package safestate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Reasons the safe state is entered, as passed to Enter
+const (
+	ReasonPanic    = "panic"
+	ReasonSignal   = "signal"
+	ReasonWatchdog = "watchdog"
+)
+
+// Default bounds on entering the safe state
+const (
+	DefaultTimeout       = 2 * time.Second
+	DefaultActionTimeout = 500 * time.Millisecond
+)
+
+// Action puts one component into its safe state. It should be idempotent
+// and return promptly once ctx is done.
+type Action func(ctx context.Context) error
+
+type entry struct {
+	id      uint64
+	name    string
+	timeout time.Duration
+	fn      Action
+}
+
+// State holds the actions that make the hardware safe and whether they
+// have run
+type State struct {
+	mu      sync.Mutex
+	entries []entry
+	nextID  uint64
+	timeout time.Duration
+
+	entered atomic.Bool
+	done    chan struct{}
+	result  error
+}
+
+// Default is the state used by the package-level functions
+var Default = New()
+
+// New creates a state with no actions, bounded by DefaultTimeout
+func New() *State {
+	return &State{timeout: DefaultTimeout, done: make(chan struct{})}
+}
+
+// Register adds an action to Default, see State.Register
+func Register(name string, fn Action) (unregister func()) {
+	return Default.Register(name, fn)
+}
+
+// RegisterTimeout adds an action to Default, see State.RegisterTimeout
+func RegisterTimeout(name string, d time.Duration, fn Action) (unregister func()) {
+	return Default.RegisterTimeout(name, d, fn)
+}
+
+// SetTimeout bounds the time Default's actions together may take
+func SetTimeout(d time.Duration) {
+	Default.SetTimeout(d)
+}
+
+// Enter runs Default's actions, see State.Enter
+func Enter(reason string) error {
+	return Default.Enter(reason)
+}
+
+// Entered reports whether Default's safe state has been entered
+func Entered() bool {
+	return Default.Entered()
+}
+
+// Register adds an action, bounded by DefaultActionTimeout, and returns a
+// function that removes it again, e.g. once the component has shut down
+// cleanly. Actions run in reverse order of registration, like deferred
+// calls, so a component registered after what it depends on is made safe
+// first.
+func (s *State) Register(name string, fn Action) (unregister func()) {
+	return s.RegisterTimeout(name, DefaultActionTimeout, fn)
+}
+
+// RegisterTimeout is Register with a bound for this action; 0 leaves it
+// bounded only by the overall timeout
+func (s *State) RegisterTimeout(name string, d time.Duration, fn Action) (unregister func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	id := s.nextID
+	s.entries = append(s.entries, entry{id: id, name: name, timeout: d, fn: fn})
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for i, e := range s.entries {
+			if e.id == id {
+				s.entries = append(s.entries[:i], s.entries[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// SetTimeout bounds the time all actions together may take
+func (s *State) SetTimeout(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.timeout = d
+}
+
+// Enter runs the registered actions and returns their errors. Only the
+// first call runs them; later and concurrent calls wait for it and return
+// its result. An action still running when its own bound or the overall
+// timeout expires is abandoned, so a hung driver can't keep the others
+// from running.
+func (s *State) Enter(reason string) error {
+	if s.entered.Swap(true) {
+		<-s.done
+		return s.result
+	}
+	defer close(s.done)
+
+	s.mu.Lock()
+	actions := make([]entry, len(s.entries))
+	copy(actions, s.entries)
+	total := s.timeout
+	s.mu.Unlock()
+
+	start := time.Now()
+	log.Printf("🛑 Entering safe state (%s): %d actions", reason, len(actions))
+	ctx, cancel := context.WithTimeout(context.Background(), total)
+	defer cancel()
+
+	var errs []error
+	for i := len(actions) - 1; i >= 0; i-- {
+		if err := run(ctx, actions[i]); err != nil {
+			log.Printf("❌ Safe state: %s: %v", actions[i].name, err)
+			errs = append(errs, fmt.Errorf("%s: %w", actions[i].name, err))
+		}
+	}
+	s.result = errors.Join(errs...)
+	log.Printf("🛑 Safe state reached in %v (%d of %d actions failed)", time.Since(start).Round(time.Millisecond), len(errs), len(actions))
+	return s.result
+}
+
+// run runs one action, giving up on it after its bound
+func run(ctx context.Context, e entry) error {
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	errc := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				errc <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		errc <- e.fn(ctx)
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("abandoned: %w", ctx.Err())
+	}
+}
+
+// Entered reports whether the safe state has been entered
+func (s *State) Entered() bool {
+	return s.entered.Load()
+}
+
+// Recover enters the safe state if the goroutine is panicking and then
+// panics again with the same value. Defer it first thing in main and in
+// each long-running goroutine that drives hardware; a panic in another
+// goroutine kills the process without running deferred calls elsewhere.
+func Recover() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	// The re-panic below reports this frame, so log where it came from
+	log.Printf("❌ panic: %v\n%s", r, debug.Stack())
+	Enter(ReasonPanic)
+	panic(r)
+}
+
+// Go runs fn in a new goroutine that enters the safe state if fn panics
+func Go(fn func()) {
+	go func() {
+		defer Recover()
+		fn()
+	}()
+}
+
+// Watchdog enters the safe state because a watchdog is about to reset the
+// board, e.g. when a feeder notices it has missed its deadline. systemd
+// signals an expired WatchdogSec= with SIGABRT, which NotifyContext
+// covers.
+func Watchdog() error {
+	return Enter(ReasonWatchdog)
+}
+
+// NotifyContext is signal.NotifyContext for SIGTERM and SIGINT that enters
+// the safe state before cancelling the context, so outputs are safe however
+// long the program then takes to shut down. It also handles SIGABRT, which
+// systemd sends when its watchdog expires: after entering the safe state the
+// signal is raised again, so the process dumps its goroutines and exits as
+// it would have. Calling stop restores the default behavior, so a second
+// SIGINT kills a program stuck shutting down.
+func NotifyContext(parent context.Context) (ctx context.Context, stop context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT, syscall.SIGABRT)
+	go func() {
+		select {
+		case sig := <-sigs:
+			if sig == syscall.SIGABRT {
+				Enter(ReasonWatchdog)
+				signal.Reset(syscall.SIGABRT)
+				syscall.Kill(os.Getpid(), syscall.SIGABRT)
+				return
+			}
+			Enter(ReasonSignal + " " + sig.String())
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			signal.Stop(sigs)
+			cancel()
+		})
+	}
+}
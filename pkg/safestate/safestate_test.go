@@ -0,0 +1,169 @@
+package safestate
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReverseOrder(t *testing.T) {
+	s := New()
+	var ran []string
+	record := func(name string) Action {
+		return func(ctx context.Context) error {
+			ran = append(ran, name)
+			return nil
+		}
+	}
+	s.Register("power", record("power"))
+	s.Register("relays", record("relays"))
+	unregister := s.Register("motor", record("motor"))
+	s.Register("leds", record("leds"))
+	unregister()
+
+	if err := s.Enter(ReasonSignal); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(ran, " "); got != "leds relays power" {
+		t.Errorf("ran %s, want leds relays power", got)
+	}
+}
+
+// hang blocks, ignoring ctx, until the test ends, as a wedged driver call
+// does
+func hang(t *testing.T) Action {
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	return func(ctx context.Context) error {
+		<-release
+		return nil
+	}
+}
+
+func TestActionTimeout(t *testing.T) {
+	s := New()
+	var ranAfter atomic.Bool
+	s.Register("relays", func(ctx context.Context) error {
+		ranAfter.Store(true)
+		return nil
+	})
+	s.RegisterTimeout("hung driver", 20*time.Millisecond, hang(t))
+
+	start := time.Now()
+	err := s.Enter(ReasonWatchdog)
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("entered in %v", d)
+	}
+	if err == nil || !strings.Contains(err.Error(), "hung driver: abandoned") || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v", err)
+	}
+	// The hung action is abandoned, and the next one still runs
+	if !ranAfter.Load() {
+		t.Error("action after the hung one didn't run")
+	}
+}
+
+func TestOverallTimeout(t *testing.T) {
+	s := New()
+	s.SetTimeout(30 * time.Millisecond)
+	var ran atomic.Bool
+	s.RegisterTimeout("never reached", 0, func(ctx context.Context) error {
+		ran.Store(true)
+		return nil
+	})
+	s.RegisterTimeout("hung driver", 0, hang(t))
+
+	start := time.Now()
+	err := s.Enter(ReasonSignal)
+	if d := time.Since(start); d < 30*time.Millisecond || d > time.Second {
+		t.Errorf("entered in %v, want about 30ms", d)
+	}
+	if err == nil || !strings.Contains(err.Error(), "hung driver: abandoned") || !strings.Contains(err.Error(), "never reached: context deadline exceeded") {
+		t.Errorf("err = %v", err)
+	}
+	// Once the overall timeout expires the remaining actions aren't started
+	if ran.Load() {
+		t.Error("action ran after the overall timeout")
+	}
+}
+
+func TestPanickingAction(t *testing.T) {
+	s := New()
+	var ran atomic.Bool
+	s.Register("relays", func(ctx context.Context) error {
+		ran.Store(true)
+		return nil
+	})
+	s.Register("buggy", func(ctx context.Context) error {
+		var m map[string]int
+		m["x"] = 1
+		return nil
+	})
+
+	err := s.Enter(ReasonPanic)
+	if err == nil || !strings.Contains(err.Error(), "buggy: panic: assignment to entry in nil map") {
+		t.Errorf("err = %v", err)
+	}
+	if !ran.Load() {
+		t.Error("action after the panicking one didn't run")
+	}
+}
+
+func TestEnterOnce(t *testing.T) {
+	s := New()
+	var runs atomic.Int32
+	fail := errors.New("relay stuck")
+	release := make(chan struct{})
+	s.Register("relays", func(ctx context.Context) error {
+		runs.Add(1)
+		<-release
+		return fail
+	})
+	if s.Entered() {
+		t.Fatal("entered before Enter")
+	}
+
+	// Concurrent calls wait for the first and return its result
+	var wg sync.WaitGroup
+	errs := make([]error, 5)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = s.Enter(ReasonSignal)
+		}(i)
+	}
+	for !s.Entered() {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	if err := s.Enter(ReasonWatchdog); !errors.Is(err, fail) {
+		t.Errorf("later call: %v", err)
+	}
+	for i, err := range errs {
+		if !errors.Is(err, fail) {
+			t.Errorf("call %d: %v", i, err)
+		}
+	}
+	if n := runs.Load(); n != 1 {
+		t.Errorf("actions ran %d times", n)
+	}
+}
+
+func TestDefault(t *testing.T) {
+	var ran atomic.Bool
+	unregister := Register("led", func(ctx context.Context) error {
+		ran.Store(true)
+		return nil
+	})
+	defer unregister()
+	// Another state neither sees nor enters Default's actions
+	if err := New().Enter(ReasonSignal); err != nil || ran.Load() || Entered() {
+		t.Errorf("separate state: %v, ran %v, Default entered %v", err, ran.Load(), Entered())
+	}
+}
@@ -0,0 +1,342 @@
+// Package schedule matches times against windows written as times of day,
+// optionally on certain days, or as cron expressions:
+//
+//	22:00-06:00            every night, across midnight
+//	mon-fri 08:00-18:00    working hours
+//	sat,sun                all weekend
+//	sunset-30m-23:00       from half an hour before sunset
+//	dusk-dawn              while it is dark
+//	*/15 * * * *           the first minute of every quarter hour
+//	* 0-5,22-23 * 1-3 *    nights in the first quarter of the year
+//
+// Either end of a span may be a sun event (dawn, sunrise, sunset or dusk)
+// with an optional offset, computed for the day at the Coordinates given
+// to ParseAt. Sun times falling outside the day are held to its start or
+// end, as are those of events that don't happen that day: without a
+// sunset in the arctic summer, sunrise-sunset is all day and
+// sunset-sunrise never.
+//
+// A cron expression matches every minute its five fields (minute, hour,
+// day of month, month, day of week with 0 or 7 for Sunday) match, with
+// cron's rule that when both day fields are restricted either may match.
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Window is a recurring set of times
+type Window struct {
+	expr string
+	// Days and time of day form; days[weekday] and the ends of the span
+	days     [7]bool
+	from, to endpoint // to < from crosses midnight; from == to is all day
+	coords   *Coordinates
+	// Cron form
+	cron *cron
+}
+
+// endpoint is an end of a time span: a time of day, or a sun event and
+// an offset from it
+type endpoint struct {
+	minute int // since midnight, for a time of day
+	event  string
+	offset time.Duration
+}
+
+const minutesPerDay = 24 * 60
+
+// Parse parses a window expression without sun events
+func Parse(expr string) (*Window, error) {
+	return ParseAt(expr, nil)
+}
+
+// ParseAt parses a window expression whose sun events are computed at
+// coords
+func ParseAt(expr string, coords *Coordinates) (*Window, error) {
+	w := &Window{expr: expr, coords: coords}
+	fields := strings.Fields(strings.ToLower(expr))
+	if len(fields) == 5 {
+		c, err := parseCron(fields)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %q: %w", expr, err)
+		}
+		w.cron = c
+		return w, nil
+	}
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("schedule %q: want [days] HH:MM-HH:MM or a cron expression", expr)
+	}
+	days, span := "", ""
+	for _, f := range fields {
+		if isSpan(f) {
+			span = f
+		} else {
+			days = f
+		}
+	}
+	if len(fields) == 2 && (days == "" || span == "") {
+		return nil, fmt.Errorf("schedule %q: want [days] HH:MM-HH:MM", expr)
+	}
+	if days == "" {
+		for i := range w.days {
+			w.days[i] = true
+		}
+	} else if err := parseDays(days, &w.days); err != nil {
+		return nil, fmt.Errorf("schedule %q: %w", expr, err)
+	}
+	if span != "" {
+		var ok bool
+		if w.from, w.to, ok = parseSpan(span); !ok {
+			return nil, fmt.Errorf("schedule %q: bad time span %q", expr, span)
+		}
+		if (w.from.event != "" || w.to.event != "") && coords == nil {
+			return nil, fmt.Errorf("schedule %q: sun events need the site's coordinates", expr)
+		}
+	}
+	return w, nil
+}
+
+// String returns the expression the window was parsed from
+func (w *Window) String() string { return w.expr }
+
+// Contains reports whether t, in its own location, falls in the window. A
+// span crossing midnight belongs to the day it starts on.
+func (w *Window) Contains(t time.Time) bool {
+	if w.cron != nil {
+		return w.cron.matches(t)
+	}
+	min := t.Hour()*60 + t.Minute()
+	day := int(t.Weekday())
+	from, to := w.from.at(t, w.coords), w.to.at(t, w.coords)
+	switch {
+	case from == to && w.from.event == "" && w.to.event == "":
+		return w.days[day]
+	case from == to:
+		return false
+	case from < to:
+		return w.days[day] && min >= from && min < to
+	case min >= from:
+		return w.days[day]
+	default: // after midnight, in a span started the day before
+		return min < to && w.days[(day+6)%7]
+	}
+}
+
+// at returns the minute of t's day the endpoint falls on, from 0 to
+// minutesPerDay
+func (e endpoint) at(t time.Time, coords *Coordinates) int {
+	if e.event == "" {
+		return e.minute
+	}
+	sun, up, ok := coords.SunTimes(t, e.event)
+	if !ok {
+		// With the sun up all day, mornings come at its start and evenings
+		// at its end; with it down, the other way round
+		if morning := e.event == Dawn || e.event == Sunrise; up == morning {
+			return 0
+		}
+		return minutesPerDay
+	}
+	y, m, d := t.Date()
+	midnight := time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	switch sun = sun.Add(e.offset); {
+	case sun.Before(midnight):
+		return 0
+	case !sun.Before(midnight.AddDate(0, 0, 1)):
+		return minutesPerDay
+	}
+	return sun.Hour()*60 + sun.Minute()
+}
+
+// isSpan reports whether a field of a window expression is a time span
+// rather than days
+func isSpan(f string) bool {
+	if strings.Contains(f, ":") {
+		return true
+	}
+	for event := range altitudes {
+		if strings.HasPrefix(f, event) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSpan parses "22:00-06:00" or "sunset-30m-sunrise", trying each
+// dash in turn as the one between the ends
+func parseSpan(s string) (from, to endpoint, ok bool) {
+	for i := 0; i < len(s); i++ {
+		if s[i] != '-' {
+			continue
+		}
+		var err1, err2 error
+		from, err1 = parseEndpoint(s[:i])
+		to, err2 = parseEndpoint(s[i+1:])
+		if err1 == nil && err2 == nil {
+			return from, to, true
+		}
+	}
+	return from, to, false
+}
+
+// parseEndpoint parses "06:30", "sunset" or "sunrise+45m"
+func parseEndpoint(s string) (endpoint, error) {
+	if strings.Contains(s, ":") {
+		m, err := parseClock(s)
+		return endpoint{minute: m}, err
+	}
+	for event := range altitudes {
+		rest, ok := strings.CutPrefix(s, event)
+		switch {
+		case !ok:
+			continue
+		case rest == "":
+			return endpoint{event: event}, nil
+		case rest[0] != '+' && rest[0] != '-':
+			return endpoint{}, fmt.Errorf("bad offset %q", rest)
+		}
+		d, err := time.ParseDuration(rest)
+		if err != nil {
+			return endpoint{}, err
+		}
+		return endpoint{event: event, offset: d}, nil
+	}
+	return endpoint{}, fmt.Errorf("bad time %q", s)
+}
+
+func parseClock(s string) (int, error) {
+	h, m, ok := strings.Cut(s, ":")
+	hour, err1 := strconv.Atoi(h)
+	minute, err2 := strconv.Atoi(m)
+	if !ok || err1 != nil || err2 != nil || hour < 0 || hour > 24 || minute < 0 || minute > 59 || (hour == 24 && minute != 0) {
+		return 0, fmt.Errorf("bad time %q", s)
+	}
+	return (hour*60 + minute) % minutesPerDay, nil
+}
+
+var dayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+func dayNumber(s string) (int, bool) {
+	for i, name := range dayNames {
+		if s == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// parseDays parses "mon-fri", "sat,sun" or "mon,wed-fri"
+func parseDays(s string, days *[7]bool) error {
+	for _, part := range strings.Split(s, ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		a, ok1 := dayNumber(from)
+		b, ok2 := a, true
+		if isRange {
+			b, ok2 = dayNumber(to)
+		}
+		if !ok1 || !ok2 {
+			return fmt.Errorf("bad days %q", part)
+		}
+		for d := a; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == b {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// cron holds the allowed values of each field
+type cron struct {
+	minute, hour, dom, month, dow []bool
+	domAny, dowAny                bool
+}
+
+func parseCron(fields []string) (*cron, error) {
+	c := &cron{domAny: fields[2] == "*", dowAny: fields[4] == "*"}
+	var err error
+	for _, f := range []struct {
+		set      *[]bool
+		s        string
+		min, max int
+		names    []string
+	}{
+		{&c.minute, fields[0], 0, 59, nil},
+		{&c.hour, fields[1], 0, 23, nil},
+		{&c.dom, fields[2], 1, 31, nil},
+		{&c.month, fields[3], 1, 12, nil},
+		{&c.dow, fields[4], 0, 7, dayNames},
+	} {
+		if *f.set, err = parseCronField(f.s, f.min, f.max, f.names); err != nil {
+			return nil, err
+		}
+	}
+	c.dow[0] = c.dow[0] || c.dow[7] // 7 is Sunday too
+	return c, nil
+}
+
+// parseCronField parses a list of *, values, ranges and steps
+func parseCronField(s string, min, max int, names []string) ([]bool, error) {
+	set := make([]bool, max+1)
+	value := func(v string) (int, error) {
+		for i, name := range names {
+			if v == name {
+				return i, nil
+			}
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < min || n > max {
+			return 0, fmt.Errorf("bad value %q (want %d-%d)", v, min, max)
+		}
+		return n, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		span, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step < 1 {
+				return nil, fmt.Errorf("bad step %q", part)
+			}
+		}
+		lo, hi := min, max
+		if span != "*" {
+			from, to, isRange := strings.Cut(span, "-")
+			var err error
+			if lo, err = value(from); err != nil {
+				return nil, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = value(to); err != nil {
+					return nil, err
+				}
+			} else if hasStep {
+				hi = max
+			}
+			if hi < lo {
+				return nil, fmt.Errorf("bad range %q", span)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+func (c *cron) matches(t time.Time) bool {
+	if !c.minute[t.Minute()] || !c.hour[t.Hour()] || !c.month[int(t.Month())] {
+		return false
+	}
+	dom, dow := c.dom[t.Day()], c.dow[int(t.Weekday())]
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
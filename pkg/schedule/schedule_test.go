@@ -0,0 +1,157 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindow(t *testing.T) {
+	// 2024-01-01 is a Monday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 1, day, hour, minute, 0, 0, time.UTC)
+	}
+	for _, tc := range []struct {
+		expr string
+		t    time.Time
+		want bool
+	}{
+		{"08:00-18:00", at(1, 8, 0), true},
+		{"08:00-18:00", at(1, 18, 0), false},
+		{"08:00-18:00", at(1, 7, 59), false},
+		{"22:00-06:00", at(1, 23, 0), true},
+		{"22:00-06:00", at(2, 5, 59), true},
+		{"22:00-06:00", at(2, 6, 0), false},
+		{"22:00-06:00", at(2, 12, 0), false},
+		{"mon-fri 08:00-18:00", at(5, 9, 0), true},  // Friday
+		{"mon-fri 08:00-18:00", at(6, 9, 0), false}, // Saturday
+		{"fri 22:00-06:00", at(6, 3, 0), true},      // Friday night, into Saturday
+		{"fri 22:00-06:00", at(5, 3, 0), false},     // Thursday night
+		{"sat,sun", at(7, 12, 0), true},
+		{"sat,sun", at(8, 12, 0), false},
+		{"fri-mon", at(7, 0, 0), true}, // wraps through the weekend
+		{"fri-mon", at(3, 0, 0), false},
+		{"18:00-24:00", at(1, 23, 59), true},
+		{"*/15 * * * *", at(1, 10, 45), true},
+		{"*/15 * * * *", at(1, 10, 46), false},
+		{"* 0-5,22-23 * 1-3 *", at(1, 23, 10), true},
+		{"* 0-5,22-23 * 1-3 *", at(1, 12, 10), false},
+		{"* * * * sat,sun", at(7, 1, 1), true},
+		{"* * * * 7", at(7, 1, 1), true},
+		{"* * 1 * mon", at(8, 0, 0), true},  // either day field may match
+		{"* * 1 * mon", at(9, 0, 0), false}, // neither
+		{"* * 1 * *", at(8, 0, 0), false},
+	} {
+		w, err := Parse(tc.expr)
+		if err != nil {
+			t.Errorf("%q: %v", tc.expr, err)
+			continue
+		}
+		if got := w.Contains(tc.t); got != tc.want {
+			t.Errorf("%q contains %v: %v, want %v", tc.expr, tc.t.Format("Mon 15:04"), got, tc.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{
+		"", "25:00-06:00", "08:00", "mon fri", "someday 08:00-09:00",
+		"08:00-09:00 10:00-11:00", "60 * * * *", "* * * 13 *", "*/0 * * * *", "5-1 * * * *",
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("%q parsed", expr)
+		}
+	}
+}
+
+func TestSunTimes(t *testing.T) {
+	bst, cet := time.FixedZone("BST", 3600), time.FixedZone("CET", 3600)
+	london, berlin := Coordinates{51.5074, -0.1278}, Coordinates{52.52, 13.405}
+	for _, tc := range []struct {
+		coords Coordinates
+		day    time.Time
+		event  string
+		want   string // published times, to the minute
+	}{
+		{london, time.Date(2024, 6, 21, 0, 0, 0, 0, bst), Sunrise, "04:43"},
+		{london, time.Date(2024, 6, 21, 23, 59, 0, 0, bst), Sunset, "21:21"},
+		{berlin, time.Date(2024, 12, 21, 12, 0, 0, 0, cet), Sunrise, "08:15"},
+		{berlin, time.Date(2024, 12, 21, 12, 0, 0, 0, cet), Sunset, "15:54"},
+		{Coordinates{-33.8688, 151.2093}, time.Date(2024, 3, 20, 9, 0, 0, 0, time.FixedZone("AEDT", 11*3600)), Sunset, "19:07"},
+	} {
+		at, _, ok := tc.coords.SunTimes(tc.day, tc.event)
+		if !ok {
+			t.Errorf("%v %s: none", tc.coords, tc.event)
+			continue
+		}
+		want, _ := time.ParseInLocation("2006-01-02 15:04", tc.day.Format("2006-01-02 ")+tc.want, tc.day.Location())
+		if d := at.Sub(want); d < -2*time.Minute || d > 2*time.Minute {
+			t.Errorf("%v %s on %s: %s, want %s", tc.coords, tc.event, tc.day.Format("2006-01-02"), at.Format("15:04"), tc.want)
+		}
+	}
+
+	tromso := Coordinates{69.6492, 18.9553}
+	if _, up, ok := tromso.SunTimes(time.Date(2024, 6, 21, 12, 0, 0, 0, cet), Sunset); ok || !up {
+		t.Errorf("midsummer in Tromsø: sunset %v, up %v", ok, up)
+	}
+	if _, up, ok := tromso.SunTimes(time.Date(2024, 12, 21, 12, 0, 0, 0, cet), Sunrise); ok || up {
+		t.Errorf("midwinter in Tromsø: sunrise %v, up %v", ok, up)
+	}
+}
+
+func TestSunWindow(t *testing.T) {
+	bst := time.FixedZone("BST", 3600)
+	london := &Coordinates{51.5074, -0.1278}
+	// Sunrise 04:43 and sunset 21:21 on 2024-06-21, a Friday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 6, day, hour, minute, 0, 0, bst)
+	}
+	for _, tc := range []struct {
+		expr string
+		t    time.Time
+		want bool
+	}{
+		{"sunset-sunrise", at(21, 22, 0), true},
+		{"sunset-sunrise", at(21, 3, 0), true},
+		{"sunset-sunrise", at(21, 12, 0), false},
+		{"sunrise-sunset", at(21, 12, 0), true},
+		{"sunset-30m-23:00", at(21, 20, 55), true},
+		{"sunset-30m-23:00", at(21, 20, 45), false},
+		{"sunrise+1h-12:00", at(21, 5, 30), false},
+		{"sunrise+1h-12:00", at(21, 5, 50), true},
+		{"fri sunset-sunrise", at(22, 3, 0), true}, // Friday night, into Saturday
+		{"fri sunset-sunrise", at(21, 3, 0), false},
+		{"dusk-dawn", at(21, 22, 0), false},
+		{"dusk-dawn", at(21, 22, 15), true},
+		{"sunset+5h-sunset+6h", at(21, 23, 59), false}, // past midnight, held to its end
+	} {
+		w, err := ParseAt(tc.expr, london)
+		if err != nil {
+			t.Errorf("%q: %v", tc.expr, err)
+			continue
+		}
+		if got := w.Contains(tc.t); got != tc.want {
+			t.Errorf("%q contains %v: %v, want %v", tc.expr, tc.t.Format("Mon 15:04"), got, tc.want)
+		}
+	}
+
+	// Without a sunset in the arctic summer, the night never comes
+	tromso := &Coordinates{69.6492, 18.9553}
+	summer := time.Date(2024, 6, 21, 0, 30, 0, 0, time.FixedZone("CEST", 7200))
+	for expr, want := range map[string]bool{"sunset-sunrise": false, "sunrise-sunset": true} {
+		w, _ := ParseAt(expr, tromso)
+		if got := w.Contains(summer); got != want {
+			t.Errorf("%q at midnight in Tromsø: %v, want %v", expr, got, want)
+		}
+	}
+
+	for _, expr := range []string{"sunset-sunrise", "sunset+-sunrise", "sunset30m-23:00", "moonrise-sunset"} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("%q parsed without coordinates", expr)
+		}
+	}
+	for _, expr := range []string{"sunset+-sunrise", "sunset30m-23:00", "sunrise+1x-12:00"} {
+		if _, err := ParseAt(expr, london); err == nil {
+			t.Errorf("%q parsed", expr)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package schedule
+
+import (
+	"math"
+	"time"
+)
+
+// Coordinates place a site on Earth, for the times of sunrise and sunset
+type Coordinates struct {
+	Latitude  float64 `json:"latitude"`  // degrees, north positive
+	Longitude float64 `json:"longitude"` // degrees, east positive
+}
+
+// Sun events a time span may start or end at
+const (
+	Dawn    = "dawn"    // civil dawn, the sun 6° below the horizon
+	Sunrise = "sunrise" // the sun's upper edge on the horizon
+	Sunset  = "sunset"
+	Dusk    = "dusk" // civil dusk
+)
+
+// altitudes are the sun's altitudes at each event, in degrees; sunrise
+// and sunset allow for refraction and the sun's radius
+var altitudes = map[string]float64{Dawn: -6, Sunrise: -0.833, Sunset: -0.833, Dusk: -6}
+
+// SunTimes returns when the sun is at the altitude of event on the day
+// containing t, in t's location, computed with the NOAA sunrise equation
+// to within a minute or two. ok is false if it never is that day: above
+// the arctic circle in summer the sun doesn't set, and in winter it
+// doesn't rise. up then says whether the sun stays above the altitude.
+func (c Coordinates) SunTimes(t time.Time, event string) (at time.Time, up, ok bool) {
+	alt, known := altitudes[event]
+	if !known {
+		return time.Time{}, false, false
+	}
+	const rad = math.Pi / 180
+	y, m, d := t.Date()
+	noon := time.Date(y, m, d, 12, 0, 0, 0, t.Location())
+	jd := float64(noon.Unix())/86400 + 2440587.5
+	// Mean solar noon at the longitude nearest the day's clock noon
+	n := math.Round(jd - 2451545.0 + c.Longitude/360)
+	mean := n - c.Longitude/360
+	anomaly := math.Mod(357.5291+0.98560028*mean, 360)
+	center := 1.9148*math.Sin(anomaly*rad) + 0.02*math.Sin(2*anomaly*rad) + 0.0003*math.Sin(3*anomaly*rad)
+	ecliptic := math.Mod(anomaly+center+180+102.9372, 360)
+	transit := 2451545.0 + mean + 0.0053*math.Sin(anomaly*rad) - 0.0069*math.Sin(2*ecliptic*rad)
+	declination := math.Asin(math.Sin(ecliptic*rad) * math.Sin(23.4397*rad))
+	lat := c.Latitude * rad
+	cosHour := (math.Sin(alt*rad) - math.Sin(lat)*math.Sin(declination)) / (math.Cos(lat) * math.Cos(declination))
+	switch {
+	case cosHour < -1:
+		return time.Time{}, true, false
+	case cosHour > 1:
+		return time.Time{}, false, false
+	}
+	hour := math.Acos(cosHour) / rad / 360
+	if event == Dawn || event == Sunrise {
+		hour = -hour
+	}
+	secs := ((transit + hour) - 2440587.5) * 86400
+	return time.Unix(int64(math.Round(secs)), 0).In(t.Location()), false, true
+}
@@ -0,0 +1,333 @@
+package sensor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+// Curve kinds
+const (
+	CurvePiecewise  = "piecewise"
+	CurvePolynomial = "polynomial"
+)
+
+// Point pairs a raw count with the value a reference instrument measured
+type Point struct {
+	Raw   float64 `json:"raw"`
+	Value float64 `json:"value"`
+	// Compensation is the compensating channel's value when the point was
+	// taken, for fitting its drift
+	Compensation *float64 `json:"compensation,omitempty"`
+}
+
+// Curve converts raw counts to a physical value. A piecewise curve
+// interpolates linearly between its points, sorted by raw count, and
+// extends the end segments beyond them. A polynomial curve evaluates
+// Coefficients[0] + Coefficients[1]*raw + Coefficients[2]*raw² + ...
+type Curve struct {
+	Kind         string    `json:"kind"`
+	Points       []Point   `json:"points,omitempty"`
+	Coefficients []float64 `json:"coefficients,omitempty"`
+}
+
+// Apply converts a raw count
+func (c Curve) Apply(raw float64) float64 {
+	if c.Kind == CurvePiecewise {
+		return interpolate(c.Points, raw)
+	}
+	return polynomial(c.Coefficients, raw)
+}
+
+func (c Curve) validate() error {
+	switch c.Kind {
+	case CurvePiecewise:
+		if len(c.Points) < 2 {
+			return errors.New("a piecewise curve needs at least 2 points")
+		}
+		for i := 1; i < len(c.Points); i++ {
+			if c.Points[i].Raw <= c.Points[i-1].Raw {
+				return errors.New("piecewise points must be sorted by raw count, without repeats")
+			}
+		}
+	case CurvePolynomial:
+		if len(c.Coefficients) == 0 {
+			return errors.New("a polynomial curve needs coefficients")
+		}
+	default:
+		return fmt.Errorf("unknown curve kind %q (want %s or %s)", c.Kind, CurvePiecewise, CurvePolynomial)
+	}
+	return nil
+}
+
+// Compensation corrects a channel's drift with another channel, e.g. a
+// pressure sensor's with temperature. With d the compensating value minus
+// Reference, the correction Coefficients[0]*d + Coefficients[1]*d² + ...
+// is added to the value from the curve.
+type Compensation struct {
+	Channel      string    `json:"channel"`
+	Reference    float64   `json:"reference"`
+	Coefficients []float64 `json:"coefficients"`
+}
+
+// Correction returns what is added to a value when the compensating
+// channel reads v
+func (c Compensation) Correction(v float64) float64 {
+	d := v - c.Reference
+	sum, pow := 0.0, d
+	for _, k := range c.Coefficients {
+		sum += k * pow
+		pow *= d
+	}
+	return sum
+}
+
+// Provenance records when and how a calibration was produced
+type Provenance struct {
+	Time       time.Time `json:"time"`
+	Method     string    `json:"method"`               // e.g. "polynomial fit, degree 2"
+	Points     int       `json:"points,omitempty"`     // reference points used
+	Residual   float64   `json:"residual,omitempty"`   // RMS error of the fit at its points
+	Instrument string    `json:"instrument,omitempty"` // reference instrument
+	Operator   string    `json:"operator,omitempty"`
+	Notes      string    `json:"notes,omitempty"`
+}
+
+// Calibration converts one channel's raw counts to its value
+type Calibration struct {
+	Curve        Curve         `json:"curve"`
+	Compensation *Compensation `json:"compensation,omitempty"`
+	Produced     Provenance    `json:"produced"`
+}
+
+// Apply converts a raw count. comp is the compensating channel's current
+// value and is ignored without a compensation.
+func (c Calibration) Apply(raw, comp float64) float64 {
+	v := c.Curve.Apply(raw)
+	if c.Compensation != nil {
+		v += c.Compensation.Correction(comp)
+	}
+	return v
+}
+
+// CalibrationFile holds the calibrations of a device's channels, by channel
+// name
+type CalibrationFile struct {
+	Channels map[string]Calibration `json:"channels"`
+}
+
+// LoadCalibration reads a calibration file and checks every curve
+func LoadCalibration(path string) (CalibrationFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return CalibrationFile{}, err
+	}
+	return ParseCalibration(data, path)
+}
+
+// ParseCalibration decodes calibrations read from source, such as a
+// board's EEPROM, and checks every curve
+func ParseCalibration(data []byte, source string) (CalibrationFile, error) {
+	var f CalibrationFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return f, fmt.Errorf("calibration %s: %w", source, err)
+	}
+	for name, c := range f.Channels {
+		if err := c.Curve.validate(); err != nil {
+			return f, fmt.Errorf("calibration %s: %s: %w", source, name, err)
+		}
+		if c.Compensation != nil && c.Compensation.Channel == name {
+			return f, fmt.Errorf("calibration %s: %s can't compensate itself", source, name)
+		}
+	}
+	return f, nil
+}
+
+// SaveCalibration writes f to path, replacing it atomically
+func SaveCalibration(path string, f CalibrationFile) error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// NewPiecewise returns a piecewise curve through points, averaging the
+// values of points with the same raw count
+func NewPiecewise(points []Point) (Calibration, error) {
+	sorted := append([]Point(nil), points...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Raw < sorted[j].Raw })
+	var curve []Point
+	for i := 0; i < len(sorted); {
+		j, sum := i, 0.0
+		for ; j < len(sorted) && sorted[j].Raw == sorted[i].Raw; j++ {
+			sum += sorted[j].Value
+		}
+		curve = append(curve, Point{Raw: sorted[i].Raw, Value: sum / float64(j-i)})
+		i = j
+	}
+	c := Calibration{Curve: Curve{Kind: CurvePiecewise, Points: curve}}
+	if err := c.Curve.validate(); err != nil {
+		return c, err
+	}
+	c.Produced = Provenance{Time: time.Now().UTC(), Method: "piecewise linear", Points: len(points), Residual: residual(c, points)}
+	return c, nil
+}
+
+// FitPolynomial fits a polynomial of the given degree to points by least
+// squares. With comp set, it also fits a compensation of compDegree
+// against that channel, around reference; every point must then record
+// the compensating value.
+func FitPolynomial(points []Point, degree int, comp string, compDegree int, reference float64) (Calibration, error) {
+	if degree < 1 {
+		return Calibration{}, errors.New("degree must be at least 1")
+	}
+	if comp == "" {
+		compDegree = 0
+	} else if compDegree < 1 {
+		return Calibration{}, errors.New("compensation degree must be at least 1")
+	}
+	terms := degree + 1 + compDegree
+	if len(points) < terms {
+		return Calibration{}, fmt.Errorf("%d points can't determine %d coefficients", len(points), terms)
+	}
+
+	// Scale raw counts to about 1 so the normal equations stay well
+	// conditioned for counts in the thousands
+	scale := 0.0
+	for _, p := range points {
+		scale = math.Max(scale, math.Abs(p.Raw))
+	}
+	if scale == 0 {
+		scale = 1
+	}
+
+	rows := make([][]float64, len(points))
+	values := make([]float64, len(points))
+	for i, p := range points {
+		row := make([]float64, 0, terms)
+		x := 1.0
+		for k := 0; k <= degree; k++ {
+			row = append(row, x)
+			x *= p.Raw / scale
+		}
+		if compDegree > 0 {
+			if p.Compensation == nil {
+				return Calibration{}, fmt.Errorf("point %d has no %s value", i+1, comp)
+			}
+			d := *p.Compensation - reference
+			x := d
+			for k := 0; k < compDegree; k++ {
+				row = append(row, x)
+				x *= d
+			}
+		}
+		rows[i], values[i] = row, p.Value
+	}
+	coef, err := leastSquares(rows, values)
+	if err != nil {
+		return Calibration{}, err
+	}
+
+	c := Calibration{Curve: Curve{Kind: CurvePolynomial, Coefficients: make([]float64, degree+1)}}
+	div := 1.0
+	for k := 0; k <= degree; k++ {
+		c.Curve.Coefficients[k] = coef[k] / div
+		div *= scale
+	}
+	method := fmt.Sprintf("polynomial fit, degree %d", degree)
+	if compDegree > 0 {
+		c.Compensation = &Compensation{Channel: comp, Reference: reference, Coefficients: coef[degree+1:]}
+		method += fmt.Sprintf(", compensated by %s, degree %d", comp, compDegree)
+	}
+	c.Produced = Provenance{Time: time.Now().UTC(), Method: method, Points: len(points), Residual: residual(c, points)}
+	return c, nil
+}
+
+// leastSquares solves the normal equations of rows·x ≈ values by Gaussian
+// elimination with partial pivoting
+func leastSquares(rows [][]float64, values []float64) ([]float64, error) {
+	n := len(rows[0])
+	a := make([][]float64, n)
+	for i := range a {
+		a[i] = make([]float64, n+1)
+		for r, row := range rows {
+			for j := range row {
+				a[i][j] += row[i] * row[j]
+			}
+			a[i][n] += row[i] * values[r]
+		}
+	}
+	for col := 0; col < n; col++ {
+		pivot := col
+		for r := col + 1; r < n; r++ {
+			if math.Abs(a[r][col]) > math.Abs(a[pivot][col]) {
+				pivot = r
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-12 {
+			return nil, errors.New("points don't determine the fit; spread them over the range")
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		for r := 0; r < n; r++ {
+			if r == col {
+				continue
+			}
+			f := a[r][col] / a[col][col]
+			for k := col; k <= n; k++ {
+				a[r][k] -= f * a[col][k]
+			}
+		}
+	}
+	x := make([]float64, n)
+	for i := range x {
+		x[i] = a[i][n] / a[i][i]
+	}
+	return x, nil
+}
+
+// residual returns the RMS error of c at points
+func residual(c Calibration, points []Point) float64 {
+	sum := 0.0
+	for _, p := range points {
+		comp := 0.0
+		if p.Compensation != nil {
+			comp = *p.Compensation
+		}
+		d := c.Apply(p.Raw, comp) - p.Value
+		sum += d * d
+	}
+	return math.Sqrt(sum / float64(len(points)))
+}
+
+func interpolate(points []Point, x float64) float64 {
+	i := sort.Search(len(points), func(i int) bool { return points[i].Raw >= x })
+	switch {
+	case i == 0:
+		i = 1
+	case i == len(points):
+		i = len(points) - 1
+	}
+	a, b := points[i-1], points[i]
+	return a.Value + (x-a.Raw)*(b.Value-a.Value)/(b.Raw-a.Raw)
+}
+
+func polynomial(coef []float64, x float64) float64 {
+	v := 0.0
+	for i := len(coef) - 1; i >= 0; i-- {
+		v = v*x + coef[i]
+	}
+	return v
+}
@@ -0,0 +1,70 @@
+package sensor
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestPiecewise(t *testing.T) {
+	c, err := NewPiecewise([]Point{{Raw: 1000, Value: 50}, {Raw: 500, Value: 0}, {Raw: 700, Value: 18}, {Raw: 700, Value: 22}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct{ raw, want float64 }{
+		{500, 0}, {600, 10}, {700, 20}, {850, 35}, {1000, 50},
+		{400, -10}, {1100, 60}, // end segments extended
+	} {
+		if got := c.Apply(tc.raw, 0); math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("Apply(%v) = %v, want %v", tc.raw, got, tc.want)
+		}
+	}
+}
+
+func TestFitPolynomial(t *testing.T) {
+	// value = 2 + 0.01 raw + 1e-6 raw², drifting 0.05 per degree from 25
+	truth := func(raw, temp float64) float64 { return 2 + 0.01*raw + 1e-6*raw*raw + 0.05*(temp-25) }
+	var points []Point
+	for _, raw := range []float64{0, 1000, 2000, 3000, 4000} {
+		for _, temp := range []float64{0, 25, 50} {
+			temp := temp
+			points = append(points, Point{Raw: raw, Value: truth(raw, temp), Compensation: &temp})
+		}
+	}
+
+	c, err := FitPolynomial(points, 2, "temperature", 1, 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Produced.Residual > 1e-6 {
+		t.Errorf("residual %v for an exact fit", c.Produced.Residual)
+	}
+	if got, want := c.Apply(2500, 40), truth(2500, 40); math.Abs(got-want) > 1e-6 {
+		t.Errorf("Apply(2500, 40) = %v, want %v", got, want)
+	}
+
+	if _, err := FitPolynomial(points[:3], 2, "temperature", 1, 25); err == nil {
+		t.Error("fit with fewer points than coefficients succeeded")
+	}
+	if _, err := FitPolynomial([]Point{{Raw: 1, Value: 1}, {Raw: 1, Value: 2}}, 1, "", 0, 0); err == nil {
+		t.Error("fit to a single raw count succeeded")
+	}
+}
+
+func TestCalibrationFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calibration.json")
+	c, err := FitPolynomial([]Point{{Raw: 0, Value: 1}, {Raw: 10, Value: 21}}, 1, "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveCalibration(path, CalibrationFile{Channels: map[string]Calibration{"level": c}}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := LoadCalibration(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f.Channels["level"].Apply(5, 0); math.Abs(got-11) > 1e-9 {
+		t.Errorf("loaded curve gives %v at 5, want 11", got)
+	}
+}
@@ -0,0 +1,75 @@
+package sensor
+
+import "math"
+
+// Correlation compares two series sampled on the same grid
+type Correlation struct {
+	R     float64 // Pearson coefficient of the aligned series, NaN unless Valid
+	N     int     // pairs with both values present
+	Lag   int     // steps b is shifted by for the strongest correlation; positive if b follows a
+	LagR  float64 // coefficient at Lag
+	LagN  int     // pairs at Lag
+	Flat  bool    // a or b doesn't vary, so no coefficient exists
+	Valid bool    // enough pairs (minPairs) for R to mean anything
+}
+
+// minPairs is the fewest value pairs a coefficient is computed from
+const minPairs = 10
+
+// Correlate computes the correlation of series a and b, which hold values
+// at the same times with NaN for gaps, and searches shifts of b by up to
+// maxLag steps either way for the one correlating most strongly (by
+// absolute value), e.g. the delay of a room's temperature behind its
+// light. Shifts leaving fewer than half the pairs of the unshifted series,
+// whose coefficients are more and more down to chance, are not
+// considered.
+func Correlate(a, b []float64, maxLag int) Correlation {
+	c := Correlation{R: math.NaN(), LagR: math.NaN()}
+	c.R, c.N, c.Flat = pearson(a, b, 0)
+	c.Valid = c.N >= minPairs && !c.Flat
+	if !c.Valid {
+		c.R = math.NaN()
+		return c
+	}
+	c.LagR, c.LagN = c.R, c.N
+	least := max(minPairs, c.N/2)
+	for lag := 1; lag <= maxLag; lag++ {
+		for _, l := range []int{lag, -lag} {
+			r, n, flat := pearson(a, b, l)
+			if n >= least && !flat && math.Abs(r) > math.Abs(c.LagR) {
+				c.Lag, c.LagR, c.LagN = l, r, n
+			}
+		}
+	}
+	return c
+}
+
+// pearson returns the correlation coefficient of a[i] and b[i+lag] over
+// the pairs where both are present, with the number of pairs, and whether
+// either side is constant
+func pearson(a, b []float64, lag int) (r float64, n int, flat bool) {
+	var sa, sb, saa, sbb, sab float64
+	for i := max(0, -lag); i < len(a) && i+lag < len(b); i++ {
+		x, y := a[i], b[i+lag]
+		if math.IsNaN(x) || math.IsNaN(y) {
+			continue
+		}
+		n++
+		sa += x
+		sb += y
+		saa += x * x
+		sbb += y * y
+		sab += x * y
+	}
+	if n == 0 {
+		return math.NaN(), 0, false
+	}
+	fn := float64(n)
+	cov := sab - sa*sb/fn
+	va, vb := saa-sa*sa/fn, sbb-sb*sb/fn
+	// Variance lost in rounding counts as none
+	if va <= 1e-12*math.Max(saa, 1) || vb <= 1e-12*math.Max(sbb, 1) {
+		return math.NaN(), n, true
+	}
+	return math.Max(-1, math.Min(1, cov/math.Sqrt(va*vb))), n, false
+}
@@ -0,0 +1,41 @@
+package sensor
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCorrelate(t *testing.T) {
+	const n = 200
+	light, temp, noise, flat := make([]float64, n), make([]float64, n), make([]float64, n), make([]float64, n)
+	for i := range light {
+		light[i] = 500 + 400*math.Sin(float64(i)/10)
+		noise[i] = math.Sin(float64(i) * 7.3) // unrelated
+		flat[i] = 21
+	}
+	// Temperature follows light 5 steps later
+	for i := range temp {
+		temp[i] = 20 + 0.01*light[max(i-5, 0)]
+	}
+	light[40], temp[90] = math.NaN(), math.NaN()
+
+	c := Correlate(light, temp, 20)
+	if !c.Valid || c.Lag != 5 || c.LagR < 0.999 {
+		t.Errorf("light/temperature = %+v, want lag 5, r 1", c)
+	}
+	if c.R >= c.LagR || c.N != n-2 {
+		t.Errorf("light/temperature at lag 0: r %v over %d pairs", c.R, c.N)
+	}
+	if c := Correlate(temp, light, 20); c.Lag != -5 {
+		t.Errorf("temperature/light lag %d, want -5", c.Lag)
+	}
+	if c := Correlate(light, noise, 0); math.Abs(c.R) > 0.2 {
+		t.Errorf("light/noise r = %v, want about 0", c.R)
+	}
+	if c := Correlate(light, flat, 5); c.Valid || !c.Flat || !math.IsNaN(c.R) {
+		t.Errorf("light/flat = %+v, want flat", c)
+	}
+	if c := Correlate(light[:5], temp[:5], 0); c.Valid || !math.IsNaN(c.R) {
+		t.Errorf("5 pairs = %+v, want not valid", c)
+	}
+}
@@ -0,0 +1,84 @@
+package sensor
+
+import (
+	"math"
+	"time"
+)
+
+// Derivative computes a channel's rate of change between consecutive
+// samples, in value units per Per. Across a gap longer than MaxGap, or next
+// to an unusable or stale sample, there is no rate rather than a made-up
+// one.
+type Derivative struct {
+	Per    time.Duration // default 1s
+	MaxGap time.Duration // 0 bridges any gap
+	prev   Sample
+	has    bool
+}
+
+// Add feeds the next sample and returns the rate since the previous one,
+// with the worse of their qualities; ok is false if there is no rate
+func (d *Derivative) Add(s Sample) (rate float64, q Quality, ok bool) {
+	prev, had := d.prev, d.has
+	if !s.Time.After(prev.Time) && had {
+		return 0, Fault, false // the source repeated its sample
+	}
+	d.prev, d.has = s, s.Quality.Usable() && s.Quality != Stale && !math.IsNaN(s.Value)
+	if !had || !d.has || gap(prev.Time, s.Time, d.MaxGap) {
+		return 0, Fault, false
+	}
+	dt := float64(s.Time.Sub(prev.Time)) / float64(per(d.Per))
+	return (s.Value - prev.Value) / dt, Worst(prev.Quality, s.Quality), true
+}
+
+// Integral accumulates the area under a channel's samples with the
+// trapezoidal rule, in value units × Per (lux-hours with Per = time.Hour).
+// With Above set only the excess over it counts, e.g. degree-minutes above
+// a threshold. Intervals across a gap longer than MaxGap, or next to an
+// unusable sample, add nothing. Total may be preset, to carry an integral
+// over from before a restart.
+type Integral struct {
+	Per    time.Duration // default 1s
+	MaxGap time.Duration // 0 bridges any gap
+	Above  *float64
+	Total  float64
+	prev   Sample
+	has    bool
+}
+
+// Add feeds the next sample and returns the total with the worst quality
+// of the samples just integrated: Stale if the interval was skipped
+func (in *Integral) Add(s Sample) (total float64, q Quality) {
+	prev, had := in.prev, in.has
+	if had && !s.Time.After(prev.Time) {
+		return in.Total, Stale
+	}
+	in.prev, in.has = s, s.Quality.Usable() && !math.IsNaN(s.Value)
+	if prev.Time.IsZero() && in.has {
+		return in.Total, s.Quality // nothing to integrate yet
+	}
+	if !had || !in.has || gap(prev.Time, s.Time, in.MaxGap) {
+		return in.Total, Stale
+	}
+	dt := float64(s.Time.Sub(prev.Time)) / float64(per(in.Per))
+	in.Total += (in.integrand(prev.Value) + in.integrand(s.Value)) / 2 * dt
+	return in.Total, Worst(prev.Quality, s.Quality)
+}
+
+func (in *Integral) integrand(v float64) float64 {
+	if in.Above == nil {
+		return v
+	}
+	return math.Max(0, v-*in.Above)
+}
+
+func gap(from, to time.Time, max time.Duration) bool {
+	return max > 0 && to.Sub(from) > max
+}
+
+func per(d time.Duration) time.Duration {
+	if d <= 0 {
+		return time.Second
+	}
+	return d
+}
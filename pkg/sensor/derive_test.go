@@ -0,0 +1,67 @@
+package sensor
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDerivative(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	d := Derivative{Per: time.Minute, MaxGap: 5 * time.Second}
+	for i, step := range []struct {
+		at    time.Duration
+		value float64
+		q     Quality
+		rate  float64
+		ok    bool
+	}{
+		{0, 10, OK, 0, false},
+		{time.Second, 11, OK, 60, true},
+		{2 * time.Second, 11, OutOfRange, 0, true},
+		{2 * time.Second, 11, OutOfRange, 0, false}, // repeated
+		{3 * time.Second, 11, Stale, 0, false},
+		{4 * time.Second, 12, OK, 0, false}, // after a stale sample
+		{5 * time.Second, 11.5, OK, -30, true},
+		{20 * time.Second, 13, OK, 0, false}, // gap
+		{21 * time.Second, 14, OK, 60, true},
+	} {
+		rate, q, ok := d.Add(Sample{Time: t0.Add(step.at), Value: step.value, Quality: step.q})
+		if ok != step.ok || (ok && math.Abs(rate-step.rate) > 1e-9) {
+			t.Errorf("step %d: rate %v ok %v, want %v %v", i, rate, ok, step.rate, step.ok)
+		}
+		if ok && i == 2 && q != OutOfRange {
+			t.Errorf("step %d: quality %v, want out-of-range", i, q)
+		}
+	}
+}
+
+func TestIntegral(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	above := 20.0
+	in := Integral{Per: time.Minute, MaxGap: 90 * time.Second, Above: &above, Total: 5}
+	for i, step := range []struct {
+		at    time.Duration
+		value float64
+		total float64
+		q     Quality
+	}{
+		{0, 20, 5, OK},
+		{time.Minute, 22, 6, OK},                // trapezoid of 0 and 2
+		{2 * time.Minute, 22, 8, OK},            // 2 for a minute
+		{3 * time.Minute, 18, 9, OK},            // 2 and 0 (clipped)
+		{4 * time.Minute, math.NaN(), 9, Stale}, // fault
+		{5 * time.Minute, 24, 9, Stale},
+		{10 * time.Minute, 24, 9, Stale}, // gap
+		{11 * time.Minute, 26, 14, OK},
+	} {
+		q := OK
+		if math.IsNaN(step.value) {
+			q = Fault
+		}
+		total, gotQ := in.Add(Sample{Time: t0.Add(step.at), Value: step.value, Quality: q})
+		if math.Abs(total-step.total) > 1e-9 || gotQ != step.q {
+			t.Errorf("step %d: total %v %v, want %v %v", i, total, gotQ, step.total, step.q)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package sensor
+
+import (
+	"math"
+	"time"
+)
+
+// Bucket summarises the samples of one step of a downsampled series. A
+// step without usable samples has Count 0 and nil values, so charts show a
+// gap rather than a made-up value.
+type Bucket struct {
+	Time    time.Time `json:"time"` // start of the step
+	Count   int       `json:"count"`
+	Mean    *float64  `json:"mean"`
+	Min     *float64  `json:"min"`
+	Max     *float64  `json:"max"`
+	Quality Quality   `json:"quality"` // worst among the samples included
+}
+
+// Downsample splits [from, to) into steps and summarises the samples in
+// each, skipping those r excludes. samples must be oldest first.
+func Downsample(samples []Sample, from, to time.Time, step time.Duration, r Range) []Bucket {
+	if step <= 0 || !to.After(from) {
+		return nil
+	}
+	n := int((to.Sub(from) + step - 1) / step)
+	buckets := make([]Bucket, 0, n)
+	i := 0
+	for b := 0; b < n; b++ {
+		start := from.Add(time.Duration(b) * step)
+		end := start.Add(step)
+		for i < len(samples) && samples[i].Time.Before(start) {
+			i++
+		}
+		j := i
+		for j < len(samples) && samples[j].Time.Before(end) {
+			j++
+		}
+		bucket := Bucket{Time: start, Quality: Fault}
+		if st := Compute(samples[i:j], r); st.Count > 0 {
+			mean, min, max := st.Mean, st.Min, st.Max
+			if !math.IsNaN(mean) {
+				bucket.Mean, bucket.Min, bucket.Max = &mean, &min, &max
+			}
+			bucket.Count, bucket.Quality = st.Count, st.Quality
+		}
+		buckets = append(buckets, bucket)
+		i = j
+	}
+	return buckets
+}
@@ -0,0 +1,46 @@
+package sensor
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDownsample(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	max := 10.0
+	var samples []Sample
+	for i, v := range []float64{1, 3, 5, 100, math.NaN(), 7} {
+		q := OK
+		if math.IsNaN(v) {
+			q = Fault
+		}
+		samples = append(samples, Sample{Time: t0.Add(time.Duration(i) * time.Second), Value: v, Quality: Range{Max: &max}.Check(v, q)})
+	}
+	// Steps of 2s over 8s: [1 3] [5 100] [NaN 7] []
+	got := Downsample(samples, t0, t0.Add(8*time.Second), 2*time.Second, Range{Max: &max, Suppress: true})
+	if len(got) != 4 {
+		t.Fatalf("got %d buckets, want 4", len(got))
+	}
+	for i, want := range []struct {
+		count int
+		mean  float64
+	}{{2, 2}, {1, 5}, {1, 7}, {0, 0}} {
+		b := got[i]
+		if b.Count != want.count {
+			t.Errorf("bucket %d: count %d, want %d", i, b.Count, want.count)
+		}
+		if want.count == 0 {
+			if b.Mean != nil || b.Quality != Fault {
+				t.Errorf("empty bucket %d: mean %v, quality %v", i, b.Mean, b.Quality)
+			}
+			continue
+		}
+		if b.Mean == nil || *b.Mean != want.mean {
+			t.Errorf("bucket %d: mean %v, want %v", i, b.Mean, want.mean)
+		}
+		if !b.Time.Equal(t0.Add(time.Duration(2*i) * time.Second)) {
+			t.Errorf("bucket %d starts at %v", i, b.Time)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package sensor
+
+import (
+	"math"
+	"time"
+)
+
+// Holt forecasts a channel by double exponential smoothing (Holt's linear
+// method): it tracks a smoothed level and trend, and extrapolates the
+// trend. Alpha weighs new samples into the level and Beta new slopes into
+// the trend, each between 0 and 1; higher values follow changes faster
+// and noise more. Samples may come at irregular intervals.
+//
+// There is no seasonal term, as the horizons of interest (minutes ahead)
+// are short against daily cycles.
+type Holt struct {
+	Alpha, Beta float64       // defaults 0.3 and 0.1
+	MaxGap      time.Duration // a longer gap starts afresh; 0 bridges any gap
+	// MinSamples is how many usable samples the forecast needs before it
+	// is trusted, default 10
+	MinSamples int
+
+	level float64
+	trend float64 // per second
+	last  time.Time
+	n     int
+}
+
+// Add feeds the next sample. Unusable and stale samples are skipped.
+func (h *Holt) Add(s Sample) {
+	if !s.Quality.Usable() || s.Quality == Stale || math.IsNaN(s.Value) {
+		return
+	}
+	if h.n > 0 && !s.Time.After(h.last) {
+		return
+	}
+	if h.n > 0 && gap(h.last, s.Time, h.MaxGap) {
+		h.n = 0
+	}
+	if h.n == 0 {
+		h.level, h.trend, h.last, h.n = s.Value, 0, s.Time, 1
+		return
+	}
+	alpha, beta := h.Alpha, h.Beta
+	if alpha <= 0 || alpha > 1 {
+		alpha = 0.3
+	}
+	if beta <= 0 || beta > 1 {
+		beta = 0.1
+	}
+	dt := s.Time.Sub(h.last).Seconds()
+	prev := h.level
+	h.level = alpha*s.Value + (1-alpha)*(h.level+h.trend*dt)
+	slope := (h.level - prev) / dt
+	if h.n == 1 {
+		h.trend = slope
+	} else {
+		h.trend = beta*slope + (1-beta)*h.trend
+	}
+	h.last = s.Time
+	h.n++
+}
+
+// HoltState is the state of a Holt forecast, for carrying it across a
+// restart
+type HoltState struct {
+	Level   float64   `json:"level"`
+	Trend   float64   `json:"trend"` // per second
+	Last    time.Time `json:"last"`
+	Samples int       `json:"samples"`
+}
+
+// State returns the forecast's state
+func (h *Holt) State() HoltState {
+	return HoltState{Level: h.level, Trend: h.trend, Last: h.last, Samples: h.n}
+}
+
+// Restore takes up a state returned by State. If the next sample comes
+// more than MaxGap after it, the forecast starts afresh as usual.
+func (h *Holt) Restore(s HoltState) {
+	h.level, h.trend, h.last, h.n = s.Level, s.Trend, s.Last, max(s.Samples, 0)
+}
+
+// Ready reports whether enough samples have been seen to forecast
+func (h *Holt) Ready() bool {
+	min := h.MinSamples
+	if min <= 0 {
+		min = 10
+	}
+	return h.n >= min
+}
+
+// Level returns the smoothed current value
+func (h *Holt) Level() float64 { return h.level }
+
+// Trend returns the smoothed rate of change per second
+func (h *Holt) Trend() float64 { return h.trend }
+
+// Forecast returns the value expected d after the latest sample
+func (h *Holt) Forecast(d time.Duration) float64 {
+	return h.level + h.trend*d.Seconds()
+}
+
+// Until returns how long after the latest sample the forecast rises to
+// threshold (above) or falls to it (!above), 0 if the level is already
+// there; ok is false if the trend leads away from it or is flat
+func (h *Holt) Until(threshold float64, above bool) (d time.Duration, ok bool) {
+	diff, trend := threshold-h.level, h.trend
+	if !above {
+		diff, trend = -diff, -trend
+	}
+	if diff <= 0 {
+		return 0, true
+	}
+	if trend <= 0 {
+		return 0, false
+	}
+	secs := diff / trend
+	if secs >= float64(math.MaxInt64)/float64(time.Second) {
+		return 0, false
+	}
+	return time.Duration(secs * float64(time.Second)), true
+}
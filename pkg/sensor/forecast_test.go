@@ -0,0 +1,72 @@
+package sensor
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestHolt(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	h := Holt{MaxGap: time.Minute}
+	// A steady rise of 0.5 per second, sampled every 2s
+	for i := 0; i < 30; i++ {
+		h.Add(Sample{Time: t0.Add(time.Duration(2*i) * time.Second), Value: 20 + float64(i), Quality: OK})
+		if i == 5 && h.Ready() {
+			t.Error("ready after 6 samples")
+		}
+	}
+	if !h.Ready() {
+		t.Fatal("not ready after 30 samples")
+	}
+	if math.Abs(h.Trend()-0.5) > 0.01 {
+		t.Errorf("trend %v, want 0.5/s", h.Trend())
+	}
+	if got := h.Forecast(time.Minute); math.Abs(got-(49+30)) > 0.5 {
+		t.Errorf("forecast a minute on: %v, want 79", got)
+	}
+	if d, ok := h.Until(60, true); !ok || (d-22*time.Second).Abs() > time.Second {
+		t.Errorf("until 60: %v %v, want 22s", d, ok)
+	}
+	if _, ok := h.Until(10, false); ok {
+		t.Error("rising series forecast to fall")
+	}
+	if d, ok := h.Until(30, true); !ok || d != 0 {
+		t.Errorf("until a threshold already passed: %v %v", d, ok)
+	}
+
+	// Unusable samples are skipped; a gap starts afresh
+	h.Add(Sample{Time: t0.Add(61 * time.Second), Value: math.NaN(), Quality: Fault})
+	h.Add(Sample{Time: t0.Add(10 * time.Minute), Value: 5, Quality: OK})
+	if h.Ready() || h.Level() != 5 || h.Trend() != 0 {
+		t.Errorf("after a gap: ready %v level %v trend %v", h.Ready(), h.Level(), h.Trend())
+	}
+}
+
+func TestHoltRestore(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	sample := func(i int) Sample {
+		return Sample{Time: t0.Add(time.Duration(i) * time.Second), Value: 10 + 0.2*float64(i), Quality: OK}
+	}
+	a := Holt{MaxGap: time.Minute}
+	for i := 0; i < 20; i++ {
+		a.Add(sample(i))
+	}
+	b := Holt{MaxGap: time.Minute}
+	b.Restore(a.State())
+	for i := 20; i < 25; i++ {
+		a.Add(sample(i))
+		b.Add(sample(i))
+	}
+	if !b.Ready() || b.Level() != a.Level() || b.Trend() != a.Trend() {
+		t.Errorf("restored: level %v trend %v, want %v %v", b.Level(), b.Trend(), a.Level(), a.Trend())
+	}
+
+	// A state older than MaxGap is dropped with the next sample
+	c := Holt{MaxGap: time.Minute}
+	c.Restore(a.State())
+	c.Add(sample(200))
+	if c.Ready() || c.Level() != sample(200).Value {
+		t.Errorf("stale state kept: ready %v, level %v", c.Ready(), c.Level())
+	}
+}
@@ -0,0 +1,225 @@
+package sensor
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultRelativeError is the quantile accuracy of a histogram created
+// without one: 0.1% of the value, three significant digits as HDR
+// histograms default to. Sensors often measure small changes on a large
+// offset (101.3 kPa ± 0.2), which a coarser histogram lumps together.
+const DefaultRelativeError = 0.001
+
+// minIndexable is the smallest magnitude a histogram tells from zero
+const minIndexable = 1e-9
+
+// Histogram counts values in buckets whose width grows with their
+// magnitude, in the manner of HDR histograms: any quantile is within the
+// relative error of a value that was added, however wide the range, and
+// memory grows with the log of the range rather than the number of values.
+// Negative values and zero are counted too. Histograms with the same
+// relative error merge exactly.
+type Histogram struct {
+	gamma, logGamma float64
+	pos, neg        map[int]uint64 // by bucket index of the magnitude
+	zero            uint64
+	count           uint64
+	sum, min, max   float64
+}
+
+// NewHistogram creates an empty histogram with quantiles accurate to
+// relativeError (0.001 for 0.1%); 0 selects DefaultRelativeError
+func NewHistogram(relativeError float64) *Histogram {
+	if relativeError <= 0 || relativeError >= 1 {
+		relativeError = DefaultRelativeError
+	}
+	gamma := (1 + relativeError) / (1 - relativeError)
+	return &Histogram{gamma: gamma, logGamma: math.Log(gamma), pos: make(map[int]uint64), neg: make(map[int]uint64)}
+}
+
+// Add counts v; NaN is ignored
+func (h *Histogram) Add(v float64) {
+	if math.IsNaN(v) {
+		return
+	}
+	switch {
+	case v >= minIndexable:
+		h.pos[h.index(v)]++
+	case v <= -minIndexable:
+		h.neg[h.index(-v)]++
+	default:
+		h.zero++
+	}
+	if h.count == 0 || v < h.min {
+		h.min = v
+	}
+	if h.count == 0 || v > h.max {
+		h.max = v
+	}
+	h.count++
+	h.sum += v
+}
+
+// index returns the bucket of a positive magnitude: bucket i holds
+// (gamma^(i-1), gamma^i]
+func (h *Histogram) index(m float64) int {
+	return int(math.Ceil(math.Log(m) / h.logGamma))
+}
+
+// value returns the value a bucket stands for, within the relative error
+// of everything in it
+func (h *Histogram) value(i int) float64 {
+	return 2 * math.Pow(h.gamma, float64(i)) / (h.gamma + 1)
+}
+
+// Merge adds the counts of o, which must have the same relative error
+func (h *Histogram) Merge(o *Histogram) {
+	if o.count == 0 {
+		return
+	}
+	for i, n := range o.pos {
+		h.pos[i] += n
+	}
+	for i, n := range o.neg {
+		h.neg[i] += n
+	}
+	h.zero += o.zero
+	if h.count == 0 || o.min < h.min {
+		h.min = o.min
+	}
+	if h.count == 0 || o.max > h.max {
+		h.max = o.max
+	}
+	h.count += o.count
+	h.sum += o.sum
+}
+
+// Reset empties the histogram
+func (h *Histogram) Reset() {
+	clear(h.pos)
+	clear(h.neg)
+	h.zero, h.count, h.sum, h.min, h.max = 0, 0, 0, 0, 0
+}
+
+// Count returns the number of values added
+func (h *Histogram) Count() uint64 { return h.count }
+
+// Sum returns the sum of the values added
+func (h *Histogram) Sum() float64 { return h.sum }
+
+// Quantile returns the q-quantile (0.5 for the median), NaN if the
+// histogram is empty. The extremes are exact.
+func (h *Histogram) Quantile(q float64) float64 {
+	if h.count == 0 || math.IsNaN(q) {
+		return math.NaN()
+	}
+	if q <= 0 {
+		return h.min
+	}
+	if q >= 1 {
+		return h.max
+	}
+	rank := uint64(q * float64(h.count-1))
+	var seen uint64
+	// Most negative first: negative magnitudes from the largest down
+	for _, i := range sortedKeys(h.neg, true) {
+		if seen += h.neg[i]; seen > rank {
+			return h.clamp(-h.value(i))
+		}
+	}
+	if seen += h.zero; seen > rank {
+		return 0
+	}
+	for _, i := range sortedKeys(h.pos, false) {
+		if seen += h.pos[i]; seen > rank {
+			return h.clamp(h.value(i))
+		}
+	}
+	return h.max
+}
+
+// clamp keeps a bucket's value within the values actually added
+func (h *Histogram) clamp(v float64) float64 {
+	return math.Min(math.Max(v, h.min), h.max)
+}
+
+func sortedKeys(m map[int]uint64, descending bool) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	if descending {
+		sort.Sort(sort.Reverse(sort.IntSlice(keys)))
+	} else {
+		sort.Ints(keys)
+	}
+	return keys
+}
+
+// WindowedHistogram is a Histogram of the values added over the last
+// window. It is kept as slots that expire whole, so the window is exact
+// to within a slot. It is safe for concurrent use.
+type WindowedHistogram struct {
+	mu     sync.Mutex
+	window time.Duration
+	slot   time.Duration
+	relErr float64
+	slots  []*Histogram
+	epochs []int64 // the slot-sized period each slot holds
+}
+
+// NewWindowedHistogram creates a histogram over window, split into slots
+// (at least 1) with quantiles accurate to relativeError
+func NewWindowedHistogram(window time.Duration, slots int, relativeError float64) *WindowedHistogram {
+	slots = max(slots, 1)
+	w := &WindowedHistogram{
+		window: window,
+		slot:   max(window/time.Duration(slots), 1),
+		relErr: relativeError,
+		slots:  make([]*Histogram, slots),
+		epochs: make([]int64, slots),
+	}
+	for i := range w.slots {
+		w.slots[i] = NewHistogram(relativeError)
+		w.epochs[i] = -1
+	}
+	return w
+}
+
+// Window returns the period the histogram covers
+func (w *WindowedHistogram) Window() time.Duration { return w.window }
+
+// Add counts v as added at t
+func (w *WindowedHistogram) Add(t time.Time, v float64) {
+	epoch := t.UnixNano() / int64(w.slot)
+	i := int(epoch % int64(len(w.slots)))
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.epochs[i] != epoch {
+		if epoch < w.epochs[i] {
+			return // older than the window
+		}
+		w.slots[i].Reset()
+		w.epochs[i] = epoch
+	}
+	w.slots[i].Add(v)
+}
+
+// Snapshot returns a Histogram of the values added within the window
+// ending at now
+func (w *WindowedHistogram) Snapshot(now time.Time) *Histogram {
+	h := NewHistogram(w.relErr)
+	epoch := now.UnixNano() / int64(w.slot)
+	oldest := epoch - int64(len(w.slots)) + 1
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for i, e := range w.epochs {
+		if e >= oldest && e <= epoch {
+			h.Merge(w.slots[i])
+		}
+	}
+	return h
+}
@@ -0,0 +1,100 @@
+package sensor
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestHistogramQuantiles(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	h := NewHistogram(0.01)
+	var values []float64
+	for i := 0; i < 10000; i++ {
+		// Mostly around 20, with a tail of spikes, and some below zero
+		v := 20 + rng.NormFloat64()
+		if i%50 == 0 {
+			v = 80 + 20*rng.Float64()
+		}
+		if i%200 == 0 {
+			v = -5 * rng.Float64()
+		}
+		h.Add(v)
+		values = append(values, v)
+	}
+	h.Add(math.NaN())
+	sort.Float64s(values)
+	if h.Count() != uint64(len(values)) {
+		t.Fatalf("count %d, want %d", h.Count(), len(values))
+	}
+	for _, q := range []float64{0.001, 0.01, 0.5, 0.95, 0.99, 0.999} {
+		want := values[int(q*float64(len(values)-1))]
+		if got := h.Quantile(q); math.Abs(got-want) > 0.01*math.Abs(want)+1e-9 {
+			t.Errorf("q%v = %v, want %v ±1%%", q, got, want)
+		}
+	}
+	if h.Quantile(0) != values[0] || h.Quantile(1) != values[len(values)-1] {
+		t.Errorf("extremes %v %v, want %v %v", h.Quantile(0), h.Quantile(1), values[0], values[len(values)-1])
+	}
+
+	// Merging two halves gives the same quantiles as one histogram
+	a, b := NewHistogram(0.01), NewHistogram(0.01)
+	for i, v := range values {
+		if i%2 == 0 {
+			a.Add(v)
+		} else {
+			b.Add(v)
+		}
+	}
+	a.Merge(b)
+	for _, q := range []float64{0.5, 0.99} {
+		if a.Quantile(q) != h.Quantile(q) {
+			t.Errorf("merged q%v = %v, want %v", q, a.Quantile(q), h.Quantile(q))
+		}
+	}
+	if empty := NewHistogram(0); !math.IsNaN(empty.Quantile(0.5)) {
+		t.Error("empty histogram has a median")
+	}
+}
+
+func TestWindowedHistogram(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	w := NewWindowedHistogram(time.Minute, 6, 0.01)
+	for i := 0; i < 60; i++ {
+		w.Add(t0.Add(time.Duration(i)*time.Second), 10)
+	}
+	for i := 60; i < 90; i++ {
+		w.Add(t0.Add(time.Duration(i)*time.Second), 100)
+	}
+	// At 1:29 the window is the six 10s slots from 0:30: 30 tens and 30
+	// hundreds
+	h := w.Snapshot(t0.Add(89 * time.Second))
+	if h.Count() != 60 {
+		t.Errorf("count %d, want 60", h.Count())
+	}
+	if p := h.Quantile(0.25); math.Abs(p-10) > 0.1 {
+		t.Errorf("p25 %v, want 10", p)
+	}
+	if p := h.Quantile(0.75); math.Abs(p-100) > 1 {
+		t.Errorf("p75 %v, want 100", p)
+	}
+	// Long after, everything has expired
+	if h := w.Snapshot(t0.Add(time.Hour)); h.Count() != 0 {
+		t.Errorf("count %d after the window, want 0", h.Count())
+	}
+}
+
+func TestComputePercentiles(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var samples []Sample
+	for i := 1; i <= 101; i++ {
+		samples = append(samples, Sample{Time: t0.Add(time.Duration(i) * time.Second), Value: float64(i), Quality: OK})
+	}
+	samples = append(samples, Sample{Time: t0, Value: math.NaN(), Quality: Fault})
+	st := Compute(samples, Range{})
+	if st.P50 != 51 || st.P95 != 96 || st.P99 != 100 {
+		t.Errorf("p50 %v p95 %v p99 %v, want 51 96 100", st.P50, st.P95, st.P99)
+	}
+}
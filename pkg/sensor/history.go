@@ -0,0 +1,283 @@
+package sensor
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Sample is one converted reading of a channel
+type Sample struct {
+	Time    time.Time `json:"time"`
+	Value   float64   `json:"value"`
+	Quality Quality   `json:"quality"`
+}
+
+// History keeps the most recent samples of a channel in a fixed-size ring.
+// The ring lives either in memory (NewHistory) or in a memory-mapped file
+// (OpenHistoryFile) so it survives restarts. It is safe for concurrent use.
+type History struct {
+	mu       sync.Mutex
+	buf      []byte // header followed by capacity records
+	capacity int
+	mapped   bool
+}
+
+// On-disk layout, little endian:
+//
+//	header: magic[8] version u32 capacity u32 next u32 full u32 (pad to 32)
+//	record: unix-nanos i64 value f64 quality u8 (pad to 24)
+const (
+	historyMagic   = "RVHIST\x00\x00"
+	historyVersion = 1
+	headerSize     = 32
+	recordSize     = 24
+
+	offVersion  = 8
+	offCapacity = 12
+	offNext     = 16
+	offFull     = 20
+)
+
+var le = binary.LittleEndian
+
+// NewHistory creates an in-memory history holding up to capacity samples
+func NewHistory(capacity int) *History {
+	if capacity < 1 {
+		capacity = 1
+	}
+	h := &History{buf: make([]byte, headerSize+capacity*recordSize), capacity: capacity}
+	h.initHeader()
+	return h
+}
+
+// OpenHistoryFile maps a history file holding up to capacity samples,
+// creating it if needed. Samples already in the file are kept, so they are
+// available immediately after a restart; a file with a different layout or
+// capacity is reset. Writes reach the page cache at once and survive a
+// process crash; call Sync to also protect them against power loss.
+func OpenHistoryFile(path string, capacity int) (*History, error) {
+	if capacity < 1 {
+		capacity = 1
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	size := headerSize + capacity*recordSize
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	reset := fi.Size() != int64(size)
+	if reset {
+		if err := f.Truncate(0); err != nil {
+			return nil, err
+		}
+		if err := f.Truncate(int64(size)); err != nil {
+			return nil, err
+		}
+	}
+
+	buf, err := syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("mmap %s: %w", path, err)
+	}
+	h := &History{buf: buf, capacity: capacity, mapped: true}
+	if reset || !h.validHeader() {
+		h.initHeader()
+	}
+	return h, nil
+}
+
+// DecodeHistory returns the samples in the contents of a history file,
+// oldest first, e.g. of one copied off a board
+func DecodeHistory(data []byte) ([]Sample, error) {
+	if len(data) < headerSize {
+		return nil, errors.New("not a history file")
+	}
+	h := &History{buf: data, capacity: int(le.Uint32(data[offCapacity:]))}
+	if h.capacity < 1 || len(data) != headerSize+h.capacity*recordSize || !h.validHeader() {
+		return nil, errors.New("not a history file, or a damaged one")
+	}
+	return h.Since(time.Time{}), nil
+}
+
+func (h *History) initHeader() {
+	clear(h.buf[:headerSize])
+	copy(h.buf, historyMagic)
+	le.PutUint32(h.buf[offVersion:], historyVersion)
+	le.PutUint32(h.buf[offCapacity:], uint32(h.capacity))
+}
+
+func (h *History) validHeader() bool {
+	return string(h.buf[:8]) == historyMagic &&
+		le.Uint32(h.buf[offVersion:]) == historyVersion &&
+		int(le.Uint32(h.buf[offCapacity:])) == h.capacity &&
+		int(le.Uint32(h.buf[offNext:])) < h.capacity
+}
+
+func (h *History) next() int  { return int(le.Uint32(h.buf[offNext:])) }
+func (h *History) full() bool { return le.Uint32(h.buf[offFull:]) != 0 }
+
+func (h *History) record(i int) []byte {
+	off := headerSize + i*recordSize
+	return h.buf[off : off+recordSize]
+}
+
+func (h *History) sample(i int) Sample {
+	rec := h.record(i)
+	return Sample{
+		Time:    time.Unix(0, int64(le.Uint64(rec[0:]))),
+		Value:   math.Float64frombits(le.Uint64(rec[8:])),
+		Quality: Quality(rec[16]),
+	}
+}
+
+// Add records a sample, overwriting the oldest once the ring is full
+func (h *History) Add(s Sample) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.add(s)
+}
+
+func (h *History) add(s Sample) {
+	next := h.next()
+	rec := h.record(next)
+	le.PutUint64(rec[0:], uint64(s.Time.UnixNano()))
+	le.PutUint64(rec[8:], math.Float64bits(s.Value))
+	rec[16] = byte(s.Quality)
+
+	// Advance the index only after the record is complete, so a crash
+	// mid-write loses at most the sample being written
+	next++
+	if next == h.capacity {
+		next = 0
+		le.PutUint32(h.buf[offFull:], 1)
+	}
+	le.PutUint32(h.buf[offNext:], uint32(next))
+}
+
+// Len returns the number of samples held
+func (h *History) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.full() {
+		return h.capacity
+	}
+	return h.next()
+}
+
+// Since returns the samples taken at or after t, oldest first
+func (h *History) Since(t time.Time) []Sample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.since(t)
+}
+
+func (h *History) since(t time.Time) []Sample {
+	var out []Sample
+	add := func(from, to int) {
+		for i := from; i < to; i++ {
+			if s := h.sample(i); !s.Time.Before(t) {
+				out = append(out, s)
+			}
+		}
+	}
+	if h.full() {
+		add(h.next(), h.capacity)
+	}
+	add(0, h.next())
+	return out
+}
+
+// Capacity returns how many samples the history holds when full
+func (h *History) Capacity() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.capacity
+}
+
+// Resize changes the capacity of an in-memory history, keeping the newest
+// samples that fit, and returns the bytes freed (negative if it grew).
+// File-backed histories keep their size: their pages are the kernel's to
+// reclaim, being backed by the file.
+func (h *History) Resize(capacity int) int {
+	capacity = max(capacity, 1)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.mapped || capacity == h.capacity {
+		return 0
+	}
+	samples := h.since(time.Time{})
+	if len(samples) > capacity {
+		samples = samples[len(samples)-capacity:]
+	}
+	before := len(h.buf)
+	h.buf, h.capacity = make([]byte, headerSize+capacity*recordSize), capacity
+	h.initHeader()
+	for _, s := range samples {
+		h.add(s)
+	}
+	return before - len(h.buf)
+}
+
+// Last returns the newest sample
+func (h *History) Last() (Sample, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	next := h.next()
+	if !h.full() && next == 0 {
+		return Sample{}, false
+	}
+	if next == 0 {
+		next = h.capacity
+	}
+	return h.sample(next - 1), true
+}
+
+// Sync flushes a file-backed history to storage
+func (h *History) Sync() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.mapped {
+		return nil
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_MSYNC, uintptr(unsafe.Pointer(&h.buf[0])), uintptr(len(h.buf)), syscall.MS_SYNC)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Close unmaps a file-backed history; the History must not be used after
+func (h *History) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.mapped {
+		return nil
+	}
+	h.mapped = false
+	err := syscall.Munmap(h.buf)
+	h.buf = nil
+	return err
+}
+
+// Between returns the samples taken at or after from and before to, oldest
+// first
+func (h *History) Between(from, to time.Time) []Sample {
+	samples := h.Since(from)
+	n := len(samples)
+	for n > 0 && !samples[n-1].Time.Before(to) {
+		n--
+	}
+	return samples[:n]
+}
@@ -0,0 +1,51 @@
+package sensor
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHistoryResize(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	h := NewHistory(8)
+	for i := 0; i < 11; i++ {
+		h.Add(Sample{Time: t0.Add(time.Duration(i) * time.Second), Value: float64(i)})
+	}
+
+	if freed := h.Resize(3); freed != 5*recordSize {
+		t.Errorf("shrinking freed %d bytes, want %d", freed, 5*recordSize)
+	}
+	values := func() []float64 {
+		var v []float64
+		for _, s := range h.Since(time.Time{}) {
+			v = append(v, s.Value)
+		}
+		return v
+	}
+	if got := values(); len(got) != 3 || got[0] != 8 || got[2] != 10 {
+		t.Fatalf("after shrinking: %v, want [8 9 10]", got)
+	}
+
+	// Growing keeps what is left and fills up before overwriting
+	h.Resize(5)
+	for i := 11; i < 13; i++ {
+		h.Add(Sample{Time: t0.Add(time.Duration(i) * time.Second), Value: float64(i)})
+	}
+	if got := values(); len(got) != 5 || got[0] != 8 || got[4] != 12 || h.Capacity() != 5 {
+		t.Fatalf("after growing: %v (capacity %d), want [8 9 10 11 12]", got, h.Capacity())
+	}
+	if s, ok := h.Last(); !ok || s.Value != 12 {
+		t.Errorf("Last = %v, %v", s, ok)
+	}
+
+	// File-backed histories keep their size
+	f, err := OpenHistoryFile(filepath.Join(t.TempDir(), "x.hist"), 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if freed := f.Resize(2); freed != 0 || f.Capacity() != 8 {
+		t.Errorf("file-backed history resized: freed %d, capacity %d", freed, f.Capacity())
+	}
+}
@@ -0,0 +1,16 @@
+package sensor
+
+// Metadata describes the sensor behind a channel, for consumers of its
+// readings that have no other way of knowing where they come from
+type Metadata struct {
+	Model    string `json:"model,omitempty"`    // e.g. "BMP280"
+	Location string `json:"location,omitempty"` // e.g. "greenhouse/north wall"
+	Serial   string `json:"serial,omitempty"`
+	// Precision is the number of decimal places worth reporting
+	Precision *int `json:"precision,omitempty"`
+}
+
+// IsZero reports whether no metadata is set
+func (m Metadata) IsZero() bool {
+	return m.Model == "" && m.Location == "" && m.Serial == "" && m.Precision == nil
+}
@@ -0,0 +1,77 @@
+// Package sensor holds the data model shared by sensor pipelines: per-channel
+// readings and the quality status that travels with them from acquisition
+// through filters, storage and output sinks.
+package sensor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Quality describes how far a value can be trusted. Values are ordered by
+// severity, so combining several inputs keeps the largest (see Worst).
+type Quality uint8
+
+const (
+	// OK is a fresh, in-range measurement
+	OK Quality = iota
+	// Interpolated is computed from neighbouring samples rather than measured
+	Interpolated
+	// Stale repeats an earlier value because the latest read failed
+	Stale
+	// OutOfRange lies outside the channel's declared physical range
+	OutOfRange
+	// Saturated is pinned at an ADC rail, so the true value may lie beyond it
+	Saturated
+	// Fault means the sensor produced no usable value
+	Fault
+)
+
+var qualityNames = [...]string{"ok", "interpolated", "stale", "out-of-range", "saturated", "sensor-fault"}
+
+func (q Quality) String() string {
+	if int(q) < len(qualityNames) {
+		return qualityNames[q]
+	}
+	return fmt.Sprintf("quality(%d)", q)
+}
+
+// MarshalText encodes the quality by name, e.g. "stale"
+func (q Quality) MarshalText() ([]byte, error) {
+	return []byte(q.String()), nil
+}
+
+// UnmarshalText accepts the names produced by MarshalText
+func (q *Quality) UnmarshalText(b []byte) error {
+	for i, name := range qualityNames {
+		if strings.EqualFold(string(b), name) {
+			*q = Quality(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown quality %q", b)
+}
+
+// Usable reports whether the value may be used in calculations
+func (q Quality) Usable() bool { return q != Fault }
+
+// Worst returns the most severe quality, for values derived from several
+// inputs (averages, filters, derived channels)
+func Worst(qs ...Quality) Quality {
+	worst := OK
+	for _, q := range qs {
+		if q > worst {
+			worst = q
+		}
+	}
+	return worst
+}
+
+// RawQuality classifies a raw ADC count against the converter's full-scale
+// value: readings at either rail are Saturated
+func RawQuality(raw, fullScale int) Quality {
+	if raw <= 0 || raw >= fullScale {
+		return Saturated
+	}
+	return OK
+}
@@ -0,0 +1,34 @@
+package sensor
+
+// Range declares the physically valid values of a channel. Either bound may
+// be left unset.
+type Range struct {
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+	// Suppress keeps out-of-range and saturated readings out of averages
+	// and other statistics; they are still reported, with their quality
+	Suppress bool `json:"suppress,omitempty"`
+}
+
+// Contains reports whether v lies within the declared bounds
+func (r Range) Contains(v float64) bool {
+	return (r.Min == nil || v >= *r.Min) && (r.Max == nil || v <= *r.Max)
+}
+
+// Check downgrades q to OutOfRange when a value that is otherwise usable
+// lies outside the range. Worse qualities are kept.
+func (r Range) Check(v float64, q Quality) Quality {
+	if r.Contains(v) {
+		return q
+	}
+	return Worst(q, OutOfRange)
+}
+
+// Excluded reports whether a value of quality q should be left out of
+// statistics under this range's settings. Faults are always excluded.
+func (r Range) Excluded(q Quality) bool {
+	if !q.Usable() {
+		return true
+	}
+	return r.Suppress && (q == OutOfRange || q == Saturated)
+}
@@ -0,0 +1,102 @@
+package sensor
+
+import "time"
+
+// Transition is an edge of a digital signal
+type Transition struct {
+	Time   time.Time
+	Rising bool
+}
+
+// maxTransitions bounds the edges a SignalMeter keeps; at higher rates
+// the measurement covers less than the window
+const maxTransitions = 8192
+
+// SignalMeter measures the frequency and duty cycle of a digital signal,
+// such as a fan tachometer or PWM output, from the times of its edges.
+// Measurements average the whole periods within the window; a signal
+// slower than that is measured over its last period.
+type SignalMeter struct {
+	Window time.Duration
+	High   bool // the level after the last edge, or before the first
+	edges  []Transition
+}
+
+// Add records an edge; edges must be added in time order
+func (m *SignalMeter) Add(t Transition) {
+	if len(m.edges) == maxTransitions {
+		m.edges = append(m.edges[:0], m.edges[maxTransitions/2:]...)
+	}
+	m.edges = append(m.edges, t)
+	m.High = t.Rising
+}
+
+// Measure returns the frequency in Hz and the fraction of each period the
+// signal is high, 0 to 1. Without edges for two periods the signal counts
+// as stopped: 0 Hz, with the duty cycle of its level.
+func (m *SignalMeter) Measure(now time.Time) (freq, duty float64) {
+	m.prune(now)
+	var rises []int
+	for i, e := range m.edges {
+		if e.Rising {
+			rises = append(rises, i)
+		}
+	}
+	// Whole periods in the window, else the last period
+	first := 0
+	for first < len(rises) && m.edges[rises[first]].Time.Before(now.Add(-m.Window)) {
+		first++
+	}
+	if len(rises)-first < 2 {
+		first = len(rises) - 2
+	}
+	if first < 0 {
+		return 0, m.level()
+	}
+	start, end := rises[first], rises[len(rises)-1]
+	span := m.edges[end].Time.Sub(m.edges[start].Time)
+	periods := len(rises) - 1 - first
+	if span <= 0 {
+		return 0, m.level()
+	}
+	last := m.edges[len(m.edges)-1].Time
+	if now.Sub(last) > 2*span/time.Duration(periods) && now.Sub(last) > m.Window {
+		return 0, m.level()
+	}
+
+	var high time.Duration
+	var since time.Time // start of the current high phase, zero while low
+	for _, e := range m.edges[start : end+1] {
+		switch {
+		case e.Rising && since.IsZero():
+			since = e.Time
+		case !e.Rising && !since.IsZero():
+			high += e.Time.Sub(since)
+			since = time.Time{}
+		}
+	}
+	if !since.IsZero() {
+		high += m.edges[end].Time.Sub(since)
+	}
+	return float64(periods) / span.Seconds(), float64(high) / float64(span)
+}
+
+func (m *SignalMeter) level() float64 {
+	if m.High {
+		return 1
+	}
+	return 0
+}
+
+// prune drops edges older than the window, keeping the last four so a
+// slow signal's last period is known
+func (m *SignalMeter) prune(now time.Time) {
+	cutoff := now.Add(-m.Window)
+	n := 0
+	for n < len(m.edges)-4 && m.edges[n].Time.Before(cutoff) {
+		n++
+	}
+	if n > 0 {
+		m.edges = append(m.edges[:0], m.edges[n:]...)
+	}
+}
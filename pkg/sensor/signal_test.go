@@ -0,0 +1,46 @@
+package sensor
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// square adds n periods of a signal high for high and low for low
+func square(m *SignalMeter, t0 time.Time, n int, high, low time.Duration) time.Time {
+	t := t0
+	for i := 0; i < n; i++ {
+		m.Add(Transition{Time: t, Rising: true})
+		m.Add(Transition{Time: t.Add(high), Rising: false})
+		t = t.Add(high + low)
+	}
+	return t
+}
+
+func TestSignalMeter(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := SignalMeter{Window: time.Second}
+	if f, d := m.Measure(t0); f != 0 || d != 0 {
+		t.Errorf("no edges: %v Hz, duty %v", f, d)
+	}
+
+	// 40 Hz at 25%
+	end := square(&m, t0, 100, 6250*time.Microsecond, 18750*time.Microsecond)
+	f, d := m.Measure(end)
+	if math.Abs(f-40) > 1e-6 || math.Abs(d-0.25) > 1e-6 {
+		t.Errorf("40 Hz 25%%: got %v Hz, duty %v", f, d)
+	}
+
+	// A 0.5 Hz signal is measured over its last period
+	m = SignalMeter{Window: time.Second}
+	end = square(&m, t0, 3, 1500*time.Millisecond, 500*time.Millisecond)
+	f, d = m.Measure(end.Add(-time.Second))
+	if math.Abs(f-0.5) > 1e-6 || math.Abs(d-0.75) > 1e-6 {
+		t.Errorf("0.5 Hz 75%%: got %v Hz, duty %v", f, d)
+	}
+
+	// Stopped, low
+	if f, d := m.Measure(end.Add(10 * time.Second)); f != 0 || d != 0 {
+		t.Errorf("stopped: %v Hz, duty %v", f, d)
+	}
+}
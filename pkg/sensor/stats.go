@@ -0,0 +1,88 @@
+package sensor
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Stats summarises a channel over a time window
+type Stats struct {
+	Window       time.Duration `json:"window"`   // period covered
+	Count        int           `json:"count"`    // samples included
+	Excluded     int           `json:"excluded"` // samples left out (faults, suppressed)
+	Min          float64       `json:"min"`
+	Max          float64       `json:"max"`
+	Mean         float64       `json:"mean"`
+	StdDev       float64       `json:"stddev"`
+	RateOfChange float64       `json:"rate_of_change"` // units per second, least-squares slope
+	P50          float64       `json:"p50"`            // median
+	P95          float64       `json:"p95"`
+	P99          float64       `json:"p99"`
+	Quality      Quality       `json:"quality"` // worst quality among included samples
+}
+
+// Compute summarises samples, skipping those r excludes. With no usable
+// samples Count is 0 and Quality is Fault; the values are left at zero so
+// the result still encodes as JSON.
+func Compute(samples []Sample, r Range) Stats {
+	var st Stats
+	if len(samples) > 0 {
+		st.Window = samples[len(samples)-1].Time.Sub(samples[0].Time)
+	}
+
+	var sum, sumSq float64
+	values := make([]float64, 0, len(samples))
+	var t0 time.Time
+	var sumT, sumTT, sumTV float64
+	for _, s := range samples {
+		if r.Excluded(s.Quality) || math.IsNaN(s.Value) {
+			st.Excluded++
+			continue
+		}
+		if st.Count == 0 {
+			st.Min, st.Max, t0 = s.Value, s.Value, s.Time
+		}
+		st.Count++
+		st.Min = math.Min(st.Min, s.Value)
+		st.Max = math.Max(st.Max, s.Value)
+		st.Quality = Worst(st.Quality, s.Quality)
+		values = append(values, s.Value)
+		sum += s.Value
+		sumSq += s.Value * s.Value
+
+		t := s.Time.Sub(t0).Seconds()
+		sumT += t
+		sumTT += t * t
+		sumTV += t * s.Value
+	}
+
+	if st.Count == 0 {
+		st.Quality = Fault
+		return st
+	}
+
+	n := float64(st.Count)
+	st.Mean = sum / n
+	st.StdDev = math.Sqrt(math.Max(0, sumSq/n-st.Mean*st.Mean))
+	if d := n*sumTT - sumT*sumT; d > 0 {
+		st.RateOfChange = (n*sumTV - sumT*sum) / d
+	}
+	sort.Float64s(values)
+	st.P50, st.P95, st.P99 = Quantile(values, 0.5), Quantile(values, 0.95), Quantile(values, 0.99)
+	return st
+}
+
+// Quantile returns the q-quantile of sorted values, interpolating between
+// the two nearest; NaN if there are none
+func Quantile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	pos := math.Min(math.Max(q, 0), 1) * float64(len(sorted)-1)
+	i := int(pos)
+	if i+1 >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[i] + (pos-float64(i))*(sorted[i+1]-sorted[i])
+}
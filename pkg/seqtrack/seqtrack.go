@@ -0,0 +1,197 @@
+// Package seqtrack follows the sequence numbers devices give the readings
+// they publish, for receivers of at-least-once delivery: a reading retried
+// after its acknowledgement was lost arrives twice, and one a device
+// dropped never arrives at all.
+//
+// Each device numbers its readings 1, 2, 3, ... A tracker remembers which
+// of a device's most recent numbers it has seen, within a window, to tell
+// duplicates from late arrivals, and counts the numbers skipped when a
+// later one arrives first. A number older than the window can no longer be
+// told apart and is reported as old. A device whose numbering starts again
+// from 1, having lost its counter, is taken to have restarted.
+package seqtrack
+
+import "sync"
+
+// DefaultWindow is the numbers remembered per device when New is given 0:
+// a bit over an hour of readings at 1s sampling
+const DefaultWindow = 4096
+
+// Result describes a reading, or the range of numbers a rollup covers
+type Result struct {
+	New       uint64 // numbers not seen before
+	Duplicate uint64 // numbers seen before
+	Old       uint64 // numbers too far behind the latest to tell
+	// Missed is the numbers skipped ahead of this one: readings the
+	// device dropped, or that are still on their way
+	Missed uint64
+	// Late is the numbers that were counted missed, arriving after all
+	Late uint64
+	// Restarted is set when the device numbered this reading from 1 again
+	Restarted bool
+}
+
+// IsDuplicate reports whether every number of the reading was seen before,
+// so it can be discarded
+func (r Result) IsDuplicate() bool { return r.Duplicate > 0 && r.New == 0 && r.Old == 0 }
+
+// Stats are the totals of a device's results
+type Stats struct {
+	Received   uint64 `json:"received"` // readings, not numbers
+	Duplicates uint64 `json:"duplicates"`
+	Missed     uint64 `json:"missed"`
+	Late       uint64 `json:"late"`
+	Restarts   uint64 `json:"restarts"`
+	Last       uint64 `json:"last"` // the highest number seen
+}
+
+// Tracker follows the numbers of any number of devices. It is safe for
+// concurrent use.
+type Tracker struct {
+	window uint64
+
+	mu      sync.Mutex
+	devices map[string]*stream
+}
+
+// stream is one device's numbering
+type stream struct {
+	high  uint64   // the highest number seen, 0 for none
+	low   uint64   // the first number seen; those before weren't missed
+	seen  []uint64 // bit n%window is set if n, within window of high, was seen
+	stats Stats
+}
+
+// New returns a tracker remembering window numbers per device, rounded up
+// to a multiple of 64; more tells late readings from duplicates further
+// back, at window/8 bytes per device
+func New(window int) *Tracker {
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	words := (window + 63) / 64
+	return &Tracker{window: uint64(words * 64), devices: make(map[string]*stream)}
+}
+
+// Add records a reading from device numbered seq, or a rollup covering
+// first to seq; first is 0 for a single reading. Readings numbered 0
+// aren't tracked.
+func (t *Tracker) Add(device string, first, seq uint64) Result {
+	if seq == 0 {
+		return Result{}
+	}
+	if first == 0 || first > seq {
+		first = seq
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.devices[device]
+	if !ok {
+		s = &stream{seen: make([]uint64, t.window/64)}
+		t.devices[device] = s
+	}
+	r := s.add(first, seq, t.window)
+	s.stats.Received++
+	s.stats.Duplicates += r.Duplicate
+	s.stats.Missed += r.Missed
+	s.stats.Late += r.Late
+	if r.Restarted {
+		s.stats.Restarts++
+	}
+	s.stats.Last = s.high
+	return r
+}
+
+// Stats returns a device's totals
+func (t *Tracker) Stats(device string) Stats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if s, ok := t.devices[device]; ok {
+		return s.stats
+	}
+	return Stats{}
+}
+
+// Devices returns the devices seen, in no particular order
+func (t *Tracker) Devices() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	devices := make([]string, 0, len(t.devices))
+	for d := range t.devices {
+		devices = append(devices, d)
+	}
+	return devices
+}
+
+func (s *stream) add(first, last, window uint64) (r Result) {
+	if first == 1 && s.high > 0 {
+		// Only a device that lost its counter numbers from 1 again; a
+		// repeated first reading looks the same, and costs nothing worse
+		// than letting it through
+		r.Restarted = true
+		s.high = 0
+		clear(s.seen)
+	}
+	started := s.high > 0
+	if !started {
+		s.low = first
+	}
+
+	// Numbers up to the highest: old, duplicates or late arrivals
+	if s.high >= window && first <= s.high-window {
+		oldEnd := min(last, s.high-window)
+		r.Old = oldEnd - first + 1
+		first = oldEnd + 1
+	}
+	for n := first; n <= min(last, s.high); n++ {
+		if s.has(n, window) {
+			r.Duplicate++
+		} else {
+			s.mark(n, window)
+			r.New++
+			if n >= s.low {
+				r.Late++
+			}
+		}
+	}
+	if last <= s.high {
+		return r
+	}
+
+	// Numbers past it, skipping any in between
+	start := max(first, s.high+1)
+	if started {
+		r.Missed = start - s.high - 1
+	}
+	r.New += last - start + 1
+	if last-s.high >= window {
+		clear(s.seen)
+	} else {
+		for n := s.high + 1; n <= last; n++ {
+			s.unmark(n, window)
+		}
+	}
+	s.high = last
+	if last >= window {
+		start = max(start, last-window+1)
+	}
+	for n := start; n <= last; n++ {
+		s.mark(n, window)
+	}
+	return r
+}
+
+func (s *stream) has(n, window uint64) bool {
+	i := n % window
+	return s.seen[i/64]&(1<<(i%64)) != 0
+}
+
+func (s *stream) mark(n, window uint64) {
+	i := n % window
+	s.seen[i/64] |= 1 << (i % 64)
+}
+
+func (s *stream) unmark(n, window uint64) {
+	i := n % window
+	s.seen[i/64] &^= 1 << (i % 64)
+}
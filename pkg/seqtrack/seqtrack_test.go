@@ -0,0 +1,57 @@
+package seqtrack
+
+import "testing"
+
+func TestDuplicatesAndGaps(t *testing.T) {
+	tr := New(64)
+	steps := []struct {
+		first, seq uint64
+		want       Result
+	}{
+		{0, 10, Result{New: 1}}, // joined mid-stream: nothing missed
+		{0, 11, Result{New: 1}},
+		{0, 11, Result{Duplicate: 1}}, // retried
+		{0, 15, Result{New: 1, Missed: 3}},
+		{0, 13, Result{New: 1, Late: 1}},
+		{0, 13, Result{Duplicate: 1}},
+		{0, 9, Result{New: 1}}, // from before joining
+		{16, 20, Result{New: 5}},
+		{12, 21, Result{New: 3, Duplicate: 7, Late: 2}}, // 12, 14 and 21
+		{0, 200, Result{New: 1, Missed: 178}},
+		{0, 100, Result{Old: 1}},
+		{0, 150, Result{New: 1, Late: 1}},
+		{0, 1, Result{New: 1, Restarted: true}},
+		{0, 2, Result{New: 1}},
+	}
+	for i, s := range steps {
+		got := tr.Add("board-1", s.first, s.seq)
+		if got != s.want {
+			t.Errorf("step %d: Add(%d, %d) = %+v, want %+v", i, s.first, s.seq, got, s.want)
+		}
+	}
+	st := tr.Stats("board-1")
+	want := Stats{Received: 14, Duplicates: 9, Missed: 181, Late: 4, Restarts: 1, Last: 2}
+	if st != want {
+		t.Errorf("Stats = %+v, want %+v", st, want)
+	}
+	if r := tr.Add("board-2", 0, 0); r != (Result{}) || len(tr.Devices()) != 1 {
+		t.Errorf("unnumbered reading tracked: %+v, devices %v", r, tr.Devices())
+	}
+}
+
+func TestIsDuplicate(t *testing.T) {
+	tr := New(0)
+	tr.Add("", 0, 5)
+	if r := tr.Add("", 0, 5); !r.IsDuplicate() {
+		t.Errorf("%+v is not a duplicate", r)
+	}
+	if r := tr.Add("", 3, 6); r.IsDuplicate() {
+		t.Errorf("rollup with new numbers %+v is a duplicate", r)
+	}
+	for seq := uint64(7); seq < 7+DefaultWindow; seq++ {
+		tr.Add("", 0, seq)
+	}
+	if r := tr.Add("", 0, 6); r.IsDuplicate() || r.Old != 1 {
+		t.Errorf("number beyond the window %+v", r)
+	}
+}
@@ -0,0 +1,326 @@
+// Package serialexport carries files over a serial console, for getting
+// data off a board whose network is gone. The board prints each file as
+// short lines of text, which survive a terminal, a login shell and kernel
+// messages printed in between; the host picks them out of everything else
+// on the line and puts the files back together.
+//
+// Every frame is one line, "@RDX <fields...> <crc32>", where the CRC
+// covers the fields:
+//
+//	@RDX F <file> <name> <size> <sha256> <chunks> <crc>    a file begins
+//	@RDX C <file> <chunk> <base64 data> <crc>              one chunk of it
+//	@RDX E <file> <crc>                                    the file ends
+//	@RDX Z <files> <crc>                                   the export ends
+//
+// A line that is garbled, or cut by a kernel message, fails its CRC and
+// is dropped; the host asks for the missing chunks again by number.
+package serialexport
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ChunkSize is the data carried per line, 64 characters in base64
+const ChunkSize = 48
+
+const prefix = "@RDX "
+
+// ErrIncomplete is returned by File.Data while chunks are missing
+var ErrIncomplete = errors.New("serialexport: chunks missing")
+
+// Send writes the files to w as frames. only, if not nil, limits a file,
+// by base name, to the listed chunks, to fill in what a receiver missed;
+// files not in it are skipped.
+func Send(w io.Writer, paths []string, only map[string][]int) error {
+	bw := bufio.NewWriter(w)
+	n := 0
+	for _, path := range paths {
+		name := filepath.Base(path)
+		var chunks []int
+		if only != nil {
+			var ok bool
+			if chunks, ok = only[name]; !ok {
+				continue
+			}
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		n++
+		if err := sendFile(bw, n, name, data, chunks); err != nil {
+			return err
+		}
+	}
+	frame(bw, "Z", strconv.Itoa(n))
+	return bw.Flush()
+}
+
+func sendFile(w *bufio.Writer, id int, name string, data []byte, chunks []int) error {
+	count := (len(data) + ChunkSize - 1) / ChunkSize
+	sum := sha256.Sum256(data)
+	fid := strconv.Itoa(id)
+	frame(w, "F", fid, url.PathEscape(name), strconv.Itoa(len(data)), hex.EncodeToString(sum[:]), strconv.Itoa(count))
+	send := func(i int) {
+		end := min((i+1)*ChunkSize, len(data))
+		frame(w, "C", fid, strconv.Itoa(i), base64.StdEncoding.EncodeToString(data[i*ChunkSize:end]))
+	}
+	if chunks == nil {
+		for i := 0; i < count; i++ {
+			send(i)
+		}
+	} else {
+		for _, i := range chunks {
+			if i < 0 || i >= count {
+				return fmt.Errorf("%s has no chunk %d (it has %d)", name, i, count)
+			}
+			send(i)
+		}
+	}
+	frame(w, "E", fid)
+	return nil
+}
+
+// frame writes one line with its CRC
+func frame(w *bufio.Writer, fields ...string) {
+	body := strings.Join(fields, " ")
+	fmt.Fprintf(w, "%s%s %08x\n", prefix, body, crc32.ChecksumIEEE([]byte(body)))
+}
+
+// File is a file being received
+type File struct {
+	Name   string
+	Size   int
+	Sum    [sha256.Size]byte
+	chunks [][]byte // nil until received
+}
+
+// Missing returns the numbers of the chunks not yet received
+func (f *File) Missing() []int {
+	var missing []int
+	for i, c := range f.chunks {
+		if c == nil {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+
+// Data returns the file once all chunks are in and its checksum matches
+func (f *File) Data() ([]byte, error) {
+	if len(f.Missing()) > 0 {
+		return nil, ErrIncomplete
+	}
+	data := bytes.Join(f.chunks, nil)
+	if len(data) != f.Size || sha256.Sum256(data) != f.Sum {
+		return nil, fmt.Errorf("serialexport: %s: checksum mismatch", f.Name)
+	}
+	return data, nil
+}
+
+// Receiver collects files from frames. A second export of the same file,
+// all of it or only missing chunks, adds to what was received; a file
+// whose size or checksum changed in between starts afresh.
+type Receiver struct {
+	files map[string]*File
+	ids   map[string]*File // by the sender's file number in this export
+	begun int              // files begun in this export
+	lost  int              // files whose first frame was lost in the last export
+	// Bad counts frames dropped for a failed CRC or bad fields
+	Bad int
+}
+
+// NewReceiver returns an empty Receiver
+func NewReceiver() *Receiver {
+	return &Receiver{files: make(map[string]*File), ids: make(map[string]*File)}
+}
+
+// Read takes frames from r until the end of an export or of r, skipping
+// other output. It returns io.ErrUnexpectedEOF if r ended first.
+func (rc *Receiver) Read(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		if done := rc.Line(sc.Text()); done {
+			return nil
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return io.ErrUnexpectedEOF
+}
+
+// Line takes one line of console output, and reports whether it ended an
+// export
+func (rc *Receiver) Line(line string) (done bool) {
+	i := strings.Index(line, prefix)
+	if i < 0 {
+		return false
+	}
+	fields := strings.Fields(line[i+len(prefix):])
+	if len(fields) < 2 {
+		rc.Bad++
+		return false
+	}
+	body := strings.Join(fields[:len(fields)-1], " ")
+	crc, err := strconv.ParseUint(fields[len(fields)-1], 16, 32)
+	if err != nil || uint32(crc) != crc32.ChecksumIEEE([]byte(body)) {
+		rc.Bad++
+		return false
+	}
+	fields = fields[:len(fields)-1]
+	switch {
+	case fields[0] == "F" && len(fields) == 6:
+		rc.begin(fields[1:])
+	case fields[0] == "C" && len(fields) == 4:
+		rc.chunk(fields[1:])
+	case fields[0] == "E" && len(fields) == 2:
+		delete(rc.ids, fields[1])
+	case fields[0] == "Z" && len(fields) == 2:
+		n, _ := strconv.Atoi(fields[1])
+		rc.lost = max(n-rc.begun, 0)
+		rc.ids, rc.begun = make(map[string]*File), 0
+		return true
+	default:
+		rc.Bad++
+	}
+	return false
+}
+
+func (rc *Receiver) begin(f []string) {
+	name, err1 := url.PathUnescape(f[1])
+	size, err2 := strconv.Atoi(f[2])
+	sum, err3 := hex.DecodeString(f[3])
+	count, err4 := strconv.Atoi(f[4])
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil || len(sum) != sha256.Size ||
+		size < 0 || count != (size+ChunkSize-1)/ChunkSize || name != filepath.Base(name) {
+		rc.Bad++
+		return
+	}
+	file := rc.files[name]
+	if file == nil || file.Size != size || !bytes.Equal(file.Sum[:], sum) {
+		file = &File{Name: name, Size: size, chunks: make([][]byte, count)}
+		copy(file.Sum[:], sum)
+		rc.files[name] = file
+	}
+	rc.ids[f[0]] = file
+	rc.begun++
+}
+
+func (rc *Receiver) chunk(f []string) {
+	file := rc.ids[f[0]]
+	i, err := strconv.Atoi(f[1])
+	if file == nil || err != nil || i < 0 || i >= len(file.chunks) {
+		rc.Bad++
+		return
+	}
+	data, err := base64.StdEncoding.DecodeString(f[2])
+	want := ChunkSize
+	if i == len(file.chunks)-1 {
+		want = file.Size - i*ChunkSize
+	}
+	if err != nil || len(data) != want {
+		rc.Bad++
+		return
+	}
+	file.chunks[i] = data
+}
+
+// Files returns the files seen, by name
+func (rc *Receiver) Files() []*File {
+	files := make([]*File, 0, len(rc.files))
+	for _, f := range rc.files {
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files
+}
+
+// Lost returns how many files of the last export were lost entirely, as
+// their first frame was; only a full export brings them back
+func (rc *Receiver) Lost() int { return rc.lost }
+
+// Missing returns the chunks still missing by file name, in the form
+// ParseChunks reads, or "" when every file is complete. With limit > 0 the
+// list is cut to at most limit characters, as a shell reading a command
+// line from a terminal takes only so much; ask again for the rest later.
+func (rc *Receiver) Missing(limit int) string {
+	var b strings.Builder
+	for _, f := range rc.Files() {
+		m := f.Missing()
+		if len(m) == 0 {
+			continue
+		}
+		sep := ";" + url.PathEscape(f.Name) + ":"
+		if b.Len() == 0 {
+			sep = sep[1:]
+		}
+		for _, r := range ranges(m) {
+			if limit > 0 && b.Len()+len(sep)+len(r) > limit {
+				return b.String()
+			}
+			b.WriteString(sep + r)
+			sep = ","
+		}
+	}
+	return b.String()
+}
+
+// ranges writes sorted numbers as runs, "1", "4-9", "12"
+func ranges(nums []int) []string {
+	var out []string
+	for i := 0; i < len(nums); {
+		j := i
+		for j+1 < len(nums) && nums[j+1] == nums[j]+1 {
+			j++
+		}
+		r := strconv.Itoa(nums[i])
+		if j > i {
+			r += "-" + strconv.Itoa(nums[j])
+		}
+		out = append(out, r)
+		i = j + 1
+	}
+	return out
+}
+
+// ParseChunks reads "name:1,4-9;other:0" into the only argument of Send
+func ParseChunks(s string) (map[string][]int, error) {
+	only := make(map[string][]int)
+	for _, part := range strings.Split(s, ";") {
+		escaped, list, ok := strings.Cut(part, ":")
+		name, err := url.PathUnescape(escaped)
+		if !ok || err != nil || name == "" {
+			return nil, fmt.Errorf("bad chunk list %q (want name:1,4-9)", part)
+		}
+		for _, r := range strings.Split(list, ",") {
+			from, to, isRange := strings.Cut(r, "-")
+			a, err1 := strconv.Atoi(from)
+			b, err2 := a, error(nil)
+			if isRange {
+				b, err2 = strconv.Atoi(to)
+			}
+			if err1 != nil || err2 != nil || a < 0 || b < a {
+				return nil, fmt.Errorf("bad chunk range %q", r)
+			}
+			for i := a; i <= b; i++ {
+				only[name] = append(only[name], i)
+			}
+		}
+	}
+	return only, nil
+}
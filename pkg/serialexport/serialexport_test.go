@@ -0,0 +1,123 @@
+package serialexport
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	big := make([]byte, 10*ChunkSize+7)
+	rand.New(rand.NewSource(1)).Read(big)
+	files := map[string][]byte{"temperature.hist": big, "empty": nil, "exact": bytes.Repeat([]byte("x"), ChunkSize)}
+	var paths []string
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var out bytes.Buffer
+	if err := Send(&out, paths, nil); err != nil {
+		t.Fatal(err)
+	}
+	// A console adds a prompt, carriage returns and kernel messages, and
+	// garbles a line or two. Lines 6 to 16 are chunks of temperature.hist.
+	var console bytes.Buffer
+	console.WriteString("root@board:~# riscv-dev serial send\r\n")
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	for i, line := range lines {
+		switch i {
+		case 8:
+			line = line[:20] + "[  812.331] mmc0: error -110" + line[20:]
+		case 10:
+			continue
+		}
+		console.WriteString(line + "\r\n")
+		if i == 12 {
+			console.WriteString("[  812.500] usb 1-1: new high-speed USB device\r\n")
+		}
+	}
+
+	rc := NewReceiver()
+	if err := rc.Read(&console); err != nil {
+		t.Fatal(err)
+	}
+	if rc.Bad != 1 {
+		t.Errorf("bad frames: %d, want 1", rc.Bad)
+	}
+	missing := rc.Missing(0)
+	if missing == "" {
+		t.Fatal("nothing missing after dropping two chunk lines")
+	}
+	if cut := rc.Missing(len(missing) - 1); len(cut) >= len(missing) || !strings.HasPrefix(missing, cut) {
+		t.Errorf("missing %q cut to %q", missing, cut)
+	}
+	if _, err := rc.Files()[len(rc.Files())-1].Data(); err != ErrIncomplete {
+		t.Errorf("incomplete file: %v", err)
+	}
+
+	// Ask for what is missing
+	only, err := ParseChunks(missing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := Send(&out, paths, only); err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.Read(&out); err != nil {
+		t.Fatal(err)
+	}
+	if m := rc.Missing(0); m != "" {
+		t.Fatalf("still missing %s", m)
+	}
+	if len(rc.Files()) != len(files) {
+		t.Fatalf("%d files, want %d", len(rc.Files()), len(files))
+	}
+	for _, f := range rc.Files() {
+		data, err := f.Data()
+		if err != nil {
+			t.Errorf("%s: %v", f.Name, err)
+		} else if !bytes.Equal(data, files[f.Name]) {
+			t.Errorf("%s differs", f.Name)
+		}
+	}
+}
+
+func TestReadTruncated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "f")
+	os.WriteFile(path, []byte("hello"), 0644)
+	var out bytes.Buffer
+	Send(&out, []string{path}, nil)
+	rc := NewReceiver()
+	if err := rc.Read(strings.NewReader(out.String()[:out.Len()/2])); err == nil {
+		t.Error("no error on a cut-off export")
+	}
+}
+
+func TestChunkRanges(t *testing.T) {
+	if s := strings.Join(ranges([]int{0, 1, 2, 5, 7, 8}), ","); s != "0-2,5,7-8" {
+		t.Errorf("ranges: %s", s)
+	}
+	only, err := ParseChunks("a%20b:0-2,5;c:7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(only["a b"]) != 4 || len(only["c"]) != 1 {
+		t.Errorf("ParseChunks: %v", only)
+	}
+	for _, bad := range []string{"a", "a:", "a:3-1", ":1"} {
+		if _, err := ParseChunks(bad); err == nil {
+			t.Errorf("%q parsed", bad)
+		}
+	}
+}
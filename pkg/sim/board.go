@@ -0,0 +1,201 @@
+package sim
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"riscv-dev/pkg/hal"
+)
+
+// EnvBoard names the environment variable selecting the simulated board:
+// a built-in profile name or the path of a JSON profile
+const EnvBoard = "RISCV_DEV_SIM_BOARD"
+
+// Board describes the hardware a simulated board has. Without a board the
+// simulator accepts any pin, channel or bus; with one, only what the
+// board provides exists, as on the real hardware.
+type Board struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	GPIOChips   []GPIOChip `json:"gpio_chips"`
+	I2CBuses    []I2CBus   `json:"i2c_buses"`
+	ADC         *ADCSpec   `json:"adc,omitempty"` // nil if the board has no ADC
+}
+
+// GPIOChip is a simulated /dev/gpiochipN
+type GPIOChip struct {
+	Name  string `json:"name"`  // e.g. "gpiochip0"
+	Label string `json:"label"` // controller, as reported by the kernel
+	Lines int    `json:"lines"`
+}
+
+// I2CBus is a simulated /dev/i2c-N with the devices that answer on it
+type I2CBus struct {
+	Number  int         `json:"number"`
+	Devices []I2CDevice `json:"devices,omitempty"`
+}
+
+// I2CDevice is a device present at an address
+type I2CDevice struct {
+	Address int    `json:"address"`
+	Name    string `json:"name"`
+}
+
+// ADCSpec describes the board's ADC
+type ADCSpec struct {
+	Channels         int     `json:"channels"`
+	Resolution       int     `json:"resolution"`
+	ReferenceVoltage float64 `json:"reference_voltage"`
+}
+
+// builtinBoards are the profiles available by name
+var builtinBoards = map[string]*Board{
+	"visionfive2": {
+		Name:        "visionfive2",
+		Description: "StarFive VisionFive 2 (JH7110)",
+		GPIOChips: []GPIOChip{
+			{Name: "gpiochip0", Label: "13040000.pinctrl", Lines: 64},
+			{Name: "gpiochip1", Label: "17020000.pinctrl", Lines: 4},
+		},
+		I2CBuses: []I2CBus{
+			{Number: 0},
+			{Number: 2},
+			{Number: 5, Devices: []I2CDevice{{Address: 0x36, Name: "axp15060"}}},
+			{Number: 6},
+		},
+	},
+	"milkv-duo": {
+		Name:        "milkv-duo",
+		Description: "Milk-V Duo (CV1800B)",
+		GPIOChips: []GPIOChip{
+			{Name: "gpiochip0", Label: "3020000.gpio", Lines: 32},
+			{Name: "gpiochip1", Label: "3021000.gpio", Lines: 32},
+			{Name: "gpiochip2", Label: "3022000.gpio", Lines: 32},
+			{Name: "gpiochip3", Label: "3023000.gpio", Lines: 32},
+			{Name: "gpiochip4", Label: "5021000.gpio", Lines: 32},
+		},
+		I2CBuses: []I2CBus{{Number: 0}, {Number: 1}, {Number: 2}, {Number: 3}, {Number: 4}},
+		ADC:      &ADCSpec{Channels: 3, Resolution: 4095, ReferenceVoltage: 1.8},
+	},
+}
+
+var (
+	boardMu     sync.Mutex
+	board       *Board
+	boardLoaded bool
+)
+
+// Boards returns the names of the built-in profiles
+func Boards() []string {
+	names := make([]string, 0, len(builtinBoards))
+	for name := range builtinBoards {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupBoard returns a built-in profile by name, or loads a JSON profile
+// if name is a file path
+func LookupBoard(name string) (*Board, error) {
+	if b, ok := builtinBoards[strings.ToLower(name)]; ok {
+		return b, nil
+	}
+	if !strings.ContainsRune(name, os.PathSeparator) && filepath.Ext(name) != ".json" {
+		return nil, fmt.Errorf("unknown simulated board %q (available: %v, or a JSON file)", name, Boards())
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var b Board
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("board profile %s: %w", name, err)
+	}
+	if b.Name == "" {
+		b.Name = strings.TrimSuffix(filepath.Base(name), ".json")
+	}
+	return &b, nil
+}
+
+// SetBoard selects the simulated board for controllers opened afterwards;
+// nil removes the limits
+func SetBoard(b *Board) {
+	boardMu.Lock()
+	defer boardMu.Unlock()
+	board, boardLoaded = b, true
+}
+
+// CurrentBoard returns the simulated board: the one set with SetBoard, or
+// else the one named by RISCV_DEV_SIM_BOARD, or nil for no limits
+func CurrentBoard() (*Board, error) {
+	boardMu.Lock()
+	defer boardMu.Unlock()
+	if !boardLoaded {
+		if name := os.Getenv(EnvBoard); name != "" {
+			b, err := LookupBoard(name)
+			if err != nil {
+				return nil, err
+			}
+			board = b
+		}
+		boardLoaded = true
+	}
+	return board, nil
+}
+
+// Devices lists the device nodes the board has, e.g. for code that
+// enumerates /dev
+func (b *Board) Devices() []string {
+	var devs []string
+	for _, c := range b.GPIOChips {
+		devs = append(devs, "/dev/"+c.Name)
+	}
+	for _, bus := range b.I2CBuses {
+		devs = append(devs, fmt.Sprintf("/dev/i2c-%d", bus.Number))
+	}
+	if b.ADC != nil {
+		devs = append(devs, "/dev/iio:device0")
+	}
+	return devs
+}
+
+// chip returns the GPIO chip at path ("/dev/gpiochip1" or "gpiochip1"); an
+// empty path selects the first chip
+func (b *Board) chip(path string) (GPIOChip, error) {
+	if len(b.GPIOChips) == 0 {
+		return GPIOChip{}, &hal.Error{Op: "sim gpio", Kind: hal.ErrNotSupported, Err: fmt.Errorf("%s has no GPIO chips", b.Name)}
+	}
+	if path == "" {
+		return b.GPIOChips[0], nil
+	}
+	name := filepath.Base(path)
+	for _, c := range b.GPIOChips {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	return GPIOChip{}, &hal.Error{Op: "sim gpio " + path, Kind: hal.ErrNotSupported, Err: fmt.Errorf("%s has no %s", b.Name, name)}
+}
+
+// bus returns the I2C bus at path ("/dev/i2c-1"); an empty path selects
+// the first bus
+func (b *Board) bus(path string) (I2CBus, error) {
+	if len(b.I2CBuses) == 0 {
+		return I2CBus{}, &hal.Error{Op: "sim i2c", Kind: hal.ErrNotSupported, Err: fmt.Errorf("%s has no I2C buses", b.Name)}
+	}
+	if path == "" {
+		return b.I2CBuses[0], nil
+	}
+	for _, bus := range b.I2CBuses {
+		if path == fmt.Sprintf("/dev/i2c-%d", bus.Number) {
+			return bus, nil
+		}
+	}
+	return I2CBus{}, &hal.Error{Op: "sim i2c " + path, Kind: hal.ErrNotSupported, Err: fmt.Errorf("%s has no such bus", b.Name)}
+}
@@ -0,0 +1,165 @@
+package sim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"riscv-dev/pkg/hal"
+)
+
+// I2C simulates an I2C bus. Each device is a 256-byte register file: a
+// write sets the register pointer from its first byte and stores the rest
+// from there on, and a read returns registers from the pointer onwards.
+// Addresses without a device don't acknowledge.
+type I2C struct {
+	device string
+	open   bool // any address answers, when no board is selected
+
+	mu      sync.Mutex
+	devices map[byte]*i2cDevice
+	player  *Player
+}
+
+type i2cDevice struct {
+	regs [256]byte
+	ptr  byte
+}
+
+// NewI2C opens a simulated bus such as "/dev/i2c-1"; an empty device
+// selects the first bus. With a board selected only its buses exist and
+// only its devices answer; without one every address answers.
+func NewI2C(device string) (*I2C, error) {
+	b, err := CurrentBoard()
+	if err != nil {
+		return nil, err
+	}
+	p, err := CurrentScenario()
+	if err != nil {
+		return nil, err
+	}
+	bus := &I2C{device: device, devices: make(map[byte]*i2cDevice), player: p}
+	if b == nil {
+		if bus.device == "" {
+			bus.device = "/dev/i2c-0"
+		}
+		bus.open = true
+		return bus, nil
+	}
+	info, err := b.bus(device)
+	if err != nil {
+		return nil, err
+	}
+	bus.device = fmt.Sprintf("/dev/i2c-%d", info.Number)
+	for _, d := range info.Devices {
+		bus.AddDevice(byte(d.Address))
+	}
+	return bus, nil
+}
+
+// Attach plays p's I2C steps on the bus
+func (b *I2C) Attach(p *Player) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.player = p
+}
+
+// AddDevice makes a device answer at addr
+func (b *I2C) AddDevice(addr byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.devices[addr]; !ok {
+		b.devices[addr] = &i2cDevice{}
+	}
+}
+
+// SetRegisters stores data in the device at addr from register reg on, as
+// the device itself would, e.g. to provide a sensor's measurement
+func (b *I2C) SetRegisters(addr, reg byte, data []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	d, ok := b.devices[addr]
+	if !ok {
+		d = &i2cDevice{}
+		b.devices[addr] = d
+	}
+	for i, v := range data {
+		d.regs[reg+byte(i)] = v
+	}
+}
+
+// lookup returns the device at addr, or the fault injected for it; b.mu
+// must be held
+func (b *I2C) lookup(op string, addr byte) (*i2cDevice, error) {
+	if b.player != nil {
+		if f := b.player.i2cFault(b.device, addr); f != nil {
+			return nil, f.err(fmt.Sprintf("sim i2c %s %s@%#02x", op, b.device, addr))
+		}
+	}
+	d, ok := b.devices[addr]
+	if !ok && b.open {
+		d = &i2cDevice{}
+		b.devices[addr] = d
+		ok = true
+	}
+	if !ok {
+		return nil, &hal.Error{Op: fmt.Sprintf("sim i2c %s %s@%#02x", op, b.device, addr), Kind: hal.ErrNack, Err: errors.New("no device at address")}
+	}
+	return d, nil
+}
+
+// Write sets the register pointer and stores any further bytes
+func (b *I2C) Write(ctx context.Context, addr byte, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	d, err := b.lookup("write", addr)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	d.ptr = data[0]
+	for _, v := range data[1:] {
+		d.regs[d.ptr] = v
+		d.ptr++
+	}
+	return nil
+}
+
+// Read returns length registers from the pointer onwards
+func (b *I2C) Read(ctx context.Context, addr byte, length int) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	d, err := b.lookup("read", addr)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, length)
+	for i := range buf {
+		buf[i] = d.regs[d.ptr]
+		d.ptr++
+	}
+	return buf, nil
+}
+
+// WriteRead writes data, typically a register number, then reads
+func (b *I2C) WriteRead(ctx context.Context, addr byte, data []byte, readLength int) ([]byte, error) {
+	if err := b.Write(ctx, addr, data); err != nil {
+		return nil, err
+	}
+	return b.Read(ctx, addr, readLength)
+}
+
+// Device returns the bus device path
+func (b *I2C) Device() string { return b.device }
+
+// Close releases the simulated bus
+func (b *I2C) Close() error { return nil }
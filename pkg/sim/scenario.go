@@ -0,0 +1,415 @@
+package sim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"riscv-dev/pkg/config"
+	"riscv-dev/pkg/hal"
+	"riscv-dev/pkg/yaml"
+)
+
+// EnvScenario names the environment variable holding the path of a JSON
+// or YAML scenario to play on the simulated hardware
+const EnvScenario = "RISCV_DEV_SIM_SCENARIO"
+
+// Scenario is a timeline of changes to the simulated hardware, such as a
+// sensor value ramping up or a device that stops acknowledging, so
+// alerting, circuit breakers and recovery can be exercised repeatably:
+//
+//	{
+//	  "name": "overheat",
+//	  "noise": 0,
+//	  "steps": [
+//	    {"at": "10s", "adc": {"channel": 0, "value": 1300, "over": "20s"}},
+//	    {"at": "30s", "i2c": {"bus": "/dev/i2c-1", "address": 72, "fault": "nack"}},
+//	    {"at": "45s", "i2c": {"fault": "none"}, "log": "bus recovered"}
+//	  ]
+//	}
+type Scenario struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Seed makes the ADC noise repeatable; 0 for a random seed
+	Seed int64 `json:"seed,omitempty"`
+	// Noise overrides the ADC noise in counts either way; 0 for exact values
+	Noise *int   `json:"noise,omitempty"`
+	Steps []Step `json:"steps"`
+}
+
+// Step is what happens at one point of a scenario, measured from its start
+type Step struct {
+	At   config.Duration `json:"at"`
+	ADC  *ADCStep        `json:"adc,omitempty"`
+	I2C  *I2CStep        `json:"i2c,omitempty"`
+	GPIO *GPIOStep       `json:"gpio,omitempty"`
+	Log  string          `json:"log,omitempty"` // printed when the step is played
+}
+
+// ADCStep scripts an ADC channel. A scripted channel reads Value instead of
+// its source; with Over it ramps linearly there from its current value.
+type ADCStep struct {
+	Channel int             `json:"channel"`
+	Value   *int            `json:"value,omitempty"` // raw counts
+	Over    config.Duration `json:"over,omitempty"`
+	// Fault makes reads fail: "nack", "timeout", "busy" or "io"; "none"
+	// clears it
+	Fault string `json:"fault,omitempty"`
+	// Release hands the channel back to its source
+	Release bool `json:"release,omitempty"`
+}
+
+// I2CStep makes transactions with devices fail, or clears such a fault
+type I2CStep struct {
+	Bus     string `json:"bus,omitempty"`     // e.g. "/dev/i2c-1"; empty for every bus
+	Address *int   `json:"address,omitempty"` // unset for every device
+	Fault   string `json:"fault"`             // "nack", "timeout", "busy", "io" or "none"
+}
+
+// GPIOStep drives the level seen on an input pin
+type GPIOStep struct {
+	Pin   int  `json:"pin"`
+	Value bool `json:"value"`
+}
+
+// errSimulated is the cause of every fault injected by a scenario
+var errSimulated = errors.New("simulated fault")
+
+// faultKinds maps scenario fault names to hal failure classes; "io" is
+// unclassified
+var faultKinds = map[string]error{
+	"nack":    hal.ErrNack,
+	"timeout": hal.ErrTimeout,
+	"busy":    hal.ErrBusBusy,
+	"io":      nil,
+}
+
+// fault is an injected failure; a nil *fault is none
+type fault struct{ kind error }
+
+func (f *fault) err(op string) error {
+	return &hal.Error{Op: op, Kind: f.kind, Err: errSimulated}
+}
+
+func parseFault(name string) (*fault, error) {
+	if name == "none" {
+		return nil, nil
+	}
+	kind, ok := faultKinds[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown fault %q (want nack, timeout, busy, io or none)", name)
+	}
+	return &fault{kind: kind}, nil
+}
+
+// LoadScenario reads a scenario, in YAML or, starting with '{', JSON
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s Scenario
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("scenario %s: %w", path, err)
+	}
+	if s.Name == "" {
+		base := filepath.Base(path)
+		s.Name = strings.TrimSuffix(base, filepath.Ext(base))
+	}
+	if err := s.validate(); err != nil {
+		return nil, fmt.Errorf("scenario %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+func (s *Scenario) validate() error {
+	for i, st := range s.Steps {
+		if st.ADC == nil && st.I2C == nil && st.GPIO == nil && st.Log == "" {
+			return fmt.Errorf("step %d does nothing", i+1)
+		}
+		if a := st.ADC; a != nil {
+			if a.Value != nil && a.Release {
+				return fmt.Errorf("step %d: adc value and release are exclusive", i+1)
+			}
+			if a.Over != 0 && a.Value == nil {
+				return fmt.Errorf("step %d: adc over needs a value", i+1)
+			}
+			if a.Fault != "" {
+				if _, err := parseFault(a.Fault); err != nil {
+					return fmt.Errorf("step %d: %w", i+1, err)
+				}
+			}
+		}
+		if st.I2C != nil {
+			if _, err := parseFault(st.I2C.Fault); err != nil {
+				return fmt.Errorf("step %d: %w", i+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Player plays a scenario on the simulated controllers attached to it.
+// Time is whatever Advance was last told, so tests can step through a
+// scenario deterministically; Run follows the wall clock instead.
+type Player struct {
+	scenario *Scenario
+	elapsed  atomic.Int64 // nanoseconds
+
+	mu    sync.Mutex
+	next  int // first step not played yet
+	adc   map[int]*adcScript
+	i2c   map[i2cTarget]*fault
+	gpio  map[int]bool
+	gpios []*GPIO
+}
+
+// adcScript is the scripted state of an ADC channel
+type adcScript struct {
+	set         bool // value overrides the source
+	from, to    int
+	fromKnown   bool // false until the ramp's starting value is read
+	start, over time.Duration
+	fault       *fault
+}
+
+// valueAt returns the channel value at elapsed time t; during a ramp the
+// starting value must be known
+func (s *adcScript) valueAt(t time.Duration) int {
+	if t >= s.start+s.over {
+		return s.to
+	}
+	if t <= s.start {
+		return s.from
+	}
+	return s.from + int(float64(s.to-s.from)*float64(t-s.start)/float64(s.over))
+}
+
+// i2cTarget selects devices; an empty bus or address -1 matches any
+type i2cTarget struct {
+	bus  string
+	addr int
+}
+
+// NewPlayer prepares s to be played from its start
+func NewPlayer(s *Scenario) (*Player, error) {
+	if err := s.validate(); err != nil {
+		return nil, fmt.Errorf("scenario %s: %w", s.Name, err)
+	}
+	sorted := *s
+	sorted.Steps = append([]Step(nil), s.Steps...)
+	sort.SliceStable(sorted.Steps, func(i, j int) bool { return sorted.Steps[i].At < sorted.Steps[j].At })
+	return &Player{
+		scenario: &sorted,
+		adc:      make(map[int]*adcScript),
+		i2c:      make(map[i2cTarget]*fault),
+		gpio:     make(map[int]bool),
+	}, nil
+}
+
+// Scenario returns the scenario being played
+func (p *Player) Scenario() *Scenario { return p.scenario }
+
+// Elapsed returns how far the scenario has been played
+func (p *Player) Elapsed() time.Duration { return time.Duration(p.elapsed.Load()) }
+
+// Done reports whether every step has been played
+func (p *Player) Done() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.next == len(p.scenario.Steps)
+}
+
+// Advance moves the scenario's clock forward to t, playing the steps due
+// by then in order. Moving it backwards does nothing.
+func (p *Player) Advance(t time.Duration) {
+	p.mu.Lock()
+	if t < p.Elapsed() {
+		p.mu.Unlock()
+		return
+	}
+	p.elapsed.Store(int64(t))
+	type input struct {
+		pin   int
+		value bool
+	}
+	var inputs []input
+	for ; p.next < len(p.scenario.Steps); p.next++ {
+		st := p.scenario.Steps[p.next]
+		if st.At.D() > t {
+			break
+		}
+		if st.Log != "" {
+			log.Printf("🎬 sim: scenario %s at %v: %s", p.scenario.Name, st.At.D(), st.Log)
+		}
+		if st.ADC != nil {
+			p.playADC(st.At.D(), st.ADC)
+		}
+		if st.I2C != nil {
+			p.playI2C(st.I2C)
+		}
+		if st.GPIO != nil {
+			p.gpio[st.GPIO.Pin] = st.GPIO.Value
+			inputs = append(inputs, input{st.GPIO.Pin, st.GPIO.Value})
+		}
+	}
+	gpios := append([]*GPIO(nil), p.gpios...)
+	p.mu.Unlock()
+
+	for _, g := range gpios {
+		for _, in := range inputs {
+			g.SetInput(in.pin, in.value)
+		}
+	}
+}
+
+// playADC applies an ADC step at time at; p.mu must be held
+func (p *Player) playADC(at time.Duration, st *ADCStep) {
+	s, ok := p.adc[st.Channel]
+	if !ok {
+		s = &adcScript{}
+		p.adc[st.Channel] = s
+	}
+	switch {
+	case st.Value != nil:
+		// A ramp starts from where the channel is now: its scripted value,
+		// or else whatever its source reads next
+		if s.set && (s.fromKnown || at >= s.start+s.over) {
+			s.from, s.fromKnown = s.valueAt(at), true
+		} else {
+			s.fromKnown = false
+		}
+		s.set, s.to, s.start, s.over = true, *st.Value, at, st.Over.D()
+	case st.Release:
+		s.set = false
+	}
+	if st.Fault != "" {
+		s.fault, _ = parseFault(st.Fault)
+	}
+}
+
+// playI2C replaces the faults of the devices an I2C step covers; p.mu must
+// be held
+func (p *Player) playI2C(st *I2CStep) {
+	target := i2cTarget{bus: st.Bus, addr: -1}
+	if st.Address != nil {
+		target.addr = *st.Address
+	}
+	for t := range p.i2c {
+		if (target.bus == "" || t.bus == target.bus) && (target.addr < 0 || t.addr == target.addr) {
+			delete(p.i2c, t)
+		}
+	}
+	if f, _ := parseFault(st.Fault); f != nil {
+		p.i2c[target] = f
+	}
+}
+
+// adcChannel returns the scripted state of a channel
+func (p *Player) adcChannel(channel int) adcScript {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if s, ok := p.adc[channel]; ok {
+		return *s
+	}
+	return adcScript{}
+}
+
+// rampFrom records the value a ramp on channel starts from, if it isn't
+// known yet, and returns the channel's state
+func (p *Player) rampFrom(channel, value int) adcScript {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.adc[channel]
+	if !s.fromKnown {
+		s.from, s.fromKnown = value, true
+	}
+	return *s
+}
+
+// i2cFault returns the fault injected for addr on bus, the most specific
+// one if several apply
+func (p *Player) i2cFault(bus string, addr byte) *fault {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, t := range []i2cTarget{{bus, int(addr)}, {bus, -1}, {"", int(addr)}, {"", -1}} {
+		if f, ok := p.i2c[t]; ok {
+			return f
+		}
+	}
+	return nil
+}
+
+// addGPIO makes g see the scenario's input levels, including those already
+// played
+func (p *Player) addGPIO(g *GPIO) map[int]bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.gpios = append(p.gpios, g)
+	levels := make(map[int]bool, len(p.gpio))
+	for pin, v := range p.gpio {
+		levels[pin] = v
+	}
+	return levels
+}
+
+// Run plays the scenario in real time, from where it was, until ctx is done
+func (p *Player) Run(ctx context.Context) {
+	start := time.Now().Add(-p.Elapsed())
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		p.Advance(time.Since(start))
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+var (
+	scenarioMu     sync.Mutex
+	player         *Player
+	scenarioLoaded bool
+)
+
+// SetScenario selects the player that controllers opened afterwards are
+// attached to; nil for none. The caller runs or advances it.
+func SetScenario(p *Player) {
+	scenarioMu.Lock()
+	defer scenarioMu.Unlock()
+	player, scenarioLoaded = p, true
+}
+
+// CurrentScenario returns the player set with SetScenario, or else one for
+// the scenario named by RISCV_DEV_SIM_SCENARIO, or nil. A scenario from the
+// environment starts playing in real time when first asked for, i.e. when
+// the first simulated controller is opened.
+func CurrentScenario() (*Player, error) {
+	scenarioMu.Lock()
+	defer scenarioMu.Unlock()
+	if !scenarioLoaded {
+		if path := os.Getenv(EnvScenario); path != "" {
+			s, err := LoadScenario(path)
+			if err != nil {
+				return nil, err
+			}
+			if player, err = NewPlayer(s); err != nil {
+				return nil, err
+			}
+			log.Printf("🎬 sim: playing scenario %s (%d steps)", s.Name, len(s.Steps))
+			go player.Run(context.Background())
+		}
+		scenarioLoaded = true
+	}
+	return player, nil
+}
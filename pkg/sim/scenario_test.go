@@ -0,0 +1,178 @@
+package sim
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"riscv-dev/pkg/config"
+	"riscv-dev/pkg/hal"
+)
+
+func intp(v int) *int { return &v }
+
+func TestScenarioPlayback(t *testing.T) {
+	s := &Scenario{
+		Name:  "overheat",
+		Noise: intp(0),
+		// Out of order on purpose: steps are played by their time
+		Steps: []Step{
+			{At: config.Duration(40 * time.Second), I2C: &I2CStep{Bus: "/dev/i2c-1", Address: intp(0x48), Fault: "nack"}},
+			{At: config.Duration(10 * time.Second), ADC: &ADCStep{Channel: 0, Value: intp(1000)}},
+			{At: config.Duration(20 * time.Second), ADC: &ADCStep{Channel: 0, Value: intp(2000), Over: config.Duration(10 * time.Second)}},
+			{At: config.Duration(50 * time.Second), I2C: &I2CStep{Fault: "none"}, Log: "bus recovered"},
+			{At: config.Duration(60 * time.Second), ADC: &ADCStep{Channel: 0, Release: true}},
+		},
+	}
+	p, err := NewPlayer(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adc := NewADC(0, 0)
+	adc.SetSource(0, func() int { return 100 })
+	adc.Attach(p)
+	bus, err := NewI2C("/dev/i2c-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus.SetRegisters(0x48, 0, []byte{0x19, 0x80})
+	bus.Attach(p)
+
+	ctx := context.Background()
+	for _, tc := range []struct {
+		at    time.Duration
+		value int
+		nack  bool
+	}{
+		{0, 100, false},
+		{10 * time.Second, 1000, false},
+		{20 * time.Second, 1000, false},
+		{25 * time.Second, 1500, false},
+		{28 * time.Second, 1800, false},
+		{30 * time.Second, 2000, false},
+		{40 * time.Second, 2000, true},
+		{49 * time.Second, 2000, true},
+		{50 * time.Second, 2000, false},
+		{60 * time.Second, 100, false},
+	} {
+		p.Advance(tc.at)
+		if v, err := adc.ReadChannel(ctx, 0); err != nil || v != tc.value {
+			t.Errorf("at %v: adc read %d, %v; want %d", tc.at, v, err, tc.value)
+		}
+		got, err := bus.WriteRead(ctx, 0x48, []byte{0}, 2)
+		if tc.nack {
+			if !errors.Is(err, hal.ErrNack) || !errors.Is(err, errSimulated) {
+				t.Errorf("at %v: i2c read %x, %v; want a simulated nack", tc.at, got, err)
+			}
+			// Other devices on the bus are unaffected
+			if _, err := bus.WriteRead(ctx, 0x49, []byte{0}, 1); err != nil {
+				t.Errorf("at %v: i2c read of another device: %v", tc.at, err)
+			}
+		} else if err != nil || got[0] != 0x19 || got[1] != 0x80 {
+			t.Errorf("at %v: i2c read %x, %v", tc.at, got, err)
+		}
+	}
+	if !p.Done() {
+		t.Error("not done after the last step")
+	}
+
+	// Time doesn't run backwards
+	p.Advance(15 * time.Second)
+	if p.Elapsed() != 60*time.Second {
+		t.Errorf("elapsed %v after moving back", p.Elapsed())
+	}
+}
+
+func TestScenarioRampFromSource(t *testing.T) {
+	p, err := NewPlayer(&Scenario{Name: "ramp", Noise: intp(0), Steps: []Step{
+		{At: config.Duration(10 * time.Second), ADC: &ADCStep{Channel: 1, Value: intp(1500), Over: config.Duration(10 * time.Second)}},
+		{At: config.Duration(30 * time.Second), ADC: &ADCStep{Channel: 1, Fault: "timeout"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	level := 500
+	adc := NewADC(0, 0)
+	adc.SetSource(1, func() int { return level })
+	adc.Attach(p)
+	ctx := context.Background()
+
+	// The ramp starts from what the source reads when it is first read,
+	// and later changes of the source don't move it
+	p.Advance(12 * time.Second)
+	if v, _ := adc.ReadChannel(ctx, 1); v != 700 {
+		t.Errorf("a fifth through the ramp %d, want 700", v)
+	}
+	level = 3000
+	p.Advance(15 * time.Second)
+	if v, _ := adc.ReadChannel(ctx, 1); v != 1000 {
+		t.Errorf("halfway through the ramp %d, want 1000", v)
+	}
+	p.Advance(30 * time.Second)
+	if _, err := adc.ReadChannel(ctx, 1); !errors.Is(err, hal.ErrTimeout) {
+		t.Errorf("faulted channel: %v", err)
+	}
+	// Unscripted channels still read mid-scale
+	if v, err := adc.ReadChannel(ctx, 2); err != nil || v != defaultResolution/2 {
+		t.Errorf("channel 2 read %d, %v", v, err)
+	}
+}
+
+func TestScenarioValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		step Step
+	}{
+		{"empty step", Step{}},
+		{"ramp without a value", Step{ADC: &ADCStep{Over: config.Duration(time.Second)}}},
+		{"value and release", Step{ADC: &ADCStep{Value: intp(1), Release: true}}},
+		{"unknown adc fault", Step{ADC: &ADCStep{Fault: "smoke"}}},
+		{"unknown i2c fault", Step{I2C: &I2CStep{Fault: "smoke"}}},
+	} {
+		if _, err := NewPlayer(&Scenario{Name: tc.name, Steps: []Step{tc.step}}); err == nil {
+			t.Errorf("%s accepted", tc.name)
+		}
+	}
+}
+
+func TestLoadScenarioYAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "brownout.yaml")
+	doc := `# the bus drops out for a while
+seed: 7
+steps:
+  - at: 5s
+    adc: {channel: 0, value: 1300, over: 20s}
+  - at: 30s
+    i2c: {bus: /dev/i2c-1, address: 0x48, fault: nack}
+    log: bus down
+`
+	if err := os.WriteFile(path, []byte(doc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := LoadScenario(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Name != "brownout" || s.Seed != 7 || len(s.Steps) != 2 {
+		t.Fatalf("loaded %+v", s)
+	}
+	adc, i2c := s.Steps[0].ADC, s.Steps[1].I2C
+	if s.Steps[0].At.D() != 5*time.Second || adc == nil || *adc.Value != 1300 || adc.Over.D() != 20*time.Second {
+		t.Errorf("step 1 = %+v, adc %+v", s.Steps[0], adc)
+	}
+	if i2c == nil || i2c.Bus != "/dev/i2c-1" || *i2c.Address != 0x48 || i2c.Fault != "nack" || s.Steps[1].Log != "bus down" {
+		t.Errorf("step 2 = %+v, i2c %+v", s.Steps[1], i2c)
+	}
+
+	// Invalid steps are reported as for JSON
+	if err := os.WriteFile(path, []byte("steps:\n  - at: 1s\n    i2c: {fault: smoke}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadScenario(path); err == nil || !strings.Contains(err.Error(), "unknown fault") {
+		t.Errorf("invalid scenario: %v", err)
+	}
+}
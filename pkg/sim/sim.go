@@ -0,0 +1,386 @@
+// Package sim provides simulated hardware backends so applications can run
+// on a development host or under QEMU user-mode without physical devices.
+// Importing the package registers the "sim" GPIO and ADC drivers with hal.
+// A board profile (see Board) limits the simulated hardware to what a real
+// board has, and a Scenario scripts what happens to it over time.
+package sim
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"riscv-dev/pkg/hal"
+)
+
+const (
+	defaultResolution = 4095
+	defaultReference  = 3.3
+	noiseCounts       = 10
+)
+
+// The simulator is always usable, so it has the lowest priority and is
+// only auto-selected when no hardware backend probes successfully.
+func init() {
+	hal.RegisterGPIODriver(hal.GPIODriver{
+		Name: "sim",
+		Open: func(cfg hal.GPIOConfig) (hal.GPIOController, error) {
+			b, err := CurrentBoard()
+			if err != nil {
+				return nil, err
+			}
+			p, err := CurrentScenario()
+			if err != nil {
+				return nil, err
+			}
+			g := NewGPIO()
+			if b != nil {
+				c, err := b.chip(cfg.Chip)
+				if err != nil {
+					return nil, err
+				}
+				g.chip, g.lines = c.Name, c.Lines
+			}
+			if p != nil {
+				g.Attach(p)
+			}
+			return g, nil
+		},
+	})
+	hal.RegisterADCDriver(hal.ADCDriver{
+		Name: "sim",
+		Open: func(cfg hal.ADCConfig) (hal.ADCController, error) {
+			b, err := CurrentBoard()
+			if err != nil {
+				return nil, err
+			}
+			p, err := CurrentScenario()
+			if err != nil {
+				return nil, err
+			}
+			var a *ADC
+			switch {
+			case b == nil:
+				a = NewADC(cfg.Resolution, cfg.ReferenceVoltage)
+			case b.ADC == nil:
+				return nil, &hal.Error{Op: "sim adc", Kind: hal.ErrNotSupported, Err: fmt.Errorf("%s has no ADC", b.Name)}
+			default:
+				a = NewADC(b.ADC.Resolution, b.ADC.ReferenceVoltage)
+				a.channels = b.ADC.Channels
+			}
+			if p != nil {
+				a.Attach(p)
+			}
+			return a, nil
+		},
+	})
+}
+
+// watchBuffer is the number of undelivered edge events kept per watcher;
+// further events are dropped while the consumer is not keeping up
+const watchBuffer = 256
+
+// GPIO simulates GPIO pins in memory
+type GPIO struct {
+	chip  string // name of the board's chip, if any
+	lines int    // number of pins on the chip; 0 for any
+
+	mu       sync.Mutex
+	modes    map[int]hal.PinMode
+	biases   map[int]hal.Bias
+	pins     map[int]bool
+	watchers map[int][]*watcher
+}
+
+type watcher struct {
+	edge hal.Edge
+	ch   chan hal.EdgeEvent
+}
+
+// NewGPIO creates a simulated GPIO controller
+func NewGPIO() *GPIO {
+	return &GPIO{
+		modes:    make(map[int]hal.PinMode),
+		biases:   make(map[int]hal.Bias),
+		pins:     make(map[int]bool),
+		watchers: make(map[int][]*watcher),
+	}
+}
+
+// checkPin fails for pins the simulated chip doesn't have
+func (g *GPIO) checkPin(op string, pin int) error {
+	if g.lines > 0 && (pin < 0 || pin >= g.lines) {
+		return &hal.Error{Op: fmt.Sprintf("sim gpio %s %s:%d", op, g.chip, pin), Kind: hal.ErrNotSupported,
+			Err: fmt.Errorf("line out of range (chip has %d)", g.lines)}
+	}
+	return nil
+}
+
+// SetMode records the pin direction
+func (g *GPIO) SetMode(pin int, mode hal.PinMode) error {
+	if err := g.checkPin("mode", pin); err != nil {
+		return err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.modes[pin] = mode
+	if _, ok := g.pins[pin]; !ok {
+		g.pins[pin] = false
+	}
+	return nil
+}
+
+// Write sets the simulated level of an output pin
+func (g *GPIO) Write(ctx context.Context, pin int, value bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := g.checkPin("write", pin); err != nil {
+		return err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if mode, ok := g.modes[pin]; !ok || mode != hal.Output {
+		return fmt.Errorf("GPIO%d is not configured as output", pin)
+	}
+	g.set(pin, value)
+	return nil
+}
+
+// Read returns the simulated level of a pin
+func (g *GPIO) Read(ctx context.Context, pin int) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	if err := g.checkPin("read", pin); err != nil {
+		return false, err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.pins[pin], nil
+}
+
+// LineStates returns the state of every configured pin
+func (g *GPIO) LineStates(ctx context.Context) ([]hal.LineState, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	states := make([]hal.LineState, 0, len(g.modes))
+	for pin, mode := range g.modes {
+		states = append(states, hal.LineState{Pin: pin, Mode: mode, Value: g.pins[pin], Bias: g.biases[pin]})
+	}
+	return states, nil
+}
+
+// SetLineState configures a pin; an output is set to s.Value, while an
+// input keeps the level driven on it
+func (g *GPIO) SetLineState(ctx context.Context, s hal.LineState) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := g.checkPin("configure", s.Pin); err != nil {
+		return err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.modes[s.Pin] = s.Mode
+	if s.Bias != hal.BiasDefault {
+		g.biases[s.Pin] = s.Bias
+	}
+	if s.Mode == hal.Output {
+		g.set(s.Pin, s.Value)
+	}
+	return nil
+}
+
+// SetInput drives the level seen on an input pin, as external hardware would
+func (g *GPIO) SetInput(pin int, value bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.set(pin, value)
+}
+
+// set changes a pin level and notifies edge watchers; g.mu must be held
+func (g *GPIO) set(pin int, value bool) {
+	old := g.pins[pin]
+	g.pins[pin] = value
+	if old == value {
+		return
+	}
+
+	ev := hal.EdgeEvent{Pin: pin, Rising: value, Time: time.Now()}
+	for _, w := range g.watchers[pin] {
+		if (value && w.edge == hal.FallingEdge) || (!value && w.edge == hal.RisingEdge) {
+			continue
+		}
+		select {
+		case w.ch <- ev:
+		default:
+		}
+	}
+}
+
+// WatchEdges reports simulated level changes on pin until ctx is done
+func (g *GPIO) WatchEdges(ctx context.Context, pin int, edge hal.Edge) (<-chan hal.EdgeEvent, error) {
+	if err := g.checkPin("watch", pin); err != nil {
+		return nil, err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, ok := g.modes[pin]; !ok {
+		g.modes[pin] = hal.Input
+	}
+	w := &watcher{edge: edge, ch: make(chan hal.EdgeEvent, watchBuffer)}
+	g.watchers[pin] = append(g.watchers[pin], w)
+
+	context.AfterFunc(ctx, func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		list := g.watchers[pin]
+		for i, other := range list {
+			if other == w {
+				g.watchers[pin] = append(list[:i:i], list[i+1:]...)
+				break
+			}
+		}
+		close(w.ch)
+	})
+	return w.ch, nil
+}
+
+// Attach plays p's GPIO steps on the controller, starting with the input
+// levels already played
+func (g *GPIO) Attach(p *Player) {
+	for pin, v := range p.addGPIO(g) {
+		g.SetInput(pin, v)
+	}
+}
+
+// Close releases the simulated pins
+func (g *GPIO) Close() error { return nil }
+
+// Source produces the ideal (noise-free) raw value for a simulated channel
+type Source func() int
+
+// ADC simulates an ADC with realistic noise around per-channel sources
+type ADC struct {
+	channels int // number of channels; 0 for any
+
+	mu         sync.Mutex
+	resolution int
+	reference  float64
+	sources    map[int]Source
+	noise      int
+	rng        *rand.Rand // nil for the shared source
+	player     *Player
+}
+
+// NewADC creates a simulated ADC; zero values select a 12-bit, 3.3V converter
+func NewADC(resolution int, reference float64) *ADC {
+	if resolution == 0 {
+		resolution = defaultResolution
+	}
+	if reference == 0 {
+		reference = defaultReference
+	}
+	return &ADC{
+		resolution: resolution,
+		reference:  reference,
+		sources:    make(map[int]Source),
+		noise:      noiseCounts,
+	}
+}
+
+// SetSource installs the signal generator for a channel
+func (a *ADC) SetSource(channel int, src Source) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.sources[channel] = src
+}
+
+// Attach plays p's ADC steps on the converter: scripted channels read the
+// scenario's values and faults instead of their sources
+func (a *ADC) Attach(p *Player) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.player = p
+	if n := p.scenario.Noise; n != nil {
+		a.noise = *n
+	}
+	if seed := p.scenario.Seed; seed != 0 {
+		a.rng = rand.New(rand.NewSource(seed))
+	}
+}
+
+// ReadChannel returns the channel's source value, or its scripted value if
+// a scenario is attached, plus ±10 counts of noise (or the scenario's),
+// clamped to the converter range. Channels without a source read mid-scale.
+func (a *ADC) ReadChannel(ctx context.Context, channel int) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if a.channels > 0 && (channel < 0 || channel >= a.channels) {
+		return 0, &hal.Error{Op: fmt.Sprintf("sim adc read channel %d", channel), Kind: hal.ErrNotSupported,
+			Err: fmt.Errorf("channel out of range (ADC has %d)", a.channels)}
+	}
+	a.mu.Lock()
+	src, ok := a.sources[channel]
+	p := a.player
+	a.mu.Unlock()
+
+	source := func() int {
+		if ok {
+			return src()
+		}
+		return a.resolution / 2
+	}
+	var value int
+	var script adcScript
+	if p != nil {
+		script = p.adcChannel(channel)
+	}
+	switch {
+	case script.fault != nil:
+		return 0, script.fault.err(fmt.Sprintf("sim adc read channel %d", channel))
+	case !script.set:
+		value = source()
+	default:
+		t := p.Elapsed()
+		if !script.fromKnown && t < script.start+script.over {
+			script = p.rampFrom(channel, source())
+		}
+		value = script.valueAt(t)
+	}
+
+	a.mu.Lock()
+	if a.noise > 0 {
+		if a.rng != nil {
+			value += a.rng.Intn(2*a.noise+1) - a.noise
+		} else {
+			value += rand.Intn(2*a.noise+1) - a.noise
+		}
+	}
+	a.mu.Unlock()
+
+	if value < 0 {
+		value = 0
+	}
+	if value > a.resolution {
+		value = a.resolution
+	}
+	return value, nil
+}
+
+// GetResolution returns the full-scale raw value
+func (a *ADC) GetResolution() int { return a.resolution }
+
+// GetReferenceVoltage returns the simulated reference voltage
+func (a *ADC) GetReferenceVoltage() float64 { return a.reference }
+
+// Close releases the simulated converter
+func (a *ADC) Close() error { return nil }
@@ -0,0 +1,456 @@
+// Package soak runs the agent pipeline for days of simulated time on the
+// sim backend, injecting faults at random, and checks the invariants a
+// board left in the field for months depends on: goroutines, heap and file
+// descriptors stay flat, sampling never stalls and no sink queue gets
+// stuck. A run ends with a pass/fail Report.
+//
+// Time is accelerated by scaling the agent's sample interval and history
+// window by Speed; other durations in the configuration, and the time of
+// day, run at their usual pace.
+package soak
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"runtime"
+	"sort"
+	"time"
+
+	"riscv-dev/pkg/agent"
+	"riscv-dev/pkg/config"
+	"riscv-dev/pkg/procstat"
+	"riscv-dev/pkg/sim"
+)
+
+// Config sets the length of a soak run, its pace and the faults injected
+type Config struct {
+	Days float64 `json:"days,omitempty"` // simulated; default 7
+	// Speed is the simulated time passing per second of real time;
+	// default 1440, a day a minute
+	Speed float64 `json:"speed,omitempty"`
+	// Seed makes the faults and ADC noise repeatable; 0 picks one
+	Seed int64 `json:"seed,omitempty"`
+	// FaultRate is the faults injected per simulated hour, default 2
+	FaultRate float64 `json:"fault_rate,omitempty"`
+	// GoroutineSlack is how many goroutines more than at the start of the
+	// run are tolerated at its end; default 5
+	GoroutineSlack int `json:"goroutine_slack,omitempty"`
+	// HeapGrowthPercent is how much the heap may grow from the first half
+	// of the run to the last quarter; default 50
+	HeapGrowthPercent float64 `json:"heap_growth_percent,omitempty"`
+
+	// Progress, if set, is called with every checkpoint
+	Progress func(Point) `json:"-"`
+}
+
+// Report is the outcome of a soak run
+type Report struct {
+	Pass      bool               `json:"pass"`
+	Seed      int64              `json:"seed"`
+	Speed     float64            `json:"speed"`
+	Simulated config.Duration    `json:"simulated"`
+	Wall      config.Duration    `json:"wall"`
+	Samples   int                `json:"samples"`
+	Checks    []Check            `json:"checks"`
+	Faults    []Fault            `json:"faults"`
+	Sinks     []agent.SinkStatus `json:"sinks"`
+	Points    []Point            `json:"points"`
+}
+
+// Check is one invariant and whether the run kept it
+type Check struct {
+	Name   string `json:"name"`
+	Pass   bool   `json:"pass"`
+	Detail string `json:"detail"`
+}
+
+// Fault is an injected failure, timed in simulated time from the start
+type Fault struct {
+	At      config.Duration `json:"at"`
+	For     config.Duration `json:"for"`
+	Kind    string          `json:"kind"`              // adc_fault, spike or sink_outage
+	Channel string          `json:"channel,omitempty"` // for adc_fault and spike
+	Detail  string          `json:"detail,omitempty"`
+}
+
+// Point is the state of the process at a checkpoint
+type Point struct {
+	At         config.Duration `json:"at"` // simulated
+	Samples    int             `json:"samples"`
+	Goroutines int             `json:"goroutines"`
+	HeapBytes  uint64          `json:"heap_bytes"` // after a GC
+	OpenFDs    int             `json:"open_fds"`   // -1 without /proc
+	Queued     int             `json:"queued"`     // across all sinks
+}
+
+// minInterval bounds the scaled sample interval, so a fast run measures
+// the pipeline rather than the scheduler
+const minInterval = time.Millisecond
+
+// drainTimeout is how long the sinks get to empty their queues at the end
+const drainTimeout = 5 * time.Second
+
+// stallCheckpoints is how many checkpoints in a row a sink may hold
+// readings without delivering any, outside of an outage, before its queue
+// counts as stuck
+const stallCheckpoints = 3
+
+func (c *Config) defaults() {
+	if c.Days <= 0 {
+		c.Days = 7
+	}
+	if c.Speed <= 0 {
+		c.Speed = 1440
+	}
+	if c.Seed == 0 {
+		c.Seed = time.Now().UnixNano()
+	}
+	if c.FaultRate < 0 {
+		c.FaultRate = 0
+	} else if c.FaultRate == 0 {
+		c.FaultRate = 2
+	}
+	if c.GoroutineSlack <= 0 {
+		c.GoroutineSlack = 5
+	}
+	if c.HeapGrowthPercent <= 0 {
+		c.HeapGrowthPercent = 50
+	}
+}
+
+// Run soaks the pipeline configured by acfg, keeping its files in dir. The
+// hardware is replaced by the sim backend, the sinks by a file sink and a
+// sink that fails during injected outages, and network-facing features
+// (metrics endpoint, provisioning, election) are left out.
+func Run(ctx context.Context, acfg agent.Config, cfg Config, dir string) (Report, error) {
+	cfg.defaults()
+	total := time.Duration(cfg.Days * float64(24*time.Hour))
+	scale := func(d time.Duration) time.Duration { return time.Duration(float64(d) / cfg.Speed) }
+	rep := Report{Seed: cfg.Seed, Speed: cfg.Speed}
+
+	acfg, err := prepare(acfg, scale, dir)
+	if err != nil {
+		return rep, err
+	}
+	rng := rand.New(rand.NewSource(cfg.Seed))
+	rep.Faults = schedule(rng, acfg.Channels, cfg.FaultRate, total)
+	steps := scenarioSteps(rep.Faults, acfg.Channels, scale)
+	player, err := sim.NewPlayer(&sim.Scenario{Name: "soak", Seed: cfg.Seed, Steps: steps})
+	if err != nil {
+		return rep, err
+	}
+	sim.SetScenario(player)
+	defer sim.SetScenario(nil)
+
+	a, err := agent.New(acfg)
+	if err != nil {
+		return rep, err
+	}
+	defer a.Close()
+	start := time.Now()
+	elapsed := func() time.Duration { return time.Duration(float64(time.Since(start)) * cfg.Speed) }
+	if adc, ok := a.ADC().(*sim.ADC); ok {
+		res := adc.GetResolution()
+		for i, ch := range acfg.Channels {
+			phase := 2 * math.Pi * float64(i) / float64(len(acfg.Channels))
+			adc.SetSource(ch.Channel, func() int {
+				day := float64(elapsed()) / float64(24*time.Hour)
+				return res/2 + int(float64(res)/8*math.Sin(2*math.Pi*day+phase))
+			})
+		}
+	}
+	flaky := &flakySink{elapsed: elapsed}
+	for _, f := range rep.Faults {
+		if f.Kind == "sink_outage" {
+			flaky.outages = append(flaky.outages, [2]time.Duration{f.At.D(), f.At.D() + f.For.D()})
+		}
+	}
+	opts := agent.DefaultSinkOptions
+	opts.Backoff, opts.MaxBackoff, opts.Timeout = 10*time.Millisecond, 200*time.Millisecond, time.Second
+	if err := a.AddSinkWithOptions("soak", flaky, opts); err != nil {
+		return rep, err
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	runErr := make(chan error, 1)
+	go func() { runErr <- a.Run(runCtx) }()
+	go player.Run(runCtx)
+
+	checkEvery := min(time.Hour, total/24)
+	ticker := time.NewTicker(max(scale(checkEvery), 10*time.Millisecond))
+	defer ticker.Stop()
+	stalled := make(map[string]int)
+	var stuck []string
+	prev := make(map[string]uint64)
+	var sampleStall int
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			return rep, ctx.Err()
+		case err := <-runErr:
+			return rep, fmt.Errorf("pipeline stopped: %v", err)
+		case <-ticker.C:
+		}
+		p := checkpoint(a, elapsed())
+		if n := len(rep.Points); n > 0 && p.Samples == rep.Points[n-1].Samples {
+			sampleStall++
+		}
+		rep.Points = append(rep.Points, p)
+		if cfg.Progress != nil {
+			cfg.Progress(p)
+		}
+		for _, st := range a.SinkStatus() {
+			done := st.Delivered + st.Rollups + st.Dropped
+			if st.Queued > 0 && done == prev[st.Name] && !flaky.overlaps(st.Name, p.At.D()-checkEvery, p.At.D()) {
+				stalled[st.Name]++
+				if stalled[st.Name] == stallCheckpoints {
+					stuck = append(stuck, fmt.Sprintf("%s at %v", st.Name, p.At.D().Round(time.Minute)))
+				}
+			} else {
+				stalled[st.Name] = 0
+			}
+			prev[st.Name] = done
+		}
+		if p.At.D() >= total {
+			break loop
+		}
+	}
+	rep.Simulated = config.Duration(elapsed())
+
+	drained := waitDrained(a, drainTimeout)
+	rep.Sinks = a.SinkStatus()
+	rep.Samples = a.SampleCount()
+	cancel()
+	stopped := true
+	select {
+	case <-runErr:
+	case <-time.After(10 * time.Second):
+		stopped = false
+	}
+	rep.Wall = config.Duration(time.Since(start))
+
+	rep.Checks = evaluate(rep.Points, cfg)
+	rep.Checks = append(rep.Checks,
+		check("sampling never stalled", sampleStall == 0, "%d checkpoints without a new sample", sampleStall),
+		check("no stuck sink queue", len(stuck) == 0, "%s", describe(stuck, "every queue kept moving")),
+		check("queues drained", drained, "%d readings still queued %v after the run", queued(rep.Sinks), drainTimeout),
+		check("clean shutdown", stopped, "%s", map[bool]string{true: "stopped when cancelled", false: "still running 10s after being cancelled"}[stopped]),
+	)
+	rep.Pass = true
+	for _, c := range rep.Checks {
+		rep.Pass = rep.Pass && c.Pass
+	}
+	return rep, nil
+}
+
+// prepare adapts the agent configuration to a soak run
+func prepare(acfg agent.Config, scale func(time.Duration) time.Duration, dir string) (agent.Config, error) {
+	if len(acfg.Channels) == 0 {
+		acfg.Channels = []agent.ChannelConfig{
+			{Name: "temperature", Channel: 0, Unit: "°C"},
+			{Name: "humidity", Channel: 1, Unit: "%"},
+			{Name: "pressure", Channel: 2, Unit: "kPa"},
+		}
+	}
+	if acfg.SampleInterval <= 0 {
+		acfg.SampleInterval = config.Duration(time.Second)
+	}
+	interval := max(scale(acfg.SampleInterval.D()), minInterval)
+	acfg.HistoryDuration = config.Duration(max(scale(acfg.HistoryDuration.D()), interval))
+	acfg.SampleInterval = config.Duration(interval)
+
+	acfg.ADC.Driver = "sim"
+	if acfg.GPIO != nil {
+		acfg.GPIO.Driver = "sim"
+	}
+	acfg.DataDir = dir
+	acfg.HistoryDir, acfg.StateFile, acfg.CalibrationFile = "", "", ""
+	if acfg.Audit != nil {
+		acfg.Audit.Path = "audit.jsonl"
+	}
+	acfg.Sound, acfg.Carrier, acfg.KernelLog, acfg.DiskHealth = nil, nil, nil, nil
+	acfg.MetricsAddr, acfg.Provisioning, acfg.Election = "", nil, nil
+	acfg.Offline, acfg.NetworkWait = false, nil
+
+	var file agent.SinkConfig
+	if err := json.Unmarshal([]byte(`{"type": "file", "path": "readings.jsonl", "max_size": 1048576, "compress": true}`), &file); err != nil {
+		return acfg, err
+	}
+	acfg.Sinks = []agent.SinkConfig{file}
+	return acfg, nil
+}
+
+// schedule picks the faults of a run: a Poisson process of FaultRate per
+// hour, leaving the last tenth of the run quiet so recovery can be checked
+func schedule(rng *rand.Rand, channels []agent.ChannelConfig, rate float64, total time.Duration) []Fault {
+	var faults []Fault
+	if rate <= 0 {
+		return nil
+	}
+	kinds := []string{"adc_fault", "spike", "sink_outage"}
+	adcFaults := []string{"nack", "timeout", "busy", "io"}
+	end := total * 9 / 10
+	for at := time.Duration(0); ; {
+		at += time.Duration(rng.ExpFloat64() / rate * float64(time.Hour)).Round(time.Second)
+		if at >= end {
+			return faults
+		}
+		d := 10*time.Second + time.Duration(rng.Int63n(int64(30*time.Minute))).Round(time.Second)
+		f := Fault{At: config.Duration(at), For: config.Duration(min(d, end-at)), Kind: kinds[rng.Intn(len(kinds))]}
+		if f.Kind != "sink_outage" {
+			f.Channel = channels[rng.Intn(len(channels))].Name
+		}
+		if f.Kind == "adc_fault" {
+			f.Detail = adcFaults[rng.Intn(len(adcFaults))]
+		}
+		faults = append(faults, f)
+	}
+}
+
+// scenarioSteps turns the ADC faults into sim scenario steps, timed in
+// real time
+func scenarioSteps(faults []Fault, channels []agent.ChannelConfig, scale func(time.Duration) time.Duration) []sim.Step {
+	adc := make(map[string]int)
+	for _, ch := range channels {
+		adc[ch.Name] = ch.Channel
+	}
+	full := 1 << 30 // clamped to the converter's range
+	var steps []sim.Step
+	for _, f := range faults {
+		at, until := config.Duration(scale(f.At.D())), config.Duration(scale(f.At.D()+f.For.D()))
+		ch := adc[f.Channel]
+		switch f.Kind {
+		case "adc_fault":
+			steps = append(steps,
+				sim.Step{At: at, ADC: &sim.ADCStep{Channel: ch, Fault: f.Detail}},
+				sim.Step{At: until, ADC: &sim.ADCStep{Channel: ch, Fault: "none"}})
+		case "spike":
+			steps = append(steps,
+				sim.Step{At: at, ADC: &sim.ADCStep{Channel: ch, Value: &full}},
+				sim.Step{At: until, ADC: &sim.ADCStep{Channel: ch, Release: true}})
+		}
+	}
+	return steps
+}
+
+// flakySink accepts readings except during outages
+type flakySink struct {
+	elapsed func() time.Duration // simulated
+	outages [][2]time.Duration
+}
+
+var errOutage = errors.New("injected sink outage")
+
+func (s *flakySink) Write(ctx context.Context, r agent.Reading) error {
+	if s.overlaps("soak", s.elapsed(), s.elapsed()) {
+		return errOutage
+	}
+	return nil
+}
+
+// overlaps reports whether an outage of the named sink falls within from
+// to to; only the soak sink has any
+func (s *flakySink) overlaps(name string, from, to time.Duration) bool {
+	if name != "soak" {
+		return false
+	}
+	for _, o := range s.outages {
+		if o[0] <= to && o[1] >= from {
+			return true
+		}
+	}
+	return false
+}
+
+func checkpoint(a *agent.Agent, at time.Duration) Point {
+	runtime.GC()
+	st := procstat.Read()
+	return Point{
+		At:         config.Duration(at),
+		Samples:    a.SampleCount(),
+		Goroutines: st.Goroutines,
+		HeapBytes:  st.HeapBytes,
+		OpenFDs:    st.OpenFDs,
+		Queued:     queued(a.SinkStatus()),
+	}
+}
+
+func queued(sinks []agent.SinkStatus) int {
+	n := 0
+	for _, st := range sinks {
+		n += st.Queued
+	}
+	return n
+}
+
+// waitDrained waits for every sink queue to empty
+func waitDrained(a *agent.Agent, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for queued(a.SinkStatus()) > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return true
+}
+
+// evaluate checks the resource invariants: the end of the run is compared
+// with its start, once caches, history and queues have filled
+func evaluate(points []Point, cfg Config) []Check {
+	n := len(points)
+	if n < 4 {
+		return []Check{check("enough checkpoints", false, "only %d checkpoints; run longer or slower", n)}
+	}
+	early, half, last := points[1:n/4+1], points[:n/2], points[n*3/4:]
+	peak := func(ps []Point, v func(Point) float64) float64 {
+		m := 0.0
+		for _, p := range ps {
+			m = max(m, v(p))
+		}
+		return m
+	}
+	goroutines := func(p Point) float64 { return float64(p.Goroutines) }
+	fds := func(p Point) float64 { return float64(p.OpenFDs) }
+
+	g0, g1 := peak(early, goroutines), peak(last, goroutines)
+	h0, h1 := medianHeap(half), medianHeap(last)
+	checks := []Check{
+		check("no goroutine growth", g1 <= g0+float64(cfg.GoroutineSlack), "%.0f at the start, %.0f at the end", g0, g1),
+		check("bounded memory", h1 <= h0*(1+cfg.HeapGrowthPercent/100)+1<<20,
+			"median heap %.1f MiB in the first half, %.1f MiB in the last quarter", h0/(1<<20), h1/(1<<20)),
+	}
+	if points[0].OpenFDs >= 0 {
+		f0, f1 := peak(early, fds), peak(last, fds)
+		checks = append(checks, check("no descriptor leak", f1 <= f0+2, "%.0f open at the start, %.0f at the end", f0, f1))
+	}
+	return checks
+}
+
+// medianHeap returns the median heap size of the checkpoints, which a GC
+// landing between two of them doesn't move
+func medianHeap(ps []Point) float64 {
+	v := make([]float64, len(ps))
+	for i, p := range ps {
+		v[i] = float64(p.HeapBytes)
+	}
+	sort.Float64s(v)
+	return v[len(v)/2]
+}
+
+func check(name string, pass bool, format string, args ...any) Check {
+	return Check{Name: name, Pass: pass, Detail: fmt.Sprintf(format, args...)}
+}
+
+func describe(items []string, none string) string {
+	if len(items) == 0 {
+		return none
+	}
+	return fmt.Sprint(items)
+}
@@ -0,0 +1,116 @@
+package sound
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// CaptureConfig selects an ALSA capture device, such as an I2S MEMS
+// microphone bound to a simple-audio-card overlay
+type CaptureConfig struct {
+	Device string `json:"device,omitempty"` // e.g. hw:1,0; default "default"
+	Rate   int    `json:"rate,omitempty"`   // default 48000
+	// Format is S16_LE, or S32_LE (the default) for 24-bit I2S
+	// microphones, which send their samples in 32-bit slots
+	Format string `json:"format,omitempty"`
+	// Channel picks the left (0) or right (1) slot of a stereo I2S bus,
+	// where each microphone's L/R pin selects its slot
+	Channel int `json:"channel,omitempty"`
+}
+
+// WithDefaults fills in the defaults for anything unset
+func (c CaptureConfig) WithDefaults() CaptureConfig {
+	if c.Device == "" {
+		c.Device = "default"
+	}
+	if c.Rate == 0 {
+		c.Rate = 48000
+	}
+	if c.Format == "" {
+		c.Format = "S32_LE"
+	}
+	return c
+}
+
+// Capture records from the device with arecord, from alsa-utils, and
+// passes the samples, scaled to ±1, to fn until ctx is done or recording
+// fails
+func Capture(ctx context.Context, cfg CaptureConfig, fn func([]float64)) error {
+	cfg = cfg.WithDefaults()
+	var width int
+	switch cfg.Format {
+	case "S16_LE":
+		width = 2
+	case "S32_LE":
+		width = 4
+	default:
+		return fmt.Errorf("unsupported format %q (want S16_LE or S32_LE)", cfg.Format)
+	}
+	if cfg.Channel < 0 || cfg.Channel > 1 {
+		return fmt.Errorf("channel %d: want 0 or 1", cfg.Channel)
+	}
+	channels := cfg.Channel + 1
+
+	cmd := exec.CommandContext(ctx, "arecord", "-q", "-t", "raw",
+		"-D", cfg.Device, "-f", cfg.Format, "-r", strconv.Itoa(cfg.Rate), "-c", strconv.Itoa(channels))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("arecord: %w", err)
+	}
+
+	// About 20ms of audio per call
+	frame := width * channels
+	frames := cfg.Rate / 50
+	buf := make([]byte, frames*frame)
+	samples := make([]float64, frames)
+	r := bufio.NewReaderSize(out, len(buf))
+	var readErr error
+	for {
+		n, err := io.ReadFull(r, buf)
+		n /= frame
+		for i := 0; i < n; i++ {
+			b := buf[i*frame+cfg.Channel*width:]
+			if width == 2 {
+				samples[i] = float64(int16(binary.LittleEndian.Uint16(b))) / (1 << 15)
+			} else {
+				samples[i] = float64(int32(binary.LittleEndian.Uint32(b))) / (1 << 31)
+			}
+		}
+		if n > 0 {
+			fn(samples[:n])
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				readErr = err
+			}
+			break
+		}
+	}
+	err = cmd.Wait()
+	if ctx.Err() != nil {
+		return nil
+	}
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return fmt.Errorf("arecord: %s", msg)
+	}
+	if err == nil {
+		err = readErr
+	}
+	if err == nil {
+		err = errors.New("arecord stopped")
+	}
+	return err
+}
@@ -0,0 +1,56 @@
+package sound
+
+import (
+	"math"
+	"sync"
+)
+
+// Meter measures the equivalent continuous level (Leq) of weighted
+// samples. Levels are in dB relative to full scale, where a full-scale
+// sine is 0 dBFS as in microphone datasheets.
+type Meter struct {
+	mu     sync.Mutex
+	filter *Filter
+	sum    float64
+	n      int
+}
+
+// NewMeter creates a meter for samples at rate Hz
+func NewMeter(weighting string, rate int) (*Meter, error) {
+	f, err := NewFilter(weighting, rate)
+	if err != nil {
+		return nil, err
+	}
+	return &Meter{filter: f}, nil
+}
+
+// Write weights and accumulates samples scaled to ±1
+func (m *Meter) Write(samples []float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, x := range samples {
+		y := m.filter.Process(x)
+		m.sum += y * y
+	}
+	m.n += len(samples)
+}
+
+// Level returns the level of the samples written since the last call; ok
+// is false if there were none
+func (m *Meter) Level() (dbfs float64, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.n == 0 {
+		return 0, false
+	}
+	ms := m.sum / float64(m.n)
+	m.sum, m.n = 0, 0
+	// A full-scale sine has a mean square of 1/2
+	return 10 * math.Log10(2*ms+1e-20), true
+}
+
+// SPL converts a level in dBFS to dB SPL for a microphone whose datasheet
+// gives its sensitivity as the output in dBFS at 94 dB SPL
+func SPL(dbfs, sensitivity float64) float64 {
+	return dbfs - sensitivity + 94
+}
@@ -0,0 +1,53 @@
+package sound
+
+import (
+	"math"
+	"testing"
+)
+
+// TestAWeighting checks the filter against the IEC 61672-1 A-weighting
+// table at 48 kHz
+func TestAWeighting(t *testing.T) {
+	f, err := NewFilter(A, 48000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct{ freq, db, tol float64 }{
+		{31.5, -39.4, 0.3},
+		{100, -19.1, 0.2},
+		{1000, 0, 0.01},
+		{4000, 1.0, 0.2},
+		{8000, -1.1, 0.7},
+	} {
+		got := 20 * math.Log10(f.Response(c.freq, 48000))
+		if math.Abs(got-c.db) > c.tol {
+			t.Errorf("%g Hz: %.2f dB, want %.1f", c.freq, got, c.db)
+		}
+	}
+}
+
+func TestMeter(t *testing.T) {
+	const rate = 48000
+	m, err := NewMeter(A, rate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.Level(); ok {
+		t.Error("level without samples")
+	}
+	// One second of a 1 kHz sine at half scale, after the filter settles
+	samples := make([]float64, rate)
+	for i := range samples {
+		samples[i] = 0.5 * math.Sin(2*math.Pi*1000*float64(i)/rate)
+	}
+	m.Write(samples)
+	m.Level()
+	m.Write(samples)
+	got, ok := m.Level()
+	if !ok || math.Abs(got-(-6.02)) > 0.05 {
+		t.Errorf("level %.2f dBFS, want -6.02", got)
+	}
+	if spl := SPL(got, -26); math.Abs(spl-113.98) > 0.05 {
+		t.Errorf("SPL %.2f dB, want 113.98", spl)
+	}
+}
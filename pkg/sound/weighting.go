@@ -0,0 +1,110 @@
+// Package sound turns audio samples into sound levels: frequency weighting
+// as in IEC 61672, equivalent continuous levels, and capture from ALSA
+// devices such as I2S MEMS microphones.
+package sound
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+)
+
+// Weightings
+const (
+	A = "A" // follows the ear's sensitivity; levels in dB(A)
+	Z = "Z" // flat, only removing the DC offset of MEMS microphones
+)
+
+// biquad is a second-order IIR section in direct form I
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+func (q *biquad) process(x float64) float64 {
+	y := q.b0*x + q.b1*q.x1 + q.b2*q.x2 - q.a1*q.y1 - q.a2*q.y2
+	q.x2, q.x1 = q.x1, x
+	q.y2, q.y1 = q.y1, y
+	return y
+}
+
+// response returns the section's complex gain at z
+func (q *biquad) response(z complex128) complex128 {
+	zi := 1 / z
+	num := complex(q.b0, 0) + complex(q.b1, 0)*zi + complex(q.b2, 0)*zi*zi
+	den := 1 + complex(q.a1, 0)*zi + complex(q.a2, 0)*zi*zi
+	return num / den
+}
+
+// bilinear maps the analog section (b2 s² + b1 s + b0) / (a2 s² + a1 s + a0)
+// to a digital one at the sample rate
+func bilinear(b2, b1, b0, a2, a1, a0, rate float64) *biquad {
+	k := 2 * rate
+	k2 := k * k
+	A0 := a2*k2 + a1*k + a0
+	return &biquad{
+		b0: (b2*k2 + b1*k + b0) / A0,
+		b1: (2*b0 - 2*b2*k2) / A0,
+		b2: (b2*k2 - b1*k + b0) / A0,
+		a1: (2*a0 - 2*a2*k2) / A0,
+		a2: (a2*k2 - a1*k + a0) / A0,
+	}
+}
+
+// Filter applies a frequency weighting to a stream of samples
+type Filter struct {
+	sections []*biquad
+	gain     float64
+}
+
+// NewFilter creates a weighting filter for a sample rate. The A weighting
+// is the analog one mapped by the bilinear transform, which rolls off
+// early near Nyquist: 0.6 dB low at 8 kHz for 48 kHz, well within the
+// class 1 tolerances.
+func NewFilter(weighting string, rate int) (*Filter, error) {
+	if rate <= 0 {
+		return nil, fmt.Errorf("invalid sample rate %d", rate)
+	}
+	fs := float64(rate)
+	f := &Filter{gain: 1}
+	switch weighting {
+	case A, "":
+		// Poles of IEC 61672-1, with four zeros at 0 Hz
+		w1 := 2 * math.Pi * 20.598997
+		w2 := 2 * math.Pi * 107.65265
+		w3 := 2 * math.Pi * 737.86223
+		w4 := 2 * math.Pi * 12194.217
+		f.sections = []*biquad{
+			bilinear(1, 0, 0, 1, 2*w1, w1*w1, fs),
+			bilinear(1, 0, 0, 1, w2+w3, w2*w3, fs),
+			bilinear(0, 0, 1, 1, 2*w4, w4*w4, fs),
+		}
+		// 0 dB at 1 kHz
+		f.gain = 1 / f.Response(1000, rate)
+	case Z:
+		// First-order high-pass at 10 Hz: s / (s + w)
+		w, k := 2*math.Pi*10, 2*fs
+		f.sections = []*biquad{{b0: k / (k + w), b1: -k / (k + w), a1: (w - k) / (k + w)}}
+	default:
+		return nil, fmt.Errorf("unknown weighting %q (want A or Z)", weighting)
+	}
+	return f, nil
+}
+
+// Process filters one sample
+func (f *Filter) Process(x float64) float64 {
+	for _, s := range f.sections {
+		x = s.process(x)
+	}
+	return x * f.gain
+}
+
+// Response returns the filter's gain at freq Hz
+func (f *Filter) Response(freq float64, rate int) float64 {
+	z := cmplx.Exp(complex(0, 2*math.Pi*freq/float64(rate)))
+	h := complex(f.gain, 0)
+	for _, s := range f.sections {
+		h *= s.response(z)
+	}
+	return cmplx.Abs(h)
+}
@@ -0,0 +1,225 @@
+// Package state keeps durable counters and small values, such as sample
+// counts, pulse totals, accumulated uptime and update attempts, in a file
+// that survives restarts, crashes and power loss.
+//
+// The file holds two copies of the records written alternately through
+// nvstore, each with a checksum, so a write torn by a power cut leaves the
+// previous copy to fall back on: the store loses at most the last Flush.
+// Changes are kept in memory until Flush, letting callers choose how often
+// to wear the flash.
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"riscv-dev/pkg/nvstore"
+)
+
+// DefaultSize is the size of a new state file, holding two copies of up
+// to 16 KiB of records
+const DefaultSize = 32 << 10
+
+// Store is a state file. Its methods are safe for concurrent use.
+type Store struct {
+	mu        sync.Mutex
+	f         *os.File
+	nv        *nvstore.Store
+	recovered bool
+}
+
+// Open opens the state file at path, creating it if needed. A file that
+// can't be read as a store is moved aside to path.corrupt and replaced by
+// an empty one; Recovered then reports true.
+func Open(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	s, err := open(path)
+	if err == nil || errors.Is(err, os.ErrPermission) || errors.Is(err, errIO) {
+		return s, err
+	}
+	if err := os.Rename(path, path+".corrupt"); err != nil {
+		return nil, err
+	}
+	if s, err = open(path); err != nil {
+		return nil, err
+	}
+	s.recovered = true
+	return s, nil
+}
+
+// errIO marks failures to read the file, as opposed to bad contents
+var errIO = errors.New("state: I/O error")
+
+func open(path string) (*Store, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err == nil && info.Size() == 0 {
+		if err = f.Truncate(DefaultSize); err == nil {
+			info, err = f.Stat()
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%w: %s: %v", errIO, path, err)
+	}
+	nv, err := nvstore.Open(context.Background(), fileMemory{f, int(info.Size())})
+	if err != nil {
+		f.Close()
+		var pe *os.PathError
+		if errors.As(err, &pe) {
+			return nil, fmt.Errorf("%w: %v", errIO, err)
+		}
+		return nil, fmt.Errorf("state: %s: %w", path, err)
+	}
+	return &Store{f: f, nv: nv}, nil
+}
+
+// Recovered reports whether Open found the file unreadable and started
+// afresh
+func (s *Store) Recovered() bool { return s.recovered }
+
+// Get returns a value
+func (s *Store) Get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nv.Get(key)
+}
+
+// Set sets a value, failing with nvstore.ErrFull if the store is full
+func (s *Store) Set(key, value string) error {
+	return s.Update(func(tx *Tx) error {
+		tx.Set(key, value)
+		return nil
+	})
+}
+
+// Counter returns a counter, 0 if it was never set
+func (s *Store) Counter(key string) uint64 {
+	v, _ := s.Get(key)
+	n, _ := strconv.ParseUint(v, 10, 64)
+	return n
+}
+
+// Add adds delta to a counter and returns its new value
+func (s *Store) Add(key string, delta uint64) (uint64, error) {
+	var n uint64
+	err := s.Update(func(tx *Tx) error {
+		n = tx.Add(key, delta)
+		return nil
+	})
+	return n, err
+}
+
+// Keys returns the keys in order
+func (s *Store) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nv.Keys()
+}
+
+// Tx is a set of changes made together by Update
+type Tx struct {
+	s       *Store
+	changes map[string]string
+}
+
+// Get returns a value, as changed so far in the transaction
+func (tx *Tx) Get(key string) (string, bool) {
+	if v, ok := tx.changes[key]; ok {
+		return v, true
+	}
+	return tx.s.nv.Get(key)
+}
+
+// Set sets a value
+func (tx *Tx) Set(key, value string) { tx.changes[key] = value }
+
+// Counter returns a counter, 0 if it was never set
+func (tx *Tx) Counter(key string) uint64 {
+	v, _ := tx.Get(key)
+	n, _ := strconv.ParseUint(v, 10, 64)
+	return n
+}
+
+// Add adds delta to a counter and returns its new value
+func (tx *Tx) Add(key string, delta uint64) uint64 {
+	n := tx.Counter(key) + delta
+	tx.Set(key, strconv.FormatUint(n, 10))
+	return n
+}
+
+// Update runs fn and applies its changes together, or none of them if fn
+// returns an error or they don't fit. Other updates wait until it returns.
+func (s *Store) Update(fn func(tx *Tx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx := &Tx{s: s, changes: make(map[string]string)}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	type undo struct {
+		key, value string
+		had        bool
+	}
+	var applied []undo
+	for k, v := range tx.changes {
+		old, had := s.nv.Get(k)
+		if err := s.nv.Set(k, v); err != nil {
+			for i := len(applied) - 1; i >= 0; i-- {
+				u := applied[i]
+				if u.had {
+					s.nv.Set(u.key, u.value)
+				} else {
+					s.nv.Delete(u.key)
+				}
+			}
+			return err
+		}
+		applied = append(applied, undo{k, old, had})
+	}
+	return nil
+}
+
+// Flush writes the changes made since the last Flush to the file
+func (s *Store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nv.Commit(context.Background())
+}
+
+// Close flushes and closes the file
+func (s *Store) Close() error {
+	err := s.Flush()
+	return errors.Join(err, s.f.Close())
+}
+
+// fileMemory is a file as nvstore memory, synced after each write so a
+// copy is on disk before the store relies on it
+type fileMemory struct {
+	f    *os.File
+	size int
+}
+
+func (m fileMemory) Size() int { return m.size }
+
+func (m fileMemory) ReadAt(ctx context.Context, p []byte, off int) error {
+	_, err := m.f.ReadAt(p, int64(off))
+	return err
+}
+
+func (m fileMemory) WriteAt(ctx context.Context, p []byte, off int) error {
+	if _, err := m.f.WriteAt(p, int64(off)); err != nil {
+		return err
+	}
+	return m.f.Sync()
+}
@@ -0,0 +1,99 @@
+package state
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"riscv-dev/pkg/nvstore"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := s.Add("samples", 5); n != 5 {
+		t.Errorf("add: %d", n)
+	}
+	s.Add("samples", 2)
+	s.Set("boot", "a")
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if n := s.Counter("samples"); n != 7 {
+		t.Errorf("samples after reopening: %d", n)
+	}
+	if v, _ := s.Get("boot"); v != "a" || s.Recovered() {
+		t.Errorf("boot %q, recovered %v", v, s.Recovered())
+	}
+
+	// A failed update changes nothing
+	failed := errors.New("failed")
+	err = s.Update(func(tx *Tx) error {
+		tx.Add("samples", 1)
+		tx.Set("boot", "b")
+		return failed
+	})
+	if err != failed || s.Counter("samples") != 7 {
+		t.Errorf("failed update: %v, samples %d", err, s.Counter("samples"))
+	}
+	err = s.Update(func(tx *Tx) error {
+		tx.Add("samples", 1)
+		tx.Set("huge", strings.Repeat("x", 20000))
+		return nil
+	})
+	if !errors.Is(err, nvstore.ErrFull) || s.Counter("samples") != 7 {
+		t.Errorf("oversized update: %v, samples %d", err, s.Counter("samples"))
+	}
+	if _, ok := s.Get("huge"); ok {
+		t.Error("oversized value stored")
+	}
+}
+
+func TestTornWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state")
+	s, _ := Open(path)
+	s.Add("n", 1)
+	s.Flush()
+	s.Add("n", 1)
+	s.Close()
+
+	// Damage the newer copy, in the second slot
+	f, _ := os.OpenFile(path, os.O_RDWR, 0)
+	f.WriteAt([]byte("garbage"), DefaultSize/2+10) // its checksum
+	f.Close()
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := s.Counter("n"); n != 1 {
+		t.Errorf("n = %d, want the previous copy's 1", n)
+	}
+	s.Close()
+}
+
+func TestCorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state")
+	os.WriteFile(path, []byte("not a store"), 0644)
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if !s.Recovered() || len(s.Keys()) != 0 {
+		t.Errorf("recovered %v, keys %v", s.Recovered(), s.Keys())
+	}
+	if _, err := os.Stat(path + ".corrupt"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,29 @@
+package termout
+
+import (
+	"math"
+	"strings"
+)
+
+// sparks are the bar heights of a sparkline, lowest first
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+// Sparkline draws values as a line of bars scaled between lo and hi, one
+// character each; NaN values, gaps in the data, are left blank. With lo
+// and hi equal, every value is drawn at mid height.
+func Sparkline(values []float64, lo, hi float64) string {
+	var b strings.Builder
+	for _, v := range values {
+		if math.IsNaN(v) {
+			b.WriteByte(' ')
+			continue
+		}
+		i := len(sparks) / 2
+		if hi > lo {
+			i = int(math.Round((v - lo) / (hi - lo) * float64(len(sparks)-1)))
+			i = min(max(i, 0), len(sparks)-1)
+		}
+		b.WriteRune(sparks[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,213 @@
+// Package termout picks how a program writes its output: pretty, with
+// emoji and colour, for a person at a terminal, or plain key=value or JSON
+// lines when it goes to journald, a file or a pipe, where it is grepped
+// and parsed rather than read.
+//
+// SetupLog converts the standard logger's lines, which across this
+// repository start with an emoji giving their severity ("❌", "⚠️ ",
+// "✅"), so code keeps logging as it does:
+//
+//	log.Printf("⚠️  Disk %s: %s", dev, msg)
+//
+// is written in plain mode as
+//
+//	time=2026-03-01T15:04:05.000Z level=warn msg="Disk mmcblk0: ..."
+//
+// Cbreak, Size and Sparkline are the pieces of a full-screen dashboard.
+package termout
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode"
+	"unicode/utf8"
+	"unsafe"
+)
+
+// Mode is an output format
+type Mode string
+
+const (
+	Pretty Mode = "pretty" // emoji and colour, for a terminal
+	Plain  Mode = "plain"  // logfmt key=value lines
+	JSON   Mode = "json"   // one JSON object per line
+)
+
+// EnvMode names the environment variable forcing a mode, as the -output
+// flag of the example applications does
+const EnvMode = "RISCV_DEV_OUTPUT"
+
+// ParseMode parses a mode name; "auto" and "" return "", for Detect
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(strings.ToLower(s)); m {
+	case "", "auto":
+		return "", nil
+	case Pretty, Plain, JSON:
+		return m, nil
+	}
+	return "", fmt.Errorf("output %q (want auto, pretty, plain or json)", s)
+}
+
+// Detect returns the mode for output to f: forced by $RISCV_DEV_OUTPUT if
+// set, else pretty if f is a terminal and plain otherwise
+func Detect(f *os.File) Mode {
+	if m, err := ParseMode(os.Getenv(EnvMode)); err == nil && m != "" {
+		return m
+	}
+	if IsTerminal(f) {
+		return Pretty
+	}
+	return Plain
+}
+
+// IsTerminal reports whether f is a terminal
+func IsTerminal(f *os.File) bool {
+	var t syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), ioctlGetTermios, uintptr(unsafe.Pointer(&t)))
+	return errno == 0
+}
+
+// SetupLog makes the standard logger write in mode: unchanged when
+// pretty, and otherwise as timestamped lines with a level, the emoji
+// removed
+func SetupLog(mode Mode) {
+	if mode == Pretty || mode == "" {
+		return
+	}
+	log.SetFlags(0)
+	log.SetOutput(NewWriter(os.Stderr, mode))
+}
+
+// NewWriter returns a writer converting log lines to mode before writing
+// them to w. Each Write is one record, as the log package's are; newlines
+// within a message are escaped rather than starting a new record.
+func NewWriter(w io.Writer, mode Mode) io.Writer {
+	return &writer{w: w, mode: mode}
+}
+
+type writer struct {
+	mu   sync.Mutex
+	w    io.Writer
+	mode Mode
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	level, msg := Split(strings.TrimSuffix(string(p), "\n"))
+	line := Format(w.mode, time.Now(), level, msg, nil)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := io.WriteString(w.w, line); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// levels maps the leading emoji of a message to its level
+var levels = map[string]string{
+	"❌": "error",
+	"⚠": "warn",
+	"🚨": "error",
+	"🔥": "error",
+}
+
+// Split removes the emoji a message starts with and returns the level it
+// stands for ("error", "warn" or "info") with the rest
+func Split(line string) (level, msg string) {
+	level = "info"
+	rest := line
+	for rest != "" {
+		r, size := utf8.DecodeRuneInString(rest)
+		if !isDecoration(r) {
+			break
+		}
+		if l, ok := levels[string(r)]; ok && level == "info" {
+			level = l
+		}
+		rest = rest[size:]
+	}
+	if rest == line {
+		return level, line
+	}
+	return level, strings.TrimLeft(rest, " ")
+}
+
+// isDecoration reports whether r is part of an emoji prefix: a symbol,
+// a variation selector or joiner, or the space after it
+func isDecoration(r rune) bool {
+	switch r {
+	case ' ', '\ufe0f', '\u200d':
+		return true
+	}
+	return r >= 0x2000 && unicode.IsSymbol(r)
+}
+
+// Format returns a record as a line in mode, with fields after the
+// message in key order. Pretty records are the message alone.
+func Format(mode Mode, t time.Time, level, msg string, fields map[string]any) string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	switch mode {
+	case JSON:
+		var b strings.Builder
+		b.WriteString(`{"time":`)
+		writeJSON(&b, t.UTC().Format(time.RFC3339Nano))
+		b.WriteString(`,"level":`)
+		writeJSON(&b, level)
+		b.WriteString(`,"msg":`)
+		writeJSON(&b, msg)
+		for _, k := range keys {
+			b.WriteByte(',')
+			writeJSON(&b, k)
+			b.WriteByte(':')
+			writeJSON(&b, fields[k])
+		}
+		b.WriteString("}\n")
+		return b.String()
+	case Plain:
+		var b strings.Builder
+		b.WriteString("time=" + t.UTC().Format("2006-01-02T15:04:05.000Z07:00"))
+		b.WriteString(" level=" + level)
+		b.WriteString(" msg=" + quote(msg))
+		for _, k := range keys {
+			b.WriteString(" " + k + "=" + quote(fmt.Sprint(fields[k])))
+		}
+		b.WriteByte('\n')
+		return b.String()
+	}
+	return msg + "\n"
+}
+
+// quote quotes a logfmt value if it needs it
+func quote(s string) string {
+	if s == "" || strings.ContainsAny(s, " =\"\\\t\n") || !utf8.ValidString(s) {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// writeJSON writes v as JSON, a value JSON has no number for (NaN, ±Inf)
+// as null
+func writeJSON(b *strings.Builder, v any) {
+	if f, ok := v.(float64); ok && (math.IsNaN(f) || math.IsInf(f, 0)) {
+		b.WriteString("null")
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	b.Write(data)
+}
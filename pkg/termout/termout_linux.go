@@ -0,0 +1,9 @@
+package termout
+
+import "syscall"
+
+// ioctl requests reading and setting a terminal's attributes
+const (
+	ioctlGetTermios = syscall.TCGETS
+	ioctlSetTermios = syscall.TCSETS
+)
@@ -0,0 +1,82 @@
+package termout
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct{ line, level, msg string }{
+		{"❌ Sink http: timeout", "error", "Sink http: timeout"},
+		{"⚠️  Disk mmcblk0: worn", "warn", "Disk mmcblk0: worn"},
+		{"✅ Storage: 40.0% free on /data", "info", "Storage: 40.0% free on /data"},
+		{"🌡️ Temperature", "info", "Temperature"},
+		{"Start 1, 0 samples", "info", "Start 1, 0 samples"},
+		{"°C offset 2", "info", "°C offset 2"},
+	}
+	for _, tt := range tests {
+		level, msg := Split(tt.line)
+		if level != tt.level || msg != tt.msg {
+			t.Errorf("Split(%q) = %q, %q; want %q, %q", tt.line, level, msg, tt.level, tt.msg)
+		}
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	l := log.New(NewWriter(&buf, Plain), "", 0)
+	l.Printf("⚠️  Sink %s: %s", "http", `said "no"`)
+	want := ` level=warn msg="Sink http: said \"no\""` + "\n"
+	if got := buf.String(); len(got) < len(want) || got[len(got)-len(want):] != want || got[:5] != "time=" {
+		t.Errorf("plain = %q", got)
+	}
+
+	buf.Reset()
+	l = log.New(NewWriter(&buf, JSON), "", 0)
+	l.Printf("❌ Sink down")
+	var rec map[string]string
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("json %q: %v", buf.String(), err)
+	}
+	if rec["level"] != "error" || rec["msg"] != "Sink down" || rec["time"] == "" {
+		t.Errorf("json = %v", rec)
+	}
+}
+
+func TestFormatFields(t *testing.T) {
+	at := time.Date(2026, 3, 1, 15, 4, 5, 0, time.UTC)
+	got := Format(Plain, at, "info", "reading", map[string]any{"temperature": 21.5, "light": "n/a"})
+	want := "time=2026-03-01T15:04:05.000Z level=info msg=reading light=n/a temperature=21.5\n"
+	if got != want {
+		t.Errorf("Format = %q, want %q", got, want)
+	}
+	if got := Format(Pretty, at, "info", "hello", nil); got != "hello\n" {
+		t.Errorf("pretty = %q", got)
+	}
+	if _, err := ParseMode("loud"); err == nil {
+		t.Error("ParseMode accepted an unknown mode")
+	}
+}
+
+func TestWriterMultiline(t *testing.T) {
+	var buf bytes.Buffer
+	l := log.New(NewWriter(&buf, Plain), "", 0)
+	l.Printf("❌ config:\nline 2")
+	if got := buf.String(); bytes.Count(buf.Bytes(), []byte("\n")) != 1 || !bytes.Contains(buf.Bytes(), []byte(`msg="config:\nline 2"`)) {
+		t.Errorf("plain = %q", got)
+	}
+}
+
+func TestSparkline(t *testing.T) {
+	nan := math.NaN()
+	if got, want := Sparkline([]float64{0, 1, 2, 3, 4, 5, 6, 7, nan, 10, -3}, 0, 7), "▁▂▃▄▅▆▇█ █▁"; got != want {
+		t.Errorf("Sparkline = %q, want %q", got, want)
+	}
+	if got, want := Sparkline([]float64{3, 3}, 3, 3), "▅▅"; got != want {
+		t.Errorf("flat Sparkline = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,41 @@
+package termout
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Cbreak puts the terminal f into cbreak mode, where each key press is
+// read as it is typed and not echoed, and returns a func restoring the
+// previous mode. Ctrl+C still raises SIGINT.
+func Cbreak(f *os.File) (restore func() error, err error) {
+	var old syscall.Termios
+	if err := termios(f, ioctlGetTermios, &old); err != nil {
+		return nil, err
+	}
+	t := old
+	t.Lflag &^= syscall.ICANON | syscall.ECHO
+	t.Cc[syscall.VMIN] = 1
+	t.Cc[syscall.VTIME] = 0
+	if err := termios(f, ioctlSetTermios, &t); err != nil {
+		return nil, err
+	}
+	return func() error { return termios(f, ioctlSetTermios, &old) }, nil
+}
+
+func termios(f *os.File, req uintptr, t *syscall.Termios) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, uintptr(unsafe.Pointer(t))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Size returns the width and height of the terminal f in characters
+func Size(f *os.File) (cols, rows int, err error) {
+	var ws struct{ Row, Col, X, Y uint16 }
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&ws))); errno != 0 {
+		return 0, 0, errno
+	}
+	return int(ws.Col), int(ws.Row), nil
+}
@@ -0,0 +1,130 @@
+// Package tlsconfig is the TLS settings block shared by every network
+// output (HTTP sinks, MQTT, gRPC and the hub client), so a secure
+// deployment configures its CA bundle and client certificate once:
+//
+//	"tls": {
+//	  "ca_file":   "/etc/riscv-dev/ca.pem",
+//	  "cert_file": "/etc/riscv-dev/device.pem",
+//	  "key_file":  "/etc/riscv-dev/device.key"
+//	}
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+)
+
+// Config describes the TLS side of a connection. The zero value uses the
+// system roots and no client certificate.
+type Config struct {
+	CAFile     string `json:"ca_file,omitempty"`     // PEM bundle replacing the system roots
+	CertFile   string `json:"cert_file,omitempty"`   // PEM certificate for mutual TLS
+	KeyFile    string `json:"key_file,omitempty"`    // PEM key matching CertFile
+	ServerName string `json:"server_name,omitempty"` // overrides the name checked against the server certificate
+
+	// InsecureSkipVerify accepts any server certificate. It is meant for
+	// bench setups only and is logged loudly whenever it is used.
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty"`
+}
+
+// Client returns a client-side tls.Config for connecting to name, which is
+// only used for log messages. A nil Config gives a default tls.Config.
+func (c *Config) Client(name string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c == nil {
+		return cfg, nil
+	}
+	cfg.ServerName = c.ServerName
+
+	if c.CAFile != "" {
+		pool, err := loadPool(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := c.keyPair()
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if c.InsecureSkipVerify {
+		log.Printf("⚠️  TLS: certificate verification DISABLED for %s; the connection can be intercepted. Do not use insecure_skip_verify in production.", name)
+		cfg.InsecureSkipVerify = true
+	}
+	return cfg, nil
+}
+
+// Server returns a server-side tls.Config. CertFile and KeyFile are
+// required; if CAFile is set, clients must present a certificate signed
+// by it (mutual TLS).
+func (c *Config) Server() (*tls.Config, error) {
+	if c == nil || c.CertFile == "" {
+		return nil, fmt.Errorf("tls: cert_file and key_file are required to serve TLS")
+	}
+	cert, err := c.keyPair()
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, Certificates: []tls.Certificate{cert}}
+	if c.CAFile != "" {
+		pool, err := loadPool(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// Merge returns c with unset fields taken from defaults, so a sink can
+// override part of the shared block
+func (c *Config) Merge(defaults *Config) *Config {
+	if c == nil {
+		return defaults
+	}
+	if defaults == nil {
+		return c
+	}
+	m := *c
+	if m.CAFile == "" {
+		m.CAFile = defaults.CAFile
+	}
+	if m.CertFile == "" && m.KeyFile == "" {
+		m.CertFile, m.KeyFile = defaults.CertFile, defaults.KeyFile
+	}
+	if m.ServerName == "" {
+		m.ServerName = defaults.ServerName
+	}
+	m.InsecureSkipVerify = m.InsecureSkipVerify || defaults.InsecureSkipVerify
+	return &m
+}
+
+func (c *Config) keyPair() (tls.Certificate, error) {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return tls.Certificate{}, fmt.Errorf("tls: cert_file and key_file must be set together")
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("tls: %w", err)
+	}
+	return cert, nil
+}
+
+func loadPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("tls: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("tls: no certificates found in %s", path)
+	}
+	return pool, nil
+}
@@ -0,0 +1,338 @@
+// Package ubootenv reads and modifies the U-Boot environment from Linux,
+// like fw_printenv and fw_setenv, so applications can set boot variables
+// such as the A/B slot to boot after an update.
+//
+// The environment is located with an fw_env.config file: one line per
+// copy, two for a redundant environment, each giving the device, the
+// offset and size of the environment, and optionally the erase sector size
+// and count. Devices may be MTD flash (/dev/mtdN), a block device or
+// partition (/dev/mmcblk0, /dev/mmcblk0boot1) or a plain file.
+package ubootenv
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"riscv-dev/pkg/hal"
+)
+
+// DefaultConfig is where fw_printenv looks for its configuration
+const DefaultConfig = "/etc/fw_env.config"
+
+// ErrNoValidEnv means no copy of the environment has a valid CRC, as on a
+// board that has never saved its environment and boots with U-Boot's
+// built-in default
+var ErrNoValidEnv = errors.New("ubootenv: no copy of the environment has a valid CRC")
+
+// Location is one copy of the environment
+type Location struct {
+	Device     string
+	Offset     int64
+	Size       int64
+	SectorSize int64 // as given in fw_env.config; writes use the device's erase size
+}
+
+// ParseConfig reads an fw_env.config file's locations
+func ParseConfig(r io.Reader) ([]Location, error) {
+	var locs []Location
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("fw_env.config line %d: want device, offset and size", n)
+		}
+		loc := Location{Device: fields[0]}
+		nums := []*int64{&loc.Offset, &loc.Size, &loc.SectorSize}
+		for i, f := range fields[1:] {
+			if i >= len(nums) {
+				break // the sector count is implied by the size
+			}
+			v, err := strconv.ParseInt(f, 0, 64)
+			if err != nil || v < 0 {
+				return nil, fmt.Errorf("fw_env.config line %d: invalid number %q", n, f)
+			}
+			*nums[i] = v
+		}
+		if loc.Size <= 5 {
+			return nil, fmt.Errorf("fw_env.config line %d: environment size %d too small", n, loc.Size)
+		}
+		locs = append(locs, loc)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	switch {
+	case len(locs) == 0:
+		return nil, errors.New("fw_env.config: no environment location")
+	case len(locs) > 2:
+		return nil, errors.New("fw_env.config: more than two environment locations")
+	case len(locs) == 2 && locs[0].Size != locs[1].Size:
+		return nil, errors.New("fw_env.config: redundant copies differ in size")
+	}
+	return locs, nil
+}
+
+// LoadConfig reads an fw_env.config file, DefaultConfig if path is empty
+func LoadConfig(path string) ([]Location, error) {
+	if path == "" {
+		path = DefaultConfig
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseConfig(f)
+}
+
+// Env is a U-Boot environment read from its device. Changes are kept in
+// memory until Save.
+type Env struct {
+	locs   []Location
+	vars   map[string]string
+	active int  // copy read, -1 if none was valid
+	flag   byte // redundancy flag of the active copy
+}
+
+// redundant reports whether there are two copies, each with a flag byte
+// after the CRC
+func (e *Env) redundant() bool { return len(e.locs) == 2 }
+
+// dataSize is the space for variables after the header
+func (e *Env) dataSize() int {
+	if e.redundant() {
+		return int(e.locs[0].Size) - 5
+	}
+	return int(e.locs[0].Size) - 4
+}
+
+// Read reads the environment from locs, picking the newer valid copy of a
+// redundant environment. If no copy is valid it returns an empty Env along
+// with ErrNoValidEnv; saving it replaces U-Boot's default environment.
+func Read(ctx context.Context, locs []Location) (*Env, error) {
+	e := &Env{locs: locs, vars: make(map[string]string), active: -1}
+	var datas [2][]byte
+	var flags [2]byte
+	valid := [2]bool{}
+	for i, loc := range locs {
+		buf := make([]byte, loc.Size)
+		if err := readLocation(ctx, loc, buf); err != nil {
+			return nil, err
+		}
+		hdr := 4
+		if e.redundant() {
+			hdr, flags[i] = 5, buf[4]
+		}
+		datas[i] = buf[hdr:]
+		valid[i] = crc32.ChecksumIEEE(datas[i]) == binary.LittleEndian.Uint32(buf)
+	}
+	switch {
+	case valid[0] && valid[1]:
+		e.active = 0
+		if newer(flags[1], flags[0]) {
+			e.active = 1
+		}
+	case valid[0]:
+		e.active = 0
+	case valid[1]:
+		e.active = 1
+	default:
+		return e, ErrNoValidEnv
+	}
+	e.flag = flags[e.active]
+	vars, err := decode(datas[e.active])
+	if err != nil {
+		return nil, fmt.Errorf("ubootenv: %s: %w", locs[e.active].Device, err)
+	}
+	e.vars = vars
+	return e, nil
+}
+
+// newer reports whether flag a marks a newer copy than b. U-Boot compares
+// the flags as a counter, which also covers the active/obsolete (1/0)
+// flags used on NOR flash.
+func newer(a, b byte) bool {
+	switch {
+	case a == b:
+		return false
+	case a == 0 && b == 0xFF:
+		return true
+	case a == 0xFF && b == 0:
+		return false
+	}
+	return a > b
+}
+
+// decode parses NUL-terminated name=value strings, ended by an empty one
+func decode(data []byte) (map[string]string, error) {
+	vars := make(map[string]string)
+	for len(data) > 0 && data[0] != 0 {
+		end := bytes.IndexByte(data, 0)
+		if end < 0 {
+			return nil, errors.New("unterminated variable")
+		}
+		kv := string(data[:end])
+		if k, v, ok := strings.Cut(kv, "="); ok && k != "" {
+			vars[k] = v
+		}
+		data = data[end+1:]
+	}
+	return vars, nil
+}
+
+// Get returns the value of a variable
+func (e *Env) Get(name string) (string, bool) {
+	v, ok := e.vars[name]
+	return v, ok
+}
+
+// Names returns the variable names in order
+func (e *Env) Names() []string {
+	names := make([]string, 0, len(e.vars))
+	for k := range e.vars {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Set adds or replaces a variable; an empty value deletes it, as with
+// fw_setenv
+func (e *Env) Set(name, value string) error {
+	if name == "" || strings.ContainsAny(name, "=\x00") {
+		return fmt.Errorf("ubootenv: invalid variable name %q", name)
+	}
+	if strings.ContainsRune(value, 0) {
+		return fmt.Errorf("ubootenv: value of %s contains a NUL byte", name)
+	}
+	if value == "" {
+		delete(e.vars, name)
+		return nil
+	}
+	e.vars[name] = value
+	return nil
+}
+
+// encode lays out the variables sorted by name, as U-Boot saves them, and
+// pads to the data size
+func (e *Env) encode() ([]byte, error) {
+	var b bytes.Buffer
+	for _, k := range e.Names() {
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(e.vars[k])
+		b.WriteByte(0)
+	}
+	b.WriteByte(0)
+	if b.Len() > e.dataSize() {
+		return nil, fmt.Errorf("ubootenv: %d bytes of variables exceed the %d byte environment", b.Len(), e.dataSize())
+	}
+	data := make([]byte, e.dataSize())
+	copy(data, b.Bytes())
+	return data, nil
+}
+
+// Save writes the environment. A redundant environment is written to the
+// copy not read from, with a newer flag, so the old copy stays valid until
+// the new one is complete.
+func (e *Env) Save(ctx context.Context) error {
+	data, err := e.encode()
+	if err != nil {
+		return err
+	}
+	var buf []byte
+	buf = binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(data))
+	target := 0
+	if e.redundant() {
+		flag := e.flag + 1
+		if e.active == 0 {
+			target = 1
+		} else if e.active < 0 {
+			flag = 1
+		}
+		buf = append(buf, flag)
+		e.flag = flag
+	}
+	buf = append(buf, data...)
+	if err := writeLocation(ctx, e.locs[target], buf); err != nil {
+		return err
+	}
+	e.active = target
+	return nil
+}
+
+func isMTD(dev string) bool {
+	return strings.HasPrefix(filepath.Base(dev), "mtd")
+}
+
+func readLocation(ctx context.Context, loc Location, buf []byte) error {
+	if isMTD(loc.Device) {
+		mtd, err := hal.OpenMTD(loc.Device)
+		if err != nil {
+			return err
+		}
+		defer mtd.Close()
+		return mtd.ReadAt(ctx, buf, loc.Offset)
+	}
+	f, err := os.Open(loc.Device)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.ReadAt(buf, loc.Offset); err != nil {
+		return fmt.Errorf("ubootenv: read %s: %w", loc.Device, err)
+	}
+	return nil
+}
+
+func writeLocation(ctx context.Context, loc Location, buf []byte) error {
+	if isMTD(loc.Device) {
+		mtd, err := hal.OpenMTD(loc.Device)
+		if err != nil {
+			return err
+		}
+		defer mtd.Close()
+		_, _, err = hal.UpdateFlash(ctx, mtd, buf, loc.Offset)
+		return err
+	}
+
+	// eMMC boot partitions are read-only until force_ro is cleared
+	forceRO := filepath.Join("/sys/class/block", filepath.Base(loc.Device), "force_ro")
+	if ro, err := os.ReadFile(forceRO); err == nil && strings.TrimSpace(string(ro)) == "1" {
+		if err := os.WriteFile(forceRO, []byte("0"), 0); err != nil {
+			return fmt.Errorf("ubootenv: unlock %s: %w", loc.Device, err)
+		}
+		defer os.WriteFile(forceRO, []byte("1"), 0)
+	}
+	f, err := os.OpenFile(loc.Device, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(buf, loc.Offset); err != nil {
+		f.Close()
+		return fmt.Errorf("ubootenv: write %s: %w", loc.Device, err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("ubootenv: write %s: %w", loc.Device, err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,98 @@
+package ubootenv
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	locs, err := ParseConfig(strings.NewReader(`
+# MTD device name	Device offset	Env. size	Flash sector size	Number of sectors
+/dev/mtd1		0x0000		0x10000		0x10000
+/dev/mtd1		0x10000		0x10000		0x10000	1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locs) != 2 || locs[1].Offset != 0x10000 || locs[1].Size != 0x10000 || locs[0].SectorSize != 0x10000 {
+		t.Errorf("locations %+v", locs)
+	}
+	if _, err := ParseConfig(strings.NewReader("/dev/mtd1 0x0\n")); err == nil {
+		t.Error("line without size accepted")
+	}
+}
+
+// image builds a redundant copy as U-Boot saves it
+func image(size int, flag byte, vars ...string) []byte {
+	data := make([]byte, size-5)
+	copy(data, strings.Join(vars, "\x00")+"\x00\x00")
+	buf := binary.LittleEndian.AppendUint32(nil, crc32.ChecksumIEEE(data))
+	return append(append(buf, flag), data...)
+}
+
+func TestRedundant(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "env.img")
+	const size = 0x400
+	content := append(image(size, 3, "boot_slot=a", "bootdelay=2"), image(size, 2, "boot_slot=b")...)
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	locs := []Location{{Device: path, Size: size}, {Device: path, Offset: size, Size: size}}
+
+	env, err := Read(ctx, locs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := env.Get("boot_slot"); v != "a" {
+		t.Fatalf("boot_slot %q, want a from the copy with the newer flag", v)
+	}
+	env.Set("boot_slot", "b")
+	env.Set("bootdelay", "")
+	if err := env.Save(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second copy now holds the change with flag 4; the first is untouched
+	raw, _ := os.ReadFile(path)
+	if raw[size+4] != 4 || string(raw[:size]) != string(content[:size]) {
+		t.Errorf("second copy flag %d, first copy changed: %v", raw[size+4], string(raw[:size]) != string(content[:size]))
+	}
+	env, err = Read(ctx, locs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := env.Get("boot_slot"); v != "b" || len(env.Names()) != 1 {
+		t.Errorf("after save: boot_slot %q, names %v", v, env.Names())
+	}
+
+	// A corrupt copy falls back to the other
+	raw[size+10] ^= 0xFF
+	os.WriteFile(path, raw, 0644)
+	env, _ = Read(ctx, locs)
+	if v, _ := env.Get("boot_slot"); v != "a" {
+		t.Errorf("with the newer copy corrupt: boot_slot %q, want a", v)
+	}
+	raw[10] ^= 0xFF
+	os.WriteFile(path, raw, 0644)
+	if _, err := Read(ctx, locs); !errors.Is(err, ErrNoValidEnv) {
+		t.Errorf("both corrupt: got %v", err)
+	}
+}
+
+func TestNewer(t *testing.T) {
+	for _, c := range []struct {
+		a, b byte
+		want bool
+	}{{1, 0, true}, {0, 1, false}, {0, 0xFF, true}, {0xFF, 0, false}, {5, 5, false}} {
+		if got := newer(c.a, c.b); got != c.want {
+			t.Errorf("newer(%d, %d) = %v", c.a, c.b, got)
+		}
+	}
+}
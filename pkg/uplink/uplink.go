@@ -0,0 +1,540 @@
+// Package uplink keeps network traffic on the best working interface of a
+// preferred list, e.g. Ethernet, then Wi-Fi, then cellular, and brings a
+// cellular link up only while nothing better works.
+//
+// Connections follow the choice by binding to the interface in use
+// (SO_BINDTODEVICE, which needs CAP_NET_RAW): pass Manager.Control to a
+// net.Dialer. Each interface needs a default route of its own, which
+// DHCP clients add with increasing metrics and pppd with defaultroute and
+// defaultroute-metric.
+package uplink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"riscv-dev/pkg/config"
+	"riscv-dev/pkg/netwait"
+)
+
+// Config lists the interfaces in order of preference
+type Config struct {
+	Interfaces []Interface `json:"interfaces"`
+	// Probe is a TCP address, such as a sink's host:port, an interface
+	// must reach to count as working; without it an interface works while
+	// it is up with an address
+	Probe string `json:"probe,omitempty"`
+	// Interval between checks; default 10s
+	Interval config.Duration `json:"interval,omitempty"`
+	// Failback is how long a preferred interface must work again before
+	// traffic returns to it; default 1m
+	Failback config.Duration `json:"failback,omitempty"`
+}
+
+// Interface is a network interface, e.g. eth0, wlan0, ppp0 or wwan0
+type Interface struct {
+	Name string `json:"name"`
+	// Link brings the interface up and down on demand; without it the
+	// interface is left to the system, e.g. to a DHCP client
+	Link *Link `json:"link,omitempty"`
+}
+
+// Link brings up an interface, typically a cellular one, with one of
+// PPPPeer, QMIDevice, Command or Up
+type Link struct {
+	// PPPPeer runs "pppd call <peer> nodetach" while the link is wanted,
+	// with the peer in /etc/ppp/peers
+	PPPPeer string `json:"ppp_peer,omitempty"`
+	// QMIDevice starts a data session with qmi-network on the modem's QMI
+	// control device, e.g. /dev/cdc-wdm0, using /etc/qmi-network.conf for
+	// the APN, and runs udhcpc on the interface for an address
+	QMIDevice string `json:"qmi_device,omitempty"`
+	// Command runs while the link is wanted and is killed to bring it
+	// down, e.g. a custom dialler
+	Command []string `json:"command,omitempty"`
+	// Up and Down are run once to bring the link up and down, e.g.
+	// ["mmcli", "-m", "0", "--simple-connect=apn=internet"]
+	Up   []string `json:"up,omitempty"`
+	Down []string `json:"down,omitempty"`
+	// KeepUp keeps the link up while a preferred interface is in use, for
+	// a quicker failover at the cost of data
+	KeepUp bool `json:"keep_up,omitempty"`
+}
+
+// Defaults, and how long a link that is up but doesn't work is given
+// before it is brought up afresh
+const (
+	defaultInterval = 10 * time.Second
+	defaultFailback = time.Minute
+	probeTimeout    = 5 * time.Second
+	linkRetry       = 2 * time.Minute
+)
+
+// Manager checks the interfaces and picks the one in use
+type Manager struct {
+	cfg      Config
+	onChange func(Change)
+	ifaces   []*iface
+	uplink   *Route   // over every interface
+	routes   []*Route // the uplink's first
+	checked  bool     // once, so a start without a working interface is logged
+
+	// check reports whether an interface works, for tests to replace
+	check func(ctx context.Context, name string) error
+
+	mu sync.Mutex // guards the routes' choices and the ifaces' state
+}
+
+// iface is an interface's state
+type iface struct {
+	Interface
+	link    *link // nil if left to the system
+	working bool
+	since   time.Time // of working changing
+	err     error     // why it doesn't work
+}
+
+// Route is traffic with interfaces of its own in order of preference, such
+// as a sink that should use Wi-Fi before Ethernet. It uses the first of
+// them that works, and the uplink in use while none does.
+type Route struct {
+	m       *Manager
+	name    string
+	order   []int // into m.ifaces
+	current int   // into m.ifaces, -1 for none
+}
+
+// Change is traffic moving between interfaces
+type Change struct {
+	// Route is the route's name, "" for the uplink
+	Route string
+	// From and To are the interfaces, "" for none
+	From, To string
+	// Err is why From stopped working, nil when traffic fails back
+	Err error
+}
+
+// New creates a manager. onChange, which may be nil, is called from Run
+// when traffic moves, for the uplink and for each route.
+func New(cfg Config, onChange func(Change)) (*Manager, error) {
+	if len(cfg.Interfaces) == 0 {
+		return nil, errors.New("uplink: interfaces are required")
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = config.Duration(defaultInterval)
+	}
+	if cfg.Failback <= 0 {
+		cfg.Failback = config.Duration(defaultFailback)
+	}
+	if onChange == nil {
+		onChange = func(Change) {}
+	}
+	m := &Manager{cfg: cfg, onChange: onChange}
+	m.check = m.checkInterface
+	m.uplink = &Route{m: m, current: -1}
+	seen := make(map[string]bool)
+	for i, ic := range cfg.Interfaces {
+		if ic.Name == "" || seen[ic.Name] {
+			return nil, fmt.Errorf("uplink: interface %q: name must be set and unique", ic.Name)
+		}
+		seen[ic.Name] = true
+		st := &iface{Interface: ic, err: errors.New("not checked yet")}
+		if ic.Link != nil {
+			l, err := newLink(ic.Name, *ic.Link)
+			if err != nil {
+				return nil, fmt.Errorf("uplink: %s: %w", ic.Name, err)
+			}
+			st.link = l
+		}
+		m.ifaces = append(m.ifaces, st)
+		m.uplink.order = append(m.uplink.order, i)
+	}
+	m.routes = []*Route{m.uplink}
+	return m, nil
+}
+
+// Route adds a route over some of the interfaces, in order of preference;
+// call it before Run
+func (m *Manager) Route(name string, interfaces []string) (*Route, error) {
+	if len(interfaces) == 0 {
+		return nil, fmt.Errorf("uplink: route %s: interfaces are required", name)
+	}
+	r := &Route{m: m, name: name, current: -1}
+	for _, n := range interfaces {
+		i := m.index(n)
+		if i < 0 {
+			return nil, fmt.Errorf("uplink: route %s: interface %q isn't one of the uplink's", name, n)
+		}
+		r.order = append(r.order, i)
+	}
+	m.routes = append(m.routes, r)
+	return r, nil
+}
+
+func (m *Manager) index(name string) int {
+	for i, st := range m.ifaces {
+		if st.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Current returns the interface in use, "" if none works
+func (m *Manager) Current() string { return m.uplink.Interface() }
+
+// Control binds a socket to the interface in use, for net.Dialer.Control;
+// with none working, sockets are left to the routing table
+func (m *Manager) Control(network, address string, c syscall.RawConn) error {
+	return m.uplink.Control(network, address, c)
+}
+
+// Interface returns the interface the route uses, "" if none of its own
+// works
+func (r *Route) Interface() string {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	if r.current < 0 {
+		return ""
+	}
+	return r.m.ifaces[r.current].Name
+}
+
+// Control binds a socket to the route's interface, or to the uplink in
+// use while none of its own works, for net.Dialer.Control
+func (r *Route) Control(network, address string, c syscall.RawConn) error {
+	name := r.Interface()
+	if name == "" && r != r.m.uplink {
+		name = r.m.Current()
+	}
+	if name == "" {
+		return nil
+	}
+	return bindToDevice(c, name)
+}
+
+// Run checks the interfaces every Interval and moves traffic as they fail
+// and recover, until ctx ends; then it brings its links down
+func (m *Manager) Run(ctx context.Context) {
+	defer func() {
+		for _, st := range m.ifaces {
+			if st.link != nil {
+				st.link.set(false)
+			}
+		}
+	}()
+	t := time.NewTicker(m.cfg.Interval.D())
+	defer t.Stop()
+	for {
+		m.step(ctx, time.Now())
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// step checks every interface, picks the one each route uses and brings
+// links up or down to suit
+func (m *Manager) step(ctx context.Context, now time.Time) {
+	errs := make([]error, len(m.ifaces))
+	for i, st := range m.ifaces {
+		errs[i] = m.check(ctx, st.Name)
+		if ctx.Err() != nil {
+			return
+		}
+	}
+	m.mu.Lock()
+	for i, st := range m.ifaces {
+		if working := errs[i] == nil; working != st.working {
+			st.working, st.since = working, now
+		}
+		st.err = errs[i]
+	}
+	m.mu.Unlock()
+
+	// A link is wanted while no interface a route prefers to it carries
+	// the route's traffic
+	wanted := make([]bool, len(m.ifaces))
+	for _, r := range m.routes {
+		cur, next := r.choose(now)
+		if next != cur || (r == m.uplink && !m.checked) {
+			m.switchTo(r, next)
+		}
+		for _, i := range r.order {
+			wanted[i] = true
+			if i == next {
+				break
+			}
+		}
+	}
+	m.checked = true
+	for i, st := range m.ifaces {
+		if st.link != nil {
+			st.link.set(st.link.cfg.KeepUp || wanted[i])
+			st.link.retry(now, st.working)
+		}
+	}
+}
+
+// choose returns the interface the route uses and the one it should use
+func (r *Route) choose(now time.Time) (cur, next int) {
+	ifaces := r.m.ifaces
+	cur, next = r.current, r.current
+	if cur < 0 || !ifaces[cur].working {
+		// Fail over at once, to the most preferred that works
+		next = -1
+		for _, i := range r.order {
+			if ifaces[i].working {
+				return cur, i
+			}
+		}
+		return cur, next
+	}
+	// Fail back once a preferred interface has worked for a while
+	for _, i := range r.order {
+		if i == cur {
+			break
+		}
+		if ifaces[i].working && now.Sub(ifaces[i].since) >= r.m.cfg.Failback.D() {
+			return cur, i
+		}
+	}
+	return cur, next
+}
+
+// switchTo moves a route's traffic to ifaces[to], -1 for none
+func (m *Manager) switchTo(r *Route, to int) {
+	from := r.current
+	c := Change{Route: r.name}
+	if from >= 0 {
+		c.From = m.ifaces[from].Name
+		if !m.ifaces[from].working {
+			c.Err = m.ifaces[from].err
+		}
+	}
+	if to >= 0 {
+		c.To = m.ifaces[to].Name
+	}
+	m.mu.Lock()
+	r.current = to
+	m.mu.Unlock()
+	what := "Uplink"
+	if r != m.uplink {
+		what = "Uplink for " + r.name
+	}
+	switch {
+	case to < 0 && from < 0:
+		log.Printf("❌ %s: no interface works yet", what)
+	case to < 0 && r != m.uplink:
+		log.Printf("⚠️  %s: none of its interfaces works (%s: %v), using the uplink's", what, c.From, c.Err)
+	case to < 0:
+		log.Printf("❌ %s: no interface works (%s: %v)", what, c.From, c.Err)
+	case from < 0:
+		log.Printf("✅ %s: using %s", what, c.To)
+	case c.Err != nil:
+		log.Printf("⚠️  %s: %s failed (%v), failing over to %s", what, c.From, c.Err, c.To)
+	default:
+		log.Printf("✅ %s: %s works again, failing back from %s", what, c.To, c.From)
+	}
+	m.onChange(c)
+}
+
+// Status describes an interface for monitoring
+type Status struct {
+	Name    string
+	Working bool
+	InUse   bool
+	Err     error // why it doesn't work
+}
+
+// Status returns the interfaces' state as of the last check
+func (m *Manager) Status() []Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var s []Status
+	for _, st := range m.ifaces {
+		s = append(s, Status{Name: st.Name, Working: st.working, InUse: m.uplink.current >= 0 && st == m.ifaces[m.uplink.current], Err: st.err})
+	}
+	return s
+}
+
+// checkInterface reports whether an interface is up with an address and,
+// with a probe, reaches it
+func (m *Manager) checkInterface(ctx context.Context, name string) error {
+	if err := netwait.Check(netwait.Config{Interface: name}); err != nil {
+		return err
+	}
+	if m.cfg.Probe == "" {
+		return nil
+	}
+	d := net.Dialer{
+		Timeout: probeTimeout,
+		Control: func(network, address string, c syscall.RawConn) error { return bindToDevice(c, name) },
+	}
+	conn, err := d.DialContext(ctx, "tcp", m.cfg.Probe)
+	if err != nil {
+		return err
+	}
+	conn.Close()
+	return nil
+}
+
+// link runs the commands bringing an interface up and down
+type link struct {
+	name     string
+	cfg      Link
+	up       [][]string
+	down     [][]string
+	wanted   bool
+	upAt     time.Time     // when it was last brought up
+	proc     *exec.Cmd     // the running Command, if any
+	exited   chan struct{} // closed when proc exits
+	failing  bool          // a command failed, which was logged
+	retrying bool          // it was brought up again, which was logged
+}
+
+func newLink(name string, cfg Link) (*link, error) {
+	l := &link{name: name, cfg: cfg}
+	n := 0
+	if cfg.PPPPeer != "" {
+		n++
+		l.cfg.Command = []string{"pppd", "call", cfg.PPPPeer, "nodetach"}
+	}
+	if cfg.QMIDevice != "" {
+		n++
+		l.up = [][]string{{"qmi-network", cfg.QMIDevice, "start"}, {"udhcpc", "-i", name, "-n", "-q"}}
+		l.down = [][]string{{"qmi-network", cfg.QMIDevice, "stop"}}
+	}
+	if len(cfg.Command) > 0 {
+		n++
+	}
+	if len(cfg.Up) > 0 {
+		n++
+		l.up = [][]string{cfg.Up}
+		if len(cfg.Down) > 0 {
+			l.down = [][]string{cfg.Down}
+		}
+	}
+	if n != 1 {
+		return nil, errors.New("link needs one of ppp_peer, qmi_device, command or up")
+	}
+	return l, nil
+}
+
+// set brings the link up or down as wanted
+func (l *link) set(wanted bool) {
+	if wanted == l.wanted {
+		return
+	}
+	l.wanted = wanted
+	if wanted {
+		log.Printf("📶 Uplink: bringing %s up", l.name)
+		l.start()
+	} else {
+		log.Printf("Uplink: bringing %s down", l.name)
+		l.stop()
+	}
+}
+
+// retry brings a wanted link up afresh if its command exited, or if it
+// hasn't worked for linkRetry since it was brought up
+func (l *link) retry(now time.Time, working bool) {
+	if !l.wanted || working {
+		l.retrying = false
+		return
+	}
+	exited := false
+	if l.exited != nil {
+		select {
+		case <-l.exited:
+			exited = true
+		default:
+		}
+	}
+	if exited || now.Sub(l.upAt) >= linkRetry {
+		if !l.retrying {
+			log.Printf("⚠️  Uplink: %s still down, bringing it up again", l.name)
+			l.retrying = true
+		}
+		l.stop()
+		l.start()
+	}
+}
+
+func (l *link) start() {
+	l.upAt = time.Now()
+	if len(l.cfg.Command) > 0 {
+		cmd := exec.Command(l.cfg.Command[0], l.cfg.Command[1:]...)
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		if err := cmd.Start(); err != nil {
+			l.logOnce(err)
+			return
+		}
+		l.proc, l.exited, l.failing = cmd, make(chan struct{}), false
+		exited := l.exited
+		go func() {
+			cmd.Wait()
+			close(exited)
+		}()
+		return
+	}
+	l.run(l.up)
+}
+
+func (l *link) stop() {
+	if l.proc != nil {
+		syscall.Kill(-l.proc.Process.Pid, syscall.SIGTERM)
+		select {
+		case <-l.exited:
+		case <-time.After(10 * time.Second):
+			syscall.Kill(-l.proc.Process.Pid, syscall.SIGKILL)
+			<-l.exited
+		}
+		l.proc, l.exited = nil, nil
+		return
+	}
+	l.run(l.down)
+}
+
+// run runs commands in turn, stopping at the first that fails
+func (l *link) run(cmds [][]string) {
+	for _, c := range cmds {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		out, err := exec.CommandContext(ctx, c[0], c[1:]...).CombinedOutput()
+		cancel()
+		if err != nil {
+			if msg := lastLine(out); msg != "" {
+				err = fmt.Errorf("%s: %w: %s", c[0], err, msg)
+			} else {
+				err = fmt.Errorf("%s: %w", c[0], err)
+			}
+			l.logOnce(err)
+			return
+		}
+	}
+	l.failing = false
+}
+
+// logOnce logs a failure to bring the link up or down, until a command
+// succeeds again
+func (l *link) logOnce(err error) {
+	if !l.failing {
+		log.Printf("❌ Uplink: %s: %v", l.name, err)
+		l.failing = true
+	}
+}
+
+func lastLine(out []byte) string {
+	msg := strings.TrimSpace(string(out))
+	return msg[strings.LastIndex(msg, "\n")+1:]
+}
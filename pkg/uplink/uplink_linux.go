@@ -0,0 +1,20 @@
+package uplink
+
+import (
+	"fmt"
+	"syscall"
+)
+
+func bindToDevice(c syscall.RawConn, name string) error {
+	var serr error
+	err := c.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, name)
+	})
+	if err == nil {
+		err = serr
+	}
+	if err != nil {
+		return fmt.Errorf("bind to %s: %w", name, err)
+	}
+	return nil
+}
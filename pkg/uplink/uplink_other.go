@@ -0,0 +1,13 @@
+//go:build !linux
+
+package uplink
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// bindToDevice fails off Linux, which alone has SO_BINDTODEVICE
+func bindToDevice(c syscall.RawConn, name string) error {
+	return fmt.Errorf("bind to %s: %w", name, syscall.ENOPROTOOPT)
+}
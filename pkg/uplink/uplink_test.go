@@ -0,0 +1,151 @@
+package uplink
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"riscv-dev/pkg/config"
+)
+
+func TestFailover(t *testing.T) {
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "cellular")
+	cfg := Config{
+		Interfaces: []Interface{
+			{Name: "eth0"},
+			{Name: "wlan0"},
+			{Name: "wwan0", Link: &Link{Up: []string{"touch", marker}, Down: []string{"rm", marker}}},
+		},
+	}
+	var changes []string
+	m, err := New(cfg, func(c Change) { changes = append(changes, c.To) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	working := map[string]bool{"eth0": true, "wlan0": true}
+	m.check = func(ctx context.Context, name string) error {
+		if working[name] {
+			return nil
+		}
+		return errors.New("down")
+	}
+	cellularUp := func() bool {
+		_, err := os.Stat(marker)
+		return err == nil
+	}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	step := func(d time.Duration) {
+		now = now.Add(d)
+		m.step(context.Background(), now)
+	}
+
+	step(0)
+	if m.Current() != "eth0" || cellularUp() {
+		t.Fatalf("using %q, cellular up %v; want eth0 alone", m.Current(), cellularUp())
+	}
+
+	// Ethernet fails: Wi-Fi takes over at once
+	working["eth0"] = false
+	step(10 * time.Second)
+	if m.Current() != "wlan0" || cellularUp() {
+		t.Fatalf("using %q, cellular up %v; want wlan0 alone", m.Current(), cellularUp())
+	}
+
+	// Wi-Fi fails too: cellular is brought up, and used once it works
+	working["wlan0"] = false
+	step(10 * time.Second)
+	if m.Current() != "" || !cellularUp() {
+		t.Fatalf("using %q, cellular up %v; want cellular coming up", m.Current(), cellularUp())
+	}
+	working["wwan0"] = true
+	step(10 * time.Second)
+	if m.Current() != "wwan0" {
+		t.Fatalf("using %q, want wwan0", m.Current())
+	}
+
+	// Ethernet is back, but is only used once it has worked for a while
+	working["eth0"] = true
+	step(10 * time.Second)
+	if m.Current() != "wwan0" {
+		t.Fatalf("failed back to %q at once", m.Current())
+	}
+	step(time.Minute)
+	if m.Current() != "eth0" || cellularUp() {
+		t.Fatalf("using %q, cellular up %v; want eth0 alone", m.Current(), cellularUp())
+	}
+
+	want := []string{"eth0", "wlan0", "", "wwan0", "eth0"}
+	if len(changes) != len(want) {
+		t.Fatalf("changes %q, want %q", changes, want)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Fatalf("changes %q, want %q", changes, want)
+		}
+	}
+}
+
+func TestRoute(t *testing.T) {
+	cfg := Config{Interfaces: []Interface{{Name: "eth0"}, {Name: "wlan0"}}, Failback: config.Duration(time.Minute)}
+	var changes []Change
+	m, err := New(cfg, func(c Change) { changes = append(changes, c) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := m.Route("http", []string{"wlan0", "eth0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Route("s3", []string{"wwan0"}); err == nil {
+		t.Error("route over an unknown interface succeeded")
+	}
+	working := map[string]bool{"eth0": true, "wlan0": true}
+	m.check = func(ctx context.Context, name string) error {
+		if working[name] {
+			return nil
+		}
+		return errors.New("down")
+	}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	m.step(context.Background(), now)
+	if m.Current() != "eth0" || r.Interface() != "wlan0" {
+		t.Fatalf("uplink %q, route %q; want eth0 and wlan0", m.Current(), r.Interface())
+	}
+
+	// The route fails over on its own, and falls back to the uplink's
+	// interface when none of its own works
+	working["wlan0"] = false
+	m.step(context.Background(), now.Add(10*time.Second))
+	if m.Current() != "eth0" || r.Interface() != "eth0" {
+		t.Fatalf("uplink %q, route %q; want eth0 for both", m.Current(), r.Interface())
+	}
+	c := changes[len(changes)-1]
+	if c.Route != "http" || c.From != "wlan0" || c.To != "eth0" || c.Err == nil {
+		t.Errorf("change %+v, want http failing over from wlan0 to eth0", c)
+	}
+	working["eth0"] = false
+	m.step(context.Background(), now.Add(20*time.Second))
+	if r.Interface() != "" || m.Current() != "" {
+		t.Fatalf("uplink %q, route %q; want neither", m.Current(), r.Interface())
+	}
+	if len(changes) != 5 {
+		t.Errorf("changes %+v, want 5", changes)
+	}
+}
+
+func TestNewRejects(t *testing.T) {
+	for _, cfg := range []Config{
+		{},
+		{Interfaces: []Interface{{Name: "eth0"}, {Name: "eth0"}}},
+		{Interfaces: []Interface{{Name: "ppp0", Link: &Link{}}}},
+		{Interfaces: []Interface{{Name: "ppp0", Link: &Link{PPPPeer: "provider", Up: []string{"true"}}}}},
+	} {
+		if _, err := New(cfg, nil); err == nil {
+			t.Errorf("New(%+v) succeeded", cfg)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package usbgadget
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Provisioning runs over a gadget serial port as one JSON request per
+// line, each answered by one JSON response line:
+//
+//	{"op": "info"}                                     board details
+//	{"op": "list"}                                     the files on offer
+//	{"op": "get", "file": "config"}                    a file's contents
+//	{"op": "put", "file": "config", "data": "<base64>"}  replace a file
+//
+// Requests carry "token" when the provisioner has one. Whoever holds the
+// cable can otherwise change what the files allow, so offer only files
+// meant for provisioning.
+
+// Request is a provisioning request
+type Request struct {
+	Op    string `json:"op"`
+	File  string `json:"file,omitempty"`
+	Data  []byte `json:"data,omitempty"`
+	Token string `json:"token,omitempty"`
+}
+
+// Response answers a Request
+type Response struct {
+	OK    bool              `json:"ok"`
+	Error string            `json:"error,omitempty"`
+	Info  map[string]string `json:"info,omitempty"`
+	Files []string          `json:"files,omitempty"`
+	Data  []byte            `json:"data,omitempty"`
+}
+
+// ProvisionFile is a file a Provisioner offers
+type ProvisionFile struct {
+	Path string
+	// Validate, if set, checks new contents before they are written
+	Validate func([]byte) error
+	// Changed, if set, is called after new contents are written
+	Changed func()
+}
+
+// Provisioner answers provisioning requests
+type Provisioner struct {
+	Files map[string]ProvisionFile
+	Info  func() map[string]string // nil answers info with no details
+	Token string                   // required in requests if set
+}
+
+// maxRequest bounds a request line: a file of 1 MiB in base64
+const maxRequest = 1<<20*4/3 + 4096
+
+// Serve answers requests from rw until ctx is done or rw fails
+func (p *Provisioner) Serve(ctx context.Context, rw io.ReadWriter) error {
+	sc := bufio.NewScanner(rw)
+	sc.Buffer(make([]byte, 4096), maxRequest)
+	for sc.Scan() {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		line := sc.Bytes()
+		if len(line) == 0 || line[0] != '{' {
+			continue // line noise, or a terminal's carriage returns
+		}
+		var req Request
+		resp := Response{OK: true}
+		if err := json.Unmarshal(line, &req); err != nil {
+			resp = Response{Error: "invalid request: " + err.Error()}
+		} else if err := p.handle(req, &resp); err != nil {
+			resp = Response{Error: err.Error()}
+		}
+		b, _ := json.Marshal(resp)
+		if _, err := rw.Write(append(b, '\n')); err != nil {
+			return err
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+func (p *Provisioner) handle(req Request, resp *Response) error {
+	if p.Token != "" && subtle.ConstantTimeCompare([]byte(req.Token), []byte(p.Token)) != 1 {
+		return errors.New("bad token")
+	}
+	var file ProvisionFile
+	if req.Op == "get" || req.Op == "put" {
+		var ok bool
+		if file, ok = p.Files[req.File]; !ok {
+			return fmt.Errorf("no file %q", req.File)
+		}
+	}
+	switch req.Op {
+	case "info":
+		if p.Info != nil {
+			resp.Info = p.Info()
+		}
+	case "list":
+		for name := range p.Files {
+			resp.Files = append(resp.Files, name)
+		}
+		sort.Strings(resp.Files)
+	case "get":
+		data, err := os.ReadFile(file.Path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		resp.Data = data
+	case "put":
+		if file.Validate != nil {
+			if err := file.Validate(req.Data); err != nil {
+				return fmt.Errorf("%s rejected: %w", req.File, err)
+			}
+		}
+		if err := writeAtomic(file.Path, req.Data); err != nil {
+			return err
+		}
+		if file.Changed != nil {
+			file.Changed()
+		}
+	default:
+		return fmt.Errorf("unknown op %q", req.Op)
+	}
+	return nil
+}
+
+// writeAtomic replaces path so that a power cut leaves the old or the new
+// contents, never a mix
+func writeAtomic(path string, data []byte) error {
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Client sends provisioning requests over a serial port
+type Client struct {
+	rw    io.ReadWriter
+	r     *bufio.Reader
+	Token string
+}
+
+// NewClient returns a client talking over rw
+func NewClient(rw io.ReadWriter) *Client {
+	return &Client{rw: rw, r: bufio.NewReaderSize(rw, maxRequest)}
+}
+
+// Do sends a request and waits for its response, skipping any other
+// output on the line. A response with OK unset comes back as an error.
+func (c *Client) Do(req Request) (Response, error) {
+	req.Token = c.Token
+	b, err := json.Marshal(req)
+	if err != nil {
+		return Response{}, err
+	}
+	if _, err := c.rw.Write(append(b, '\n')); err != nil {
+		return Response{}, err
+	}
+	for {
+		line, err := c.r.ReadBytes('\n')
+		if err != nil {
+			return Response{}, err
+		}
+		var resp Response
+		if len(line) == 0 || line[0] != '{' || json.Unmarshal(line, &resp) != nil {
+			continue
+		}
+		if !resp.OK {
+			return resp, errors.New(resp.Error)
+		}
+		return resp, nil
+	}
+}
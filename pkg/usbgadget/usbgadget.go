@@ -0,0 +1,329 @@
+// Package usbgadget sets up and finds the USB functions of boards that can
+// appear to a host as a USB device, like the Milk-V Duo on its USB-C port:
+// a serial port (CDC-ACM, what g_serial provides) and an Ethernet link
+// (CDC-ECM or RNDIS, what g_ether provides). Setup composes them through
+// configfs and libcomposite; Detect also finds the legacy gadget modules.
+//
+// Paths are taken relative to a root, "/" for the running system, so the
+// functions can be tried against a copy of sysfs.
+package usbgadget
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	configfsDir = "sys/kernel/config/usb_gadget"
+	udcDir      = "sys/class/udc"
+	netDir      = "sys/class/net"
+	moduleDir   = "sys/module"
+)
+
+// Default identity: the Linux Foundation's multifunction composite gadget
+const (
+	DefaultVendorID  = 0x1d6b
+	DefaultProductID = 0x0104
+	DefaultName      = "riscv-dev"
+)
+
+// legacyModules are the gadget drivers that set themselves up when loaded
+var legacyModules = []string{"g_serial", "g_ether", "g_cdc", "g_multi"}
+
+// Config describes the gadget Setup composes
+type Config struct {
+	// Name is the gadget's directory under configfs, default "riscv-dev"
+	Name string `json:"name,omitempty"`
+	// Serial is the number of CDC-ACM serial ports, /dev/ttyGS0 and on
+	Serial int `json:"serial,omitempty"`
+	// Ethernet is "ecm" (Linux and macOS hosts), "rndis" (Windows) or
+	// empty for none
+	Ethernet string `json:"ethernet,omitempty"`
+	// HostAddr and DevAddr are the MAC addresses of the two ends of the
+	// link; the kernel picks random ones, which change every boot, if empty
+	HostAddr string `json:"host_addr,omitempty"`
+	DevAddr  string `json:"dev_addr,omitempty"`
+	// VendorID and ProductID default to DefaultVendorID and
+	// DefaultProductID
+	VendorID  uint16 `json:"vendor_id,omitempty"`
+	ProductID uint16 `json:"product_id,omitempty"`
+	// Manufacturer, Product and SerialNumber are shown to the host
+	Manufacturer string `json:"manufacturer,omitempty"`
+	Product      string `json:"product,omitempty"`
+	SerialNumber string `json:"serial_number,omitempty"`
+	// UDC is the USB device controller to bind to, default the first
+	UDC string `json:"udc,omitempty"`
+}
+
+// UDC is a USB device controller
+type UDC struct {
+	Name  string `json:"name"`
+	State string `json:"state"`           // e.g. "configured", "not attached"
+	Bound string `json:"bound,omitempty"` // configfs gadget using it, if any
+}
+
+// Status is what gadget support the system has and uses
+type Status struct {
+	UDCs    []UDC    `json:"udcs"`
+	Gadgets []string `json:"gadgets,omitempty"` // configfs gadgets
+	Legacy  []string `json:"legacy,omitempty"`  // loaded legacy gadget modules
+	Serial  []string `json:"serial,omitempty"`  // gadget serial ports, /dev/ttyGS*
+	Net     []string `json:"net,omitempty"`     // gadget network interfaces
+}
+
+// Active reports whether a gadget is set up on some controller
+func (s Status) Active() bool {
+	return len(s.Legacy) > 0 || len(s.Serial) > 0 || len(s.Net) > 0
+}
+
+// Attached reports whether a host has configured the gadget
+func (s Status) Attached() bool {
+	for _, u := range s.UDCs {
+		if u.State == "configured" {
+			return true
+		}
+	}
+	return false
+}
+
+// Detect reports the device controllers, gadgets and their serial ports
+// and network interfaces
+func Detect(root string) (Status, error) {
+	var st Status
+	gadgets, _ := filepath.Glob(filepath.Join(root, configfsDir, "*"))
+	bound := make(map[string]string)
+	for _, g := range gadgets {
+		name := filepath.Base(g)
+		st.Gadgets = append(st.Gadgets, name)
+		if udc := readAttr(filepath.Join(g, "UDC")); udc != "" {
+			bound[udc] = name
+		}
+	}
+	udcs, err := filepath.Glob(filepath.Join(root, udcDir, "*"))
+	if err != nil {
+		return st, err
+	}
+	for _, u := range udcs {
+		name := filepath.Base(u)
+		st.UDCs = append(st.UDCs, UDC{Name: name, State: readAttr(filepath.Join(u, "state")), Bound: bound[name]})
+	}
+	for _, m := range legacyModules {
+		if _, err := os.Stat(filepath.Join(root, moduleDir, m)); err == nil {
+			st.Legacy = append(st.Legacy, m)
+		}
+	}
+	st.Serial, _ = filepath.Glob(filepath.Join(root, "dev", "ttyGS*"))
+	for i, p := range st.Serial {
+		st.Serial[i] = "/" + strings.TrimPrefix(strings.TrimPrefix(p, root), "/")
+	}
+	sort.Strings(st.Serial)
+	ifaces, _ := filepath.Glob(filepath.Join(root, netDir, "*"))
+	for _, iface := range ifaces {
+		if isGadgetNet(iface) {
+			st.Net = append(st.Net, filepath.Base(iface))
+		}
+	}
+	return st, nil
+}
+
+// HostPorts returns, on the host, the CDC-ACM serial ports of attached
+// gadgets (/dev/ttyACM*), those with the default identity first
+func HostPorts(root string) []string {
+	ttys, _ := filepath.Glob(filepath.Join(root, "sys/class/tty/ttyACM*"))
+	want := fmt.Sprintf("%04x:%04x", DefaultVendorID, DefaultProductID)
+	var ours, others []string
+	for _, tty := range ttys {
+		port := "/dev/" + filepath.Base(tty)
+		// device is the USB interface; its parent is the USB device
+		dev, err := filepath.EvalSymlinks(filepath.Join(tty, "device"))
+		if err == nil && readAttr(filepath.Join(dev, "..", "idVendor"))+":"+readAttr(filepath.Join(dev, "..", "idProduct")) == want {
+			ours = append(ours, port)
+		} else {
+			others = append(others, port)
+		}
+	}
+	sort.Strings(ours)
+	sort.Strings(others)
+	return append(ours, others...)
+}
+
+// isGadgetNet reports whether a network interface is a gadget's end of a
+// USB link rather than, say, a USB Ethernet adapter plugged into the board
+func isGadgetNet(iface string) bool {
+	dev, err := filepath.EvalSymlinks(filepath.Join(iface, "device"))
+	if err != nil {
+		return false
+	}
+	for _, part := range strings.Split(dev, string(filepath.Separator)) {
+		if part == "gadget" || strings.HasPrefix(part, "gadget.") {
+			return true
+		}
+	}
+	return false
+}
+
+// Setup composes the gadget under configfs and binds it to the
+// controller, replacing a gadget of the same name. It needs root and the
+// libcomposite module with its functions (usb_f_acm, usb_f_ecm,
+// usb_f_rndis); a legacy gadget module must be unloaded first, as it holds
+// the controller.
+func Setup(root string, cfg Config) error {
+	if cfg.Serial == 0 && cfg.Ethernet == "" {
+		return errors.New("usbgadget: no functions configured")
+	}
+	if cfg.Ethernet != "" && cfg.Ethernet != "ecm" && cfg.Ethernet != "rndis" {
+		return fmt.Errorf("usbgadget: ethernet %q (want ecm or rndis)", cfg.Ethernet)
+	}
+	if cfg.Name == "" {
+		cfg.Name = DefaultName
+	}
+	if cfg.VendorID == 0 {
+		cfg.VendorID, cfg.ProductID = DefaultVendorID, DefaultProductID
+	}
+	base := filepath.Join(root, configfsDir)
+	if _, err := os.Stat(base); err != nil {
+		return fmt.Errorf("usbgadget: %s missing; load libcomposite and mount configfs (%w)", "/"+configfsDir, err)
+	}
+	st, err := Detect(root)
+	if err != nil {
+		return err
+	}
+	if len(st.Legacy) > 0 {
+		return fmt.Errorf("usbgadget: legacy gadget %s holds the controller; unload it first", strings.Join(st.Legacy, ", "))
+	}
+	udc := cfg.UDC
+	if udc == "" {
+		if len(st.UDCs) == 0 {
+			return errors.New("usbgadget: no USB device controller; is the port in peripheral (OTG) mode?")
+		}
+		udc = st.UDCs[0].Name
+	}
+	for _, u := range st.UDCs {
+		if u.Name == udc && u.Bound != "" && u.Bound != cfg.Name {
+			return fmt.Errorf("usbgadget: %s is bound to gadget %s", udc, u.Bound)
+		}
+	}
+
+	g := filepath.Join(base, cfg.Name)
+	if err := Teardown(root, cfg.Name); err != nil {
+		return err
+	}
+	w := &writer{}
+	w.mkdir(g)
+	w.write(filepath.Join(g, "idVendor"), fmt.Sprintf("0x%04x", cfg.VendorID))
+	w.write(filepath.Join(g, "idProduct"), fmt.Sprintf("0x%04x", cfg.ProductID))
+	w.write(filepath.Join(g, "bcdUSB"), "0x0200")
+	str := filepath.Join(g, "strings", "0x409")
+	w.mkdir(str)
+	w.write(filepath.Join(str, "manufacturer"), or(cfg.Manufacturer, "riscv-dev"))
+	w.write(filepath.Join(str, "product"), or(cfg.Product, "RISC-V board"))
+	if cfg.SerialNumber != "" {
+		w.write(filepath.Join(str, "serialnumber"), cfg.SerialNumber)
+	}
+	conf := filepath.Join(g, "configs", "c.1")
+	w.mkdir(filepath.Join(conf, "strings", "0x409"))
+	w.write(filepath.Join(conf, "strings", "0x409", "configuration"), "riscv-dev")
+	w.write(filepath.Join(conf, "MaxPower"), "250")
+
+	var functions []string
+	if cfg.Ethernet != "" {
+		// Windows binds RNDIS only as the first function
+		functions = append(functions, cfg.Ethernet+".usb0")
+	}
+	for i := 0; i < cfg.Serial; i++ {
+		functions = append(functions, fmt.Sprintf("acm.usb%d", i))
+	}
+	for _, fn := range functions {
+		dir := filepath.Join(g, "functions", fn)
+		w.mkdir(dir)
+		if strings.HasPrefix(fn, cfg.Ethernet+".") {
+			if cfg.HostAddr != "" {
+				w.write(filepath.Join(dir, "host_addr"), cfg.HostAddr)
+			}
+			if cfg.DevAddr != "" {
+				w.write(filepath.Join(dir, "dev_addr"), cfg.DevAddr)
+			}
+		}
+		if w.err == nil {
+			w.err = os.Symlink(dir, filepath.Join(conf, fn))
+		}
+	}
+	w.write(filepath.Join(g, "UDC"), udc)
+	if w.err != nil {
+		return fmt.Errorf("usbgadget: %w", w.err)
+	}
+	return nil
+}
+
+// Teardown unbinds and removes a gadget Setup made; a missing one is not
+// an error. configfs wants the parts removed in the reverse order of
+// their creation.
+func Teardown(root, name string) error {
+	if name == "" {
+		name = DefaultName
+	}
+	g := filepath.Join(root, configfsDir, name)
+	if _, err := os.Stat(g); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if readAttr(filepath.Join(g, "UDC")) != "" {
+		if err := os.WriteFile(filepath.Join(g, "UDC"), []byte("\n"), 0644); err != nil {
+			return fmt.Errorf("usbgadget: unbind: %w", err)
+		}
+	}
+	var errs []error
+	rm := func(pattern string) {
+		matches, _ := filepath.Glob(filepath.Join(g, pattern))
+		for _, m := range matches {
+			if err := os.Remove(m); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	rm("configs/*/*.usb*") // function links
+	rm("configs/*/strings/*")
+	rm("configs/*")
+	rm("functions/*")
+	rm("strings/*")
+	if err := os.Remove(g); err != nil {
+		errs = append(errs, err)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("usbgadget: teardown %s: %w", name, err)
+	}
+	return nil
+}
+
+// writer runs configfs steps until the first error
+type writer struct{ err error }
+
+func (w *writer) mkdir(path string) {
+	if w.err == nil {
+		w.err = os.MkdirAll(path, 0755)
+	}
+}
+
+func (w *writer) write(path, value string) {
+	if w.err == nil {
+		w.err = os.WriteFile(path, []byte(value+"\n"), 0644)
+	}
+}
+
+func readAttr(path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func or(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
@@ -0,0 +1,156 @@
+package usbgadget
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeSys lays out the sysfs and configfs parts of a board with one
+// device controller
+func fakeSys(t *testing.T) string {
+	root := t.TempDir()
+	for _, dir := range []string{
+		configfsDir,
+		udcDir + "/4340000.usb",
+		"sys/devices/platform/4340000.usb/gadget.0/net/usb0",
+		"sys/devices/platform/eth0",
+		netDir,
+		"dev",
+	} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.WriteFile(filepath.Join(root, udcDir, "4340000.usb", "state"), []byte("not attached\n"), 0644)
+	return root
+}
+
+func TestDetectAndSetup(t *testing.T) {
+	root := fakeSys(t)
+	st, err := Detect(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st.UDCs) != 1 || st.UDCs[0].Name != "4340000.usb" || st.Active() || st.Attached() {
+		t.Fatalf("before setup: %+v", st)
+	}
+
+	if err := Setup(root, Config{Serial: 1, Ethernet: "rndis"}); err != nil {
+		t.Fatal(err)
+	}
+	g := filepath.Join(root, configfsDir, DefaultName)
+	if v := readAttr(filepath.Join(g, "idVendor")); v != "0x1d6b" {
+		t.Errorf("idVendor %q", v)
+	}
+	if v := readAttr(filepath.Join(g, "UDC")); v != "4340000.usb" {
+		t.Errorf("UDC %q", v)
+	}
+	for _, fn := range []string{"rndis.usb0", "acm.usb0"} {
+		if _, err := os.Lstat(filepath.Join(g, "configs", "c.1", fn)); err != nil {
+			t.Errorf("%s not linked: %v", fn, err)
+		}
+	}
+	if err := Setup(root, Config{Ethernet: "ncm"}); err == nil {
+		t.Error("unknown ethernet function accepted")
+	}
+
+	// What the kernel then shows
+	os.WriteFile(filepath.Join(root, "dev", "ttyGS0"), nil, 0644)
+	os.WriteFile(filepath.Join(root, udcDir, "4340000.usb", "state"), []byte("configured\n"), 0644)
+	os.MkdirAll(filepath.Join(root, netDir, "usb0"), 0755)
+	os.MkdirAll(filepath.Join(root, netDir, "eth0"), 0755)
+	os.Symlink(filepath.Join(root, "sys/devices/platform/4340000.usb/gadget.0"), filepath.Join(root, netDir, "usb0", "device"))
+	os.Symlink(filepath.Join(root, "sys/devices/platform/eth0"), filepath.Join(root, netDir, "eth0", "device"))
+	st, err = Detect(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.Active() || !st.Attached() || st.UDCs[0].Bound != DefaultName {
+		t.Errorf("after setup: %+v", st)
+	}
+	if len(st.Serial) != 1 || st.Serial[0] != "/dev/ttyGS0" {
+		t.Errorf("serial ports %v", st.Serial)
+	}
+	if len(st.Net) != 1 || st.Net[0] != "usb0" {
+		t.Errorf("gadget interfaces %v", st.Net)
+	}
+
+	// A legacy module holds the controller
+	os.MkdirAll(filepath.Join(root, moduleDir, "g_serial"), 0755)
+	if err := Setup(root, Config{Serial: 1, Name: "other"}); err == nil || !strings.Contains(err.Error(), "g_serial") {
+		t.Errorf("setup with g_serial loaded: %v", err)
+	}
+}
+
+// pipe joins two ends of a fake serial line
+type pipe struct {
+	io.Reader
+	io.Writer
+}
+
+func TestProvision(t *testing.T) {
+	dir := t.TempDir()
+	cfg := filepath.Join(dir, "config.json")
+	os.WriteFile(cfg, []byte(`{"old":true}`), 0600)
+	changed := 0
+	p := &Provisioner{
+		Files: map[string]ProvisionFile{"config": {
+			Path: cfg,
+			Validate: func(b []byte) error {
+				if !bytes.HasPrefix(b, []byte("{")) {
+					return errors.New("not JSON")
+				}
+				return nil
+			},
+			Changed: func() { changed++ },
+		}},
+		Info:  func() map[string]string { return map[string]string{"board": "duo"} },
+		Token: "secret",
+	}
+	hostR, boardW := io.Pipe()
+	boardR, hostW := io.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- p.Serve(context.Background(), pipe{boardR, boardW}) }()
+	c := NewClient(pipe{hostR, hostW})
+
+	if _, err := c.Do(Request{Op: "list"}); err == nil || err.Error() != "bad token" {
+		t.Errorf("no token: %v", err)
+	}
+	c.Token = "secret"
+	// A terminal's echo and noise ahead of the request are skipped
+	hostW.Write([]byte("\r\n~~\r\n"))
+	if resp, err := c.Do(Request{Op: "info"}); err != nil || resp.Info["board"] != "duo" {
+		t.Errorf("info: %+v %v", resp, err)
+	}
+	if resp, err := c.Do(Request{Op: "list"}); err != nil || len(resp.Files) != 1 || resp.Files[0] != "config" {
+		t.Errorf("list: %+v %v", resp, err)
+	}
+	if resp, err := c.Do(Request{Op: "get", File: "config"}); err != nil || string(resp.Data) != `{"old":true}` {
+		t.Errorf("get: %+v %v", resp, err)
+	}
+	if _, err := c.Do(Request{Op: "put", File: "config", Data: []byte("junk")}); err == nil {
+		t.Error("invalid contents accepted")
+	}
+	if _, err := c.Do(Request{Op: "put", File: "config", Data: []byte(`{"new":true}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do(Request{Op: "get", File: "/etc/shadow"}); err == nil {
+		t.Error("file not on offer served")
+	}
+	if b, _ := os.ReadFile(cfg); string(b) != `{"new":true}` || changed != 1 {
+		t.Errorf("after put: %q, changed %d", b, changed)
+	}
+	if fi, _ := os.Stat(cfg); fi.Mode().Perm() != 0600 {
+		t.Errorf("mode %v after put", fi.Mode())
+	}
+	hostW.Close()
+	if err := <-done; err != io.EOF {
+		t.Errorf("serve: %v", err)
+	}
+}
@@ -0,0 +1,471 @@
+// Package yaml reads the subset of YAML 1.2 configuration files use, for
+// files people edit by hand more happily than JSON. Documents become the
+// values encoding/json produces (map[string]any, []any, string, float64,
+// bool and nil), so they decode into the same structs as JSON, leaving
+// their json tags and UnmarshalJSON methods (durations, ranges, sink
+// configs) to do the rest.
+//
+// The subset is block mappings and sequences, plain, single- and
+// double-quoted scalars, flow sequences and mappings on one line, and
+// comments. Anchors, tags, block scalars (| and >) and multiple documents
+// are rejected rather than misread.
+package yaml
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Unmarshal decodes a YAML document, or a JSON one starting with '{',
+// into v as encoding/json would
+func Unmarshal(data []byte, v any) error {
+	// JSON is YAML, but more than the subset Parse reads
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		var err error
+		if data, err = ToJSON(data); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(data, v)
+}
+
+// ToJSON converts a YAML document to JSON
+func ToJSON(data []byte) ([]byte, error) {
+	v, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// line is a line with content, its comment removed
+type line struct {
+	n      int // line number, from 1
+	indent int
+	text   string
+}
+
+type parser struct {
+	lines []line
+	i     int
+}
+
+// Parse parses a document; an empty one is an empty mapping
+func Parse(data []byte) (any, error) {
+	p := &parser{}
+	for n, raw := range strings.Split(string(data), "\n") {
+		raw = strings.TrimRight(raw, " \t\r")
+		text := strings.TrimLeft(raw, " ")
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("line %d: tabs can't indent YAML", n+1)
+		}
+		text, err := stripComment(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n+1, err)
+		}
+		switch {
+		case text == "":
+			continue
+		case text == "---" && len(p.lines) == 0:
+			continue
+		case text == "---" || text == "...":
+			return nil, fmt.Errorf("line %d: only one document is supported", n+1)
+		}
+		p.lines = append(p.lines, line{n: n + 1, indent: len(raw) - len(strings.TrimLeft(raw, " ")), text: text})
+	}
+	if len(p.lines) == 0 {
+		return map[string]any{}, nil
+	}
+	v, err := p.block(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.i < len(p.lines) {
+		return nil, p.errorf("unexpected indentation")
+	}
+	return v, nil
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	n := p.lines[len(p.lines)-1].n
+	if p.i < len(p.lines) {
+		n = p.lines[p.i].n
+	}
+	return fmt.Errorf("line %d: %s", n, fmt.Sprintf(format, args...))
+}
+
+// more reports whether a line indented at least indent is next
+func (p *parser) more(indent int) bool {
+	return p.i < len(p.lines) && p.lines[p.i].indent >= indent
+}
+
+// block parses the sequence or mapping starting on the next line, which
+// is indented at least indent; it is nil if there is none
+func (p *parser) block(indent int) (any, error) {
+	if !p.more(indent) {
+		return nil, nil
+	}
+	l := p.lines[p.i]
+	if isSeqItem(l.text) {
+		return p.sequence(l.indent)
+	}
+	return p.mapping(l.indent)
+}
+
+func isSeqItem(text string) bool { return text == "-" || strings.HasPrefix(text, "- ") }
+
+// sequence parses the items at indent
+func (p *parser) sequence(indent int) ([]any, error) {
+	list := []any{}
+	for p.i < len(p.lines) && p.lines[p.i].indent == indent && isSeqItem(p.lines[p.i].text) {
+		l := p.lines[p.i]
+		rest := strings.TrimLeft(l.text[1:], " ")
+		var item any
+		var err error
+		switch {
+		case rest == "":
+			p.i++
+			item, err = p.block(indent + 1)
+		case isSeqItem(rest):
+			return nil, p.errorf("nested sequences must start on their own line")
+		case hasKey(rest):
+			// "- key: value" starts a mapping indented to its key
+			p.lines[p.i] = line{n: l.n, indent: l.indent + len(l.text) - len(rest), text: rest}
+			item, err = p.mapping(p.lines[p.i].indent)
+		default:
+			p.i++
+			item, err = scalar(rest)
+		}
+		if err != nil {
+			return nil, p.wrap(l, err)
+		}
+		list = append(list, item)
+	}
+	return list, nil
+}
+
+// mapping parses the keys at indent
+func (p *parser) mapping(indent int) (map[string]any, error) {
+	m := map[string]any{}
+	for p.i < len(p.lines) && p.lines[p.i].indent == indent {
+		l := p.lines[p.i]
+		if isSeqItem(l.text) {
+			return nil, p.errorf("expected a key, not a sequence item")
+		}
+		key, rest, err := splitKey(l.text)
+		if err != nil {
+			return nil, p.wrap(l, err)
+		}
+		if _, dup := m[key]; dup {
+			return nil, p.errorf("duplicate key %q", key)
+		}
+		p.i++
+		var v any
+		switch {
+		case rest != "":
+			v, err = scalar(rest)
+		case p.more(indent + 1):
+			v, err = p.block(indent + 1)
+		case p.i < len(p.lines) && p.lines[p.i].indent == indent && isSeqItem(p.lines[p.i].text):
+			// A sequence may sit at its key's indentation
+			v, err = p.sequence(indent)
+		}
+		if err != nil {
+			return nil, p.wrap(l, err)
+		}
+		m[key] = v
+	}
+	if p.more(indent + 1) {
+		return nil, p.errorf("unexpected indentation")
+	}
+	return m, nil
+}
+
+// wrap adds l's line number to errors without one
+func (p *parser) wrap(l line, err error) error {
+	if strings.HasPrefix(err.Error(), "line ") {
+		return err
+	}
+	return fmt.Errorf("line %d: %w", l.n, err)
+}
+
+// stripComment removes a comment: a # at the start or after a space,
+// outside quotes
+func stripComment(text string) (string, error) {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+		case (c == '"' || c == '\'') && (i == 0 || strings.IndexByte(" [{,:-", text[i-1]) >= 0):
+			quote = c
+		case c == '#' && (i == 0 || text[i-1] == ' '):
+			return strings.TrimRight(text[:i], " "), nil
+		}
+	}
+	if quote != 0 {
+		return "", fmt.Errorf("unterminated quoted string")
+	}
+	return text, nil
+}
+
+// hasKey reports whether text is "key: ..." rather than a scalar
+func hasKey(text string) bool {
+	_, _, err := splitKey(text)
+	return err == nil
+}
+
+// splitKey splits "key: value" into the key and the rest
+func splitKey(text string) (key, rest string, err error) {
+	if text[0] == '"' || text[0] == '\'' {
+		end := quotedEnd(text)
+		if end < 0 {
+			return "", "", fmt.Errorf("unterminated quoted key")
+		}
+		k, err := scalar(text[:end])
+		if err != nil {
+			return "", "", err
+		}
+		after := strings.TrimLeft(text[end:], " ")
+		if !strings.HasPrefix(after, ":") || (len(after) > 1 && after[1] != ' ') {
+			return "", "", fmt.Errorf("expected ':' after key")
+		}
+		return k.(string), strings.TrimSpace(after[1:]), nil
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ') {
+			key = strings.TrimRight(text[:i], " ")
+			if key == "" || strings.ContainsAny(key[:1], "[{&*!|>%@`") {
+				break
+			}
+			return key, strings.TrimSpace(text[i+1:]), nil
+		}
+	}
+	return "", "", fmt.Errorf("expected \"key: value\" in %q", text)
+}
+
+// quotedEnd returns the index after the quoted string text starts with,
+// or -1
+func quotedEnd(text string) int {
+	q := text[0]
+	for i := 1; i < len(text); i++ {
+		switch {
+		case q == '"' && text[i] == '\\':
+			i++
+		case text[i] == q && q == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			i++ // '' is an escaped quote
+		case text[i] == q:
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// scalar parses a value on the rest of a line
+func scalar(text string) (any, error) {
+	switch text[0] {
+	case '[', '{':
+		f := &flowParser{s: text}
+		v, err := f.value(false)
+		if err != nil {
+			return nil, err
+		}
+		if f.skipSpace(); f.pos < len(f.s) {
+			return nil, fmt.Errorf("unexpected %q after %c…%c", f.s[f.pos:], text[0], f.s[f.pos-1])
+		}
+		return v, nil
+	case '"', '\'':
+		end := quotedEnd(text)
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated quoted string")
+		}
+		if end != len(text) {
+			return nil, fmt.Errorf("unexpected %q after quoted string", text[end:])
+		}
+		if text[0] == '\'' {
+			return strings.ReplaceAll(text[1:end-1], "''", "'"), nil
+		}
+		// JSON's escapes are the common subset of YAML's
+		var s string
+		if err := json.Unmarshal([]byte(text), &s); err != nil {
+			return nil, fmt.Errorf("bad double-quoted string %s", text)
+		}
+		return s, nil
+	case '|', '>':
+		return nil, fmt.Errorf("block scalars (%c) aren't supported; use a quoted string", text[0])
+	case '&', '*', '!':
+		return nil, fmt.Errorf("anchors, aliases and tags aren't supported")
+	}
+	return plain(text), nil
+}
+
+// plain resolves a plain scalar by YAML 1.2's core schema
+func plain(s string) any {
+	switch s {
+	case "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	}
+	if n, ok := parseInt(s); ok {
+		return float64(n)
+	}
+	if isDecimal(s) {
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	}
+	return s
+}
+
+// parseInt parses a decimal, 0x hexadecimal or 0o octal integer. Leading
+// zeros are decimal in YAML 1.2, not octal.
+func parseInt(s string) (int64, bool) {
+	digits, neg := strings.TrimLeft(s, "+-"), strings.HasPrefix(s, "-")
+	if len(s)-len(digits) > 1 || digits == "" {
+		return 0, false
+	}
+	base := 10
+	switch {
+	case strings.HasPrefix(digits, "0x"):
+		digits, base = digits[2:], 16
+	case strings.HasPrefix(digits, "0o"):
+		digits, base = digits[2:], 8
+	}
+	if strings.ContainsAny(digits, "_+-") {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(digits, base, 64)
+	if err != nil {
+		return 0, false
+	}
+	if neg {
+		n = -n
+	}
+	return n, true
+}
+
+// isDecimal reports whether s looks like a decimal number, so that Go's
+// wider syntax (hex floats, underscores, "Inf") stays a string
+func isDecimal(s string) bool {
+	digits := false
+	for i, c := range s {
+		switch {
+		case c >= '0' && c <= '9':
+			digits = true
+		case c == '.' || c == 'e' || c == 'E':
+		case (c == '+' || c == '-') && (i == 0 || s[i-1] == 'e' || s[i-1] == 'E'):
+		default:
+			return false
+		}
+	}
+	return digits
+}
+
+// flowParser parses [a, b] and {k: v} on one line
+type flowParser struct {
+	s   string
+	pos int
+}
+
+func (f *flowParser) skipSpace() {
+	for f.pos < len(f.s) && f.s[f.pos] == ' ' {
+		f.pos++
+	}
+}
+
+// value parses a collection or a scalar ending at a flow indicator; in a
+// mapping's key, it also ends at ':'
+func (f *flowParser) value(key bool) (any, error) {
+	f.skipSpace()
+	if f.pos == len(f.s) {
+		return nil, fmt.Errorf("unterminated flow collection")
+	}
+	switch c := f.s[f.pos]; c {
+	case '[':
+		f.pos++
+		list := []any{}
+		err := f.items(']', func() error {
+			v, err := f.value(false)
+			list = append(list, v)
+			return err
+		})
+		return list, err
+	case '{':
+		f.pos++
+		m := map[string]any{}
+		err := f.items('}', func() error {
+			k, err := f.value(true)
+			if err != nil {
+				return err
+			}
+			ks, ok := k.(string)
+			if !ok {
+				ks = fmt.Sprint(k)
+			}
+			if f.skipSpace(); f.pos == len(f.s) || f.s[f.pos] != ':' {
+				return fmt.Errorf("expected ':' after %q", ks)
+			}
+			f.pos++
+			if _, dup := m[ks]; dup {
+				return fmt.Errorf("duplicate key %q", ks)
+			}
+			v, err := f.value(false)
+			m[ks] = v
+			return err
+		})
+		return m, err
+	case '"', '\'':
+		end := quotedEnd(f.s[f.pos:])
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated quoted string")
+		}
+		v, err := scalar(f.s[f.pos : f.pos+end])
+		f.pos += end
+		return v, err
+	}
+	start := f.pos
+	for f.pos < len(f.s) && strings.IndexByte(",]}", f.s[f.pos]) < 0 && !(key && f.s[f.pos] == ':') {
+		f.pos++
+	}
+	text := strings.TrimRight(f.s[start:f.pos], " ")
+	if text == "" {
+		return nil, nil
+	}
+	return plain(text), nil
+}
+
+// items parses comma-separated items up to end, calling item for each
+func (f *flowParser) items(end byte, item func() error) error {
+	if f.skipSpace(); f.pos < len(f.s) && f.s[f.pos] == end {
+		f.pos++
+		return nil
+	}
+	for {
+		if err := item(); err != nil {
+			return err
+		}
+		f.skipSpace()
+		if f.pos == len(f.s) {
+			return fmt.Errorf("unterminated flow collection")
+		}
+		switch f.s[f.pos] {
+		case ',':
+			f.pos++
+		case end:
+			f.pos++
+			return nil
+		default:
+			return fmt.Errorf("expected ',' or '%c' at %q", end, f.s[f.pos:])
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package yaml
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	doc := `---
+# The gateway's site
+agent:
+  sample_interval: 5s   # a comment after a value
+  namespace: {site: "acme #1", room: 'boiler''s room'}
+  channels:
+  - name: flow
+    channel: 0
+    scale: 2.5
+    range: {min: -10, max: 1e3}
+  -   name: return
+      channel: 1
+  sinks:
+    - type: mqtt
+      broker: tcp://broker.local:1883
+      retain: true
+      password: ~
+modbus:
+  unit: 0x11
+  registers: [40001, 0o17, 007]
+  tags: []
+empty:
+version: 1.2.3
+url: "http://x/\u00b0C"
+`
+	got, err := Parse([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"agent": map[string]any{
+			"sample_interval": "5s",
+			"namespace":       map[string]any{"site": "acme #1", "room": "boiler's room"},
+			"channels": []any{
+				map[string]any{"name": "flow", "channel": 0.0, "scale": 2.5, "range": map[string]any{"min": -10.0, "max": 1000.0}},
+				map[string]any{"name": "return", "channel": 1.0},
+			},
+			"sinks": []any{
+				map[string]any{"type": "mqtt", "broker": "tcp://broker.local:1883", "retain": true, "password": nil},
+			},
+		},
+		"modbus": map[string]any{
+			"unit":      17.0,
+			"registers": []any{40001.0, 15.0, 7.0},
+			"tags":      []any{},
+		},
+		"empty":   nil,
+		"version": "1.2.3",
+		"url":     "http://x/°C",
+	}
+	if !reflect.DeepEqual(got, want) {
+		g, _ := json.MarshalIndent(got, "", "  ")
+		t.Errorf("got\n%s", g)
+	}
+}
+
+func TestParseScalars(t *testing.T) {
+	tests := map[string]any{
+		"- 22:00-06:00":   "22:00-06:00",
+		"- sunset+30m":    "sunset+30m",
+		"- -3":            -3.0,
+		"- +4.5":          4.5,
+		"- 1_000":         "1_000",
+		"- 0x1F":          31.0,
+		"- 'it''s'":       "it's",
+		`- "tab\tnew"`:    "tab\tnew",
+		"- don't # note":  "don't",
+		"- a#b":           "a#b",
+		"- False":         false,
+		"- [a, [b, c]]":   []any{"a", []any{"b", "c"}},
+		"- {a: [1], b: }": map[string]any{"a": []any{1.0}, "b": nil},
+	}
+	for doc, want := range tests {
+		got, err := Parse([]byte(doc))
+		if err != nil {
+			t.Errorf("%s: %v", doc, err)
+			continue
+		}
+		if list := got.([]any); len(list) != 1 || !reflect.DeepEqual(list[0], want) {
+			t.Errorf("%s: got %#v, want %#v", doc, got, want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string]string{
+		"a: 1\na: 2":            "line 2: duplicate key",
+		"a: 1\n  b: 2":          "line 2: unexpected indentation",
+		"a:\n\tb: 1":            "line 2: tabs",
+		"a: |\n  text":          "line 1: block scalars",
+		"a: &x 1":               "anchors",
+		"a: [1, 2":              "unterminated",
+		"a: 'x":                 "unterminated quoted string",
+		"a: 1\n- b":             "line 2: expected a key",
+		"a: 1\n---\nb: 2":       "only one document",
+		"list:\n  - x\n  y: 1":  "line 3: unexpected indentation",
+		"a: {b: 1, b: 2}":       "duplicate key",
+		"- - nested":            "nested sequences",
+		"a: \"x\" y":            "after quoted string",
+		"k: v\n  - item":        "unexpected indentation",
+		"top:\n  a: 1\n b: 2":   "line 3: unexpected indentation",
+		"a: [1] trailing":       "unexpected",
+		"\"k\"x: 1":             "expected ':' after key",
+		"plain text, not a map": "expected \"key: value\"",
+	}
+	for doc, want := range tests {
+		_, err := Parse([]byte(doc))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: got error %v, want %q", doc, err, want)
+		}
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	type sink struct {
+		Type string `json:"type"`
+		QoS  int    `json:"qos"`
+	}
+	type config struct {
+		Name  string `json:"name"`
+		Sinks []sink `json:"sinks"`
+	}
+	want := config{Name: "boiler", Sinks: []sink{{Type: "mqtt", QoS: 1}}}
+	for _, doc := range []string{
+		"name: boiler\nsinks:\n  - type: mqtt\n    qos: 1\n",
+		` {"name": "boiler", "sinks": [{"type": "mqtt", "qos": 1}]}`,
+	} {
+		var got config
+		if err := Unmarshal([]byte(doc), &got); err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: got %+v, %v", doc, got, err)
+		}
+	}
+	var c config
+	if err := Unmarshal([]byte("name: [1"), &c); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("error without its line: %v", err)
+	}
+}